@@ -1,4 +1,3 @@
-
 // Package buildinfo is GENERATED CODE from the Canticle build tool,
 // you may check this file in so build can happen without
 // genversion. DO NOT CHECK IN info.go in this package.
@@ -9,16 +8,38 @@ import "encoding/json"
 // BuildInfo contains the deps of this as well as information about
 // when genversion was called.
 type BuildInfo struct {
-	BuildTime    string
-	BuildUser    string
-	BuildHost    string
-        Revision     string
+	BuildTime string
+	BuildUser string
+	BuildHost string
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	// Target is the name of the binary, if generated for one.
+	Target   string
+	Revision string
+	// Version describes Revision relative to the nearest tag,
+	// e.g. v1.2.0-3-gabc1234-dirty.
+	Version string
+	Dirty   bool
+	// Dependencies maps the root of every pinned dependency to
+	// its revision.
+	Dependencies map[string]string
 	CanticleDeps *json.RawMessage
 }
 
 var buildInfo = &BuildInfo{}
 
+// canticleStamp is set by info.go to the json of the build info so
+// cant inspect can read it from binaries.
+var canticleStamp string
+
 // GetBuildInfo returns the information saved by cant genversion.
 func GetBuildInfo() *BuildInfo {
-        return buildInfo
-}
\ No newline at end of file
+	return buildInfo
+}
+
+// DependencyRevision returns the revision the dependency rooted at
+// root was built from, or the empty string if it is not pinned.
+func (b *BuildInfo) DependencyRevision(root string) string {
+	return b.Dependencies[root]
+}
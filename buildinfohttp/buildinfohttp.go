@@ -0,0 +1,43 @@
+// Package buildinfohttp serves the build info generated by cant
+// genversion so every stamped service has the same introspection
+// endpoint. Pass it the result of your buildinfo.GetBuildInfo():
+//
+//	buildinfohttp.Register(http.DefaultServeMux, buildinfo.GetBuildInfo())
+//	buildinfohttp.Publish(buildinfo.GetBuildInfo())
+package buildinfohttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// Path is the path Register serves the build info at.
+const Path = "/buildinfo"
+
+// Handler returns an http.Handler serving info as json.
+func Handler(info interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// Register serves info at Path on mux.
+func Register(mux *http.ServeMux, info interface{}) {
+	mux.Handle(Path, Handler(info))
+}
+
+// Publish publishes info as the expvar "buildinfo", it is then
+// served with the other vars at /debug/vars. Like expvar.Publish it
+// panics if called more than once.
+func Publish(info interface{}) {
+	expvar.Publish("buildinfo", expvar.Func(func() interface{} {
+		return info
+	}))
+}
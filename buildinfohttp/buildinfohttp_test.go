@@ -0,0 +1,44 @@
+package buildinfohttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testInfo struct {
+	Revision string
+}
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux, &testInfo{Revision: "abc"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", Path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected json content type got %s", ct)
+	}
+	var info testInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Error decoding build info: %s", err.Error())
+	}
+	if info.Revision != "abc" {
+		t.Errorf("Expected revision abc got %s", info.Revision)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	Publish(&testInfo{Revision: "abc"})
+	v := expvar.Get("buildinfo")
+	if v == nil {
+		t.Fatalf("Expected buildinfo to be published")
+	}
+	if v.String() != `{"Revision":"abc"}` {
+		t.Errorf("Unexpected published build info %s", v.String())
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
 	"text/template"
+	"time"
 
 	"github.com/Comcast/Canticle/buildinfo"
 	"github.com/Comcast/Canticle/canticles"
@@ -23,6 +27,15 @@ import (
 // Copy the result back out
 func main() {
 	versionFlag := flag.Bool("version", false, "version prints the version info of canticle")
+	debugTimings := flag.Bool("debug-timings", false, "print how long was spent fetching, resolving, listing and reading files")
+	flag.BoolVar(&canticles.CreateGoPath, "create", false, "create the GOPATH src directory if it is missing")
+	flag.Var(&canticles.GoEnv, "goenv", "KEY=VALUE enviroment variable to set for go subprocesses, may be repeated")
+	flag.StringVar(&canticles.TLS.CAFile, "cafile", "", "a PEM bundle of extra CAs to trust when discovering and fetching repos")
+	flag.Var(canticles.InsecureHosts{TLSConfig: canticles.TLS}, "insecure", "do not verify the TLS certificate of this host, may be repeated")
+	flag.BoolVar(&canticles.Secure.Enabled, "secure", false, "only fetch and discover dependencies over encrypted transports")
+	flag.Var(canticles.Secure.Plaintext, "plaintext", "allow fetching from this host unencrypted with -secure, may be repeated")
+	flag.IntVar(&canticles.HTTP.MaxConnsPerHost, "http-conns", canticles.HTTP.MaxConnsPerHost, "the most http connections made to one host at once when discovering and downloading, 0 for no limit")
+	flag.BoolVar(&canticles.AllowVCSHooks, "allow-hooks", false, "let git and hg run hooks, filters and templates when fetching dependencies")
 	flag.Usage = usage
 	flag.Parse()
 	log.SetFlags(0)
@@ -44,29 +57,95 @@ func main() {
 	cmdName := args[0]
 	cmd, ok := canticles.Commands[cmdName]
 	if !ok {
-		fmt.Fprintln(os.Stderr, "Unkown subcommand ", cmdName)
-		usage()
+		plugin, err := canticles.FindPlugin(cmdName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unkown subcommand ", cmdName)
+			usage()
+		}
+		os.Exit(runPlugin(plugin, args[1:]))
 	}
 
+	timings := &canticles.Timings{}
+	if *debugTimings {
+		canticles.Instrument = timings.Record
+	}
+	metrics, err := canticles.StartMetrics(cmdName)
+	if err != nil {
+		log.Printf("WARN: Not sending metrics: %s", err.Error())
+	}
 	cmd.Flags.Usage = cmd.Usage
 	cmd.Flags.Parse(args[1:])
-	cmd.Cmd.Run(args[1:])
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cmd.Cmd.Run(ctx, args[1:])
+	if metrics != nil {
+		// Unreachable metrics servers must not hold up the command
+		flushCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := metrics.Flush(flushCtx); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		}
+		cancel()
+	}
+	if *debugTimings {
+		for _, line := range timings.Summary() {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
 }
 
 var UsageTemplate = `Canticle is a tool for managing go dependencies.
 
 Usage:
-  cant command [arguments]
+  cant [-create] [-goenv KEY=VALUE] [-cafile <file>] [-insecure <host>] [-allow-hooks] [-http-conns <n>] [-secure] [-plaintext <host>] [-debug-timings] command [arguments]
 
 The commands are:
 {{range .}}
          {{.Name | printf "%-11s"}} {{.ShortDescription}}{{end}}
 
 Use "cant help [command]" for more information about that command.
+
+Any other command runs the cant-<command> executable on PATH as a plugin, passing it the rest of the arguments, and the GOPATH and Canticle file cant would use as JSON on its stdin and in CANT_GOPATH and CANT_CANTICLE.
+
+If the Metrics of the users ~/.canticle/config.json are set, fetch counts and errors, cache hits and misses, bytes of archives downloaded and the time spent in each phase are sent to a statsd server over udp and pushed to a Prometheus pushgateway, after each set of fetches and when the command finishes, e.g.:
+
+    {
+      "Metrics": {
+        "StatsD": "localhost:8125",
+        "Pushgateway": "http://pushgateway:9091",
+        "Labels": {"instance": "ci-agent-12"}
+      }
+    }
 `
 
 func usage() {
 	tmpl, _ := template.New("UsageTemplate").Parse(UsageTemplate)
 	tmpl.Execute(os.Stderr, canticles.Commands)
+	if plugins := canticles.Plugins(); len(plugins) > 0 {
+		fmt.Fprintf(os.Stderr, "\nThe plugins on PATH are:\n")
+		for _, name := range plugins {
+			fmt.Fprintf(os.Stderr, "\n         %s", name)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
 	os.Exit(2)
 }
+
+// runPlugin runs the plugin at path with args, returning the status
+// cant should exit with.
+func runPlugin(path string, args []string) int {
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = canticles.RunPlugin(ctx, path, canticles.NewPluginContext(wd, args))
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.ExitCode()
+	}
+	if err != nil {
+		log.Printf("cant run plugin %s %s", path, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package canticles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// An Annotation records why the pin of a dependency was changed, for
+// review of dependency bumps.
+type Annotation struct {
+	// Revision is the revision of the dependency annotated, an
+	// annotation does not carry over to later changes.
+	Revision string
+	Reason   string
+	Ticket   string `json:",omitempty"`
+}
+
+// Covers returns true if a is an annotation of cdep at its current
+// Revision.
+func (a *Annotation) Covers(cdep *CanticleDependency) bool {
+	return a != nil && a.Reason != "" && a.Revision == cdep.Revision
+}
+
+// ChangedDependencies returns the cdeps which are not pinned at the
+// same Revision in old.
+func ChangedDependencies(old, cdeps []*CanticleDependency) []*CanticleDependency {
+	pins := make(map[string]string, len(old))
+	for _, cdep := range old {
+		pins[cdep.Root] = cdep.Revision
+	}
+	var changed []*CanticleDependency
+	for _, cdep := range cdeps {
+		if rev, ok := pins[cdep.Root]; !ok || rev != cdep.Revision {
+			changed = append(changed, cdep)
+		}
+	}
+	return changed
+}
+
+// Annotate carries the Annotations of cdeps unchanged since old over
+// and, if reason is set, annotates the changed cdeps with reason and
+// ticket.
+func Annotate(old, cdeps []*CanticleDependency, reason, ticket string) {
+	annotations := make(map[string]*Annotation, len(old))
+	for _, cdep := range old {
+		annotations[cdep.Root] = cdep.Annotation
+	}
+	for _, cdep := range cdeps {
+		if a := annotations[cdep.Root]; a.Covers(cdep) {
+			cdep.Annotation = a
+		}
+	}
+	if reason == "" {
+		return
+	}
+	for _, cdep := range ChangedDependencies(old, cdeps) {
+		cdep.Annotation = &Annotation{Revision: cdep.Revision, Reason: reason, Ticket: ticket}
+	}
+}
+
+// UnannotatedChanges returns a description of each cdep changed
+// since old without an Annotation covering its new revision.
+func UnannotatedChanges(old, cdeps []*CanticleDependency) []string {
+	var problems []string
+	for _, cdep := range ChangedDependencies(old, cdeps) {
+		if !cdep.Annotation.Covers(cdep) {
+			problems = append(problems, fmt.Sprintf("%s changed to %s without an annotation", cdep.Root, cdep.Revision))
+		}
+	}
+	return problems
+}
+
+// CommittedDependencies returns the dependencies in the Canticle
+// file of the project at path as of the git revision base. If the
+// file does not exist at base no dependencies are returned.
+func CommittedDependencies(path, base string) ([]*CanticleDependency, error) {
+	cmd := exec.Command("git", "rev-parse", "-q", "--verify", base+"^{commit}")
+	cmd.Dir = path
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cant find git revision %s %s", base, string(out))
+	}
+	file := base + ":./Canticle"
+	cmd = exec.Command("git", "cat-file", "-e", file)
+	cmd.Dir = path
+	if err := cmd.Run(); err != nil {
+		LogVerbose("No Canticle file at %s", base)
+		return nil, nil
+	}
+	cmd = exec.Command("git", "show", file)
+	cmd.Dir = path
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file at %s %s", base, err.Error())
+	}
+	var deps []*CanticleDependency
+	if err := json.Unmarshal(out, &deps); err != nil {
+		return nil, fmt.Errorf("cant decode Canticle file at %s %s", base, err.Error())
+	}
+	return deps, nil
+}
+
+// SavedDependencies returns the dependencies in the Canticle file at
+// path, or none if it does not exist.
+func SavedDependencies(path string) ([]*CanticleDependency, error) {
+	b, err := ioutil.ReadFile(DependencyFile(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deps []*CanticleDependency
+	if err := json.Unmarshal(b, &deps); err != nil {
+		return nil, fmt.Errorf("cant decode Canticle file %s %s", DependencyFile(path), err.Error())
+	}
+	return deps, nil
+}
@@ -0,0 +1,89 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	old := []*CanticleDependency{
+		{Root: "dep.com/a", Revision: "1", Annotation: &Annotation{Revision: "1", Reason: "initial"}},
+		{Root: "dep.com/b", Revision: "1", Annotation: &Annotation{Revision: "1", Reason: "initial"}},
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "dep.com/a", Revision: "1"},
+		{Root: "dep.com/b", Revision: "2"},
+		{Root: "dep.com/c", Revision: "1"},
+	}
+	expected := []string{
+		"dep.com/b changed to 2 without an annotation",
+		"dep.com/c changed to 1 without an annotation",
+	}
+	Annotate(old, cdeps, "", "")
+	if cdeps[0].Annotation != old[0].Annotation {
+		t.Errorf("Expected annotation of unchanged dep to be kept got %+v", cdeps[0].Annotation)
+	}
+	if cdeps[1].Annotation != nil {
+		t.Errorf("Expected annotation of changed dep to not carry over got %+v", cdeps[1].Annotation)
+	}
+	if problems := UnannotatedChanges(old, cdeps); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v got %v", expected, problems)
+	}
+
+	Annotate(old, cdeps, "fix bug", "BUG-1")
+	annotation := &Annotation{Revision: "2", Reason: "fix bug", Ticket: "BUG-1"}
+	if !reflect.DeepEqual(cdeps[1].Annotation, annotation) {
+		t.Errorf("Expected annotation %+v got %+v", annotation, cdeps[1].Annotation)
+	}
+	if cdeps[0].Annotation != old[0].Annotation {
+		t.Errorf("Expected unchanged dep to not be reannotated got %+v", cdeps[0].Annotation)
+	}
+	if problems := UnannotatedChanges(old, cdeps); len(problems) != 0 {
+		t.Errorf("Expected annotated changes to have no problems got %v", problems)
+	}
+}
+
+func TestCommittedDependencies(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = testHome
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	git("init")
+	if err := ioutil.WriteFile(DependencyFile(testHome), []byte(`[{"Root": "dep.com/a", "Revision": "1"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CommittedDependencies(testHome, "HEAD"); err == nil {
+		t.Errorf("Expected error for a repo without commits")
+	}
+	git("add", "Canticle")
+	git("-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test")
+
+	deps, err := CommittedDependencies(testHome, "HEAD")
+	if err != nil {
+		t.Fatalf("Error reading committed deps: %s", err.Error())
+	}
+	expected := []*CanticleDependency{{Root: "dep.com/a", Revision: "1"}}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("Expected committed deps %+v got %+v", expected, deps)
+	}
+	git("rm", "-q", "Canticle")
+	git("-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "remove")
+	if deps, err := CommittedDependencies(testHome, "HEAD"); err != nil || deps != nil {
+		t.Errorf("Expected no deps without a committed Canticle file got %+v %v", deps, err)
+	}
+	saved, err := SavedDependencies(testHome)
+	if err != nil || saved != nil {
+		t.Errorf("Expected no saved deps without a Canticle file got %+v %v", saved, err)
+	}
+}
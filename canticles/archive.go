@@ -0,0 +1,451 @@
+package canticles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveDir is the metadata directory of a dependency unpacked from
+// an archive. Like VCSDirs it is not part of the dependencies tree.
+const ArchiveDir = ".canticle-archive"
+
+// ArchiveExtensions are the suffixes of SourcePaths fetched as
+// archives rather than VCSs.
+var ArchiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// IsArchiveSource returns true if source is an archive.
+func IsArchiveSource(source string) bool {
+	if i := strings.IndexAny(source, "?#"); i >= 0 {
+		source = source[:i]
+	}
+	for _, ext := range ArchiveExtensions {
+		if strings.HasSuffix(strings.ToLower(source), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveChecksum returns the Checksum, the sha256 of its contents
+// prefixed with TreeHashPrefix, of an archive file.
+func ArchiveChecksum(filename string) (string, error) {
+	sum, err := fileHash(filename)
+	if err != nil {
+		return "", err
+	}
+	return TreeHashPrefix + sum, nil
+}
+
+// An ArchiveVCS fetches a dependency from a tarball or zip
+// Source. Archives have no revisions, instead the archive must match
+// Checksum before it is unpacked.
+type ArchiveVCS struct {
+	Root     string
+	Source   string
+	Checksum string
+	Gopath   string
+}
+
+// archiveRecord is stored in the ArchiveDir of an unpacked archive.
+type archiveRecord struct {
+	Source   string
+	Checksum string `json:",omitempty"`
+	// Revision is the revision of a RawSourceVCS.
+	Revision string `json:",omitempty"`
+}
+
+func (av *ArchiveVCS) record() *archiveRecord {
+	return readArchiveRecord(PackageSource(av.Gopath, av.Root))
+}
+
+// readArchiveRecord returns the record of the archive unpacked in dir,
+// nil if there is none.
+func readArchiveRecord(dir string) *archiveRecord {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ArchiveDir, "archive.json"))
+	if err != nil {
+		return nil
+	}
+	record := &archiveRecord{}
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil
+	}
+	return record
+}
+
+// Create downloads the archive, verifies it matches Checksum and
+// unpacks it into the Root of the gopath, replacing any previous
+// archive. If the archive with Checksum is already unpacked nothing
+// is done. rev is ignored.
+func (av *ArchiveVCS) Create(ctx context.Context, rev string) error {
+	if av.Checksum == "" {
+		return fmt.Errorf("cant fetch archive %s without a Checksum", av.Source)
+	}
+	if record := av.record(); record != nil && record.Checksum == av.Checksum {
+		LogVerboseContext(ctx, "Archive %s already unpacked in %s", av.Source, av.Root)
+		return nil
+	}
+	check := func(filename string) error {
+		sum, err := ArchiveChecksum(filename)
+		if err != nil {
+			return err
+		}
+		if sum != av.Checksum {
+			return fmt.Errorf("cant unpack archive %s, its checksum %s does not match the saved %s", av.Source, sum, av.Checksum)
+		}
+		return nil
+	}
+	record := &archiveRecord{Source: av.Source, Checksum: av.Checksum}
+	return fetchArchive(ctx, av.Source, PackageSource(av.Gopath, av.Root), true, record, check)
+}
+
+// fetchArchive downloads the archive at source, a http(s) url or a
+// local file, and unpacks it into dir, replacing what is there, with
+// record in its ArchiveDir. strip strips a single top level directory
+// of the archive. If check is not nil the downloaded archive is only
+// unpacked if it returns no error.
+func fetchArchive(ctx context.Context, source, dir string, strip bool, record *archiveRecord, check func(filename string) error) error {
+	tmp, err := ioutil.TempFile("", "cant-archive")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := downloadArchive(ctx, source, tmp); err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(tmp.Name()); err != nil {
+			return err
+		}
+	}
+
+	unpacked := dir + ".cant-unpack"
+	os.RemoveAll(unpacked)
+	defer os.RemoveAll(unpacked)
+	if err := unpackArchive(tmp.Name(), source, unpacked, strip); err != nil {
+		return fmt.Errorf("cant unpack archive %s %s", source, err.Error())
+	}
+	if err := os.MkdirAll(filepath.Join(unpacked, ArchiveDir), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(record, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(unpacked, ArchiveDir, "archive.json"), b, 0644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(unpacked, dir)
+}
+
+// downloadArchive copies the archive at source, a http(s) url or a
+// local file, into w.
+func downloadArchive(ctx context.Context, source string, w io.Writer) error {
+	var r io.ReadCloser
+	switch {
+	case strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://"):
+		LogVerboseContext(ctx, "Downloading archive %s", source)
+		req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+		if err != nil {
+			return fmt.Errorf("cant download archive %s", err.Error())
+		}
+		resp, err := HTTPClient(ctx).Do(req)
+		if err != nil {
+			return fmt.Errorf("cant download archive %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("cant download archive %s status %s", source, resp.Status)
+		}
+		r = resp.Body
+	default:
+		f, err := os.Open(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return fmt.Errorf("cant open archive %s", err.Error())
+		}
+		r = f
+	}
+	defer r.Close()
+	n, err := io.Copy(w, r)
+	Count(MetricBytesDownloaded, n)
+	return err
+}
+
+// SetRev does nothing, archives have no revisions.
+func (av *ArchiveVCS) SetRev(ctx context.Context, rev string) error {
+	return nil
+}
+
+// GetRev returns the Checksum of the unpacked archive.
+func (av *ArchiveVCS) GetRev(ctx context.Context) (string, error) {
+	record := av.record()
+	if record == nil {
+		return "", fmt.Errorf("archive %s is not unpacked", av.Root)
+	}
+	return record.Checksum, nil
+}
+
+// GetBranch always returns an error, archives have no branches.
+func (av *ArchiveVCS) GetBranch(ctx context.Context) (string, error) {
+	return "", errors.New("archives have no branches")
+}
+
+// UpdateBranch never updates, archives have no branches.
+func (av *ArchiveVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
+	return false, "archives have no branches", nil
+}
+
+// GetSource returns the Source of the archive.
+func (av *ArchiveVCS) GetSource(ctx context.Context) (string, error) {
+	return av.Source, nil
+}
+
+// GetRoot returns the Root of the archive.
+func (av *ArchiveVCS) GetRoot() string {
+	return av.Root
+}
+
+// ArchiveRepoResolver resolves an ArchiveVCS for dependencies whose
+// SourcePath is an archive.
+type ArchiveRepoResolver struct {
+	Gopath string
+}
+
+// ResolveRepo returns an ArchiveVCS if dep has an archive SourcePath.
+func (ar *ArchiveRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	if dep == nil || !IsArchiveSource(dep.SourcePath) {
+		return nil, NewResolutionFailureError(importPath, "archive")
+	}
+	root := dep.Root
+	if root == "" {
+		root = importPath
+	}
+	return &ArchiveVCS{Root: root, Source: dep.SourcePath, Checksum: dep.Checksum, Gopath: ar.Gopath}, nil
+}
+
+// UnpackArchive extracts the tar, optionally gzipped, or zip archive
+// filename, named source, into dir. If every file is under a single
+// top level directory, as in archives of a repository, it is
+// stripped. Entries escaping dir are refused.
+func UnpackArchive(filename, source, dir string) error {
+	return unpackArchive(filename, source, dir, true)
+}
+
+// unpackArchive is UnpackArchive, only stripping a top level
+// directory if strip is set.
+func unpackArchive(filename, source, dir string, strip bool) error {
+	var entries []*archiveEntry
+	var err error
+	lower := strings.ToLower(source)
+	if i := strings.IndexAny(lower, "?#"); i >= 0 {
+		lower = lower[:i]
+	}
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		entries, err = zipEntries(filename)
+	default:
+		entries, err = tarEntries(filename, !strings.HasSuffix(lower, ".tar"))
+	}
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if strip {
+		prefix = commonDir(entries)
+	}
+	for _, e := range entries {
+		name := strippedName(e.name, prefix)
+		if name == "" || name == "." {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := checkArchiveParents(dir, name); err != nil {
+			return fmt.Errorf("entry %s %s", e.name, err.Error())
+		}
+		switch {
+		case e.mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case e.mode&os.ModeSymlink != 0:
+			rel := path.Clean(path.Join(path.Dir(name), e.link))
+			if path.IsAbs(e.link) || rel == ".." || strings.HasPrefix(rel, "../") {
+				return fmt.Errorf("link %s to %s escapes the archive", e.name, e.link)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(e.link, target); err != nil {
+				return err
+			}
+		case e.hardlink:
+			if err := unpackHardlink(dir, prefix, target, e); err != nil {
+				return err
+			}
+		case e.mode.IsRegular():
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(target, e.data, e.mode.Perm()|0600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// strippedName returns the path of the archive entry name within the
+// dir it is unpacked into once the top level directory prefix, if
+// any, is stripped.
+func strippedName(name, prefix string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+}
+
+// unpackHardlink writes the hard link entry e at target as a copy of
+// the file it links to, which must already be unpacked in dir.
+func unpackHardlink(dir, prefix, target string, e *archiveEntry) error {
+	link := path.Clean(e.link)
+	if path.IsAbs(link) || link == ".." || strings.HasPrefix(link, "../") || (prefix != "" && !strings.HasPrefix(link, prefix+"/")) {
+		return fmt.Errorf("hard link %s to %s escapes the archive", e.name, e.link)
+	}
+	name := strippedName(link, prefix)
+	if err := checkArchiveParents(dir, name); err != nil {
+		return fmt.Errorf("hard link %s to %s %s", e.name, e.link, err.Error())
+	}
+	src := filepath.Join(dir, filepath.FromSlash(name))
+	fi, err := os.Lstat(src)
+	if err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("hard link %s to %s is not to a file unpacked before it", e.name, e.link)
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, data, fi.Mode().Perm()|0600)
+}
+
+// checkArchiveParents returns an error if name, or a directory above
+// it, in dir is a symlink, so no entry is written through a link
+// unpacked before it.
+func checkArchiveParents(dir, name string) error {
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p)))
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("is written through the link %s", p)
+		}
+	}
+	return nil
+}
+
+type archiveEntry struct {
+	name string
+	mode os.FileMode
+	link string
+	// hardlink is set for tar hard links, link is then the name of
+	// the entry linked to.
+	hardlink bool
+	data     []byte
+}
+
+// commonDir returns the single top level directory of entries, or
+// "" if there is none.
+func commonDir(entries []*archiveEntry) string {
+	prefix := ""
+	for _, e := range entries {
+		name := strings.TrimPrefix(path.Clean("/"+e.name), "/")
+		top := strings.SplitN(name, "/", 2)
+		if len(top) == 1 && !e.mode.IsDir() {
+			return ""
+		}
+		if prefix != "" && top[0] != prefix {
+			return ""
+		}
+		prefix = top[0]
+	}
+	return prefix
+}
+
+func tarEntries(filename string, gzipped bool) ([]*archiveEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var entries []*archiveEntry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := &archiveEntry{name: hdr.Name, mode: hdr.FileInfo().Mode(), link: hdr.Linkname, hardlink: hdr.Typeflag == tar.TypeLink}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if e.mode.IsRegular() && !e.hardlink {
+			if e.data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+func zipEntries(filename string) ([]*archiveEntry, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var entries []*archiveEntry
+	for _, zf := range zr.File {
+		e := &archiveEntry{name: zf.Name, mode: zf.Mode()}
+		if e.mode.IsRegular() || e.mode&os.ModeSymlink != 0 {
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			e.data, err = ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if e.mode&os.ModeSymlink != 0 {
+			e.link = string(e.data)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,262 @@
+package canticles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsArchiveSource(t *testing.T) {
+	cases := map[string]bool{
+		"https://example.com/x-1.0.tar.gz":      true,
+		"https://example.com/x.zip?token=abc":   true,
+		"/tmp/x.TGZ":                            true,
+		"file:///tmp/x.tar":                     true,
+		"https://github.com/foo/bar":            false,
+		"git@github.com:foo/bar.git":            false,
+		"https://example.com/archive.tar.gz.sh": false,
+	}
+	for source, expected := range cases {
+		if result := IsArchiveSource(source); result != expected {
+			t.Errorf("Expected %s archive %v got %v", source, expected, result)
+		}
+	}
+}
+
+func writeTestTarGz(t *testing.T, filename string, files map[string]string, links map[string]string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range links {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Linkname: target, Mode: 0777, Typeflag: tar.TypeSymlink}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A testTarEntry is a file, or a symlink to link if it is set.
+type testTarEntry struct {
+	name, link, content string
+}
+
+// writeTestTar writes an uncompressed tar of entries, in order.
+func writeTestTar(t *testing.T, filename string, entries []testTarEntry) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			h = &tar.Header{Name: e.name, Linkname: e.link, Mode: 0777, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveVCS(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	archive := filepath.Join(testHome, "x-1.0.tar.gz")
+	writeTestTarGz(t, archive, map[string]string{
+		"x-1.0/a.go":     "package x\n",
+		"x-1.0/sub/b.go": "package sub\n",
+	}, map[string]string{"x-1.0/link.go": "a.go"})
+	checksum, err := ArchiveChecksum(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &ArchiveRepoResolver{Gopath: testHome}
+	if _, err := resolver.ResolveRepo(context.Background(), "dep.com/x", &CanticleDependency{Root: "dep.com/x", SourcePath: "https://dep.com/x"}); ResolutionFailureErr(err) == nil {
+		t.Errorf("Expected resolution failure for a non archive source got %v", err)
+	}
+	cdep := &CanticleDependency{Root: "dep.com/x", SourcePath: archive}
+	v, err := resolver.ResolveRepo(context.Background(), cdep.Root, cdep)
+	if err != nil {
+		t.Fatalf("Error resolving archive: %s", err.Error())
+	}
+	if err := v.Create(context.Background(), ""); err == nil {
+		t.Errorf("Expected archive without a checksum to be refused")
+	}
+
+	cdep.Checksum = TreeHashPrefix + "0000"
+	v, _ = resolver.ResolveRepo(context.Background(), cdep.Root, cdep)
+	if err := v.Create(context.Background(), ""); err == nil {
+		t.Errorf("Expected archive with a mismatched checksum to be refused")
+	}
+	dir := PackageSource(testHome, "dep.com/x")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected mismatched archive to not be unpacked got %v", err)
+	}
+
+	cdep.Checksum = checksum
+	v, _ = resolver.ResolveRepo(context.Background(), cdep.Root, cdep)
+	if err := v.Create(context.Background(), ""); err != nil {
+		t.Fatalf("Error creating archive: %s", err.Error())
+	}
+	for name, expected := range map[string]string{"a.go": "package x\n", "sub/b.go": "package sub\n", "link.go": "package x\n"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(b) != expected {
+			t.Errorf("Expected %s to contain %q got %q %v", name, expected, string(b), err)
+		}
+	}
+	if rev, err := v.GetRev(context.Background()); err != nil || rev != checksum {
+		t.Errorf("Expected rev %s got %s %v", checksum, rev, err)
+	}
+	hash, err := TreeHash(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "local.go"), []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Create(context.Background(), ""); err != nil {
+		t.Errorf("Error recreating archive: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "local.go")); err != nil {
+		t.Errorf("Expected unpacked archive with the same checksum to not be replaced")
+	}
+	os.Remove(filepath.Join(dir, "local.go"))
+	if again, err := TreeHash(context.Background(), dir); err != nil || again != hash {
+		t.Errorf("Expected archive metadata to not change the tree hash")
+	}
+}
+
+func TestUnpackArchiveEscapes(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	archive := filepath.Join(testHome, "bad.tar.gz")
+	writeTestTarGz(t, archive, map[string]string{"a.go": "package x\n"}, map[string]string{"evil": "../../outside"})
+	if err := UnpackArchive(archive, archive, filepath.Join(testHome, "out")); err == nil {
+		t.Errorf("Expected link escaping the archive to be refused")
+	}
+
+	// A link to the directory above it, then an entry through it
+	dotdot := filepath.Join(testHome, "dotdot.tar.gz")
+	writeTestTarGz(t, dotdot, map[string]string{"top/a.go": "package x\n"}, map[string]string{"top/x": ".."})
+	if err := UnpackArchive(dotdot, dotdot, filepath.Join(testHome, "dotdot", "out")); err == nil {
+		t.Errorf("Expected link to .. to be refused")
+	}
+	through := filepath.Join(testHome, "through.tar")
+	writeTestTar(t, through, []testTarEntry{
+		{"top/a.go", "", "package x\n"},
+		{"top/x", ".", ""},
+		{"top/x/y", "..", ""},
+		{"top/x/y/pwned", "", "pwned\n"},
+	})
+	out := filepath.Join(testHome, "through", "out")
+	if err := UnpackArchive(through, through, out); err == nil {
+		t.Errorf("Expected entries written through a link to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(testHome, "through", "pwned")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside the directory got %v", err)
+	}
+
+	zipped := filepath.Join(testHome, "x.zip")
+	f, err := os.Create(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("../../a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("package x\n"))
+	zw.Close()
+	f.Close()
+	out = filepath.Join(testHome, "zip", "out")
+	if err := UnpackArchive(zipped, zipped, out); err != nil {
+		t.Fatalf("Error unpacking zip: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.go")); err != nil {
+		t.Errorf("Expected escaping entry to be unpacked inside the directory got %s", err.Error())
+	}
+}
+
+func TestUnpackArchiveHardlinks(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	writeLinks := func(filename string, links map[string]string) {
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		content := "package x\n"
+		tw.WriteHeader(&tar.Header{Name: "top/a.go", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+		for name, link := range links {
+			tw.WriteHeader(&tar.Header{Name: name, Linkname: link, Mode: 0644, Typeflag: tar.TypeLink})
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	good := filepath.Join(testHome, "good.tar")
+	writeLinks(good, map[string]string{"top/sub/b.go": "top/a.go"})
+	out := filepath.Join(testHome, "good")
+	if err := UnpackArchive(good, good, out); err != nil {
+		t.Fatalf("Error unpacking hard link: %s", err.Error())
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(out, "sub", "b.go")); err != nil || string(b) != "package x\n" {
+		t.Errorf("Expected hard link unpacked as a copy of its target got %q %v", string(b), err)
+	}
+
+	secret := filepath.Join(testHome, "secret")
+	ioutil.WriteFile(secret, []byte("secret\n"), 0644)
+	for _, link := range []string{"../secret", secret, "top/../../secret", "top/missing.go", "top"} {
+		bad := filepath.Join(testHome, "bad.tar")
+		writeLinks(bad, map[string]string{"top/b.go": link})
+		out := filepath.Join(testHome, "bad", "out")
+		if err := UnpackArchive(bad, bad, out); err == nil {
+			t.Errorf("Expected hard link to %s refused", link)
+		}
+		os.RemoveAll(filepath.Join(testHome, "bad"))
+	}
+}
@@ -0,0 +1,360 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An Advisory describes a vulnerability in the revisions of a
+// dependency.
+type Advisory struct {
+	ID string
+	// Root is the root of the vulnerable dependency. Dependencies
+	// below Root are also affected.
+	Root    string
+	Summary string `json:",omitempty"`
+	// Affected are the vulnerable revisions, as commits or tags, of
+	// Root. If empty every revision is affected.
+	Affected []string `json:",omitempty"`
+	// Fixed is the first revision with the vulnerability fixed.
+	Fixed string `json:",omitempty"`
+	URL   string `json:",omitempty"`
+}
+
+// Affects returns true if the advisory applies to cdep at any of
+// revs.
+func (a *Advisory) Affects(cdep *CanticleDependency, revs ...string) bool {
+	if cdep.Root != a.Root && !strings.HasPrefix(cdep.Root, a.Root+"/") {
+		return false
+	}
+	if len(a.Affected) == 0 {
+		return true
+	}
+	for _, affected := range a.Affected {
+		for _, rev := range revs {
+			if rev != "" && rev == affected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SnapshotFile is the file in an advisory snapshot directory
+// recording where and when it was fetched.
+const SnapshotFile = "snapshot.json"
+
+// An AdvisorySnapshot records the source of a snapshot directory of
+// advisories.
+type AdvisorySnapshot struct {
+	Source  string
+	Fetched string
+	// Files are the advisory files fetched into the directory,
+	// the only ones read and replaced.
+	Files []string `json:",omitempty"`
+}
+
+// readSnapshot returns the AdvisorySnapshot of dir, or nil if dir has
+// no SnapshotFile.
+func readSnapshot(dir string) (*AdvisorySnapshot, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, SnapshotFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &AdvisorySnapshot{}
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, fmt.Errorf("cant decode advisory snapshot %s %s", dir, err.Error())
+	}
+	return snapshot, nil
+}
+
+// FetchAdvisories downloads the json list of advisories at url.
+func FetchAdvisories(ctx context.Context, url string) ([]*Advisory, error) {
+	LogVerboseContext(ctx, "Fetching advisories from %s", url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cant fetch advisories %s", err.Error())
+	}
+	resp, err := HTTPClient(ctx).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cant fetch advisories %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cant fetch advisories from %s status %s", url, resp.Status)
+	}
+	var advisories []*Advisory
+	if err := json.NewDecoder(resp.Body).Decode(&advisories); err != nil {
+		return nil, fmt.Errorf("cant decode advisories from %s %s", url, err.Error())
+	}
+	return advisories, nil
+}
+
+// LoadAdvisories reads the advisories, one json file each, of the
+// snapshot directory dir. Only the Files of its SnapshotFile are read
+// if it has one.
+func LoadAdvisories(dir string) ([]*Advisory, error) {
+	snapshot, err := readSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if snapshot != nil && snapshot.Files != nil {
+		for _, file := range snapshot.Files {
+			files = append(files, filepath.Join(dir, file))
+		}
+	} else if files, err = filepath.Glob(filepath.Join(dir, "*.json")); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("cant read advisory snapshot %s", err.Error())
+		}
+	}
+	var advisories []*Advisory
+	for _, file := range files {
+		if filepath.Base(file) == SnapshotFile {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		advisory := &Advisory{}
+		if err := json.Unmarshal(b, advisory); err != nil {
+			return nil, fmt.Errorf("cant decode advisory %s %s", file, err.Error())
+		}
+		advisories = append(advisories, advisory)
+	}
+	return advisories, nil
+}
+
+// RefreshAdvisories replaces the advisories in the snapshot
+// directory dir with those fetched from url. The new advisories are
+// written to a temporary directory first, so a failure leaves the old
+// snapshot, and only the Files of the old snapshot are removed.
+func RefreshAdvisories(ctx context.Context, url, dir string) ([]*Advisory, error) {
+	advisories, err := FetchAdvisories(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &AdvisorySnapshot{Source: url, Fetched: time.Now().UTC().Format(time.RFC3339), Files: []string{}}
+	fetched := NewStringSet()
+	for _, advisory := range advisories {
+		file := advisory.ID + ".json"
+		if advisory.ID == "" || strings.ContainsAny(advisory.ID, `/\`) || strings.HasPrefix(advisory.ID, ".") || file == SnapshotFile {
+			return nil, fmt.Errorf("cant save advisory with invalid id %q", advisory.ID)
+		}
+		if fetched[file] {
+			return nil, fmt.Errorf("cant save advisory with duplicate id %q", advisory.ID)
+		}
+		fetched[file] = true
+		snapshot.Files = append(snapshot.Files, file)
+	}
+	old, err := readSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir(dir, ".refresh")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	for i, advisory := range advisories {
+		if err := writeJSON(filepath.Join(tmp, snapshot.Files[i]), advisory); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeJSON(filepath.Join(tmp, SnapshotFile), snapshot); err != nil {
+		return nil, err
+	}
+	if old != nil {
+		for _, file := range old.Files {
+			if fetched[file] || filepath.Base(file) != file {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	// The SnapshotFile goes last so it only lists written files
+	for _, file := range append(snapshot.Files, SnapshotFile) {
+		if err := os.Rename(filepath.Join(tmp, file), filepath.Join(dir, file)); err != nil {
+			return nil, err
+		}
+	}
+	return advisories, nil
+}
+
+func writeJSON(filename string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// AuditDependencies returns a description of each advisory
+// affecting the cdeps, at their pinned revision or the revision on
+// disk in gopath.
+func AuditDependencies(ctx context.Context, gopath string, cdeps []*CanticleDependency, advisories []*Advisory) []string {
+	resolver := &LocalRepoResolver{LocalPath: gopath}
+	var findings []string
+	for _, cdep := range cdeps {
+		revs := []string{cdep.Revision}
+		if v, err := resolver.ResolveRepo(ctx, cdep.Root, cdep); err == nil {
+			if rev, err := v.GetRev(ctx); err == nil {
+				revs = append(revs, rev)
+			}
+		}
+		for _, advisory := range advisories {
+			if !advisory.Affects(cdep, revs...) {
+				continue
+			}
+			finding := fmt.Sprintf("%s %s: %s", cdep.Root, advisory.ID, advisory.Summary)
+			if advisory.Fixed != "" {
+				finding += " (fixed in " + advisory.Fixed + ")"
+			}
+			findings = append(findings, finding)
+		}
+	}
+	sort.Strings(findings)
+	return findings
+}
+
+type Audit struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	DB      string
+	URL     string
+	Refresh bool
+}
+
+func NewAudit() *Audit {
+	f := flag.NewFlagSet("audit", flag.ExitOnError)
+	a := &Audit{flags: f}
+	f.BoolVar(&a.Verbose, "v", false, "Be verbose when getting stuff")
+	f.StringVar(&a.DB, "db", "", "Read advisories from this snapshot directory instead of fetching them")
+	f.StringVar(&a.URL, "url", "", "Fetch advisories from this url")
+	f.BoolVar(&a.Refresh, "refresh", false, "Replace the -db snapshot with the advisories at -url before auditing")
+	return a
+}
+
+var audit = NewAudit()
+
+var AuditCommand = &Command{
+	Name:             "audit",
+	UsageLine:        "audit [-v] [-db <dir>] [-url <url>] [-refresh]",
+	ShortDescription: "Check the dependencies against a database of advisories.",
+	LongDescription: `The audit command checks the dependencies pinned in the Canticle file of the project in the current directory against advisories of vulnerable revisions. It exits with status 1 if any dependency is affected.
+
+Advisories are fetched from -url, a json list of advisories:
+  [
+      {
+          "ID": "CANT-2016-0001",
+          "Root": "github.com/foo/bar",
+          "Summary": "Remote code execution in bar.Parse",
+          "Affected": ["v1.0.0", "a1b2c3..."],
+          "Fixed": "v1.0.1"
+      }
+  ]
+
+An advisory with no Affected revisions affects every revision. For air-gapped builds specify -db advisories to read a snapshot directory, holding one json file per advisory, instead. Specify -refresh with -db and -url to replace the snapshot with the advisories at url, when the network is available. A refresh records the files it writes in snapshot.json and only replaces those, other files in the directory are kept and not read. The AdvisoryURL and AdvisoryDB, relative to the project, fields of the projects .canticle.json set the defaults.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: audit.flags,
+	Cmd:   audit,
+}
+
+// Run the audit command.
+func (a *Audit) Run(ctx context.Context, args []string) {
+	if a.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	findings, err := a.AuditProject(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Advisories returns the advisories for the project at path, from
+// the snapshot directory if one is set, otherwise from the url.
+func (a *Audit) Advisories(ctx context.Context, path string) ([]*Advisory, error) {
+	config, err := LoadProjectConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	url, db := a.URL, a.DB
+	if url == "" {
+		url = config.AdvisoryURL
+	}
+	if db == "" && config.AdvisoryDB != "" {
+		db = filepath.Join(path, config.AdvisoryDB)
+	}
+	client, err := HTTP.Client(TLS.Merge(config.TLS, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithHTTPClient(ctx, client)
+	switch {
+	case a.Refresh && (db == "" || url == ""):
+		return nil, fmt.Errorf("cant refresh advisories without a -db and -url")
+	case a.Refresh:
+		return RefreshAdvisories(ctx, url, db)
+	case db != "":
+		return LoadAdvisories(db)
+	case url != "":
+		return FetchAdvisories(ctx, url)
+	}
+	return nil, fmt.Errorf("cant audit without a -db or -url of advisories")
+}
+
+// AuditProject returns the advisories affecting the pinned
+// dependencies of the project at path.
+func (a *Audit) AuditProject(ctx context.Context, gopath, path string) ([]string, error) {
+	advisories, err := a.Advisories(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	cdeps, err := (&DepReader{Gopath: gopath}).CanticleDependencies(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	return AuditDependencies(ctx, gopath, cdeps, advisories), nil
+}
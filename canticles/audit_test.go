@@ -0,0 +1,121 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAdvisoryAffects(t *testing.T) {
+	advisory := &Advisory{ID: "A-1", Root: "dep.com/x", Affected: []string{"v1.0.0"}}
+	cases := []struct {
+		cdep     *CanticleDependency
+		revs     []string
+		expected bool
+	}{
+		{&CanticleDependency{Root: "dep.com/x"}, []string{"v1.0.0"}, true},
+		{&CanticleDependency{Root: "dep.com/x/sub"}, []string{"abc", "v1.0.0"}, true},
+		{&CanticleDependency{Root: "dep.com/x"}, []string{"v1.0.1"}, false},
+		{&CanticleDependency{Root: "dep.com/xy"}, []string{"v1.0.0"}, false},
+	}
+	for _, c := range cases {
+		if result := advisory.Affects(c.cdep, c.revs...); result != c.expected {
+			t.Errorf("Expected %s at %v affected %v got %v", c.cdep.Root, c.revs, c.expected, result)
+		}
+	}
+	if !(&Advisory{Root: "dep.com/x"}).Affects(&CanticleDependency{Root: "dep.com/x"}, "any") {
+		t.Errorf("Expected advisory without Affected revisions to affect every revision")
+	}
+}
+
+func TestRefreshAdvisories(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	advisories := []*Advisory{
+		{ID: "A-1", Root: "dep.com/x", Summary: "bad", Affected: []string{"v1.0.0"}, Fixed: "v1.0.1"},
+		{ID: "A-2", Root: "dep.com/y", Summary: "worse"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(advisories)
+	}))
+	defer server.Close()
+
+	db := filepath.Join(testHome, "advisories")
+	if err := os.MkdirAll(db, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(db, "OLD.json"), []byte(`{"ID": "OLD"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshAdvisories(context.Background(), server.URL, db); err != nil {
+		t.Fatalf("Error refreshing advisories: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(db, SnapshotFile)); err != nil {
+		t.Errorf("Expected snapshot file written got %s", err.Error())
+	}
+	// Files not written by a refresh are kept
+	if _, err := os.Stat(filepath.Join(db, "OLD.json")); err != nil {
+		t.Errorf("Expected OLD.json kept got %s", err.Error())
+	}
+	// The snapshot is read without the server
+	server.Close()
+	loaded, err := LoadAdvisories(db)
+	if err != nil {
+		t.Fatalf("Error loading advisories: %s", err.Error())
+	}
+	if !reflect.DeepEqual(loaded, advisories) {
+		t.Errorf("Expected advisories %+v got %+v", advisories, loaded)
+	}
+
+	cdeps := []*CanticleDependency{
+		{Root: "dep.com/x", Revision: "v1.0.0"},
+		{Root: "dep.com/y", Revision: "abc"},
+		{Root: "dep.com/z", Revision: "v1.0.0"},
+	}
+	expected := []string{
+		"dep.com/x A-1: bad (fixed in v1.0.1)",
+		"dep.com/y A-2: worse",
+	}
+	if findings := AuditDependencies(context.Background(), testHome, cdeps, loaded); !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Expected findings %v got %v", expected, findings)
+	}
+
+	if _, err := LoadAdvisories(filepath.Join(testHome, "missing")); err == nil {
+		t.Errorf("Expected error loading missing snapshot")
+	}
+
+	// A failed refresh leaves the snapshot, a refresh replaces
+	// only the files it wrote
+	advisories = []*Advisory{{ID: "A-3", Root: "dep.com/z"}, {ID: "../x", Root: "dep.com/z"}}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(advisories)
+	}))
+	defer server.Close()
+	if _, err := RefreshAdvisories(context.Background(), server.URL, db); err == nil {
+		t.Errorf("Expected error refreshing an advisory with an invalid id")
+	}
+	if reloaded, err := LoadAdvisories(db); err != nil || len(reloaded) != 2 {
+		t.Errorf("Expected the 2 old advisories after a failed refresh got %v %v", reloaded, err)
+	}
+	advisories = advisories[:1]
+	if _, err := RefreshAdvisories(context.Background(), server.URL, db); err != nil {
+		t.Fatalf("Error refreshing advisories: %s", err.Error())
+	}
+	for file, exists := range map[string]bool{"A-1.json": false, "A-2.json": false, "A-3.json": true, "OLD.json": true} {
+		if _, err := os.Stat(filepath.Join(db, file)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist %v got %v", file, exists, err)
+		}
+	}
+	if reloaded, err := LoadAdvisories(db); err != nil || !reflect.DeepEqual(reloaded, advisories) {
+		t.Errorf("Expected advisories %+v got %+v %v", advisories, reloaded, err)
+	}
+}
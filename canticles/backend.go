@@ -0,0 +1,200 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Backend fetches the repos of a version control system, or other
+// store, go/vcs does not know, such as Fossil or an artifact store.
+// Register it with RegisterBackend for resolvers to use it for
+// dependencies whose SourcePath has one of its Schemes or whose import
+// path is under one of its Prefixes.
+type Backend struct {
+	// Name of the backend, e.g. fossil.
+	Name string
+	// Schemes are the url schemes of the SourcePaths, e.g. fossil
+	// for fossil://host/repo, fetched with the backend.
+	Schemes []string
+	// Prefixes are the import paths, e.g. corp.com/artifacts, the
+	// repos under which are fetched with the backend.
+	Prefixes []string
+	// Root, if set, returns the root of the repo of an import path
+	// under one of Prefixes, which is otherwise the prefix.
+	Root func(importPath string) string
+
+	// Create fetches source, the SourcePath of the dependency or
+	// else its root, into dir at rev, the default revision if
+	// empty.
+	Create func(ctx context.Context, dir, source, rev string) error
+	// SetRev changes the revision of the repo in dir to rev.
+	SetRev func(ctx context.Context, dir, rev string) error
+	// GetRev returns the revision of the repo in dir.
+	GetRev func(ctx context.Context, dir string) (string, error)
+	// GetSource, if set, returns the source the repo in dir was
+	// fetched from, which is otherwise the source resolved.
+	GetSource func(ctx context.Context, dir string) (string, error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]*Backend)
+)
+
+// RegisterBackend makes b available to resolvers. An error is
+// returned if b lacks a Name, Create, SetRev or GetRev, or a backend
+// of the same name is registered.
+func RegisterBackend(b *Backend) error {
+	if b.Name == "" || b.Create == nil || b.SetRev == nil || b.GetRev == nil {
+		return errors.New("cant register backend, it needs a Name, Create, SetRev and GetRev")
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if backends[b.Name] != nil {
+		return fmt.Errorf("cant register backend %s, it is already registered", b.Name)
+	}
+	backends[b.Name] = b
+	return nil
+}
+
+// UnregisterBackend removes the backend named name, if registered.
+func UnregisterBackend(name string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	delete(backends, name)
+}
+
+// Backends returns the registered backends sorted by name.
+func Backends() []*Backend {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*Backend, 0, len(names))
+	for _, name := range names {
+		list = append(list, backends[name])
+	}
+	return list
+}
+
+// LookupBackend returns the backend of the scheme of source, or else
+// the backend with the longest of its Prefixes importPath is under
+// and that prefix. Nil is returned if no backend matches.
+func LookupBackend(importPath, source string) (b *Backend, prefix string) {
+	scheme := ""
+	if i := strings.Index(source, "://"); i >= 0 {
+		scheme = strings.ToLower(source[:i])
+	}
+	var found *Backend
+	for _, backend := range Backends() {
+		for _, s := range backend.Schemes {
+			if scheme != "" && strings.ToLower(s) == scheme {
+				return backend, ""
+			}
+		}
+		for _, p := range backend.Prefixes {
+			if (p == importPath || PathIsChild(p, importPath)) && len(p) > len(prefix) {
+				found, prefix = backend, p
+			}
+		}
+	}
+	return found, prefix
+}
+
+// BackendVCS is the VCS of a repo fetched with a registered Backend.
+type BackendVCS struct {
+	Backend *Backend
+	Root    string
+	Source  string
+	Gopath  string
+}
+
+func (bv *BackendVCS) dir() string {
+	return PackageSource(bv.Gopath, bv.Root)
+}
+
+// Create fetches the repo with the backend at rev.
+func (bv *BackendVCS) Create(ctx context.Context, rev string) error {
+	return bv.Backend.Create(ctx, bv.dir(), bv.Source, rev)
+}
+
+// SetRev changes the revision of the repo with the backend, it does
+// nothing if rev is empty.
+func (bv *BackendVCS) SetRev(ctx context.Context, rev string) error {
+	if rev == "" {
+		return nil
+	}
+	if err := bv.Backend.SetRev(ctx, bv.dir(), rev); err != nil {
+		return &RevisionError{Root: bv.Root, Revision: rev, Err: err}
+	}
+	return nil
+}
+
+// GetRev returns the revision of the repo from the backend.
+func (bv *BackendVCS) GetRev(ctx context.Context) (string, error) {
+	return bv.Backend.GetRev(ctx, bv.dir())
+}
+
+// GetBranch always returns an error, backends have no branches.
+func (bv *BackendVCS) GetBranch(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("%s repos have no branches", bv.Backend.Name)
+}
+
+// UpdateBranch never updates, backends have no branches.
+func (bv *BackendVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
+	return false, fmt.Sprintf("%s repos have no branches", bv.Backend.Name), nil
+}
+
+// GetSource returns the source of the repo from the backend, or the
+// Source resolved if it can not tell.
+func (bv *BackendVCS) GetSource(ctx context.Context) (string, error) {
+	if bv.Backend.GetSource == nil {
+		return bv.Source, nil
+	}
+	return bv.Backend.GetSource(ctx, bv.dir())
+}
+
+// GetRoot returns the Root of the repo.
+func (bv *BackendVCS) GetRoot() string {
+	return bv.Root
+}
+
+// BackendRepoResolver resolves a BackendVCS for dependencies matching
+// a registered backend, see LookupBackend.
+type BackendRepoResolver struct {
+	Gopath string
+}
+
+// ResolveRepo returns the BackendVCS of importPath if its dependency
+// matches a registered backend.
+func (br *BackendRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	source := ""
+	if dep != nil {
+		source = dep.SourcePath
+	}
+	backend, prefix := LookupBackend(importPath, source)
+	if backend == nil {
+		return nil, NewResolutionFailureError(importPath, "backend")
+	}
+	root := prefix
+	switch {
+	case dep != nil && dep.Root != "":
+		root = dep.Root
+	case prefix != "" && backend.Root != nil:
+		root = backend.Root(importPath)
+	case prefix == "":
+		root = importPath
+	}
+	if source == "" {
+		source = root
+	}
+	LogVerboseContext(ctx, "Resolved %s to %s backend repo %s", importPath, backend.Name, root)
+	return &BackendVCS{Backend: backend, Root: root, Source: source, Gopath: br.Gopath}, nil
+}
@@ -0,0 +1,120 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fileBackend keeps the revision of a repo in its REV file.
+func fileBackend(name string) *Backend {
+	setRev := func(ctx context.Context, dir, rev string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "REV"), []byte(rev), 0644)
+	}
+	return &Backend{
+		Name:     name,
+		Schemes:  []string{name},
+		Prefixes: []string{"corp.com/" + name},
+		Root: func(importPath string) string {
+			parts := strings.Split(importPath, "/")
+			if len(parts) > 3 {
+				parts = parts[:3]
+			}
+			return strings.Join(parts, "/")
+		},
+		Create: func(ctx context.Context, dir, source, rev string) error {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			return setRev(ctx, dir, rev)
+		},
+		SetRev: setRev,
+		GetRev: func(ctx context.Context, dir string) (string, error) {
+			b, err := ioutil.ReadFile(filepath.Join(dir, "REV"))
+			return string(b), err
+		},
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	if err := RegisterBackend(fileBackend("fake")); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterBackend("fake")
+	if err := RegisterBackend(fileBackend("fake")); err == nil {
+		t.Errorf("Expected error registering a backend twice")
+	}
+	if err := RegisterBackend(&Backend{Name: "empty"}); err == nil {
+		t.Errorf("Expected error registering a backend without funcs")
+	}
+	tests := []struct {
+		importPath, source string
+		backend, prefix    string
+	}{
+		{"example.com/x", "fake://host/x", "fake", ""},
+		{"corp.com/fake/lib/sub", "", "fake", "corp.com/fake"},
+		{"corp.com/fakes/lib", "", "", ""},
+		{"example.com/x", "https://host/x", "", ""},
+	}
+	for _, test := range tests {
+		b, prefix := LookupBackend(test.importPath, test.source)
+		name := ""
+		if b != nil {
+			name = b.Name
+		}
+		if name != test.backend || prefix != test.prefix {
+			t.Errorf("Expected %s %s to match backend %q %q got %q %q", test.importPath, test.source, test.backend, test.prefix, name, prefix)
+		}
+	}
+}
+
+func TestBackendRepoResolver(t *testing.T) {
+	if err := RegisterBackend(fileBackend("fake")); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterBackend("fake")
+	gopath, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	ctx := context.Background()
+
+	resolver, _ := NewFetchResolver(gopath, &SecureMode{}, nil, false)
+	v, err := resolver.ResolveRepo(ctx, "corp.com/fake/lib/sub", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.GetRoot() != "corp.com/fake/lib" {
+		t.Errorf("Expected root corp.com/fake/lib got %s", v.GetRoot())
+	}
+	if err := v.Create(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.SetRev(ctx, "r2"); err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := v.GetSource(ctx); source != "corp.com/fake/lib" {
+		t.Errorf("Expected source corp.com/fake/lib got %s", source)
+	}
+
+	// The checkout on disk is controlled by the backend too
+	dep := &CanticleDependency{Root: "corp.com/fake/lib", SourcePath: "fake://host/lib"}
+	v, err = (&LocalRepoResolver{LocalPath: gopath}).ResolveRepo(ctx, "corp.com/fake/lib", dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := v.GetRev(ctx); err != nil || rev != "r2" {
+		t.Errorf("Expected revision r2 got %s %v", rev, err)
+	}
+	if bv, ok := v.(*BackendVCS); !ok || bv.Source != "fake://host/lib" {
+		t.Errorf("Expected backend vcs of fake://host/lib got %+v", v)
+	}
+
+	if _, err := (&BackendRepoResolver{gopath}).ResolveRepo(ctx, "example.com/x", nil); ResolutionFailureErr(err) == nil {
+		t.Errorf("Expected resolution failure for a path of no backend got %v", err)
+	}
+}
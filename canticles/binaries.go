@@ -0,0 +1,199 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// BinaryFile returns the location of the per binary dependency file
+// for a path. Should be a directory.
+func BinaryFile(p string) string {
+	return filepath.Join(p, "Canticle.binaries")
+}
+
+// Closure returns the dependencies reachable from pkgs through their
+// Imports, including pkgs themselves.
+func (d Dependencies) Closure(pkgs ...string) Dependencies {
+	closure := NewDependencies()
+	queue := append([]string{}, pkgs...)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		dep := d[pkg]
+		if dep == nil || closure[pkg] != nil {
+			continue
+		}
+		closure[pkg] = dep
+		queue = append(queue, dep.Imports.Array()...)
+	}
+	return closure
+}
+
+// MainPackages returns the sorted import paths of all main packages
+// in d.
+func (d Dependencies) MainPackages() []string {
+	var mains []string
+	for _, dep := range d {
+		if dep.Main {
+			mains = append(mains, dep.ImportPath)
+		}
+	}
+	sort.Strings(mains)
+	return mains
+}
+
+// CoveringDependency returns the CanticleDependency whose Root
+// contains importPath, or nil if none do.
+func CoveringDependency(cdeps []*CanticleDependency, importPath string) *CanticleDependency {
+	for _, cdep := range cdeps {
+		if cdep.Root == importPath || PathIsChild(cdep.Root, importPath) {
+			return cdep
+		}
+	}
+	return nil
+}
+
+// BinaryRoots returns a map of each main package in deps to the
+// sorted roots of the cdeps its dependency closure requires.
+func BinaryRoots(deps Dependencies, cdeps []*CanticleDependency) map[string][]string {
+	binaries := make(map[string][]string)
+	for _, main := range deps.MainPackages() {
+		roots := NewStringSet()
+		for pkg := range deps.Closure(main) {
+			if cdep := CoveringDependency(cdeps, pkg); cdep != nil {
+				roots.Add(cdep.Root)
+			}
+		}
+		binaries[main] = roots.Array()
+	}
+	return binaries
+}
+
+// ReadBinaryRoots reads the BinaryFile in path.
+func ReadBinaryRoots(path string) (map[string][]string, error) {
+	b, err := ioutil.ReadFile(BinaryFile(path))
+	if err != nil {
+		return nil, err
+	}
+	binaries := make(map[string][]string)
+	if err := json.Unmarshal(b, &binaries); err != nil {
+		return nil, fmt.Errorf("cant decode binaries file %s %s", BinaryFile(path), err.Error())
+	}
+	return binaries, nil
+}
+
+type Binaries struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	JSON    bool
+}
+
+func NewBinaries() *Binaries {
+	f := flag.NewFlagSet("binaries", flag.ExitOnError)
+	b := &Binaries{flags: f}
+	f.BoolVar(&b.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&b.JSON, "json", false, "Print the dependencies of each binary as json")
+	return b
+}
+
+var binaries = NewBinaries()
+
+var BinariesCommand = &Command{
+	Name:             "binaries",
+	UsageLine:        "binaries [-v] [-json] [binary...]",
+	ShortDescription: "List the dependencies required by each main package.",
+	LongDescription: `The binaries command lists the pinned dependencies required to build each main package of the project in the current directory. Use cant save -binaries to record them.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -json to print a json object of binary import path to Canticle dependencies.
+
+Specify binary import paths to only list those binaries.`,
+	Flags: binaries.flags,
+	Cmd:   binaries,
+}
+
+// Run the binaries command.
+func (b *Binaries) Run(ctx context.Context, args []string) {
+	if b.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := b.BinaryDependencies(gopath, wd, b.flags.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if b.JSON {
+		j, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(j))
+		return
+	}
+	names := make([]string, 0, len(result))
+	for name := range result {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s:\n", name)
+		for _, cdep := range result[name] {
+			fmt.Printf("\t%s %s\n", cdep.Root, cdep.Revision)
+		}
+	}
+}
+
+// BinaryDependencies returns the CanticleDependencies for each binary
+// recorded for the project at path. If names is not empty only those
+// binaries are returned.
+func (b *Binaries) BinaryDependencies(gopath, path string, names []string) (map[string][]*CanticleDependency, error) {
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	cdeps, err := (&DepReader{Gopath: gopath}).CanticleDependencies(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	roots, err := ReadBinaryRoots(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		selected := make(map[string][]string, len(names))
+		for _, name := range names {
+			if _, ok := roots[name]; !ok {
+				return nil, fmt.Errorf("no binary %s recorded for %s", name, pkg)
+			}
+			selected[name] = roots[name]
+		}
+		roots = selected
+	}
+	result := make(map[string][]*CanticleDependency, len(roots))
+	for name, binRoots := range roots {
+		result[name] = make([]*CanticleDependency, 0, len(binRoots))
+		for _, root := range binRoots {
+			if cdep := CoveringDependency(cdeps, root); cdep != nil {
+				result[name] = append(result[name], cdep)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testBinaryDeps() Dependencies {
+	deps := NewDependencies()
+	server := NewDependency("test.com/proj/cmd/server")
+	server.Main = true
+	server.Imports.Add("test.com/proj/lib", "web.com/http/router")
+	client := NewDependency("test.com/proj/cmd/client")
+	client.Main = true
+	client.Imports.Add("test.com/proj/lib")
+	lib := NewDependency("test.com/proj/lib")
+	lib.Imports.Add("log.com/log")
+	router := NewDependency("web.com/http/router")
+	router.Imports.Add("log.com/log")
+	for _, dep := range []*Dependency{server, client, lib, router, NewDependency("log.com/log")} {
+		deps.AddDependency(dep)
+	}
+	return deps
+}
+
+func TestBinaryRoots(t *testing.T) {
+	deps := testBinaryDeps()
+	closure := deps.Closure("test.com/proj/cmd/client")
+	if len(closure) != 3 {
+		t.Errorf("Expected closure of 3 packages got %v", closure)
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "web.com/http", Revision: "a"},
+		{Root: "log.com/log", Revision: "b"},
+	}
+	expected := map[string][]string{
+		"test.com/proj/cmd/client": {"log.com/log"},
+		"test.com/proj/cmd/server": {"log.com/log", "web.com/http"},
+	}
+	if roots := BinaryRoots(deps, cdeps); !reflect.DeepEqual(expected, roots) {
+		t.Errorf("Expected binary roots %v got %v", expected, roots)
+	}
+}
+
+func TestBinaryDependencies(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	path := PackageSource(testHome, "test.com/proj")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "log.com/log", Revision: "b"},
+		{Root: "web.com/http", Revision: "a"},
+	}
+	s := NewSave()
+	if err := s.SaveDeps(path, cdeps); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBinaries(path, BinaryRoots(testBinaryDeps(), cdeps)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBinaries()
+	result, err := b.BinaryDependencies(testHome, path, []string{"test.com/proj/cmd/server"})
+	if err != nil {
+		t.Fatalf("Error reading binary dependencies: %s", err.Error())
+	}
+	expected := map[string][]*CanticleDependency{"test.com/proj/cmd/server": cdeps}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected binary deps %v got %v", expected, result)
+	}
+	if _, err := b.BinaryDependencies(testHome, path, []string{"test.com/proj/cmd/nothere"}); err == nil {
+		t.Errorf("Expected error for unknown binary")
+	}
+}
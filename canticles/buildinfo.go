@@ -1,11 +1,19 @@
 package canticles
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"go/format"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"os/user"
-	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -14,35 +22,342 @@ type BuildInfo struct {
 	BuildTime    string
 	BuildUser    string
 	BuildHost    string
+	GoVersion    string
+	GOOS         string
+	GOARCH       string
+	Target       string
 	Revision     string
+	Version      string
+	Dirty        bool
+	Dependencies map[string]string
 	CanticleDeps *json.RawMessage
 }
 
+// BuildInfoFields are the names of the fields which may be passed to
+// BuildInfo.Omit.
+var BuildInfoFields = []string{"time", "user", "host", "goversion", "platform", "version", "dirty"}
+
+// Omit clears the named fields, see BuildInfoFields, so they are not
+// generated. This is used for reproducible builds.
+func (b *BuildInfo) Omit(fields ...string) error {
+	for _, field := range fields {
+		switch field {
+		case "time":
+			b.BuildTime = ""
+		case "user":
+			b.BuildUser = ""
+		case "host":
+			b.BuildHost = ""
+		case "goversion":
+			b.GoVersion = ""
+		case "platform":
+			b.GOOS, b.GOARCH = "", ""
+		case "version":
+			b.Version = ""
+		case "dirty":
+			b.Dirty = false
+		default:
+			return fmt.Errorf("unknown build info field %s, must be one of %v", field, BuildInfoFields)
+		}
+	}
+	return nil
+}
+
+// GoToolchain returns the version and target platform of the go
+// tool which will build the project, respecting GoEnv.
+func GoToolchain(ctx context.Context) (version, goos, goarch string, err error) {
+	env := GoEnviroment(os.Getenv("GOPATH"))
+	cmd := exec.CommandContext(ctx, "go", "version")
+	cmd.Env = env
+	result, err := cmd.Output()
+	if err != nil {
+		return "", "", "", fmt.Errorf("cant get go version %s", err.Error())
+	}
+	// go version go1.5 linux/amd64
+	if fields := strings.Fields(string(result)); len(fields) > 2 {
+		version = fields[2]
+	}
+	cmd = exec.CommandContext(ctx, "go", "env", "GOOS", "GOARCH")
+	cmd.Env = env
+	result, err = cmd.Output()
+	if err != nil {
+		return "", "", "", fmt.Errorf("cant get go platform %s", err.Error())
+	}
+	platform := strings.Fields(string(result))
+	if len(platform) != 2 {
+		return "", "", "", fmt.Errorf("cant parse go platform %s", string(result))
+	}
+	return version, platform[0], platform[1], nil
+}
+
 func (b *BuildInfo) DepString() string {
 	s, _ := json.Marshal(b.CanticleDeps)
 	return string(s)
 }
 
+// BuildInfoStampPrefix precedes the json of the build info generated
+// packages embed in binaries, see ReadBinaryBuildInfo.
+const BuildInfoStampPrefix = "canticle-buildinfo:"
+
+// Stamp returns b as json prefixed by BuildInfoStampPrefix.
+func (b *BuildInfo) Stamp() (string, error) {
+	j, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	return BuildInfoStampPrefix + string(j), nil
+}
+
+// BuildInfoDir returns the directory of the generated buildinfo
+// package of the project in dir.
+func BuildInfoDir(dir string) string {
+	return filepath.Join(dir, "buildinfo")
+}
+
+// GoFiles returns the contents of the generated buildinfo package by
+// file name.
+func (b *BuildInfo) GoFiles() (map[string][]byte, error) {
+	return NewBuildInfoFile(b).GoFiles()
+}
+
+// WriteFiles writes the buildinfo package of the project in dir.
 func (b *BuildInfo) WriteFiles(dir string) error {
-	pkgdir := path.Join(dir, "buildinfo")
+	return NewBuildInfoFile(b).WriteFiles(BuildInfoDir(dir))
+}
+
+// StaleFiles returns the sorted paths of the files of the buildinfo
+// package in dir which are missing or differ from those b would
+// generate.
+func (b *BuildInfo) StaleFiles(dir string) ([]string, error) {
+	return NewBuildInfoFile(b).StaleFiles(BuildInfoDir(dir))
+}
+
+// A BuildInfoFile is the data the generated package, or a user
+// supplied build info template, is executed with. The BuildInfo
+// fields are available directly.
+type BuildInfoFile struct {
+	*BuildInfo
+	// Package is the name of the generated package.
+	Package string
+	// Var is the name of the generated variable.
+	Var string
+	// BuildTags, if set, is the build constraint of the generated
+	// info.go, e.g. "release", so the info is only set in those
+	// builds.
+	BuildTags string
+	// Constants are extra constants to generate, by name.
+	Constants map[string]string
+}
+
+// NewBuildInfoFile returns the BuildInfoFile of the default buildinfo
+// package layout for b.
+func NewBuildInfoFile(b *BuildInfo) *BuildInfoFile {
+	return &BuildInfoFile{
+		BuildInfo: b,
+		Package:   "buildinfo",
+		Var:       "buildInfo",
+	}
+}
+
+// GoFiles returns the gofmted contents of the generated package by
+// file name.
+func (bf *BuildInfoFile) GoFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte, 2)
+	for name, tmpl := range map[string]*template.Template{
+		"buildinfo.go": BuildInfoGoTemplate,
+		"info.go":      BuildInfoTemplate,
+	} {
+		src, err := bf.executeGo(name, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = src
+	}
+	return files, nil
+}
+
+// WriteFiles writes the generated package to pkgdir, creating it if
+// needed.
+func (bf *BuildInfoFile) WriteFiles(pkgdir string) error {
+	files, err := bf.GoFiles()
+	if err != nil {
+		return err
+	}
 	if err := os.MkdirAll(pkgdir, 0755); err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(path.Join(pkgdir, "buildinfo.go"), []byte(BuildInfoGoFile), 0644); err != nil {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(pkgdir, name), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StaleFiles returns the sorted paths of the files of the generated
+// package in pkgdir which are missing or differ from those bf would
+// generate.
+func (bf *BuildInfoFile) StaleFiles(pkgdir string) ([]string, error) {
+	files, err := bf.GoFiles()
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for name, content := range files {
+		filename := filepath.Join(pkgdir, name)
+		onDisk, err := ioutil.ReadFile(filename)
+		switch {
+		case os.IsNotExist(err):
+			stale = append(stale, filename)
+		case err != nil:
+			return nil, err
+		case !bytes.Equal(onDisk, content):
+			stale = append(stale, filename)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// BuildInfoFuncs are the functions available to build info templates.
+// quote returns a go string literal of its argument.
+var BuildInfoFuncs = template.FuncMap{
+	"quote": strconv.Quote,
+}
+
+// ParseBuildInfoTemplate reads a build info template from file.
+func ParseBuildInfoTemplate(file string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(file)).Funcs(BuildInfoFuncs).Parse(string(b))
+}
+
+// WriteGoFile executes tmpl with bf and writes the result to
+// filename, creating its directory if needed.
+func (bf *BuildInfoFile) WriteGoFile(filename string, tmpl *template.Template) error {
+	src, err := bf.executeGo(filename, tmpl)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return err
 	}
-	f, err := os.Create(path.Join(pkgdir, "info.go"))
+	return ioutil.WriteFile(filename, src, 0644)
+}
+
+// executeGo executes tmpl with bf and returns the result gofmted. An
+// error is returned if it is not valid go, so a broken template or
+// value is reported before anything is written.
+func (bf *BuildInfoFile) executeGo(filename string, tmpl *template.Template) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, bf); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cant generate valid go for %s %s", filename, err.Error())
+	}
+	return src, nil
+}
+
+// LDFlags returns the linker -X flags setting the string variable
+// named after each non empty field of b in the package pkg, e.g.
+// main.Revision. Dirty is always set, to "true" or "false", and
+// CanticleDeps to its json.
+func (b *BuildInfo) LDFlags(pkg string) []string {
+	vals := []struct{ name, value string }{
+		{"BuildTime", b.BuildTime},
+		{"BuildUser", b.BuildUser},
+		{"BuildHost", b.BuildHost},
+		{"GoVersion", b.GoVersion},
+		{"GOOS", b.GOOS},
+		{"GOARCH", b.GOARCH},
+		{"Target", b.Target},
+		{"Revision", b.Revision},
+		{"Version", b.Version},
+		{"Dirty", strconv.FormatBool(b.Dirty)},
+	}
+	if b.CanticleDeps != nil {
+		vals = append(vals, struct{ name, value string }{"CanticleDeps", b.DepString()})
+	}
+	var flags []string
+	for _, v := range vals {
+		if v.value != "" {
+			flags = append(flags, "-X", pkg+"."+v.name+"="+v.value)
+		}
+	}
+	return flags
+}
+
+// LDFlagsString returns the LDFlags for pkg as a single value for go
+// build -ldflags, single quoting flags containing spaces, quotes or
+// shell metacharacters as a POSIX shell does. A single quote is
+// written by closing the quote, escaping it and quoting again.
+func (b *BuildInfo) LDFlagsString(pkg string) string {
+	flags := b.LDFlags(pkg)
+	for i, f := range flags {
+		if strings.ContainsAny(f, " \t\n'\"\\$`") {
+			flags[i] = "'" + strings.Replace(f, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+// goFlagsValue returns flags as a single value the go command splits
+// back into them. The go command quotes with ' or " and has no
+// escapes, so flags containing both can not be passed.
+func goFlagsValue(flags []string) (string, error) {
+	quoted := make([]string, len(flags))
+	for i, f := range flags {
+		switch {
+		case !strings.ContainsAny(f, " \t\n\r'\""):
+			quoted[i] = f
+		case !strings.Contains(f, "'"):
+			quoted[i] = "'" + f + "'"
+		case !strings.Contains(f, `"`):
+			quoted[i] = `"` + f + `"`
+		default:
+			return "", fmt.Errorf("cant pass %s to the go command, it contains both quotes", f)
+		}
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// GoBuild runs go build in dir with args, stamping b into pkg using
+// LDFlags.
+func (b *BuildInfo) GoBuild(ctx context.Context, gopath, dir, pkg string, args ...string) error {
+	ldflags, err := goFlagsValue(b.LDFlags(pkg))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return BuildInfoTemplate.Execute(f, b)
+	buildArgs := append([]string{"build", "-ldflags", ldflags}, args...)
+	LogVerbose("Running command go %v", buildArgs)
+	cmd := exec.CommandContext(ctx, "go", buildArgs...)
+	cmd.Dir = dir
+	cmd.Env = GoEnviroment(gopath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cant build %s %s", dir, err.Error())
+	}
+	return nil
 }
 
-func NewBuildInfo(rev string, stable bool, deps []*CanticleDependency) (*BuildInfo, error) {
+// NewBuildInfo returns the BuildInfo of revision rev built with deps.
+// The build time, user and host change on every build and are only
+// recorded if stamp is set, so by default identical inputs give
+// identical build info.
+func NewBuildInfo(ctx context.Context, rev string, stamp bool, deps []*CanticleDependency) (*BuildInfo, error) {
 	var bi BuildInfo
 	bi.Revision = rev
 
+	// Sort a copy of our deps so identical inputs generate identical
+	// files regardless of resolution order
+	deps = append([]*CanticleDependency(nil), deps...)
+	sort.Sort(CanticleDependencies(deps))
+
 	// Encode our deps to place be placed
 	msg, err := json.MarshalIndent(deps, "", "    ")
 	if err != nil {
@@ -55,9 +370,17 @@ func NewBuildInfo(rev string, stable bool, deps []*CanticleDependency) (*BuildIn
 		return nil, err
 	}
 	bi.CanticleDeps = &j
+	bi.Dependencies = make(map[string]string, len(deps))
+	for _, dep := range deps {
+		bi.Dependencies[dep.Root] = dep.Revision
+	}
+
+	if bi.GoVersion, bi.GOOS, bi.GOARCH, err = GoToolchain(ctx); err != nil {
+		return nil, err
+	}
 
-	// Add the other fields if a stable build info set is requiered
-	if stable {
+	// Add the fields changing on every build only if asked for
+	if !stamp {
 		return &bi, nil
 	}
 
@@ -71,51 +394,105 @@ func NewBuildInfo(rev string, stable bool, deps []*CanticleDependency) (*BuildIn
 		return nil, err
 	}
 
-	bi.BuildTime = time.Now().Format(time.RFC3339)
+	bi.BuildTime, err = BuildTime()
+	if err != nil {
+		return nil, err
+	}
 
 	return &bi, nil
 }
 
-var BuildInfoGoFile = `
-// Package buildinfo is GENERATED CODE from the Canticle build tool,
+// BuildTime returns the time to record in build info in UTC. As with
+// other reproducible build tools the SOURCE_DATE_EPOCH enviroment
+// variable, in seconds since the epoch, overrides the current time.
+func BuildTime() (string, error) {
+	t := time.Now()
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("cant parse SOURCE_DATE_EPOCH %s", err.Error())
+		}
+		t = time.Unix(secs, 0)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// BuildInfoGoTemplate generates the buildinfo.go file of the
+// buildinfo package, which may be checked in.
+var BuildInfoGoTemplate = template.Must(template.New("buildinfo").Parse(`// Package {{.Package}} is GENERATED CODE from the Canticle build tool,
 // you may check this file in so build can happen without
 // genversion. DO NOT CHECK IN info.go in this package.
-package buildinfo
+package {{.Package}}
 
 import "encoding/json"
 
 // BuildInfo contains the deps of this as well as information about
 // when genversion was called.
 type BuildInfo struct {
-	BuildTime    string
-	BuildUser    string
-	BuildHost    string
-	Revision     string
+	BuildTime string
+	BuildUser string
+	BuildHost string
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	// Target is the name of the binary, if generated for one.
+	Target   string
+	Revision string
+	// Version describes Revision relative to the nearest tag,
+	// e.g. v1.2.0-3-gabc1234-dirty.
+	Version string
+	Dirty   bool
+	// Dependencies maps the root of every pinned dependency to
+	// its revision.
+	Dependencies map[string]string
 	CanticleDeps *json.RawMessage
 }
 
-var buildInfo = &BuildInfo{}
+var {{.Var}} = &BuildInfo{}
+
+// canticleStamp is set by info.go to the json of the build info so
+// cant inspect can read it from binaries.
+var canticleStamp string
 
 // GetBuildInfo returns the information saved by cant genversion.
 func GetBuildInfo() *BuildInfo {
-        return buildInfo
+	return {{.Var}}
 }
-`
 
-var BuildInfoTemplate = template.Must(template.New("version").Parse(`
-package buildinfo
+// DependencyRevision returns the revision the dependency rooted at
+// root was built from, or the empty string if it is not pinned.
+func (b *BuildInfo) DependencyRevision(root string) string {
+	return b.Dependencies[root]
+}
+`))
+
+// BuildInfoTemplate generates the info.go file of the buildinfo
+// package, setting the build info.
+var BuildInfoTemplate = template.Must(template.New("version").Parse(`{{if .BuildTags}}//go:build {{.BuildTags}}
+{{end}}
+package {{.Package}}
 
 import "encoding/json"
 
 // This is GENERATED CODE, DO NOT CHECK THIS IN
 func init() {
-	CanticleDeps := json.RawMessage(` + "`{{.DepString}}`" + `)
-	buildInfo = &BuildInfo{
-		"{{.BuildTime}}",
-		"{{.BuildUser}}",
-		"{{.BuildHost}}",
-		"{{.Revision}}",
-		&CanticleDeps,
-        }
+	CanticleDeps := json.RawMessage({{printf "%q" .DepString}})
+	{{.Var}} = &BuildInfo{
+		BuildTime:    {{printf "%q" .BuildTime}},
+		BuildUser:    {{printf "%q" .BuildUser}},
+		BuildHost:    {{printf "%q" .BuildHost}},
+		GoVersion:    {{printf "%q" .GoVersion}},
+		GOOS:         {{printf "%q" .GOOS}},
+		GOARCH:       {{printf "%q" .GOARCH}},
+		Target:       {{printf "%q" .Target}},
+		Revision:     {{printf "%q" .Revision}},
+		Version:      {{printf "%q" .Version}},
+		Dirty:        {{.Dirty}},
+		Dependencies: map[string]string{ {{range $root, $rev := .Dependencies}}
+			{{printf "%q" $root}}: {{printf "%q" $rev}},{{end}}
+		},
+		CanticleDeps: &CanticleDeps,
+	}
+	canticleStamp = {{printf "%q" .Stamp}}
 }
 `))
@@ -1,10 +1,13 @@
 package canticles
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -29,8 +32,8 @@ func main() {
 var deps = []*CanticleDependency{}
 
 func TestBuildInfo(t *testing.T) {
-	for _, stable := range []bool{true, false} {
-		bi, err := NewBuildInfo("test", stable, deps)
+	for _, stamp := range []bool{false, true} {
+		bi, err := NewBuildInfo(context.Background(), "test", stamp, deps)
 		if err != nil {
 			t.Errorf("Error not nil obtaining information about our own package: %s", err.Error())
 		}
@@ -62,3 +65,324 @@ func TestBuildInfo(t *testing.T) {
 		t.Log(string(output))
 	}
 }
+
+func TestBuildInfoOmit(t *testing.T) {
+	bi, err := NewBuildInfo(context.Background(), "test", true, deps)
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	if bi.GoVersion == "" || bi.GOOS == "" || bi.GOARCH == "" {
+		t.Errorf("Expected go version and platform to be set got %+v", bi)
+	}
+	bi.Dirty = true
+	if err := bi.Omit("time", "platform", "dirty"); err != nil {
+		t.Fatalf("Error omitting valid fields: %s", err.Error())
+	}
+	if bi.BuildTime != "" || bi.GOOS != "" || bi.GOARCH != "" || bi.Dirty {
+		t.Errorf("Expected omitted fields to be cleared got %+v", bi)
+	}
+	if bi.BuildUser == "" || bi.GoVersion == "" {
+		t.Errorf("Expected fields not omitted to be kept got %+v", bi)
+	}
+	if err := bi.Omit("bogus"); err == nil {
+		t.Errorf("Expected error omitting unknown field")
+	}
+}
+
+func TestBuildInfoTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tmplFile := path.Join(dir, "info.tmpl")
+	tmplSrc := "package {{.Package}}\n\nconst {{.Var}}Revision = {{quote .Revision}}\n{{range $k, $v := .Constants}}const {{$k}} = {{quote $v}}\n{{end}}"
+	if err := ioutil.WriteFile(tmplFile, []byte(tmplSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseBuildInfoTemplate(tmplFile)
+	if err != nil {
+		t.Fatalf("Error parsing template: %s", err.Error())
+	}
+	bf := &BuildInfoFile{
+		BuildInfo: &BuildInfo{Revision: "abc"},
+		Package:   "version",
+		Var:       "Build",
+		Constants: map[string]string{"Product": "cant"},
+	}
+	out := path.Join(dir, "version", "version.go")
+	if err := bf.WriteGoFile(out, tmpl); err != nil {
+		t.Fatalf("Error writing template: %s", err.Error())
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "package version\n\nconst BuildRevision = \"abc\"\nconst Product = \"cant\"\n"
+	if string(b) != expected {
+		t.Errorf("Expected generated file:\n%s\ngot:\n%s", expected, string(b))
+	}
+}
+
+func TestBuildInfoTemplateQuoting(t *testing.T) {
+	deps := json.RawMessage(`[{"Root": "dep.com/x", "SourcePath": "https://dep.com/` + "`x`" + `"}]`)
+	bi := &BuildInfo{
+		BuildUser:    `o"brien`,
+		BuildHost:    `host\corp`,
+		Revision:     "abc",
+		CanticleDeps: &deps,
+	}
+	files, err := NewBuildInfoFile(bi).GoFiles()
+	if err != nil {
+		t.Fatalf("Error generating build info with quotes: %s", err.Error())
+	}
+	for _, expected := range []string{`BuildUser:    "o\"brien"`, `BuildHost:    "host\\corp"`, "https://dep.com/`x`"} {
+		if !strings.Contains(string(files["info.go"]), expected) {
+			t.Errorf("Expected info.go to contain %s got:\n%s", expected, files["info.go"])
+		}
+	}
+}
+
+var stampTemplate = `package main
+
+import "fmt"
+
+var (
+	Revision string
+	BuildUser string
+	Dirty string
+)
+
+func main() {
+	fmt.Printf("%s|%s|%s", Revision, BuildUser, Dirty)
+}
+`
+
+func TestBuildInfoLDFlags(t *testing.T) {
+	bi := &BuildInfo{Revision: "abc", BuildUser: "a user", Dirty: true}
+	expected := "-X main.BuildUser=a user -X main.Revision=abc -X main.Dirty=true"
+	if flags := strings.Join(bi.LDFlags("main"), " "); flags != expected {
+		t.Errorf("Expected ldflags %s got %s", expected, flags)
+	}
+	expected = "-X 'main.BuildUser=a user' -X main.Revision=abc -X main.Dirty=true"
+	if flags := bi.LDFlagsString("main"); flags != expected {
+		t.Errorf("Expected ldflags string %s got %s", expected, flags)
+	}
+
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "main.go"), []byte(stampTemplate), 0644); err != nil {
+		t.Fatalf("Error writing temp main: %s", err.Error())
+	}
+	bin := path.Join(dir, "stamped")
+	if err := bi.GoBuild(context.Background(), os.Getenv("GOPATH"), dir, "main", "-o", bin); err != nil {
+		t.Fatalf("Error building stamped binary: %s", err.Error())
+	}
+	output, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error running stamped binary: %s", string(output))
+	}
+	if string(output) != "abc|a user|true" {
+		t.Errorf("Expected stamped values abc|a user|true got %s", string(output))
+	}
+
+	bi.BuildUser = "o'brien"
+	expected = `-X 'main.BuildUser=o'\''brien' -X main.Revision=abc -X main.Dirty=true`
+	if flags := bi.LDFlagsString("main"); flags != expected {
+		t.Errorf("Expected ldflags string %s got %s", expected, flags)
+	}
+	if err := bi.GoBuild(context.Background(), os.Getenv("GOPATH"), dir, "main", "-o", bin); err != nil {
+		t.Fatalf("Error building stamped binary: %s", err.Error())
+	}
+	if output, err = exec.Command(bin).CombinedOutput(); err != nil || string(output) != "abc|o'brien|true" {
+		t.Errorf("Expected stamped values abc|o'brien|true got %s %v", string(output), err)
+	}
+	bi.BuildUser = `o'brien "x"`
+	if err := bi.GoBuild(context.Background(), os.Getenv("GOPATH"), dir, "main", "-o", bin); err == nil {
+		t.Errorf("Expected error passing a value with both quotes to go build")
+	}
+}
+
+func TestBuildInfoDependencies(t *testing.T) {
+	pinned := []*CanticleDependency{{Root: "a.com/x", Revision: "1"}, {Root: "b.com/y", Revision: "2"}}
+	bi, err := NewBuildInfo(context.Background(), "test", false, pinned)
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "main.go"), []byte(mainTemplate), 0644); err != nil {
+		t.Fatalf("Error writing temp main: %s", err.Error())
+	}
+	if err := bi.WriteFiles(dir); err != nil {
+		t.Fatalf("Error writing buildinfo go file: %s", err.Error())
+	}
+	cmd := exec.Command("go", "build")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error generating built file, output: %s", string(output))
+	}
+	output, err := exec.Command(path.Join(dir, path.Base(dir))).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error running built file, output: %s", string(output))
+	}
+	expected := `"Dependencies":{"a.com/x":"1","b.com/y":"2"}`
+	if !strings.Contains(string(output), expected) {
+		t.Errorf("Expected output to contain %s got %s", expected, string(output))
+	}
+}
+
+func TestBuildInfoStaleFiles(t *testing.T) {
+	bi, err := NewBuildInfo(context.Background(), "test", false, deps)
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	stale, err := bi.StaleFiles(dir)
+	if err != nil {
+		t.Fatalf("Error checking build info: %s", err.Error())
+	}
+	if len(stale) != 2 {
+		t.Errorf("Expected 2 missing files got %v", stale)
+	}
+	if err := bi.WriteFiles(dir); err != nil {
+		t.Fatalf("Error writing buildinfo go file: %s", err.Error())
+	}
+	if stale, err = bi.StaleFiles(dir); err != nil || len(stale) != 0 {
+		t.Errorf("Expected no stale files got %v %v", stale, err)
+	}
+	bi.Revision = "changed"
+	stale, err = bi.StaleFiles(dir)
+	if err != nil {
+		t.Fatalf("Error checking build info: %s", err.Error())
+	}
+	if len(stale) != 1 || stale[0] != path.Join(BuildInfoDir(dir), "info.go") {
+		t.Errorf("Expected info.go to be stale got %v", stale)
+	}
+}
+
+func TestBuildInfoDeterministic(t *testing.T) {
+	a := []*CanticleDependency{{Root: "b.com/y", Revision: "2"}, {Root: "a.com/x", Revision: "1"}}
+	b := []*CanticleDependency{a[1], a[0]}
+	os.Setenv("SOURCE_DATE_EPOCH", "0")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	var generated []map[string][]byte
+	for _, pinned := range [][]*CanticleDependency{a, b} {
+		bi, err := NewBuildInfo(context.Background(), "test", true, pinned)
+		if err != nil {
+			t.Fatalf("Error creating build info: %s", err.Error())
+		}
+		if bi.BuildTime != "1970-01-01T00:00:00Z" {
+			t.Errorf("Expected SOURCE_DATE_EPOCH build time got %s", bi.BuildTime)
+		}
+		files, err := bi.GoFiles()
+		if err != nil {
+			t.Fatalf("Error generating build info: %s", err.Error())
+		}
+		generated = append(generated, files)
+	}
+	for name, content := range generated[0] {
+		if string(content) != string(generated[1][name]) {
+			t.Errorf("Expected identical %s got:\n%s\n%s", name, content, generated[1][name])
+		}
+	}
+	if a[0].Root != "b.com/y" {
+		t.Errorf("Expected deps passed to NewBuildInfo to not be reordered")
+	}
+}
+
+var versionMainTemplate = `package main
+
+import (
+     "fmt"
+     "./internal/version"
+)
+
+func main() {
+	fmt.Printf("%s", version.GetBuildInfo().Revision)
+}
+`
+
+func TestBuildInfoFileLayout(t *testing.T) {
+	bi, err := NewBuildInfo(context.Background(), "abc", false, deps)
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "main.go"), []byte(versionMainTemplate), 0644); err != nil {
+		t.Fatalf("Error writing temp main: %s", err.Error())
+	}
+	bf := NewBuildInfoFile(bi)
+	bf.Package = "version"
+	bf.Var = "info"
+	bf.BuildTags = "release"
+	if err := bf.WriteFiles(path.Join(dir, "internal", "version")); err != nil {
+		t.Fatalf("Error writing version package: %s", err.Error())
+	}
+	for tags, expected := range map[string]string{"release": "abc", "": ""} {
+		cmd := exec.Command("go", "build", "-tags", tags, "-o", "app")
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error building with tags %s, output: %s", tags, string(output))
+		}
+		output, err := exec.Command(path.Join(dir, "app")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running built file, output: %s", string(output))
+		}
+		if string(output) != expected {
+			t.Errorf("Expected revision %q with tags %q got %q", expected, tags, string(output))
+		}
+	}
+}
+
+func TestBuildInfoGoFileCheckedIn(t *testing.T) {
+	files, err := NewBuildInfoFile(&BuildInfo{}).GoFiles()
+	if err != nil {
+		t.Fatalf("Error generating build info: %s", err.Error())
+	}
+	b, err := ioutil.ReadFile(path.Join("..", "buildinfo", "buildinfo.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(files["buildinfo.go"]) {
+		t.Errorf("Expected checked in buildinfo.go to match the generated file got:\n%s", string(b))
+	}
+}
+
+func TestBuildInfoTemplateInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tmplFile := path.Join(dir, "info.tmpl")
+	if err := ioutil.WriteFile(tmplFile, []byte("package {{.Package}}\n\nconst {{.Var}} = {{.Revision}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseBuildInfoTemplate(tmplFile)
+	if err != nil {
+		t.Fatalf("Error parsing template: %s", err.Error())
+	}
+	bf := &BuildInfoFile{BuildInfo: &BuildInfo{Revision: "not quoted"}, Package: "version", Var: "Revision"}
+	out := path.Join(dir, "version.go")
+	if err := bf.WriteGoFile(out, tmpl); err == nil {
+		t.Errorf("Expected error generating invalid go")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("Expected invalid go file to not be written")
+	}
+}
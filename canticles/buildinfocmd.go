@@ -0,0 +1,102 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type BuildInfoGen struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Stamp   bool
+	Check   bool
+	Omit    string
+	Dir     string
+	Package string
+	Var     string
+	Tags    string
+}
+
+func NewBuildInfoGen() *BuildInfoGen {
+	f := flag.NewFlagSet("buildinfo", flag.ExitOnError)
+	b := &BuildInfoGen{flags: f}
+	f.BoolVar(&b.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&b.Stamp, "stamp", false, "Also generate the build time, user and host, which change on every build")
+	f.Bool("stable", true, "Deprecated, build info is stable unless -stamp is set")
+	f.BoolVar(&b.Check, "check", false, "Do not write files, exit with status 1 if the buildinfo package is stale")
+	f.StringVar(&b.Omit, "omit", "", "Comma separated build info fields to not generate: time,user,host,goversion,platform,version,dirty")
+	f.StringVar(&b.Dir, "dir", "buildinfo", "The directory, relative to the package, to generate the package in")
+	f.StringVar(&b.Package, "package", "buildinfo", "The name of the generated package")
+	f.StringVar(&b.Var, "var", "", "The name of the generated variable")
+	f.StringVar(&b.Tags, "tags", "", "A build constraint, e.g. release, for the generated info.go")
+	return b
+}
+
+var buildinfo = NewBuildInfoGen()
+
+var BuildInfoCommand = &Command{
+	Name:             "buildinfo",
+	UsageLine:        "buildinfo [-v] [-stamp] [-omit <fields>] [-dir <dir>] [-package <name>] [-var <name>] [-tags <constraint>] [-check] [packages]",
+	ShortDescription: "Generate or check the buildinfo package of packages.",
+	LongDescription: `The buildinfo command generates the buildinfo package, as genversion does, for each package listed, or the current directory if none are. Packages are import paths in the GOPATH.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -stamp, -omit, -dir, -package, -var and -tags as with genversion.
+
+Specify -check to write nothing and instead exit with status 1 if any buildinfo file is missing or differs from what would be generated, e.g. in CI to catch a stale checked in package. As the build time, user and host always change do not use -check with -stamp.`,
+	Flags: buildinfo.flags,
+	Cmd:   buildinfo,
+}
+
+// Run the buildinfo command.
+func (b *BuildInfoGen) Run(ctx context.Context, args []string) {
+	if b.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stale := false
+	for _, pkg := range ParseCmdLinePackages(b.flags.Args()) {
+		path := pkg
+		if _, err := os.Stat(path); err != nil {
+			path = PackageSource(gopath, pkg)
+		}
+		files, err := b.GenPackage(ctx, gopath, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, f := range files {
+			fmt.Printf("stale: %s\n", f)
+			stale = true
+		}
+	}
+	if stale {
+		os.Exit(1)
+	}
+}
+
+// GenPackage writes the buildinfo package of the package in path. If
+// Check is set nothing is written and the stale files are returned
+// instead.
+func (b *BuildInfoGen) GenPackage(ctx context.Context, gopath, path string) ([]string, error) {
+	g := &GenVersion{Stamp: b.Stamp, Omit: b.Omit, Package: b.Package, Var: b.Var, Tags: b.Tags}
+	bi, err := g.ProjectBuildInfo(ctx, gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	pkgdir := filepath.Join(path, b.Dir)
+	if b.Check {
+		LogVerboseContext(ctx, "Checking version files in:%s", pkgdir)
+		return g.BuildInfoFile(bi).StaleFiles(pkgdir)
+	}
+	LogVerboseContext(ctx, "Writing version files to:%s", pkgdir)
+	return nil, g.BuildInfoFile(bi).WriteFiles(pkgdir)
+}
@@ -0,0 +1,270 @@
+package canticles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheDir returns the directory canticle keeps its caches in. This
+// is $CANTICLE_CACHE if set, otherwise the CacheDir of the
+// UserConfig, otherwise ~/.canticle/cache.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("CANTICLE_CACHE"); dir != "" {
+		return dir, nil
+	}
+	if config, err := LoadUserConfig(); err == nil && config.CacheDir != "" {
+		return config.CacheDir, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("cant find cache dir, could not get current user %s", err.Error())
+	}
+	return filepath.Join(u.HomeDir, ".canticle", "cache"), nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes, optionally with a KB, MB, GB or
+// TB suffix, e.g. 512MB.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range sizeUnits {
+		if !strings.HasSuffix(s, unit.suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("cant parse size %q", s)
+		}
+		return int64(n * float64(unit.size)), nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("cant parse size %q", s)
+	}
+	return n, nil
+}
+
+// FormatSize formats a size in bytes with the largest whole unit.
+func FormatSize(n int64) string {
+	for _, unit := range sizeUnits {
+		if n >= unit.size && unit.size > 1 {
+			return strconv.FormatFloat(float64(n)/float64(unit.size), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// A Cache is the root directory of all canticle caches. Each file or
+// directory directly in it is an entry, entries are evicted least
+// recently used first when the cache is larger than its Budget.
+type Cache struct {
+	Dir string
+	// Budget is the size in bytes the cache is kept within, zero
+	// means unlimited.
+	Budget int64
+}
+
+// DefaultCache returns the Cache in the CacheDir, with the CacheSize
+// of the UserConfig as its Budget.
+func DefaultCache() (*Cache, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+	cache := &Cache{Dir: dir}
+	if config.CacheSize != "" {
+		if cache.Budget, err = ParseSize(config.CacheSize); err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
+// Entry returns the path of the entry name in the cache, marking it
+// as used.
+func (c *Cache) Entry(name string) string {
+	p := filepath.Join(c.Dir, name)
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return p
+}
+
+// cacheLocks is the directory in a Cache of the lock files of its
+// entries, it is not an entry itself.
+const cacheLocks = ".locks"
+
+// lock opens the lock file of the entry name and locks it, see
+// lockFile. It returns a nil file if the lock is held by another.
+func (c *Cache) lock(name string, exclusive, wait bool) (*os.File, error) {
+	dir := filepath.Join(c.Dir, cacheLocks)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cant create cache lock dir %s", err.Error())
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cant open cache lock %s", err.Error())
+	}
+	locked, err := lockFile(f, exclusive, wait)
+	if err != nil || !locked {
+		f.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant lock cache entry %s %s", name, err.Error())
+	}
+	if !locked {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Use marks the entry name as in use, by this or any other cant
+// process, until the returned function is called. Evict skips entries
+// in use.
+func (c *Cache) Use(name string) (func(), error) {
+	f, err := c.lock(name, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// A CacheEntry is a file or directory directly in a Cache.
+type CacheEntry struct {
+	Name string
+	Size int64
+	// Used is when the entry was last used or modified.
+	Used time.Time
+}
+
+// Entries returns the entries of the cache, least recently used
+// first.
+func (c *Cache) Entries() ([]*CacheEntry, error) {
+	finfos, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*CacheEntry
+	for _, fi := range finfos {
+		if fi.Name() == cacheLocks {
+			continue
+		}
+		entry := &CacheEntry{Name: fi.Name(), Used: fi.ModTime()}
+		err := filepath.Walk(filepath.Join(c.Dir, fi.Name()), func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				entry.Size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Stable(byUsed(entries))
+	return entries, nil
+}
+
+type byUsed []*CacheEntry
+
+func (bu byUsed) Len() int           { return len(bu) }
+func (bu byUsed) Less(i, j int) bool { return bu[i].Used.Before(bu[j].Used) }
+func (bu byUsed) Swap(i, j int)      { bu[i], bu[j] = bu[j], bu[i] }
+
+// Size returns the total size of entries.
+func Size(entries []*CacheEntry) int64 {
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+	return size
+}
+
+// Evict removes the least recently used entries until the cache is
+// within its Budget and returns the removed entries. Entries in Use
+// are skipped.
+func (c *Cache) Evict() ([]*CacheEntry, error) {
+	if c.Budget <= 0 {
+		return nil, nil
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	size := Size(entries)
+	var evicted []*CacheEntry
+	for _, e := range entries {
+		if size <= c.Budget {
+			break
+		}
+		f, err := c.lock(e.Name, true, false)
+		if err != nil {
+			return evicted, err
+		}
+		if f == nil {
+			LogVerbose("Not evicting cache entry %s, it is in use", e.Name)
+			continue
+		}
+		LogVerbose("Evicting cache entry %s of %s", e.Name, FormatSize(e.Size))
+		err = os.RemoveAll(filepath.Join(c.Dir, e.Name))
+		f.Close()
+		if err != nil {
+			return evicted, err
+		}
+		size -= e.Size
+		evicted = append(evicted, e)
+	}
+	return evicted, nil
+}
+
+// Clear removes every entry in the cache.
+func (c *Cache) Clear() error {
+	entries, err := c.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(c.Dir, e.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvictCache evicts entries from the DefaultCache, only logging
+// errors.
+func EvictCache() {
+	cache, err := DefaultCache()
+	if err == nil {
+		_, err = cache.Evict()
+	}
+	if err != nil {
+		LogWarn("Error evicting cache entries: %s", err.Error())
+	}
+}
@@ -0,0 +1,130 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"512":    512,
+		"2KB":    2048,
+		"1.5MB":  3 << 19,
+		"1gb":    1 << 30,
+		" 10B ":  10,
+		"100 MB": 100 << 20,
+	}
+	for s, expected := range cases {
+		if size, err := ParseSize(s); err != nil || size != expected {
+			t.Errorf("Expected %q to parse to %d got %d %v", s, expected, size, err)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1KB", "ten"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+	if s := FormatSize(3 << 19); s != "1.5MB" {
+		t.Errorf("Expected 1.5MB got %s", s)
+	}
+	if s := FormatSize(12); s != "12B" {
+		t.Errorf("Expected 12B got %s", s)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	cache := &Cache{Dir: testHome, Budget: 250}
+	now := time.Now()
+	for i, name := range []string{"old", "mid", "new"} {
+		p := filepath.Join(testHome, name)
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(p, "data"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(p, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Using the oldest makes it the most recently used
+	cache.Entry("old")
+
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatalf("Error reading entries: %s", err.Error())
+	}
+	if len(entries) != 3 || entries[0].Name != "mid" || Size(entries) != 300 {
+		t.Errorf("Expected 3 entries of 300 bytes, least recently used mid first, got %+v", entries)
+	}
+	stats := CacheStats(cache, entries)
+	if last := stats[len(stats)-1]; !strings.Contains(last, "3 entries, 300B of 250B") {
+		t.Errorf("Expected stats total got %s", last)
+	}
+
+	evicted, err := cache.Evict()
+	if err != nil {
+		t.Fatalf("Error evicting: %s", err.Error())
+	}
+	if len(evicted) != 1 || evicted[0].Name != "mid" {
+		t.Errorf("Expected mid evicted got %+v", evicted)
+	}
+	if _, err := os.Stat(filepath.Join(testHome, "mid")); !os.IsNotExist(err) {
+		t.Errorf("Expected mid removed got %v", err)
+	}
+	if err := cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := cache.Entries(); len(entries) != 0 {
+		t.Errorf("Expected cleared cache to be empty got %+v", entries)
+	}
+}
+
+func TestCacheEvictInUse(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	cache := &Cache{Dir: testHome, Budget: 150}
+	now := time.Now()
+	for i, name := range []string{"old", "new"} {
+		p := filepath.Join(testHome, name)
+		if err := ioutil.WriteFile(p, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(p, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release, err := cache.Use("old")
+	if err != nil {
+		t.Fatalf("Error using entry: %s", err.Error())
+	}
+	evicted, err := cache.Evict()
+	if err != nil {
+		t.Fatalf("Error evicting: %s", err.Error())
+	}
+	if len(evicted) != 1 || evicted[0].Name != "new" {
+		t.Errorf("Expected only new evicted while old is in use got %+v", evicted)
+	}
+	if entries, _ := cache.Entries(); len(entries) != 1 || entries[0].Name != "old" {
+		t.Errorf("Expected old kept and no lock entries got %+v", entries)
+	}
+	release()
+	cache.Budget = 50
+	if evicted, err := cache.Evict(); err != nil || len(evicted) != 1 || evicted[0].Name != "old" {
+		t.Errorf("Expected old evicted once released got %+v %v", evicted, err)
+	}
+}
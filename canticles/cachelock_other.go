@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package canticles
+
+import "os"
+
+// lockFile is only supported where flock is, elsewhere cache entries
+// are never seen as in use.
+func lockFile(f *os.File, exclusive, wait bool) (bool, error) {
+	return true, nil
+}
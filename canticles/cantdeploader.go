@@ -1,8 +1,12 @@
 package canticles
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // A CantDepReader should return the CanticleDependencies of a
@@ -15,7 +19,8 @@ type CantDepReader interface {
 // set of CanticleDependencies. It uses a reader for fetchpath to read
 // the dependencies in a path and a resolver to resolve the vcs for
 // each path and actually fetch the dep. Update can be set to udpate
-// branches for a dep.
+// branches for a dep. Unless updating the TreeHash of each fetched dep
+// is verified.
 type CanticleDepLoader struct {
 	Reader   CantDepReader
 	Resolver RepoResolver
@@ -23,13 +28,33 @@ type CanticleDepLoader struct {
 	Update   bool
 	updated  map[string]string
 	Limit    int
+
+	// Signed are the roots of deps which must have a good GPG
+	// signature, "*" for all, verified using the keys of GPGHome,
+	// or only those with the fingerprints SignedKeys.
+	Signed     StringSet
+	GPGHome    string
+	SignedKeys []string
+
+	// Policy, if set, is enforced on the deps of FetchPath. If
+	// AllowViolations is set violations are recorded as exceptions
+	// instead.
+	Policy          *SourcePolicy
+	AllowViolations bool
+
+	// Provenance, if set, records every fetched dep.
+	Provenance *ProvenanceLog
+
+	// Logger, if non nil, is logged to while fetching.
+	Logger Logger
 }
 
 // FetchPath fetches the dependencies in a Canticle file at path. It
 // will return an array of errors encountered while fetching those
 // deps.
-func (cdl *CanticleDepLoader) FetchPath(path string) []error {
-	LogVerbose("Reading %s canticle deps", path)
+func (cdl *CanticleDepLoader) FetchPath(ctx context.Context, path string) []error {
+	ctx = withLogger(ctx, cdl.Logger)
+	LogVerboseContext(ctx, "Reading %s canticle deps", path)
 	pkg, err := PackageName(cdl.Gopath, path)
 	if err != nil {
 		return []error{err}
@@ -38,8 +63,11 @@ func (cdl *CanticleDepLoader) FetchPath(path string) []error {
 	if err != nil {
 		return []error{fmt.Errorf("cant fetch package %s couldn't read cant file %s", pkg, err.Error())}
 	}
-	LogVerbose("Read package canticle %s deps", pkg)
-	return cdl.FetchDeps(cdeps...)
+	LogVerboseContext(ctx, "Read package canticle %s deps", pkg)
+	if err := cdl.Policy.EnforcePolicy(path, cdeps, cdl.AllowViolations); err != nil {
+		return []error{err}
+	}
+	return cdl.FetchDeps(ctx, cdeps...)
 }
 
 type update struct {
@@ -49,8 +77,10 @@ type update struct {
 }
 
 // FetchDeps will fetch all of the cdeps passed to it in parallel and
-// return an array of encountered errors.
-func (cdl *CanticleDepLoader) FetchDeps(cdeps ...*CanticleDependency) []error {
+// return an array of encountered errors. No more deps are fetched once
+// ctx is done.
+func (cdl *CanticleDepLoader) FetchDeps(ctx context.Context, cdeps ...*CanticleDependency) []error {
+	ctx = withLogger(ctx, cdl.Logger)
 	cdl.updated = make(map[string]string, len(cdeps))
 	results := make(chan update, len(cdeps))
 	fetch := make(chan *CanticleDependency)
@@ -64,14 +94,28 @@ func (cdl *CanticleDepLoader) FetchDeps(cdeps ...*CanticleDependency) []error {
 		wg.Add(1)
 		go func() {
 			for cdep := range fetch {
-				rev, err := FetchDep(cdl.Resolver, cdep, cdl.Update)
+				v, rev, err := fetchDep(ctx, cdl.Resolver, cdep, cdl.Update)
+				if err == nil && !cdl.Update {
+					err = VerifyTreeHash(ctx, cdl.Gopath, cdep)
+				}
+				if err == nil {
+					err = cdl.verifySignature(ctx, cdep)
+				}
+				if err == nil && cdl.Provenance != nil {
+					err = cdl.recordProvenance(ctx, v, cdep, rev)
+				}
 				results <- update{cdep, rev, err}
 			}
 			wg.Done()
 		}()
 	}
+feed:
 	for _, cdep := range cdeps {
-		fetch <- cdep
+		select {
+		case fetch <- cdep:
+		case <-ctx.Done():
+			break feed
+		}
 	}
 	close(fetch)
 	go func() {
@@ -86,39 +130,114 @@ func (cdl *CanticleDepLoader) FetchDeps(cdeps ...*CanticleDependency) []error {
 			cdl.updated[result.cdep.Root] = result.rev
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		errors = append(errors, err)
+	}
+	Emit(ctx, FetchesFinished{Fetched: len(cdeps), Failed: len(errors)})
 	return errors
 }
 
+// recordProvenance records the fetch of cdep, resolved to v, in the
+// Provenance log.
+func (cdl *CanticleDepLoader) recordProvenance(ctx context.Context, v VCS, cdep *CanticleDependency, updated string) error {
+	if err := cdl.Provenance.Record(ctx, cdl.Gopath, v, cdep, updated); err != nil {
+		return fmt.Errorf("cant record provenance of %s %s", cdep.Root, err.Error())
+	}
+	return nil
+}
+
+// verifySignature checks the signature of cdep if it is Signed.
+func (cdl *CanticleDepLoader) verifySignature(ctx context.Context, cdep *CanticleDependency) error {
+	if cdl.Signed == nil || !(cdl.Signed["*"] || cdl.Signed[cdep.Root]) {
+		return nil
+	}
+	dir := PackageSource(cdl.Gopath, cdep.Root)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return fmt.Errorf("cant verify signature of %s, signatures are only supported for git", cdep.Root)
+	}
+	LogVerboseContext(ctx, "Verifying signature of %s at %s", cdep.Root, cdep.Revision)
+	if err := VerifyGitSignature(ctx, dir, cdep.Revision, cdl.GPGHome, cdl.SignedKeys); err != nil {
+		return fmt.Errorf("cant verify signature of %s %s", cdep.Root, err.Error())
+	}
+	return nil
+}
+
 // Updated returns a map of repo roots that where updated by the last
 // fetch deps/fetchpath call and the resulting info from the update.
 func (cdl *CanticleDepLoader) Updated() map[string]string {
 	return cdl.updated
 }
 
+// Unchanged returns true if v is a local checkout of cdep already at
+// its Revision and, if set, SourcePath, so it need not be fetched or
+// updated.
+func Unchanged(ctx context.Context, v VCS, cdep *CanticleDependency) bool {
+	lv, ok := v.(*LocalVCS)
+	if !ok || cdep.Revision == "" {
+		return false
+	}
+	if rev, err := lv.GetRev(ctx); err != nil || rev != cdep.Revision {
+		return false
+	}
+	if cdep.SourcePath == "" {
+		return true
+	}
+	source, err := lv.GetSource(ctx)
+	return err == nil && source == cdep.SourcePath
+}
+
 // FetchDep fetchs a single canticle dep using the resolver. If update
 // is true it will update the vcs branch to cdep.Revision. If not
 // updated the rev string will be the empty string.
-func FetchDep(resolver RepoResolver, cdep *CanticleDependency, update bool) (string, error) {
-	LogInfo("Resolving repo for cdep %+v", cdep)
-	vcs, err := resolver.ResolveRepo(cdep.Root, cdep)
+func FetchDep(ctx context.Context, resolver RepoResolver, cdep *CanticleDependency, update bool) (string, error) {
+	_, rev, err := fetchDep(ctx, resolver, cdep, update)
+	return rev, err
+}
+
+// fetchDep is FetchDep also returning the VCS cdep was resolved to,
+// nil if it could not be resolved.
+func fetchDep(ctx context.Context, resolver RepoResolver, cdep *CanticleDependency, update bool) (VCS, string, error) {
+	LogInfoContext(ctx, "Resolving repo for cdep %+v", cdep)
+	done := StartSpan(PhaseResolve, cdep.Root)
+	vcs, err := resolver.ResolveRepo(ctx, cdep.Root, cdep)
+	done()
+	if err != nil {
+		return nil, "", &ResolveError{Path: cdep.Root, Err: err}
+	}
+	Emit(ctx, DepResolved{ImportPath: cdep.Root, Root: vcs.GetRoot()})
+	if Unchanged(ctx, vcs, cdep) {
+		LogVerboseContext(ctx, "Cdep %s is already at %s", cdep.Root, cdep.Revision)
+		return vcs, "", nil
+	}
+	LogInfoContext(ctx, "Fetching cdep %+v", cdep)
+	Emit(ctx, FetchStarted{Root: cdep.Root, Revision: cdep.Revision})
+	start := time.Now()
+	done = StartSpan(PhaseFetch, cdep.Root)
+	err = vcs.Create(ctx, cdep.Revision)
+	done()
+	Emit(ctx, FetchFinished{Root: cdep.Root, Revision: cdep.Revision, Duration: time.Since(start), Err: err})
 	if err != nil {
-		return "", fmt.Errorf("cant create vcs for %v because %s", cdep, err.Error())
+		return vcs, "", &FetchError{Root: cdep.Root, Op: "fetch", Err: err}
 	}
-	LogInfo("Fetching cdep %+v", cdep)
-	if err := vcs.Create(cdep.Revision); err != nil {
-		return "", fmt.Errorf("cant fetch repo %s because %s", cdep.Root, err.Error())
+	if cdep.Revision != "" {
+		Emit(ctx, RevisionSet{Root: cdep.Root, Revision: cdep.Revision})
 	}
 	if update {
-		LogVerbose("Updating cdep %+v", cdep)
-		updated, res, err := vcs.UpdateBranch(cdep.Revision)
+		LogVerboseContext(ctx, "Updating cdep %+v", cdep)
+		done = StartSpan(PhaseFetch, cdep.Root)
+		updated, res, err := vcs.UpdateBranch(ctx, cdep.Revision)
+		done()
 		if !updated {
 			res = ""
 		}
 		if err != nil {
-			return res, fmt.Errorf("cant update repo %s because %s", cdep.Root, err.Error())
+			return vcs, res, &FetchError{Root: cdep.Root, Op: "update", Err: err}
+		}
+		if res != "" {
+			Emit(ctx, RevisionSet{Root: cdep.Root, Revision: res})
 		}
-		return res, nil
+		return vcs, res, nil
 	}
 
-	return "", nil
+	return vcs, "", nil
 }
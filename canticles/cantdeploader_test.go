@@ -1,9 +1,17 @@
 package canticles
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+
+	"golang.org/x/tools/go/vcs"
 )
 
 type testCantDepReader struct {
@@ -35,7 +43,7 @@ func newTestRepoRes(resolutions map[string]resolution) *testRepoRes {
 	}
 }
 
-func (rr *testRepoRes) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (rr *testRepoRes) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
 	rr.Lock()
 	defer rr.Unlock()
 	rr.calls[dep.Root] = true
@@ -125,7 +133,7 @@ func TestCantDepLoader(t *testing.T) {
 			Gopath:   gopath,
 			Update:   test.update,
 		}
-		errs := loader.FetchPath(test.path)
+		errs := loader.FetchPath(context.Background(), test.path)
 		if len(errs) != test.expectedErrors {
 			t.Errorf("test %s: Expected %s errors, got %v", test.name, test.expectedErrors, errs)
 		}
@@ -156,3 +164,106 @@ func checkResolutions(t *testing.T, test test, dep *CanticleDependency) {
 	}
 
 }
+
+func TestCanticleDepLoaderVerifySignature(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	if err := os.MkdirAll(PackageSource(testHome, "dep.com/x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cdl := &CanticleDepLoader{Gopath: testHome}
+	cdep := &CanticleDependency{Root: "dep.com/x"}
+	if err := cdl.verifySignature(context.Background(), cdep); err != nil {
+		t.Errorf("Expected no verification without Signed got %s", err.Error())
+	}
+	cdl.Signed = NewStringSet()
+	cdl.Signed.Add("*")
+	err = cdl.verifySignature(context.Background(), cdep)
+	if err == nil || !strings.Contains(err.Error(), "dep.com/x") {
+		t.Errorf("Expected error naming unverified dep got %v", err)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgname := "dep.com/x"
+	src := PackageSource(testHome, pkgname)
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a.go"), []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"add", "a.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+		{"remote", "add", "origin", "https://dep.com/x"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = src
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	v := NewLocalVCS(pkgname, pkgname, testHome, vcs.ByCmd("git"))
+	rev, err := v.GetRev(context.Background())
+	if err != nil {
+		t.Fatalf("Error getting rev: %s", err.Error())
+	}
+
+	tests := []struct {
+		v         VCS
+		cdep      *CanticleDependency
+		unchanged bool
+	}{
+		{v, &CanticleDependency{Root: pkgname, Revision: rev}, true},
+		{v, &CanticleDependency{Root: pkgname, Revision: rev, SourcePath: "https://dep.com/x"}, true},
+		{v, &CanticleDependency{Root: pkgname, Revision: rev, SourcePath: "https://mirror.com/x"}, false},
+		{v, &CanticleDependency{Root: pkgname, Revision: "master"}, false},
+		{v, &CanticleDependency{Root: pkgname}, false},
+		{&TestVCS{Rev: rev}, &CanticleDependency{Root: pkgname, Revision: rev}, false},
+	}
+	for i, test := range tests {
+		if unchanged := Unchanged(context.Background(), test.v, test.cdep); unchanged != test.unchanged {
+			t.Errorf("Test %d expected unchanged %v got %v for %+v", i, test.unchanged, unchanged, test.cdep)
+		}
+	}
+}
+
+func TestSourcesResolverUnchanged(t *testing.T) {
+	sources := NewDependencySources(2)
+	for _, root := range []string{"dep.com/x", "dep.com/y"} {
+		source := NewDependencySource(root)
+		source.OnDiskRevision = "a"
+		source.Revisions.Add("a")
+		sources.AddSource(source)
+	}
+	reader := &testCantDepReader{deps: []*CanticleDependency{
+		{Root: "dep.com/x", Revision: "b"},
+		{Root: "dep.com/y", Revision: "b"},
+	}}
+	sr := &SourcesResolver{
+		CDepReader: reader,
+		Saved: []*CanticleDependency{
+			{Root: "dep.com/x", Revision: "a"},
+			{Root: "dep.com/y", Revision: "c"},
+		},
+	}
+	if err := sr.resolveCantDeps(context.Background(), sources, "dep.com/z"); err != nil {
+		t.Fatalf("Error resolving cant deps: %s", err.Error())
+	}
+	if x := sources.DepSource("dep.com/x"); x.Revisions.Size() != 1 {
+		t.Errorf("Expected unchanged dep.com/x not re-resolved got %v", x.Revisions)
+	}
+	if y := sources.DepSource("dep.com/y"); y.Revisions.Size() != 2 {
+		t.Errorf("Expected changed dep.com/y resolved got %v", y.Revisions)
+	}
+}
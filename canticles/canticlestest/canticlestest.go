@@ -0,0 +1,213 @@
+// Package canticlestest provides fakes of the VCS, RepoResolver and
+// dependency reader interfaces of package canticles, so tools
+// embedding canticle can test against them without real repos.
+package canticlestest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Comcast/Canticle/canticles"
+)
+
+// MockVCS is a canticles.VCS holding its revision, branch and source
+// in memory. Every method returns Err if it is set. It is safe for
+// concurrent use.
+type MockVCS struct {
+	sync.Mutex
+	Root   string
+	Rev    string
+	Branch string
+	Source string
+	Err    error
+	// Created and Updated count the calls to Create and SetRev.
+	Created int
+	Updated int
+}
+
+// Create records the create and sets Rev to rev if it is not empty.
+func (mv *MockVCS) Create(ctx context.Context, rev string) error {
+	mv.Lock()
+	defer mv.Unlock()
+	mv.Created++
+	if mv.Err != nil {
+		return mv.Err
+	}
+	if rev != "" {
+		mv.Rev = rev
+	}
+	return nil
+}
+
+// SetRev records the update and sets Rev to rev.
+func (mv *MockVCS) SetRev(ctx context.Context, rev string) error {
+	mv.Lock()
+	defer mv.Unlock()
+	mv.Updated++
+	if mv.Err != nil {
+		return mv.Err
+	}
+	mv.Rev = rev
+	return nil
+}
+
+// GetRev returns Rev.
+func (mv *MockVCS) GetRev(ctx context.Context) (string, error) {
+	mv.Lock()
+	defer mv.Unlock()
+	return mv.Rev, mv.Err
+}
+
+// GetBranch returns Branch.
+func (mv *MockVCS) GetBranch(ctx context.Context) (string, error) {
+	mv.Lock()
+	defer mv.Unlock()
+	return mv.Branch, mv.Err
+}
+
+// UpdateBranch reports an update if branch is Branch.
+func (mv *MockVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
+	mv.Lock()
+	defer mv.Unlock()
+	if mv.Err != nil {
+		return false, "", mv.Err
+	}
+	if branch == "" || branch != mv.Branch {
+		return false, "", nil
+	}
+	return true, mv.Rev, nil
+}
+
+// GetSource returns Source.
+func (mv *MockVCS) GetSource(ctx context.Context) (string, error) {
+	mv.Lock()
+	defer mv.Unlock()
+	return mv.Source, mv.Err
+}
+
+// GetRoot returns Root.
+func (mv *MockVCS) GetRoot() string {
+	return mv.Root
+}
+
+// A Resolution is what a MockResolver resolves an import path to.
+type Resolution struct {
+	VCS canticles.VCS
+	Err error
+}
+
+// MockResolver is a canticles.RepoResolver resolving the import paths
+// in Resolutions, and the packages under them. Other paths fail with
+// a canticles.ResolutionFailureError. It is safe for concurrent use.
+type MockResolver struct {
+	sync.Mutex
+	Resolutions map[string]Resolution
+	// Calls are the import paths resolved, in order.
+	Calls []string
+}
+
+// NewMockResolver returns a MockResolver resolving the root of each
+// of vcss to it.
+func NewMockResolver(vcss ...*MockVCS) *MockResolver {
+	mr := &MockResolver{Resolutions: make(map[string]Resolution, len(vcss))}
+	for _, v := range vcss {
+		mr.Resolutions[v.Root] = Resolution{VCS: v}
+	}
+	return mr
+}
+
+// ResolveRepo returns the Resolution of the longest path in
+// Resolutions containing importPath.
+func (mr *MockResolver) ResolveRepo(ctx context.Context, importPath string, dep *canticles.CanticleDependency) (canticles.VCS, error) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.Calls = append(mr.Calls, importPath)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	root := ""
+	for path := range mr.Resolutions {
+		if canticles.PathIsChild(path, importPath) && len(path) > len(root) {
+			root = path
+		}
+	}
+	if root == "" {
+		return nil, canticles.NewResolutionFailureError(importPath, "mock")
+	}
+	r := mr.Resolutions[root]
+	return r.VCS, r.Err
+}
+
+// MockReader reads packages and Canticle files from memory. It
+// implements canticles.CantDepReader, and its ReadDependencies and
+// ReadImports methods are a canticles.DependencyReader and
+// canticles.PkgReaderFunc. It is safe for concurrent use.
+type MockReader struct {
+	sync.Mutex
+	// Imports are the imports of each package.
+	Imports map[string][]string
+	// Canticles are the contents of the Canticle file of each
+	// package.
+	Canticles map[string][]*canticles.CanticleDependency
+	// Errs are returned when reading the keyed packages.
+	Errs map[string]error
+	// Reads are the packages read, in order.
+	Reads []string
+}
+
+// NewMockReader returns an empty MockReader.
+func NewMockReader() *MockReader {
+	return &MockReader{
+		Imports:   make(map[string][]string),
+		Canticles: make(map[string][]*canticles.CanticleDependency),
+		Errs:      make(map[string]error),
+	}
+}
+
+func (mr *MockReader) read(pkg string) error {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.Reads = append(mr.Reads, pkg)
+	return mr.Errs[pkg]
+}
+
+// ReadImports returns the Imports of pkg, sorted.
+func (mr *MockReader) ReadImports(ctx context.Context, pkg string) ([]string, error) {
+	if err := mr.read(pkg); err != nil {
+		return nil, err
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	imports := append([]string{}, mr.Imports[pkg]...)
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// ReadDependencies returns a Dependency for each of the Imports of
+// pkg.
+func (mr *MockReader) ReadDependencies(ctx context.Context, pkg string) (canticles.Dependencies, error) {
+	imports, err := mr.ReadImports(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	deps := canticles.NewDependencies()
+	deps.AddDeps(imports...)
+	return deps, nil
+}
+
+// CanticleDependencies returns the Canticles of pkg, failing as a
+// missing Canticle file would if it has none.
+func (mr *MockReader) CanticleDependencies(pkg string) ([]*canticles.CanticleDependency, error) {
+	if err := mr.read(pkg); err != nil {
+		return nil, err
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	cdeps, ok := mr.Canticles[pkg]
+	if !ok {
+		return nil, fmt.Errorf("no Canticle file for %s", pkg)
+	}
+	return cdeps, nil
+}
@@ -0,0 +1,79 @@
+package canticlestest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Comcast/Canticle/canticles"
+)
+
+func TestMockResolver(t *testing.T) {
+	v := &MockVCS{Root: "dep.com/x"}
+	mr := NewMockResolver(v)
+	got, err := mr.ResolveRepo(context.Background(), "dep.com/x/sub", nil)
+	if err != nil || got != v {
+		t.Errorf("Expected dep.com/x/sub resolved to %v got %v %v", v, got, err)
+	}
+	if _, err := mr.ResolveRepo(context.Background(), "other.com/y", nil); canticles.ResolutionFailureErr(err) == nil {
+		t.Errorf("Expected resolution failure got %v", err)
+	}
+	if expected := []string{"dep.com/x/sub", "other.com/y"}; !reflect.DeepEqual(expected, mr.Calls) {
+		t.Errorf("Expected calls %v got %v", expected, mr.Calls)
+	}
+}
+
+func TestMockFetch(t *testing.T) {
+	current := &MockVCS{Root: "dep.com/x", Rev: "abc"}
+	stale := &MockVCS{Root: "dep.com/y", Rev: "old"}
+	broken := &MockVCS{Root: "dep.com/z", Err: errors.New("no network")}
+	reader := NewMockReader()
+	reader.Canticles["test.com/project"] = []*canticles.CanticleDependency{
+		{Root: "dep.com/x", Revision: "abc"},
+		{Root: "dep.com/y", Revision: "new"},
+		{Root: "dep.com/z", Revision: "def"},
+	}
+	loader := &canticles.CanticleDepLoader{
+		Reader:   reader,
+		Resolver: NewMockResolver(current, stale, broken),
+		Gopath:   "/gopath",
+	}
+	errs := loader.FetchPath(context.Background(), canticles.PackageSource("/gopath", "test.com/project"))
+	if len(errs) != 1 || canticles.ErrorCode(errs[0]) != canticles.CodeFetch {
+		t.Errorf("Expected the fetch of dep.com/z to fail got %v", errs)
+	}
+	if current.Created != 1 || current.Rev != "abc" {
+		t.Errorf("Expected dep.com/x fetched at abc got %d %s", current.Created, current.Rev)
+	}
+	if stale.Created != 1 || stale.Rev != "new" {
+		t.Errorf("Expected dep.com/y fetched at new got %d %s", stale.Created, stale.Rev)
+	}
+}
+
+func TestMockReader(t *testing.T) {
+	reader := NewMockReader()
+	reader.Imports["test.com/project"] = []string{"dep.com/y", "dep.com/x"}
+	reader.Imports["dep.com/x"] = []string{"dep.com/y"}
+	reader.Errs["dep.com/y"] = errors.New("unreadable")
+	var handled []string
+	dw := canticles.NewDependencyWalker(reader.ReadImports, func(ctx context.Context, pkg string) error {
+		handled = append(handled, pkg)
+		return nil
+	})
+	if err := dw.TraverseDependencies(context.Background(), "test.com/project"); err == nil {
+		t.Errorf("Expected error reading dep.com/y")
+	}
+	sort.Strings(handled)
+	if expected := []string{"dep.com/x", "dep.com/y", "test.com/project"}; !reflect.DeepEqual(expected, handled) {
+		t.Errorf("Expected packages handled %v got %v", expected, handled)
+	}
+	deps, err := reader.ReadDependencies(context.Background(), "dep.com/x")
+	if err != nil || deps.Dependency("dep.com/y") == nil {
+		t.Errorf("Expected dep.com/x to import dep.com/y got %v %v", deps, err)
+	}
+	if _, err := reader.CanticleDependencies("dep.com/x"); err == nil {
+		t.Errorf("Expected error reading missing Canticle file")
+	}
+}
@@ -0,0 +1,93 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+)
+
+type Clean struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Stats   bool
+	All     bool
+}
+
+func NewClean() *Clean {
+	f := flag.NewFlagSet("clean", flag.ExitOnError)
+	c := &Clean{flags: f}
+	f.BoolVar(&c.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&c.Stats, "stats", false, "Print the entries of the cache and their sizes instead of cleaning it")
+	f.BoolVar(&c.All, "all", false, "Remove every entry of the cache")
+	return c
+}
+
+var clean = NewClean()
+
+var CleanCommand = &Command{
+	Name:             "clean",
+	UsageLine:        "clean [-v] [-stats] [-all]",
+	ShortDescription: "Evict entries from the cache.",
+	LongDescription: `The clean command evicts the least recently used entries of the cache until it is within the CacheSize of the users ~/.canticle/config.json, e.g.:
+  {
+      "CacheDir": "/var/cache/canticle",
+      "CacheSize": "512MB"
+  }
+
+The cache is in $CANTICLE_CACHE if set, otherwise the CacheDir of the config, otherwise ~/.canticle/cache. cant save also evicts entries after updating the cache.
+
+Specify -stats to print each entry of the cache, least recently used first, with its size and the total against the budget.
+
+Specify -all to remove every entry.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: clean.flags,
+	Cmd:   clean,
+}
+
+// Run the clean command.
+func (c *Clean) Run(ctx context.Context, args []string) {
+	if c.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	cache, err := DefaultCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case c.Stats:
+		entries, err := cache.Entries()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range CacheStats(cache, entries) {
+			fmt.Println(line)
+		}
+	case c.All:
+		if err := cache.Clear(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		evicted, err := cache.Evict()
+		if err != nil {
+			log.Fatal(err)
+		}
+		LogInfoContext(ctx, "Evicted %d entries, %s", len(evicted), FormatSize(Size(evicted)))
+	}
+}
+
+// CacheStats returns a line for each of the entries of cache and a
+// line with their total size and the caches budget.
+func CacheStats(cache *Cache, entries []*CacheEntry) []string {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%-40s %8s  %s", e.Name, FormatSize(e.Size), e.Used.Format("2006-01-02 15:04:05")))
+	}
+	budget := "unlimited"
+	if cache.Budget > 0 {
+		budget = FormatSize(cache.Budget)
+	}
+	return append(lines, fmt.Sprintf("%s: %d entries, %s of %s", cache.Dir, len(entries), FormatSize(Size(entries)), budget))
+}
@@ -0,0 +1,184 @@
+package canticles
+
+import (
+	"context"
+	"runtime"
+)
+
+// Options configures a Client. The zero value fetches into the gopath
+// of EnvGoPath and never prompts.
+type Options struct {
+	// Gopath is the gopath packages are read from and fetched
+	// into, if empty the gopath of EnvGoPath is used.
+	Gopath string
+	// Verbose logs a verbose set of operations while the Client
+	// works instead of just errors.
+	Verbose bool
+	// Limit is the number of fetches in flight at once, 10 if
+	// unset.
+	Limit int
+	// Jobs is the number of packages read at once when resolving,
+	// the number of CPUs if unset.
+	Jobs int
+	// Update updates branches where possible when fetching.
+	Update bool
+	// Branches resolves branches or tags instead of revisions
+	// where present.
+	Branches bool
+	// NoCache lists every package with go list instead of
+	// reusing the package cache.
+	NoCache bool
+	// AllFiles reads the imports of files excluded by build
+	// constraints for any platform or tags too, as cant save
+	// -all-files does.
+	AllFiles bool
+	// Generate reads the go:generate tools of the project as
+	// dependencies, as cant save -generate does.
+	Generate bool
+	// Positions records the file:line positions of each import
+	// in the ImportedAt of the deps read by ReadDeps.
+	Positions bool
+	// AllowViolations fetches and resolves dependencies forbidden
+	// by the source policy, recording them as exceptions.
+	AllowViolations bool
+	// Resolver resolves conflicting revisions and sources, if nil
+	// the revisions and sources on disk are preferred.
+	Resolver ConflictResolver
+	// Logger, if non nil, receives the events logged by the
+	// Client's operations instead of the Logger of their context.
+	Logger Logger
+	// Events, if non nil, is the bus the Client's operations emit
+	// their events on instead of the bus of their context.
+	Events *EventBus
+}
+
+// A Client performs canticle operations programmatically, as cant
+// get, save and vendor do, for tools embedding canticle. Operations
+// stop once their context is done, and log with the Logger and trace
+// id of their context, see WithLogger and WithTraceID.
+type Client struct {
+	opts   Options
+	gopath string
+}
+
+// NewClient returns a Client using opts.
+func NewClient(opts Options) (*Client, error) {
+	c := &Client{opts: opts, gopath: opts.Gopath}
+	if c.gopath == "" {
+		var err error
+		if c.gopath, err = EnvGoPath(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.Limit <= 0 {
+		c.opts.Limit = 10
+	}
+	if c.opts.Jobs <= 0 {
+		c.opts.Jobs = runtime.GOMAXPROCS(0)
+	}
+	if c.opts.Resolver == nil {
+		c.opts.Resolver = &PreferLocalResolution{}
+	}
+	return c, nil
+}
+
+// Gopath returns the gopath the Client works in.
+func (c *Client) Gopath() string {
+	return c.gopath
+}
+
+// verbose sets Verbose for the length of an operation, the returned
+// func restores it.
+func (c *Client) verbose() func() {
+	prev := Verbose
+	if c.opts.Verbose {
+		Verbose = true
+	}
+	return func() { Verbose = prev }
+}
+
+// context returns ctx using the Logger and Events of the Client.
+func (c *Client) context(ctx context.Context) context.Context {
+	ctx = withLogger(ctx, c.opts.Logger)
+	if c.opts.Events != nil {
+		ctx = WithEvents(ctx, c.opts.Events)
+	}
+	return ctx
+}
+
+func (c *Client) save() *Save {
+	return &Save{
+		Resolver:        c.opts.Resolver,
+		Excludes:        DirFlags(NewStringSet()),
+		Branches:        c.opts.Branches,
+		NoCache:         c.opts.NoCache,
+		AllFiles:        c.opts.AllFiles,
+		Generate:        c.opts.Generate,
+		Positions:       c.opts.Positions,
+		Jobs:            c.opts.Jobs,
+		AllowViolations: c.opts.AllowViolations,
+	}
+}
+
+// Get fetches the package at path, and all of its dependencies, at
+// the revisions of their Canticle files, see cant get.
+func (c *Client) Get(ctx context.Context, path string) error {
+	defer c.verbose()()
+	ctx = c.context(ctx)
+	g := &Get{
+		Gopath:          c.gopath,
+		Update:          c.opts.Update,
+		Limit:           c.opts.Limit,
+		AllowViolations: c.opts.AllowViolations,
+		Signed:          NewStringSet(),
+		SignedKeys:      NewStringSet(),
+	}
+	return g.GetPackage(ctx, path)
+}
+
+// ReadDeps walks the packages under path, returning every package in
+// its dep tree.
+func (c *Client) ReadDeps(ctx context.Context, path string) (Dependencies, error) {
+	defer c.verbose()()
+	ctx = c.context(ctx)
+	return c.save().ReadDeps(ctx, c.gopath, path)
+}
+
+// Resolve returns the dependencies cant save would save for the
+// package at path, without saving them.
+func (c *Client) Resolve(ctx context.Context, path string) ([]*CanticleDependency, error) {
+	defer c.verbose()()
+	ctx = c.context(ctx)
+	_, cdeps, err := c.save().ResolveProject(ctx, c.gopath, path)
+	return cdeps, err
+}
+
+// Save saves the dependencies of the package at path in its Canticle
+// file, see cant save.
+func (c *Client) Save(ctx context.Context, path string) error {
+	defer c.verbose()()
+	ctx = c.context(ctx)
+	return c.save().SaveProject(ctx, c.gopath, path)
+}
+
+// Dependencies returns the dependencies saved in the Canticle file at
+// path, none if it has no Canticle file.
+func (c *Client) Dependencies(path string) ([]*CanticleDependency, error) {
+	return SavedDependencies(path)
+}
+
+// Vendor fetches the package pkg and everything it imports, using the
+// sources and revisions of cdeps, see cant vendor.
+func (c *Client) Vendor(ctx context.Context, pkg string, cdeps []*CanticleDependency) error {
+	defer c.verbose()()
+	ctx = c.context(ctx)
+	v := &Vendor{Gopath: c.gopath, Resolver: c.opts.Resolver, AllowViolations: c.opts.AllowViolations}
+	return v.Vendor(ctx, pkg, cdeps)
+}
+
+// RepoResolver returns the resolver the Client fetches with, see
+// NewFetchResolver. Its resolutions are not cached across runs.
+func (c *Client) RepoResolver() RepoResolver {
+	resolver, _ := NewFetchResolver(c.gopath, Secure, nil, false)
+	return resolver
+}
@@ -0,0 +1,76 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"src/proj/main.go":   "package main\n\nimport _ \"dep.com/x\"\n\nfunc main() {}\n",
+		"src/dep.com/x/x.go": "package x\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(testHome, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dep := filepath.Join(testHome, "src", "dep.com", "x")
+	for _, args := range [][]string{
+		{"init"},
+		{"add", "x.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dep
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+
+	c, err := NewClient(Options{Gopath: testHome, NoCache: true})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err.Error())
+	}
+	if c.Gopath() != testHome {
+		t.Errorf("Expected gopath %s got %s", testHome, c.Gopath())
+	}
+	proj := filepath.Join(testHome, "src", "proj")
+	deps, err := c.ReadDeps(context.Background(), proj)
+	if err != nil {
+		t.Fatalf("Error reading deps: %s", err.Error())
+	}
+	if deps.Dependency("dep.com/x") == nil {
+		t.Errorf("Expected dep.com/x in dep tree got %+v", deps)
+	}
+	cdeps, err := c.Resolve(context.Background(), proj)
+	if err != nil {
+		t.Fatalf("Error resolving: %s", err.Error())
+	}
+	if len(cdeps) != 1 || cdeps[0].Root != "dep.com/x" || cdeps[0].Revision == "" {
+		t.Fatalf("Expected dep.com/x at a revision got %+v", cdeps)
+	}
+	if saved, err := c.Dependencies(proj); err != nil || len(saved) != 0 {
+		t.Errorf("Expected nothing saved by Resolve got %+v %v", saved, err)
+	}
+	if err := c.Save(context.Background(), proj); err != nil {
+		t.Fatalf("Error saving: %s", err.Error())
+	}
+	saved, err := c.Dependencies(proj)
+	if err != nil || len(saved) != 1 || saved[0].Revision != cdeps[0].Revision {
+		t.Errorf("Expected saved %+v got %+v %v", cdeps, saved, err)
+	}
+}
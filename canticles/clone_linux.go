@@ -0,0 +1,30 @@
+package canticles
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl, which shares the extents of one file
+// with another on filesystems such as btrfs and xfs.
+const ficlone = 0x40049409
+
+// cloneFile creates dst as a copy on write clone of src.
+func cloneFile(src, dst string, mode os.FileMode) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.Fd(), ficlone, s.Fd())
+	if errno != 0 {
+		d.Close()
+		os.Remove(dst)
+		return errno
+	}
+	return d.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package canticles
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is only supported on linux.
+func cloneFile(src, dst string, mode os.FileMode) error {
+	return errors.New("copy on write clones are not supported")
+}
@@ -1,6 +1,7 @@
 package canticles
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,9 +9,9 @@ import (
 )
 
 // Runnable is used by Command to call an item with the arguments
-// pertinent to it.
+// pertinent to it. Its work stops once ctx is done.
 type Runnable interface {
-	Run(args []string)
+	Run(ctx context.Context, args []string)
 }
 
 // Command represents a Canticle command to be run including:
@@ -29,10 +30,35 @@ type Command struct {
 
 // Commands is the prebuild list of Canticle commands.
 var Commands = map[string]*Command{
-	"get":        GetCommand,
-	"save":       SaveCommand,
-	"vendor":     VendorCommand,
-	"genversion": GenVersionCommand,
+	"get":         GetCommand,
+	"save":        SaveCommand,
+	"vendor":      VendorCommand,
+	"genversion":  GenVersionCommand,
+	"sysdeps":     SysDepsCommand,
+	"binaries":    BinariesCommand,
+	"lint":        LintCommand,
+	"buildinfo":   BuildInfoCommand,
+	"sbom":        SBOMCommand,
+	"inspect":     InspectCommand,
+	"verify":      VerifyCommand,
+	"audit":       AuditCommand,
+	"clean":       CleanCommand,
+	"warm":        WarmCommand,
+	"build":       BuildCommand,
+	"test":        TestCommand,
+	"exec":        ExecCommand,
+	"daemon":      DaemonCommand,
+	"query":       QueryCommand,
+	"status":      StatusCommand,
+	"hooks":       HooksCommand,
+	"self-update": SelfUpdateCommand,
+	"lock":        LockCommand,
+	"graph":       GraphCommand,
+	"why":         WhyCommand,
+	"import":      ImportCommand,
+	"gomod":       GoModCommand,
+	"prune":       PruneCommand,
+	"outdated":    OutdatedCommand,
 }
 
 // Usage will print the commands UsageLine and LongDescription and
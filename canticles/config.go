@@ -0,0 +1,145 @@
+package canticles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+)
+
+// ConfigFile returns the location of the project configuration file
+// for a path. Should be a directory.
+func ConfigFile(p string) string {
+	return filepath.Join(p, ".canticle.json")
+}
+
+// ProjectConfig holds per project settings, it is stored as json in
+// the ConfigFile next to a projects Canticle file.
+type ProjectConfig struct {
+	// SkipDirs are filepath.Match patterns of directories save
+	// will not recur into. Patterns without a slash match a
+	// directories name, others match its slash separated path
+	// relative to the project.
+	SkipDirs []string `json:",omitempty"`
+	// GoEnv are extra enviroment variables applied to the go
+	// subprocesses run for this project. Values given with
+	// cant -goenv take precedence.
+	GoEnv map[string]string `json:",omitempty"`
+	// Signed lists the roots of dependencies whose revision must
+	// be a GPG signed git tag or commit, "*" requires all to be.
+	Signed []string `json:",omitempty"`
+	// GPGHome is the GNUPGHOME directory, relative to the project,
+	// holding the keys Signed dependencies are verified against.
+	// The users default keyring is used if empty, then SignedKeys
+	// must be set.
+	GPGHome string `json:",omitempty"`
+	// SignedKeys are the fingerprints of the keys trusted to sign
+	// Signed dependencies. If empty every key of GPGHome is.
+	SignedKeys []string `json:",omitempty"`
+	// TLS configures the CAs trusted, and hosts not verified, when
+	// fetching. CAFiles are relative to the project. Settings
+	// given with cant -cafile and -insecure take precedence.
+	TLS *TLSConfig `json:",omitempty"`
+	// AdvisoryURL is where cant audit fetches advisories from.
+	AdvisoryURL string `json:",omitempty"`
+	// AdvisoryDB is a snapshot directory of advisories, relative
+	// to the project, cant audit reads instead of AdvisoryURL.
+	AdvisoryDB string `json:",omitempty"`
+	// Secure refuses fetching from unencrypted sources, as cant
+	// -secure does.
+	Secure bool `json:",omitempty"`
+	// PlaintextHosts may be fetched from unencrypted in secure
+	// mode.
+	PlaintextHosts []string `json:",omitempty"`
+	// ImportPath is the import path the project is linked at in
+	// its isolated gopath, see cant get -isolated. It defaults to
+	// the projects path in the users gopath.
+	ImportPath string `json:",omitempty"`
+	// VendorPrune are patterns, or names of PruneSets or NonGo, of
+	// files not copied into vendor by cant vendor -copy and -only.
+	VendorPrune []string `json:",omitempty"`
+	// LockServer is the url cant lock pushes the projects lock to
+	// and pulls it from, see LockServer.
+	LockServer string `json:",omitempty"`
+}
+
+// Env returns the GoEnv of this config as sorted KEY=VALUE pairs.
+func (pc *ProjectConfig) Env() EnvFlags {
+	env := make(EnvFlags, 0, len(pc.GoEnv))
+	for k, v := range pc.GoEnv {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// LoadProjectConfig reads the ProjectConfig for the project at
+// path. If no config file is present an empty config is returned.
+func LoadProjectConfig(path string) (*ProjectConfig, error) {
+	config := &ProjectConfig{}
+	f, err := os.Open(ConfigFile(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	LogVerbose("Reading project config: %s", f.Name())
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("cant decode project config %s %s", f.Name(), err.Error())
+	}
+	return config, nil
+}
+
+// UserConfigFile returns the location of the users configuration
+// file, ~/.canticle/config.json.
+func UserConfigFile() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("cant find user config, could not get current user %s", err.Error())
+	}
+	return filepath.Join(u.HomeDir, ".canticle", "config.json"), nil
+}
+
+// UserConfig holds per user settings, it is stored as json in the
+// UserConfigFile.
+type UserConfig struct {
+	// CacheDir is the root of canticles caches, see CacheDir.
+	CacheDir string `json:",omitempty"`
+	// CacheSize is the size budget of the cache, e.g. 512MB or
+	// 2GB. The least recently used cache entries are evicted to
+	// keep within it, empty means unlimited.
+	CacheSize string `json:",omitempty"`
+	// Metrics, if set, is where the metrics of operations are
+	// sent, see Metrics.
+	Metrics *MetricsConfig `json:",omitempty"`
+	// Update configures where cant self-update gets releases and
+	// how they are verified, see UpdateConfig.
+	Update *UpdateConfig `json:",omitempty"`
+}
+
+// LoadUserConfig reads the UserConfig. If no config file is present
+// an empty config is returned.
+func LoadUserConfig() (*UserConfig, error) {
+	config := &UserConfig{}
+	file, err := UserConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	LogVerbose("Reading user config: %s", f.Name())
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("cant decode user config %s %s", f.Name(), err.Error())
+	}
+	return config, nil
+}
@@ -0,0 +1,42 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadProjectConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	config, err := LoadProjectConfig(dir)
+	if err != nil {
+		t.Errorf("Error loading missing config: %s", err.Error())
+	}
+	if config == nil || len(config.SkipDirs) != 0 {
+		t.Errorf("Expected empty config got %+v", config)
+	}
+
+	if err := ioutil.WriteFile(ConfigFile(dir), []byte(`{"SkipDirs": ["gen", "cmd/*"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadProjectConfig(dir)
+	if err != nil {
+		t.Errorf("Error loading valid config: %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"gen", "cmd/*"}, config.SkipDirs) {
+		t.Errorf("Unexpected skip dirs %v", config.SkipDirs)
+	}
+
+	if err := ioutil.WriteFile(ConfigFile(dir), []byte(`{"SkipDirs": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProjectConfig(dir); err == nil {
+		t.Errorf("Expected error loading invalid config")
+	}
+}
@@ -19,7 +19,12 @@ func (pl *PreferLocalResolution) ResolveConflicts(deps *DependencySources) ([]*C
 			Root:       source.Root,
 			SourcePath: source.OnDiskSource,
 			Revision:   source.OnDiskRevision,
+			Constraint: source.Constraint,
 		}
+		if source.Resolved != "" {
+			cd.Revision = source.Resolved
+		}
+		cd.PkgConfig, cd.CgoLibs = source.SystemDeps()
 		cdeps = append(cdeps, cd)
 	}
 	return cdeps, nil
@@ -43,10 +48,14 @@ func (pr PromptResolution) ResolveConflicts(deps *DependencySources) ([]*Canticl
 }
 
 func (pr PromptResolution) ResolveConflict(dep *DependencySource) (*CanticleDependency, error) {
-	cd := &CanticleDependency{Root: dep.Root}
+	cd := &CanticleDependency{Root: dep.Root, Constraint: dep.Constraint}
+	cd.PkgConfig, cd.CgoLibs = dep.SystemDeps()
 	var err error
 	size := dep.Revisions.Size()
 	switch {
+	case dep.Resolved != "":
+		// Version constraints leave no choice
+		cd.Revision = dep.Resolved
 	case size > 1:
 		cd.Revision, err = pr.SelectRevision(dep)
 		if err != nil {
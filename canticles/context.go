@@ -0,0 +1,82 @@
+package canticles
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	traceIDKey
+	eventsKey
+	walkDepthKey
+	httpClientKey
+	vcsEnvKey
+)
+
+// WithTraceID returns a copy of ctx whose operations log with the
+// trace id id, StdLogger prefixes their lines with it.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey, id)
+}
+
+// TraceID returns the trace id of ctx, if any.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey).(string)
+	return id
+}
+
+// withWalkDepth returns a copy of ctx for handling a package depth
+// imports from the package a DependencyWalker walks from.
+func withWalkDepth(ctx context.Context, depth int) context.Context {
+	return context.WithValue(ctx, walkDepthKey, depth)
+}
+
+// WalkDepth returns the number of imports between the package a
+// DependencyWalker walks from and the package its handler is called
+// with ctx for, zero outside a walk.
+func WalkDepth(ctx context.Context) int {
+	depth, _ := ctx.Value(walkDepthKey).(int)
+	return depth
+}
+
+// WithHTTPClient returns a copy of ctx whose http requests, such as
+// meta tag discovery and archive downloads, use client.
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey, client)
+}
+
+// HTTPClient returns the http.Client of ctx, the http.DefaultClient
+// if it has none.
+func HTTPClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(httpClientKey).(*http.Client); ok && client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// WithVCSEnv returns a copy of ctx whose git and hg subprocesses run
+// with the KEY=VALUE variables of env set.
+func WithVCSEnv(ctx context.Context, env []string) context.Context {
+	return context.WithValue(ctx, vcsEnvKey, env)
+}
+
+// command returns an exec.Cmd running name with args in dir, killed
+// when ctx is done, with the enviroment of WithVCSEnv.
+func command(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	if env, _ := ctx.Value(vcsEnvKey).([]string); len(env) > 0 {
+		cmd.Env = os.Environ()
+		for _, kv := range env {
+			parts := strings.SplitN(kv, "=", 2)
+			cmd.Env = PatchEnviroment(cmd.Env, parts[0], parts[1])
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,21 @@
+package canticles
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTraverseDependenciesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tw := &TestWalker{}
+	dw := NewDependencyWalker(NormalReader.ReadDependencies, func(ctx context.Context, pkg string) error {
+		cancel()
+		return tw.HandlePackage(ctx, pkg)
+	})
+	if err := dw.TraverseDependencies(ctx, "testpkg"); err != context.Canceled {
+		t.Errorf("Expected context.Canceled got %v", err)
+	}
+	if len(tw.calls) != 1 {
+		t.Errorf("Expected no packages handled after cancel got %v", tw.calls)
+	}
+}
@@ -0,0 +1,306 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+)
+
+// DaemonSocket returns the socket cant daemon listens on by default,
+// $CANTICLE_DAEMON_SOCKET if set, otherwise ~/.canticle/daemon.sock.
+func DaemonSocket() (string, error) {
+	if socket := os.Getenv("CANTICLE_DAEMON_SOCKET"); socket != "" {
+		return socket, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("cant find daemon socket, could not get current user %s", err.Error())
+	}
+	return filepath.Join(u.HomeDir, ".canticle", "daemon.sock"), nil
+}
+
+// DaemonArgs are the arguments of every DaemonService method.
+type DaemonArgs struct {
+	// Path is the directory of the project queried.
+	Path string
+	// ImportPath is the package, or root of packages, asked about
+	// by Why.
+	ImportPath string `json:",omitempty"`
+}
+
+// daemonProject is the cached state of one project. It is thrown away
+// once any package directory it was read from changes.
+type daemonProject struct {
+	deps         Dependencies
+	cdeps        []*CanticleDependency
+	fingerprints map[string]string
+}
+
+// DaemonService answers queries about projects over JSON-RPC for cant
+// daemon, keeping the packages read and dependencies resolved for
+// each project between queries.
+type DaemonService struct {
+	client   *Client
+	ctx      context.Context
+	mu       sync.Mutex
+	projects map[string]*daemonProject
+}
+
+// NewDaemonService returns a DaemonService using client. Queries are
+// cut short once ctx is done.
+func NewDaemonService(ctx context.Context, client *Client) *DaemonService {
+	return &DaemonService{client: client, ctx: ctx, projects: make(map[string]*daemonProject)}
+}
+
+// fingerprints returns the DirFingerprint of the directories in the
+// gopath of every package in deps, and of path.
+func (ds *DaemonService) fingerprints(path string, deps Dependencies) map[string]string {
+	dirs := []string{path}
+	for importPath := range deps {
+		dirs = append(dirs, PackageSource(ds.client.Gopath(), importPath))
+	}
+	fingerprints := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		// Packages missing on disk fingerprint as empty, so
+		// fetching them invalidates the project
+		fingerprints[dir], _ = DirFingerprint(dir)
+	}
+	return fingerprints
+}
+
+// project returns the cached state of the project at path, reading
+// it again if it is stale. If resolve is true its dependencies are
+// resolved too. The mu lock must be held.
+func (ds *DaemonService) project(path string, resolve bool) (*daemonProject, error) {
+	if path == "" {
+		return nil, errors.New("cant query daemon without a project path")
+	}
+	path = filepath.Clean(path)
+	p := ds.projects[path]
+	if p != nil {
+		for dir, fingerprint := range p.fingerprints {
+			if current, _ := DirFingerprint(dir); current != fingerprint {
+				LogVerboseContext(ds.ctx, "Project %s is stale, %s changed", path, dir)
+				p = nil
+				break
+			}
+		}
+	}
+	if p != nil && (p.cdeps != nil || !resolve) {
+		return p, nil
+	}
+	var (
+		deps  Dependencies
+		cdeps []*CanticleDependency
+		err   error
+	)
+	if resolve {
+		defer ds.client.verbose()()
+		deps, cdeps, err = ds.client.save().ResolveProject(ds.client.context(ds.ctx), ds.client.Gopath(), path)
+	} else {
+		deps, err = ds.client.ReadDeps(ds.ctx, path)
+	}
+	if err != nil {
+		delete(ds.projects, path)
+		return nil, err
+	}
+	p = &daemonProject{deps: deps, cdeps: cdeps, fingerprints: ds.fingerprints(path, deps)}
+	ds.projects[path] = p
+	return p, nil
+}
+
+// Resolve replies with the dependencies cant save would save for the
+// project.
+func (ds *DaemonService) Resolve(args *DaemonArgs, reply *[]*CanticleDependency) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	p, err := ds.project(args.Path, true)
+	if err != nil {
+		return err
+	}
+	*reply = p.cdeps
+	return nil
+}
+
+// Graph replies with the remote imports of every package of the
+// project and its dependencies.
+func (ds *DaemonService) Graph(args *DaemonArgs, reply *map[string][]string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	p, err := ds.project(args.Path, false)
+	if err != nil {
+		return err
+	}
+	*reply = p.deps.Graph()
+	return nil
+}
+
+// Why replies with the shortest chains of imports from the packages
+// of the project to args.ImportPath, or the packages under it, as cant
+// why does.
+func (ds *DaemonService) Why(args *DaemonArgs, reply *[][]string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	p, err := ds.project(args.Path, false)
+	if err != nil {
+		return err
+	}
+	pkg, err := PackageName(ds.client.Gopath(), filepath.Clean(args.Path))
+	if err != nil {
+		return err
+	}
+	chains, err := WhyChains(ds.ctx, p.deps, p.deps.PackagesUnder(pkg), args.ImportPath)
+	if err != nil {
+		return err
+	}
+	*reply = chains
+	return nil
+}
+
+// Status replies with the revision on disk of every dependency saved
+// in the Canticle file of the project.
+func (ds *DaemonService) Status(args *DaemonArgs, reply *[]*DepStatus) error {
+	statuses, err := DependencyStatuses(ds.ctx, ds.client.Gopath(), args.Path)
+	if err != nil {
+		return err
+	}
+	*reply = statuses
+	return nil
+}
+
+// Invalidate drops the cached state of the project, or of every
+// project if args.Path is empty.
+func (ds *DaemonService) Invalidate(args *DaemonArgs, reply *bool) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if args.Path == "" {
+		ds.projects = make(map[string]*daemonProject)
+	} else {
+		delete(ds.projects, filepath.Clean(args.Path))
+	}
+	*reply = true
+	return nil
+}
+
+// ServeDaemon serves ds as the Canticle JSON-RPC service to the
+// connections accepted from l until ctx is done.
+func ServeDaemon(ctx context.Context, l net.Listener, ds *DaemonService) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Canticle", ds); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// DialDaemon returns a client of the cant daemon listening on socket.
+// Call its methods as Canticle.Resolve, Canticle.Status and so on.
+func DialDaemon(socket string) (*rpc.Client, error) {
+	return jsonrpc.Dial("unix", socket)
+}
+
+type Daemon struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Socket  string
+}
+
+func NewDaemon() *Daemon {
+	f := flag.NewFlagSet("daemon", flag.ExitOnError)
+	d := &Daemon{flags: f}
+	f.BoolVar(&d.Verbose, "v", false, "Be verbose when getting stuff")
+	f.StringVar(&d.Socket, "socket", "", "Listen on this unix socket instead of ~/.canticle/daemon.sock")
+	return d
+}
+
+var daemon = NewDaemon()
+
+var DaemonCommand = &Command{
+	Name:             "daemon",
+	UsageLine:        "daemon [-v] [-socket <file>]",
+	ShortDescription: "Answer queries about dependencies over a local socket.",
+	LongDescription: `The daemon command serves JSON-RPC 1.0 on a unix socket, $CANTICLE_DAEMON_SOCKET or ~/.canticle/daemon.sock, so editors and other tools can query projects without running cant and walking every package each time. The packages read and dependencies resolved for a project are kept until a directory they were read from changes.
+
+Each method takes {"Path": "<project dir>"} as its only parameter:
+
+Canticle.Resolve replies with the dependencies cant save would save.
+
+Canticle.Status replies with the pinned and on disk revision of every dependency of the Canticle file.
+
+Canticle.Graph replies with the remote imports of every package.
+
+Canticle.Why, also given "ImportPath", replies with the shortest chain of imports from each package of the project to the package or the packages under it.
+
+Canticle.Invalidate drops what is kept for the project, or for every project if Path is empty.
+
+For example: {"method": "Canticle.Status", "params": [{"Path": "/home/me/go/src/example.com/project"}], "id": 1}
+
+Specify -socket to listen on another socket.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: daemon.flags,
+	Cmd:   daemon,
+}
+
+// Run the daemon command.
+func (d *Daemon) Run(ctx context.Context, args []string) {
+	if d.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	socket := d.Socket
+	if socket == "" {
+		var err error
+		if socket, err = DaemonSocket(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := d.Serve(ctx, socket); err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+}
+
+// Serve listens on socket and serves a DaemonService until ctx is
+// done. A socket left by a daemon no longer running is replaced.
+func (d *Daemon) Serve(ctx context.Context, socket string) error {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("cant start daemon, one is already listening on %s", socket)
+	}
+	os.Remove(socket)
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return fmt.Errorf("cant create dir for socket %s %s", socket, err.Error())
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("cant listen on %s %s", socket, err.Error())
+	}
+	defer os.Remove(socket)
+	client, err := NewClient(Options{})
+	if err != nil {
+		return err
+	}
+	LogInfoContext(ctx, "Listening on %s", socket)
+	return ServeDaemon(ctx, l, NewDaemonService(ctx, client))
+}
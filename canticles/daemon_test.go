@@ -0,0 +1,137 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDaemonServiceWhy(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	gopath := filepath.Join(testHome, "gopath")
+	project := PackageSource(gopath, "example.com/p")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(Options{Gopath: gopath})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err.Error())
+	}
+	ds := NewDaemonService(context.Background(), client)
+
+	deps := NewDependencies()
+	add := func(pkg string, imports ...string) {
+		dep := NewDependency(pkg)
+		dep.Imports.Add(imports...)
+		deps.AddDependency(dep)
+		for _, i := range imports {
+			imported := NewDependency(i)
+			imported.ImportedFrom.Add(pkg)
+			deps.AddDependency(imported)
+		}
+	}
+	add("example.com/p", "dep.com/a")
+	add("example.com/p/sub", "dep.com/b")
+	add("dep.com/a", "dep.com/b/x")
+	add("dep.com/b/x")
+	ds.projects[project] = &daemonProject{deps: deps, fingerprints: ds.fingerprints(project, deps)}
+
+	// The daemon answers as cant why does
+	var chains [][]string
+	if err := ds.Why(&DaemonArgs{Path: project, ImportPath: "dep.com/b"}, &chains); err != nil {
+		t.Fatalf("Error calling why: %s", err.Error())
+	}
+	expected, _ := WhyChains(context.Background(), deps, deps.PackagesUnder("example.com/p"), "dep.com/b")
+	if !reflect.DeepEqual(chains, expected) || !reflect.DeepEqual(chains, [][]string{{"example.com/p/sub", "dep.com/b"}}) {
+		t.Errorf("Expected chains %v got %v", expected, chains)
+	}
+	if err := ds.Why(&DaemonArgs{Path: project, ImportPath: "dep.com/c"}, &chains); err != nil || len(chains) != 0 {
+		t.Errorf("Expected no chains to an unimported package got %v %v", chains, err)
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	gopath := filepath.Join(testHome, "gopath")
+	project := PackageSource(gopath, "example.com/p")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal([]*CanticleDependency{{Root: "dep.com/missing", Revision: "1"}})
+	if err := ioutil.WriteFile(DependencyFile(project), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(Options{Gopath: gopath})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := NewDaemonService(ctx, client)
+	socket := filepath.Join(testHome, "daemon.sock")
+	d := NewDaemon()
+	served := make(chan error)
+	go func() { served <- d.Serve(ctx, socket) }()
+	var conn *rpc.Client
+	for i := 0; i < 100 && conn == nil; i++ {
+		if conn, err = DialDaemon(socket); err != nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if conn == nil {
+		t.Fatalf("Error dialing daemon: %s", err.Error())
+	}
+	defer conn.Close()
+	var statuses []*DepStatus
+	if err := conn.Call("Canticle.Status", &DaemonArgs{Path: project}, &statuses); err != nil {
+		t.Fatalf("Error calling status: %s", err.Error())
+	}
+	if len(statuses) != 1 || !statuses[0].Missing || statuses[0].Revision != "1" {
+		t.Errorf("Expected missing dependency status got %+v", statuses)
+	}
+	if err := d.Serve(ctx, socket); err == nil {
+		t.Errorf("Expected error serving a second daemon on the socket")
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Expected daemon to stop cleanly got %s", err.Error())
+	}
+	if _, err := os.Stat(socket); err == nil {
+		t.Errorf("Expected socket removed once stopped")
+	}
+
+	// Projects are served from the cache until a directory changes
+	deps := NewDependencies()
+	deps.AddDeps("example.com/p")
+	ds.projects[project] = &daemonProject{deps: deps, fingerprints: ds.fingerprints(project, deps)}
+	var graph map[string][]string
+	if err := ds.Graph(&DaemonArgs{Path: project}, &graph); err != nil || len(graph) != 1 {
+		t.Errorf("Expected cached graph got %v %v", graph, err)
+	}
+	cached := ds.projects[project]
+	if err := ioutil.WriteFile(filepath.Join(project, "p.go"), []byte("package p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds.Graph(&DaemonArgs{Path: project}, &graph)
+	if ds.projects[project] == cached {
+		t.Errorf("Expected changed project to be read again")
+	}
+	var ok bool
+	if err := ds.Invalidate(&DaemonArgs{}, &ok); err != nil || len(ds.projects) != 0 {
+		t.Errorf("Expected every project invalidated got %v %v", ds.projects, err)
+	}
+}
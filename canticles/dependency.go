@@ -9,8 +9,30 @@ type Dependency struct {
 	// ImportedFrom is a list of packages which import
 	// this dependency.
 	ImportedFrom StringSet
+	// ImportedAt is the set of file:line positions of the import
+	// statements for this dependency. It is only filled in when
+	// deps are read with Positions.
+	ImportedAt StringSet
 	// Imports is the set of remote imports for this dep.
 	Imports StringSet
+	// PkgConfig is the set of pkg-config packages required
+	// through cgo.
+	PkgConfig StringSet
+	// CgoLibs is the set of libraries linked with -l through cgo.
+	CgoLibs StringSet
+	// Main is true if this is a main package.
+	Main bool
+	// Tool is true if this package is a go:generate tool of the
+	// project.
+	Tool bool
+	// CanonicalPath is the import path declared by the packages
+	// import comment if it differs from ImportPath.
+	CanonicalPath string
+	// Depth is the number of imports between the package a
+	// DependencyLoader was walked from and this one along the
+	// shortest chain, zero for that package. It is not set by
+	// other readers.
+	Depth int
 	// Attempt to read the package caused an error.
 	Err error
 }
@@ -18,7 +40,10 @@ type Dependency struct {
 func NewDependency(importPath string) *Dependency {
 	return &Dependency{
 		ImportedFrom: NewStringSet(),
+		ImportedAt:   NewStringSet(),
 		Imports:      NewStringSet(),
+		PkgConfig:    NewStringSet(),
+		CgoLibs:      NewStringSet(),
 		ImportPath:   importPath,
 	}
 }
@@ -52,9 +77,21 @@ func (d Dependencies) AddDependency(dep *Dependency) {
 		return
 	}
 
-	already.Err = dep.Err
+	// Imports of a package never clear its own error, whatever
+	// order they are added in
+	if dep.Err != nil {
+		already.Err = dep.Err
+	}
+	already.Main = already.Main || dep.Main
+	already.Tool = already.Tool || dep.Tool
+	if dep.CanonicalPath != "" {
+		already.CanonicalPath = dep.CanonicalPath
+	}
 	already.ImportedFrom.Union(dep.ImportedFrom)
+	already.ImportedAt.Union(dep.ImportedAt)
 	already.Imports.Union(dep.Imports)
+	already.PkgConfig.Union(dep.PkgConfig)
+	already.CgoLibs.Union(dep.CgoLibs)
 }
 
 func (d Dependencies) AddDeps(deps ...string) {
@@ -65,6 +102,18 @@ func (d Dependencies) AddDeps(deps ...string) {
 	}
 }
 
+// PackagesUnder returns the sorted import paths of the deps rooted
+// at root.
+func (d Dependencies) PackagesUnder(root string) []string {
+	pkgs := NewStringSet()
+	for importPath := range d {
+		if importPath == root || PathIsChild(root, importPath) {
+			pkgs.Add(importPath)
+		}
+	}
+	return pkgs.Array()
+}
+
 // String will print this out as newline seperated %+v values.
 func (d Dependencies) String() string {
 	str := ""
@@ -82,9 +131,42 @@ type CanticleDependency struct {
 	Root string
 	// Revision is the VCS specific commit id
 	Revision string `json:",omitempty"`
+	// Constraint, if set, is the semantic version constraint the
+	// Revision was resolved from, e.g. "^1.2.0" or ">=2.0, <3.0".
+	// Cant save resolves it to the highest tag satisfying it,
+	// see ParseConstraint.
+	Constraint string `json:",omitempty"`
 	// All means walks this VCS from the root for nonhidden files. This will save and
 	// fetch the subdirs of package.
 	All bool `json:",omitempty"`
+	// PkgConfig lists the pkg-config packages required by cgo
+	// code in this VCS.
+	PkgConfig []string `json:",omitempty"`
+	// CgoLibs lists the libraries linked with -l by cgo code in
+	// this VCS.
+	CgoLibs []string `json:",omitempty"`
+	// Packages lists the import paths used from this VCS when
+	// saved with package granularity. The VCS is still fetched
+	// from Root.
+	Packages []string `json:",omitempty"`
+	// Tools are the go:generate tools installed from this VCS.
+	Tools []string `json:",omitempty"`
+	// Group, if set, is the named group of dependencies this
+	// belongs to, e.g. ToolsGroup. See cant get -group.
+	Group string `json:",omitempty"`
+	// TreeHash, if set, is the TreeHash of the VCS at Revision.
+	// It is verified after fetching.
+	TreeHash string `json:",omitempty"`
+	// Checksum is the sha256, prefixed with TreeHashPrefix, of
+	// the archive at SourcePath. It is required for archive
+	// sources and verified before unpacking.
+	Checksum string `json:",omitempty"`
+	// Annotation, if set, records why Revision was pinned.
+	Annotation *Annotation `json:",omitempty"`
+	// Subpath, if set, is the directory of the repo at SourcePath
+	// holding Root. The repo is fetched once for every dependency
+	// on it and the Subpath linked at Root.
+	Subpath string `json:",omitempty"`
 }
 
 type CanticleDependencies []*CanticleDependency
@@ -1,7 +1,19 @@
 package canticles
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestDependenciesAddDependency(t *testing.T) {
 
 }
+
+func TestDependenciesPackagesUnder(t *testing.T) {
+	deps := NewDependencies()
+	deps.AddDeps("a.com/x", "a.com/x/y", "a.com/xy", "b.com/z")
+	expected := []string{"a.com/x", "a.com/x/y"}
+	if pkgs := deps.PackagesUnder("a.com/x"); !reflect.DeepEqual(expected, pkgs) {
+		t.Errorf("Expected packages %v got %v", expected, pkgs)
+	}
+}
@@ -1,35 +1,289 @@
 package canticles
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 // DepReader works in a particular gopath to read the
 // dependencies of both Canticle and non-Canticle go packages.
 type DepReader struct {
 	Gopath string
+	// Cache, if non nil, is used to avoid listing unchanged
+	// packages.
+	Cache *PackageCache
+	// TagSets are additional build constraints whose files'
+	// imports are included as dependencies.
+	TagSets []BuildTagSet
+	// IgnoredFiles includes the imports of every file excluded
+	// by build constraints, regardless of TagSets.
+	IgnoredFiles bool
+	// ExcludeTests omits the imports of both internal and
+	// external test files.
+	ExcludeTests bool
+	// Scan reads packages with ScanPackage, only using go list
+	// when a package can not be scanned.
+	Scan bool
+	// Generate is the import path of the project whose packages
+	// go:generate tools are read as dependencies. Empty disables
+	// reading tools.
+	Generate string
+	// Positions records the file:line positions of each import,
+	// see ImportPositions.
+	Positions bool
+	// FS is where Canticle files are read from, the DefaultFS if
+	// nil.
+	FS FS
+	// Env are KEY=VALUE enviroment variables, such as the GoEnv of
+	// the project's .canticle.json, go list is run with before
+	// GoEnv.
+	Env []string
+
+	mu         sync.Mutex
+	packages   map[string]*Package
+	systemDeps map[string]*SystemDeps
+	canonical  map[string]string
+	mains      StringSet
+	tools      StringSet
+	positions  map[string]map[string][]string
+}
+
+// IsMain returns true if a package previously read by this reader is
+// a main package.
+func (dr *DepReader) IsMain(importPath string) bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.mains[importPath]
+}
+
+// IsTool returns true if a package is a go:generate tool of a package
+// previously read by this reader.
+func (dr *DepReader) IsTool(importPath string) bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.tools[importPath]
+}
+
+// CanonicalPath returns the import comment path of a package
+// previously read by this reader if it differs from importPath.
+func (dr *DepReader) CanonicalPath(importPath string) string {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.canonical[importPath]
+}
+
+// recordCanonicalPath warns if pkg is checked out at a path other
+// than its import comment and remembers the canonical path.
+func (dr *DepReader) recordCanonicalPath(importPath string, pkg *Package) {
+	canonical := pkg.CanonicalPath()
+	if canonical == "" || canonical == importPath {
+		return
+	}
+	LogWarn("Package %s declares canonical import path %s, builds importing it as %s will fail", importPath, canonical, importPath)
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.canonical == nil {
+		dr.canonical = make(map[string]string)
+	}
+	dr.canonical[importPath] = canonical
+}
+
+// ImportPositions returns the file:line positions at which the
+// package importer, previously read by this reader, imports
+// imported. Positions are only recorded if Positions is set.
+func (dr *DepReader) ImportPositions(importer, imported string) []string {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.positions[importer][imported]
+}
+
+// recordImportPositions parses the import blocks of the go files of
+// pkg read for dependencies.
+func (dr *DepReader) recordImportPositions(importPath string, pkg *Package) error {
+	files := append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+	if !dr.ExcludeTests {
+		files = append(append(files, pkg.TestGoFiles...), pkg.XTestGoFiles...)
+	}
+	if dr.IgnoredFiles || len(dr.TagSets) > 0 {
+		files = append(files, pkg.IgnoredGoFiles...)
+	}
+	positions := make(map[string][]string)
+	for _, name := range files {
+		filePositions, err := FileImportPositions(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			return err
+		}
+		for imp, pos := range filePositions {
+			positions[imp] = append(positions[imp], pos...)
+		}
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.positions == nil {
+		dr.positions = make(map[string]map[string][]string)
+	}
+	dr.positions[importPath] = positions
+	return nil
+}
+
+// SystemDeps returns the system deps of a package previously read by
+// this reader, or nil if it has none.
+func (dr *DepReader) SystemDeps(importPath string) *SystemDeps {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.systemDeps[importPath]
+}
+
+func (dr *DepReader) recordSystemDeps(importPath string, pkg *Package) {
+	sd := pkg.SystemDeps()
+	if sd == nil {
+		return
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.systemDeps == nil {
+		dr.systemDeps = make(map[string]*SystemDeps)
+	}
+	dr.systemDeps[importPath] = sd
+}
+
+// PrefetchPaths loads the go packages for all paths with a single go
+// list call and remembers them so later reads of those paths do not
+// spawn their own go process. An error is only returned once ctx is
+// done, go list errors are logged and each path is then loaded on its
+// own when read.
+func (dr *DepReader) PrefetchPaths(ctx context.Context, paths []string) error {
+	if dr.Scan {
+		return nil
+	}
+	names := make([]string, 0, len(paths))
+	cached := make(map[string]*Package)
+	for _, p := range paths {
+		pname, err := PackageName(dr.Gopath, p)
+		if err != nil || pname == "" {
+			continue
+		}
+		if pkg := dr.cachedPackage(pname); pkg != nil {
+			cached[pname] = pkg
+			continue
+		}
+		names = append(names, pname)
+	}
+	pkgs, err := loadPackages(ctx, dr.Gopath, dr.Env, names...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		LogVerboseContext(ctx, "Error prefetching packages, loading them one at a time %s", err.Error())
+		pkgs = cached
+	}
+	for name, pkg := range pkgs {
+		if dr.Cache != nil {
+			dr.Cache.put(dr.Gopath, dr.Env, name, pkg)
+		}
+	}
+	for name, pkg := range cached {
+		pkgs[name] = pkg
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.packages == nil {
+		dr.packages = make(map[string]*Package, len(pkgs))
+	}
+	for name, pkg := range pkgs {
+		dr.packages[name] = pkg
+	}
+	return nil
+}
+
+func (dr *DepReader) cachedPackage(importPath string) *Package {
+	if dr.Cache == nil {
+		return nil
+	}
+	return dr.Cache.get(dr.Gopath, dr.Env, importPath)
+}
+
+// loadPackage returns a prefetched or cached package if we have one,
+// otherwise LoadPackage is used.
+func (dr *DepReader) loadPackage(ctx context.Context, importPath string) (*Package, error) {
+	dr.mu.Lock()
+	pkg := dr.packages[importPath]
+	delete(dr.packages, importPath)
+	dr.mu.Unlock()
+	if pkg == nil && dr.Scan {
+		pkg = dr.scanPackage(importPath)
+	}
+	if pkg == nil {
+		pkg = dr.cachedPackage(importPath)
+	}
+	if pkg == nil {
+		pkgs, err := loadPackages(ctx, dr.Gopath, dr.Env, importPath)
+		if err != nil {
+			return nil, err
+		}
+		pkg = pkgs[importPath]
+		// Relative paths are listed under a different import path
+		if pkg == nil && len(pkgs) == 1 {
+			for _, p := range pkgs {
+				pkg = p
+			}
+		}
+		if pkg == nil {
+			return nil, fmt.Errorf("go list returned no package for %s", importPath)
+		}
+		if dr.Cache != nil {
+			dr.Cache.put(dr.Gopath, dr.Env, importPath, pkg)
+		}
+	}
+	// Import comment mismatches are errors so check this first
+	dr.recordCanonicalPath(importPath, pkg)
+	if pkg.Error != nil {
+		return nil, pkg.Error
+	}
+	return pkg, nil
+}
+
+// scanPackage returns the package read by ScanPackage, or nil if the
+// package needs go list to report its details.
+func (dr *DepReader) scanPackage(importPath string) *Package {
+	pkg, err := ScanPackage(importPath, dr.Gopath)
+	switch {
+	case err != nil:
+		if e, ok := err.(*PackageError); ok && e.IsNoBuildable() {
+			return &Package{ImportPath: importPath, Error: e}
+		}
+		LogVerbose("Could not scan %s, using go list: %s", importPath, err.Error())
+		return nil
+	case pkg.CanonicalPath() != "":
+		// go list reports the import comment error
+		return nil
+	}
+	return pkg
 }
 
 // ReadCanticleDependencies returns the dependencies listed in the
 // packages Canticle file. Dependencies will never be nil.
 func (dr *DepReader) CanticleDependencies(pkg string) ([]*CanticleDependency, error) {
+	defer StartSpan(PhaseIO, DependencyFile(pkg))()
 	var deps []*CanticleDependency
-	f, err := os.Open(DependencyFile(PackageSource(dr.Gopath, pkg)))
+	file := DependencyFile(PackageSource(dr.Gopath, pkg))
+	b, err := fsOr(dr.FS).ReadFile(file)
 	if err != nil {
 		return deps, err
 	}
-	LogVerbose("Reading canticle file: %s", f.Name())
-	defer f.Close()
-	d := json.NewDecoder(f)
-	if err := d.Decode(&deps); err != nil {
+	LogVerbose("Reading canticle file: %s", file)
+	if err := json.Unmarshal(b, &deps); err != nil {
 		return deps, err
 	}
 	return deps, nil
 }
 
-func (dr *DepReader) AllImports(path string) ([]string, error) {
-	deps, err := dr.AllDeps(path)
+func (dr *DepReader) AllImports(ctx context.Context, path string) ([]string, error) {
+	deps, err := dr.AllDeps(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +296,7 @@ func (dr *DepReader) AllImports(path string) ([]string, error) {
 }
 
 // Read both the go and cant deps of a path.
-func (dr *DepReader) AllDeps(path string) (Dependencies, error) {
+func (dr *DepReader) AllDeps(ctx context.Context, path string) (Dependencies, error) {
 	allDeps := NewDependencies()
 	// We only want to process directories, and ignore files
 	pname, err := PackageName(dr.Gopath, path)
@@ -61,7 +315,7 @@ func (dr *DepReader) AllDeps(path string) (Dependencies, error) {
 	}
 	// If this is a dir attempt to read its deps, ignore if it has
 	// no go files
-	goDeps, err := dr.GoRemoteDependencies(pname)
+	goDeps, err := dr.GoRemoteDependencies(ctx, pname)
 	if err != nil {
 		return allDeps, err
 	}
@@ -70,11 +324,52 @@ func (dr *DepReader) AllDeps(path string) (Dependencies, error) {
 }
 
 // ReadGoRemoteDependencies reads the dependencies for package p listed
-// as imports in *.go files, including tests, and returns the result.
-func (dr *DepReader) GoRemoteDependencies(importPath string) ([]string, error) {
-	pkg, err := LoadPackage(importPath, dr.Gopath)
+// as imports in *.go files, including internal and external tests
+// unless ExcludeTests is set, and returns the result.
+func (dr *DepReader) GoRemoteDependencies(ctx context.Context, importPath string) ([]string, error) {
+	pkg, err := dr.loadPackage(ctx, importPath)
 	if err != nil {
 		return []string{}, err
 	}
-	return pkg.RemoteImports(true), nil
+	dr.recordSystemDeps(importPath, pkg)
+	if dr.Positions {
+		if err := dr.recordImportPositions(importPath, pkg); err != nil {
+			return []string{}, err
+		}
+	}
+	if pkg.Name == "main" {
+		dr.mu.Lock()
+		if dr.mains == nil {
+			dr.mains = NewStringSet()
+		}
+		dr.mains.Add(importPath)
+		dr.mu.Unlock()
+	}
+	imports := pkg.RemoteImports(!dr.ExcludeTests)
+	if dr.Generate != "" && (importPath == dr.Generate || PathIsChild(dr.Generate, importPath)) {
+		tools, err := pkg.GenerateTools()
+		if err != nil {
+			return imports, err
+		}
+		dr.mu.Lock()
+		if dr.tools == nil {
+			dr.tools = NewStringSet()
+		}
+		dr.tools.Add(tools...)
+		dr.mu.Unlock()
+		imports = append(imports, tools...)
+	}
+	var ignoredImports []string
+	switch {
+	case dr.IgnoredFiles:
+		ignoredImports, err = pkg.IgnoredImports(!dr.ExcludeTests)
+	case len(dr.TagSets) > 0:
+		ignoredImports, err = pkg.MatrixImports(dr.TagSets, !dr.ExcludeTests)
+	default:
+		return imports, nil
+	}
+	if err != nil {
+		return imports, err
+	}
+	return append(imports, filterStrings(ignoredImports, IsRemote)...), nil
 }
@@ -1,16 +1,18 @@
 package canticles
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
 
 func TestCanticleDependencies(t *testing.T) {
-	dr := &DepReader{os.ExpandEnv("$GOPATH")}
+	dr := &DepReader{Gopath: os.ExpandEnv("$GOPATH")}
 
 	// Happy path
 	deps, err := dr.CanticleDependencies("github.com/Comcast/Canticle")
@@ -110,7 +112,7 @@ func TestReadAllDeps(t *testing.T) {
 	}
 
 	// Setup all complete, lets read all our Canticle deps
-	dr := &DepReader{dir}
+	dr := &DepReader{Gopath: dir}
 
 	// Happy path
 	result, err := dr.ReadAllCantDeps("canttest")
@@ -174,7 +176,7 @@ func TestReadAllRemoteDeps(t *testing.T) {
 	}
 	//defer os.Remove(dir)
 	// Setup all complete, lets read all our Canticle deps
-	dr := &DepReader{dir}
+	dr := &DepReader{Gopath: dir}
 
 	result, err := dr.ReadAllRemoteDependencies("test.com/cubicle")
 	if err != nil {
@@ -190,7 +192,7 @@ func TestReadAllRemoteDeps(t *testing.T) {
 }
 
 func TestReadRemoteDependencies(t *testing.T) {
-	dr := &DepReader{os.ExpandEnv("$GOPATH")}
+	dr := &DepReader{Gopath: os.ExpandEnv("$GOPATH")}
 
 	// Happy path
 	deps, err := dr.ReadRemoteDependencies("github.com/Comcast/Canticle")
@@ -224,10 +226,10 @@ func TestReadRemoteDependencies(t *testing.T) {
 }
 */
 func TestReadDependencies(t *testing.T) {
-	dr := &DepReader{os.ExpandEnv("$GOPATH")}
+	dr := &DepReader{Gopath: os.ExpandEnv("$GOPATH")}
 
 	// Happy path
-	deps, err := dr.GoRemoteDependencies("github.com/Comcast/Canticle/cant")
+	deps, err := dr.GoRemoteDependencies(context.Background(), "github.com/Comcast/Canticle/cant")
 	if err != nil {
 		t.Errorf("Error reading remotes for valid package %s error: %s", "github.com/Comcast/Canticle", err.Error())
 	}
@@ -248,7 +250,7 @@ func TestReadDependencies(t *testing.T) {
 	}
 
 	// Not so happy path
-	deps, err = dr.GoRemoteDependencies("github.comcast.com/viper-cog/nothere")
+	deps, err = dr.GoRemoteDependencies(context.Background(), "github.comcast.com/viper-cog/nothere")
 	if err == nil {
 		t.Errorf("ReadRemoteDependencies returned nil error loading invalid path")
 	}
@@ -278,7 +280,7 @@ func TestReadDependencies(t *testing.T) {
 
 	// Test ourselves
 	dr.Gopath = testHome
-	deps, err = dr.GoRemoteDependencies("github.com/Comcast/Canticle/cant")
+	deps, err = dr.GoRemoteDependencies(context.Background(), "github.com/Comcast/Canticle/cant")
 	if err != nil {
 		t.Errorf("Error reading remotes for valid package %s error: %s", "github.com/Comcast/Canticle", err.Error())
 	}
@@ -298,3 +300,121 @@ func TestReadDependencies(t *testing.T) {
 		t.Errorf("ReadRemoteDependencies returned %+v expected %+v", deps[1], expected)
 	}
 }
+
+func TestCanonicalPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Could not create tmp directory with err %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := path.Join(dir, "src", "fork.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := "package pkg // import \"canonical.com/pkg\"\n"
+	if err := ioutil.WriteFile(path.Join(pkgDir, "pkg.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dr := &DepReader{Gopath: dir}
+	if _, err := dr.GoRemoteDependencies(context.Background(), "fork.com/pkg"); err == nil {
+		t.Errorf("Expected an error reading package checked out at non canonical path")
+	}
+	if canonical := dr.CanonicalPath("fork.com/pkg"); canonical != "canonical.com/pkg" {
+		t.Errorf("Expected canonical path canonical.com/pkg got %s", canonical)
+	}
+
+	pkg := &Package{ImportPath: "canonical.com/pkg", ImportComment: "canonical.com/pkg"}
+	if canonical := pkg.CanonicalPath(); canonical != "" {
+		t.Errorf("Expected no canonical path for matching import comment got %s", canonical)
+	}
+}
+
+func TestImportPositions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Could not create tmp directory with err %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := path.Join(dir, "src", "test.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := "package pkg\n\nimport (\n\t\"fmt\"\n\t\"dep.com/a\"\n)\n"
+	if err := ioutil.WriteFile(path.Join(pkgDir, "pkg.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dr := &DepReader{Gopath: dir, Scan: true, Positions: true}
+	if _, err := dr.GoRemoteDependencies(context.Background(), "test.com/pkg"); err != nil {
+		t.Fatalf("Error reading deps %s", err.Error())
+	}
+	expected := []string{path.Join(pkgDir, "pkg.go") + ":5"}
+	if positions := dr.ImportPositions("test.com/pkg", "dep.com/a"); !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected import positions %v got %v", expected, positions)
+	}
+}
+
+func TestDepReaderEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Could not create tmp directory with err %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := path.Join(dir, "src", "test.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(pkgDir, "pkg.go"), []byte("package pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := "//go:build canttag\n\npackage pkg\n\nimport _ \"dep.com/tagged\"\n"
+	if err := ioutil.WriteFile(path.Join(pkgDir, "tagged.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := &DepReader{Gopath: dir}
+	deps, err := plain.GoRemoteDependencies(context.Background(), "test.com/pkg")
+	if err != nil {
+		t.Fatalf("Error reading deps %s", err.Error())
+	}
+	if len(deps) != 0 {
+		t.Errorf("Expected no deps without the tag got %v", deps)
+	}
+
+	tagged := &DepReader{Gopath: dir, Env: []string{"GOFLAGS=-tags=canttag"}}
+	deps, err = tagged.GoRemoteDependencies(context.Background(), "test.com/pkg")
+	if err != nil {
+		t.Fatalf("Error reading deps %s", err.Error())
+	}
+	if expected := []string{"dep.com/tagged"}; !reflect.DeepEqual(expected, deps) {
+		t.Errorf("Expected deps %v got %v", expected, deps)
+	}
+	if len(GoEnv) != 0 {
+		t.Errorf("Expected reading with Env to leave GoEnv alone got %v", GoEnv)
+	}
+}
+
+func TestPrefetchPathsErrors(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := PackageSource(testHome, "example.com/p")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "p.go"), []byte("package p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dr := &DepReader{Gopath: testHome, Env: []string{"GOFLAGS=-bogus"}}
+	if err := dr.PrefetchPaths(context.Background(), []string{dir}); err != nil {
+		t.Errorf("Expected go list errors only logged got %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dr.PrefetchPaths(ctx, []string{dir}); err != context.Canceled {
+		t.Errorf("Expected the walk halted once ctx is done got %v", err)
+	}
+}
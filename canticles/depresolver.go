@@ -1,6 +1,7 @@
 package canticles
 
 import (
+	"context"
 	"fmt"
 	"os"
 )
@@ -17,6 +18,14 @@ type DependencySource struct {
 	Sources StringSet
 	// OnDiskSource for this VCS.
 	OnDiskSource string
+	// Constraints are the version constraints specified by the
+	// project and canticle files.
+	Constraints StringSet
+	// Constraint is the project's own version constraint.
+	Constraint string
+	// Resolved is the revision of ResolvedTag, the highest tag
+	// satisfying Constraints, if there are any.
+	Resolved, ResolvedTag string
 	// Deps contained by this VCS system.
 	Deps Dependencies
 	// Root of the pacakges import path (prefix for all dep import paths).
@@ -29,10 +38,11 @@ type DependencySource struct {
 // disk.
 func NewDependencySource(root string) *DependencySource {
 	return &DependencySource{
-		Root:      root,
-		Deps:      NewDependencies(),
-		Revisions: NewStringSet(),
-		Sources:   NewStringSet(),
+		Root:        root,
+		Deps:        NewDependencies(),
+		Revisions:   NewStringSet(),
+		Sources:     NewStringSet(),
+		Constraints: NewStringSet(),
 	}
 }
 
@@ -40,6 +50,7 @@ func NewDependencySource(root string) *DependencySource {
 func (d *DependencySource) AddCantSource(source *CanticleDependency, path string) {
 	d.Revisions.Add(source.Revision)
 	d.Sources.Add(source.SourcePath)
+	d.Constraints.Add(source.Constraint)
 	dep := NewDependency(path)
 	dep.Imports.Add(source.Root)
 	d.Deps.AddDependency(dep)
@@ -97,56 +108,83 @@ type SourcesResolver struct {
 	Resolver          RepoResolver
 	Branches, Sources bool
 	CDepReader        CantDepReader
+	// Saved are the dependencies previously saved for RootPath.
+	// Roots still at their saved revision and source are not
+	// re-resolved, the revisions other Canticle files ask for are
+	// not considered for them.
+	Saved []*CanticleDependency
+	// Constraints are the project's version constraints by root.
+	// Roots with constraints are resolved to the highest tag
+	// satisfying them and those of other Canticle files.
+	Constraints map[string]string
+}
+
+// unchanged returns true if source is at the revision, and if
+// sources are saved the source, it was saved with.
+func (sr *SourcesResolver) unchanged(source *DependencySource) bool {
+	for _, cdep := range sr.Saved {
+		if cdep.Root != source.Root {
+			continue
+		}
+		return cdep.Revision != "" && cdep.Revision == source.OnDiskRevision &&
+			(!sr.Sources || cdep.SourcePath == source.OnDiskSource)
+	}
+	return false
 }
 
 // ResolveSources for everything in deps, no dependency trees will be
-// walked.
-func (sr *SourcesResolver) ResolveSources(deps Dependencies) (*DependencySources, error) {
+// walked. It stops with the error of ctx once ctx is done.
+func (sr *SourcesResolver) ResolveSources(ctx context.Context, deps Dependencies) (*DependencySources, error) {
 	sources := NewDependencySources(len(deps))
 	for _, dep := range deps {
-		LogVerbose("\tFinding source for %s", dep.ImportPath)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		LogVerboseContext(ctx, "\tFinding source for %s", dep.ImportPath)
 		// If we already have a source
 		// for this dep just continue
 		if source := sources.DepSource(dep.ImportPath); source != nil {
-			LogVerbose("\t\tDep already added %s", dep.ImportPath)
+			LogVerboseContext(ctx, "\t\tDep already added %s", dep.ImportPath)
 			source.Deps.AddDependency(dep)
 			continue
 		}
 
 		// Otherwise find the vcs root for it
-		vcs, err := sr.Resolver.ResolveRepo(dep.ImportPath, nil)
+		vcs, err := sr.Resolver.ResolveRepo(ctx, dep.ImportPath, nil)
 		if err != nil {
-			LogWarn("\t\tSkipping dep %+v, %s", dep, err.Error())
+			LogWarnContext(ctx, "\t\tSkipping dep %+v, %s", dep, err.Error())
 			continue
 		}
 
 		root := vcs.GetRoot()
 		rootSrc := PackageSource(sr.Gopath, root)
 		if rootSrc == sr.RootPath || PathIsChild(rootSrc, sr.RootPath) {
-			LogVerbose("\t\tSkipping pkg %s since its vcs is at our save level", sr.RootPath)
+			LogVerboseContext(ctx, "\t\tSkipping pkg %s since its vcs is at our save level", sr.RootPath)
 			continue
 		}
 		source := NewDependencySource(root)
+		source.Constraint = sr.Constraints[root]
+		source.Constraints.Add(source.Constraint)
 
 		var rev string
 		if sr.Branches {
-			rev, err = vcs.GetBranch()
+			rev, err = vcs.GetBranch(ctx)
 			if err != nil {
-				LogWarn("\t\tNo branch from vcs at %s %s", root, err.Error())
+				LogWarnContext(ctx, "\t\tNo branch from vcs at %s %s", root, err.Error())
 			}
 		}
 		if !sr.Branches || err != nil {
-			rev, err = vcs.GetRev()
+			rev, err = vcs.GetRev(ctx)
 			if err != nil {
-				return nil, fmt.Errorf("cant get revision from vcs at %s %s", root, err.Error())
+				return nil, &RevisionError{Root: root, Err: err}
 			}
 		}
 		source.Revisions.Add(rev)
 		source.OnDiskRevision = rev
 
 		if sr.Sources {
-			LogVerbose("\t\tGetting source for VCS: %s", root)
-			vcsSource, err := vcs.GetSource()
+			LogVerboseContext(ctx, "\t\tGetting source for VCS: %s", root)
+			vcsSource, err := vcs.GetSource(ctx)
 			if err != nil {
 				return nil, fmt.Errorf("cant get vcs source from vcs at %s %s", root, err.Error())
 			}
@@ -161,7 +199,7 @@ func (sr *SourcesResolver) ResolveSources(deps Dependencies) (*DependencySources
 	// Resolve sources from importpaths, that is any canticle
 	// files stored in a directory imported by our vcs
 	for _, dep := range deps {
-		if err := sr.resolveCantDeps(sources, dep.ImportPath); err != nil {
+		if err := sr.resolveCantDeps(ctx, sources, dep.ImportPath); err != nil {
 			return sources, err
 		}
 	}
@@ -169,7 +207,16 @@ func (sr *SourcesResolver) ResolveSources(deps Dependencies) (*DependencySources
 	// Resolve any sources from our vcs roots, that is any
 	// canticle files stored at the vcs route of a project.
 	for _, source := range sources.Sources {
-		if err := sr.resolveCantDeps(sources, source.Root); err != nil {
+		if err := sr.resolveCantDeps(ctx, sources, source.Root); err != nil {
+			return sources, err
+		}
+	}
+
+	for _, source := range sources.Sources {
+		if source.Constraints.Size() == 0 {
+			continue
+		}
+		if err := sr.solve(ctx, source); err != nil {
 			return sources, err
 		}
 	}
@@ -177,7 +224,34 @@ func (sr *SourcesResolver) ResolveSources(deps Dependencies) (*DependencySources
 	return sources, nil
 }
 
-func (sr *SourcesResolver) resolveCantDeps(sources *DependencySources, path string) error {
+// solve sets Resolved to the highest tag of the repo of source
+// satisfying its Constraints.
+func (sr *SourcesResolver) solve(ctx context.Context, source *DependencySource) error {
+	vcs, err := sr.Resolver.ResolveRepo(ctx, source.Root, nil)
+	if err != nil {
+		return err
+	}
+	lister, ok := vcs.(TagLister)
+	if !ok {
+		return fmt.Errorf("cant solve version constraints of %s, its tags can not be listed", source.Root)
+	}
+	tags, err := lister.Tags(ctx)
+	if err != nil {
+		return fmt.Errorf("cant list tags of %s %s", source.Root, err.Error())
+	}
+	tag, rev, err := SolveConstraints(tags, source.Constraints.Array())
+	if err != nil {
+		return fmt.Errorf("cant solve version constraints of %s %s", source.Root, err.Error())
+	}
+	LogVerboseContext(ctx, "\t\tResolved %s %v to %s %s", source.Root, source.Constraints.Array(), tag, rev)
+	source.Resolved, source.ResolvedTag = rev, tag
+	if rev != source.OnDiskRevision {
+		LogWarnContext(ctx, "%s resolved to %s %s but %s is on disk, run cant get after saving", source.Root, tag, rev, source.OnDiskRevision)
+	}
+	return nil
+}
+
+func (sr *SourcesResolver) resolveCantDeps(ctx context.Context, sources *DependencySources, path string) error {
 	cdeps, err := sr.CDepReader.CanticleDependencies(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -191,10 +265,14 @@ func (sr *SourcesResolver) resolveCantDeps(sources *DependencySources, path stri
 		if source == nil {
 			continue
 		}
+		if sr.unchanged(source) {
+			LogVerboseContext(ctx, "\t\tNot resolving unchanged %s", source.Root)
+			continue
+		}
 		if !sr.Sources {
 			cdep.SourcePath = ""
 		}
-		LogVerbose("\t\tAdding canticle source %+v", cdep)
+		LogVerboseContext(ctx, "\t\tAdding canticle source %+v", cdep)
 		source.AddCantSource(cdep, path)
 	}
 	return nil
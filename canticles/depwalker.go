@@ -1,32 +1,75 @@
 package canticles
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // PkgReaderFunc takes a given package string and returns all
 // the dependencies for that package. If error is not nil on
 // return the walker halts and returns the error.
-type PkgReaderFunc func(pkg string) ([]string, error)
+type PkgReaderFunc func(ctx context.Context, pkg string) ([]string, error)
 
 // PkgHandlerFunc is called once for each loaded package. If the error
 // ErrorSkip is returned deps or this package are no read. All other
 // non nil errors halt the walker and return the value.
-type PkgHandlerFunc func(pkg string) error
+type PkgHandlerFunc func(ctx context.Context, pkg string) error
+
+// PkgPrefetchFunc is given a batch of packages that are about to be
+// handled so their information may be loaded all at once. Errors
+// returned halt the walker.
+type PkgPrefetchFunc func(ctx context.Context, pkgs []string) error
 
 // ErrorSkip tells a walker to skip loading the deps of this dep.
 var ErrorSkip = errors.New("skip this dep")
 
+// DefaultBatchSize is the number of packages passed to a walkers
+// Prefetch func at once if BatchSize is not set.
+var DefaultBatchSize = 64
+
 // DependencyWalker is used to walker the dependencies of a package.
 // It will walk the dependencies for an import path only once.
 type DependencyWalker struct {
-	nodeQueue   []string
-	visited     map[string]bool
+	nodeQueue  []string
+	visited    map[string]bool
+	prefetched map[string]bool
+	depths     map[string]int
+	edges      map[string][]string
+	// parents holds, for each package, the packages importing
+	// it one depth closer to the package walked from.
+	parents     map[string]StringSet
 	readPackage PkgReaderFunc
 	handleDep   PkgHandlerFunc
+	// Prefetch, if non nil, is called with batches of queued
+	// packages before they are handled.
+	Prefetch PkgPrefetchFunc
+	// BatchSize limits the number of packages passed to Prefetch
+	// at once.
+	BatchSize int
+	// Workers, if greater than one, is the number of packages at
+	// each depth of the walk handled and read concurrently, and
+	// the number of Prefetch batches run at once. The handler,
+	// reader and Prefetch must then be safe for concurrent use.
+	Workers int
+	// Logger, if non nil, is logged to during the walk, including
+	// by the reader, handler and Prefetch.
+	Logger Logger
+	// MaxDepth, if greater than zero, stops the walk that many
+	// imports from the package walked from: 1 handles only its
+	// direct dependencies, 2 their dependencies too and so on.
+	// Packages at MaxDepth are handled but not read.
+	MaxDepth int
+	// DetectCycles makes TraverseDependencies return a CycleError
+	// once the walk is done if the packages read form a cycle.
+	DetectCycles bool
 }
 
 // NewDependencyWalker creates a new dep loader. It uses the
@@ -35,41 +78,113 @@ type DependencyWalker struct {
 func NewDependencyWalker(reader PkgReaderFunc, handler PkgHandlerFunc) *DependencyWalker {
 	return &DependencyWalker{
 		visited:     make(map[string]bool),
+		prefetched:  make(map[string]bool),
+		depths:      make(map[string]int),
+		edges:       make(map[string][]string),
+		parents:     make(map[string]StringSet),
 		handleDep:   handler,
 		readPackage: reader,
 	}
 }
 
+// Depth returns the number of imports between the package walked
+// from and pkg along the shortest chain, and false if pkg was not
+// walked.
+func (dw *DependencyWalker) Depth(pkg string) (int, bool) {
+	depth, ok := dw.depths[pkg]
+	return depth, ok
+}
+
+// ShortestChains returns every shortest chain of imports from the
+// package walked from to pkg, sorted, or nil if pkg was not reached.
+// Each chain starts with the package walked from and ends with pkg.
+func (dw *DependencyWalker) ShortestChains(pkg string) [][]string {
+	if _, ok := dw.depths[pkg]; !ok {
+		return nil
+	}
+	parents := dw.parents[pkg]
+	if len(parents) == 0 {
+		return [][]string{{pkg}}
+	}
+	var chains [][]string
+	for _, parent := range parents.Array() {
+		for _, chain := range dw.ShortestChains(parent) {
+			chains = append(chains, append(chain, pkg))
+		}
+	}
+	return chains
+}
+
+// addChild records that p imports child, giving child its depth if
+// it has none yet. Breadth first, so the first depth seen is the
+// shortest.
+func (dw *DependencyWalker) addChild(p, child string) {
+	depth, ok := dw.depths[child]
+	if !ok {
+		depth = dw.depths[p] + 1
+		dw.depths[child] = depth
+	}
+	if depth == dw.depths[p]+1 {
+		if dw.parents[child] == nil {
+			dw.parents[child] = NewStringSet()
+		}
+		dw.parents[child].Add(p)
+	}
+}
+
+// Cycles returns the cycles among the packages read so far, as
+// FindCycles does. The visited map keeps the walk itself from
+// looping.
+func (dw *DependencyWalker) Cycles() [][]string {
+	return FindCycles(dw.edges)
+}
+
 // TraverseDependencies reads and loads all dependencies of dep. It is
 // a breadth first search. If handler returns the special error
-// ErrorSkip it does not read the deps of this package.
-func (dw *DependencyWalker) TraverseDependencies(pkg string) error {
+// ErrorSkip it does not read the deps of this package. The handler
+// may find the depth of the package it is called for with WalkDepth.
+// The walk stops with the error of ctx once ctx is done.
+func (dw *DependencyWalker) TraverseDependencies(ctx context.Context, pkg string) error {
+	ctx = withLogger(ctx, dw.Logger)
+	if _, ok := dw.depths[pkg]; !ok {
+		dw.depths[pkg] = 0
+	}
+	var err error
+	if dw.Workers > 1 {
+		err = dw.traverseConcurrent(ctx, pkg)
+	} else {
+		err = dw.traverse(ctx, pkg)
+	}
+	if err != nil || !dw.DetectCycles {
+		return err
+	}
+	if cycles := dw.Cycles(); len(cycles) > 0 {
+		return &CycleError{Cycles: cycles}
+	}
+	return nil
+}
+
+// traverse walks the dependencies of pkg one package at a time.
+func (dw *DependencyWalker) traverse(ctx context.Context, pkg string) error {
 	dw.nodeQueue = append(dw.nodeQueue, pkg)
 	for len(dw.nodeQueue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Dequeue and mark loaded
 		p := dw.nodeQueue[0]
 		dw.nodeQueue = dw.nodeQueue[1:]
 		dw.visited[p] = true
-		LogVerbose("Handling pkg: %+v", p)
-
-		// Inform our handler of this package
-		err := dw.handleDep(p)
-		switch {
-		case err == ErrorSkip:
-			continue
-		case err != nil:
+		if err := dw.prefetch(ctx, p); err != nil {
 			return err
 		}
-
-		// Read out our children
-		children, err := dw.readPackage(p)
+		children, err := dw.visit(ctx, pkg, p)
 		if err != nil {
-			return fmt.Errorf("cant read deps of package %s with error %s", pkg, err.Error())
+			return err
 		}
-		sort.Strings(children)
-		LogVerbose("Package %s has children %v", p, children)
-
+		dw.edges[p] = children
 		for _, child := range children {
+			dw.addChild(p, child)
 			if dw.visited[child] {
 				continue
 			}
@@ -80,17 +195,220 @@ func (dw *DependencyWalker) TraverseDependencies(pkg string) error {
 	return nil
 }
 
+// traverseConcurrent walks the dependencies of pkg a depth at a
+// time, handling and reading the packages at each depth with Workers
+// goroutines. The first error, in walk order, is returned.
+func (dw *DependencyWalker) traverseConcurrent(ctx context.Context, pkg string) error {
+	level := []string{pkg}
+	for len(level) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := dw.prefetchLevel(ctx, level); err != nil {
+			return err
+		}
+		for _, p := range level {
+			dw.visited[p] = true
+		}
+
+		children := make([][]string, len(level))
+		errs := make([]error, len(level))
+		work := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < dw.Workers && i < len(level); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range work {
+					children[j], errs[j] = dw.visit(ctx, pkg, level[j])
+				}
+			}()
+		}
+		for i := range level {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+
+		var next []string
+		queued := NewStringSet()
+		for i := range level {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			dw.edges[level[i]] = children[i]
+			for _, child := range children[i] {
+				dw.addChild(level[i], child)
+				if dw.visited[child] || queued[child] {
+					continue
+				}
+				queued.Add(child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// visit informs the handler of p and returns its sorted children. No
+// children are returned if the handler returns ErrorSkip or p is at
+// MaxDepth.
+func (dw *DependencyWalker) visit(ctx context.Context, pkg, p string) ([]string, error) {
+	LogVerboseContext(ctx, "Handling pkg: %+v", p)
+	// Inform our handler of this package
+	depth := dw.depths[p]
+	err := dw.handleDep(withWalkDepth(ctx, depth), p)
+	switch {
+	case err == ErrorSkip:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if dw.MaxDepth > 0 && depth >= dw.MaxDepth {
+		LogVerboseContext(ctx, "Not reading deps of %s at depth %d", p, depth)
+		return nil, nil
+	}
+
+	// Read out our children
+	children, err := dw.readPackage(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("cant read deps of package %s with error %s", pkg, err.Error())
+	}
+	sort.Strings(children)
+	LogVerboseContext(ctx, "Package %s has children %v", p, children)
+	return children, nil
+}
+
+// prefetchLevel calls Prefetch with the packages of level not yet
+// prefetched, split into batches so up to Workers run at once. The
+// first error, in level order, is returned.
+func (dw *DependencyWalker) prefetchLevel(ctx context.Context, level []string) error {
+	if dw.Prefetch == nil {
+		return nil
+	}
+	var pkgs []string
+	for _, p := range level {
+		if !dw.prefetched[p] && !dw.visited[p] {
+			dw.prefetched[p] = true
+			pkgs = append(pkgs, p)
+		}
+	}
+	size := dw.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	// Spread the level over the workers rather than filling
+	// the first batches
+	if spread := (len(pkgs) + dw.Workers - 1) / dw.Workers; spread < size {
+		size = spread
+	}
+	var batches [][]string
+	for len(pkgs) > 0 {
+		n := size
+		if n > len(pkgs) {
+			n = len(pkgs)
+		}
+		batches = append(batches, pkgs[:n])
+		pkgs = pkgs[n:]
+	}
+
+	errs := make([]error, len(batches))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < dw.Workers && i < len(batches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				LogVerboseContext(ctx, "Prefetching %d pkgs", len(batches[j]))
+				errs[j] = dw.Prefetch(ctx, batches[j])
+			}
+		}()
+	}
+	for i := range batches {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefetch calls Prefetch with pkg and the next queued packages if
+// pkg has not already been prefetched.
+func (dw *DependencyWalker) prefetch(ctx context.Context, pkg string) error {
+	if dw.Prefetch == nil || dw.prefetched[pkg] {
+		return nil
+	}
+	size := dw.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	batch := []string{pkg}
+	dw.prefetched[pkg] = true
+	for _, p := range dw.nodeQueue {
+		if len(batch) >= size {
+			break
+		}
+		if dw.prefetched[p] || dw.visited[p] {
+			continue
+		}
+		dw.prefetched[p] = true
+		batch = append(batch, p)
+	}
+	LogVerboseContext(ctx, "Prefetching %d pkgs", len(batch))
+	return dw.Prefetch(ctx, batch)
+}
+
 // A DependencyReader reads the set of deps for a package
-type DependencyReader func(importPath string) (Dependencies, error)
+type DependencyReader func(ctx context.Context, importPath string) (Dependencies, error)
 
 // A DependencyLoader fetches and set the correct revision for a
-// dependency using the specified resolver.
+// dependency using the specified resolver. Each VCS root is fetched
+// at most once however many of its packages are loaded.
 type DependencyLoader struct {
 	deps     Dependencies
 	cdeps    []*CanticleDependency
 	gopath   string
 	resolver RepoResolver
 	readDeps DependencyReader
+	// fetches holds the fetch of each root started, packages of
+	// a root being fetched wait for and share its result.
+	fetches map[string]*rootFetch
+	// mu guards deps and fetches so a DependencyLoader may be
+	// used by a concurrent DependencyWalker.
+	mu sync.Mutex
+	// Logger, if non nil, is logged to while fetching.
+	Logger Logger
+	// FS is where packages are looked for before fetching them.
+	FS FS
+	// VendorDir, if set, is a vendor directory each root fetched
+	// is copied into at the Revision of its CanticleDependency,
+	// replacing any copy there, without VCS metadata or files
+	// matching VendorPrune, see CopyToVendor. The gopath then only
+	// stages the repos, roots already in it are not copied.
+	VendorDir   string
+	VendorPrune []string
+	// Shallow fetches roots at the Revision of their
+	// CanticleDependency without their history, where their VCS
+	// is a ShallowCreator, falling back to fetching their history
+	// if the revision can not be fetched alone.
+	Shallow bool
+	// Workers, if greater than one, is the number of roots
+	// Prefetch fetches at once.
+	Workers int
+}
+
+// A rootFetch is the fetch of a VCS root, err is set before done is
+// closed.
+type rootFetch struct {
+	done chan struct{}
+	err  error
 }
 
 // NewDependencyLoader returns a DependencyLoader initialized with the
@@ -102,6 +420,8 @@ func NewDependencyLoader(resolver RepoResolver, depReader DependencyReader, cdep
 		resolver: resolver,
 		cdeps:    cdeps,
 		gopath:   gopath,
+		fetches:  make(map[string]*rootFetch),
+		FS:       DefaultFS,
 	}
 }
 
@@ -110,61 +430,163 @@ func NewDependencyLoader(resolver RepoResolver, depReader DependencyReader, cdep
 // FetchUpdatePackage will fetch or set the specified path to the version
 // defined by the Dependency or if no version is defined will use
 // the VCS default.
-func (dl *DependencyLoader) FetchUpdatePackage(pkg string) error {
-	LogVerbose("DepLoader handling pkg: %s", pkg)
+func (dl *DependencyLoader) FetchUpdatePackage(ctx context.Context, pkg string) error {
+	ctx = withLogger(ctx, dl.Logger)
+	LogVerboseContext(ctx, "DepLoader handling pkg: %s", pkg)
 	path := PackageSource(dl.gopath, pkg)
 
 	// See if this path is on disk, if so we don't need to fetch anything
 	ondisk := true
-	s, err := os.Stat(path)
+	s, err := dl.FS.Stat(path)
 	switch {
 	case err != nil && os.IsNotExist(err):
 		ondisk = false
 	case err != nil:
-		fmt.Errorf("cant fetch package error when stating import path %s", err.Error())
+		return fmt.Errorf("cant fetch package error when stating import path %s", err.Error())
 	case s != nil && !s.IsDir():
 		return fmt.Errorf("cant fetch pkg for path %s is a file not a directory", path)
 	}
 
 	// Fetch the package
-	LogVerbose("DepLoader check path: %s", path)
+	LogVerboseContext(ctx, "DepLoader check path: %s", path)
 	if !ondisk {
-		// Resolve the vcs using our cdep if available
-		cdep := dl.cdepForPkg(pkg)
-		LogVerbose("Resolving repo for %s ondisk %v path %s", pkg, ondisk, path)
-		vcs, err := dl.resolver.ResolveRepo(pkg, cdep)
-		if err != nil {
-			return fmt.Errorf("%s version control %s", pkg, err.Error())
-		}
-
-		if err := dl.fetchPackage(vcs, cdep); err != nil {
-			return fmt.Errorf("cant fetch package %s %s", pkg, err.Error())
+		if _, err := dl.resolveFetch(ctx, pkg); err != nil {
+			return err
 		}
 	}
 
 	// Load all the deps for this file directly
-	LogVerbose("DepLoader reading deps of path: %s", path)
-	deps, err := dl.readDeps(path)
+	LogVerboseContext(ctx, "DepLoader reading deps of path: %s", path)
+	deps, err := dl.readDeps(ctx, path)
 	if err != nil {
 		return fmt.Errorf("package %s couldn't read deps %s", pkg, err.Error())
 	}
-	LogVerbose("Read package %s deps:\n[\n%+v]", pkg, deps)
+	LogVerboseContext(ctx, "Read package %s deps:\n[\n%+v]", pkg, deps)
 
 	// Setup our deps
+	depth := WalkDepth(ctx)
 	dep := NewDependency(pkg)
 	for _, d := range deps {
 		d.ImportedFrom.Add(pkg)
 	}
-	dl.deps.AddDependencies(deps)
 	for _, pkgDep := range deps {
 		dep.Imports.Add(pkgDep.ImportPath)
 	}
-	LogVerbose("Adding dep %+v\n", dep)
+	LogVerboseContext(ctx, "Adding dep %+v\n", dep)
+	dl.mu.Lock()
+	// Packages are walked breadth first, so the depth they are
+	// first seen at is the shortest
+	for _, d := range deps {
+		if dl.deps[d.ImportPath] == nil {
+			d.Depth = depth + 1
+		}
+	}
+	dl.deps.AddDependencies(deps)
 	dl.deps.AddDependency(dep)
+	dl.deps[pkg].Depth = depth
+	dl.mu.Unlock()
 
 	return nil
 }
 
+// resolveFetch resolves the repo of pkg, using its cdep if available,
+// and fetches it, returning its root.
+func (dl *DependencyLoader) resolveFetch(ctx context.Context, pkg string) (string, error) {
+	cdep := dl.cdepForPkg(pkg)
+	LogVerboseContext(ctx, "Resolving repo for %s", pkg)
+	vcs, err := dl.resolver.ResolveRepo(ctx, pkg, cdep)
+	if err != nil {
+		return pkg, &ResolveError{Path: pkg, Err: err}
+	}
+	Emit(ctx, DepResolved{ImportPath: pkg, Root: vcs.GetRoot()})
+	root := vcs.GetRoot()
+	if root == "" {
+		root = pkg
+	}
+	return root, dl.fetchRoot(ctx, pkg, vcs, cdep)
+}
+
+// Prefetch fetches the roots of pkgs not on disk, Workers at a time,
+// so a DependencyWalker using it as its Prefetch clones the repos of
+// each batch of packages concurrently rather than one at a time as
+// they are handled. The events each fetch logs are held until it is
+// done so those of different repos are not interleaved. Once every
+// fetch is done a FetchErrors is returned with the error of each root
+// which could not be resolved or fetched. It does nothing unless
+// Workers is greater than one.
+func (dl *DependencyLoader) Prefetch(ctx context.Context, pkgs []string) error {
+	if dl.Workers <= 1 {
+		return nil
+	}
+	ctx = withLogger(ctx, dl.Logger)
+	logger := LoggerOf(ctx)
+	// mu guards failed and serializes flushing the logs of
+	// each fetch
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < dl.Workers && i < len(pkgs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pkg := range work {
+				buf := &bufferedLogger{}
+				root, err := dl.prefetchPackage(WithLogger(ctx, buf), pkg)
+				mu.Lock()
+				buf.flush(logger)
+				if err != nil && failed[root] == nil {
+					failed[root] = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, pkg := range pkgs {
+		select {
+		case work <- pkg:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	roots := make([]string, 0, len(failed))
+	for root := range failed {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	errs := make(FetchErrors, len(roots))
+	for i, root := range roots {
+		errs[i] = failed[root]
+	}
+	return errs
+}
+
+// prefetchPackage fetches the root of pkg if pkg is not on disk,
+// returning the root. Packages which can not be stated are left for
+// FetchUpdatePackage to report.
+func (dl *DependencyLoader) prefetchPackage(ctx context.Context, pkg string) (string, error) {
+	if _, err := dl.FS.Stat(PackageSource(dl.gopath, pkg)); !os.IsNotExist(err) {
+		return pkg, nil
+	}
+	return dl.resolveFetch(ctx, pkg)
+}
+
+// Dependencies returns the packages loaded.
+func (dl *DependencyLoader) Dependencies() Dependencies {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.deps
+}
+
 func (dl *DependencyLoader) cdepForPkg(pkg string) *CanticleDependency {
 	for _, dep := range dl.cdeps {
 		if PathIsChild(dep.Root, pkg) {
@@ -175,31 +597,101 @@ func (dl *DependencyLoader) cdepForPkg(pkg string) *CanticleDependency {
 }
 
 // PackagePaths determines the set of import paths for package.
-func (dl *DependencyLoader) PackageImports(pkg string) ([]string, error) {
+func (dl *DependencyLoader) PackageImports(ctx context.Context, pkg string) ([]string, error) {
+	dl.mu.Lock()
 	dep := dl.deps.Dependency(pkg)
+	dl.mu.Unlock()
 	if dep == nil {
 		return []string{}, fmt.Errorf("no dep for %s, should not be requested", pkg)
 	}
 	return dep.Imports.Array(), nil
 }
 
-func (dl *DependencyLoader) setRevision(vcs VCS, dep *CanticleDependency) error {
-	LogVerbose("Setting rev on dep %+v", dep)
-	if err := vcs.SetRev(""); err != nil {
+func (dl *DependencyLoader) setRevision(ctx context.Context, vcs VCS, dep *CanticleDependency) error {
+	LogVerboseContext(ctx, "Setting rev on dep %+v", dep)
+	if err := vcs.SetRev(ctx, ""); err != nil {
 		return fmt.Errorf("failed to set revision because %s", err.Error())
 	}
 	return nil
 }
 
-func (dl *DependencyLoader) fetchPackage(vcs VCS, dep *CanticleDependency) error {
-	LogVerbose("Fetching dep %+v", dep)
-	if err := vcs.Create(""); err != nil {
-		return fmt.Errorf("failed to fetch because %s", err.Error())
+// fetchRoot fetches the root of vcs unless it has already been
+// fetched for another package, in which case that result is
+// returned. A fetch still in progress is waited for.
+func (dl *DependencyLoader) fetchRoot(ctx context.Context, pkg string, vcs VCS, dep *CanticleDependency) error {
+	root := vcs.GetRoot()
+	if root == "" {
+		root = pkg
+	}
+	dl.mu.Lock()
+	fetch := dl.fetches[root]
+	if fetch != nil {
+		dl.mu.Unlock()
+		LogVerboseContext(ctx, "Waiting for fetch of %s for %s", root, pkg)
+		select {
+		case <-fetch.done:
+			return fetch.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	fetch = &rootFetch{done: make(chan struct{})}
+	dl.fetches[root] = fetch
+	dl.mu.Unlock()
+
+	fetch.err = dl.fetchPackage(ctx, root, vcs, dep)
+	close(fetch.done)
+	return fetch.err
+}
+
+// fetchPackage fetches root. Deps with a TreeHash are fetched at their
+// Revision, which the hash is only valid for, and verified. When
+// vendoring, or fetching shallowly, deps are fetched at their
+// Revision, and copied into VendorDir when vendoring.
+func (dl *DependencyLoader) fetchPackage(ctx context.Context, root string, vcs VCS, dep *CanticleDependency) error {
+	LogVerboseContext(ctx, "Fetching dep %+v", dep)
+	rev := ""
+	if dep != nil && (dep.TreeHash != "" || dl.VendorDir != "" || dl.Shallow) {
+		rev = dep.Revision
+	}
+	Emit(ctx, FetchStarted{Root: root})
+	start := time.Now()
+	err := dl.create(ctx, root, vcs, rev)
+	Emit(ctx, FetchFinished{Root: root, Duration: time.Since(start), Err: err})
+	if err != nil {
+		return &FetchError{Root: root, Op: "fetch", Err: err}
+	}
+	if rev != "" {
+		if err := VerifyTreeHash(ctx, dl.gopath, dep); err != nil {
+			return err
+		}
+	}
+	if dl.VendorDir != "" {
+		LogVerboseContext(ctx, "Copying %s into %s", root, dl.VendorDir)
+		return CopyToVendor(dl.gopath, dl.VendorDir, root, dl.VendorPrune)
 	}
 	return nil
 }
 
-type DepReaderFunc func(importPath string) (Dependencies, error)
+// create fetches root at rev, without its history if Shallow is set
+// and vcs can, else with it.
+func (dl *DependencyLoader) create(ctx context.Context, root string, vcs VCS, rev string) error {
+	sc, ok := vcs.(ShallowCreator)
+	if !dl.Shallow || !ok || rev == "" {
+		return vcs.Create(ctx, rev)
+	}
+	err := sc.CreateShallow(ctx, rev)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	LogVerboseContext(ctx, "Cant fetch %s at %s without its history, fetching it all: %s", root, rev, err.Error())
+	if err := os.RemoveAll(PackageSource(dl.gopath, root)); err != nil {
+		return err
+	}
+	return vcs.Create(ctx, rev)
+}
+
+type DepReaderFunc func(ctx context.Context, importPath string) (Dependencies, error)
 
 // DependencySaver is a handler for dependencies that will save all
 // dependencies current revisions. Call Dependencies() to retrieve the
@@ -212,8 +704,57 @@ type DependencySaver struct {
 	// NoRecur contains a list of directories this will not recur
 	// into under root.
 	NoRecur StringSet
+	// Skip contains filepath.Match patterns of directories this
+	// will not recur into under root. Patterns without a slash
+	// match the directory name, others the path relative to root.
+	Skip []string
+	// Ignore are the rules of the projects .canticleignore file,
+	// ignored directories are not recurred into.
+	Ignore IgnoreRules
+	// VCSIgnored, if non nil, returns the paths ignored by the
+	// projects own VCS. It is disabled after its first error.
+	VCSIgnored func(ctx context.Context, paths []string) ([]string, error)
+	// infos holds the FileInfo of subdirectories read while
+	// expanding paths so they need not be stat'd again.
+	infos map[string]os.FileInfo
+	// FS is the filesystem the packages under root are read from.
+	FS FS
+	// Compact bounds memory on very large trees. Packages outside
+	// the project are aggregated into a single Dependency for
+	// their VCS root, importers and imports are recorded by root,
+	// and the imports of each package are only kept until it is
+	// walked. Main, Tool and CanonicalPath are only kept for
+	// project packages.
+	Compact bool
+	// RootOf returns the VCS root of an import path when Compact
+	// is set. It is only called for paths under no root already
+	// seen. If nil, or on error, the path is its own root.
+	RootOf func(ctx context.Context, importPath string) (string, error)
+	// Describe, if non nil, is called with the Dependency of each
+	// package read before it is added, e.g. to fill in its cgo
+	// requirements before it is aggregated.
+	Describe func(dep *Dependency)
+	// Logger, if non nil, is logged to while saving.
+	Logger Logger
+	// project is the import path of root.
+	project string
+	// names interns the import paths of the saved dependencies.
+	names *Interner
+	// roots are the VCS roots seen when Compact is set.
+	roots StringSet
+	// pending holds the imports of packages added but not yet
+	// walked when Compact is set.
+	pending map[string][]string
+	// mu guards deps, infos, VCSIgnored, roots and pending so a
+	// DependencySaver may be used by a concurrent
+	// DependencyWalker.
+	mu sync.Mutex
 }
 
+// DefaultSkipDirs are the directory patterns a DependencySaver skips
+// by default. Like the go tool these are never treated as packages.
+var DefaultSkipDirs = []string{"testdata", "_*"}
+
 // NewDependencySaver builds a new dependencysaver to work in the
 // specified gopath and resolve using the resolverfunc. A
 // DependencySaver should generally only be used once. A
@@ -221,26 +762,195 @@ type DependencySaver struct {
 // if the resolverfunc can handle them. Deps that resolve using ignore
 // will not be saved.
 func NewDependencySaver(reader DepReaderFunc, gopath, root string) *DependencySaver {
+	project, _ := PackageName(gopath, root)
 	return &DependencySaver{
+		project: project,
 		deps:    NewDependencies(),
 		root:    root,
 		read:    reader,
 		gopath:  gopath,
 		NoRecur: NewStringSet(),
+		Skip:    append([]string{}, DefaultSkipDirs...),
+		infos:   make(map[string]os.FileInfo),
+		FS:      DefaultFS,
+		names:   NewInterner(),
+		roots:   NewStringSet(),
+		pending: make(map[string][]string),
 	}
 }
 
+// statPath returns the FileInfo for path read during path
+// expansion, only calling Stat for paths not yet seen.
+func (ds *DependencySaver) statPath(path string) (os.FileInfo, error) {
+	ds.mu.Lock()
+	info, ok := ds.infos[path]
+	delete(ds.infos, path)
+	ds.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+	return ds.FS.Stat(path)
+}
+
+// skipDir returns true if dir matches one of the Skip patterns or is
+// ignored.
+func (ds *DependencySaver) skipDir(dir string) bool {
+	rel, err := filepath.Rel(ds.root, dir)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if ds.Ignore.Match(rel, true) {
+		return true
+	}
+	base := filepath.Base(dir)
+	for _, pattern := range ds.Skip {
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = rel
+		}
+		if match, _ := filepath.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// removeVCSIgnored removes the paths ignored by the projects VCS.
+func (ds *DependencySaver) removeVCSIgnored(ctx context.Context, paths StringSet) {
+	ds.mu.Lock()
+	vcsIgnored := ds.VCSIgnored
+	ds.mu.Unlock()
+	if vcsIgnored == nil || paths.Size() == 0 {
+		return
+	}
+	ignored, err := vcsIgnored(ctx, paths.Array())
+	if err != nil {
+		LogVerboseContext(ctx, "Not checking VCS ignores: %s", err.Error())
+		ds.mu.Lock()
+		ds.VCSIgnored = nil
+		ds.mu.Unlock()
+		return
+	}
+	for _, p := range ignored {
+		LogVerboseContext(ctx, "Skipping VCS ignored dir %s", p)
+		delete(paths, p)
+	}
+}
+
+// addDependency adds dep, and the deps it imports, to the saved
+// dependencies. If Compact is set they are aggregated first.
+func (ds *DependencySaver) addDependency(ctx context.Context, dep *Dependency, imported Dependencies) {
+	if ds.Compact {
+		pkg := dep.ImportPath
+		var imports []string
+		if dep.Err == nil {
+			imports = dep.Imports.Array()
+		}
+		dep = ds.compactDependency(ctx, dep)
+		compacted := NewDependencies()
+		for _, d := range imported {
+			compacted.AddDependency(ds.compactDependency(ctx, d))
+		}
+		imported = compacted
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		if imports != nil {
+			ds.pending[pkg] = imports
+		}
+	} else {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+	}
+	ds.deps.AddDependencies(imported)
+	ds.deps.AddDependency(dep)
+}
+
+// compactDependency returns dep recorded by root. Packages outside
+// the project become their root, as do their importers and imports.
+// Imports crossing an internal boundary are kept as is so they are
+// still reported.
+func (ds *DependencySaver) compactDependency(ctx context.Context, dep *Dependency) *Dependency {
+	key := ds.compactPath(ctx, dep.ImportPath)
+	c := NewDependency(key)
+	c.Err = dep.Err
+	c.PkgConfig = dep.PkgConfig
+	c.CgoLibs = dep.CgoLibs
+	if key == dep.ImportPath {
+		c.Main = dep.Main
+		c.Tool = dep.Tool
+		c.CanonicalPath = dep.CanonicalPath
+	}
+	for imp := range dep.Imports {
+		if InternalImportAllowed(dep.ImportPath, imp) {
+			imp = ds.rootOf(ctx, imp)
+		}
+		if imp != key {
+			c.Imports.Add(imp)
+		}
+	}
+	for importer := range dep.ImportedFrom {
+		if importer = ds.rootOf(ctx, importer); importer != key {
+			c.ImportedFrom.Add(importer)
+		}
+	}
+	return c
+}
+
+// compactPath returns the path importPath is recorded under when
+// Compact is set, itself for project packages and its root
+// otherwise.
+func (ds *DependencySaver) compactPath(ctx context.Context, importPath string) string {
+	if root := ds.rootOf(ctx, importPath); root != ds.project {
+		return root
+	}
+	return importPath
+}
+
+// rootOf returns the project for its packages, otherwise the VCS
+// root of importPath. Roots already seen are reused so RootOf is
+// called about once a root.
+func (ds *DependencySaver) rootOf(ctx context.Context, importPath string) string {
+	if importPath == ds.project || PathIsChild(ds.project, importPath) {
+		return ds.project
+	}
+	ds.mu.Lock()
+	for p := importPath; p != "." && p != "/"; p = path.Dir(p) {
+		if ds.roots[p] {
+			ds.mu.Unlock()
+			return ds.names.Intern(p)
+		}
+	}
+	rootOf := ds.RootOf
+	ds.mu.Unlock()
+	root := importPath
+	if rootOf != nil {
+		r, err := rootOf(ctx, importPath)
+		if err != nil {
+			LogVerboseContext(ctx, "Recording %s as its own root: %s", importPath, err.Error())
+		} else {
+			root = r
+		}
+	}
+	root = ds.names.Intern(root)
+	ds.mu.Lock()
+	ds.roots.Add(root)
+	ds.mu.Unlock()
+	return root
+}
+
 // SavePackageDeps uses the reader to read all 1st order deps of this
 // pkg.
-func (ds *DependencySaver) SavePackageDeps(path string) error {
-	LogVerbose("Examine path %s", path)
+func (ds *DependencySaver) SavePackageDeps(ctx context.Context, path string) error {
+	ctx = withLogger(ctx, ds.Logger)
+	LogVerboseContext(ctx, "Examine path %s", path)
 	pkg, err := PackageName(ds.gopath, path)
 	if err != nil {
 		return fmt.Errorf("Error getting package name for path %s", path)
 	}
 
 	// Check if we can find this package
-	s, err := os.Stat(path)
+	s, err := ds.statPath(path)
 	switch {
 	case s != nil && !s.IsDir():
 		err = fmt.Errorf("cant save deps for path %s is a file not a directory", path)
@@ -250,10 +960,10 @@ func (ds *DependencySaver) SavePackageDeps(path string) error {
 		err = fmt.Errorf("cant save deps for path %s due to %s", path, err.Error())
 	}
 	if err != nil {
-		LogVerbose("Error stating path %s %s", path, err.Error())
-		dep := NewDependency(pkg)
+		LogVerboseContext(ctx, "Error stating path %s %s", path, err.Error())
+		dep := NewDependency(ds.names.Intern(pkg))
 		dep.Err = err
-		ds.deps.AddDependency(dep)
+		ds.addDependency(ctx, dep, nil)
 		return ErrorSkip
 	}
 	// Don't attempt to read the dependencies of the "src" dir...
@@ -265,66 +975,101 @@ func (ds *DependencySaver) SavePackageDeps(path string) error {
 	// nil (this is an empty dir, so we don't want it in our
 	// package setup). If we have any pkgDeps though (from a cant file)
 	// we need this.
-	pkgDeps, err := ds.read(path)
+	pkgDeps, err := ds.read(ctx, path)
 	if len(pkgDeps) == 0 && err != nil {
 		if e, ok := err.(*PackageError); ok {
 			if e.IsNoBuildable() {
-				LogVerbose("Unbuildable pkg")
+				LogVerboseContext(ctx, "Unbuildable pkg")
 				return nil
 			}
 		}
-		LogVerbose("Error reading pkg deps %s %s", pkg, err.Error())
-		dep := NewDependency(pkg)
+		LogVerboseContext(ctx, "Error reading pkg deps %s %s", pkg, err.Error())
+		dep := NewDependency(ds.names.Intern(pkg))
 		dep.Err = fmt.Errorf("cant read deps for package %s %s", pkg, err.Error())
-		ds.deps.AddDependency(dep)
+		ds.addDependency(ctx, dep, nil)
 		return nil
 	}
 
+	pkg = ds.names.Intern(pkg)
 	dep := NewDependency(pkg)
 	for _, d := range pkgDeps {
+		d.ImportPath = ds.names.Intern(d.ImportPath)
 		d.ImportedFrom.Add(pkg)
 	}
-	ds.deps.AddDependencies(pkgDeps)
 	for _, pkgDep := range pkgDeps {
 		dep.Imports.Add(pkgDep.ImportPath)
 	}
-	LogVerbose("Adding dep for pkg %v", dep)
-	ds.deps.AddDependency(dep)
+	if ds.Describe != nil {
+		ds.Describe(dep)
+	}
+	LogVerboseContext(ctx, "Adding dep for pkg %v", dep)
+	ds.addDependency(ctx, dep, pkgDeps)
 	return nil
 }
 
 // PackagePaths returns d all import paths for a pkg, and all subdirs
 // if the pkg is under the root of the passed to the ds at construction.
-func (ds *DependencySaver) PackagePaths(path string) ([]string, error) {
+func (ds *DependencySaver) PackagePaths(ctx context.Context, path string) ([]string, error) {
+	ctx = withLogger(ctx, ds.Logger)
 	paths := NewStringSet()
 	if PathIsChild(ds.root, path) {
-		subdirs, err := VisibleSubDirectories(path)
+		infos, err := VisibleSubDirectoryInfos(ds.FS, path)
 		if err != nil {
 			return []string{}, err
 		}
-		paths.Add(subdirs...)
-		LogVerbose("Package has subdirs %v", subdirs)
+		for _, info := range infos {
+			subdir := filepath.Join(path, info.Name())
+			if ds.skipDir(subdir) {
+				LogVerboseContext(ctx, "Skipping dir %s", subdir)
+				continue
+			}
+			ds.mu.Lock()
+			ds.infos[subdir] = info
+			ds.mu.Unlock()
+			paths.Add(subdir)
+		}
+		ds.removeVCSIgnored(ctx, paths)
+		LogVerboseContext(ctx, "Package has %d subdirs", len(infos))
 	}
 	paths.Difference(ds.NoRecur)
 	pkg, err := PackageName(ds.gopath, path)
 	if err != nil {
-		LogVerbose("Package name error %s", err.Error())
+		LogVerboseContext(ctx, "Package name error %s", err.Error())
 		return []string{}, err
 	}
+	if ds.Compact {
+		ds.mu.Lock()
+		imports, ok := ds.pending[pkg]
+		delete(ds.pending, pkg)
+		ds.mu.Unlock()
+		if !ok {
+			LogVerboseContext(ctx, "Package has no pending imports %s", pkg)
+			return paths.Array(), nil
+		}
+		for _, imp := range imports {
+			paths.Add(PackageSource(ds.gopath, imp))
+		}
+		LogVerboseContext(ctx, "Package has imports %v", imports)
+		return paths.Array(), nil
+	}
+	ds.mu.Lock()
 	dep := ds.deps.Dependency(pkg)
+	ds.mu.Unlock()
 	if dep == nil {
-		LogVerbose("Package has no dep %s", pkg)
+		LogVerboseContext(ctx, "Package has no dep %s", pkg)
 		return paths.Array(), nil
 	}
 	if dep.Err != nil {
-		LogVerbose("Package dep err not nil %s %v", pkg, dep.Err)
-		return []string{}, nil
+		// Directories such as cmd often hold no go files
+		// themselves but still contain packages
+		LogVerboseContext(ctx, "Package dep err not nil %s %v", pkg, dep.Err)
+		return paths.Array(), nil
 	}
 	imports := dep.Imports.Array()
 	for _, imp := range imports {
 		paths.Add(PackageSource(ds.gopath, imp))
 	}
-	LogVerbose("Package has imports %v", imports)
+	LogVerboseContext(ctx, "Package has imports %v", imports)
 	return paths.Array(), nil
 }
 
@@ -1,10 +1,18 @@
 package canticles
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type TestDepRead struct {
@@ -16,7 +24,7 @@ type TestDepReader struct {
 	PackageDeps map[string]TestDepRead
 }
 
-func (tdr *TestDepReader) ReadDependencies(p string) ([]string, error) {
+func (tdr *TestDepReader) ReadDependencies(ctx context.Context, p string) ([]string, error) {
 	r := tdr.PackageDeps[p]
 	return r.Deps, r.Err
 }
@@ -26,7 +34,7 @@ type TestWalker struct {
 	responses []error
 }
 
-func (tw *TestWalker) HandlePackage(pkg string) error {
+func (tw *TestWalker) HandlePackage(ctx context.Context, pkg string) error {
 	tw.calls = append(tw.calls, pkg)
 	if len(tw.responses) > 0 {
 		resp := tw.responses[0]
@@ -83,7 +91,7 @@ func TestTraverseDependencies(t *testing.T) {
 	tw := &TestWalker{}
 	// Run a test with a reader with normal deps
 	dw := NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
-	err := dw.TraverseDependencies("testpkg")
+	err := dw.TraverseDependencies(context.Background(), "testpkg")
 	if err != nil {
 		t.Errorf("Error loading valid pkg %s", err.Error())
 	}
@@ -92,7 +100,7 @@ func TestTraverseDependencies(t *testing.T) {
 	// Run a test with an error from our handler
 	tw = &TestWalker{responses: []error{nil, errTest}}
 	dw = NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
-	err = dw.TraverseDependencies("testpkg")
+	err = dw.TraverseDependencies(context.Background(), "testpkg")
 	if err == nil {
 		t.Errorf("Error not returned from hanlder")
 	}
@@ -101,7 +109,7 @@ func TestTraverseDependencies(t *testing.T) {
 	// Run a test with a skip error from our handler
 	tw = &TestWalker{responses: []error{nil, ErrorSkip}}
 	dw = NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
-	err = dw.TraverseDependencies("testpkg")
+	err = dw.TraverseDependencies(context.Background(), "testpkg")
 	if err != nil {
 		t.Errorf("Error returned when skip form hanlder %s", err.Error())
 	}
@@ -110,7 +118,7 @@ func TestTraverseDependencies(t *testing.T) {
 	// Run a test with a reader with cycled deps, make sure we don't infinite loop
 	tw = &TestWalker{}
 	dw = NewDependencyWalker(CycledReader.ReadDependencies, tw.HandlePackage)
-	err = dw.TraverseDependencies("testpkg")
+	err = dw.TraverseDependencies(context.Background(), "testpkg")
 	if err != nil {
 		t.Errorf("Error loading valid pkg %s", err.Error())
 	}
@@ -119,13 +127,224 @@ func TestTraverseDependencies(t *testing.T) {
 	// Run a test with a non loadable package
 	tw = &TestWalker{}
 	dw = NewDependencyWalker(ChildErrorReader.ReadDependencies, tw.HandlePackage)
-	err = dw.TraverseDependencies("testpkg")
+	err = dw.TraverseDependencies(context.Background(), "testpkg")
 	if err == nil {
 		t.Errorf("Error loading invvalid pkg %s", err.Error())
 	}
 	CheckResult(t, "ChildErrorReader", ChildErrorReaderResult, tw.calls)
 }
 
+type TestPrefetcher struct {
+	sync.Mutex
+	batches [][]string
+	// inFlight and maxInFlight count concurrent Prefetch calls,
+	// each of which takes delay.
+	inFlight, maxInFlight int
+	delay                 time.Duration
+}
+
+func (tp *TestPrefetcher) Prefetch(ctx context.Context, pkgs []string) error {
+	tp.Lock()
+	tp.batches = append(tp.batches, pkgs)
+	tp.inFlight++
+	if tp.inFlight > tp.maxInFlight {
+		tp.maxInFlight = tp.inFlight
+	}
+	tp.Unlock()
+	time.Sleep(tp.delay)
+	tp.Lock()
+	tp.inFlight--
+	tp.Unlock()
+	return nil
+}
+
+var DeepReader = &TestDepReader{
+	map[string]TestDepRead{
+		"testpkg": TestDepRead{[]string{"dep1", "dep2"}, nil},
+		"dep1":    TestDepRead{[]string{"dep3"}, nil},
+		"dep2":    TestDepRead{[]string{"dep1"}, nil},
+		"dep3":    TestDepRead{[]string{"dep4"}, nil},
+		"dep4":    TestDepRead{},
+	},
+}
+
+func TestTraverseDependenciesMaxDepth(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		var mu sync.Mutex
+		handled := make(map[string]int)
+		handler := func(ctx context.Context, pkg string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled[pkg] = WalkDepth(ctx)
+			return nil
+		}
+		read := NewStringSet()
+		reader := func(ctx context.Context, pkg string) ([]string, error) {
+			mu.Lock()
+			read.Add(pkg)
+			mu.Unlock()
+			return DeepReader.ReadDependencies(ctx, pkg)
+		}
+		dw := NewDependencyWalker(reader, handler)
+		dw.Workers = workers
+		dw.MaxDepth = 2
+		if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+			t.Errorf("Error loading valid pkg %s", err.Error())
+		}
+		expected := map[string]int{"testpkg": 0, "dep1": 1, "dep2": 1, "dep3": 2}
+		if !reflect.DeepEqual(expected, handled) {
+			t.Errorf("Expected packages handled at depths %v got %v", expected, handled)
+		}
+		if reads := read.Array(); !reflect.DeepEqual([]string{"dep1", "dep2", "testpkg"}, reads) {
+			t.Errorf("Expected packages at MaxDepth not read got %v", reads)
+		}
+		for pkg, depth := range expected {
+			if d, ok := dw.Depth(pkg); !ok || d != depth {
+				t.Errorf("Expected %s at depth %d got %d %v", pkg, depth, d, ok)
+			}
+		}
+		if _, ok := dw.Depth("dep4"); ok {
+			t.Errorf("Expected dep4 not walked")
+		}
+		chains := [][]string{{"testpkg", "dep1", "dep3"}}
+		if got := dw.ShortestChains("dep3"); !reflect.DeepEqual(chains, got) {
+			t.Errorf("Expected chains %v got %v", chains, got)
+		}
+		if got := dw.ShortestChains("dep4"); got != nil {
+			t.Errorf("Expected no chains to dep4 got %v", got)
+		}
+	}
+}
+
+func TestTraverseDependenciesCycles(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		tw := &TestWalker{}
+		dw := NewDependencyWalker(CycledReader.ReadDependencies, func(ctx context.Context, pkg string) error {
+			return nil
+		})
+		dw.Workers = workers
+		dw.DetectCycles = true
+		err := dw.TraverseDependencies(context.Background(), "testpkg")
+		ce, ok := err.(*CycleError)
+		if !ok {
+			t.Fatalf("Expected CycleError got %v", err)
+		}
+		expected := [][]string{{"dep1", "dep2", "dep1"}}
+		if !reflect.DeepEqual(expected, ce.Cycles) {
+			t.Errorf("Expected cycles %v got %v", expected, ce.Cycles)
+		}
+		if ErrorCode(err) != CodeCycle {
+			t.Errorf("Expected code %s got %s", CodeCycle, ErrorCode(err))
+		}
+
+		dw = NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
+		dw.Workers = workers
+		dw.DetectCycles = true
+		if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+			t.Errorf("Expected no cycles got %v", err)
+		}
+	}
+}
+
+func TestTraverseDependenciesPrefetch(t *testing.T) {
+	tw := &TestWalker{}
+	tp := &TestPrefetcher{}
+	dw := NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
+	dw.Prefetch = tp.Prefetch
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+		t.Errorf("Error loading valid pkg %s", err.Error())
+	}
+	CheckResult(t, "Prefetch", NormalReaderResult, tw.calls)
+	expected := [][]string{{"testpkg"}, {"dep1", "dep2"}}
+	if !reflect.DeepEqual(expected, tp.batches) {
+		t.Errorf("Expected prefetch batches %v got %v", expected, tp.batches)
+	}
+
+	// Limit the size of our batches
+	tw = &TestWalker{}
+	tp = &TestPrefetcher{}
+	dw = NewDependencyWalker(NormalReader.ReadDependencies, tw.HandlePackage)
+	dw.Prefetch = tp.Prefetch
+	dw.BatchSize = 1
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+		t.Errorf("Error loading valid pkg %s", err.Error())
+	}
+	expected = [][]string{{"testpkg"}, {"dep1"}, {"dep2"}}
+	if !reflect.DeepEqual(expected, tp.batches) {
+		t.Errorf("Expected prefetch batches %v got %v", expected, tp.batches)
+	}
+}
+
+func TestTraverseDependenciesConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	handler := func(ctx context.Context, pkg string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, pkg)
+		return nil
+	}
+	for _, reader := range []*TestDepReader{NormalReader, CycledReader} {
+		calls = nil
+		tp := &TestPrefetcher{}
+		dw := NewDependencyWalker(reader.ReadDependencies, handler)
+		dw.Prefetch = tp.Prefetch
+		dw.Workers = 4
+		if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+			t.Errorf("Error loading valid pkg %s", err.Error())
+		}
+		sort.Strings(calls)
+		expected := []string{"dep1", "dep2", "testpkg"}
+		if !reflect.DeepEqual(expected, calls) {
+			t.Errorf("Expected each package handled once %v got %v", expected, calls)
+		}
+		// The level is spread over the workers
+		sort.Sort(byFirst(tp.batches))
+		batches := [][]string{{"dep1"}, {"dep2"}, {"testpkg"}}
+		if !reflect.DeepEqual(batches, tp.batches) {
+			t.Errorf("Expected prefetch batches %v got %v", batches, tp.batches)
+		}
+	}
+
+	tp := &TestPrefetcher{delay: 10 * time.Millisecond}
+	dw := NewDependencyWalker(NormalReader.ReadDependencies, handler)
+	dw.Prefetch = tp.Prefetch
+	dw.Workers = 4
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+		t.Errorf("Error loading valid pkg %s", err.Error())
+	}
+	if tp.maxInFlight != 2 {
+		t.Errorf("Expected both deps prefetched at once got %d at most", tp.maxInFlight)
+	}
+	dw = NewDependencyWalker(NormalReader.ReadDependencies, handler)
+	dw.Prefetch = func(ctx context.Context, pkgs []string) error {
+		if pkgs[0] == "dep2" {
+			return errTest
+		}
+		return nil
+	}
+	dw.Workers = 4
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != errTest {
+		t.Errorf("Expected prefetch error got %v", err)
+	}
+
+	dw = NewDependencyWalker(ChildErrorReader.ReadDependencies, handler)
+	dw.Workers = 4
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err == nil {
+		t.Errorf("Expected error from unreadable child")
+	}
+	dw = NewDependencyWalker(NormalReader.ReadDependencies, func(ctx context.Context, pkg string) error {
+		if pkg == "dep2" {
+			return errTest
+		}
+		return nil
+	})
+	dw.Workers = 4
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != errTest {
+		t.Errorf("Expected handler error got %v", err)
+	}
+}
+
 type TestVCSResolve struct {
 	V   VCS
 	Err error
@@ -135,7 +354,7 @@ type TestResolver struct {
 	ResolvePaths map[string]*TestVCSResolve
 }
 
-func (tr *TestResolver) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (tr *TestResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
 	r := tr.ResolvePaths[importPath]
 	return r.V, r.Err
 }
@@ -149,11 +368,17 @@ type TestDependencyReader struct {
 	PackageDeps map[string]TestDependencyRead
 }
 
-func (tdr *TestDependencyReader) ReadDependencies(p string) (Dependencies, error) {
+func (tdr *TestDependencyReader) ReadDependencies(ctx context.Context, p string) (Dependencies, error) {
 	r := tdr.PackageDeps[p]
 	return r.Deps, r.Err
 }
 
+type byFirst [][]string
+
+func (bf byFirst) Len() int           { return len(bf) }
+func (bf byFirst) Less(i, j int) bool { return bf[i][0] < bf[j][0] }
+func (bf byFirst) Swap(i, j int)      { bf[i], bf[j] = bf[j], bf[i] }
+
 func TestDependencyLoader(t *testing.T) {
 	// Create our
 	testHome, err := ioutil.TempDir("", "cant-test")
@@ -184,7 +409,7 @@ func TestDependencyLoader(t *testing.T) {
 		&CanticleDependency{Root: "pkg2"},
 	}
 	pkg1vcs := &TestVCS{}
-	pkg2vcs := &TestVCS{}
+	pkg2vcs := &TestVCS{Root: "pkg2"}
 	tr := &TestResolver{map[string]*TestVCSResolve{
 		"pkg1":       &TestVCSResolve{pkg1vcs, nil},
 		"pkg1/child": &TestVCSResolve{pkg1vcs, nil},
@@ -192,10 +417,10 @@ func TestDependencyLoader(t *testing.T) {
 		"pkg2/child": &TestVCSResolve{pkg2vcs, nil},
 	}}
 	dl := NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
-	if err := dl.FetchUpdatePackage("pkg1"); err != nil {
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1"); err != nil {
 		t.Errorf("Error fetching pkg1: %s", err.Error())
 	}
-	pkgImports, err := dl.PackageImports("pkg1")
+	pkgImports, err := dl.PackageImports(context.Background(), "pkg1")
 	if err != nil {
 		t.Errorf("Error getting imports for pkg1: %s", err.Error())
 	}
@@ -205,10 +430,10 @@ func TestDependencyLoader(t *testing.T) {
 	if pkg1vcs.Created != 0 {
 		t.Errorf("Expected pkg1vcs to have no creates: %d", pkg1vcs.Created)
 	}
-	if err := dl.FetchUpdatePackage("pkg1/child"); err != nil {
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1/child"); err != nil {
 		t.Errorf("Error fetching pkg1: %s", err.Error())
 	}
-	pkgImports, err = dl.PackageImports("pkg1/child")
+	pkgImports, err = dl.PackageImports(context.Background(), "pkg1/child")
 	if err != nil {
 		t.Errorf("Error getting imports for pkg1: %s", err.Error())
 	}
@@ -219,10 +444,10 @@ func TestDependencyLoader(t *testing.T) {
 		t.Errorf("Expected pkg1vcs to have no creates: %d", pkg1vcs.Created)
 	}
 
-	if err := dl.FetchUpdatePackage("pkg2/child"); err != nil {
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg2/child"); err != nil {
 		t.Errorf("Error fetching pkg2: %s", err.Error())
 	}
-	pkgImports, err = dl.PackageImports("pkg2/child")
+	pkgImports, err = dl.PackageImports(context.Background(), "pkg2/child")
 	if err != nil {
 		t.Errorf("Error getting imports for pkg2: %s", err.Error())
 	}
@@ -233,4 +458,558 @@ func TestDependencyLoader(t *testing.T) {
 		t.Errorf("Expected pkg2vcs to have 1 create: %d", pkg2vcs.Created)
 	}
 
+	// Another package of the root is not fetched again
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg2"); err != nil {
+		t.Errorf("Error fetching pkg2: %s", err.Error())
+	}
+	if pkg2vcs.Created != 1 {
+		t.Errorf("Expected pkg2vcs to have 1 create: %d", pkg2vcs.Created)
+	}
+}
+
+func TestDependencyLoaderDepth(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	if err := os.MkdirAll(path.Join(testHome, "src", "pkg1", "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	deps := &TestDependencyReader{
+		map[string]TestDependencyRead{
+			testHome + "/src/" + "pkg1":       TestDependencyRead{map[string]*Dependency{"pkg1/child": NewDependency("pkg1/child")}, nil},
+			testHome + "/src/" + "pkg1/child": TestDependencyRead{map[string]*Dependency{"pkg2/child": NewDependency("pkg2/child")}, nil},
+		},
+	}
+	tr := &TestResolver{map[string]*TestVCSResolve{}}
+	dl := NewDependencyLoader(tr, deps.ReadDependencies, nil, testHome)
+	dw := NewDependencyWalker(dl.PackageImports, dl.FetchUpdatePackage)
+	dw.MaxDepth = 1
+	if err := dw.TraverseDependencies(context.Background(), "pkg1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"pkg1": 0, "pkg1/child": 1, "pkg2/child": 2}
+	got := make(map[string]int)
+	for importPath, dep := range dl.Dependencies() {
+		got[importPath] = dep.Depth
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected dependency depths %v got %v", expected, got)
+	}
+}
+
+// writingVCS writes Content to the root of its repo when created.
+type writingVCS struct {
+	*TestVCS
+	Dir     string
+	Content string
+}
+
+func (v *writingVCS) Create(ctx context.Context, rev string) error {
+	if err := os.MkdirAll(v.Dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(v.Dir, "a.go"), []byte(v.Content), 0644); err != nil {
+		return err
+	}
+	return v.TestVCS.Create(ctx, rev)
+}
+
+func TestDependencyLoaderTreeHash(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := path.Join(testHome, "src", "pkg1")
+	deps := &TestDependencyReader{
+		map[string]TestDependencyRead{
+			dir: TestDependencyRead{NewDependencies(), nil},
+		},
+	}
+	v := &writingVCS{TestVCS: &TestVCS{Root: "pkg1"}, Dir: dir, Content: "package pkg1\n"}
+	if err := v.Create(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := TreeHash(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir)
+
+	cdeps := []*CanticleDependency{{Root: "pkg1", Revision: "abc", TreeHash: hash}}
+	tr := &TestResolver{map[string]*TestVCSResolve{"pkg1": &TestVCSResolve{v, nil}}}
+	dl := NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1"); err != nil {
+		t.Errorf("Expected matching tree to verify got %s", err.Error())
+	}
+	if v.Rev != "abc" {
+		t.Errorf("Expected hashed dep fetched at its revision got %s", v.Rev)
+	}
+
+	os.RemoveAll(dir)
+	v.Content = "package tampered\n"
+	dl = NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
+	err = dl.FetchUpdatePackage(context.Background(), "pkg1")
+	if ErrorCode(err) != CodeIntegrity {
+		t.Errorf("Expected integrity error got %v", err)
+	}
+}
+
+func TestDependencyLoaderVendorDir(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := path.Join(testHome, "src", "pkg1")
+	deps := &TestDependencyReader{
+		map[string]TestDependencyRead{
+			dir: TestDependencyRead{NewDependencies(), nil},
+		},
+	}
+	v := &writingVCS{TestVCS: &TestVCS{Root: "pkg1"}, Dir: dir, Content: "package pkg1\n"}
+	cdeps := []*CanticleDependency{{Root: "pkg1", Revision: "abc"}}
+	tr := &TestResolver{map[string]*TestVCSResolve{"pkg1": &TestVCSResolve{v, nil}}}
+	dl := NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
+	dl.VendorDir = path.Join(testHome, "project", "vendor")
+	dl.VendorPrune = []string{"tests"}
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1"); err != nil {
+		t.Fatalf("Error vendoring pkg1 %s", err.Error())
+	}
+	if v.Rev != "abc" {
+		t.Errorf("Expected vendored dep fetched at its revision got %s", v.Rev)
+	}
+	if b, err := ioutil.ReadFile(path.Join(dl.VendorDir, "pkg1", "a.go")); err != nil || string(b) != v.Content {
+		t.Errorf("Expected pkg1 copied into vendor got %s %v", b, err)
+	}
+}
+
+// A shallowVCS is a writingVCS which can fetch shallowly, failing
+// with Err if set.
+type shallowVCS struct {
+	*writingVCS
+	Err     error
+	Shallow int
+}
+
+func (v *shallowVCS) CreateShallow(ctx context.Context, rev string) error {
+	v.Shallow++
+	if err := os.MkdirAll(v.Dir, 0755); err != nil {
+		return err
+	}
+	if v.Err != nil {
+		// Leave a partial fetch behind
+		return v.Err
+	}
+	return v.writingVCS.Create(ctx, rev)
+}
+
+func TestDependencyLoaderShallow(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := path.Join(testHome, "src", "pkg1")
+	deps := &TestDependencyReader{
+		map[string]TestDependencyRead{
+			dir: TestDependencyRead{NewDependencies(), nil},
+		},
+	}
+	v := &shallowVCS{writingVCS: &writingVCS{TestVCS: &TestVCS{Root: "pkg1"}, Dir: dir, Content: "package pkg1\n"}}
+	cdeps := []*CanticleDependency{{Root: "pkg1", Revision: "abc"}}
+	tr := &TestResolver{map[string]*TestVCSResolve{"pkg1": &TestVCSResolve{v, nil}}}
+	dl := NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
+	dl.Shallow = true
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1"); err != nil {
+		t.Fatalf("Error fetching pkg1 shallowly %s", err.Error())
+	}
+	if v.Shallow != 1 || v.Created != 1 || v.Rev != "abc" {
+		t.Errorf("Expected pkg1 fetched shallowly at abc got %d %d %s", v.Shallow, v.Created, v.Rev)
+	}
+
+	os.RemoveAll(dir)
+	v.Shallow, v.Created, v.Err = 0, 0, errTest
+	dl = NewDependencyLoader(tr, deps.ReadDependencies, cdeps, testHome)
+	dl.Shallow = true
+	if err := dl.FetchUpdatePackage(context.Background(), "pkg1"); err != nil {
+		t.Fatalf("Expected pkg1 fetched with its history got %s", err.Error())
+	}
+	if v.Shallow != 1 || v.Created != 1 || v.Rev != "abc" {
+		t.Errorf("Expected pkg1 fetched at abc after the shallow fetch failed got %d %d %s", v.Shallow, v.Created, v.Rev)
+	}
+}
+
+func TestDependencyLoaderConcurrentFetch(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgs := []string{"pkg1", "pkg1/a", "pkg1/b", "pkg1/c"}
+	deps := &TestDependencyReader{map[string]TestDependencyRead{}}
+	tr := &TestResolver{map[string]*TestVCSResolve{}}
+	pkg1vcs := &blockingVCS{TestVCS: TestVCS{Root: "pkg1", Err: errTest}, release: make(chan struct{})}
+	for _, pkg := range pkgs {
+		deps.PackageDeps[PackageSource(testHome, pkg)] = TestDependencyRead{NewDependencies(), nil}
+		tr.ResolvePaths[pkg] = &TestVCSResolve{pkg1vcs, nil}
+	}
+	dl := NewDependencyLoader(tr, deps.ReadDependencies, nil, testHome)
+	errs := make(chan error, len(pkgs))
+	for _, pkg := range pkgs {
+		go func(pkg string) {
+			errs <- dl.FetchUpdatePackage(context.Background(), pkg)
+		}(pkg)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(pkg1vcs.release)
+	for range pkgs {
+		if err := <-errs; err == nil {
+			t.Errorf("Expected every package to share the fetch error")
+		}
+	}
+	if pkg1vcs.Created != 1 {
+		t.Errorf("Expected pkg1 fetched once got %d", pkg1vcs.Created)
+	}
+}
+
+// A barrierVCS is a TestVCS whose Create waits for the Create of every
+// other barrierVCS sharing started, failing if it is called alone.
+type barrierVCS struct {
+	TestVCS
+	Dir     string
+	started *sync.WaitGroup
+}
+
+func (bv *barrierVCS) Create(ctx context.Context, rev string) error {
+	LogVerboseContext(ctx, "started %s", bv.Root)
+	bv.started.Done()
+	all := make(chan struct{})
+	go func() {
+		bv.started.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+	case <-time.After(time.Second):
+		return errors.New("fetched alone")
+	}
+	LogVerboseContext(ctx, "finished %s", bv.Root)
+	if err := os.MkdirAll(bv.Dir, 0755); err != nil {
+		return err
+	}
+	return bv.TestVCS.Create(ctx, rev)
+}
+
+func TestDependencyLoaderPrefetch(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	Verbose = true
+	defer func() { Verbose = false }()
+
+	started := &sync.WaitGroup{}
+	tr := &TestResolver{map[string]*TestVCSResolve{}}
+	for _, root := range []string{"pkg1", "pkg2", "pkg3"} {
+		v := &barrierVCS{TestVCS: TestVCS{Root: root}, Dir: PackageSource(testHome, root), started: started}
+		if root != "pkg1" {
+			v.Err = errTest
+		}
+		started.Add(1)
+		tr.ResolvePaths[root] = &TestVCSResolve{v, nil}
+	}
+	tr.ResolvePaths["pkg2/a"] = tr.ResolvePaths["pkg2"]
+	var mu sync.Mutex
+	var logged []string
+	dl := NewDependencyLoader(tr, nil, nil, testHome)
+	dl.Workers = 4
+	dl.Logger = LoggerFunc(func(ctx context.Context, level, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(msg, "started") || strings.HasPrefix(msg, "finished") {
+			logged = append(logged, msg)
+		}
+	})
+
+	err = dl.Prefetch(context.Background(), []string{"pkg1", "pkg2", "pkg2/a", "pkg3"})
+	errs, ok := err.(FetchErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected an error for each of pkg2 and pkg3 got %v", err)
+	}
+	if fe, ok := errs[0].(*FetchError); !ok || fe.Root != "pkg2" || !errors.Is(err, errTest) {
+		t.Errorf("Expected pkg2 fetch error first got %v", errs[0])
+	}
+	if ErrorCode(err) != CodeFetch {
+		t.Errorf("Expected fetch code got %s", ErrorCode(err))
+	}
+	if _, err := os.Stat(PackageSource(testHome, "pkg1")); err != nil {
+		t.Errorf("Expected pkg1 fetched got %s", err.Error())
+	}
+	if len(logged) != 6 {
+		t.Fatalf("Expected the start and finish of 3 fetches logged got %v", logged)
+	}
+	for i := 0; i < len(logged); i += 2 {
+		if root := strings.TrimPrefix(logged[i], "started "); logged[i+1] != "finished "+root {
+			t.Errorf("Expected the logs of each fetch together got %v", logged)
+		}
+	}
+}
+
+// A blockingVCS is a TestVCS whose Create waits for release.
+type blockingVCS struct {
+	TestVCS
+	release chan struct{}
+}
+
+func (bv *blockingVCS) Create(ctx context.Context, rev string) error {
+	<-bv.release
+	return bv.TestVCS.Create(ctx, rev)
+}
+
+func TestDependencySaverSkip(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	root := PackageSource(testHome, "test.com/project")
+	dirs := []string{"pkg", "testdata", "_scratch", ".hidden", "gen", "cmd/tool", "cmd/gen"}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds := NewDependencySaver(nil, testHome, root)
+	ds.Skip = append(ds.Skip, "cmd/gen")
+	paths, err := ds.PackagePaths(context.Background(), root)
+	if err != nil {
+		t.Fatalf("Error getting package paths: %s", err.Error())
+	}
+	expected := []string{path.Join(root, "cmd"), path.Join(root, "gen"), path.Join(root, "pkg")}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected paths %v got %v", expected, paths)
+	}
+	paths, err = ds.PackagePaths(context.Background(), path.Join(root, "cmd"))
+	if err != nil {
+		t.Fatalf("Error getting package paths: %s", err.Error())
+	}
+	expected = []string{path.Join(root, "cmd", "tool")}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected paths %v got %v", expected, paths)
+	}
+}
+
+// A statCountingFS records the paths stat'd through it.
+type statCountingFS struct {
+	FS
+	stats []string
+}
+
+func (sf *statCountingFS) Stat(name string) (os.FileInfo, error) {
+	sf.stats = append(sf.stats, name)
+	return sf.FS.Stat(name)
+}
+
+func TestDependencySaverReusesDirInfo(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	root := PackageSource(testHome, "test.com/project")
+	for _, dir := range []string{"a", "b"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(ctx context.Context, path string) (Dependencies, error) {
+		return NewDependencies(), nil
+	}
+	ds := NewDependencySaver(read, testHome, root)
+	stats := &statCountingFS{FS: OSFS{}}
+	ds.FS = stats
+	dw := NewDependencyWalker(ds.PackagePaths, ds.SavePackageDeps)
+	if err := dw.TraverseDependencies(context.Background(), root); err != nil {
+		t.Fatalf("Error traversing deps: %s", err.Error())
+	}
+	expected := []string{root}
+	if !reflect.DeepEqual(expected, stats.stats) {
+		t.Errorf("Expected only the root to be stat'd got %v", stats.stats)
+	}
+}
+
+func TestDependencySaverConcurrent(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	root := PackageSource(testHome, "test.com/project")
+	for _, dir := range []string{"a/x", "a/y", "b", "c/z", "empty"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(ctx context.Context, p string) (Dependencies, error) {
+		deps := NewDependencies()
+		if p == path.Join(root, "empty") {
+			return deps, &PackageError{Err: "no buildable Go source files"}
+		}
+		deps.AddDeps("dep.com/lib")
+		if p == path.Join(root, "b") {
+			deps.AddDeps("test.com/project/a/x")
+		}
+		return deps, nil
+	}
+	save := func(workers int) Dependencies {
+		ds := NewDependencySaver(read, testHome, root)
+		ds.NoRecur.Add(PackageSource(testHome, "dep.com/lib"))
+		dw := NewDependencyWalker(ds.PackagePaths, ds.SavePackageDeps)
+		dw.Workers = workers
+		if err := dw.TraverseDependencies(context.Background(), root); err != nil {
+			t.Fatalf("Error traversing deps: %s", err.Error())
+		}
+		return ds.Dependencies()
+	}
+	serial, concurrent := save(1), save(8)
+	if !reflect.DeepEqual(serial, concurrent) {
+		t.Errorf("Expected concurrent save %v to match serial save %v", concurrent, serial)
+	}
+	lib := concurrent.Dependency("dep.com/lib")
+	if lib == nil || lib.ImportedFrom.Size() != 7 {
+		t.Errorf("Expected dep.com/lib imported from every package got %+v", lib)
+	}
+}
+
+func TestDependencySaverVCSIgnored(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	root := PackageSource(testHome, "test.com/project")
+	for _, dir := range []string{"pkg", "build"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(root, ".gitignore"), []byte("build/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewDependencySaver(nil, testHome, root)
+	ds.VCSIgnored = func(ctx context.Context, paths []string) ([]string, error) {
+		return GitIgnoredPaths(ctx, root, paths)
+	}
+	paths, err := ds.PackagePaths(context.Background(), root)
+	if err != nil {
+		t.Fatalf("Error getting package paths: %s", err.Error())
+	}
+	expected := []string{path.Join(root, "build"), path.Join(root, "pkg")}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected paths outside git %v got %v", expected, paths)
+	}
+	if ds.VCSIgnored != nil {
+		t.Errorf("Expected VCSIgnored to be disabled outside a git repo")
+	}
+
+	cmd := exec.Command("git", "init")
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error running git init %s %s", err.Error(), string(out))
+	}
+	ds = NewDependencySaver(nil, testHome, root)
+	ds.VCSIgnored = func(ctx context.Context, paths []string) ([]string, error) {
+		return GitIgnoredPaths(ctx, root, paths)
+	}
+	paths, err = ds.PackagePaths(context.Background(), root)
+	if err != nil {
+		t.Fatalf("Error getting package paths: %s", err.Error())
+	}
+	expected = []string{path.Join(root, "pkg")}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected paths %v got %v", expected, paths)
+	}
+}
+
+func TestDependencySaverCompact(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	root := PackageSource(testHome, "test.com/project")
+	for _, pkg := range []string{"test.com/project/a", "test.com/project/b", "dep.com/lib/sub", "other.com/x"} {
+		if err := os.MkdirAll(PackageSource(testHome, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imports := map[string][]string{
+		"test.com/project/a": {"dep.com/lib/sub"},
+		"test.com/project/b": {"dep.com/lib", "test.com/project/a"},
+		"dep.com/lib/sub":    {"other.com/x"},
+	}
+	var mu sync.Mutex
+	read := NewStringSet()
+	reader := func(ctx context.Context, p string) (Dependencies, error) {
+		pkg, _ := PackageName(testHome, p)
+		mu.Lock()
+		read.Add(pkg)
+		mu.Unlock()
+		deps := NewDependencies()
+		deps.AddDeps(imports[pkg]...)
+		return deps, nil
+	}
+	rootOfCalls := 0
+	ds := NewDependencySaver(reader, testHome, root)
+	ds.Compact = true
+	ds.RootOf = func(ctx context.Context, importPath string) (string, error) {
+		mu.Lock()
+		rootOfCalls++
+		mu.Unlock()
+		if importPath == "dep.com/lib" || PathIsChild("dep.com/lib", importPath) {
+			return "dep.com/lib", nil
+		}
+		return importPath, nil
+	}
+	dw := NewDependencyWalker(ds.PackagePaths, ds.SavePackageDeps)
+	if err := dw.TraverseDependencies(context.Background(), root); err != nil {
+		t.Fatalf("Error traversing deps: %s", err.Error())
+	}
+
+	for _, pkg := range []string{"dep.com/lib", "dep.com/lib/sub", "other.com/x"} {
+		if !read[pkg] {
+			t.Errorf("Expected %s to be walked", pkg)
+		}
+	}
+	deps := ds.Dependencies()
+	var keys []string
+	for key := range deps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	expected := []string{"dep.com/lib", "other.com/x", "test.com/project", "test.com/project/a", "test.com/project/b"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected deps %v got %v", expected, keys)
+	}
+	lib := deps.Dependency("dep.com/lib")
+	if lib == nil || !reflect.DeepEqual(lib.ImportedFrom.Array(), []string{"test.com/project"}) || !reflect.DeepEqual(lib.Imports.Array(), []string{"other.com/x"}) {
+		t.Errorf("Expected dep.com/lib imported from the project and importing other.com/x got %+v", lib)
+	}
+	x := deps.Dependency("other.com/x")
+	if x == nil || !reflect.DeepEqual(x.ImportedFrom.Array(), []string{"dep.com/lib"}) {
+		t.Errorf("Expected other.com/x imported from dep.com/lib got %+v", x)
+	}
+	if len(ds.pending) != 0 {
+		t.Errorf("Expected no pending imports after walking got %v", ds.pending)
+	}
+	if rootOfCalls != 2 {
+		t.Errorf("Expected RootOf called once for each root got %d", rootOfCalls)
+	}
 }
@@ -0,0 +1,229 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A Diagnostic explains a failure with a known cause: what failed,
+// why it likely did and the command which should fix it.
+type Diagnostic struct {
+	// Code is the ErrorCode of the failure.
+	Code string
+	// Problem is what failed, without the output of the commands
+	// run.
+	Problem string
+	Cause   string
+	Fix     string
+	// Detail is the innermost error, usually the output of the
+	// command which failed.
+	Detail string
+}
+
+// String renders the diagnostic as cant prints it.
+func (d *Diagnostic) String() string {
+	var buf bytes.Buffer
+	if d.Code != "" {
+		fmt.Fprintf(&buf, "%s: ", d.Code)
+	}
+	fmt.Fprintf(&buf, "%s\n  cause: %s\n  fix:   %s", d.Problem, d.Cause, d.Fix)
+	if d.Detail != "" && d.Detail != d.Problem {
+		fmt.Fprintf(&buf, "\n  detail: %s", d.Detail)
+	}
+	return buf.String()
+}
+
+// A Diagnoser returns the Diagnostic of err if it recognizes its
+// cause, otherwise nil. gopath is the gopath of the failed command.
+type Diagnoser func(err error, gopath string) *Diagnostic
+
+// Diagnosers are tried in order by Diagnose.
+var Diagnosers = []Diagnoser{
+	DiagnoseGoPath,
+	DiagnoseDirtyTree,
+	DiagnoseMissingRevision,
+	DiagnoseUnresolvable,
+	DiagnoseIntegrity,
+}
+
+// Diagnose returns the Diagnostic of the first of the Diagnosers
+// recognizing err, or nil if none do.
+func Diagnose(err error, gopath string) *Diagnostic {
+	// Cancelled operations need no fixing
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	for _, diagnose := range Diagnosers {
+		if d := diagnose(err, gopath); d != nil {
+			if d.Code == "" {
+				d.Code = ErrorCode(err)
+			}
+			if d.Detail == "" {
+				d.Detail = innermost(err).Error()
+			}
+			return d
+		}
+	}
+	return nil
+}
+
+// multiError is an error joining several, like FetchErrors.
+type multiError interface {
+	Unwrap() []error
+}
+
+// innermost returns the last error in the chain of err, following the
+// error joined by a multiError of one.
+func innermost(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if m, ok := err.(multiError); ok && len(m.Unwrap()) == 1 {
+			next = m.Unwrap()[0]
+		}
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// splitErrors returns the errors joined by the multiErrors in the
+// chain of err, so each may be diagnosed on its own, or err if there
+// are none.
+func splitErrors(err error) []error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		m, ok := e.(multiError)
+		if !ok || len(m.Unwrap()) == 0 {
+			continue
+		}
+		var errs []error
+		for _, joined := range m.Unwrap() {
+			errs = append(errs, splitErrors(joined)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+// DiagnoseGoPath recognizes a missing or invalid gopath.
+func DiagnoseGoPath(err error, gopath string) *Diagnostic {
+	var ge *GoPathError
+	if !errors.As(err, &ge) {
+		return nil
+	}
+	d := &Diagnostic{Problem: "no usable gopath found", Detail: ge.Err.Error()}
+	switch {
+	case ge.Gopath == "":
+		d.Cause = "GOPATH is not set and the current directory is not inside a src directory"
+		d.Fix = "export GOPATH=$HOME/go, or run cant from within $GOPATH/src/<import path>"
+	case ge.Missing:
+		d.Problem = fmt.Sprintf("gopath %s has no src directory", ge.Gopath)
+		d.Cause = "canticle expects packages at $GOPATH/src/<import path>"
+		d.Fix = "cant -create " + strings.Join(os.Args[1:], " ")
+	default:
+		src := filepath.Join(ge.Gopath, "src")
+		d.Problem = fmt.Sprintf("gopath %s is not usable", ge.Gopath)
+		d.Cause = fmt.Sprintf("%s must be a directory", src)
+		d.Fix = fmt.Sprintf("mv %s %s.bak && mkdir %s", src, src, src)
+	}
+	return d
+}
+
+// failedRoot returns the root and revision of the repo whose fetch or
+// checkout failed with err, and what failed.
+func failedRoot(err error) (root, rev, problem string) {
+	var re *RevisionError
+	if errors.As(err, &re) {
+		if re.Revision == "" {
+			return re.Root, "", fmt.Sprintf("cant get revision of %s", re.Root)
+		}
+		return re.Root, re.Revision, fmt.Sprintf("cant set %s to revision %s", re.Root, re.Revision)
+	}
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.Root, "", fmt.Sprintf("cant %s repo %s", fe.Op, fe.Root)
+	}
+	return "", "", ""
+}
+
+// isGit returns true if dir is a git checkout.
+func isGit(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+var dirtyTreePattern = regexp.MustCompile(`(?i)local changes|would be overwritten|uncommitted changes|commit your changes or stash them`)
+
+// DiagnoseDirtyTree recognizes a checkout refused because of local
+// changes to a dependency.
+func DiagnoseDirtyTree(err error, gopath string) *Diagnostic {
+	root, _, problem := failedRoot(err)
+	if root == "" || !dirtyTreePattern.MatchString(err.Error()) {
+		return nil
+	}
+	dir := PackageSource(gopath, root)
+	d := &Diagnostic{
+		Problem: problem,
+		Cause:   fmt.Sprintf("%s has local changes the checkout would overwrite", dir),
+		Fix:     fmt.Sprintf("commit or revert the changes in %s", dir),
+	}
+	if isGit(dir) {
+		d.Fix = fmt.Sprintf("git -C %s stash", dir)
+	}
+	return d
+}
+
+var missingRevisionPattern = regexp.MustCompile(`(?i)unknown revision|did not match any|reference is not a tree|not a valid object name|bad revision|couldn't find remote ref|no such revision`)
+
+// DiagnoseMissingRevision recognizes a revision missing from the
+// checkout of a dependency.
+func DiagnoseMissingRevision(err error, gopath string) *Diagnostic {
+	root, rev, problem := failedRoot(err)
+	if root == "" || rev == "" || !missingRevisionPattern.MatchString(err.Error()) {
+		return nil
+	}
+	dir := PackageSource(gopath, root)
+	d := &Diagnostic{
+		Problem: problem,
+		Cause:   fmt.Sprintf("revision %s is not in the checkout of %s, it may not be fetched yet or was removed upstream, if so pin another revision with cant save", rev, root),
+		Fix:     fmt.Sprintf("pull the latest history into %s", dir),
+	}
+	if isGit(dir) {
+		d.Fix = fmt.Sprintf("git -C %s fetch --all --tags", dir)
+	}
+	return d
+}
+
+// DiagnoseUnresolvable recognizes an import path no repo was found
+// for.
+func DiagnoseUnresolvable(err error, gopath string) *Diagnostic {
+	var re *ResolveError
+	if !errors.As(err, &re) {
+		return nil
+	}
+	return &Diagnostic{
+		Problem: fmt.Sprintf("cant resolve vcs for %s", re.Path),
+		Cause:   fmt.Sprintf("no repo was found for %s, it may be mistyped, private or its host unreachable, private repos need a SourcePath in the Canticle file", re.Path),
+		Fix:     fmt.Sprintf("git ls-remote https://%s", re.Path),
+	}
+}
+
+// DiagnoseIntegrity recognizes a fetched repo whose files do not
+// match the TreeHash saved for it.
+func DiagnoseIntegrity(err error, gopath string) *Diagnostic {
+	var ie *IntegrityError
+	if !errors.As(err, &ie) {
+		return nil
+	}
+	return &Diagnostic{
+		Problem: fmt.Sprintf("cant verify %s at %s", ie.Root, ie.Revision),
+		Cause:   fmt.Sprintf("the files of %s differ from those saved, its history was rewritten, its source tampered with or it was changed locally", PackageSource(gopath, ie.Root)),
+		Fix:     "inspect the changes, and only if they are expected record the new hash with cant save -hash",
+	}
+}
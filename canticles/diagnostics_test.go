@@ -0,0 +1,79 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	gopath := filepath.Join(testHome, "gopath")
+	dir := PackageSource(gopath, "dep.com/x")
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	checkout := func(output string) error {
+		cause := fmt.Errorf("git checkout abc failed %s", output)
+		return &FetchError{Root: "dep.com/x", Op: "fetch", Err: &RevisionError{Root: "dep.com/x", Revision: "abc", Err: cause}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		fix  string
+	}{
+		{"dirty", checkout("error: Your local changes to the following files would be overwritten by checkout"), "git -C " + dir + " stash"},
+		{"missing", checkout("error: pathspec 'abc' did not match any file(s) known to git"), "git -C " + dir + " fetch --all --tags"},
+		{"unresolvable", &ResolveError{Path: "dep.com/y", Err: NewResolutionFailureError("dep.com/y", "remote")}, "git ls-remote https://dep.com/y"},
+		{"gopath", &GoPathError{Err: errors.New("no gopath set")}, "export GOPATH=$HOME/go, or run cant from within $GOPATH/src/<import path>"},
+		{"integrity", &IntegrityError{Root: "dep.com/x", Revision: "abc", Saved: "sha256:1", Got: "sha256:2"}, "inspect the changes, and only if they are expected record the new hash with cant save -hash"},
+	}
+	for _, test := range tests {
+		d := Diagnose(fmt.Errorf("cant load package %w", test.err), gopath)
+		if d == nil {
+			t.Errorf("Expected %s diagnosed", test.name)
+			continue
+		}
+		if d.Fix != test.fix {
+			t.Errorf("Expected %s fix %q got %q", test.name, test.fix, d.Fix)
+		}
+	}
+
+	d := Diagnose(checkout("error: pathspec 'abc' did not match any file(s) known to git"), gopath)
+	expected := "revision: cant set dep.com/x to revision abc\n  cause: "
+	if !strings.HasPrefix(d.String(), expected) || !strings.Contains(d.String(), "\n  detail: git checkout abc failed error: pathspec") {
+		t.Errorf("Expected rendered diagnostic got %s", d.String())
+	}
+	other := &FetchError{Root: "dep.com/z", Op: "fetch", Err: &RevisionError{Root: "dep.com/z", Revision: "def", Err: errors.New("git checkout def failed error: pathspec 'def' did not match any file(s) known to git")}}
+	fetchErrs := fmt.Errorf("cant load package %w", FetchErrors{checkout("error: Your local changes to the following files would be overwritten by checkout"), other})
+	if errs := splitErrors(fetchErrs); len(errs) != 2 {
+		t.Errorf("Expected an error for each repo got %v", errs)
+	}
+	msg := ErrorMessage(fetchErrs)
+	for _, line := range []string{"cant set dep.com/x to revision abc", "local changes", "cant set dep.com/z to revision def", "detail: git checkout def failed"} {
+		if !strings.Contains(msg, line) {
+			t.Errorf("Expected a diagnostic of each repo containing %s got %s", line, msg)
+		}
+	}
+	if d := Diagnose(FetchErrors{other}, gopath); d == nil || !strings.HasPrefix(d.Detail, "git checkout def failed") {
+		t.Errorf("Expected the innermost error of a single fetch error as detail got %v", d)
+	}
+	for _, err := range []error{
+		checkout("fatal: unable to access"),
+		&ResolveError{Path: "dep.com/y", Err: context.Canceled},
+	} {
+		if d := Diagnose(err, gopath); d != nil {
+			t.Errorf("Expected %s undiagnosed got %s", err.Error(), d.String())
+		}
+	}
+}
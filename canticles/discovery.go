@@ -0,0 +1,195 @@
+package canticles
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// RepoRootForImportPath returns the repo root of importPath as
+// vcs.RepoRootForImportPath does, with insecure http allowed, but
+// fetching go-import meta tags with the HTTPClient of ctx.
+func RepoRootForImportPath(ctx context.Context, importPath string) (*vcs.RepoRoot, error) {
+	rr, err := vcs.RepoRootForImportPathStatic(importPath, "")
+	if err == nil || isStaticImportPath(importPath) {
+		return rr, err
+	}
+	rr, err = RepoRootForImportDynamic(ctx, importPath)
+	if err != nil {
+		LogVerboseContext(ctx, "import %q: %s", importPath, err.Error())
+		return nil, fmt.Errorf("unrecognized import path %q", importPath)
+	}
+	if strings.Contains(importPath, "...") && strings.Contains(rr.Root, "...") {
+		return nil, fmt.Errorf("cannot expand ... in %q", importPath)
+	}
+	return rr, nil
+}
+
+// staticHosts are the prefixes of the hosts
+// vcs.RepoRootForImportPathStatic knows.
+var staticHosts = []string{"github.com/", "bitbucket.org/", "launchpad.net/", "git.openstack.org"}
+
+// isStaticImportPath returns true if importPath is invalid or on a
+// host in staticHosts, so an error from its static lookup is not
+// resolved by a dynamic lookup.
+func isStaticImportPath(importPath string) bool {
+	for _, prefix := range staticHosts {
+		if strings.HasPrefix(importPath, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(importPath, "://")
+}
+
+// RepoRootForImportDynamic finds the repo root of importPath from the
+// go-import meta tags served at https://importPath?go-get=1, or
+// over http if that fails, fetched with the HTTPClient of ctx.
+func RepoRootForImportDynamic(ctx context.Context, importPath string) (*vcs.RepoRoot, error) {
+	host := importPath
+	if i := strings.Index(importPath, "/"); i >= 0 {
+		host = importPath[:i]
+	}
+	if !strings.Contains(host, ".") {
+		return nil, fmt.Errorf("import path doesn't contain a hostname")
+	}
+	imports, url, err := fetchMetaImports(ctx, importPath)
+	if err != nil {
+		return nil, err
+	}
+	mi, err := matchMetaImport(imports, importPath)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", url, err.Error())
+	}
+	LogVerboseContext(ctx, "Found meta tag %+v for %s at %s", mi, importPath, url)
+	// A path below the prefix may not claim the repo of its
+	// prefix, the prefix must agree.
+	if mi.Prefix != importPath {
+		rootImports, rootURL, err := fetchMetaImports(ctx, mi.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		root, err := matchMetaImport(rootImports, importPath)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %s", rootURL, err.Error())
+		}
+		if root != mi {
+			return nil, fmt.Errorf("%s and %s disagree about go-import for %s", url, rootURL, mi.Prefix)
+		}
+	}
+	if !strings.Contains(mi.RepoRoot, "://") {
+		return nil, fmt.Errorf("invalid repo root %q, no scheme", mi.RepoRoot)
+	}
+	v := vcs.ByCmd(mi.VCS)
+	if v == nil {
+		return nil, fmt.Errorf("%s: unknown vcs %q", url, mi.VCS)
+	}
+	return &vcs.RepoRoot{VCS: v, Repo: mi.RepoRoot, Root: mi.Prefix}, nil
+}
+
+// metaImport is a go-import meta tag.
+type metaImport struct {
+	Prefix, VCS, RepoRoot string
+}
+
+// fetchMetaImports returns the go-import meta tags of importPath and
+// the url they were read from.
+func fetchMetaImports(ctx context.Context, importPath string) ([]metaImport, string, error) {
+	client := HTTPClient(ctx)
+	var errs []string
+	for _, scheme := range []string{"https", "http"} {
+		url := scheme + "://" + importPath + "?go-get=1"
+		LogVerboseContext(ctx, "Fetching %s", url)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			errs = append(errs, fmt.Sprintf("%s status %s", url, resp.Status))
+			continue
+		}
+		imports, err := parseMetaImports(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("parsing %s: %s", url, err.Error())
+		}
+		return imports, url, nil
+	}
+	return nil, "", fmt.Errorf("http/https fetch: %s", strings.Join(errs, ", "))
+}
+
+// parseMetaImports returns the go-import meta tags in the head of the
+// html in r, ignoring those for module mode.
+func parseMetaImports(r io.Reader) ([]metaImport, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if strings.ToLower(charset) == "ascii" {
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+	d.Strict = false
+	var imports []metaImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || metaAttr(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(metaAttr(e.Attr, "content")); len(f) == 3 && f[1] != "mod" {
+			imports = append(imports, metaImport{Prefix: f[0], VCS: f[1], RepoRoot: f[2]})
+		}
+	}
+}
+
+// metaAttr returns the value of the attribute name, ignoring case.
+func metaAttr(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// matchMetaImport returns the only meta import whose prefix is, or
+// is a parent of, importPath.
+func matchMetaImport(imports []metaImport, importPath string) (metaImport, error) {
+	var match metaImport
+	found := false
+	for _, mi := range imports {
+		if importPath != mi.Prefix && !strings.HasPrefix(importPath, mi.Prefix+"/") {
+			continue
+		}
+		if found {
+			return metaImport{}, fmt.Errorf("multiple meta tags match import path %q", importPath)
+		}
+		match, found = mi, true
+	}
+	if !found {
+		return metaImport{}, fmt.Errorf("no go-import meta tags for %s", importPath)
+	}
+	return match, nil
+}
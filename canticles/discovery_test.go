@@ -0,0 +1,48 @@
+package canticles
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepoRootForImportDynamic(t *testing.T) {
+	var host string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta name="go-import" content="` + host + `/x git https://git.example.com/x"></head></html>`))
+	}))
+	defer server.Close()
+	host = strings.TrimPrefix(server.URL, "https://")
+
+	// The server is only trusted by its own client
+	ctx := WithHTTPClient(context.Background(), server.Client())
+	rr, err := RepoRootForImportDynamic(ctx, host+"/x/sub")
+	if err != nil {
+		t.Fatalf("Error discovering repo: %s", err.Error())
+	}
+	if rr.Root != host+"/x" || rr.Repo != "https://git.example.com/x" || rr.VCS.Cmd != "git" {
+		t.Errorf("Expected repo %s/x at https://git.example.com/x got %+v", host, rr)
+	}
+	if _, err := RepoRootForImportDynamic(context.Background(), host+"/x"); err == nil {
+		t.Errorf("Expected discovery without the context client to fail")
+	}
+	if _, err := RepoRootForImportDynamic(ctx, host+"/y"); err == nil {
+		t.Errorf("Expected error for import path without a matching meta tag")
+	}
+}
+
+func TestParseMetaImports(t *testing.T) {
+	html := `<html><head>
+<meta name="go-import" content="example.com/a git https://example.com/a">
+<meta name="go-import" content="example.com/a mod https://proxy.example.com">
+</head><body><meta name="go-import" content="example.com/b git https://example.com/b"></body></html>`
+	imports, err := parseMetaImports(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("Error parsing meta imports: %s", err.Error())
+	}
+	if len(imports) != 1 || imports[0] != (metaImport{"example.com/a", "git", "https://example.com/a"}) {
+		t.Errorf("Expected only the git meta import in the head got %+v", imports)
+	}
+}
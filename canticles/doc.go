@@ -0,0 +1,33 @@
+// Package canticles implements the cant commands, and exposes them
+// for tools embedding canticle.
+//
+// A Client, configured by Options, is the entry point for embedding:
+// it fetches (Get, Vendor), reads dep trees (ReadDeps), resolves and
+// saves Canticle files (Resolve, Save) as the cant commands do.
+//
+// The pieces a Client is built from may also be used directly:
+//   - A DependencyWalker walks a dep tree breadth first
+//   - A DependencyLoader and CanticleDepLoader fetch the packages walked
+//   - A DependencySaver records the packages walked as Dependencies
+//   - A RepoResolver resolves import paths to VCSs, see NewFetchResolver
+//   - A ConflictResolver picks between the revisions and sources found
+//   - An FS, such as a MemFS, is what the savers and loaders read from
+//
+// Errors fetching and resolving dependencies are a FetchError,
+// ResolveError or RevisionError wrapping their cause, ErrorCode
+// classifies them.
+//
+// Operations emit Events, such as FetchStarted and SaveCompleted, on
+// the DefaultEvents bus or the bus of their context, see WithEvents,
+// for progress displays and audit logs to Subscribe to.
+//
+// Package canticlestest has fakes of the VCS, RepoResolver and readers
+// for testing code built on these pieces.
+//
+// Events are logged to the DefaultLogger, unless a Logger is set on
+// the Client, the walkers, loaders and resolvers used, or the context
+// of an operation, see WithLogger.
+//
+// Package level settings, such as Verbose, TLS, HTTP and Secure, are
+// shared by every Client in a process.
+package canticles
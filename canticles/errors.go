@@ -0,0 +1,227 @@
+package canticles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The codes of the errors returned while fetching and resolving
+// dependencies, see ErrorCode.
+const (
+	CodeFetch     = "fetch"
+	CodeResolve   = "resolve"
+	CodeRevision  = "revision"
+	CodeGoPath    = "gopath"
+	CodeCycle     = "cycle"
+	CodeIntegrity = "integrity"
+)
+
+// A FetchError is returned when the repo at Root could not be
+// fetched or updated.
+type FetchError struct {
+	Root string
+	// Op is what was being done, fetch or update.
+	Op  string
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("cant %s repo %s because %s", e.Op, e.Root, e.Err.Error())
+}
+
+// Unwrap returns the cause of the error.
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// Code returns CodeFetch.
+func (e *FetchError) Code() string { return CodeFetch }
+
+// A ResolveError is returned when no VCS could be resolved for the
+// import path Path.
+type ResolveError struct {
+	Path string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("cant resolve vcs for %s because %s", e.Path, e.Err.Error())
+}
+
+// Unwrap returns the cause of the error.
+func (e *ResolveError) Unwrap() error { return e.Err }
+
+// Code returns CodeResolve.
+func (e *ResolveError) Code() string { return CodeResolve }
+
+// A RevisionError is returned when the revision of the repo at Root
+// could not be read, or set to Revision.
+type RevisionError struct {
+	Root     string
+	Revision string
+	Err      error
+}
+
+func (e *RevisionError) Error() string {
+	if e.Revision == "" {
+		return fmt.Sprintf("cant get revision of %s because %s", e.Root, e.Err.Error())
+	}
+	return fmt.Sprintf("cant set %s to revision %s because %s", e.Root, e.Revision, e.Err.Error())
+}
+
+// Unwrap returns the cause of the error.
+func (e *RevisionError) Unwrap() error { return e.Err }
+
+// Code returns CodeRevision.
+func (e *RevisionError) Code() string { return CodeRevision }
+
+// A GoPathError is returned when no usable gopath is found. Gopath
+// is empty if none is set.
+type GoPathError struct {
+	Gopath string
+	// Missing is true if the src directory of Gopath does not
+	// exist.
+	Missing bool
+	Err     error
+}
+
+func (e *GoPathError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cause of the error.
+func (e *GoPathError) Unwrap() error { return e.Err }
+
+// Code returns CodeGoPath.
+func (e *GoPathError) Code() string { return CodeGoPath }
+
+// An IntegrityError is returned when the files of the repo at Root
+// do not match the TreeHash saved for it.
+type IntegrityError struct {
+	Root     string
+	Revision string
+	// Saved is the TreeHash of the Canticle file, Got the hash of
+	// the files fetched.
+	Saved string
+	Got   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("cant verify %s at %s, its tree hash %s does not match the saved %s", e.Root, e.Revision, e.Got, e.Saved)
+}
+
+// Code returns CodeIntegrity.
+func (e *IntegrityError) Code() string { return CodeIntegrity }
+
+// A CycleError is returned when packages import each other in a
+// cycle. Each of Cycles starts and ends with the same package, as
+// returned by FindCycles.
+type CycleError struct {
+	Cycles [][]string
+}
+
+func (e *CycleError) Error() string {
+	paths := make([]string, len(e.Cycles))
+	for i, cycle := range e.Cycles {
+		paths[i] = strings.Join(cycle, " -> ")
+	}
+	if len(paths) == 1 {
+		return "import cycle " + paths[0]
+	}
+	return fmt.Sprintf("%d import cycles %s", len(paths), strings.Join(paths, ", "))
+}
+
+// Code returns CodeCycle.
+func (e *CycleError) Code() string { return CodeCycle }
+
+// FetchErrors are the errors of the repos which could not be resolved
+// or fetched while fetching several at once, one for each root.
+type FetchErrors []error
+
+func (e FetchErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("cant fetch %d repos:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// Unwrap returns the errors so errors.Is and errors.As look in each.
+func (e FetchErrors) Unwrap() []error { return e }
+
+// Code returns the code of the errors if they all have the same one,
+// else CodeFetch.
+func (e FetchErrors) Code() string {
+	code := ""
+	for i, err := range e {
+		c := ErrorCode(err)
+		if i > 0 && c != code {
+			return CodeFetch
+		}
+		code = c
+	}
+	if code == "" {
+		return CodeFetch
+	}
+	return code
+}
+
+// ErrorCode returns the code of the most specific error in the chain
+// of err with one, so a revision that could not be checked out while
+// fetching is CodeRevision. It returns the empty string if there is
+// none.
+func ErrorCode(err error) string {
+	code := ""
+	for ; err != nil; err = errors.Unwrap(err) {
+		if c, ok := err.(interface{ Code() string }); ok {
+			code = c.Code()
+		}
+	}
+	return code
+}
+
+// ErrorMessage returns the message of err prefixed with its code, if
+// it has one, as cant prints it. Failures with a known cause are
+// rendered as their Diagnostic instead, the errors of each repo of a
+// FetchErrors are rendered on their own.
+func ErrorMessage(err error) string {
+	gopath := messageGoPath()
+	errs := splitErrors(err)
+	if len(errs) == 1 {
+		return errorMessage(errs[0], gopath)
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = errorMessage(err, gopath)
+	}
+	return fmt.Sprintf("%d errors:\n%s", len(errs), strings.Join(msgs, "\n"))
+}
+
+// errorMessage returns the Diagnostic of err, or its message prefixed
+// with its code.
+func errorMessage(err error, gopath string) string {
+	if d := Diagnose(err, gopath); d != nil {
+		return d.String()
+	}
+	if code := ErrorCode(err); code != "" {
+		return code + ": " + err.Error()
+	}
+	return err.Error()
+}
+
+// messageGoPath returns the gopath EnvGoPath would for rendering
+// errors, without checking or creating it.
+func messageGoPath() string {
+	if wd, err := os.Getwd(); err == nil {
+		if root := ProjectRoot(wd); root != "" {
+			return root
+		}
+	}
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		return gopath
+	}
+	return "$GOPATH"
+}
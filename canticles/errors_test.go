@@ -0,0 +1,50 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	cause := errors.New("no such rev")
+	rev := &RevisionError{Root: "dep.com/x", Revision: "abc", Err: cause}
+	fetch := &FetchError{Root: "dep.com/x", Op: "fetch", Err: rev}
+	wrapped := fmt.Errorf("cant load package %w", fetch)
+	if code := ErrorCode(wrapped); code != CodeRevision {
+		t.Errorf("Expected code %s got %s", CodeRevision, code)
+	}
+	if code := ErrorCode(&FetchError{Root: "dep.com/x", Op: "update", Err: cause}); code != CodeFetch {
+		t.Errorf("Expected code %s got %s", CodeFetch, code)
+	}
+	if code := ErrorCode(cause); code != "" {
+		t.Errorf("Expected no code got %s", code)
+	}
+	if !errors.Is(wrapped, cause) {
+		t.Errorf("Expected cause to be kept")
+	}
+	var fe *FetchError
+	if !errors.As(wrapped, &fe) || fe.Root != "dep.com/x" {
+		t.Errorf("Expected FetchError of dep.com/x got %v", fe)
+	}
+	expected := "revision: cant set dep.com/x to revision abc because no such rev"
+	if msg := ErrorMessage(rev); msg != expected {
+		t.Errorf("Expected message %q got %q", expected, msg)
+	}
+}
+
+func TestFetchDepErrors(t *testing.T) {
+	cdep := &CanticleDependency{Root: "dep.com/x", Revision: "abc"}
+	fail := NewResolutionFailureError("dep.com/x", "test")
+	_, err := FetchDep(context.Background(), &testResolver{response: []resolve{{nil, fail}}}, cdep, false)
+	var re *ResolveError
+	if !errors.As(err, &re) || re.Path != "dep.com/x" || ResolutionFailureErr(err) != fail {
+		t.Errorf("Expected ResolveError wrapping resolution failure got %v", err)
+	}
+	v := &TestVCS{Err: errTest}
+	_, err = FetchDep(context.Background(), &testResolver{response: []resolve{{v, nil}}}, cdep, false)
+	if ErrorCode(err) != CodeFetch || !errors.Is(err, errTest) {
+		t.Errorf("Expected FetchError wrapping errTest got %v", err)
+	}
+}
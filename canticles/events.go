@@ -0,0 +1,151 @@
+package canticles
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// An Event is emitted as dependencies are resolved, fetched and
+// saved, see EventBus. Events are DepResolved, FetchStarted,
+// FetchFinished, FetchesFinished, RevisionSet and SaveCompleted.
+type Event interface {
+	// EventName is the name of the type of event, such as
+	// fetch-started.
+	EventName() string
+}
+
+// DepResolved is emitted once the VCS of a dependency is resolved.
+type DepResolved struct {
+	// ImportPath is the path resolved, Root the root of its VCS.
+	ImportPath string
+	Root       string
+}
+
+// EventName returns dep-resolved.
+func (DepResolved) EventName() string { return "dep-resolved" }
+
+// FetchStarted is emitted before a repo is fetched.
+type FetchStarted struct {
+	Root     string
+	Revision string
+}
+
+// EventName returns fetch-started.
+func (FetchStarted) EventName() string { return "fetch-started" }
+
+// FetchFinished is emitted once a fetch is done, Err is non nil if
+// it failed.
+type FetchFinished struct {
+	Root     string
+	Revision string
+	Duration time.Duration
+	Err      error
+}
+
+// EventName returns fetch-finished.
+func (FetchFinished) EventName() string { return "fetch-finished" }
+
+// FetchesFinished is emitted once a set of dependencies fetched
+// together is done, before any errors fetching them are returned.
+type FetchesFinished struct {
+	Fetched int
+	Failed  int
+}
+
+// EventName returns fetches-finished.
+func (FetchesFinished) EventName() string { return "fetches-finished" }
+
+// RevisionSet is emitted once a fetched repo is at Revision.
+type RevisionSet struct {
+	Root     string
+	Revision string
+}
+
+// EventName returns revision-set.
+func (RevisionSet) EventName() string { return "revision-set" }
+
+// SaveCompleted is emitted once the Canticle file of Path is saved.
+type SaveCompleted struct {
+	Path         string
+	Dependencies []*CanticleDependency
+}
+
+// EventName returns save-completed.
+func (SaveCompleted) EventName() string { return "save-completed" }
+
+// An EventHandler is called with each event emitted. It is called
+// from the goroutine emitting the event, so must be safe for
+// concurrent use and should return quickly.
+type EventHandler func(ctx context.Context, ev Event)
+
+// An EventBus passes the events emitted to its subscribers, in the
+// order they subscribed. The zero value has no subscribers.
+type EventBus struct {
+	mu          sync.RWMutex
+	next        int
+	subscribers []subscriber
+}
+
+type subscriber struct {
+	id int
+	h  EventHandler
+}
+
+// NewEventBus returns an EventBus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe calls h with every event emitted on the bus until the
+// returned func is called.
+func (eb *EventBus) Subscribe(h EventHandler) (unsubscribe func()) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	id := eb.next
+	eb.next++
+	eb.subscribers = append(eb.subscribers, subscriber{id, h})
+	return func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		for i, s := range eb.subscribers {
+			if s.id == id {
+				eb.subscribers = append(eb.subscribers[:i:i], eb.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Emit calls every subscriber with ev.
+func (eb *EventBus) Emit(ctx context.Context, ev Event) {
+	eb.mu.RLock()
+	subscribers := eb.subscribers
+	eb.mu.RUnlock()
+	for _, s := range subscribers {
+		s.h(ctx, ev)
+	}
+}
+
+// DefaultEvents is the bus events of operations without a bus of
+// their own are emitted on, see WithEvents.
+var DefaultEvents = NewEventBus()
+
+// WithEvents returns a copy of ctx whose operations emit their events
+// on eb instead of the DefaultEvents.
+func WithEvents(ctx context.Context, eb *EventBus) context.Context {
+	return context.WithValue(ctx, eventsKey, eb)
+}
+
+// EventsOf returns the bus operations with ctx emit their events on.
+func EventsOf(ctx context.Context) *EventBus {
+	if eb, ok := ctx.Value(eventsKey).(*EventBus); ok && eb != nil {
+		return eb
+	}
+	return DefaultEvents
+}
+
+// Emit emits ev on the bus of ctx.
+func Emit(ctx context.Context, ev Event) {
+	EventsOf(ctx).Emit(ctx, ev)
+}
@@ -0,0 +1,70 @@
+package canticles
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// An eventRecorder records the names of the events it is passed.
+type eventRecorder struct {
+	sync.Mutex
+	names  []string
+	events []Event
+}
+
+func (er *eventRecorder) Handle(ctx context.Context, ev Event) {
+	er.Lock()
+	defer er.Unlock()
+	er.names = append(er.names, ev.EventName())
+	er.events = append(er.events, ev)
+}
+
+func TestEventBus(t *testing.T) {
+	eb := NewEventBus()
+	var calls []string
+	unsub := eb.Subscribe(func(ctx context.Context, ev Event) { calls = append(calls, "first "+ev.EventName()) })
+	eb.Subscribe(func(ctx context.Context, ev Event) { calls = append(calls, "second "+ev.EventName()) })
+	eb.Emit(context.Background(), RevisionSet{Root: "dep.com/x", Revision: "abc"})
+	unsub()
+	unsub()
+	eb.Emit(context.Background(), SaveCompleted{Path: "/project"})
+	expected := []string{"first revision-set", "second revision-set", "second save-completed"}
+	if !reflect.DeepEqual(expected, calls) {
+		t.Errorf("Expected calls %v got %v", expected, calls)
+	}
+}
+
+func TestFetchDepEvents(t *testing.T) {
+	er := &eventRecorder{}
+	eb := NewEventBus()
+	eb.Subscribe(er.Handle)
+	defaults := &eventRecorder{}
+	defer DefaultEvents.Subscribe(defaults.Handle)()
+
+	v := &TestVCS{Root: "dep.com/x"}
+	cdep := &CanticleDependency{Root: "dep.com/x", Revision: "abc"}
+	ctx := WithEvents(context.Background(), eb)
+	if _, err := FetchDep(ctx, &testResolver{response: []resolve{{v, nil}}}, cdep, false); err != nil {
+		t.Fatalf("Error fetching dep %s", err.Error())
+	}
+	expected := []string{"dep-resolved", "fetch-started", "fetch-finished", "revision-set"}
+	if !reflect.DeepEqual(expected, er.names) {
+		t.Errorf("Expected events %v got %v", expected, er.names)
+	}
+	if rs, ok := er.events[3].(RevisionSet); !ok || rs.Revision != "abc" {
+		t.Errorf("Expected revision abc set got %+v", er.events[3])
+	}
+	if len(defaults.names) != 0 {
+		t.Errorf("Expected no events on the DefaultEvents got %v", defaults.names)
+	}
+
+	v.Err = errTest
+	if _, err := FetchDep(ctx, &testResolver{response: []resolve{{v, nil}}}, cdep, false); err == nil {
+		t.Fatalf("Expected fetch error")
+	}
+	if ff, ok := er.events[len(er.events)-1].(FetchFinished); !ok || ff.Err != errTest {
+		t.Errorf("Expected failed fetch finished last got %+v", er.events[len(er.events)-1])
+	}
+}
@@ -0,0 +1,58 @@
+package canticles
+
+import (
+	"context"
+	"net/http"
+	"os"
+)
+
+// A FetchEnv is the http client and the enviroment of git and hg
+// subprocesses a command fetches dependencies with. Commands carry it
+// on their context, see Context, rather than setting process globals,
+// so concurrent commands with different settings do not interfere.
+type FetchEnv struct {
+	Client *http.Client
+	// Env are the KEY=VALUE variables git and hg are run with.
+	Env []string
+	// hgrc is the temporary hgrc unsetting hg hooks, if any.
+	hgrc string
+}
+
+// NewFetchEnv returns a FetchEnv using the HTTP settings and the TLS
+// settings of tc, refusing unencrypted discovery as sm does and,
+// unless AllowVCSHooks is set, stopping git and hg from running hooks,
+// filters and templates. Either of tc and sm may be nil. Close must be
+// called to remove its temporary files.
+func NewFetchEnv(tc *TLSConfig, sm *SecureMode) (*FetchEnv, error) {
+	client, err := HTTP.Client(tc, sm)
+	if err != nil {
+		return nil, err
+	}
+	fe := &FetchEnv{Client: client}
+	var config []string
+	if !AllowVCSHooks {
+		if config, fe.Env, fe.hgrc, err = hooklessEnv(); err != nil {
+			return nil, err
+		}
+	}
+	gitEnv, err := GitConfigEnv(append(config, tc.GitConfig()...))
+	if err != nil {
+		fe.Close()
+		return nil, err
+	}
+	fe.Env = append(fe.Env, gitEnv...)
+	return fe, nil
+}
+
+// Context returns a copy of ctx fetching with fe.
+func (fe *FetchEnv) Context(ctx context.Context) context.Context {
+	return WithVCSEnv(WithHTTPClient(ctx, fe.Client), fe.Env)
+}
+
+// Close removes the temporary files of fe.
+func (fe *FetchEnv) Close() error {
+	if fe.hgrc == "" {
+		return nil
+	}
+	return os.Remove(fe.hgrc)
+}
@@ -0,0 +1,328 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A VendoredCopy is a copy of a dependency in the vendor directory of
+// another dependency.
+type VendoredCopy struct {
+	// Root is the import path of the vendored dependency.
+	Root string
+	// Dir is the directory of the copy.
+	Dir string
+	// Vendorer is the root of the dependency vendoring it.
+	Vendorer string
+	// Revision is the revision the Canticle file of the Vendorer
+	// pins Root at, empty if it does not.
+	Revision string
+	// Hash is the TreeHash of the copy.
+	Hash string
+}
+
+// A FlattenChoice is what was kept of a dependency vendored by other
+// dependencies.
+type FlattenChoice struct {
+	Root string
+	// Revision is the revision chosen, empty if none of the copies
+	// are pinned.
+	Revision string
+	// Kept is the directory kept at the top level of the gopath,
+	// either the dependency already there or the copy moved to it.
+	Kept string
+	// Removed are the copies removed.
+	Removed []*VendoredCopy
+	// Identical is how many of the removed copies had the same
+	// files as the copy kept or another removed copy.
+	Identical int
+}
+
+// String describes the choice.
+func (fc *FlattenChoice) String() string {
+	vendorers := NewStringSet()
+	for _, c := range fc.Removed {
+		vendorers.Add(c.Vendorer)
+	}
+	rev := fc.Revision
+	if rev == "" {
+		rev = "an unpinned revision"
+	}
+	s := fmt.Sprintf("%s kept at %s from %s", fc.Root, rev, fc.Kept)
+	if len(fc.Removed) > 0 {
+		s += fmt.Sprintf(", removed %d copies vendored by %s", len(fc.Removed), strings.Join(vendorers.Array(), ", "))
+		if fc.Identical > 0 {
+			s += fmt.Sprintf(" (%d identical)", fc.Identical)
+		}
+	}
+	return s
+}
+
+// A VendorFlattener flattens the vendor directories of dependencies
+// in Gopath. Each dependency vendored is kept once at the top level
+// of the gopath, at the revision picked by the Resolver from the
+// revision already there and those the vendoring dependencies pin,
+// and the vendored copies are removed.
+type VendorFlattener struct {
+	Gopath string
+	// Resolver picks the revision kept of dependencies with
+	// several.
+	Resolver ConflictResolver
+	// RepoResolver resolves the VCS of dependencies already at the
+	// top level.
+	RepoResolver RepoResolver
+	// Reader reads the Canticle files of the vendoring
+	// dependencies.
+	Reader CantDepReader
+	// DryRun reports the choices without changing anything.
+	DryRun bool
+}
+
+// NewVendorFlattener returns a VendorFlattener of the dependencies in
+// gopath picking revisions with resolver.
+func NewVendorFlattener(gopath string, resolver ConflictResolver) *VendorFlattener {
+	return &VendorFlattener{
+		Gopath:       gopath,
+		Resolver:     resolver,
+		RepoResolver: NewMemoizedRepoResolver(&LocalRepoResolver{gopath}),
+		Reader:       &DepReader{Gopath: gopath},
+	}
+}
+
+// FindVendored returns the copies in the vendor directories of roots,
+// sorted by root and directory. Each directory under a vendor
+// directory which is one of roots, or else the shallowest containing
+// go files, is a copy.
+func (vf *VendorFlattener) FindVendored(ctx context.Context, roots []string) ([]*VendoredCopy, error) {
+	known := NewStringSet()
+	known.Add(roots...)
+	var copies []*VendoredCopy
+	for _, vendorer := range roots {
+		var pins []*CanticleDependency
+		if vf.Reader != nil {
+			pins, _ = vf.Reader.CanticleDependencies(vendorer)
+		}
+		dir := PackageSource(vf.Gopath, vendorer)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if VCSDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			if info.Name() != "vendor" {
+				return nil
+			}
+			found, err := findCopies(path, known)
+			if err != nil {
+				return err
+			}
+			for _, c := range found {
+				c.Vendorer = vendorer
+				for _, pin := range pins {
+					if pin.Root == c.Root {
+						c.Revision = pin.Revision
+					}
+				}
+				if c.Hash, err = TreeHash(ctx, c.Dir); err != nil {
+					return err
+				}
+				LogVerboseContext(ctx, "Found %s vendored by %s in %s", c.Root, vendorer, c.Dir)
+			}
+			copies = append(copies, found...)
+			return filepath.SkipDir
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cant find vendored dependencies of %s %s", vendorer, err.Error())
+		}
+	}
+	sort.Sort(vendoredCopies(copies))
+	return copies, nil
+}
+
+// findCopies returns the copies directly under the vendor directory
+// vendor.
+func findCopies(vendor string, known StringSet) ([]*VendoredCopy, error) {
+	var copies []*VendoredCopy
+	err := filepath.Walk(vendor, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == vendor {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(vendor, path)
+		if err != nil {
+			return err
+		}
+		importPath := filepath.ToSlash(rel)
+		if _, ok := known[importPath]; !ok {
+			hasGo, err := hasGoFiles(path)
+			if err != nil || !hasGo || knownUnder(known, importPath) {
+				return err
+			}
+		}
+		copies = append(copies, &VendoredCopy{Root: importPath, Dir: path})
+		return filepath.SkipDir
+	})
+	return copies, err
+}
+
+func hasGoFiles(dir string) (bool, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".go") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// knownUnder returns true if a root in known is under importPath.
+func knownUnder(known StringSet, importPath string) bool {
+	for root := range known {
+		if PathIsChild(importPath, root) && root != importPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Flatten flattens the vendor directories of roots, returning the
+// choice made for each dependency vendored sorted by root.
+func (vf *VendorFlattener) Flatten(ctx context.Context, roots []string) ([]*FlattenChoice, error) {
+	copies, err := vf.FindVendored(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+	byRoot := make(map[string][]*VendoredCopy)
+	sources := NewDependencySources(0)
+	for _, c := range copies {
+		if byRoot[c.Root] == nil {
+			sources.AddSource(NewDependencySource(c.Root))
+		}
+		byRoot[c.Root] = append(byRoot[c.Root], c)
+	}
+	// Dependencies at the top level are kept there, those without a
+	// VCS, such as copies moved there, at no revision
+	top := make(map[string]VCS)
+	for _, source := range sources.Sources {
+		for _, c := range byRoot[source.Root] {
+			if c.Revision != "" {
+				source.Revisions.Add(c.Revision)
+			}
+		}
+		if _, err := os.Stat(PackageSource(vf.Gopath, source.Root)); err != nil {
+			continue
+		}
+		top[source.Root] = nil
+		vcs, err := vf.RepoResolver.ResolveRepo(ctx, source.Root, nil)
+		if err != nil || vcs.GetRoot() != source.Root {
+			LogVerboseContext(ctx, "Keeping %s at the top level without a revision", source.Root)
+			continue
+		}
+		if source.OnDiskRevision, err = vcs.GetRev(ctx); err != nil {
+			return nil, &RevisionError{Root: source.Root, Err: err}
+		}
+		source.Revisions.Add(source.OnDiskRevision)
+		top[source.Root] = vcs
+	}
+	cdeps, err := vf.Resolver.ResolveConflicts(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := make([]*FlattenChoice, 0, len(cdeps))
+	for _, cdep := range cdeps {
+		vcs, onDisk := top[cdep.Root]
+		choice, err := vf.flatten(ctx, cdep, onDisk, vcs, byRoot[cdep.Root])
+		if err != nil {
+			return choices, err
+		}
+		LogInfoContext(ctx, "%s", choice.String())
+		choices = append(choices, choice)
+	}
+	return choices, nil
+}
+
+// flatten keeps one copy of cdep at the top level. If it is already
+// onDisk its VCS, if any, is set to the revision of cdep.
+func (vf *VendorFlattener) flatten(ctx context.Context, cdep *CanticleDependency, onDisk bool, vcs VCS, copies []*VendoredCopy) (*FlattenChoice, error) {
+	choice := &FlattenChoice{Root: cdep.Root, Revision: cdep.Revision, Kept: PackageSource(vf.Gopath, cdep.Root)}
+	kept := -1
+	if !onDisk {
+		kept = 0
+		for i, c := range copies {
+			if c.Revision == cdep.Revision {
+				kept = i
+				break
+			}
+		}
+		choice.Kept = copies[kept].Dir
+	}
+	hashes := NewStringSet()
+	if kept >= 0 {
+		hashes.Add(copies[kept].Hash)
+	}
+	for i, c := range copies {
+		if i == kept {
+			continue
+		}
+		if _, ok := hashes[c.Hash]; ok {
+			choice.Identical++
+		}
+		hashes.Add(c.Hash)
+		choice.Removed = append(choice.Removed, c)
+	}
+	if vf.DryRun {
+		return choice, nil
+	}
+
+	if vcs != nil && cdep.Revision != "" {
+		if rev, err := vcs.GetRev(ctx); err == nil && rev != cdep.Revision {
+			if err := vcs.SetRev(ctx, cdep.Revision); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if kept >= 0 {
+		top := PackageSource(vf.Gopath, cdep.Root)
+		if err := os.MkdirAll(filepath.Dir(top), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(copies[kept].Dir, top); err != nil {
+			return nil, fmt.Errorf("cant move vendored %s to %s %s", cdep.Root, top, err.Error())
+		}
+	}
+	for _, c := range choice.Removed {
+		if err := os.RemoveAll(c.Dir); err != nil {
+			return nil, fmt.Errorf("cant remove vendored %s %s", c.Dir, err.Error())
+		}
+	}
+	return choice, nil
+}
+
+type vendoredCopies []*VendoredCopy
+
+func (vc vendoredCopies) Len() int      { return len(vc) }
+func (vc vendoredCopies) Swap(i, j int) { vc[i], vc[j] = vc[j], vc[i] }
+func (vc vendoredCopies) Less(i, j int) bool {
+	if vc[i].Root != vc[j].Root {
+		return vc[i].Root < vc[j].Root
+	}
+	return vc[i].Dir < vc[j].Dir
+}
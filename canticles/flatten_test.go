@@ -0,0 +1,122 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type pickRevision string
+
+func (pr pickRevision) ResolveConflicts(deps *DependencySources) ([]*CanticleDependency, error) {
+	var cdeps []*CanticleDependency
+	for _, source := range deps.Sources {
+		cdeps = append(cdeps, &CanticleDependency{Root: source.Root, Revision: string(pr)})
+	}
+	return cdeps, nil
+}
+
+func setupVendored(t *testing.T) string {
+	gopath, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	files := map[string]string{
+		"a.com/a/a.go":                    "package a",
+		"a.com/a/vendor/b.com/b/b.go":     "package b",
+		"a.com/a/vendor/b.com/b/x/x.go":   "package x",
+		"c.com/c/c.go":                    "package c",
+		"c.com/c/vendor/b.com/b/b.go":     "package b",
+		"c.com/c/sub/vendor/d.com/d/d.go": "package d",
+	}
+	for name, content := range files {
+		path := PackageSource(gopath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating dir: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+	}
+	b, _ := json.Marshal([]*CanticleDependency{{Root: "b.com/b", Revision: "1"}})
+	if err := ioutil.WriteFile(DependencyFile(PackageSource(gopath, "a.com/a")), b, 0644); err != nil {
+		t.Fatalf("Error writing Canticle file: %s", err.Error())
+	}
+	return gopath
+}
+
+func TestFindVendored(t *testing.T) {
+	gopath := setupVendored(t)
+	defer os.RemoveAll(gopath)
+	vf := NewVendorFlattener(gopath, &PreferLocalResolution{})
+	copies, err := vf.FindVendored(context.Background(), []string{"a.com/a", "c.com/c"})
+	if err != nil {
+		t.Fatalf("Error finding vendored copies: %s", err.Error())
+	}
+	expected := []VendoredCopy{
+		{Root: "b.com/b", Vendorer: "a.com/a", Revision: "1", Dir: PackageSource(gopath, "a.com/a/vendor/b.com/b")},
+		{Root: "b.com/b", Vendorer: "c.com/c", Dir: PackageSource(gopath, "c.com/c/vendor/b.com/b")},
+		{Root: "d.com/d", Vendorer: "c.com/c", Dir: PackageSource(gopath, "c.com/c/sub/vendor/d.com/d")},
+	}
+	if len(copies) != len(expected) {
+		t.Fatalf("Expected %d copies got %d", len(expected), len(copies))
+	}
+	for i, c := range copies {
+		e := expected[i]
+		if c.Root != e.Root || c.Vendorer != e.Vendorer || c.Revision != e.Revision || c.Dir != e.Dir {
+			t.Errorf("Expected copy %+v got %+v", e, c)
+		}
+	}
+	if copies[0].Hash == copies[1].Hash {
+		t.Errorf("Expected different copies to hash differently")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	gopath := setupVendored(t)
+	defer os.RemoveAll(gopath)
+	vf := NewVendorFlattener(gopath, pickRevision("1"))
+	choices, err := vf.Flatten(context.Background(), []string{"a.com/a", "c.com/c"})
+	if err != nil {
+		t.Fatalf("Error flattening: %s", err.Error())
+	}
+	if len(choices) != 2 {
+		t.Fatalf("Expected 2 choices got %v", choices)
+	}
+	expected := "b.com/b kept at 1 from " + PackageSource(gopath, "a.com/a/vendor/b.com/b") + ", removed 1 copies vendored by c.com/c"
+	if choices[0].String() != expected {
+		t.Errorf("Expected choice %s got %s", expected, choices[0].String())
+	}
+	for _, path := range []string{"b.com/b/x/x.go", "d.com/d/d.go"} {
+		if _, err := os.Stat(PackageSource(gopath, path)); err != nil {
+			t.Errorf("Expected %s moved to the top level got %s", path, err.Error())
+		}
+	}
+	for _, path := range []string{"a.com/a/vendor/b.com/b", "c.com/c/vendor/b.com/b", "c.com/c/sub/vendor/d.com/d"} {
+		if _, err := os.Stat(PackageSource(gopath, path)); err == nil {
+			t.Errorf("Expected %s removed", path)
+		}
+	}
+
+	// Copies of a dependency at the top level are removed, those
+	// identical counted
+	for _, vendorer := range []string{"a.com/a", "c.com/c"} {
+		path := PackageSource(gopath, vendorer+"/vendor/d.com/d/d.go")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("package d"), 0644)
+	}
+	vf.DryRun = true
+	choices, err = vf.Flatten(context.Background(), []string{"a.com/a", "c.com/c"})
+	if err != nil {
+		t.Fatalf("Error flattening: %s", err.Error())
+	}
+	if len(choices) != 1 || choices[0].Kept != PackageSource(gopath, "d.com/d") || choices[0].Identical != 1 {
+		t.Errorf("Expected the top level d.com/d kept and 1 identical copy got %+v", choices)
+	}
+	if _, err := os.Stat(PackageSource(gopath, "a.com/a/vendor/d.com/d")); err != nil {
+		t.Errorf("Expected dry run to not remove copies got %s", err.Error())
+	}
+}
@@ -0,0 +1,199 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An FS is the filesystem packages and Canticle files are read from
+// and written to. Paths are the same as on the real disk.
+type FS interface {
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the entries of dirname sorted by name.
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// OSFS is the FS of the real disk.
+type OSFS struct{}
+
+// Stat calls os.Stat.
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+// ReadDir calls ioutil.ReadDir.
+func (OSFS) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
+
+// ReadFile calls ioutil.ReadFile.
+func (OSFS) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+
+// WriteFile calls ioutil.WriteFile.
+func (OSFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+// MkdirAll calls os.MkdirAll.
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+// DefaultFS is the FS used by anything not given one of its own.
+var DefaultFS FS = OSFS{}
+
+// fsOr returns fsys, or the DefaultFS if it is nil.
+func fsOr(fsys FS) FS {
+	if fsys == nil {
+		return DefaultFS
+	}
+	return fsys
+}
+
+// A MemFS is an FS held in memory, safe for concurrent use. The zero
+// value is empty except for the root directory.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	path    string
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f *memFile) Name() string       { return filepath.Base(f.path) }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() os.FileMode  { return f.mode }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *memFile) Sys() interface{}   { return nil }
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{}
+}
+
+// lookup returns the file at name, the caller must hold mu.
+func (m *MemFS) lookup(name string) (*memFile, bool) {
+	name = filepath.Clean(name)
+	if name == string(filepath.Separator) || name == "." {
+		return &memFile{path: name, mode: os.ModeDir | 0755}, true
+	}
+	f, ok := m.files[name]
+	return f, ok
+}
+
+// Stat returns the FileInfo of name.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.lookup(name)
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// ReadDir returns the files directly in dirname.
+func (m *MemFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, ok := m.lookup(dirname)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: dirname, Err: os.ErrNotExist}
+	}
+	if !dir.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: dirname, Err: errNotDir}
+	}
+	dirname = filepath.Clean(dirname)
+	var infos []os.FileInfo
+	for name, f := range m.files {
+		if filepath.Dir(name) == dirname && name != dirname {
+			infos = append(infos, f)
+		}
+	}
+	sort.Sort(fileInfosByName(infos))
+	return infos, nil
+}
+
+// ReadFile returns the contents of the file name.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.lookup(name)
+	switch {
+	case !ok:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case f.IsDir():
+		return nil, &os.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return append([]byte{}, f.data...), nil
+}
+
+// WriteFile writes data to the file name, whose directory must exist.
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, ok := m.lookup(filepath.Dir(name))
+	switch {
+	case !ok:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !dir.IsDir():
+		return &os.PathError{Op: "open", Path: name, Err: errNotDir}
+	}
+	if f, ok := m.lookup(name); ok && f.IsDir() {
+		return &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	m.put(&memFile{path: filepath.Clean(name), data: append([]byte{}, data...), mode: perm.Perm()})
+	return nil
+}
+
+// MkdirAll creates the directory path and any missing parents.
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path = filepath.Clean(path)
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		f, ok := m.lookup(p)
+		if ok && !f.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: p, Err: errNotDir}
+		}
+		if ok {
+			break
+		}
+		missing = append(missing, p)
+	}
+	for _, p := range missing {
+		m.put(&memFile{path: p, mode: os.ModeDir | perm.Perm()})
+	}
+	return nil
+}
+
+// put adds f, the caller must hold mu.
+func (m *MemFS) put(f *memFile) {
+	if m.files == nil {
+		m.files = make(map[string]*memFile)
+	}
+	f.modTime = time.Now()
+	m.files[f.path] = f
+}
+
+var (
+	errNotDir = osError("not a directory")
+	errIsDir  = osError("is a directory")
+)
+
+type osError string
+
+func (e osError) Error() string { return string(e) }
+
+type fileInfosByName []os.FileInfo
+
+func (fi fileInfosByName) Len() int           { return len(fi) }
+func (fi fileInfosByName) Less(i, j int) bool { return fi[i].Name() < fi[j].Name() }
+func (fi fileInfosByName) Swap(i, j int)      { fi[i], fi[j] = fi[j], fi[i] }
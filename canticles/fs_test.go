@@ -0,0 +1,98 @@
+package canticles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	fsys := NewMemFS()
+	dir := filepath.Join("/gopath", "src", "test.com", "project")
+	if err := fsys.WriteFile(filepath.Join(dir, "Canticle"), []byte("[]"), 0644); !os.IsNotExist(err) {
+		t.Errorf("Expected writing into a missing dir to fail got %v", err)
+	}
+	if err := fsys.MkdirAll(filepath.Join(dir, "b"), 0755); err != nil {
+		t.Fatalf("Error making dirs %s", err.Error())
+	}
+	if err := fsys.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatalf("Error making dirs %s", err.Error())
+	}
+	if err := fsys.WriteFile(filepath.Join(dir, "Canticle"), []byte("[]"), 0644); err != nil {
+		t.Fatalf("Error writing file %s", err.Error())
+	}
+	b, err := fsys.ReadFile(filepath.Join(dir, "Canticle"))
+	if err != nil || string(b) != "[]" {
+		t.Errorf("Expected file contents [] got %s %v", b, err)
+	}
+	if _, err := fsys.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Expected missing file to not exist got %v", err)
+	}
+	s, err := fsys.Stat(filepath.Join(dir, "a"))
+	if err != nil || !s.IsDir() || s.Name() != "a" {
+		t.Errorf("Expected dir a got %v %v", s, err)
+	}
+	if err := fsys.MkdirAll(filepath.Join(dir, "Canticle", "x"), 0755); err == nil {
+		t.Errorf("Expected error making a dir under a file")
+	}
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading dir %s", err.Error())
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if expected := []string{"Canticle", "a", "b"}; !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected entries %v got %v", expected, names)
+	}
+	subdirs, err := VisibleSubDirectories(fsys, dir)
+	if expected := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}; err != nil || !reflect.DeepEqual(expected, subdirs) {
+		t.Errorf("Expected subdirs %v got %v %v", expected, subdirs, err)
+	}
+}
+
+func TestDependencySaverMemFS(t *testing.T) {
+	gopath := "/gopath"
+	fsys := NewMemFS()
+	root := PackageSource(gopath, "test.com/project")
+	for _, pkg := range []string{"test.com/project/a", "test.com/project/.hidden", "dep.com/lib"} {
+		if err := fsys.MkdirAll(PackageSource(gopath, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fsys.WriteFile(DependencyFile(PackageSource(gopath, "dep.com/lib")), []byte(`[{"Root": "dep.com/x", "Revision": "abc"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read := func(ctx context.Context, p string) (Dependencies, error) {
+		deps := NewDependencies()
+		if p == filepath.Join(root, "a") {
+			deps.AddDeps("dep.com/lib")
+		}
+		return deps, nil
+	}
+	ds := NewDependencySaver(read, gopath, root)
+	ds.FS = fsys
+	dw := NewDependencyWalker(ds.PackagePaths, ds.SavePackageDeps)
+	if err := dw.TraverseDependencies(context.Background(), root); err != nil {
+		t.Fatalf("Error traversing deps: %s", err.Error())
+	}
+	var saved []string
+	for pkg := range ds.Dependencies() {
+		saved = append(saved, pkg)
+	}
+	sort.Strings(saved)
+	expected := []string{"dep.com/lib", "test.com/project", "test.com/project/a"}
+	if !reflect.DeepEqual(expected, saved) {
+		t.Errorf("Expected deps %v got %v", expected, saved)
+	}
+
+	dr := &DepReader{Gopath: gopath, FS: fsys}
+	cdeps, err := dr.CanticleDependencies("dep.com/lib")
+	if err != nil || len(cdeps) != 1 || cdeps[0].Root != "dep.com/x" {
+		t.Errorf("Expected Canticle file read from the FS got %v %v", cdeps, err)
+	}
+}
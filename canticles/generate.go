@@ -0,0 +1,129 @@
+package canticles
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ToolsGroup is the Group of dependencies only needed to run
+// go:generate tools.
+const ToolsGroup = "tools"
+
+// KnownGenerators maps the command names of common go:generate tools
+// to the import path they are installed from.
+var KnownGenerators = map[string]string{
+	"stringer": "golang.org/x/tools/cmd/stringer",
+	"goyacc":   "golang.org/x/tools/cmd/goyacc",
+	"mockgen":  "github.com/golang/mock/mockgen",
+}
+
+// GenerateTool returns the import path of the tool run by the
+// arguments of a go:generate directive, or the empty string if it
+// does not reference a remote tool. Tools are found from "go run" and
+// "go install" arguments and the KnownGenerators.
+func GenerateTool(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	if args[0] == "go" && len(args) > 2 && (args[1] == "run" || args[1] == "install") {
+		for _, arg := range args[2:] {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			tool := strings.SplitN(arg, "@", 2)[0]
+			if IsRemote(tool) {
+				return tool
+			}
+			return ""
+		}
+		return ""
+	}
+	return KnownGenerators[args[0]]
+}
+
+// FileGenerateTools returns the import paths of the tools referenced
+// by the go:generate directives in a go file.
+func FileGenerateTools(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tools []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "//go:generate ") {
+			continue
+		}
+		if tool := GenerateTool(strings.Fields(strings.TrimPrefix(line, "//go:generate "))); tool != "" {
+			tools = append(tools, tool)
+		}
+	}
+	return tools, s.Err()
+}
+
+// GenerateTools returns the sorted import paths of the tools
+// referenced by go:generate directives in the packages go files.
+func (p *Package) GenerateTools() ([]string, error) {
+	tools := NewStringSet()
+	for _, files := range [][]string{p.GoFiles, p.CgoFiles, p.TestGoFiles, p.XTestGoFiles} {
+		for _, name := range files {
+			fileTools, err := FileGenerateTools(filepath.Join(p.Dir, name))
+			if err != nil {
+				return nil, err
+			}
+			tools.Add(fileTools...)
+		}
+	}
+	return tools.Array(), nil
+}
+
+// MarkTools sets the Tools of each cdep containing a go:generate tool
+// in deps. Cdeps whose packages other than tools are only imported
+// from within the cdep itself are placed in the ToolsGroup.
+func MarkTools(deps Dependencies, cdeps []*CanticleDependency) {
+	for _, cdep := range cdeps {
+		var tools []string
+		toolOnly := true
+		for _, importPath := range deps.PackagesUnder(cdep.Root) {
+			dep := deps[importPath]
+			if dep.Tool {
+				tools = append(tools, importPath)
+				continue
+			}
+			for importer := range dep.ImportedFrom {
+				if importer != cdep.Root && !PathIsChild(cdep.Root, importer) {
+					toolOnly = false
+				}
+			}
+		}
+		if len(tools) == 0 {
+			continue
+		}
+		cdep.Tools = tools
+		if toolOnly {
+			cdep.Group = ToolsGroup
+		}
+	}
+}
+
+// InstallTools runs go install in gopath for the Tools of cdeps.
+func InstallTools(ctx context.Context, gopath string, cdeps []*CanticleDependency) error {
+	for _, cdep := range cdeps {
+		for _, tool := range cdep.Tools {
+			LogInfoContext(ctx, "Installing tool %s", tool)
+			cmd := exec.CommandContext(ctx, "go", "install", tool)
+			cmd.Env = GoEnviroment(gopath)
+			if result, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("cant install tool %s %s", tool, string(result))
+			}
+		}
+	}
+	return nil
+}
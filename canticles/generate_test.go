@@ -0,0 +1,63 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTool(t *testing.T) {
+	cases := map[string]string{
+		"stringer -type=Pill":                         "golang.org/x/tools/cmd/stringer",
+		"go run github.com/a/gen -out x.go":           "github.com/a/gen",
+		"go run -mod=mod github.com/a/gen@v1.2.0 arg": "github.com/a/gen",
+		"go run gen.go":                               "",
+		"sh -c true":                                  "",
+	}
+	for directive, expected := range cases {
+		if tool := GenerateTool(strings.Fields(directive)); tool != expected {
+			t.Errorf("Expected tool %q for %s got %q", expected, directive, tool)
+		}
+	}
+}
+
+func TestFileGenerateTools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	src := "package pkg\n\n//go:generate stringer -type=Pill\n//go:generate go run github.com/a/gen\n// go:generate mockgen\n"
+	if err := ioutil.WriteFile(path.Join(dir, "pkg.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Package{Dir: dir, GoFiles: []string{"pkg.go"}}
+	tools, err := pkg.GenerateTools()
+	if err != nil {
+		t.Fatalf("Error reading generate tools: %s", err.Error())
+	}
+	expected := []string{"github.com/a/gen", "golang.org/x/tools/cmd/stringer"}
+	if !reflect.DeepEqual(expected, tools) {
+		t.Errorf("Expected tools %v got %v", expected, tools)
+	}
+}
+
+func TestMarkTools(t *testing.T) {
+	deps := NewDependencies()
+	deps.AddDeps("golang.org/x/tools/cmd/stringer", "golang.org/x/tools/go/packages", "github.com/a/gen", "github.com/a/gen/lib")
+	deps["golang.org/x/tools/cmd/stringer"].Tool = true
+	deps["golang.org/x/tools/go/packages"].ImportedFrom.Add("golang.org/x/tools/cmd/stringer")
+	deps["github.com/a/gen"].Tool = true
+	deps["github.com/a/gen/lib"].ImportedFrom.Add("test.com/proj")
+	cdeps := []*CanticleDependency{{Root: "golang.org/x/tools"}, {Root: "github.com/a/gen"}}
+	MarkTools(deps, cdeps)
+	if cdeps[0].Group != ToolsGroup || !reflect.DeepEqual([]string{"golang.org/x/tools/cmd/stringer"}, cdeps[0].Tools) {
+		t.Errorf("Expected x/tools to be a tools group dep with stringer got %+v", cdeps[0])
+	}
+	if cdeps[1].Group != "" || !reflect.DeepEqual([]string{"github.com/a/gen"}, cdeps[1].Tools) {
+		t.Errorf("Expected gen to have a tool but no group got %+v", cdeps[1])
+	}
+}
@@ -1,15 +1,35 @@
 package canticles
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type GenVersion struct {
 	flags   *flag.FlagSet
 	Verbose bool
-	Stable  bool
+	Stamp   bool
+	Omit    string
+
+	Dir       string
+	Tags      string
+	Binaries  string
+	Template  string
+	Out       string
+	Package   string
+	Var       string
+	Constants EnvFlags
+
+	LDFlags string
+	Build   bool
+
+	Manifest     string
+	ManifestOnly bool
 }
 
 func NewGenVersion() *GenVersion {
@@ -18,7 +38,21 @@ func NewGenVersion() *GenVersion {
 		flags: f,
 	}
 	f.BoolVar(&v.Verbose, "v", false, "Be verbose when getting stuff")
-	f.BoolVar(&v.Stable, "stable", false, "When true, not generate date or host build info so builds can be stable")
+	f.BoolVar(&v.Stamp, "stamp", false, "Also generate the build time, user and host, which change on every build")
+	f.Bool("stable", true, "Deprecated, build info is stable unless -stamp is set")
+	f.StringVar(&v.Dir, "dir", "buildinfo", "The directory, relative to path, to generate the package in")
+	f.StringVar(&v.Binaries, "binaries", "", "Generate a package in every main package under this directory, e.g. cmd, instead of in path")
+	f.StringVar(&v.Tags, "tags", "", "A build constraint, e.g. release, for the generated info.go")
+	f.StringVar(&v.Template, "template", "", "A text/template file to generate the build info with instead of the default layout")
+	f.StringVar(&v.Out, "out", filepath.Join("buildinfo", "info.go"), "The file, relative to path, to write with -template")
+	f.StringVar(&v.Package, "package", "buildinfo", "The name of the generated package")
+	f.StringVar(&v.Var, "var", "", "The name of the generated variable, buildInfo or BuildInfo with -template by default")
+	f.Var(&v.Constants, "const", "A NAME=VALUE constant passed to -template, may be repeated")
+	f.StringVar(&v.LDFlags, "ldflags", "", "Print the go build -ldflags stamping the build info into this package instead of generating files")
+	f.BoolVar(&v.Build, "build", false, "With -ldflags, run go build with the stamping flags and any remaining args")
+	f.StringVar(&v.Manifest, "manifest", "", "Also write the build info and dependency pins to this .json or .yaml file")
+	f.BoolVar(&v.ManifestOnly, "manifest-only", false, "Only write the -manifest file, not the go files")
+	f.StringVar(&v.Omit, "omit", "", "Comma separated build info fields to not generate: time,user,host,goversion,platform,version,dirty")
 	return v
 }
 
@@ -26,16 +60,38 @@ var genversion = NewGenVersion()
 
 var GenVersionCommand = &Command{
 	Name:             "genversion",
-	UsageLine:        "genversion [-v] [path]",
+	UsageLine:        "genversion [-v] [-stamp] [-omit <fields>] [-dir <dir>] [-package <name>] [-var <name>] [-tags <constraint>] [-binaries <dir>] [-template <file> [-out <file>] [-package <name>] [-var <name>] [-const NAME=VALUE]] [-manifest <file> [-manifest-only]] [-ldflags <pkg> [-build [build args]]]",
 	ShortDescription: "Generate a version go package containing revision of all current dependencies.",
 	LongDescription: `The genversion command will generate a package containing all deps from the current path for use in reporting version information in built applications.
 
-Specify -v to print out a verbose set of operations instead of just errors.`,
+The generated info includes the revision, git describe style version and dirty state of the project, and the go version and GOOS/GOARCH used.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -stamp to also generate the build time, user and host. They change on every build, so without -stamp identical inputs generate identical files: dependencies are sorted, and with -stamp the build time is in UTC, taken from SOURCE_DATE_EPOCH when set. -stable, the default, is still accepted.
+
+Specify -omit goversion,dirty to not generate the listed fields, which may be any of time, user, host, goversion, platform, version and dirty.
+
+Specify -dir internal/version -package version to generate the package somewhere other than buildinfo, -var to name its unexported variable and -tags release to only set the build info in builds with that build constraint.
+
+Specify -binaries cmd in a repo with several binaries to generate the package in every main package under cmd instead, e.g. cmd/server/buildinfo. Each records the name of its binary as the Target and only the dependencies it imports.
+
+Specify -template to generate a single go file, -out, from your own text/template instead. The template is executed with the BuildInfo fields as well as .Package, .Var and .Constants, a map of the -const values. The quote function returns a go string literal, e.g.:
+
+  package {{.Package}}
+
+  const {{.Var}}Revision = {{quote .Revision}}
+  {{range $name, $value := .Constants}}
+  const {{$name}} = {{quote $value}}{{end}}
+
+Specify -manifest release/buildinfo.json to also write the build info, including every dependency pin, to a file for attaching to releases or deployment tooling. Files ending in .yaml or .yml are written as YAML. Add -manifest-only to skip generating go files.
+
+Specify -ldflags main to print the value of a go build -ldflags flag which sets string variables in the given package, named after the fields of BuildInfo (e.g. main.Revision), instead of generating any files. Dirty is set to "true" or "false" and CanticleDeps to json. Flags with spaces or quotes are single quoted as for a POSIX shell. Add -build to run go build with these flags and any remaining arguments, e.g. cant genversion -ldflags main -build -o bin/app.`,
 	Flags: genversion.flags,
 	Cmd:   genversion,
 }
 
-func (g *GenVersion) Run(args []string) {
+func (g *GenVersion) Run(ctx context.Context, args []string) {
 	if g.Verbose {
 		Verbose = true
 	}
@@ -44,49 +100,207 @@ func (g *GenVersion) Run(args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := g.SaveProjectDeps(wd); err != nil {
+	if g.LDFlags != "" {
+		if err := g.StampProject(ctx, wd, g.flags.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := g.SaveProjectDeps(ctx, wd); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func (g *GenVersion) SaveProjectDeps(path string) error {
+func (g *GenVersion) SaveProjectDeps(ctx context.Context, path string) error {
 	gopath, err := EnvGoPath()
 	if err != nil {
 		return err
 	}
-	s := NewSave()
-	s.Resolver = &PreferLocalResolution{}
-	deps, err := s.ReadDeps(gopath, path)
+	bi, err := g.ProjectBuildInfo(ctx, gopath, path)
 	if err != nil {
 		return err
 	}
-	sources, err := s.GetSources(gopath, path, deps)
+	if g.Manifest != "" {
+		LogVerboseContext(ctx, "Writing manifest to:%s", g.Manifest)
+		if err := WriteManifest(g.Manifest, bi); err != nil {
+			return err
+		}
+		if g.ManifestOnly {
+			return nil
+		}
+	}
+	if g.Template != "" {
+		return g.WriteTemplate(path, bi)
+	}
+	if g.Binaries != "" {
+		return g.StampBinaries(ctx, gopath, path)
+	}
+	pkgdir := filepath.Join(path, g.Dir)
+	LogVerboseContext(ctx, "Writing version files to:%s", pkgdir)
+	return g.BuildInfoFile(bi).WriteFiles(pkgdir)
+}
+
+// BuildInfoFile returns the generated package layout for bi given the
+// Package, Var and Tags options.
+func (g *GenVersion) BuildInfoFile(bi *BuildInfo) *BuildInfoFile {
+	bf := NewBuildInfoFile(bi)
+	if g.Package != "" {
+		bf.Package = g.Package
+	}
+	if g.Var != "" {
+		bf.Var = g.Var
+	}
+	bf.BuildTags = g.Tags
+	return bf
+}
+
+// StampProject prints the -ldflags value for the LDFlags package or,
+// if Build is set, runs go build in path with them and args.
+func (g *GenVersion) StampProject(ctx context.Context, path string, args []string) error {
+	gopath, err := EnvGoPath()
 	if err != nil {
 		return err
 	}
-	LogVerbose("Discovered sources:\n%+v", sources)
-	cantdeps, err := s.Resolver.ResolveConflicts(sources)
+	bi, err := g.ProjectBuildInfo(ctx, gopath, path)
 	if err != nil {
 		return err
 	}
+	if g.Build {
+		return bi.GoBuild(ctx, gopath, path, g.LDFlags, args...)
+	}
+	fmt.Println(bi.LDFlagsString(g.LDFlags))
+	return nil
+}
+
+// ProjectBuildInfo reads the build info for the project at path.
+func (g *GenVersion) ProjectBuildInfo(ctx context.Context, gopath, path string) (*BuildInfo, error) {
+	_, cantdeps, err := g.projectDeps(ctx, gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	return g.buildInfo(ctx, gopath, path, cantdeps)
+}
+
+// projectDeps reads the dep tree of the project at path and resolves
+// the revisions of its dependencies.
+func (g *GenVersion) projectDeps(ctx context.Context, gopath, path string) (Dependencies, []*CanticleDependency, error) {
+	s := NewSave()
+	s.Resolver = &PreferLocalResolution{}
+	deps, err := s.ReadDeps(ctx, gopath, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources, err := s.GetSources(ctx, gopath, path, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+	LogVerboseContext(ctx, "Discovered sources:\n%+v", sources)
+	cantdeps, err := s.Resolver.ResolveConflicts(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	LogVerboseContext(ctx, "Resolved conflicts:\n%+v", cantdeps)
+	return deps, cantdeps, nil
+}
+
+// buildInfo returns the build info of the project at path built with
+// cantdeps.
+func (g *GenVersion) buildInfo(ctx context.Context, gopath, path string, cantdeps []*CanticleDependency) (*BuildInfo, error) {
 	r := &LocalRepoResolver{LocalPath: gopath}
 	pkg, err := PackageName(gopath, path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	v, err := r.ResolveRepo(pkg, nil)
+	v, err := r.ResolveRepo(ctx, pkg, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	rev, err := v.GetRev(ctx)
+	if err != nil {
+		return nil, err
 	}
-	rev, err := v.GetRev()
+	bi, err := NewBuildInfo(ctx, rev, g.Stamp, cantdeps)
+	if err != nil {
+		return nil, err
+	}
+	if lv, ok := v.(*LocalVCS); ok {
+		if bi.Dirty, err = lv.IsDirty(ctx); err != nil {
+			LogWarnContext(ctx, "Not recording dirty state: %s", err.Error())
+		}
+		if bi.Version, err = lv.Describe(ctx); err != nil {
+			LogWarnContext(ctx, "Not recording version: %s", err.Error())
+		}
+	}
+	if g.Omit != "" {
+		if err := bi.Omit(strings.Split(g.Omit, ",")...); err != nil {
+			return nil, err
+		}
+	}
+	return bi, nil
+}
+
+// StampBinaries generates the buildinfo package of every main
+// package under the Binaries directory of path. Each records its
+// binary as the Target and only the dependencies its import closure
+// requires.
+func (g *GenVersion) StampBinaries(ctx context.Context, gopath, path string) error {
+	deps, cantdeps, err := g.projectDeps(ctx, gopath, path)
 	if err != nil {
 		return err
 	}
-	LogVerbose("Resolved conflicts:\n%+v", cantdeps)
-	bi, err := NewBuildInfo(rev, g.Stable, cantdeps)
+	pkg, err := PackageName(gopath, path)
 	if err != nil {
 		return err
 	}
-	LogVerbose("Writing version files to:%s", path)
-	return bi.WriteFiles(path)
+	under := pkg + "/" + filepath.ToSlash(g.Binaries)
+	stamped := 0
+	for main, roots := range BinaryRoots(deps, cantdeps) {
+		if !PathIsChild(under, main) {
+			continue
+		}
+		bindeps := make([]*CanticleDependency, 0, len(roots))
+		for _, root := range roots {
+			bindeps = append(bindeps, CoveringDependency(cantdeps, root))
+		}
+		bi, err := g.buildInfo(ctx, gopath, path, bindeps)
+		if err != nil {
+			return err
+		}
+		bi.Target = main[strings.LastIndex(main, "/")+1:]
+		pkgdir := filepath.Join(PackageSource(gopath, main), g.Dir)
+		LogVerboseContext(ctx, "Writing version files for %s to:%s", main, pkgdir)
+		if err := g.BuildInfoFile(bi).WriteFiles(pkgdir); err != nil {
+			return err
+		}
+		stamped++
+	}
+	if stamped == 0 {
+		return fmt.Errorf("cant find any main packages under %s", under)
+	}
+	return nil
+}
+
+// WriteTemplate generates the Out file in path from the users
+// Template.
+func (g *GenVersion) WriteTemplate(path string, bi *BuildInfo) error {
+	tmpl, err := ParseBuildInfoTemplate(g.Template)
+	if err != nil {
+		return fmt.Errorf("cant read build info template %s %s", g.Template, err.Error())
+	}
+	bf := &BuildInfoFile{
+		BuildInfo: bi,
+		Package:   g.Package,
+		Var:       g.Var,
+		Constants: make(map[string]string, len(g.Constants)),
+	}
+	if bf.Var == "" {
+		bf.Var = "BuildInfo"
+	}
+	for _, c := range g.Constants {
+		parts := strings.SplitN(c, "=", 2)
+		bf.Constants[parts[0]] = parts[1]
+	}
+	out := filepath.Join(path, g.Out)
+	LogVerbose("Writing version file to:%s", out)
+	return bf.WriteGoFile(out, tmpl)
 }
@@ -0,0 +1,70 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestStampBinaries(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"test.com/proj/cmd/server/main.go": "package main\nimport _ \"dep.com/server/pkg\"\nfunc main() {}\n",
+		"test.com/proj/cmd/client/main.go": "package main\nimport _ \"dep.com/client\"\nfunc main() {}\n",
+		"dep.com/server/pkg/a.go":          "package pkg\n",
+		"dep.com/client/a.go":              "package client\n",
+	}
+	for name, src := range files {
+		p := PackageSource(testHome, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range []string{"test.com/proj", "dep.com/server", "dep.com/client"} {
+		for _, args := range [][]string{
+			{"init"},
+			{"add", "."},
+			{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = PackageSource(testHome, root)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+			}
+		}
+	}
+
+	g := NewGenVersion()
+	g.Binaries = "cmd"
+	proj := PackageSource(testHome, "test.com/proj")
+	if err := g.StampBinaries(context.Background(), testHome, proj); err != nil {
+		t.Fatalf("Error stamping binaries: %s", err.Error())
+	}
+	binaries := map[string]string{"server": "dep.com/server", "client": "dep.com/client"}
+	for target, dep := range binaries {
+		b, err := ioutil.ReadFile(path.Join(proj, "cmd", target, "buildinfo", "info.go"))
+		if err != nil {
+			t.Fatalf("Error reading stamped info for %s: %s", target, err.Error())
+		}
+		info := strings.Join(strings.Fields(string(b)), " ")
+		if !strings.Contains(info, `Target: "`+target+`"`) {
+			t.Errorf("Expected %s to be stamped with its target got:\n%s", target, info)
+		}
+		for other, otherDep := range binaries {
+			if contains := strings.Contains(info, `"`+otherDep+`":`); contains != (other == target) {
+				t.Errorf("Expected %s to only record dependency %s got:\n%s", target, dep, info)
+			}
+		}
+	}
+}
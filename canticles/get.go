@@ -1,27 +1,58 @@
 package canticles
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"path/filepath"
 )
 
 type Get struct {
-	flags   *flag.FlagSet
-	Verbose bool
-	Update  bool
-	Source  string
-	Limit   int
+	flags      *flag.FlagSet
+	Verbose    bool
+	Update     bool
+	Source     string
+	Limit      int
+	Group      string
+	Signed     StringSet
+	GPGHome    string
+	SignedKeys StringSet
+
+	// Isolated fetches into the isolated gopath of each package,
+	// see IsolatedProject.
+	Isolated bool
+	// Context, if set, is the BuildContext directory packages are
+	// copied and fetched into. Image, if set, is the container
+	// image the fetch is run in.
+	Context string
+	Image   string
+
+	AllowViolations bool
+	Provenance      string
+
+	// Gopath, if set, is fetched into instead of the gopath of
+	// EnvGoPath.
+	Gopath string
 }
 
 func NewGet() *Get {
 	f := flag.NewFlagSet("get", flag.ExitOnError)
-	g := &Get{flags: f}
+	g := &Get{flags: f, Signed: NewStringSet(), SignedKeys: NewStringSet()}
 	f.BoolVar(&g.Verbose, "v", false, "Be verbose when getting stuff")
 	f.BoolVar(&g.Update, "u", false, "Update branches where possible, print the results")
 	f.StringVar(&g.Source, "source", "", "Overide the VCS url to fetch this from")
 	f.IntVar(&g.Limit, "limit", 10, "Limit the number of fetches in flight at once to limit")
+	f.Var(g.Signed, "signed", "Require this dependency root, or * for all, to be a GPG signed git tag or commit, may be repeated")
+	f.StringVar(&g.GPGHome, "gpghome", "", "The GNUPGHOME holding the keys -signed dependencies are verified against")
+	f.Var(g.SignedKeys, "signed-key", "Trust only the key with this fingerprint to sign -signed dependencies, may be repeated")
+	f.BoolVar(&g.AllowViolations, "allow-violations", false, "Fetch dependencies forbidden by the source policy, recording them as exceptions")
+	f.StringVar(&g.Provenance, "provenance", "", "Append the source, revision, tree hash and resolution of every fetched dependency to this file")
+	f.BoolVar(&g.Isolated, "isolated", false, "Fetch into the projects own gopath in .canticle/gopath, used by cant build, test and exec")
+	f.StringVar(&g.Context, "context", "", "Copy the project and fetch its dependencies into a gopath in this directory, for use as a container build context")
+	f.StringVar(&g.Image, "image", "", "With -context, run the fetch in a container of this image with docker")
+	f.StringVar(&g.Group, "group", "", "Only fetch dependencies in this group and install their tools")
 	return g
 }
 
@@ -29,19 +60,57 @@ var get = NewGet()
 
 var GetCommand = &Command{
 	Name:             "get",
-	UsageLine:        "get [-v] [-u] [-source] [-limit <n>]",
+	UsageLine:        "get [-v] [-u] [-source] [-limit <n>] [-group <name>] [-signed <root>] [-gpghome <dir>] [-signed-key <fingerprint>] [-allow-violations] [-provenance <file>] [-isolated] [-context <dir> [-image <image>]]",
 	ShortDescription: "download dependencies as defined in the Canticle file",
 	LongDescription: `The get command fetches dependencies. When issued locally it looks...
 
+Dependencies already checked out at their revision, and source if one is saved, are not fetched again.
+
+Dependencies saved with cant save -hash are verified against their saved hash after being fetched.
+
+If the package contains a Canticle.policy file, a json object with Allow and Deny lists of source prefixes such as github.com/myorg, dependencies whose source it does not allow are not fetched. Its license policy, see cant verify, is checked once dependencies are fetched.
+
 Specify -v to print out a verbose set of operations instead of just errors.
 
-Specify -u to update branches and print results.`,
+Specify -allow-violations to fetch dependencies forbidden by the source policy anyway, recording them as Exceptions in the policy file.
+
+Specify -u to update branches and print results.
+
+Specify -signed github.com/foo/bar, or -signed '*' for every dependency, to fail unless the checked out revision of the dependency is a git tag or commit with a good GPG signature. The trusted keys must be given: those of the -gpghome directory, or only those whose fingerprint is given with -signed-key, which may use the default keyring. The Signed, GPGHome and SignedKeys fields of the projects .canticle.json set these for every fetch.
+
+Dependencies are fetched with git and hg hooks, smudge and clean filters and git templates disabled, so fetching an untrusted repo can not run commands, use cant -allow-hooks get to allow them.
+
+How each dependency is resolved is cached, see cant clean, so fetching it again needs no discovery. Git repos mirrored in the cache by cant warm are cloned from their mirror when it contains the revision fetched.
+
+Dependencies on hosts which serve archives of revisions but can not be cloned may set their SourcePath to gitiles+https://host/repo or cgit+https://host/repo.git. Their Revision is downloaded from the archive endpoint of the Gitiles or cgit frontend, such as https://host/repo/+archive/<revision>.tar.gz, and unpacked. Such dependencies can only be fetched at a revision, they have no branches to update.
+
+Dependencies within a subdirectory of a larger repository set their SourcePath to the repository and their Subpath to the directory holding their Root, e.g. {"Root": "example.com/lib", "SourcePath": "https://git.example.com/big.git", "Subpath": "go/lib"}. The repository is cloned once into $GOPATH/src/.canticle-monorepos, however many dependencies use it, and each Subpath is linked at its Root. Dependencies on one repository must pin the same Revision.
+
+Dependencies whose SourcePath is a .tar.gz, .tgz, .tar or .zip archive, at a http(s) url or local path, are downloaded and unpacked instead of cloned. Archives have no revision to trust, so their Checksum, e.g. "sha256:9f86d0...", the sha256 of the archive, is required and an archive not matching it is not unpacked. A single top level directory in the archive is stripped.
+
+Programs embedding Canticle may register backends for version control systems, or other stores, go get does not know, such as Fossil, with RegisterBackend. Dependencies whose SourcePath has the url scheme of a backend, e.g. fossil://host/repo, or whose Root is under one of its import path prefixes are fetched, and their revision set and read, with the backend.
+
+With cant -secure, or Secure set in the projects .canticle.json, dependencies are only fetched and discovered over encrypted transports. http:// and git:// sources are rewritten to https://, other unencrypted sources are refused, unless their host is given with cant -plaintext or listed in the PlaintextHosts of the .canticle.json.
+
+Specify -provenance fetched.log to append a line of json to fetched.log for every dependency fetched, recording its source, requested and fetched revision, tree hash, the time and whether it was resolved locally or remotely, as an audit trail.
+
+Specify -isolated to fetch the dependencies into a gopath of the projects own, .canticle/gopath in the project, holding nothing but the project and its pinned dependencies. The project is linked into it at the ImportPath of its .canticle.json, or else its path in the users gopath. cant build, cant test and cant exec build and run the project in it.
+
+Specify -context ../build to copy the project, leaving out hidden files, and fetch its pinned dependencies into ../build/gopath, a gopath holding nothing else, so a container build never depends on the hosts gopath. A .dockerignore keeping VCS metadata out of the image is written to ../build. The directory may be used as a docker build context:
+  COPY gopath /go
+  ENV GOPATH /go
+  WORKDIR /go/src/<import path>
+The import path of the project is the ImportPath of its .canticle.json, or else its path in the users gopath. The directory must be outside the project, or hidden in it such as .build, so neither the copy nor the go tool reads it as part of the project.
+
+Specify -image golang:1.6 with -context to run the fetch in a container of that image with docker, with the build directory mounted at /context. The running cant is mounted into the container as /usr/local/bin/cant so must be able to run there, as a statically linked cant for the same platform can. Only -v and -u are passed on to it.
+
+Specify -group tools to only fetch the dependencies in the tools group and go install the go:generate tools they contain, see cant save -generate.`,
 	Flags: get.flags,
 	Cmd:   get,
 }
 
 // Run the get command. Ignores args.
-func (g *Get) Run(args []string) {
+func (g *Get) Run(ctx context.Context, args []string) {
 	if g.Verbose {
 		Verbose = true
 		defer func() { Verbose = false }()
@@ -53,8 +122,20 @@ func (g *Get) Run(args []string) {
 	}
 	pkgs := ParseCmdLinePackages(pkgArgs)
 	for _, pkg := range pkgs {
-		if err := g.GetPackage(pkg); err != nil {
-			log.Fatal(err)
+		if g.Context != "" {
+			if err := g.GetContext(ctx, pkg); err != nil {
+				log.Fatal(ErrorMessage(err))
+			}
+			continue
+		}
+		if g.Isolated {
+			if err := g.GetIsolated(ctx, pkg); err != nil {
+				log.Fatal(ErrorMessage(err))
+			}
+			continue
+		}
+		if err := g.GetPackage(ctx, pkg); err != nil {
+			log.Fatal(ErrorMessage(err))
 		}
 	}
 }
@@ -67,19 +148,37 @@ func (g *Get) Run(args []string) {
 
 // GetPackage fetches a package and all of it dependencies to either
 // the buildroot or the gopath.
-func (g *Get) GetPackage(path string) error {
-	LogVerbose("Fetching path %+v", path)
-	gopath, err := EnvGoPath()
+func (g *Get) GetPackage(ctx context.Context, path string) error {
+	LogVerboseContext(ctx, "Fetching path %+v", path)
+	gopath := g.Gopath
+	if gopath == "" {
+		var err error
+		if gopath, err = EnvGoPath(); err != nil {
+			return err
+		}
+	}
+	config, err := LoadProjectConfig(path)
 	if err != nil {
 		return err
 	}
-	resolvers := []RepoResolver{
-		&LocalRepoResolver{LocalPath: gopath},
-		&RemoteRepoResolver{gopath},
-		&DefaultRepoResolver{gopath},
+	secure := Secure.Merge(config)
+	fe, err := NewFetchEnv(TLS.Merge(config.TLS, path), secure)
+	if err != nil {
+		return err
+	}
+	defer fe.Close()
+	ctx = fe.Context(ctx)
+	policy, err := LoadSourcePolicy(path)
+	if err != nil {
+		return err
 	}
-	resolver := NewMemoizedRepoResolver(&CompositeRepoResolver{resolvers})
-	depReader := &DepReader{gopath}
+	resolver, remote := NewFetchResolver(gopath, secure, policy, g.AllowViolations)
+	defer func() {
+		if err := remote.Save(); err != nil {
+			LogWarnContext(ctx, "Error saving resolution cache: %s", err.Error())
+		}
+	}()
+	depReader := &DepReader{Gopath: gopath}
 
 	loader := &CanticleDepLoader{
 		Reader:   depReader,
@@ -88,10 +187,41 @@ func (g *Get) GetPackage(path string) error {
 		Update:   g.Update,
 		Limit:    g.Limit,
 	}
-	if errs := loader.FetchPath(path); len(errs) > 0 {
-		for _, err := range errs {
-			return fmt.Errorf("cant load package %s", err.Error())
-		}
+	loader.Policy = policy
+	loader.AllowViolations = g.AllowViolations
+	if g.Provenance != "" {
+		loader.Provenance = &ProvenanceLog{File: g.Provenance}
+	}
+	loader.Signed = NewStringSet()
+	loader.Signed.Union(g.Signed)
+	loader.Signed.Add(config.Signed...)
+	loader.GPGHome = g.GPGHome
+	if loader.GPGHome == "" && config.GPGHome != "" {
+		loader.GPGHome = filepath.Join(path, config.GPGHome)
+	}
+	keys := NewStringSet()
+	keys.Union(g.SignedKeys)
+	keys.Add(config.SignedKeys...)
+	loader.SignedKeys = keys.Array()
+	if len(loader.Signed) > 0 && loader.GPGHome == "" && len(loader.SignedKeys) == 0 {
+		return fmt.Errorf("cant verify -signed dependencies without trusted keys, specify -gpghome or -signed-key")
+	}
+	if g.Group != "" {
+		return g.GetGroup(ctx, loader, gopath, path)
+	}
+	if errs := loader.FetchPath(ctx, path); len(errs) > 0 {
+		return fetchErrors(ctx, errs)
+	}
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return err
+	}
+	cdeps, err := depReader.CanticleDependencies(pkg)
+	if err != nil {
+		return fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	if err := VerifyLicenses(gopath, path, cdeps); err != nil {
+		return err
 	}
 	if g.Update {
 		b, err := json.Marshal(loader.Updated())
@@ -102,3 +232,115 @@ func (g *Get) GetPackage(path string) error {
 	}
 	return nil
 }
+
+// GetIsolated fetches the dependencies of the project in dir into its
+// isolated gopath, linking the project into it.
+func (g *Get) GetIsolated(ctx context.Context, dir string) error {
+	ip, err := NewIsolatedProject(dir)
+	if err != nil {
+		return err
+	}
+	if err := ip.Link(); err != nil {
+		return err
+	}
+	LogVerboseContext(ctx, "Fetching %s into isolated gopath %s", ip.ImportPath, ip.Gopath)
+	isolated := *g
+	isolated.Gopath = ip.Gopath
+	return isolated.GetPackage(ctx, ip.Src())
+}
+
+// GetContext copies the project in dir into the BuildContext in the
+// Context directory, and fetches its dependencies there, in a
+// container of the Image if set.
+func (g *Get) GetContext(ctx context.Context, dir string) error {
+	ip, err := NewIsolatedProject(dir)
+	if err != nil {
+		return err
+	}
+	contextDir, err := filepath.Abs(g.Context)
+	if err != nil {
+		return err
+	}
+	bc := &BuildContext{Dir: contextDir, ImportPath: ip.ImportPath}
+	LogVerboseContext(ctx, "Copying %s into build context %s", ip.Dir, bc.Dir)
+	if err := bc.CopyProject(ip.Dir); err != nil {
+		return err
+	}
+	if err := bc.WriteDockerIgnore(); err != nil {
+		return err
+	}
+	if g.Image != "" {
+		var args []string
+		if g.Verbose {
+			args = append(args, "-v")
+		}
+		if g.Update {
+			args = append(args, "-u")
+		}
+		return bc.RunDockerFetch(ctx, g.Image, args...)
+	}
+	hermetic := *g
+	hermetic.Gopath = bc.Gopath()
+	return hermetic.GetPackage(ctx, bc.Src())
+}
+
+// NewFetchResolver returns the resolver get and vendor fetch with:
+// registered backends, then archives, then raw sources, then repos on
+// disk in gopath, then remote repos, all subject to secure. Repos
+// whose source is not allowed by policy, which may be nil, are
+// refused unless allow is set, see PolicyRepoResolver. Resolutions of
+// remote repos are cached in the returned CachedRepoResolver, which
+// should be saved once done.
+func NewFetchResolver(gopath string, secure *SecureMode, policy *SourcePolicy, allow bool) (RepoResolver, *CachedRepoResolver) {
+	remote := NewCachedRepoResolver(&CompositeRepoResolver{[]RepoResolver{
+		&RemoteRepoResolver{gopath},
+		&DefaultRepoResolver{gopath},
+	}}, gopath)
+	resolvers := []RepoResolver{
+		&BackendRepoResolver{gopath},
+		&ArchiveRepoResolver{gopath},
+		&RawSourceRepoResolver{gopath},
+		NewMonorepoRepoResolver(gopath),
+		&LocalRepoResolver{LocalPath: gopath},
+		remote,
+	}
+	return NewMemoizedRepoResolver(policy.Resolver(secure.Resolver(&CompositeRepoResolver{resolvers}), allow)), remote
+}
+
+// GetGroup fetches only the dependencies of path in the Group and
+// installs their tools.
+func (g *Get) GetGroup(ctx context.Context, loader *CanticleDepLoader, gopath, path string) error {
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return err
+	}
+	cdeps, err := loader.Reader.CanticleDependencies(pkg)
+	if err != nil {
+		return fmt.Errorf("cant fetch package %s couldn't read cant file %s", pkg, err.Error())
+	}
+	var group []*CanticleDependency
+	for _, cdep := range cdeps {
+		if cdep.Group == g.Group {
+			group = append(group, cdep)
+		}
+	}
+	if err := loader.Policy.EnforcePolicy(path, group, loader.AllowViolations); err != nil {
+		return err
+	}
+	if errs := loader.FetchDeps(ctx, group...); len(errs) > 0 {
+		return fetchErrors(ctx, errs)
+	}
+	if err := VerifyLicenses(gopath, path, group); err != nil {
+		return err
+	}
+	return InstallTools(ctx, gopath, group)
+}
+
+// fetchErrors warns of all but the first of errs and returns the first,
+// wrapped so its cause is kept.
+func fetchErrors(ctx context.Context, errs []error) error {
+	for _, err := range errs[1:] {
+		LogWarnContext(ctx, "%s", ErrorMessage(err))
+	}
+	return fmt.Errorf("cant load package %w", errs[0])
+}
@@ -0,0 +1,282 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitHookMarker marks the git hooks written by cant hooks, only hooks
+// with it are replaced or removed.
+const GitHookMarker = "# Installed by cant hooks"
+
+// GitHookNames are the git hooks cant hooks installs.
+var GitHookNames = []string{"pre-commit", "pre-push"}
+
+// GitHookProjectMarker starts the lines of each project a hook checks,
+// so the projects of a monorepo share its hooks.
+const GitHookProjectMarker = "# cant hooks project "
+
+// GitHookProject returns the lines of the git hook name checking the
+// project at rel within the repo. They run cant status -check, pre-push
+// also cant verify if verify is true.
+func GitHookProject(name, rel string, verify bool) string {
+	rel = filepath.ToSlash(rel)
+	checks := "cant status -check"
+	if verify && name == "pre-push" {
+		checks += " && cant verify"
+	}
+	return fmt.Sprintf("%s%s\n(cd \"$(git rev-parse --show-toplevel)/%s\" && %s) || exit 1\n", GitHookProjectMarker, rel, rel, checks)
+}
+
+// GitHookScript returns the script of a git hook running the
+// GitHookProject lines of projects in order. The hooks do nothing if
+// $CI or $CANT_SKIP_HOOKS is set.
+func GitHookScript(projects []string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#!/bin/sh\n%s, remove with cant hooks uninstall\n", GitHookMarker)
+	fmt.Fprintf(&buf, "if [ -n \"$CI\" ] || [ -n \"$CANT_SKIP_HOOKS\" ]; then\n\texit 0\nfi\n")
+	for _, project := range projects {
+		buf.WriteString(project)
+	}
+	return buf.String()
+}
+
+// hookProjects returns the projects checked by the hook script, in
+// order, and their GitHookProject lines.
+func hookProjects(script string) ([]string, map[string]string) {
+	var rels []string
+	projects := make(map[string]string)
+	rel := ""
+	for _, line := range strings.SplitAfter(script, "\n") {
+		if strings.HasPrefix(line, GitHookProjectMarker) {
+			rel = strings.TrimSpace(strings.TrimPrefix(line, GitHookProjectMarker))
+			if _, ok := projects[rel]; !ok {
+				rels = append(rels, rel)
+			}
+			projects[rel] = ""
+		}
+		if rel != "" {
+			projects[rel] += line
+		}
+	}
+	return rels, projects
+}
+
+// hookLines returns the GitHookProject lines of rels in projects.
+func hookLines(rels []string, projects map[string]string) []string {
+	lines := make([]string, 0, len(rels))
+	for _, rel := range rels {
+		lines = append(lines, projects[rel])
+	}
+	return lines
+}
+
+// gitOutput runs git with args in dir returning its trimmed output.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := command(ctx, dir, "git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitHooks installs and removes the git hooks checking the project in
+// Dir.
+type GitHooks struct {
+	Dir string
+	// Verify runs cant verify before pushing too.
+	Verify bool
+	// Force replaces hooks not installed by cant hooks.
+	Force bool
+}
+
+// paths returns the hooks directory of the repo of the project and
+// the path of the project within it.
+func (gh *GitHooks) paths(ctx context.Context) (hooks, rel string, err error) {
+	top, err := gitOutput(ctx, gh.Dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", "", fmt.Errorf("cant find git repo of %s %s", gh.Dir, err.Error())
+	}
+	// --git-path respects core.hooksPath
+	if hooks, err = gitOutput(ctx, gh.Dir, "rev-parse", "--git-path", "hooks"); err != nil {
+		return "", "", fmt.Errorf("cant find git hooks of %s %s", gh.Dir, err.Error())
+	}
+	if !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(gh.Dir, hooks)
+	}
+	if rel, err = filepath.Rel(EvalPath(top), EvalPath(gh.Dir)); err != nil {
+		return "", "", err
+	}
+	return hooks, rel, nil
+}
+
+// ours returns true if the hook at file was written by cant hooks.
+func ours(file string) bool {
+	b, err := ioutil.ReadFile(file)
+	return err == nil && bytes.Contains(b, []byte(GitHookMarker))
+}
+
+// writeHook writes the hook script to file.
+func writeHook(file, script string) error {
+	if err := ioutil.WriteFile(file, []byte(script), 0755); err != nil {
+		return fmt.Errorf("cant write git hook %s", err.Error())
+	}
+	// WriteFile keeps the mode of existing files
+	return os.Chmod(file, 0755)
+}
+
+// Install adds the project to the GitHookNames hooks, returning the
+// files written. The checks of other projects of the repo in the hooks
+// are kept. Hooks of other tools are not replaced unless Force is set.
+func (gh *GitHooks) Install(ctx context.Context) ([]string, error) {
+	hooks, rel, err := gh.paths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range GitHookNames {
+		file := filepath.Join(hooks, name)
+		if _, err := os.Stat(file); err == nil && !ours(file) && !gh.Force {
+			return nil, fmt.Errorf("cant install git hook %s, it was not installed by cant hooks, use -f to replace it", file)
+		}
+	}
+	if err := os.MkdirAll(hooks, 0755); err != nil {
+		return nil, fmt.Errorf("cant create git hooks dir %s", err.Error())
+	}
+	var written []string
+	for _, name := range GitHookNames {
+		file := filepath.Join(hooks, name)
+		var rels []string
+		projects := make(map[string]string)
+		if ours(file) {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return written, fmt.Errorf("cant read git hook %s", err.Error())
+			}
+			rels, projects = hookProjects(string(b))
+		}
+		key := filepath.ToSlash(rel)
+		if _, ok := projects[key]; !ok {
+			rels = append(rels, key)
+		}
+		projects[key] = GitHookProject(name, rel, gh.Verify)
+		if err := writeHook(file, GitHookScript(hookLines(rels, projects))); err != nil {
+			return written, err
+		}
+		written = append(written, file)
+	}
+	return written, nil
+}
+
+// Uninstall removes the project from the hooks written by Install,
+// returning the files changed. Hooks left without projects are removed,
+// hooks of other tools are kept.
+func (gh *GitHooks) Uninstall(ctx context.Context) ([]string, error) {
+	hooks, rel, err := gh.paths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := filepath.ToSlash(rel)
+	var changed []string
+	for _, name := range GitHookNames {
+		file := filepath.Join(hooks, name)
+		if !ours(file) {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return changed, fmt.Errorf("cant read git hook %s", err.Error())
+		}
+		rels, projects := hookProjects(string(b))
+		if _, ok := projects[key]; !ok && len(rels) > 0 {
+			continue
+		}
+		delete(projects, key)
+		var kept []string
+		for _, r := range rels {
+			if r != key {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) > 0 {
+			if err := writeHook(file, GitHookScript(hookLines(kept, projects))); err != nil {
+				return changed, err
+			}
+		} else if err := os.Remove(file); err != nil {
+			return changed, fmt.Errorf("cant remove git hook %s", err.Error())
+		}
+		changed = append(changed, file)
+	}
+	return changed, nil
+}
+
+type Hooks struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Verify  bool
+	Force   bool
+}
+
+func NewHooks() *Hooks {
+	f := flag.NewFlagSet("hooks", flag.ExitOnError)
+	h := &Hooks{flags: f}
+	f.BoolVar(&h.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&h.Verify, "verify", false, "Also run cant verify before pushing")
+	f.BoolVar(&h.Force, "f", false, "Replace hooks not installed by cant hooks")
+	return h
+}
+
+var hooks = NewHooks()
+
+var HooksCommand = &Command{
+	Name:             "hooks",
+	UsageLine:        "hooks [-v] [-verify] [-f] install|uninstall",
+	ShortDescription: "Install git hooks catching dependency drift.",
+	LongDescription: `The hooks command installs, or uninstalls, pre-commit and pre-push git hooks in the git repo of the project in the current directory. The hooks run cant status -check in the project, so commits and pushes fail while a dependency on disk is missing or not at the revision pinned in the Canticle file.
+
+The hooks do nothing when $CI or $CANT_SKIP_HOOKS is set, and may be skipped once with git commit --no-verify. The hooks directory of core.hooksPath is used if it is set.
+
+Each project of a monorepo may install the hooks, they then check every project installed. Uninstall only removes the checks of the project in the current directory.
+
+Hooks not installed by cant hooks are never removed, and are not replaced unless -f is given.
+
+Specify -verify to also run cant verify in the pre-push hook.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: hooks.flags,
+	Cmd:   hooks,
+}
+
+// Run the hooks command.
+func (h *Hooks) Run(ctx context.Context, args []string) {
+	if h.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gh := &GitHooks{Dir: wd, Verify: h.Verify, Force: h.Force}
+	var files []string
+	switch h.flags.Arg(0) {
+	case "install":
+		files, err = gh.Install(ctx)
+	case "uninstall":
+		files, err = gh.Uninstall(ctx)
+	default:
+		HooksCommand.Usage()
+	}
+	for _, file := range files {
+		LogInfoContext(ctx, "%sed %s", h.flags.Arg(0), file)
+	}
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+}
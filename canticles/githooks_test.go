@@ -0,0 +1,122 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitHooks(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	project := filepath.Join(testHome, "repo", "project")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "init")
+	cmd.Dir = filepath.Join(testHome, "repo")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error running git init %s %s", err.Error(), string(out))
+	}
+	hooksDir := filepath.Join(testHome, "repo", ".git", "hooks")
+	os.MkdirAll(hooksDir, 0755)
+	foreign := filepath.Join(hooksDir, "pre-push")
+	if err := ioutil.WriteFile(foreign, []byte("#!/bin/sh\nlint\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	gh := &GitHooks{Dir: project, Verify: true}
+	if _, err := gh.Install(context.Background()); err == nil {
+		t.Errorf("Expected error replacing a foreign hook")
+	}
+	gh.Force = true
+	written, err := gh.Install(context.Background())
+	if err != nil || len(written) != 2 {
+		t.Fatalf("Expected hooks installed got %v %v", written, err)
+	}
+	b, err := ioutil.ReadFile(foreign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{GitHookMarker, `cd "$(git rev-parse --show-toplevel)/project"`, "cant status -check", "cant verify", "$CANT_SKIP_HOOKS"} {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("Expected pre-push hook to contain %s got %s", line, string(b))
+		}
+	}
+	if s, err := os.Stat(filepath.Join(hooksDir, "pre-commit")); err != nil || s.Mode()&0100 == 0 {
+		t.Errorf("Expected executable pre-commit hook got %v", err)
+	}
+	if strings.Contains(GitHookProject("pre-commit", ".", true), "cant verify") {
+		t.Errorf("Expected only pre-push to verify")
+	}
+
+	ioutil.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh\nlint\n"), 0755)
+	removed, err := gh.Uninstall(context.Background())
+	if err != nil || len(removed) != 1 || removed[0] != foreign {
+		t.Errorf("Expected only our pre-push hook removed got %v %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(hooksDir, "pre-commit")); err != nil {
+		t.Errorf("Expected foreign pre-commit hook kept got %s", err.Error())
+	}
+}
+
+func TestGitHooksMonorepo(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	repo := filepath.Join(testHome, "repo")
+	for _, dir := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(repo, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("git", "init")
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error running git init %s %s", err.Error(), string(out))
+	}
+	ctx := context.Background()
+	a := &GitHooks{Dir: filepath.Join(repo, "a")}
+	b := &GitHooks{Dir: filepath.Join(repo, "b"), Verify: true}
+	for _, gh := range []*GitHooks{a, b, a} {
+		if _, err := gh.Install(ctx); err != nil {
+			t.Fatalf("Expected hooks installed got %s", err.Error())
+		}
+	}
+	prePush := filepath.Join(repo, ".git", "hooks", "pre-push")
+	script, err := ioutil.ReadFile(prePush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"/a\" && cant status -check)", "/b\" && cant status -check && cant verify)"} {
+		if strings.Count(string(script), line) != 1 {
+			t.Errorf("Expected pre-push hook to contain %s once got %s", line, string(script))
+		}
+	}
+
+	if _, err := a.Uninstall(ctx); err != nil {
+		t.Fatal(err)
+	}
+	script, err = ioutil.ReadFile(prePush)
+	if err != nil {
+		t.Fatalf("Expected hook of b kept got %s", err.Error())
+	}
+	if strings.Contains(string(script), "/a\"") || !strings.Contains(string(script), "/b\"") {
+		t.Errorf("Expected only the checks of a removed got %s", string(script))
+	}
+	if _, err := b.Uninstall(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(prePush); !os.IsNotExist(err) {
+		t.Errorf("Expected hook without projects removed got %v", err)
+	}
+}
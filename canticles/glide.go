@@ -0,0 +1,326 @@
+package canticles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GlideFile and GlideLockFile are the dependency files of glide.
+const (
+	GlideFile     = "glide.yaml"
+	GlideLockFile = "glide.lock"
+)
+
+// A GlidePackage is a package entry of glide.yaml, or glide.lock where
+// it is keyed by name rather than package.
+type GlidePackage struct {
+	Package string
+	// Version is a revision, branch, tag or semantic version
+	// range, exact in glide.lock.
+	Version string
+	// Repo overrides the repo the package is fetched from.
+	Repo        string
+	VCS         string
+	Subpackages []string
+}
+
+// ReadGlide returns the dependencies of the glide.yaml file of the
+// project at path, pinned at the versions of its glide.lock if there
+// is one. Versions of glide.yaml which are ranges are returned as the
+// Constraint of the dependency.
+func ReadGlide(path string) ([]*ImportedDependency, error) {
+	config, err := readGlideFile(filepath.Join(path, GlideFile), "package", "import", "testImport")
+	if err != nil {
+		return nil, err
+	}
+	locked, err := readGlideFile(filepath.Join(path, GlideLockFile), "name", "imports", "testImports")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries := config
+	if err == nil {
+		entries = locked
+	}
+	constraints := make(map[string]string)
+	for _, pkg := range config {
+		if isGlideRange(pkg.Version) {
+			constraints[pkg.Package] = pkg.Version
+		}
+	}
+	deps := make([]*ImportedDependency, 0, len(entries))
+	for _, pkg := range entries {
+		dep := &ImportedDependency{
+			ImportPath: pkg.Package,
+			SourcePath: pkg.Repo,
+			Constraint: constraints[pkg.Package],
+		}
+		if !isGlideRange(pkg.Version) {
+			dep.Revision = pkg.Version
+		}
+		for _, sub := range pkg.Subpackages {
+			dep.Subpackages = append(dep.Subpackages, pkg.Package+"/"+strings.Trim(sub, "/"))
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// isGlideRange returns true if version is a semantic version range
+// rather than a revision, branch or tag.
+func isGlideRange(version string) bool {
+	if !strings.ContainsAny(version, "^~<>=!*|, ") && !strings.HasSuffix(version, ".x") {
+		return false
+	}
+	_, err := ParseConstraint(version)
+	return err == nil
+}
+
+// readGlideFile returns the package entries, keyed by key, of the
+// lists named in the glide file.
+func readGlideFile(file, key string, lists ...string) ([]*GlidePackage, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("cant decode %s %s", file, err.Error())
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cant decode %s, it is not a mapping", file)
+	}
+	var pkgs []*GlidePackage
+	for _, list := range lists {
+		entries, _ := m[list].([]interface{})
+		for _, entry := range entries {
+			e, ok := entry.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cant decode %s, %s has an entry which is not a mapping", file, list)
+			}
+			pkg := &GlidePackage{
+				Package: yamlString(e[key]),
+				Version: yamlString(e["version"]),
+				Repo:    yamlString(e["repo"]),
+				VCS:     yamlString(e["vcs"]),
+			}
+			if pkg.Package == "" {
+				return nil, fmt.Errorf("cant decode %s, %s has an entry without a %s", file, list, key)
+			}
+			subs, _ := e["subpackages"].([]interface{})
+			for _, sub := range subs {
+				pkg.Subpackages = append(pkg.Subpackages, yamlString(sub))
+			}
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+func yamlString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// yamlLine is a line of a YAML document without its indentation.
+type yamlLine struct {
+	indent int
+	text   string
+	n      int
+}
+
+// parseYAML parses the block style subset of YAML written by glide,
+// mappings and sequences of plain or quoted scalars, into maps of
+// string to value, slices of values and strings.
+func parseYAML(doc string) (interface{}, error) {
+	var lines []yamlLine
+	for i, line := range strings.Split(doc, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d is indented with a tab", i+1)
+		}
+		lines = append(lines, yamlLine{len(line) - len(text), text, i + 1})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, fmt.Errorf("line %d is not indented as expected", lines[p.i].n)
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a # comment outside quotes from line.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the sequence or mapping at indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLItem(p.lines[p.i].text) {
+		line := p.lines[p.i]
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if item == "" {
+			p.i++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		if _, _, ok := splitYAMLKey(item); ok || isYAMLItem(item) {
+			// The item is a block starting on the line of its
+			// dash, parse it from there
+			p.lines[p.i] = yamlLine{indent + len(line.text) - len(item), item, line.n}
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		v, err := parseYAMLScalar(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d %s", line.n, err.Error())
+		}
+		list = append(list, v)
+		p.i++
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && !isYAMLItem(p.lines[p.i].text) {
+		line := p.lines[p.i]
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d is not a key: value pair", line.n)
+		}
+		p.i++
+		if value != "" {
+			v, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d %s", line.n, err.Error())
+			}
+			m[key] = v
+			continue
+		}
+		// Sequences may be at the indentation of their key
+		v, err := p.nested(indent, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// nested parses the block under a line at indent, nil if there is
+// none.
+func (p *yamlParser) nested(indent int, sameIndentItems bool) (interface{}, error) {
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.i]
+	switch {
+	case next.indent > indent:
+		return p.block(next.indent)
+	case sameIndentItems && next.indent == indent && isYAMLItem(next.text):
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// splitYAMLKey splits a "key: value" or "key:" line.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true
+}
+
+// parseYAMLScalar returns the value of a scalar, or of an empty or flow
+// sequence of scalars such as [a, b].
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+		list := []interface{}{}
+		for _, item := range strings.Split(text[1:len(text)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "\""):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("cant unquote %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("cant unquote %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	return text, nil
+}
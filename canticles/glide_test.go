@@ -0,0 +1,108 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testGlideYAML = `package: example.com/project
+# Dependencies
+import:
+- package: github.com/a/b
+  version: ^1.2.0
+  subpackages:
+  - sub
+  - other/pkg
+- package: golang.org/x/net
+  repo: https://mirror.example.com/net.git   # a mirror
+  vcs: git
+  version: master
+testImport:
+  - package: "github.com/stretchr/testify"
+    subpackages: [assert]
+`
+
+const testGlideLock = `hash: 1234
+updated: 2016-10-01T10:00:00Z
+imports:
+- name: github.com/a/b
+  version: abc
+  subpackages:
+  - sub
+  - other/pkg
+- name: golang.org/x/net
+  version: def
+  repo: https://mirror.example.com/net.git
+testImports: []
+`
+
+func TestParseYAML(t *testing.T) {
+	v, err := parseYAML("a: 1\nb:\n  c: 'it''s'\n  d:\n  - x\n  - - y\n    - z\n  -\n    e: \"q#\" # comment\ne: [f, g]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"a": "1",
+		"b": map[string]interface{}{
+			"c": "it's",
+			"d": []interface{}{"x", []interface{}{"y", "z"}, map[string]interface{}{"e": "q#"}},
+		},
+		"e": []interface{}{"f", "g"},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %v got %v", expected, v)
+	}
+	for _, bad := range []string{"a: 1\n  b: 2\n", "a: \"x\n", "just text\n"} {
+		if _, err := parseYAML(bad); err == nil {
+			t.Errorf("Expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestReadGlide(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, GlideFile), []byte(testGlideYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a lock the versions of glide.yaml are used
+	deps, err := ReadGlide(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*ImportedDependency{
+		{ImportPath: "github.com/a/b", Constraint: "^1.2.0", Subpackages: []string{"github.com/a/b/sub", "github.com/a/b/other/pkg"}},
+		{ImportPath: "golang.org/x/net", Revision: "master", SourcePath: "https://mirror.example.com/net.git"},
+		{ImportPath: "github.com/stretchr/testify", Subpackages: []string{"github.com/stretchr/testify/assert"}},
+	}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("Expected glide.yaml deps %+v got %+v", expected, deps)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, GlideLockFile), []byte(testGlideLock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver := &TestResolver{ResolvePaths: map[string]*TestVCSResolve{
+		"github.com/a/b":   {V: &TestVCS{Root: "github.com/a/b"}},
+		"golang.org/x/net": {V: &TestVCS{Root: "golang.org/x/net"}},
+	}}
+	cdeps, err := (&Import{}).Import(context.Background(), resolver, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCDeps := []*CanticleDependency{
+		{Root: "github.com/a/b", Revision: "abc", Constraint: "^1.2.0", Packages: []string{"github.com/a/b/other/pkg", "github.com/a/b/sub"}},
+		{Root: "golang.org/x/net", Revision: "def", SourcePath: "https://mirror.example.com/net.git"},
+	}
+	if !reflect.DeepEqual(cdeps, expectedCDeps) {
+		t.Errorf("Expected glide.lock deps %+v got %+v", expectedCDeps, cdeps)
+	}
+}
@@ -0,0 +1,412 @@
+package canticles
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A ModuleRequirement is a dependency as a Go module.
+type ModuleRequirement struct {
+	// Path is the module path, the Root of the dependency or the
+	// path declared by its go.mod.
+	Path    string
+	Version string
+	// Sum and GoModSum are the go.sum hashes of the module and of
+	// its go.mod.
+	Sum, GoModSum string
+}
+
+// PseudoVersion returns the Go pseudo-version of rev committed at t,
+// following base, the highest semantic version tagged before it, or
+// major.0.0 if base is nil.
+func PseudoVersion(base *Version, major int, t time.Time, rev string) string {
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	stamp := t.UTC().Format("20060102150405") + "-" + rev
+	switch {
+	case base == nil:
+		return fmt.Sprintf("v%d.0.0-%s", major, stamp)
+	case base.Pre != "":
+		return fmt.Sprintf("v%s.0.%s", base, stamp)
+	}
+	return fmt.Sprintf("v%d.%d.%d-0.%s", base.Major, base.Minor, base.Patch+1, stamp)
+}
+
+// pathMajor returns the major version required of a module by its
+// path, e.g. 2 for example.com/a/v2 or gopkg.in/yaml.v2, and false if
+// any of v0 and v1 will do.
+func pathMajor(modPath string) (int, bool) {
+	i := strings.LastIndexAny(modPath, "/.")
+	if i < 0 || i+2 >= len(modPath) || modPath[i+1] != 'v' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(modPath[i+2:])
+	if err != nil {
+		return 0, false
+	}
+	if strings.HasPrefix(modPath, "gopkg.in/") && modPath[i] == '.' {
+		return n, true
+	}
+	if modPath[i] == '/' && n >= 2 {
+		return n, true
+	}
+	return 0, false
+}
+
+// moduleVersion returns the version of the module at path for rev, a
+// tagged version if rev is tagged, otherwise a pseudo-version. tags
+// maps the tags reachable from rev to their revision. Modules without
+// a go.mod may use major versions above 1 as +incompatible.
+func moduleVersion(modPath string, hasGoMod bool, tags map[string]string, rev string, t time.Time) string {
+	major, strict := pathMajor(modPath)
+	var tagged, base *Version
+	for tag, tagRev := range tags {
+		v, err := ParseVersion(tag)
+		if err != nil || tag != "v"+v.String() {
+			continue
+		}
+		if (strict && v.Major != major) || (!strict && v.Major >= 2 && hasGoMod) {
+			continue
+		}
+		if tagRev == rev && (tagged == nil || v.Compare(tagged) > 0) {
+			tagged = v
+		}
+		if base == nil || v.Compare(base) > 0 {
+			base = v
+		}
+	}
+	v, version := tagged, ""
+	if tagged != nil {
+		version = "v" + tagged.String()
+	} else {
+		v, version = base, PseudoVersion(base, major, t, rev)
+	}
+	// Majors above 1 need a /vN module path, which modules without
+	// a go.mod lack
+	if v != nil && v.Major >= 2 && !strict {
+		version += "+incompatible"
+	}
+	return version
+}
+
+// isVendoredPackage returns true if name is a file of a package in a
+// vendor directory, such files are left out of modules.
+func isVendoredPackage(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += j + len("/vendor/")
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// moduleFiles returns the content of the files of a module from a tar
+// archive of its repo, leaving out symlinks, vendored packages and
+// the files of nested modules as go does.
+func moduleFiles(r io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if isVendoredPackage(hdr.Name) {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = b
+	}
+	// Drop the files of directories with their own go.mod
+	for name := range files {
+		if name == "go.mod" || filepath.Base(name) != "go.mod" {
+			continue
+		}
+		nested := strings.TrimSuffix(name, "go.mod")
+		for other := range files {
+			if strings.HasPrefix(other, nested) {
+				delete(files, other)
+			}
+		}
+	}
+	return files, nil
+}
+
+// HashModule returns the go.sum h1: hash of files, named relative to
+// the root of the module at modPath and version.
+func HashModule(modPath, version string, files map[string][]byte) string {
+	return hashFiles(modPath+"@"+version+"/", files)
+}
+
+// HashGoMod returns the go.sum h1: hash of the go.mod of a module.
+func HashGoMod(gomod []byte) string {
+	return hashFiles("", map[string][]byte{"go.mod": gomod})
+}
+
+// hashFiles hashes the sha256 of each file, named with prefix, in
+// order of name.
+func hashFiles(prefix string, files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	summary := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(summary, "%x  %s%s\n", sha256.Sum256(files[name]), prefix, name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil))
+}
+
+// goModPath returns the module path declared by gomod.
+func goModPath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			if path, err := strconv.Unquote(fields[1]); err == nil {
+				return path
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// ResolveModule returns the module of the git repo of root in dir at
+// rev.
+func ResolveModule(ctx context.Context, dir, root, rev string) (*ModuleRequirement, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commit, err := gitOutput(ctx, dir, "rev-parse", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := gitOutput(ctx, dir, "log", "-1", "--format=%ct", commit)
+	if err != nil {
+		return nil, err
+	}
+	secs, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cant read commit time of %s %s", root, err.Error())
+	}
+	// The commit of each tag reachable from commit, annotated tags
+	// dereferenced
+	merged, err := gitOutput(ctx, dir, "for-each-ref", "--merged", commit, "--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, line := range strings.Split(merged, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			tags[fields[0]] = fields[len(fields)-1]
+		}
+	}
+
+	archive := command(ctx, dir, "git", "archive", "--format=tar", commit)
+	out, err := archive.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	archive.Stderr = &stderr
+	if err := archive.Start(); err != nil {
+		return nil, fmt.Errorf("cant archive %s %s", root, err.Error())
+	}
+	files, readErr := moduleFiles(out)
+	io.Copy(ioutil.Discard, out)
+	if err := archive.Wait(); err != nil {
+		return nil, fmt.Errorf("cant archive %s %s", root, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("cant read archive of %s %s", root, readErr.Error())
+	}
+
+	mod := &ModuleRequirement{Path: root}
+	gomod, hasGoMod := files["go.mod"]
+	if hasGoMod {
+		if path := goModPath(gomod); path != "" {
+			mod.Path = path
+		}
+	} else {
+		gomod = []byte("module " + mod.Path + "\n")
+	}
+	mod.Version = moduleVersion(mod.Path, hasGoMod, tags, commit, time.Unix(secs, 0))
+	mod.Sum = HashModule(mod.Path, mod.Version, files)
+	mod.GoModSum = HashGoMod(gomod)
+	return mod, nil
+}
+
+// WriteGoMod writes a go.mod for module requiring mods to w.
+func WriteGoMod(w io.Writer, module string, mods []*ModuleRequirement) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "module %s\n", module)
+	if len(mods) > 0 {
+		fmt.Fprintf(&buf, "\nrequire (\n")
+		for _, mod := range mods {
+			fmt.Fprintf(&buf, "\t%s %s\n", mod.Path, mod.Version)
+		}
+		fmt.Fprintf(&buf, ")\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteGoSum writes the go.sum lines of mods to w.
+func WriteGoSum(w io.Writer, mods []*ModuleRequirement) error {
+	var buf bytes.Buffer
+	for _, mod := range mods {
+		fmt.Fprintf(&buf, "%s %s %s\n", mod.Path, mod.Version, mod.Sum)
+		fmt.Fprintf(&buf, "%s %s/go.mod %s\n", mod.Path, mod.Version, mod.GoModSum)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type modulesByPath []*ModuleRequirement
+
+func (m modulesByPath) Len() int           { return len(m) }
+func (m modulesByPath) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m modulesByPath) Less(i, j int) bool { return m[i].Path < m[j].Path }
+
+type GoMod struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	DryRun  bool
+	Force   bool
+}
+
+func NewGoMod() *GoMod {
+	f := flag.NewFlagSet("gomod", flag.ExitOnError)
+	g := &GoMod{flags: f}
+	f.BoolVar(&g.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&g.DryRun, "d", false, "Don't write go.mod and go.sum, just print them.")
+	f.BoolVar(&g.Force, "f", false, "Overwrite an existing go.mod and go.sum")
+	return g
+}
+
+var gomod = NewGoMod()
+
+var GoModCommand = &Command{
+	Name:             "gomod",
+	UsageLine:        "gomod [-v] [-d] [-f]",
+	ShortDescription: "Export the Canticle file of a project to go.mod and go.sum.",
+	LongDescription: `The gomod command writes a go.mod requiring the dependencies of the Canticle file of the project in the current directory, at their revisions, and a go.sum with their hashes, so the project can move to Go modules while the Canticle file stays the source of truth. Run it again after cant save to keep them in sync.
+
+Each dependency must be a git repo in the gopath, see cant get. Its module path is its root, or the path declared by its go.mod. A revision tagged with a semantic version, such as v1.2.0, is required at that version, other revisions at the pseudo-version go would use, e.g. v1.2.1-0.20161001100000-abcdefabcdef. Dependencies without a go.mod tagged v2 or later are required as +incompatible.
+
+The hashes are computed from the revision's files as go does, go mod verify checks them once the modules are downloaded. The SourcePath of dependencies is not exported, mirrors need a replace directive or GOPROXY.
+
+Specify -d to print go.mod and go.sum instead of writing them.
+
+Specify -f to overwrite an existing go.mod and go.sum.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: gomod.flags,
+	Cmd:   gomod,
+}
+
+// Run the gomod command.
+func (g *GoMod) Run(ctx context.Context, args []string) {
+	if g.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := g.Export(ctx, gopath, wd); err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+}
+
+// Modules returns the module of each dependency of the Canticle file
+// of the project at path, sorted by module path.
+func (g *GoMod) Modules(ctx context.Context, gopath, path string) ([]*ModuleRequirement, error) {
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	var mods []*ModuleRequirement
+	for _, cdep := range cdeps {
+		LogVerboseContext(ctx, "Exporting %s at %s", cdep.Root, cdep.Revision)
+		mod, err := ResolveModule(ctx, PackageSource(gopath, cdep.Root), cdep.Root, cdep.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("cant export %s %s", cdep.Root, err.Error())
+		}
+		mods = append(mods, mod)
+	}
+	sort.Sort(modulesByPath(mods))
+	return mods, nil
+}
+
+// Export writes the go.mod and go.sum of the project at path.
+func (g *GoMod) Export(ctx context.Context, gopath, path string) error {
+	modFile, sumFile := filepath.Join(path, "go.mod"), filepath.Join(path, "go.sum")
+	if _, err := os.Stat(modFile); err == nil && !g.Force && !g.DryRun {
+		return fmt.Errorf("cant export to %s, it already exists, use -f to overwrite it", modFile)
+	}
+	config, err := LoadProjectConfig(path)
+	if err != nil {
+		return err
+	}
+	module := config.ImportPath
+	if module == "" {
+		if module, err = PackageName(gopath, path); err != nil {
+			return fmt.Errorf("cant find module path of %s %s", path, err.Error())
+		}
+	}
+	mods, err := g.Modules(ctx, gopath, path)
+	if err != nil {
+		return err
+	}
+	var mod, sum bytes.Buffer
+	if err := WriteGoMod(&mod, module, mods); err != nil {
+		return err
+	}
+	if err := WriteGoSum(&sum, mods); err != nil {
+		return err
+	}
+	if g.DryRun {
+		fmt.Print(mod.String())
+		fmt.Println()
+		fmt.Print(sum.String())
+		return nil
+	}
+	if err := ioutil.WriteFile(modFile, mod.Bytes(), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sumFile, sum.Bytes(), 0644)
+}
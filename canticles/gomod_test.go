@@ -0,0 +1,138 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPseudoVersion(t *testing.T) {
+	at := time.Date(2016, 10, 1, 10, 0, 0, 0, time.UTC)
+	rev := "abcdefabcdef0123456789"
+	tests := []struct {
+		base     string
+		major    int
+		expected string
+	}{
+		{"", 0, "v0.0.0-20161001100000-abcdefabcdef"},
+		{"", 2, "v2.0.0-20161001100000-abcdefabcdef"},
+		{"v1.2.3", 0, "v1.2.4-0.20161001100000-abcdefabcdef"},
+		{"v1.2.3-rc.1", 0, "v1.2.3-rc.1.0.20161001100000-abcdefabcdef"},
+	}
+	for _, test := range tests {
+		var base *Version
+		if test.base != "" {
+			base, _ = ParseVersion(test.base)
+		}
+		if v := PseudoVersion(base, test.major, at, rev); v != test.expected {
+			t.Errorf("Expected pseudo-version %s got %s", test.expected, v)
+		}
+	}
+}
+
+func TestModuleVersion(t *testing.T) {
+	at := time.Date(2016, 10, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		path     string
+		gomod    bool
+		tags     map[string]string
+		rev      string
+		expected string
+	}{
+		{"example.com/a", false, map[string]string{"v1.0.0": "a", "v1.1.0": "b", "1.2.0": "c"}, "b", "v1.1.0"},
+		{"example.com/a", false, map[string]string{"v1.0.0": "a", "release": "b"}, "b", "v1.0.1-0.20161001100000-b"},
+		{"example.com/a", false, map[string]string{"v2.0.0": "a"}, "a", "v2.0.0+incompatible"},
+		{"example.com/a", false, map[string]string{"v2.0.0": "a"}, "b", "v2.0.1-0.20161001100000-b+incompatible"},
+		{"example.com/a", true, map[string]string{"v1.0.0": "a", "v2.0.0": "b"}, "b", "v1.0.1-0.20161001100000-b"},
+		{"example.com/a/v2", true, map[string]string{"v1.0.0": "a", "v2.0.0": "b"}, "b", "v2.0.0"},
+		{"example.com/a/v3", true, map[string]string{"v2.0.0": "b"}, "c", "v3.0.0-20161001100000-c"},
+		{"gopkg.in/yaml.v2", false, map[string]string{"v2.1.0": "a", "v1.0.0": "b"}, "a", "v2.1.0"},
+	}
+	for _, test := range tests {
+		if v := moduleVersion(test.path, test.gomod, test.tags, test.rev, at); v != test.expected {
+			t.Errorf("Expected %s at %s to be %s got %s", test.path, test.rev, test.expected, v)
+		}
+	}
+}
+
+func TestHashGoMod(t *testing.T) {
+	// As in the go.sum of modules requiring golang.org/x/text
+	// before it had a go.mod
+	expected := "h1:NqM8EUOU14njkJ3fqMW+pc6Ldnwhi/IjpwHt7yyuwOQ="
+	if sum := HashGoMod([]byte("module golang.org/x/text\n")); sum != expected {
+		t.Errorf("Expected go.mod hash %s got %s", expected, sum)
+	}
+}
+
+func TestResolveModule(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		cmd.Dir = tmpdir
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE=2016-10-01T10:00:00Z", "GIT_AUTHOR_DATE=2016-10-01T10:00:00Z")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	files := map[string]string{
+		"a.go":             "package a\n",
+		"vendor/b/b.go":    "package b\n",
+		"nested/go.mod":    "module example.com/a/nested\n",
+		"nested/nested.go": "package nested\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(tmpdir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	run("add", ".")
+	run("commit", "-q", "-m", "first")
+	run("tag", "v1.0.0")
+
+	mod, err := ResolveModule(context.Background(), tmpdir, "example.com/a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod.Path != "example.com/a" || mod.Version != "v1.0.0" {
+		t.Errorf("Expected example.com/a v1.0.0 got %s %s", mod.Path, mod.Version)
+	}
+	expected := HashModule("example.com/a", "v1.0.0", map[string][]byte{"a.go": []byte("package a\n")})
+	if mod.Sum != expected {
+		t.Errorf("Expected only a.go hashed %s got %s", expected, mod.Sum)
+	}
+	if mod.GoModSum != HashGoMod([]byte("module example.com/a\n")) {
+		t.Errorf("Expected synthesized go.mod hashed got %s", mod.GoModSum)
+	}
+
+	run("commit", "-q", "--allow-empty", "-m", "second")
+	mod, err = ResolveModule(context.Background(), tmpdir, "example.com/a", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mod.Version, "v1.0.1-0.20161001100000-") {
+		t.Errorf("Expected pseudo-version after v1.0.0 got %s", mod.Version)
+	}
+
+	var buf bytes.Buffer
+	WriteGoMod(&buf, "example.com/project", []*ModuleRequirement{mod})
+	if buf.String() != "module example.com/project\n\nrequire (\n\texample.com/a "+mod.Version+"\n)\n" {
+		t.Errorf("Unexpected go.mod %s", buf.String())
+	}
+	buf.Reset()
+	WriteGoSum(&buf, []*ModuleRequirement{mod})
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "example.com/a "+mod.Version+"/go.mod h1:") {
+		t.Errorf("Unexpected go.sum %s", buf.String())
+	}
+}
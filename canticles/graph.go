@@ -0,0 +1,349 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Graph returns the imports of each package of d.
+func (d Dependencies) Graph() map[string][]string {
+	graph := make(map[string][]string, len(d))
+	for importPath, dep := range d {
+		graph[importPath] = dep.Imports.Array()
+	}
+	return graph
+}
+
+// RootGraph returns graph with each import path replaced by the
+// longest of roots it is under, so cycles between repos show even
+// when their packages do not import each other in a cycle. Paths
+// under no root are kept as is, and imports within a root dropped.
+func RootGraph(graph map[string][]string, roots []string) map[string][]string {
+	rootOf := func(importPath string) string {
+		best := importPath
+		found := false
+		for _, r := range roots {
+			if (r == importPath || PathIsChild(r, importPath)) && (!found || len(r) > len(best)) {
+				best, found = r, true
+			}
+		}
+		return best
+	}
+	sets := make(map[string]StringSet)
+	for importPath, imports := range graph {
+		from := rootOf(importPath)
+		if sets[from] == nil {
+			sets[from] = NewStringSet()
+		}
+		for _, imp := range imports {
+			if to := rootOf(imp); to != from {
+				sets[from].Add(to)
+			}
+		}
+	}
+	rootGraph := make(map[string][]string, len(sets))
+	for root, set := range sets {
+		rootGraph[root] = set.Array()
+	}
+	return rootGraph
+}
+
+// FindCycles returns a cycle of each strongly connected set of nodes
+// in graph, e.g. [a b c a] for a importing b importing c importing a.
+// Each cycle is the shortest from the smallest node of its set, and
+// the cycles are sorted by it.
+func FindCycles(graph map[string][]string) [][]string {
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	// Tarjan's strongly connected components
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+	var connect func(node string)
+	connect = func(node string) {
+		index[node] = len(index)
+		lowlink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range graph[node] {
+			if _, seen := index[next]; !seen {
+				connect(next)
+				if lowlink[next] < lowlink[node] {
+					lowlink[node] = lowlink[next]
+				}
+			} else if onStack[next] && index[next] < lowlink[node] {
+				lowlink[node] = index[next]
+			}
+		}
+		if lowlink[node] != index[node] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == node {
+				break
+			}
+		}
+		components = append(components, component)
+	}
+	for _, node := range nodes {
+		if _, seen := index[node]; !seen {
+			connect(node)
+		}
+	}
+
+	var cycles [][]string
+	for _, component := range components {
+		members := NewStringSet()
+		for _, node := range component {
+			members.Add(node)
+		}
+		start := members.Array()[0]
+		if cycle := shortestCycle(graph, members, start); cycle != nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+	sort.Sort(cyclesByStart(cycles))
+	return cycles
+}
+
+// shortestCycle returns the shortest path from start back to itself
+// through members, or nil if there is none.
+func shortestCycle(graph map[string][]string, members StringSet, start string) []string {
+	prev := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[node] {
+			if next == start {
+				cycle := []string{start}
+				for p := node; p != start; p = prev[p] {
+					cycle = append(cycle, p)
+				}
+				// Reverse the path walked back
+				for i, j := 1, len(cycle)-1; i < j; i, j = i+1, j-1 {
+					cycle[i], cycle[j] = cycle[j], cycle[i]
+				}
+				return append(cycle, start)
+			}
+			if _, seen := prev[next]; seen || !members[next] {
+				continue
+			}
+			prev[next] = node
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+type cyclesByStart [][]string
+
+func (c cyclesByStart) Len() int           { return len(c) }
+func (c cyclesByStart) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c cyclesByStart) Less(i, j int) bool { return c[i][0] < c[j][0] }
+
+// WriteDOT renders graph as a Graphviz DOT digraph to w. Packages
+// are clustered by the longest of the roots of cdeps they are under,
+// each cluster labelled with its root and Revision, so the package
+// each import originates from shows. Packages under no root are left
+// outside the clusters.
+func WriteDOT(w io.Writer, graph map[string][]string, cdeps []*CanticleDependency) error {
+	nodes := NewStringSet()
+	for node, imports := range graph {
+		nodes.Add(node)
+		for _, imp := range imports {
+			nodes.Add(imp)
+		}
+	}
+	clusters := make(map[*CanticleDependency][]string)
+	var loose []string
+	for _, node := range nodes.Array() {
+		var best *CanticleDependency
+		for _, cdep := range cdeps {
+			if (cdep.Root == node || PathIsChild(cdep.Root, node)) && (best == nil || len(cdep.Root) > len(best.Root)) {
+				best = cdep
+			}
+		}
+		if best == nil {
+			loose = append(loose, node)
+			continue
+		}
+		clusters[best] = append(clusters[best], node)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph canticle {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	sorted := append([]*CanticleDependency{}, cdeps...)
+	sort.Sort(CanticleDependencies(sorted))
+	for i, cdep := range sorted {
+		members := clusters[cdep]
+		if len(members) == 0 {
+			continue
+		}
+		label := cdep.Root
+		if cdep.Revision != "" {
+			label += "@" + cdep.Revision
+		}
+		fmt.Fprintf(&buf, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", i, strconv.Quote(label))
+		for _, node := range members {
+			fmt.Fprintf(&buf, "\t\t%s;\n", strconv.Quote(node))
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+	for _, node := range loose {
+		fmt.Fprintf(&buf, "\t%s;\n", strconv.Quote(node))
+	}
+	for _, node := range nodes.Array() {
+		for _, imp := range graph[node] {
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote(node), strconv.Quote(imp))
+		}
+	}
+	fmt.Fprintf(&buf, "}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type Graph struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Cycles  bool
+	Roots   bool
+	DOT     bool
+}
+
+func NewGraph() *Graph {
+	f := flag.NewFlagSet("graph", flag.ExitOnError)
+	g := &Graph{flags: f}
+	f.BoolVar(&g.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&g.Cycles, "cycles", false, "Print only import cycles, exiting 1 if there are any")
+	f.BoolVar(&g.Roots, "roots", false, "Graph the repos of the Canticle file instead of packages")
+	f.BoolVar(&g.DOT, "dot", false, "Print the graph in Graphviz DOT format")
+	return g
+}
+
+var graph = NewGraph()
+
+var GraphCommand = &Command{
+	Name:             "graph",
+	UsageLine:        "graph [-v] [-cycles] [-roots] [-dot]",
+	ShortDescription: "Print the import graph of a project.",
+	LongDescription: `The graph command prints the import graph of the packages of the project in the current directory and their dependencies, one "package import" line per import.
+
+Specify -cycles to print only the import cycles, one "a -> b -> a" line per cycle, exiting with status 1 if there are any.
+
+Specify -roots to graph the repos pinned in the Canticle file, and the project, instead of their packages. Repos importing each other, e.g. a package of repo a importing repo b importing another package of a, can not be updated independently, -roots -cycles finds them.
+
+Specify -dot to print the graph in Graphviz DOT format, with the packages of each repo clustered and labelled with the repo and its revision in the Canticle file, e.g. cant graph -dot | dot -Tsvg > graph.svg.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: graph.flags,
+	Cmd:   graph,
+}
+
+// Run the graph command.
+func (g *Graph) Run(ctx context.Context, args []string) {
+	if g.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	edges, err := g.Edges(ctx, wd)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	if g.DOT && !g.Cycles {
+		roots, err := g.RootDependencies(wd)
+		if err != nil {
+			log.Fatal(ErrorMessage(err))
+		}
+		if err := WriteDOT(os.Stdout, edges, roots); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if !g.Cycles {
+		nodes := make([]string, 0, len(edges))
+		for node := range edges {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			for _, imp := range edges[node] {
+				fmt.Println(node, imp)
+			}
+		}
+		return
+	}
+	cycles := FindCycles(edges)
+	for _, cycle := range cycles {
+		fmt.Println(strings.Join(cycle, " -> "))
+	}
+	if len(cycles) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Edges returns the import graph of the project at path, of its repos
+// if Roots is set.
+func (g *Graph) Edges(ctx context.Context, path string) (map[string][]string, error) {
+	client, err := NewClient(Options{})
+	if err != nil {
+		return nil, err
+	}
+	deps, err := client.ReadDeps(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	edges := deps.Graph()
+	if !g.Roots {
+		return edges, nil
+	}
+	cdeps, err := g.RootDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]string, len(cdeps))
+	for i, cdep := range cdeps {
+		roots[i] = cdep.Root
+	}
+	return RootGraph(edges, roots), nil
+}
+
+// RootDependencies returns the dependencies of the Canticle file of
+// the project at path, and the project itself if it is in the gopath.
+func (g *Graph) RootDependencies(path string) ([]*CanticleDependency, error) {
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		return nil, err
+	}
+	if project, err := PackageName(gopath, path); err == nil {
+		cdeps = append(cdeps, &CanticleDependency{Root: project})
+	}
+	return cdeps, nil
+}
@@ -0,0 +1,102 @@
+package canticles
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFindCycles(t *testing.T) {
+	graph := map[string][]string{
+		"a": {"b"},
+		"b": {"c", "d"},
+		"c": {"a"},
+		"d": {"e"},
+		"e": {"d"},
+		"f": {"f"},
+		"g": {"a"},
+	}
+	expected := [][]string{{"a", "b", "c", "a"}, {"d", "e", "d"}, {"f", "f"}}
+	if cycles := FindCycles(graph); !reflect.DeepEqual(expected, cycles) {
+		t.Errorf("Expected cycles %v got %v", expected, cycles)
+	}
+	if cycles := FindCycles(map[string][]string{"a": {"b"}, "b": {"c"}}); len(cycles) != 0 {
+		t.Errorf("Expected no cycles got %v", cycles)
+	}
+
+	// The shortest cycle from the smallest node is reported
+	graph = map[string][]string{
+		"a": {"b", "x"},
+		"b": {"c"},
+		"c": {"d"},
+		"d": {"a"},
+		"x": {"a"},
+	}
+	expected = [][]string{{"a", "x", "a"}}
+	if cycles := FindCycles(graph); !reflect.DeepEqual(expected, cycles) {
+		t.Errorf("Expected cycles %v got %v", expected, cycles)
+	}
+}
+
+func TestRootGraph(t *testing.T) {
+	deps := NewDependencies()
+	for importPath, imports := range map[string][]string{
+		"example.com/a":     {"example.com/b/x"},
+		"example.com/b/x":   {"example.com/a/sub", "example.com/b/y"},
+		"example.com/a/sub": {"other.com/c"},
+	} {
+		dep := NewDependency(importPath)
+		for _, imp := range imports {
+			dep.Imports.Add(imp)
+		}
+		deps.AddDependency(dep)
+	}
+	graph := RootGraph(deps.Graph(), []string{"example.com/a", "example.com/b"})
+	expected := map[string][]string{
+		"example.com/a": {"example.com/b", "other.com/c"},
+		"example.com/b": {"example.com/a"},
+	}
+	if !reflect.DeepEqual(expected, graph) {
+		t.Errorf("Expected root graph %v got %v", expected, graph)
+	}
+	cycles := FindCycles(graph)
+	if err := (&CycleError{Cycles: cycles}).Error(); err != "import cycle example.com/a -> example.com/b -> example.com/a" {
+		t.Errorf("Expected cycle between repos got %s", err)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	graph := map[string][]string{
+		"example.com/a":   {"example.com/b/x", "other.com/c"},
+		"example.com/b/x": {"example.com/b/y"},
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "example.com/b", Revision: "abc"},
+		{Root: "example.com/a"},
+	}
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, graph, cdeps); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph canticle {
+	rankdir=LR;
+	node [shape=box];
+	subgraph cluster_0 {
+		label="example.com/a";
+		"example.com/a";
+	}
+	subgraph cluster_1 {
+		label="example.com/b@abc";
+		"example.com/b/x";
+		"example.com/b/y";
+	}
+	"other.com/c";
+	"example.com/a" -> "example.com/b/x";
+	"example.com/a" -> "other.com/c";
+	"example.com/b/x" -> "example.com/b/y";
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected DOT\n%s\ngot\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,114 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A BuildContext is a directory holding a copy of a project and its
+// pinned dependencies in a gopath of their own, ready to be COPYed
+// into a container image so the build never uses the hosts gopath.
+type BuildContext struct {
+	// Dir is the directory of the context.
+	Dir string
+	// ImportPath is the import path of the project.
+	ImportPath string
+}
+
+// Gopath returns the gopath of the context.
+func (bc *BuildContext) Gopath() string {
+	return filepath.Join(bc.Dir, "gopath")
+}
+
+// Src returns the directory of the project in the context.
+func (bc *BuildContext) Src() string {
+	return PackageSource(bc.Gopath(), bc.ImportPath)
+}
+
+// CopyProject replaces the copy of the project in the context with the
+// project in dir, leaving out hidden files. Files are cloned or
+// hardlinked where the filesystem allows, see LinkFile.
+func (bc *BuildContext) CopyProject(dir string) error {
+	// Contexts outside the project, or hidden in it, are not copied
+	if rel, err := filepath.Rel(dir, bc.Dir); err == nil && (rel == "." || !strings.HasPrefix(rel, ".")) {
+		return fmt.Errorf("cant put build context %s in the project %s, it would copy itself, use a directory outside the project such as ../build", bc.Dir, dir)
+	}
+	// Nor contexts whose copy of the project would overlap it, e.g.
+	// a context holding the gopath of the project
+	src := bc.Src()
+	if PathIsChild(src, dir) || (PathIsChild(dir, src) && !PathIsChild(dir, bc.Dir)) {
+		return fmt.Errorf("cant copy %s to %s in build context %s, the copy would overlap the project, use another context directory", dir, src, bc.Dir)
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return fmt.Errorf("cant remove old copy of %s %s", bc.ImportPath, err.Error())
+	}
+	dc := NewDirCopier(dir, src)
+	dc.Link = true
+	if err := dc.Copy(); err != nil {
+		return fmt.Errorf("cant copy %s into build context %s", dir, err.Error())
+	}
+	return nil
+}
+
+// DockerIgnore are the patterns of the .dockerignore written to the
+// context, keeping VCS metadata out of images.
+func DockerIgnore() []string {
+	var patterns []string
+	for dir := range VCSDirs {
+		patterns = append(patterns, "**/"+dir)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// WriteDockerIgnore writes the DockerIgnore patterns to the
+// .dockerignore of the context.
+func (bc *BuildContext) WriteDockerIgnore() error {
+	ignore := strings.Join(DockerIgnore(), "\n") + "\n"
+	return ioutil.WriteFile(filepath.Join(bc.Dir, ".dockerignore"), []byte(ignore), 0644)
+}
+
+// ContainerDir is where the context is mounted in the container
+// fetching into it.
+const ContainerDir = "/context"
+
+// DockerFetch returns the arguments of the docker command running
+// cant get for the project in the context inside image. The cant at
+// exe is mounted into the container, so must be able to run there.
+func (bc *BuildContext) DockerFetch(exe, image string, getArgs ...string) []string {
+	gopath := path.Join(ContainerDir, "gopath")
+	args := []string{
+		"run", "--rm",
+		"-v", exe + ":/usr/local/bin/cant:ro",
+		"-v", bc.Dir + ":" + ContainerDir,
+		"-e", "GOPATH=" + gopath,
+		"-w", path.Join(gopath, "src", bc.ImportPath),
+		image,
+		"cant", "get",
+	}
+	return append(args, getArgs...)
+}
+
+// RunDockerFetch runs cant get for the project in the context inside
+// image with docker.
+func (bc *BuildContext) RunDockerFetch(ctx context.Context, image string, getArgs ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cant find cant to mount into %s %s", image, err.Error())
+	}
+	args := bc.DockerFetch(exe, image, getArgs...)
+	LogVerboseContext(ctx, "Running docker %v", args)
+	cmd := command(ctx, bc.Dir, "docker", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cant fetch in container %s %s", image, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildContext(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	project := filepath.Join(testHome, "project")
+	for _, name := range []string{"main.go", ".git/HEAD", "sub/sub.go"} {
+		path := filepath.Join(project, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+	}
+
+	bc := &BuildContext{Dir: filepath.Join(testHome, "build"), ImportPath: "example.com/project"}
+	if err := bc.CopyProject(project); err != nil {
+		t.Fatalf("Error copying project: %s", err.Error())
+	}
+	src := filepath.Join(testHome, "build", "gopath", "src", "example.com", "project")
+	if bc.Src() != src {
+		t.Errorf("Expected src %s got %s", src, bc.Src())
+	}
+	for name, present := range map[string]bool{"main.go": true, "sub/sub.go": true, ".git": false} {
+		if _, err := os.Stat(filepath.Join(src, filepath.FromSlash(name))); (err == nil) != present {
+			t.Errorf("Expected %s present %v got %v", name, present, err)
+		}
+	}
+	if err := bc.WriteDockerIgnore(); err != nil {
+		t.Fatalf("Error writing .dockerignore: %s", err.Error())
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(bc.Dir, ".dockerignore")); err != nil || len(b) == 0 {
+		t.Errorf("Expected .dockerignore got %s %v", string(b), err)
+	}
+
+	inside := &BuildContext{Dir: filepath.Join(project, "build"), ImportPath: "example.com/project"}
+	if err := inside.CopyProject(project); err == nil {
+		t.Errorf("Expected error copying project into a context inside it")
+	}
+	hidden := &BuildContext{Dir: filepath.Join(project, ".build"), ImportPath: "example.com/project"}
+	if err := hidden.CopyProject(project); err != nil {
+		t.Errorf("Expected hidden context in project to be allowed got %s", err.Error())
+	}
+
+	// Contexts whose copy of the project is, or holds, the project
+	// are refused, e.g. -context ~ with GOPATH=~/gopath
+	home := filepath.Join(testHome, "home")
+	gopathProject := filepath.Join(home, "gopath", "src", "example.com", "project")
+	if err := os.MkdirAll(gopathProject, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(gopathProject, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, importPath := range []string{"example.com/project", "example.com"} {
+		overlap := &BuildContext{Dir: home, ImportPath: importPath}
+		if err := overlap.CopyProject(gopathProject); err == nil {
+			t.Errorf("Expected error copying project into an overlapping context %s", importPath)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(gopathProject, "main.go")); err != nil {
+		t.Errorf("Expected project kept got %s", err.Error())
+	}
+
+	args := bc.DockerFetch("/bin/cant", "golang:1.6", "-v")
+	expected := []string{
+		"run", "--rm",
+		"-v", "/bin/cant:/usr/local/bin/cant:ro",
+		"-v", bc.Dir + ":/context",
+		"-e", "GOPATH=/context/gopath",
+		"-w", "/context/gopath/src/example.com/project",
+		"golang:1.6", "cant", "get", "-v",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected docker args %v got %v", expected, args)
+	}
+}
@@ -0,0 +1,126 @@
+package canticles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AllowVCSHooks, if true, lets git and hg run hooks, filters and
+// templates while fetching dependencies.
+var AllowVCSHooks bool
+
+// SafeGitConfig is the git configuration, as key=value, which stops
+// git running hooks, repository templates, the filesystem monitor
+// and the ext:: transport.
+var SafeGitConfig = []string{
+	"core.hooksPath=" + os.DevNull,
+	"init.templateDir=",
+	"core.fsmonitor=false",
+	"protocol.ext.allow=never",
+}
+
+// GitFilterConfig returns the git configuration, as key=value,
+// which disables every filter driver configured in config, the
+// output of git config --name-only --get-regexp.
+func GitFilterConfig(config []byte) []string {
+	seen := NewStringSet()
+	var filters []string
+	scanner := bufio.NewScanner(bytes.NewReader(config))
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		i := strings.LastIndex(key, ".")
+		if !strings.HasPrefix(key, "filter.") || i <= len("filter.") {
+			continue
+		}
+		name := key[:i]
+		if seen[name] {
+			continue
+		}
+		seen.Add(name)
+		filters = append(filters, name+".smudge=", name+".clean=", name+".process=", name+".required=false")
+	}
+	return filters
+}
+
+// HgHooklessRC returns an hgrc including each file in files and
+// unsetting each of hooks.
+func HgHooklessRC(files, hooks []string) string {
+	var rc bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&rc, "%%include %s\n", f)
+	}
+	rc.WriteString("[hooks]\n")
+	for _, h := range hooks {
+		fmt.Fprintf(&rc, "%%unset %s\n", h)
+	}
+	return rc.String()
+}
+
+// hooklessEnv returns the git configuration, as key=value, and the
+// enviroment, as KEY=VALUE, of git and hg subprocesses so fetching an
+// untrusted repo does not run hooks, smudge or clean filters or
+// templates. Repository level hg configuration is skipped and hooks in
+// the users hg configuration are unset by an hgrc, whose path is
+// returned if one is written.
+func hooklessEnv() (config, env []string, hgrc string, err error) {
+	config = append([]string{}, SafeGitConfig...)
+	if out, err := exec.Command("git", "config", "--name-only", "--get-regexp", `^filter\.`).Output(); err == nil {
+		config = append(config, GitFilterConfig(out)...)
+	}
+	env = []string{"GIT_TEMPLATE_DIR=", "HGRCSKIPREPO=1"}
+	if hgrc, err = writeHooklessHgrc(); err != nil {
+		return nil, nil, "", err
+	}
+	if hgrc != "" {
+		env = append(env, "HGRCPATH="+hgrc)
+	}
+	return config, env, hgrc, nil
+}
+
+// writeHooklessHgrc writes a temporary hgrc unsetting every hook in
+// the users hg configuration and returns its path, or "" if there are
+// no hooks.
+func writeHooklessHgrc() (string, error) {
+	out, err := exec.Command("hg", "config", "--debug", "hooks").Output()
+	if err != nil {
+		// No hg, or no hooks, nothing to disable
+		return "", nil
+	}
+	var files, hooks []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "read config from: ") {
+			files = append(files, strings.TrimPrefix(line, "read config from: "))
+			continue
+		}
+		// Hooks are listed as file:line: hooks.name=command
+		i := strings.Index(line, ": hooks.")
+		if i < 0 {
+			continue
+		}
+		hook := line[i+len(": hooks."):]
+		if j := strings.Index(hook, "="); j > 0 {
+			hooks = append(hooks, hook[:j])
+		}
+	}
+	if len(hooks) == 0 {
+		return "", nil
+	}
+	f, err := ioutil.TempFile("", "cant-hgrc")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(HgHooklessRC(files, hooks)); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	LogVerbose("Disabling hg hooks %v with %s", hooks, f.Name())
+	return f.Name(), nil
+}
@@ -0,0 +1,119 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitFilterConfig(t *testing.T) {
+	config := []byte("filter.lfs.smudge\nfilter.lfs.process\nfilter.a.b.clean\ncore.bare\n")
+	expected := []string{
+		"filter.lfs.smudge=", "filter.lfs.clean=", "filter.lfs.process=", "filter.lfs.required=false",
+		"filter.a.b.smudge=", "filter.a.b.clean=", "filter.a.b.process=", "filter.a.b.required=false",
+	}
+	if result := GitFilterConfig(config); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected filter config %v got %v", expected, result)
+	}
+}
+
+func TestHgHooklessRC(t *testing.T) {
+	rc := HgHooklessRC([]string{"/etc/mercurial/hgrc", "/home/test/.hgrc"}, []string{"update", "pretxncommit.check"})
+	expected := "%include /etc/mercurial/hgrc\n%include /home/test/.hgrc\n[hooks]\n%unset update\n%unset pretxncommit.check\n"
+	if rc != expected {
+		t.Errorf("Expected hgrc %q got %q", expected, rc)
+	}
+}
+
+func TestFetchEnvHooks(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	marker := filepath.Join(testHome, "ran")
+	script := "#!/bin/sh\ntouch " + marker + "\ncat\n"
+	templates := filepath.Join(testHome, "templates")
+	if err := os.MkdirAll(filepath.Join(templates, "hooks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(templates, "hooks", "post-checkout"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(testHome, "smudge"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gitconfig := "[init]\n\ttemplateDir = " + templates + "\n[filter \"evil\"]\n\tsmudge = " + filepath.Join(testHome, "smudge") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(testHome, ".gitconfig"), []byte(gitconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", testHome)
+
+	src := filepath.Join(testHome, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{".gitattributes": "*.txt filter=evil\n", "a.txt": "a\n"}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	git(src, "init")
+	git(src, "add", ".")
+	git(src, "-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test")
+	os.Remove(marker)
+
+	fe, err := NewFetchEnv(nil, nil)
+	if err != nil {
+		t.Fatalf("Error disabling hooks: %s", err.Error())
+	}
+	defer fe.Close()
+	ctx := fe.Context(context.Background())
+	if out, err := command(ctx, testHome, "git", "clone", src, filepath.Join(testHome, "dst")).CombinedOutput(); err != nil {
+		t.Fatalf("Error cloning %s %s", err.Error(), string(out))
+	}
+	if os.Getenv("GIT_CONFIG_COUNT") != "" || os.Getenv("GIT_TEMPLATE_DIR") != "" {
+		t.Errorf("Expected the process enviroment to not be changed")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Expected clone to not run hooks or filters")
+	}
+	if _, err := os.Stat(filepath.Join(testHome, "dst", ".git", "hooks", "post-checkout")); err == nil {
+		t.Errorf("Expected clone to not install template hooks")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(testHome, "dst", "a.txt")); err != nil || !strings.Contains(string(b), "a") {
+		t.Errorf("Expected a.txt checked out got %s %v", string(b), err)
+	}
+}
+
+func TestFetchEnvClose(t *testing.T) {
+	f, err := ioutil.TempFile("", "cant-hgrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fe := &FetchEnv{hgrc: f.Name()}
+	if err := fe.Close(); err != nil {
+		t.Errorf("Error closing fetch env: %s", err.Error())
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("Expected the hgrc to be removed")
+	}
+	if err := (&FetchEnv{}).Close(); err != nil {
+		t.Errorf("Expected closing without an hgrc to succeed got %s", err.Error())
+	}
+}
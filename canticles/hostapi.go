@@ -0,0 +1,227 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Upgrade is a dependency with a revision upstream newer than the
+// one pinned.
+type Upgrade struct {
+	Root string
+	// Source is where the dependency is fetched from, its Root if
+	// empty.
+	Source string
+	// From is the revision pinned, To the newer revision.
+	From string
+	To   string
+}
+
+// An Enrichment is what the API of the host of a dependency says of an
+// Upgrade, to make deciding on it faster. Fields the host does not
+// know, or could not be asked for, are empty.
+type Enrichment struct {
+	// CompareURL is the web page of the changes From to To.
+	CompareURL string `json:",omitempty"`
+	// Date is when To was committed.
+	Date time.Time `json:",omitempty"`
+	// ReleaseNotes are the notes of the release tagged To.
+	ReleaseNotes string `json:",omitempty"`
+}
+
+// A HostAPI enriches the upgrades of repos on a host. The repo is
+// the slash separated path of the repo on the host, such as
+// owner/name.
+type HostAPI interface {
+	// CompareURL returns the web page of the changes of u, it
+	// makes no requests.
+	CompareURL(repo string, u *Upgrade) string
+	// Enrich asks the host for the Date and ReleaseNotes of u,
+	// setting them in e.
+	Enrich(ctx context.Context, repo string, u *Upgrade, e *Enrichment) error
+}
+
+// GitHubAPI is the HostAPI of github.com, or a GitHub Enterprise host.
+type GitHubAPI struct {
+	// BaseURL is the root of the API, WebURL of the web pages.
+	BaseURL string
+	WebURL  string
+	// Token, if set, authenticates requests.
+	Token string
+}
+
+// CompareURL returns the GitHub compare page of u.
+func (gh *GitHubAPI) CompareURL(repo string, u *Upgrade) string {
+	return fmt.Sprintf("%s/%s/compare/%s...%s", gh.WebURL, repo, u.From, u.To)
+}
+
+// Enrich asks GitHub for the commit To and the release tagged To.
+func (gh *GitHubAPI) Enrich(ctx context.Context, repo string, u *Upgrade, e *Enrichment) error {
+	header := http.Header{"Accept": {"application/vnd.github.v3+json"}}
+	if gh.Token != "" {
+		header.Set("Authorization", "token "+gh.Token)
+	}
+	var commit struct {
+		Commit struct {
+			Committer struct{ Date time.Time }
+		}
+	}
+	if err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s", gh.BaseURL, repo, url.PathEscape(u.To)), header, &commit); err != nil {
+		return err
+	}
+	e.Date = commit.Commit.Committer.Date
+	var release struct{ Body string }
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/releases/tags/%s", gh.BaseURL, repo, url.PathEscape(u.To)), header, &release)
+	if err != nil && err != errNotFound {
+		return err
+	}
+	e.ReleaseNotes = release.Body
+	return nil
+}
+
+// GitLabAPI is the HostAPI of gitlab.com, or a self hosted GitLab.
+type GitLabAPI struct {
+	// BaseURL is the root of both the web pages and the API.
+	BaseURL string
+	// Token, if set, authenticates requests.
+	Token string
+}
+
+// CompareURL returns the GitLab compare page of u.
+func (gl *GitLabAPI) CompareURL(repo string, u *Upgrade) string {
+	return fmt.Sprintf("%s/%s/-/compare/%s...%s", gl.BaseURL, repo, u.From, u.To)
+}
+
+// Enrich asks GitLab for the commit To and the release tagged To.
+func (gl *GitLabAPI) Enrich(ctx context.Context, repo string, u *Upgrade, e *Enrichment) error {
+	header := http.Header{}
+	if gl.Token != "" {
+		header.Set("PRIVATE-TOKEN", gl.Token)
+	}
+	project := fmt.Sprintf("%s/api/v4/projects/%s", gl.BaseURL, url.PathEscape(repo))
+	var commit struct {
+		CommittedDate time.Time `json:"committed_date"`
+	}
+	if err := getJSON(ctx, project+"/repository/commits/"+url.PathEscape(u.To), header, &commit); err != nil {
+		return err
+	}
+	e.Date = commit.CommittedDate
+	var release struct{ Description string }
+	err := getJSON(ctx, project+"/releases/"+url.PathEscape(u.To), header, &release)
+	if err != nil && err != errNotFound {
+		return err
+	}
+	e.ReleaseNotes = release.Description
+	return nil
+}
+
+var errNotFound = errors.New("not found")
+
+// getJSON decodes the json at url into v, returning errNotFound if
+// there is none.
+func getJSON(ctx context.Context, url string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := HTTPClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("cant get %s status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// An Enricher enriches upgrades using the HostAPI of the host of each
+// dependency. Without a network, or an API for the host, upgrades are
+// enriched with what can be learnt offline, such as their CompareURL.
+// It is safe for concurrent use.
+type Enricher struct {
+	// APIs are the HostAPI of each host.
+	APIs map[string]HostAPI
+	mu   sync.Mutex
+	// down are the hosts not asked again once unreachable.
+	down StringSet
+}
+
+// NewEnricher returns an Enricher of github.com and gitlab.com
+// authenticated with the GITHUB_TOKEN and GITLAB_TOKEN in the
+// enviroment, if set.
+func NewEnricher() *Enricher {
+	return &Enricher{
+		APIs: map[string]HostAPI{
+			"github.com": &GitHubAPI{BaseURL: "https://api.github.com", WebURL: "https://github.com", Token: os.Getenv("GITHUB_TOKEN")},
+			"gitlab.com": &GitLabAPI{BaseURL: "https://gitlab.com", Token: os.Getenv("GITLAB_TOKEN")},
+		},
+		down: NewStringSet(),
+	}
+}
+
+// Enrich returns what the host of u says of it, nil if its host has no
+// API. Errors asking are logged and what could be learnt returned. A
+// host which can not be reached is not asked again.
+func (e *Enricher) Enrich(ctx context.Context, u *Upgrade) *Enrichment {
+	source := u.Source
+	if source == "" {
+		source = u.Root
+	}
+	host, repo, ok := HostedRepo(source)
+	api := e.APIs[host]
+	if !ok || api == nil {
+		return nil
+	}
+	enrichment := &Enrichment{CompareURL: api.CompareURL(repo, u)}
+	e.mu.Lock()
+	_, down := e.down[host]
+	e.mu.Unlock()
+	if down {
+		return enrichment
+	}
+	if err := api.Enrich(ctx, repo, u, enrichment); err != nil {
+		LogVerboseContext(ctx, "Not enriching %s from %s %s", u.Root, host, err.Error())
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			e.mu.Lock()
+			e.down.Add(host)
+			e.mu.Unlock()
+		}
+	}
+	return enrichment
+}
+
+// HostedRepo returns the host and slash separated repo path of the
+// first two path elements of source, a VCS url such as
+// https://github.com/owner/name.git or git@github.com:owner/name, or
+// an import path.
+func HostedRepo(source string) (host, repo string, ok bool) {
+	if i := strings.Index(source, "://"); i >= 0 {
+		source = source[i+3:]
+	} else if i := strings.Index(source, ":"); i >= 0 && !strings.Contains(source[:i], "/") {
+		source = source[:i] + "/" + source[i+1:]
+	}
+	if i := strings.Index(source, "@"); i >= 0 && i < strings.Index(source, "/") {
+		source = source[i+1:]
+	}
+	parts := strings.Split(strings.TrimSuffix(source, ".git"), "/")
+	if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return strings.ToLower(parts[0]), parts[1] + "/" + parts[2], true
+}
@@ -0,0 +1,83 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostedRepo(t *testing.T) {
+	tests := []struct {
+		source, host, repo string
+		ok                 bool
+	}{
+		{"github.com/owner/name", "github.com", "owner/name", true},
+		{"github.com/owner/name/sub", "github.com", "owner/name", true},
+		{"https://github.com/owner/name.git", "github.com", "owner/name", true},
+		{"git@gitlab.com:owner/name.git", "gitlab.com", "owner/name", true},
+		{"ssh://git@GitHub.com/owner/name", "github.com", "owner/name", true},
+		{"golang.org/x", "", "", false},
+	}
+	for _, test := range tests {
+		host, repo, ok := HostedRepo(test.source)
+		if host != test.host || repo != test.repo || ok != test.ok {
+			t.Errorf("Expected %s to be %s %s %v got %s %s %v", test.source, test.host, test.repo, test.ok, host, repo, ok)
+		}
+	}
+}
+
+func TestEnricher(t *testing.T) {
+	date := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization")+r.Header.Get("PRIVATE-TOKEN"))
+		switch r.URL.EscapedPath() {
+		case "/repos/owner/name/commits/v2":
+			fmt.Fprintf(w, `{"commit": {"committer": {"date": %q}}}`, date.Format(time.RFC3339))
+		case "/repos/owner/name/releases/tags/v2":
+			fmt.Fprint(w, `{"body": "fixes"}`)
+		case "/api/v4/projects/owner%2Fname/repository/commits/v2":
+			fmt.Fprintf(w, `{"committed_date": %q}`, date.Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	e := NewEnricher()
+	e.APIs["github.com"] = &GitHubAPI{BaseURL: server.URL, WebURL: "https://github.com", Token: "gh"}
+	e.APIs["gitlab.com"] = &GitLabAPI{BaseURL: server.URL, Token: "gl"}
+
+	u := &Upgrade{Root: "github.com/owner/name", From: "v1", To: "v2"}
+	enrichment := e.Enrich(context.Background(), u)
+	expected := &Enrichment{CompareURL: "https://github.com/owner/name/compare/v1...v2", Date: date, ReleaseNotes: "fixes"}
+	if enrichment == nil || *enrichment != *expected {
+		t.Errorf("Expected enrichment %+v got %+v", expected, enrichment)
+	}
+	u = &Upgrade{Root: "example.com/name", Source: "git@gitlab.com:owner/name.git", From: "v1", To: "v2"}
+	enrichment = e.Enrich(context.Background(), u)
+	if enrichment == nil || !enrichment.Date.Equal(date) || enrichment.ReleaseNotes != "" {
+		t.Errorf("Expected gitlab enrichment with no release notes got %+v", enrichment)
+	}
+	if len(auth) != 4 || auth[0] != "token gh" || auth[2] != "gl" {
+		t.Errorf("Expected authenticated requests got %v", auth)
+	}
+	if e.Enrich(context.Background(), &Upgrade{Root: "example.com/x/y"}) != nil {
+		t.Errorf("Expected no enrichment for a host without an api")
+	}
+
+	// Unreachable hosts are asked once, and still give a compare url
+	server.Close()
+	auth = nil
+	u = &Upgrade{Root: "github.com/owner/name", From: "v1", To: "v2"}
+	for i := 0; i < 2; i++ {
+		enrichment = e.Enrich(context.Background(), u)
+		if enrichment == nil || enrichment.CompareURL != expected.CompareURL || !enrichment.Date.IsZero() {
+			t.Errorf("Expected offline enrichment with a compare url got %+v", enrichment)
+		}
+	}
+	if _, down := e.down["github.com"]; !down {
+		t.Errorf("Expected github.com to be down")
+	}
+}
@@ -0,0 +1,45 @@
+package canticles
+
+import "net/http"
+
+// HTTPConfig configures the transport of the http.Client of a
+// command, shared by meta tag discovery, archive downloads and
+// advisory fetches, so connections to each host are kept alive and
+// reused.
+type HTTPConfig struct {
+	// MaxConnsPerHost limits the connections to a host, further
+	// requests wait for one to be free. Zero is no limit.
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost is the number of connections kept
+	// alive to each host between requests.
+	MaxIdleConnsPerHost int
+}
+
+// HTTP is the HTTPConfig applied by commands.
+var HTTP = &HTTPConfig{MaxConnsPerHost: 8, MaxIdleConnsPerHost: 8}
+
+// Transport returns a new transport using the settings of hc. It
+// keeps connections alive and attempts HTTP/2 even with a custom TLS
+// configuration.
+func (hc *HTTPConfig) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = 0
+	t.MaxConnsPerHost = hc.MaxConnsPerHost
+	t.MaxIdleConnsPerHost = hc.MaxIdleConnsPerHost
+	return t
+}
+
+// Client returns a new http.Client using a Transport with the TLS
+// settings of tc, refusing unencrypted requests as sm does. Either
+// may be nil.
+func (hc *HTTPConfig) Client(tc *TLSConfig, sm *SecureMode) (*http.Client, error) {
+	var rt http.RoundTripper = hc.Transport()
+	if !tc.Empty() {
+		var err error
+		if rt, err = tc.roundTripper(hc); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Client{Transport: sm.Transport(rt)}, nil
+}
@@ -0,0 +1,69 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHTTPConfigReusesConnections(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<meta name="go-import" content="dep.com/x git https://dep.com/x">`))
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	hc := &HTTPConfig{MaxConnsPerHost: 2, MaxIdleConnsPerHost: 2}
+	client := &http.Client{Transport: hc.Transport()}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL + "/x?go-get=1")
+		if err != nil {
+			t.Fatalf("Error fetching: %s", err.Error())
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("Expected 1 connection reused for every request got %d", conns)
+	}
+}
+
+func TestHTTPConfigClient(t *testing.T) {
+	hc := &HTTPConfig{MaxConnsPerHost: 3}
+	client, err := hc.Client(nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err.Error())
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.MaxConnsPerHost != 3 || !transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected a transport limited to 3 connections got %+v", client.Transport)
+	}
+	if client == http.DefaultClient || http.DefaultClient.Transport != nil {
+		t.Errorf("Expected the http.DefaultClient to not be changed")
+	}
+
+	// TLS settings use a transport per host with the same limits
+	tc := &TLSConfig{}
+	tc.Host("example.com").Insecure = true
+	if client, err = hc.Client(tc, nil); err != nil {
+		t.Fatalf("Error creating client: %s", err.Error())
+	}
+	ht, ok := client.Transport.(*hostTransport)
+	if !ok || ht.hosts["example.com"].(*http.Transport).MaxConnsPerHost != 3 {
+		t.Errorf("Expected a per host transport limited to 3 connections got %+v", client.Transport)
+	}
+}
@@ -0,0 +1,137 @@
+package canticles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile returns the location of the ignore file for a path.
+// Should be a directory.
+func IgnoreFile(p string) string {
+	return filepath.Join(p, ".canticleignore")
+}
+
+type ignoreRule struct {
+	pattern  []string
+	negate   bool
+	anchored bool
+	dirOnly  bool
+}
+
+// IgnoreRules are the patterns read from a .canticleignore file. They
+// use gitignore syntax: blank lines and lines starting with # are
+// ignored, a leading ! negates a pattern, a trailing / only matches
+// directories, patterns containing a / are relative to the project
+// root and ** matches any number of directories.
+type IgnoreRules []ignoreRule
+
+// ParseIgnore reads IgnoreRules from r.
+func ParseIgnore(r io.Reader) (IgnoreRules, error) {
+	var rules IgnoreRules
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		for _, part := range strings.Split(line, "/") {
+			if _, err := path.Match(part, ""); err != nil {
+				return nil, fmt.Errorf("invalid ignore pattern %s %s", s.Text(), err.Error())
+			}
+		}
+		rule.pattern = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules, s.Err()
+}
+
+// LoadIgnoreFile reads the IgnoreRules for the project at path. If no
+// ignore file is present no rules are returned.
+func LoadIgnoreFile(path string) (IgnoreRules, error) {
+	f, err := os.Open(IgnoreFile(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	LogVerbose("Reading ignore file: %s", f.Name())
+	rules, err := ParseIgnore(f)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse ignore file %s %s", f.Name(), err.Error())
+	}
+	return rules, nil
+}
+
+// Match returns true if the slash separated path rel, relative to the
+// project root, is ignored. As with git the last matching pattern
+// decides.
+func (ir IgnoreRules) Match(rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	ignored := false
+	for _, rule := range ir {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var match bool
+		if rule.anchored {
+			match = matchParts(rule.pattern, parts)
+		} else {
+			match, _ = path.Match(rule.pattern[0], parts[len(parts)-1])
+		}
+		if match {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchParts matches path elements against pattern elements where a
+// ** element matches zero or more path elements, or one or more if it
+// is the last, so dir/** only matches what is under dir.
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if match, _ := path.Match(pattern[0], parts[0]); !match {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
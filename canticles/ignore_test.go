@@ -0,0 +1,67 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testIgnore = `# generated code
+gen/
+*.tmp
+/experimental
+docs/**/examples
+!docs/keep/examples
+vendored/**
+`
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := ParseIgnore(strings.NewReader(testIgnore))
+	if err != nil {
+		t.Fatalf("Error parsing ignore rules: %s", err.Error())
+	}
+	cases := []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"gen", true, true},
+		{"pkg/gen", true, true},
+		{"gen", false, false},
+		{"build.tmp", true, true},
+		{"experimental", true, true},
+		{"pkg/experimental", true, false},
+		{"docs/examples", true, true},
+		{"docs/a/b/examples", true, true},
+		{"docs/keep/examples", true, false},
+		{"pkg", true, false},
+		{"vendored", true, false},
+		{"vendored/x", true, true},
+		{"vendored/x/y.go", false, true},
+	}
+	for _, c := range cases {
+		if ignored := rules.Match(c.rel, c.isDir); ignored != c.ignored {
+			t.Errorf("Expected %s ignored %v got %v", c.rel, c.ignored, ignored)
+		}
+	}
+}
+
+func TestLoadIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	rules, err := LoadIgnoreFile(dir)
+	if err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules for missing ignore file got %v %v", rules, err)
+	}
+	if err := ioutil.WriteFile(IgnoreFile(dir), []byte("[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIgnoreFile(dir); err == nil {
+		t.Errorf("Expected error loading invalid ignore file")
+	}
+}
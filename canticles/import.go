@@ -0,0 +1,237 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// An ImportedDependency is a pinned package read from the
+// dependency file of another tool.
+type ImportedDependency struct {
+	ImportPath string
+	// Revision is the VCS specific commit id the package is
+	// pinned at.
+	Revision string
+	// SourcePath, if set, is the repo the package is fetched from.
+	SourcePath string
+	// Constraint, if set, is the semantic version constraint
+	// Revision satisfies, see CanticleDependency.Constraint.
+	Constraint string
+	// Subpackages, if listed, are the import paths of the packages
+	// used from the repo of ImportPath.
+	Subpackages []string
+}
+
+// An Importer reads the dependency file of another tool.
+type Importer struct {
+	// Name of the tool, e.g. godep.
+	Name string
+	// File is the dependency file, relative to the project.
+	File string
+	// Read returns the dependencies of the project at path.
+	Read func(path string) ([]*ImportedDependency, error)
+}
+
+// Importers are tried in order by cant import.
+var Importers = []*Importer{
+	{Name: "godep", File: filepath.Join("Godeps", "Godeps.json"), Read: ReadGodeps},
+	{Name: "glide", File: GlideFile, Read: ReadGlide},
+}
+
+// Godeps is a Godeps/Godeps.json file of godep.
+type Godeps struct {
+	ImportPath string
+	GoVersion  string
+	Packages   []string `json:",omitempty"`
+	Deps       []GodepsDependency
+}
+
+// A GodepsDependency is a package pinned by godep.
+type GodepsDependency struct {
+	ImportPath string
+	// Comment is the tag or description of Rev, it is not used.
+	Comment string `json:",omitempty"`
+	Rev     string
+}
+
+// ReadGodeps returns the dependencies of the Godeps/Godeps.json file
+// of the project at path.
+func ReadGodeps(path string) ([]*ImportedDependency, error) {
+	file := filepath.Join(path, "Godeps", "Godeps.json")
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var godeps Godeps
+	if err := json.Unmarshal(b, &godeps); err != nil {
+		return nil, fmt.Errorf("cant decode %s %s", file, err.Error())
+	}
+	deps := make([]*ImportedDependency, 0, len(godeps.Deps))
+	for _, dep := range godeps.Deps {
+		deps = append(deps, &ImportedDependency{ImportPath: dep.ImportPath, Revision: dep.Rev})
+	}
+	return deps, nil
+}
+
+// CollapseRoots returns the Canticle dependencies of the VCS roots of
+// deps, as found with resolver. Packages under a root already found
+// are not resolved again. The Subpackages of deps are saved as the
+// Packages of their root. An error is returned if the packages of a
+// root are pinned at different revisions, constraints or sources.
+func CollapseRoots(ctx context.Context, resolver RepoResolver, deps []*ImportedDependency) ([]*CanticleDependency, error) {
+	// Resolve shorter paths first so their children are found
+	// under them
+	sorted := append([]*ImportedDependency{}, deps...)
+	sort.Sort(importedByPath(sorted))
+	var cdeps []*CanticleDependency
+	for _, dep := range sorted {
+		var cdep *CanticleDependency
+		for _, c := range cdeps {
+			if c.Root == dep.ImportPath || PathIsChild(c.Root, dep.ImportPath) {
+				cdep = c
+				break
+			}
+		}
+		if cdep == nil {
+			LogVerboseContext(ctx, "Resolving root of %s", dep.ImportPath)
+			vcs, err := resolver.ResolveRepo(ctx, dep.ImportPath, nil)
+			if err != nil {
+				return nil, fmt.Errorf("cant resolve root of %s %s", dep.ImportPath, err.Error())
+			}
+			cdep = &CanticleDependency{Root: vcs.GetRoot(), Revision: dep.Revision, SourcePath: dep.SourcePath, Constraint: dep.Constraint}
+			cdep.Packages = append(cdep.Packages, dep.Subpackages...)
+			cdeps = append(cdeps, cdep)
+			continue
+		}
+		cdep.Packages = append(cdep.Packages, dep.Subpackages...)
+		if cdep.Revision != dep.Revision {
+			return nil, fmt.Errorf("cant import %s, packages of %s are pinned at %s and %s", dep.ImportPath, cdep.Root, cdep.Revision, dep.Revision)
+		}
+		if cdep.SourcePath != dep.SourcePath {
+			return nil, fmt.Errorf("cant import %s, packages of %s are fetched from %s and %s", dep.ImportPath, cdep.Root, cdep.SourcePath, dep.SourcePath)
+		}
+		if cdep.Constraint != dep.Constraint {
+			return nil, fmt.Errorf("cant import %s, packages of %s are constrained to %s and %s", dep.ImportPath, cdep.Root, cdep.Constraint, dep.Constraint)
+		}
+	}
+	for _, cdep := range cdeps {
+		if len(cdep.Packages) > 0 {
+			sort.Strings(cdep.Packages)
+		}
+	}
+	sort.Sort(CanticleDependencies(cdeps))
+	return cdeps, nil
+}
+
+type importedByPath []*ImportedDependency
+
+func (d importedByPath) Len() int           { return len(d) }
+func (d importedByPath) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d importedByPath) Less(i, j int) bool { return d[i].ImportPath < d[j].ImportPath }
+
+type Import struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	DryRun  bool
+	Force   bool
+}
+
+func NewImport() *Import {
+	f := flag.NewFlagSet("import", flag.ExitOnError)
+	i := &Import{flags: f}
+	f.BoolVar(&i.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&i.DryRun, "d", false, "Don't save the deps, just print them.")
+	f.BoolVar(&i.Force, "f", false, "Overwrite an existing Canticle file")
+	return i
+}
+
+var importer = NewImport()
+
+var ImportCommand = &Command{
+	Name:             "import",
+	UsageLine:        "import [-v] [-d] [-f]",
+	ShortDescription: "Convert the dependency file of another tool into a Canticle file.",
+	LongDescription: `The import command converts the dependency file of the project in the current directory written by another tool into a Canticle file, so projects can adopt cant without pinning every dependency again. The supported files are:
+
+  godep: Godeps/Godeps.json
+  glide: glide.yaml, and glide.lock if present
+
+The pinned packages are collapsed into the repos they are in, found in the gopath or from their import path as go get does. Import fails if the packages of a repo are pinned at different revisions.
+
+Glide packages are pinned at the version of glide.lock, or of glide.yaml without a lock. Versions of glide.yaml which are ranges, such as ^1.2.0, are saved as the Constraint of the dependency, see cant save. The repo of a package is saved as its SourcePath and its subpackages as its Packages.
+
+Specify -d to print the dependencies instead of saving them.
+
+Specify -f to overwrite an existing Canticle file.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: importer.flags,
+	Cmd:   importer,
+}
+
+// Run the import command.
+func (i *Import) Run(ctx context.Context, args []string) {
+	if i.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := i.ImportProject(ctx, gopath, wd); err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+}
+
+// ImportProject converts the dependency file of the project at path
+// and saves it as its Canticle file.
+func (i *Import) ImportProject(ctx context.Context, gopath, path string) error {
+	if _, err := os.Stat(DependencyFile(path)); err == nil && !i.Force && !i.DryRun {
+		return fmt.Errorf("cant import into %s, it already exists, use -f to overwrite it", DependencyFile(path))
+	}
+	resolver := NewMemoizedRepoResolver(&CompositeRepoResolver{[]RepoResolver{
+		&LocalRepoResolver{LocalPath: gopath},
+		&DefaultRepoResolver{gopath},
+	}})
+	cdeps, err := i.Import(ctx, resolver, path)
+	if err != nil {
+		return err
+	}
+	save := &Save{DryRun: i.DryRun}
+	if err := save.SaveDeps(path, cdeps); err != nil {
+		return err
+	}
+	if !i.DryRun {
+		LogInfoContext(ctx, "Imported %d dependencies into %s", len(cdeps), DependencyFile(path))
+	}
+	return nil
+}
+
+// Import returns the Canticle dependencies of the dependency file of
+// the first of Importers found in the project at path.
+func (i *Import) Import(ctx context.Context, resolver RepoResolver, path string) ([]*CanticleDependency, error) {
+	for _, imp := range Importers {
+		if _, err := os.Stat(filepath.Join(path, imp.File)); err != nil {
+			continue
+		}
+		LogVerboseContext(ctx, "Importing %s file %s", imp.Name, imp.File)
+		deps, err := imp.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		return CollapseRoots(ctx, resolver, deps)
+	}
+	return nil, fmt.Errorf("cant import %s, it has no dependency file of a supported tool", path)
+}
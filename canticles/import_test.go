@@ -0,0 +1,70 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testGodeps = `{
+	"ImportPath": "example.com/project",
+	"GoVersion": "go1.6",
+	"Deps": [
+		{"ImportPath": "github.com/a/b/sub", "Rev": "abc"},
+		{"ImportPath": "github.com/a/b", "Comment": "v1.0.0", "Rev": "abc"},
+		{"ImportPath": "golang.org/x/net/context", "Rev": "def"}
+	]
+}`
+
+func TestImportGodeps(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := os.MkdirAll(filepath.Join(tmpdir, "Godeps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "Godeps", "Godeps.json"), []byte(testGodeps), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver := &TestResolver{ResolvePaths: map[string]*TestVCSResolve{
+		"github.com/a/b":           {V: &TestVCS{Root: "github.com/a/b"}},
+		"golang.org/x/net/context": {V: &TestVCS{Root: "golang.org/x/net"}},
+	}}
+	cdeps, err := (&Import{}).Import(context.Background(), resolver, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*CanticleDependency{
+		{Root: "github.com/a/b", Revision: "abc"},
+		{Root: "golang.org/x/net", Revision: "def"},
+	}
+	if len(cdeps) != len(expected) {
+		t.Fatalf("Expected %d dependencies got %d", len(expected), len(cdeps))
+	}
+	for i, cdep := range cdeps {
+		if cdep.Root != expected[i].Root || cdep.Revision != expected[i].Revision {
+			t.Errorf("Expected %+v got %+v", expected[i], cdep)
+		}
+	}
+
+	if _, err := (&Import{}).Import(context.Background(), resolver, filepath.Join(tmpdir, "Godeps")); err == nil {
+		t.Errorf("Expected error importing without a dependency file")
+	}
+}
+
+func TestCollapseRootsConflict(t *testing.T) {
+	resolver := &TestResolver{ResolvePaths: map[string]*TestVCSResolve{
+		"github.com/a/b": {V: &TestVCS{Root: "github.com/a/b"}},
+	}}
+	deps := []*ImportedDependency{
+		{ImportPath: "github.com/a/b", Revision: "abc"},
+		{ImportPath: "github.com/a/b/sub", Revision: "def"},
+	}
+	if _, err := CollapseRoots(context.Background(), resolver, deps); err == nil {
+		t.Errorf("Expected error collapsing packages of one root at different revisions")
+	}
+}
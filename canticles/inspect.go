@@ -0,0 +1,134 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+// ReadBinaryBuildInfo reads the build info embedded in the binary
+// filename by a package generated with cant genversion. Binaries
+// stamped with -ldflags do not embed it.
+func ReadBinaryBuildInfo(filename string) (*BuildInfo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	prefix := []byte(BuildInfoStampPrefix + "{")
+	for i := bytes.Index(data, prefix); i >= 0; {
+		var bi BuildInfo
+		start := i + len(BuildInfoStampPrefix)
+		if err := json.NewDecoder(bytes.NewReader(data[start:])).Decode(&bi); err == nil {
+			return &bi, nil
+		}
+		next := bytes.Index(data[start:], prefix)
+		if next < 0 {
+			break
+		}
+		i = start + next
+	}
+	return nil, fmt.Errorf("no canticle build info found in %s", filename)
+}
+
+type Inspect struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	JSON    bool
+}
+
+func NewInspect() *Inspect {
+	f := flag.NewFlagSet("inspect", flag.ExitOnError)
+	i := &Inspect{flags: f}
+	f.BoolVar(&i.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&i.JSON, "json", false, "Print the build info as json")
+	return i
+}
+
+var inspect = NewInspect()
+
+var InspectCommand = &Command{
+	Name:             "inspect",
+	UsageLine:        "inspect [-v] [-json] <binaries>",
+	ShortDescription: "Print the build info embedded in compiled binaries.",
+	LongDescription: `The inspect command prints the build info, including the revision of every dependency, embedded in each binary listed by a buildinfo package generated with cant genversion. No source is required.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -json to print the build info as json.`,
+	Flags: inspect.flags,
+	Cmd:   inspect,
+}
+
+// Run the inspect command.
+func (i *Inspect) Run(ctx context.Context, args []string) {
+	if i.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	binaries := i.flags.Args()
+	if len(binaries) == 0 {
+		log.Fatal("cant inspect, no binaries given")
+	}
+	for _, binary := range binaries {
+		LogVerboseContext(ctx, "Reading build info from %s", binary)
+		bi, err := ReadBinaryBuildInfo(binary)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i.JSON {
+			b, err := json.MarshalIndent(bi, "", "    ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(b))
+			continue
+		}
+		fmt.Printf("%s:\n", binary)
+		fmt.Print(bi.Summary())
+	}
+}
+
+// Summary returns b as human readable lines, omitting empty fields
+// and listing the dependencies sorted by root.
+func (b *BuildInfo) Summary() string {
+	var buf bytes.Buffer
+	for _, field := range []struct{ name, value string }{
+		{"Target", b.Target},
+		{"Revision", b.Revision},
+		{"Version", b.Version},
+		{"Dirty", fmt.Sprintf("%t", b.Dirty)},
+		{"Built", b.BuildTime},
+		{"User", b.BuildUser},
+		{"Host", b.BuildHost},
+		{"Go", b.GoVersion},
+		{"Platform", platform(b.GOOS, b.GOARCH)},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&buf, "\t%-10s%s\n", field.name+":", field.value)
+		}
+	}
+	roots := make([]string, 0, len(b.Dependencies))
+	for root := range b.Dependencies {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	if len(roots) > 0 {
+		fmt.Fprintf(&buf, "\tDependencies:\n")
+	}
+	for _, root := range roots {
+		fmt.Fprintf(&buf, "\t\t%s %s\n", root, b.Dependencies[root])
+	}
+	return buf.String()
+}
+
+func platform(goos, goarch string) string {
+	if goos == "" && goarch == "" {
+		return ""
+	}
+	return goos + "/" + goarch
+}
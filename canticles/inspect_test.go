@@ -0,0 +1,50 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestReadBinaryBuildInfo(t *testing.T) {
+	pinned := []*CanticleDependency{{Root: "a.com/x", Revision: "1"}}
+	bi, err := NewBuildInfo(context.Background(), "test", false, pinned)
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	bi.Target = "app"
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "main.go"), []byte(mainTemplate), 0644); err != nil {
+		t.Fatalf("Error writing temp main: %s", err.Error())
+	}
+	if err := bi.WriteFiles(dir); err != nil {
+		t.Fatalf("Error writing buildinfo go file: %s", err.Error())
+	}
+	cmd := exec.Command("go", "build", "-ldflags", "-s -w", "-o", "app")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error building, output: %s", string(output))
+	}
+
+	read, err := ReadBinaryBuildInfo(path.Join(dir, "app"))
+	if err != nil {
+		t.Fatalf("Error reading build info: %s", err.Error())
+	}
+	if read.Revision != "test" || read.Target != "app" || read.Dependencies["a.com/x"] != "1" {
+		t.Errorf("Unexpected build info read %+v", read)
+	}
+	if desc := read.Summary(); !strings.Contains(desc, "a.com/x 1") || !strings.Contains(desc, "Target:") {
+		t.Errorf("Unexpected build info description:\n%s", desc)
+	}
+	if _, err := ReadBinaryBuildInfo(path.Join(dir, "main.go")); err == nil {
+		t.Errorf("Expected error reading build info from a file without any")
+	}
+}
@@ -0,0 +1,34 @@
+package canticles
+
+import "sync"
+
+// An Interner returns a single shared copy of equal strings. Import
+// paths repeat in the ImportedFrom and Imports of many packages,
+// interning them keeps one copy of each in memory.
+type Interner struct {
+	sync.Mutex
+	strings map[string]string
+}
+
+// NewInterner returns an empty Interner.
+func NewInterner() *Interner {
+	return &Interner{strings: make(map[string]string)}
+}
+
+// Intern returns the shared copy of s.
+func (in *Interner) Intern(s string) string {
+	in.Lock()
+	defer in.Unlock()
+	if interned, ok := in.strings[s]; ok {
+		return interned
+	}
+	in.strings[s] = s
+	return s
+}
+
+// Size returns the number of distinct strings interned.
+func (in *Interner) Size() int {
+	in.Lock()
+	defer in.Unlock()
+	return len(in.strings)
+}
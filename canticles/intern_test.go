@@ -0,0 +1,19 @@
+package canticles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterner(t *testing.T) {
+	in := NewInterner()
+	a := in.Intern("github.com/Comcast/Canticle")
+	b := in.Intern(strings.Join([]string{"github.com", "Comcast", "Canticle"}, "/"))
+	if a != b {
+		t.Errorf("Expected %s got %s", a, b)
+	}
+	in.Intern("golang.org/x/tools")
+	if in.Size() != 2 {
+		t.Errorf("Expected 2 interned strings got %d", in.Size())
+	}
+}
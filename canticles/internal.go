@@ -0,0 +1,66 @@
+package canticles
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// InternalImportAllowed returns true if importer may import imported
+// under the go internal package rules. A path containing an internal
+// element may only be imported by code rooted at the parent of the
+// internal directory.
+func InternalImportAllowed(importer, imported string) bool {
+	parts := strings.Split(imported, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] != "internal" {
+			continue
+		}
+		parent := strings.Join(parts[:i], "/")
+		return importer == parent || parent == "" || strings.HasPrefix(importer, parent+"/")
+	}
+	return true
+}
+
+// An InternalViolation records a package importing an internal
+// package it is not allowed to.
+type InternalViolation struct {
+	Importer string
+	Imported string
+}
+
+func (iv *InternalViolation) Error() string {
+	return fmt.Sprintf("package %s imports internal package %s which is not allowed", iv.Importer, iv.Imported)
+}
+
+// InternalViolations returns every import in d which crosses an
+// internal boundary it is not allowed to, sorted by importer.
+func (d Dependencies) InternalViolations() []*InternalViolation {
+	var violations []*InternalViolation
+	for _, dep := range d {
+		for _, imp := range dep.Imports.Array() {
+			if !InternalImportAllowed(dep.ImportPath, imp) {
+				violations = append(violations, &InternalViolation{dep.ImportPath, imp})
+			}
+		}
+	}
+	sort.Sort(internalViolations(violations))
+	return violations
+}
+
+type internalViolations []*InternalViolation
+
+func (iv internalViolations) Len() int {
+	return len(iv)
+}
+
+func (iv internalViolations) Less(i, j int) bool {
+	if iv[i].Importer == iv[j].Importer {
+		return iv[i].Imported < iv[j].Imported
+	}
+	return iv[i].Importer < iv[j].Importer
+}
+
+func (iv internalViolations) Swap(i, j int) {
+	iv[i], iv[j] = iv[j], iv[i]
+}
@@ -0,0 +1,41 @@
+package canticles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInternalImportAllowed(t *testing.T) {
+	cases := []struct {
+		importer, imported string
+		expected           bool
+	}{
+		{"a.com/x/y", "a.com/x/internal/z", true},
+		{"a.com/x", "a.com/x/internal", true},
+		{"a.com/x/internal/z", "a.com/x/internal/w", true},
+		{"a.com/xy", "a.com/x/internal/z", false},
+		{"b.com/x", "a.com/x/internal/z", false},
+		{"b.com/x", "a.com/x/y", true},
+		{"a.com/x/y", "a.com/x/y/internal/z/internal/w", false},
+	}
+	for _, c := range cases {
+		if InternalImportAllowed(c.importer, c.imported) != c.expected {
+			t.Errorf("Expected InternalImportAllowed(%s, %s) to be %v", c.importer, c.imported, c.expected)
+		}
+	}
+}
+
+func TestInternalViolations(t *testing.T) {
+	deps := NewDependencies()
+	good := NewDependency("a.com/x/cmd")
+	good.Imports.Add("a.com/x/internal/util")
+	bad := NewDependency("b.com/y")
+	bad.Imports.Add("a.com/x/internal/util", "a.com/x/pub")
+	deps.AddDependency(good)
+	deps.AddDependency(bad)
+
+	expected := []*InternalViolation{{"b.com/y", "a.com/x/internal/util"}}
+	if violations := deps.InternalViolations(); !reflect.DeepEqual(expected, violations) {
+		t.Errorf("Expected violations %v got %v", expected, violations)
+	}
+}
@@ -0,0 +1,201 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// IsolatedGoPath returns the location of the dedicated gopath of the
+// project in p.
+func IsolatedGoPath(p string) string {
+	return filepath.Join(p, ".canticle", "gopath")
+}
+
+// An IsolatedProject is a project built in a gopath of its own,
+// holding only the project and its pinned dependencies, so nothing
+// else in the users gopath is built against.
+type IsolatedProject struct {
+	// Dir is the directory of the project.
+	Dir string
+	// ImportPath is the import path of the project.
+	ImportPath string
+	// Gopath is the isolated gopath, see IsolatedGoPath.
+	Gopath string
+}
+
+// NewIsolatedProject returns the IsolatedProject of the project in
+// dir. Its import path is the ImportPath of its .canticle.json, or
+// else its path within the users gopath.
+func NewIsolatedProject(dir string) (*IsolatedProject, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadProjectConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	importPath := config.ImportPath
+	if importPath == "" {
+		gopath, err := EnvGoPath()
+		if err != nil {
+			return nil, fmt.Errorf("cant isolate %s without an ImportPath in its .canticle.json %s", dir, err.Error())
+		}
+		importPath, err = PackageName(gopath, dir)
+		if err != nil || importPath == "" || importPath == "." {
+			return nil, fmt.Errorf("cant isolate %s, it is not in the gopath %s and has no ImportPath in its .canticle.json", dir, gopath)
+		}
+	}
+	return &IsolatedProject{Dir: dir, ImportPath: importPath, Gopath: IsolatedGoPath(dir)}, nil
+}
+
+// Src returns the directory of the project within the isolated
+// gopath.
+func (ip *IsolatedProject) Src() string {
+	return PackageSource(ip.Gopath, ip.ImportPath)
+}
+
+// Link creates the isolated gopath if it is missing and links the
+// project into it at its import path.
+func (ip *IsolatedProject) Link() error {
+	src := ip.Src()
+	if target, err := os.Readlink(src); err == nil {
+		if target == ip.Dir {
+			return nil
+		}
+		if err := os.Remove(src); err != nil {
+			return fmt.Errorf("cant relink %s %s", src, err.Error())
+		}
+	} else if _, err := os.Lstat(src); err == nil {
+		return fmt.Errorf("cant link project into %s, it is not a link", src)
+	}
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		return fmt.Errorf("cant create isolated gopath %s %s", ip.Gopath, err.Error())
+	}
+	return os.Symlink(ip.Dir, src)
+}
+
+// Linked returns an error if the project has not been linked into its
+// isolated gopath, as cant get -isolated does.
+func (ip *IsolatedProject) Linked() error {
+	if _, err := os.Stat(ip.Src()); err != nil {
+		return fmt.Errorf("cant use isolated gopath %s of %s, run cant get -isolated first", ip.Gopath, ip.Dir)
+	}
+	return nil
+}
+
+// Command returns the cmd running name with args in the project
+// within the isolated gopath.
+func (ip *IsolatedProject) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := command(ctx, ip.Src(), name, args...)
+	cmd.Env = GoEnviroment(ip.Gopath)
+	return cmd
+}
+
+// Isolated runs a command in the isolated gopath of a project, see
+// IsolatedProject.
+type Isolated struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	// Tool is run with the arguments given, if it is empty the
+	// first argument is the command run.
+	Tool []string
+}
+
+// NewIsolated returns the command name running tool in the isolated
+// gopath.
+func NewIsolated(name string, tool ...string) *Isolated {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	i := &Isolated{flags: f, Tool: tool}
+	f.BoolVar(&i.Verbose, "v", false, "Be verbose when getting stuff")
+	return i
+}
+
+var (
+	isolatedBuild = NewIsolated("build", "go", "build")
+	isolatedTest  = NewIsolated("test", "go", "test")
+	isolatedExec  = NewIsolated("exec")
+)
+
+const isolatedDescription = `
+
+The project is built in its isolated gopath, .canticle/gopath in the project, which holds only the project and the dependencies fetched by cant get -isolated. Nothing else in the users gopath is visible to the build. The project is linked into the isolated gopath at its import path, the ImportPath of its .canticle.json or else its path in the users gopath.
+
+Arguments after -- are passed on as they are, e.g. cant test -- -race ./...
+
+Specify -v to print out a verbose set of operations instead of just errors.`
+
+var BuildCommand = &Command{
+	Name:             "build",
+	UsageLine:        "build [-v] [-- <go build arguments>]",
+	ShortDescription: "Run go build in the isolated gopath of the project.",
+	LongDescription:  `The build command runs go build with its arguments in the project.` + isolatedDescription,
+	Flags:            isolatedBuild.flags,
+	Cmd:              isolatedBuild,
+}
+
+var TestCommand = &Command{
+	Name:             "test",
+	UsageLine:        "test [-v] [-- <go test arguments>]",
+	ShortDescription: "Run go test in the isolated gopath of the project.",
+	LongDescription:  `The test command runs go test with its arguments in the project.` + isolatedDescription,
+	Flags:            isolatedTest.flags,
+	Cmd:              isolatedTest,
+}
+
+var ExecCommand = &Command{
+	Name:             "exec",
+	UsageLine:        "exec [-v] [--] <command> [arguments]",
+	ShortDescription: "Run a command in the isolated gopath of the project.",
+	LongDescription:  `The exec command runs a command with its arguments in the project, with GOPATH set to the isolated gopath.` + isolatedDescription,
+	Flags:            isolatedExec.flags,
+	Cmd:              isolatedExec,
+}
+
+// Run the command in the isolated gopath of the current directory,
+// exiting with its status.
+func (i *Isolated) Run(ctx context.Context, args []string) {
+	if i.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+
+	args = append(append([]string{}, i.Tool...), i.flags.Args()...)
+	if len(args) == 0 {
+		log.Fatal("cant exec without a command")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = i.RunIsolated(ctx, wd, args[0], args[1:]...)
+	if ee, ok := err.(*exec.ExitError); ok {
+		os.Exit(ee.ExitCode())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// RunIsolated runs name with args in the isolated gopath of the
+// project in dir, sharing cant's stdin, stdout and stderr.
+func (i *Isolated) RunIsolated(ctx context.Context, dir, name string, args ...string) error {
+	ip, err := NewIsolatedProject(dir)
+	if err != nil {
+		return err
+	}
+	if err := ip.Linked(); err != nil {
+		return err
+	}
+	LogVerboseContext(ctx, "Running %s %v in %s with GOPATH %s", name, args, ip.Src(), ip.Gopath)
+	cmd := ip.Command(ctx, name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
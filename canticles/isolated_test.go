@@ -0,0 +1,59 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsolatedProject(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	config := []byte(`{"ImportPath": "example.com/proj"}`)
+	if err := ioutil.WriteFile(ConfigFile(testHome), config, 0644); err != nil {
+		t.Fatalf("Error writing config: %s", err.Error())
+	}
+
+	ip, err := NewIsolatedProject(testHome)
+	if err != nil {
+		t.Fatalf("Error creating isolated project: %s", err.Error())
+	}
+	expected := filepath.Join(testHome, ".canticle", "gopath", "src", "example.com", "proj")
+	if ip.Src() != expected {
+		t.Errorf("Expected src %s got %s", expected, ip.Src())
+	}
+	if err := ip.Linked(); err == nil {
+		t.Errorf("Expected error using unlinked project")
+	}
+	for i := 0; i < 2; i++ {
+		if err := ip.Link(); err != nil {
+			t.Fatalf("Error linking project: %s", err.Error())
+		}
+	}
+	if target, err := os.Readlink(expected); err != nil || target != testHome {
+		t.Errorf("Expected link to %s got %s %v", testHome, target, err)
+	}
+	if err := ip.Linked(); err != nil {
+		t.Errorf("Expected linked project got %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(ip.Src(), ".canticle.json")); err != nil {
+		t.Errorf("Expected project files through link got %s", err.Error())
+	}
+
+	cmd := ip.Command(context.Background(), "go", "env", "GOPATH")
+	if cmd.Dir != expected {
+		t.Errorf("Expected command in %s got %s", expected, cmd.Dir)
+	}
+	found := false
+	for _, kv := range cmd.Env {
+		found = found || kv == "GOPATH="+ip.Gopath
+	}
+	if !found {
+		t.Errorf("Expected GOPATH=%s in command env %v", ip.Gopath, cmd.Env)
+	}
+}
@@ -0,0 +1,197 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// UnusedDependencies returns the cdeps whose roots contain none of
+// the packages in deps.
+func UnusedDependencies(cdeps []*CanticleDependency, deps Dependencies) []*CanticleDependency {
+	var unused []*CanticleDependency
+	for _, cdep := range cdeps {
+		used := false
+		for importPath := range deps {
+			if cdep.Root == importPath || PathIsChild(cdep.Root, importPath) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, cdep)
+		}
+	}
+	return unused
+}
+
+// UnpinnedImports returns the sorted import paths in deps not
+// contained by any cdeps root. Packages in project are not included.
+func UnpinnedImports(cdeps []*CanticleDependency, deps Dependencies, project string) []string {
+	unpinned := NewStringSet()
+	for importPath := range deps {
+		if importPath == project || PathIsChild(project, importPath) {
+			continue
+		}
+		if CoveringDependency(cdeps, importPath) == nil {
+			unpinned.Add(importPath)
+		}
+	}
+	return unpinned.Array()
+}
+
+// LintResult contains the problems found with a projects Canticle
+// file.
+type LintResult struct {
+	// Unused are dependencies nothing imports.
+	Unused []*CanticleDependency
+	// Unpinned are imports no dependency contains.
+	Unpinned []string
+}
+
+// Problems returns the number of problems found.
+func (lr *LintResult) Problems() int {
+	return len(lr.Unused) + len(lr.Unpinned)
+}
+
+// Remaining returns the number of problems left once unused
+// dependencies are removed if fix is set and unpinned imports are
+// pinned if add is set.
+func (lr *LintResult) Remaining(fix, add bool) int {
+	remaining := 0
+	if !fix {
+		remaining += len(lr.Unused)
+	}
+	if !add {
+		remaining += len(lr.Unpinned)
+	}
+	return remaining
+}
+
+type Lint struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Fix     bool
+	Add     bool
+}
+
+func NewLint() *Lint {
+	f := flag.NewFlagSet("lint", flag.ExitOnError)
+	l := &Lint{flags: f}
+	f.BoolVar(&l.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&l.Fix, "fix", false, "Remove unused dependencies from the Canticle file")
+	f.BoolVar(&l.Add, "add", false, "Pin unpinned imports at their on disk revision")
+	return l
+}
+
+var lint = NewLint()
+
+var LintCommand = &Command{
+	Name:             "lint",
+	UsageLine:        "lint [-v] [-fix] [-add]",
+	ShortDescription: "Check the Canticle file against the imports of the project.",
+	LongDescription: `The lint command compares the Canticle file of the project in the current directory against its import closure and reports dependencies nothing imports anymore and imports no dependency pins. It exits with status 1 if problems are found which -fix or -add did not fix.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -fix to remove unused dependencies from the Canticle file.
+
+Specify -add to pin unpinned imports at the revision and source currently on disk.`,
+	Flags: lint.flags,
+	Cmd:   lint,
+}
+
+// Run the lint command.
+func (l *Lint) Run(ctx context.Context, args []string) {
+	if l.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := l.LintProject(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, cdep := range result.Unused {
+		fmt.Printf("unused: %s is not imported by any package\n", cdep.Root)
+	}
+	for _, imp := range result.Unpinned {
+		fmt.Printf("unpinned: %s is not contained by any dependency\n", imp)
+	}
+	if result.Remaining(l.Fix, l.Add) > 0 {
+		os.Exit(1)
+	}
+}
+
+// LintProject reads the dep tree of path and compares it against its
+// Canticle file. If Fix is set unused dependencies are removed from
+// the Canticle file, if Add is set unpinned imports are added to it.
+func (l *Lint) LintProject(ctx context.Context, gopath, path string) (*LintResult, error) {
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	cdeps, err := (&DepReader{Gopath: gopath}).CanticleDependencies(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	s := NewSave()
+	deps, err := s.ReadDeps(ctx, gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	result := &LintResult{
+		Unused:   UnusedDependencies(cdeps, deps),
+		Unpinned: UnpinnedImports(cdeps, deps, pkg),
+	}
+	fixed, changed := cdeps, false
+	if l.Fix && len(result.Unused) > 0 {
+		unused := make(map[*CanticleDependency]bool, len(result.Unused))
+		for _, cdep := range result.Unused {
+			unused[cdep] = true
+		}
+		fixed = make([]*CanticleDependency, 0, len(cdeps))
+		for _, cdep := range cdeps {
+			if !unused[cdep] {
+				fixed = append(fixed, cdep)
+			}
+		}
+		changed = true
+	}
+	if l.Add && len(result.Unpinned) > 0 {
+		added, err := l.pinImports(ctx, s, gopath, path, deps, result.Unpinned)
+		if err != nil {
+			return result, err
+		}
+		fixed = append(fixed, added...)
+		changed = true
+	}
+	if !changed {
+		return result, nil
+	}
+	return result, s.SaveDeps(path, fixed)
+}
+
+// pinImports resolves the on disk revision and source of the VCS
+// roots of imports.
+func (l *Lint) pinImports(ctx context.Context, s *Save, gopath, path string, deps Dependencies, imports []string) ([]*CanticleDependency, error) {
+	unpinned := NewDependencies()
+	for _, imp := range imports {
+		unpinned.AddDependency(deps[imp])
+	}
+	sources, err := s.GetSources(ctx, gopath, path, unpinned)
+	if err != nil {
+		return nil, err
+	}
+	return (&PreferLocalResolution{}).ResolveConflicts(sources)
+}
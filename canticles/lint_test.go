@@ -0,0 +1,95 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestUnusedDependencies(t *testing.T) {
+	deps := NewDependencies()
+	deps.AddDeps("a.com/x/y", "b.com/z")
+	cdeps := []*CanticleDependency{
+		{Root: "a.com/x"},
+		{Root: "b.com/z"},
+		{Root: "c.com/gone"},
+	}
+	expected := []*CanticleDependency{cdeps[2]}
+	if unused := UnusedDependencies(cdeps, deps); !reflect.DeepEqual(expected, unused) {
+		t.Errorf("Expected unused %v got %v", expected, unused)
+	}
+}
+
+func TestLintProject(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"test.com/proj/main.go": "package main\nimport _ \"dep.com/used/pkg\"\nfunc main() {}\n",
+		"dep.com/used/pkg/a.go": "package pkg\n",
+	}
+	for name, src := range files {
+		p := PackageSource(testHome, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proj := PackageSource(testHome, "test.com/proj")
+	cdeps := []*CanticleDependency{{Root: "dep.com/used"}, {Root: "dep.com/unused"}}
+	if err := NewSave().SaveDeps(proj, cdeps); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLint()
+	l.Fix = true
+	result, err := l.LintProject(context.Background(), testHome, proj)
+	if err != nil {
+		t.Fatalf("Error linting project: %s", err.Error())
+	}
+	if len(result.Unused) != 1 || result.Unused[0].Root != "dep.com/unused" {
+		t.Errorf("Expected dep.com/unused to be unused got %v", result.Unused)
+	}
+	fixed, err := (&DepReader{Gopath: testHome}).CanticleDependencies("test.com/proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixed) != 1 || fixed[0].Root != "dep.com/used" {
+		t.Errorf("Expected fixed Canticle file to only contain dep.com/used got %v", fixed)
+	}
+	if n := result.Remaining(true, false); n != 0 {
+		t.Errorf("Expected no problems left after fixing got %d", n)
+	}
+
+	unpinned := &LintResult{Unused: result.Unused, Unpinned: []string{"dep.com/other"}}
+	for _, test := range []struct {
+		fix, add  bool
+		remaining int
+	}{
+		{false, false, 2},
+		{true, false, 1},
+		{false, true, 1},
+		{true, true, 0},
+	} {
+		if n := unpinned.Remaining(test.fix, test.add); n != test.remaining {
+			t.Errorf("Expected %d problems left with fix %v add %v got %d", test.remaining, test.fix, test.add, n)
+		}
+	}
+}
+
+func TestUnpinnedImports(t *testing.T) {
+	deps := NewDependencies()
+	deps.AddDeps("test.com/proj/sub", "a.com/x/y", "b.com/z", "c.com/float/pkg")
+	cdeps := []*CanticleDependency{{Root: "a.com/x"}, {Root: "b.com/z"}}
+	expected := []string{"c.com/float/pkg"}
+	if unpinned := UnpinnedImports(cdeps, deps, "test.com/proj"); !reflect.DeepEqual(expected, unpinned) {
+		t.Errorf("Expected unpinned %v got %v", expected, unpinned)
+	}
+}
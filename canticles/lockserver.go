@@ -0,0 +1,280 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrNoLock is returned by LockServer.Pull when no lock was pushed for
+// the project and branch.
+var ErrNoLock = errors.New("no lock pushed")
+
+// maxLockSize bounds the locks pulled.
+const maxLockSize = 16 << 20
+
+// A LockServer stores the locks of projects, their Canticle file or
+// workspace Canticle.lock, at URL/<project>/<branch>. Locks are
+// pushed with PUT and pulled with GET, any HTTP server accepting
+// both, such as a WebDAV share or an artifact repository, will do.
+type LockServer struct {
+	URL string
+	// Token, if set, is sent as a bearer token. It is never sent
+	// over plain http.
+	Token  string
+	Client *http.Client
+}
+
+// NewLockServer returns a LockServer at url fetching with client and
+// authenticating with $CANTICLE_LOCK_TOKEN.
+func NewLockServer(url string, client *http.Client) *LockServer {
+	return &LockServer{URL: url, Token: os.Getenv("CANTICLE_LOCK_TOKEN"), Client: client}
+}
+
+// LockURL returns the url of the lock of project on branch. Branches
+// with slashes are escaped into one path segment.
+func (ls *LockServer) LockURL(project, branch string) string {
+	return strings.TrimSuffix(ls.URL, "/") + "/" + project + "/" + url.PathEscape(branch)
+}
+
+func (ls *LockServer) do(ctx context.Context, method, project, branch string, body []byte) (*http.Response, error) {
+	u := ls.LockURL(project, branch)
+	LogVerboseContext(ctx, "%s %s", method, u)
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ls.Token != "" {
+		if req.URL.Scheme != "https" {
+			return nil, fmt.Errorf("cant send CANTICLE_LOCK_TOKEN to %s over unencrypted %s", u, req.URL.Scheme)
+		}
+		req.Header.Set("Authorization", "Bearer "+ls.Token)
+	}
+	resp, err := ls.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cant %s lock %s %s", method, u, err.Error())
+	}
+	return resp, nil
+}
+
+// Push stores lock as the lock of project on branch.
+func (ls *LockServer) Push(ctx context.Context, project, branch string, lock []byte) error {
+	if _, err := decodeLock(lock); err != nil {
+		return err
+	}
+	resp, err := ls.do(ctx, "PUT", project, branch, lock)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cant push lock %s status %s", ls.LockURL(project, branch), resp.Status)
+	}
+	return nil
+}
+
+// Pull returns the lock of project on branch, or ErrNoLock if there
+// is none.
+func (ls *LockServer) Pull(ctx context.Context, project, branch string) ([]byte, error) {
+	resp, err := ls.do(ctx, "GET", project, branch, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoLock
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cant pull lock %s status %s", ls.LockURL(project, branch), resp.Status)
+	}
+	lock, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLockSize))
+	if err != nil {
+		return nil, fmt.Errorf("cant pull lock %s %s", ls.LockURL(project, branch), err.Error())
+	}
+	if _, err := decodeLock(lock); err != nil {
+		return nil, fmt.Errorf("cant use lock %s %s", ls.LockURL(project, branch), err.Error())
+	}
+	return lock, nil
+}
+
+// decodeLock checks lock is a list of CanticleDependency.
+func decodeLock(lock []byte) ([]*CanticleDependency, error) {
+	var deps []*CanticleDependency
+	if err := json.Unmarshal(lock, &deps); err != nil {
+		return nil, fmt.Errorf("cant decode lock %s", err.Error())
+	}
+	return deps, nil
+}
+
+// LockFile returns the lock of the project in dir shared with a
+// LockServer: the Canticle.lock of a workspace, otherwise its
+// Canticle file.
+func LockFile(dir string) string {
+	if _, err := os.Stat(WorkspaceFile(dir)); err == nil {
+		return WorkspaceLockFile(dir)
+	}
+	return DependencyFile(dir)
+}
+
+// GitBranch returns the branch checked out in dir.
+func GitBranch(ctx context.Context, dir string) (string, error) {
+	branch, err := gitOutput(ctx, dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if branch == "HEAD" {
+		return "", fmt.Errorf("cant find branch of %s, HEAD is detached, use -branch", dir)
+	}
+	return branch, nil
+}
+
+type Lock struct {
+	flags    *flag.FlagSet
+	Verbose  bool
+	Server   string
+	Project  string
+	Branch   string
+	Fallback string
+}
+
+func NewLock() *Lock {
+	f := flag.NewFlagSet("lock", flag.ExitOnError)
+	l := &Lock{flags: f}
+	f.BoolVar(&l.Verbose, "v", false, "Be verbose when getting stuff")
+	f.StringVar(&l.Server, "server", "", "Use this lock server instead of the LockServer of the project config")
+	f.StringVar(&l.Project, "project", "", "Key the lock by this project instead of the import path of the current directory")
+	f.StringVar(&l.Branch, "branch", "", "Key the lock by this branch instead of the one checked out")
+	f.StringVar(&l.Fallback, "fallback", "", "Pull the lock of this branch if none was pushed for the branch")
+	return l
+}
+
+var lock = NewLock()
+
+var LockCommand = &Command{
+	Name:             "lock",
+	UsageLine:        "lock [-v] [-server <url>] [-project <path>] [-branch <branch>] [-fallback <branch>] push|pull",
+	ShortDescription: "Share the lock of a project on a lock server.",
+	LongDescription: `The lock command pushes the lock of the project in the current directory to a lock server, or pulls it from one, keyed by project and branch. The lock is the Canticle.lock of a workspace, see cant save -workspace, otherwise the Canticle file.
+
+This lets a team bless dependency snapshots, and CI fetch the canonical lock, without committing the lock to every branch. For example CI can run:
+
+  cant lock -fallback master pull && cant get
+
+The lock server is the LockServer of the project config, .canticle.json, e.g. {"LockServer": "https://locks.example.com/canticle"}. Locks are PUT to and GET from <server>/<project>/<branch>, so any HTTP server storing files will do. If $CANTICLE_LOCK_TOKEN is set it is sent as a bearer token, only over https. The TLS and Secure settings of the project config apply.
+
+The project defaults to the ImportPath of the project config, otherwise the import path of the current directory. The branch defaults to the git branch checked out.
+
+Specify -server to use that lock server.
+
+Specify -project to key the lock by that project.
+
+Specify -branch to key the lock by that branch.
+
+Specify -fallback to pull the lock of that branch, e.g. master, when no lock was pushed for the branch.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: lock.flags,
+	Cmd:   lock,
+}
+
+// Run the lock command.
+func (l *Lock) Run(ctx context.Context, args []string) {
+	if l.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	op := l.flags.Arg(0)
+	if op != "push" && op != "pull" {
+		LockCommand.Usage()
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := l.Share(ctx, op, wd); err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+}
+
+// Share pushes or pulls, as op is "push" or "pull", the lock of the
+// project in dir.
+func (l *Lock) Share(ctx context.Context, op, dir string) error {
+	config, err := LoadProjectConfig(dir)
+	if err != nil {
+		return err
+	}
+	server := l.Server
+	if server == "" {
+		server = config.LockServer
+	}
+	if server == "" {
+		return fmt.Errorf("cant %s lock, no LockServer in %s, use -server", op, ConfigFile(dir))
+	}
+	project := l.Project
+	if project == "" {
+		project = config.ImportPath
+	}
+	if project == "" {
+		gopath, err := EnvGoPath()
+		if err != nil {
+			return err
+		}
+		if project, err = PackageName(gopath, dir); err != nil {
+			return fmt.Errorf("cant find project of %s, use -project %s", dir, err.Error())
+		}
+	}
+	branch := l.Branch
+	if branch == "" {
+		if branch, err = GitBranch(ctx, dir); err != nil {
+			return err
+		}
+	}
+	client, err := HTTP.Client(TLS.Merge(config.TLS, dir), Secure.Merge(config))
+	if err != nil {
+		return err
+	}
+	ls := NewLockServer(server, client)
+	file := LockFile(dir)
+	if op == "push" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("cant read lock %s", err.Error())
+		}
+		if err := ls.Push(ctx, project, branch, b); err != nil {
+			return err
+		}
+		LogInfoContext(ctx, "Pushed %s to %s", file, ls.LockURL(project, branch))
+		return nil
+	}
+	b, err := ls.Pull(ctx, project, branch)
+	if err == ErrNoLock && l.Fallback != "" && l.Fallback != branch {
+		LogInfoContext(ctx, "No lock for %s, pulling %s", branch, l.Fallback)
+		branch = l.Fallback
+		b, err = ls.Pull(ctx, project, branch)
+	}
+	if err == ErrNoLock {
+		return fmt.Errorf("cant pull lock %s, %s", ls.LockURL(project, branch), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return fmt.Errorf("cant write lock %s", err.Error())
+	}
+	LogInfoContext(ctx, "Pulled %s from %s", file, ls.LockURL(project, branch))
+	return nil
+}
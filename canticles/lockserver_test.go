@@ -0,0 +1,122 @@
+package canticles
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testLockServer(t *testing.T) (*httptest.Server, map[string]string) {
+	var mu sync.Mutex
+	locks := make(map[string]string)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			locks[r.URL.EscapedPath()] = string(b)
+		case "GET":
+			lock, ok := locks[r.URL.EscapedPath()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(lock))
+		}
+	}))
+	return server, locks
+}
+
+func TestLockServer(t *testing.T) {
+	server, locks := testLockServer(t)
+	defer server.Close()
+	ls := &LockServer{URL: server.URL + "/locks/", Token: "secret", Client: server.Client()}
+	if u := ls.LockURL("example.com/a", "feature/x"); u != server.URL+"/locks/example.com/a/feature%2Fx" {
+		t.Errorf("Expected escaped lock url got %s", u)
+	}
+
+	lock := []byte(`[{"Root": "example.com/b", "Revision": "abc"}]`)
+	if err := ls.Push(context.Background(), "example.com/a", "feature/x", lock); err != nil {
+		t.Fatal(err)
+	}
+	if locks["/locks/example.com/a/feature%2Fx"] != string(lock) {
+		t.Errorf("Expected lock pushed got %v", locks)
+	}
+	b, err := ls.Pull(context.Background(), "example.com/a", "feature/x")
+	if err != nil || string(b) != string(lock) {
+		t.Errorf("Expected lock pulled got %s %v", b, err)
+	}
+	if _, err := ls.Pull(context.Background(), "example.com/a", "master"); err != ErrNoLock {
+		t.Errorf("Expected ErrNoLock got %v", err)
+	}
+	if err := ls.Push(context.Background(), "example.com/a", "master", []byte("not json")); err == nil {
+		t.Errorf("Expected error pushing invalid lock")
+	}
+	plain := &LockServer{URL: "http://" + server.Listener.Addr().String(), Token: "secret", Client: server.Client()}
+	if err := plain.Push(context.Background(), "example.com/a", "master", lock); err == nil {
+		t.Errorf("Expected error sending the token over http")
+	}
+	ls.Token = ""
+	if err := ls.Push(context.Background(), "example.com/a", "master", lock); err == nil {
+		t.Errorf("Expected error pushing unauthorized")
+	}
+}
+
+func TestLockShare(t *testing.T) {
+	server, _ := testLockServer(t)
+	defer server.Close()
+	os.Setenv("CANTICLE_LOCK_TOKEN", "secret")
+	defer os.Unsetenv("CANTICLE_LOCK_TOKEN")
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "ca.pem"), ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(ConfigFile(tmpdir), []byte(`{"LockServer": "`+server.URL+`", "ImportPath": "example.com/a", "TLS": {"CAFile": "ca.pem"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock := `[{"Root": "example.com/b", "Revision": "abc"}]`
+	if err := ioutil.WriteFile(DependencyFile(tmpdir), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &Lock{Branch: "master"}
+	if err := l.Share(context.Background(), "push", tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(DependencyFile(tmpdir))
+	l = &Lock{Branch: "feature", Fallback: "master"}
+	if err := l.Share(context.Background(), "pull", tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(DependencyFile(tmpdir)); string(b) != lock {
+		t.Errorf("Expected fallback lock pulled got %s", b)
+	}
+	l = &Lock{Branch: "feature"}
+	if err := l.Share(context.Background(), "pull", tmpdir); err == nil {
+		t.Errorf("Expected error pulling missing lock without fallback")
+	}
+
+	// Workspaces share their Canticle.lock
+	if err := ioutil.WriteFile(WorkspaceFile(tmpdir), []byte(`{"Projects": ["a"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if file := LockFile(tmpdir); file != filepath.Join(tmpdir, "Canticle.lock") {
+		t.Errorf("Expected workspace lock got %s", file)
+	}
+}
@@ -0,0 +1,134 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// The levels of the events canticle logs.
+const (
+	LevelVerbose = "verbose"
+	LevelInfo    = "info"
+	LevelWarn    = "warn"
+)
+
+// A Logger receives the events canticle logs, at one of the Level
+// constants. Verbose events are only logged if Verbose is true, info
+// and warn events unless Quite is.
+type Logger interface {
+	Log(ctx context.Context, level, msg string)
+}
+
+// LoggerFunc adapts a func to a Logger.
+type LoggerFunc func(ctx context.Context, level, msg string)
+
+// Log calls f.
+func (f LoggerFunc) Log(ctx context.Context, level, msg string) {
+	f(ctx, level, msg)
+}
+
+// A StdLogger logs events to Logger, or the standard logger if it is
+// nil, prefixing them with their trace id and warn and info events
+// with WARN: and INFO:.
+type StdLogger struct {
+	Logger *log.Logger
+}
+
+// Log prints msg.
+func (sl StdLogger) Log(ctx context.Context, level, msg string) {
+	switch level {
+	case LevelWarn:
+		msg = "WARN: " + msg
+	case LevelInfo:
+		msg = "INFO: " + msg
+	}
+	if id := TraceID(ctx); id != "" {
+		msg = "[" + id + "] " + msg
+	}
+	if sl.Logger != nil {
+		sl.Logger.Print(msg)
+		return
+	}
+	log.Print(msg)
+}
+
+// A bufferedLogger holds the events logged to it until flushed, so
+// the events of an operation run alongside others are logged together
+// rather than interleaved with theirs.
+type bufferedLogger struct {
+	mu     sync.Mutex
+	events []bufferedEvent
+}
+
+type bufferedEvent struct {
+	ctx        context.Context
+	level, msg string
+}
+
+// Log holds the event.
+func (bl *bufferedLogger) Log(ctx context.Context, level, msg string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.events = append(bl.events, bufferedEvent{ctx, level, msg})
+}
+
+// flush logs the events held to l, in order, and forgets them.
+func (bl *bufferedLogger) flush(l Logger) {
+	bl.mu.Lock()
+	events := bl.events
+	bl.events = nil
+	bl.mu.Unlock()
+	for _, e := range events {
+		l.Log(e.ctx, e.level, e.msg)
+	}
+}
+
+// DefaultLogger receives the events of operations without a Logger,
+// see WithLogger.
+var DefaultLogger Logger = StdLogger{}
+
+// WithLogger returns a copy of ctx whose operations log to l instead
+// of the DefaultLogger.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// withLogger returns ctx logging to l, if it is non nil. Types with a
+// Logger of their own use it to log their operations.
+func withLogger(ctx context.Context, l Logger) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return WithLogger(ctx, l)
+}
+
+// LoggerOf returns the Logger operations with ctx log to.
+func LoggerOf(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey).(Logger); ok && l != nil {
+		return l
+	}
+	return DefaultLogger
+}
+
+// LogVerboseContext is LogVerbose using the Logger of ctx.
+func LogVerboseContext(ctx context.Context, fmtString string, args ...interface{}) {
+	if Verbose {
+		LoggerOf(ctx).Log(ctx, LevelVerbose, fmt.Sprintf(fmtString, args...))
+	}
+}
+
+// LogWarnContext is LogWarn using the Logger of ctx.
+func LogWarnContext(ctx context.Context, fmtString string, args ...interface{}) {
+	if !Quite {
+		LoggerOf(ctx).Log(ctx, LevelWarn, fmt.Sprintf(fmtString, args...))
+	}
+}
+
+// LogInfoContext is LogInfo using the Logger of ctx.
+func LogInfoContext(ctx context.Context, fmtString string, args ...interface{}) {
+	if !Quite {
+		LoggerOf(ctx).Log(ctx, LevelInfo, fmt.Sprintf(fmtString, args...))
+	}
+}
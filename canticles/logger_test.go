@@ -0,0 +1,73 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithTraceID(WithLogger(context.Background(), StdLogger{log.New(&buf, "", 0)}), "req1")
+	if id := TraceID(ctx); id != "req1" {
+		t.Errorf("Expected trace id req1 got %s", id)
+	}
+	LogInfoContext(ctx, "fetching %s", "dep.com/x")
+	if expected := "[req1] INFO: fetching dep.com/x\n"; buf.String() != expected {
+		t.Errorf("Expected log %q got %q", expected, buf.String())
+	}
+	buf.Reset()
+	Verbose = false
+	LogVerboseContext(ctx, "quiet")
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing logged without Verbose got %q", buf.String())
+	}
+}
+
+// A testLogger records the events logged to it.
+type testLogger struct {
+	sync.Mutex
+	events []string
+}
+
+func (tl *testLogger) Log(ctx context.Context, level, msg string) {
+	tl.Lock()
+	defer tl.Unlock()
+	tl.events = append(tl.events, level+" "+msg)
+}
+
+func TestWalkerLogger(t *testing.T) {
+	tl := &testLogger{}
+	dw := NewDependencyWalker(NormalReader.ReadDependencies, func(ctx context.Context, pkg string) error {
+		LogInfoContext(ctx, "handling %s", pkg)
+		return nil
+	})
+	dw.Logger = tl
+	if err := dw.TraverseDependencies(context.Background(), "testpkg"); err != nil {
+		t.Fatalf("Error walking deps %s", err.Error())
+	}
+	expected := NewStringSet()
+	expected.Add("info handling testpkg", "info handling dep1", "info handling dep2")
+	logged := NewStringSet()
+	logged.Add(tl.events...)
+	if !reflect.DeepEqual(expected, logged) {
+		t.Errorf("Expected events %v got %v", expected, tl.events)
+	}
+
+	// Without a Logger of its own the walk logs to the Logger of
+	// its context.
+	other := &testLogger{}
+	dw = NewDependencyWalker(NormalReader.ReadDependencies, func(ctx context.Context, pkg string) error {
+		LogWarnContext(ctx, "%s", pkg)
+		return ErrorSkip
+	})
+	if err := dw.TraverseDependencies(WithLogger(context.Background(), other), "testpkg"); err != nil {
+		t.Fatalf("Error walking deps %s", err.Error())
+	}
+	if expected := []string{"warn testpkg"}; !reflect.DeepEqual(expected, other.events) {
+		t.Errorf("Expected events %v got %v", expected, other.events)
+	}
+}
@@ -0,0 +1,152 @@
+package canticles
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PopularRoots are widely used dependency roots new dependencies are
+// checked against for look-alike paths, along with those already
+// pinned.
+var PopularRoots = []string{
+	"github.com/sirupsen/logrus",
+	"github.com/stretchr/testify",
+	"github.com/golang/protobuf",
+	"github.com/gorilla/mux",
+	"github.com/spf13/cobra",
+	"github.com/spf13/viper",
+	"github.com/spf13/pflag",
+	"github.com/pkg/errors",
+	"github.com/gogo/protobuf",
+	"github.com/davecgh/go-spew",
+	"github.com/google/uuid",
+	"github.com/satori/go.uuid",
+	"github.com/go-sql-driver/mysql",
+	"github.com/lib/pq",
+	"github.com/mattn/go-sqlite3",
+	"github.com/gin-gonic/gin",
+	"github.com/prometheus/client_golang",
+	"github.com/urfave/cli",
+	"github.com/BurntSushi/toml",
+	"gopkg.in/yaml.v2",
+	"golang.org/x/net",
+	"golang.org/x/sys",
+	"golang.org/x/crypto",
+	"golang.org/x/text",
+	"golang.org/x/tools",
+	"google.golang.org/grpc",
+}
+
+// homoglyphs maps characters, and character sequences, to the
+// character they are easily mistaken for.
+var homoglyphs = strings.NewReplacer(
+	"rn", "m",
+	"vv", "w",
+	"0", "o",
+	"1", "l",
+	"i", "l",
+	"5", "s",
+	"а", "a", // cyrillic
+	"е", "e",
+	"о", "o",
+	"р", "p",
+	"с", "c",
+	"х", "x",
+	"у", "y",
+	"ӏ", "l",
+	"-", "",
+	"_", "",
+	".", "",
+)
+
+// skeleton returns the form of path in which look-alike paths are
+// equal.
+func skeleton(path string) string {
+	return homoglyphs.Replace(strings.ToLower(path))
+}
+
+// editDistance returns the levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// majorBase returns path without its major version suffix, e.g.
+// gopkg.in/yaml for gopkg.in/yaml.v3, see pathMajor.
+func majorBase(path string) string {
+	if _, ok := pathMajor(path); ok {
+		return path[:strings.LastIndexAny(path, "/.")]
+	}
+	return path
+}
+
+// LookAlike returns why path may be mistaken for known, or "" if it
+// is not a look-alike. Other major versions of known, such as
+// gopkg.in/yaml.v3 of gopkg.in/yaml.v2, are not look-alikes.
+func LookAlike(path, known string) string {
+	path, known = majorBase(path), majorBase(known)
+	switch {
+	case path == known:
+		return ""
+	case strings.EqualFold(path, known):
+		return "differs only in case from"
+	case skeleton(path) == skeleton(known):
+		return "looks like"
+	case editDistance(path, known) == 1:
+		return "is one character from"
+	}
+	return ""
+}
+
+// LookAlikeWarnings returns a warning for each of cdeps not pinned in
+// saved whose root is a look-alike of a root pinned in saved or of
+// one of the PopularRoots.
+func LookAlikeWarnings(saved, cdeps []*CanticleDependency) []string {
+	known := NewStringSet()
+	known.Add(PopularRoots...)
+	pinned := NewStringSet()
+	for _, cdep := range saved {
+		pinned.Add(cdep.Root)
+	}
+	known.Union(pinned)
+	var warnings []string
+	for _, cdep := range cdeps {
+		if pinned[cdep.Root] {
+			continue
+		}
+		for k := range known {
+			if reason := LookAlike(cdep.Root, k); reason != "" {
+				warnings = append(warnings, fmt.Sprintf("new dependency %s %s %s", cdep.Root, reason, k))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
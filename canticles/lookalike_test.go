@@ -0,0 +1,51 @@
+package canticles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookAlike(t *testing.T) {
+	cases := []struct {
+		path, known, expected string
+	}{
+		{"github.com/Sirupsen/logrus", "github.com/sirupsen/logrus", "differs only in case from"},
+		{"github.com/sirupsen/1ogrus", "github.com/sirupsen/logrus", "looks like"},
+		{"github.com/sirupsen/lоgrus", "github.com/sirupsen/logrus", "looks like"}, // cyrillic o
+		{"github.com/stretchr/testlfy", "github.com/stretchr/testify", "looks like"},
+		{"github.com/gorila/mux", "github.com/gorilla/mux", "is one character from"},
+		{"github.com/gorilla/mux", "github.com/gorilla/mux", ""},
+		{"github.com/gorilla/websocket", "github.com/gorilla/mux", ""},
+		{"gopkg.in/yaml.v3", "gopkg.in/yaml.v2", ""},
+		{"github.com/spf13/cobra/v2", "github.com/spf13/cobra", ""},
+		{"github.com/go-redis/redis/v8", "github.com/go-redis/redis/v9", ""},
+		{"gopkg.in/yarnl.v3", "gopkg.in/yaml.v2", "looks like"},
+		{"github.com/spf13/cobrra/v2", "github.com/spf13/cobra", "is one character from"},
+	}
+	for _, c := range cases {
+		if result := LookAlike(c.path, c.known); result != c.expected {
+			t.Errorf("Expected %s %q %s got %q", c.path, c.expected, c.known, result)
+		}
+	}
+}
+
+func TestLookAlikeWarnings(t *testing.T) {
+	saved := []*CanticleDependency{{Root: "dep.com/foo/bar"}}
+	cdeps := []*CanticleDependency{
+		{Root: "dep.com/foo/bar"},
+		{Root: "dep.com/foo/baz"},
+		{Root: "github.com/pkg/errors"},
+		{Root: "github.com/pkg/errrors"},
+		{Root: "gopkg.in/yaml.v3"},
+	}
+	expected := []string{
+		"new dependency dep.com/foo/baz is one character from dep.com/foo/bar",
+		"new dependency github.com/pkg/errrors is one character from github.com/pkg/errors",
+	}
+	if warnings := LookAlikeWarnings(saved, cdeps); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %v got %v", expected, warnings)
+	}
+	if warnings := LookAlikeWarnings(cdeps, cdeps); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for pinned deps got %v", warnings)
+	}
+}
@@ -0,0 +1,119 @@
+package canticles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteManifest writes the build info, including its dependency pins,
+// to filename as an artifact for release and deployment tooling. Files
+// ending in .yaml or .yml are written as YAML, all others as JSON.
+func WriteManifest(filename string, bi *BuildInfo) error {
+	var b []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		b, err = MarshalYAML(bi)
+	default:
+		b, err = json.MarshalIndent(bi, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+// MarshalYAML encodes v as YAML. V is first encoded as json so json
+// struct tags are respected, object keys are sorted and all strings
+// are double quoted.
+func MarshalYAML(v interface{}) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	if err := d.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeYAML(&buf, generic, 0)
+	return buf.Bytes(), nil
+}
+
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			buf.WriteString(pad + "{}\n")
+			return
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.WriteString(pad + strconv.Quote(k) + ":")
+			writeYAMLValue(buf, val[k], indent)
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			buf.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range val {
+			buf.WriteString(pad + "-")
+			writeYAMLValue(buf, item, indent)
+		}
+	default:
+		buf.WriteString(pad + yamlScalar(val) + "\n")
+	}
+}
+
+// writeYAMLValue writes v after a key or list marker.
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, val, indent+1)
+			return
+		}
+		buf.WriteString(" {}\n")
+	case []interface{}:
+		if len(val) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, val, indent+1)
+			return
+		}
+		buf.WriteString(" []\n")
+	default:
+		buf.WriteString(" " + yamlScalar(val) + "\n")
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(val)
+	case json.Number:
+		return val.String()
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
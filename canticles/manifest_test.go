@@ -0,0 +1,69 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMarshalYAML(t *testing.T) {
+	v := map[string]interface{}{
+		"Revision": "abc",
+		"Dirty":    true,
+		"Deps": []map[string]interface{}{
+			{"Root": "a.com/x", "Revision": "1"},
+		},
+		"Empty": []string{},
+	}
+	b, err := MarshalYAML(v)
+	if err != nil {
+		t.Fatalf("Error marshaling yaml: %s", err.Error())
+	}
+	expected := `"Deps":
+  -
+    "Revision": "1"
+    "Root": "a.com/x"
+"Dirty": true
+"Empty": []
+"Revision": "abc"
+`
+	if string(b) != expected {
+		t.Errorf("Expected yaml:\n%s\ngot:\n%s", expected, string(b))
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	bi, err := NewBuildInfo(context.Background(), "abc", false, []*CanticleDependency{{Root: "a.com/x", Revision: "1"}})
+	if err != nil {
+		t.Fatalf("Error creating build info: %s", err.Error())
+	}
+	file := path.Join(dir, "release", "buildinfo.json")
+	if err := WriteManifest(file, bi); err != nil {
+		t.Fatalf("Error writing manifest: %s", err.Error())
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read struct {
+		Revision     string
+		CanticleDeps []*CanticleDependency
+	}
+	if err := json.Unmarshal(b, &read); err != nil {
+		t.Fatalf("Error reading manifest: %s", err.Error())
+	}
+	if read.Revision != "abc" || len(read.CanticleDeps) != 1 || read.CanticleDeps[0].Root != "a.com/x" {
+		t.Errorf("Unexpected manifest %s", string(b))
+	}
+	if err := WriteManifest(path.Join(dir, "buildinfo.yaml"), bi); err != nil {
+		t.Errorf("Error writing yaml manifest: %s", err.Error())
+	}
+}
@@ -0,0 +1,320 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The counters counted with Count.
+const (
+	MetricFetches               = "fetches"
+	MetricFetchErrors           = "fetch_errors"
+	MetricResolutionCacheHits   = "resolution_cache_hits"
+	MetricResolutionCacheMisses = "resolution_cache_misses"
+	MetricPackageCacheHits      = "package_cache_hits"
+	MetricPackageCacheMisses    = "package_cache_misses"
+	MetricMirrorHits            = "mirror_hits"
+	MetricMirrorMisses          = "mirror_misses"
+	MetricBytesDownloaded       = "bytes_downloaded"
+)
+
+// Counter, if non nil, is called with each Count. It may be called
+// concurrently.
+var Counter func(name string, n int64)
+
+// Count adds n to the counter name, one of the Metric constants.
+func Count(name string, n int64) {
+	if counter := Counter; counter != nil {
+		counter(name, n)
+	}
+}
+
+// MetricsConfig is where metrics are sent, it is part of the
+// UserConfig. Either or both of StatsD and Pushgateway may be set.
+type MetricsConfig struct {
+	// StatsD is the host:port of a statsd server metrics are
+	// sent to over udp.
+	StatsD string `json:",omitempty"`
+	// Pushgateway is the url of a Prometheus pushgateway metrics
+	// are pushed to.
+	Pushgateway string `json:",omitempty"`
+	// Prefix prefixes the names of the metrics, canticle if
+	// empty.
+	Prefix string `json:",omitempty"`
+	// Job is the pushgateway job pushed to, canticle if empty.
+	Job string `json:",omitempty"`
+	// Labels are the pushgateway grouping labels pushed to, e.g.
+	// {"instance": "ci-agent-12"}, as well as the command.
+	Labels map[string]string `json:",omitempty"`
+}
+
+// phaseMetrics totals the spans of a phase.
+type phaseMetrics struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+// Metrics collects the counters, spans and fetch events of an
+// operation and sends them where its Config says. Counters and spans
+// are sent to statsd as they were since the last Flush, and pushed to
+// the pushgateway in total, replacing those pushed before.
+type Metrics struct {
+	Config *MetricsConfig
+	// Command is the command measured, it is a grouping label
+	// of the metrics pushed.
+	Command string
+	mu      sync.Mutex
+	// sent are the counters last sent to statsd
+	counters, sent map[string]int64
+	phases         map[string]*phaseMetrics
+	pending        []*Span
+}
+
+// NewMetrics returns Metrics for command sent as config says.
+func NewMetrics(config *MetricsConfig, command string) *Metrics {
+	return &Metrics{
+		Config:   config,
+		Command:  command,
+		counters: make(map[string]int64),
+		sent:     make(map[string]int64),
+		phases:   make(map[string]*phaseMetrics),
+	}
+}
+
+// StartMetrics returns the Metrics of command if the UserConfig
+// configures any, otherwise nil. They are set as the Counter and
+// chained to the Instrument, and subscribed to the DefaultEvents so
+// every set of fetches is flushed as it finishes.
+func StartMetrics(command string) (*Metrics, error) {
+	config, err := LoadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Metrics == nil || (config.Metrics.StatsD == "" && config.Metrics.Pushgateway == "") {
+		return nil, nil
+	}
+	m := NewMetrics(config.Metrics, command)
+	Counter = m.Count
+	if instrument := Instrument; instrument != nil {
+		Instrument = func(span *Span) {
+			instrument(span)
+			m.Record(span)
+		}
+	} else {
+		Instrument = m.Record
+	}
+	DefaultEvents.Subscribe(m.Handle)
+	return m, nil
+}
+
+// Count adds n to the counter name.
+func (m *Metrics) Count(name string, n int64) {
+	m.mu.Lock()
+	m.counters[name] += n
+	m.mu.Unlock()
+}
+
+// Record adds span to the totals of its phase, it may be used as the
+// Instrument.
+func (m *Metrics) Record(span *Span) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.phases[span.Phase]
+	if p == nil {
+		p = &phaseMetrics{}
+		m.phases[span.Phase] = p
+	}
+	p.count++
+	p.total += span.Duration
+	if span.Duration > p.max {
+		p.max = span.Duration
+	}
+	if m.Config.StatsD != "" {
+		m.pending = append(m.pending, span)
+	}
+}
+
+// Handle counts fetches and their errors, and flushes once a set of
+// fetches finishes. It is an EventHandler.
+func (m *Metrics) Handle(ctx context.Context, ev Event) {
+	switch ev := ev.(type) {
+	case FetchFinished:
+		m.Count(MetricFetches, 1)
+		if ev.Err != nil {
+			m.Count(MetricFetchErrors, 1)
+		}
+	case FetchesFinished:
+		if err := m.Flush(ctx); err != nil {
+			LogWarnContext(ctx, "%s", err.Error())
+		}
+	}
+}
+
+func (m *Metrics) prefix() string {
+	if m.Config.Prefix != "" {
+		return m.Config.Prefix
+	}
+	return "canticle"
+}
+
+// StatsDLines returns the statsd lines of the counters and spans since
+// they were last returned. Counters are sent as counts, spans as
+// timers of their phase.
+func (m *Metrics) StatsDLines() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lines []string
+	for name, n := range m.counters {
+		if delta := n - m.sent[name]; delta != 0 {
+			lines = append(lines, fmt.Sprintf("%s.%s:%d|c", m.prefix(), name, delta))
+		}
+		m.sent[name] = n
+	}
+	sort.Strings(lines)
+	for _, span := range m.pending {
+		lines = append(lines, fmt.Sprintf("%s.%s:%d|ms", m.prefix(), strings.Replace(span.Phase, "-", "_", -1), span.Duration.Nanoseconds()/int64(time.Millisecond)))
+	}
+	m.pending = nil
+	return lines
+}
+
+// PrometheusText returns the totals of the counters and phases in the
+// Prometheus text format.
+func (m *Metrics) PrometheusText(now time.Time) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var buf bytes.Buffer
+	prefix := strings.Replace(m.prefix(), ".", "_", -1)
+	var names []string
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s_%s_total counter\n%s_%s_total %d\n", prefix, name, prefix, name, m.counters[name])
+	}
+	var phases []string
+	for phase := range m.phases {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	for _, metric := range []struct {
+		name, kind string
+		value      func(*phaseMetrics) string
+	}{
+		{"phase_spans_total", "counter", func(p *phaseMetrics) string { return fmt.Sprint(p.count) }},
+		{"phase_seconds_total", "counter", func(p *phaseMetrics) string { return fmt.Sprint(p.total.Seconds()) }},
+		{"phase_seconds_max", "gauge", func(p *phaseMetrics) string { return fmt.Sprint(p.max.Seconds()) }},
+	} {
+		if len(phases) == 0 {
+			break
+		}
+		fmt.Fprintf(&buf, "# TYPE %s_%s %s\n", prefix, metric.name, metric.kind)
+		for _, phase := range phases {
+			fmt.Fprintf(&buf, "%s_%s{phase=%q} %s\n", prefix, metric.name, phase, metric.value(m.phases[phase]))
+		}
+	}
+	fmt.Fprintf(&buf, "# TYPE %s_last_run_timestamp_seconds gauge\n%s_last_run_timestamp_seconds %d\n", prefix, prefix, now.Unix())
+	return buf.String()
+}
+
+// PushURL returns the pushgateway url of the job and grouping labels
+// of the metrics.
+func (m *Metrics) PushURL() string {
+	job := m.Config.Job
+	if job == "" {
+		job = "canticle"
+	}
+	labels := map[string]string{}
+	for k, v := range m.Config.Labels {
+		labels[k] = v
+	}
+	if m.Command != "" {
+		labels["command"] = m.Command
+	}
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	u := strings.TrimSuffix(m.Config.Pushgateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	for _, k := range keys {
+		u += "/" + url.PathEscape(k) + "/" + url.PathEscape(labels[k])
+	}
+	return u
+}
+
+// Flush sends the metrics to statsd and the pushgateway.
+func (m *Metrics) Flush(ctx context.Context) error {
+	if m.Config.StatsD != "" {
+		if err := sendStatsD(ctx, m.Config.StatsD, m.StatsDLines()); err != nil {
+			return fmt.Errorf("cant send metrics to statsd %s %s", m.Config.StatsD, err.Error())
+		}
+	}
+	if m.Config.Pushgateway != "" {
+		if err := m.push(ctx); err != nil {
+			return fmt.Errorf("cant push metrics to %s %s", m.Config.Pushgateway, err.Error())
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) push(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "PUT", m.PushURL(), strings.NewReader(m.PrometheusText(time.Now())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := HTTPClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// maxStatsDPacket keeps packets within the MTU of most networks.
+const maxStatsDPacket = 1432
+
+// sendStatsD sends lines to the statsd server at addr, as few to a
+// packet as fit.
+func sendStatsD(ctx context.Context, addr string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	_, err = conn.Write(packet.Bytes())
+	return err
+}
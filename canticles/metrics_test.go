@@ -0,0 +1,70 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	statsd, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	defer statsd.Close()
+	var pushed, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		pushed, path = string(b), r.URL.Path
+	}))
+	defer server.Close()
+
+	config := &MetricsConfig{StatsD: statsd.LocalAddr().String(), Pushgateway: server.URL, Labels: map[string]string{"instance": "ci 1"}}
+	m := NewMetrics(config, "get")
+	m.Count(MetricPackageCacheHits, 2)
+	m.Record(&Span{Phase: PhaseGoList, Duration: 1500 * time.Millisecond})
+	m.Handle(context.Background(), FetchFinished{Root: "a"})
+	m.Handle(context.Background(), FetchFinished{Root: "b", Err: errors.New("failed")})
+	m.Handle(context.Background(), FetchesFinished{Fetched: 2, Failed: 1})
+
+	buf := make([]byte, maxStatsDPacket)
+	statsd.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := statsd.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Error reading statsd packet: %s", err.Error())
+	}
+	expected := []string{
+		"canticle.fetch_errors:1|c",
+		"canticle.fetches:2|c",
+		"canticle.package_cache_hits:2|c",
+		"canticle.go_list:1500|ms",
+	}
+	if lines := strings.Split(string(buf[:n]), "\n"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected statsd lines %v got %v", expected, lines)
+	}
+	if path != "/metrics/job/canticle/command/get/instance/ci 1" {
+		t.Errorf("Expected push to the job and labels got %s", path)
+	}
+	for _, line := range []string{
+		"canticle_fetches_total 2",
+		"canticle_fetch_errors_total 1",
+		`canticle_phase_seconds_total{phase="go-list"} 1.5`,
+	} {
+		if !strings.Contains(pushed, line+"\n") {
+			t.Errorf("Expected pushed metrics to contain %s got %s", line, pushed)
+		}
+	}
+
+	// Counters are sent to statsd as deltas
+	m.Count(MetricFetches, 1)
+	if lines := m.StatsDLines(); !reflect.DeepEqual(lines, []string{"canticle.fetches:1|c"}) {
+		t.Errorf("Expected only the new fetch got %v", lines)
+	}
+}
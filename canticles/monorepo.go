@@ -0,0 +1,246 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// MonorepoDir is the hidden directory of the gopaths src monorepos are
+// cloned into. The go tool ignores it, their subpaths are only seen
+// through the import paths linked to them.
+const MonorepoDir = ".canticle-monorepos"
+
+// MonorepoRoot returns the root, within the gopaths src, of the clone
+// of the repo at source. e.g. https://git.example.com/big.git is
+// cloned to .canticle-monorepos/git.example.com/big.
+func MonorepoRoot(source string) string {
+	if i := strings.Index(source, "://"); i != -1 {
+		source = source[i+3:]
+	}
+	if i := strings.Index(source, "@"); i != -1 && !strings.Contains(source[:i], "/") {
+		source = source[i+1:]
+	}
+	source = strings.Replace(source, ":", "/", -1)
+	source = strings.TrimSuffix(path.Clean("/"+source), ".git")
+	return MonorepoDir + source
+}
+
+// CheckSubpath returns an error if subpath is not a directory within
+// a repo.
+func CheckSubpath(subpath string) error {
+	clean := path.Clean(subpath)
+	if subpath == "" || path.IsAbs(subpath) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("subpath %s is not a directory within its repo", subpath)
+	}
+	return nil
+}
+
+// monorepoClones tracks the commits the monorepos were set to by one
+// fetch, so a monorepo shared by several dependencies is fetched
+// once and not moved between their revisions.
+type monorepoClones struct {
+	sync.Mutex
+	revs map[string]string
+}
+
+// A MonorepoVCS fetches a dependency whose Root is the Subpath of a
+// larger repo at Source. The repo is cloned once into MonorepoDir,
+// however many dependencies use it, and the Subpath is linked at the
+// Root.
+type MonorepoVCS struct {
+	Root    string
+	Source  string
+	Subpath string
+	Gopath  string
+	Cmd     *vcs.Cmd
+	clones  *monorepoClones
+}
+
+// NewMonorepoVCS returns the MonorepoVCS of root at subpath of the
+// repo at source.
+func NewMonorepoVCS(root, source, subpath, gopath string, cmd *vcs.Cmd) *MonorepoVCS {
+	return &MonorepoVCS{
+		Root:    root,
+		Source:  source,
+		Subpath: subpath,
+		Gopath:  gopath,
+		Cmd:     cmd,
+		clones:  &monorepoClones{revs: make(map[string]string)},
+	}
+}
+
+// CloneRoot returns the root of the clone of the monorepo within the
+// gopath.
+func (mv *MonorepoVCS) CloneRoot() string {
+	return MonorepoRoot(mv.Source)
+}
+
+func (mv *MonorepoVCS) clone() *LocalVCS {
+	root := mv.CloneRoot()
+	return NewLocalVCS(root, root, mv.Gopath, mv.Cmd)
+}
+
+// Create clones the monorepo, unless it is already cloned, sets it to
+// rev and links the Subpath at the Root. If another dependency set
+// the monorepo to a different revision an error is returned.
+func (mv *MonorepoVCS) Create(ctx context.Context, rev string) error {
+	mv.clones.Lock()
+	defer mv.clones.Unlock()
+	root := mv.CloneRoot()
+	if fetched, ok := mv.clones.revs[root]; ok {
+		if rev != "" && rev != fetched {
+			// The same commit may be pinned by tag, branch or hash
+			if commit, err := mv.clone().ResolveRev(ctx, rev); err != nil || commit != fetched {
+				return &RevisionError{Root: mv.Root, Revision: rev, Err: fmt.Errorf("monorepo %s is at %s for another subpath", mv.Source, fetched)}
+			}
+		}
+		return mv.link()
+	}
+	if _, err := os.Stat(PackageSource(mv.Gopath, root)); err != nil {
+		LogVerboseContext(ctx, "Cloning monorepo %s for %s", mv.Source, mv.Root)
+		pv := &PackageVCS{Repo: &vcs.RepoRoot{VCS: mv.Cmd, Repo: mv.Source, Root: root}, Gopath: mv.Gopath}
+		if err := pv.Create(ctx, ""); err != nil {
+			return err
+		}
+	}
+	if err := mv.setRev(ctx, rev); err != nil {
+		return err
+	}
+	return mv.link()
+}
+
+// SetRev sets the monorepo to rev, see Create.
+func (mv *MonorepoVCS) SetRev(ctx context.Context, rev string) error {
+	return mv.Create(ctx, rev)
+}
+
+// setRev sets the clone to rev and records its commit. The clones
+// lock must be held.
+func (mv *MonorepoVCS) setRev(ctx context.Context, rev string) error {
+	lv := mv.clone()
+	if rev != "" {
+		// Only fetch if the clone does not have rev already
+		if err := lv.TagSync(ctx, rev); err != nil {
+			if err := lv.SetRev(ctx, rev); err != nil {
+				return &RevisionError{Root: mv.Root, Revision: rev, Err: err}
+			}
+		}
+	}
+	return mv.recordRev(ctx)
+}
+
+// recordRev records the commit the clone is at. The clones lock must
+// be held.
+func (mv *MonorepoVCS) recordRev(ctx context.Context) error {
+	current, err := mv.clone().GetRev(ctx)
+	if err != nil {
+		return fmt.Errorf("cant get revision of monorepo %s %s", mv.Source, err.Error())
+	}
+	mv.clones.revs[mv.CloneRoot()] = current
+	return nil
+}
+
+// link links the Subpath of the clone at the Root, replacing any link
+// already there.
+func (mv *MonorepoVCS) link() error {
+	target := filepath.Join(PackageSource(mv.Gopath, mv.CloneRoot()), filepath.FromSlash(mv.Subpath))
+	if s, err := os.Stat(target); err != nil || !s.IsDir() {
+		return fmt.Errorf("cant link %s, %s is not a directory in %s", mv.Root, mv.Subpath, mv.Source)
+	}
+	src := PackageSource(mv.Gopath, mv.Root)
+	if current, err := os.Readlink(src); err == nil {
+		if current == target {
+			return nil
+		}
+		if err := os.Remove(src); err != nil {
+			return fmt.Errorf("cant relink %s %s", src, err.Error())
+		}
+	} else if _, err := os.Lstat(src); err == nil {
+		return fmt.Errorf("cant link %s into %s, it is not a link", mv.Subpath, src)
+	}
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		return fmt.Errorf("cant create dir for %s %s", mv.Root, err.Error())
+	}
+	return os.Symlink(target, src)
+}
+
+// GetRev returns the revision of the monorepo.
+func (mv *MonorepoVCS) GetRev(ctx context.Context) (string, error) {
+	return mv.clone().GetRev(ctx)
+}
+
+// GetBranch returns the branch of the monorepo.
+func (mv *MonorepoVCS) GetBranch(ctx context.Context) (string, error) {
+	return mv.clone().GetBranch(ctx)
+}
+
+// UpdateBranch updates the monorepo to the head of branch. Every
+// dependency on the monorepo sees the update.
+func (mv *MonorepoVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
+	mv.clones.Lock()
+	defer mv.clones.Unlock()
+	updated, res, err := mv.clone().UpdateBranch(ctx, branch)
+	if err != nil || !updated {
+		return updated, res, err
+	}
+	if _, ok := mv.clones.revs[mv.CloneRoot()]; ok {
+		if err := mv.recordRev(ctx); err != nil {
+			return updated, res, err
+		}
+	}
+	return updated, res, nil
+}
+
+// GetSource returns the Source of the monorepo.
+func (mv *MonorepoVCS) GetSource(ctx context.Context) (string, error) {
+	return mv.Source, nil
+}
+
+// GetRoot returns the Root.
+func (mv *MonorepoVCS) GetRoot() string {
+	return mv.Root
+}
+
+// MonorepoRepoResolver resolves a MonorepoVCS for dependencies with a
+// Subpath. The VCSs it resolves share their clones.
+type MonorepoRepoResolver struct {
+	Gopath string
+	clones *monorepoClones
+}
+
+// NewMonorepoRepoResolver returns a MonorepoRepoResolver cloning into
+// gopath.
+func NewMonorepoRepoResolver(gopath string) *MonorepoRepoResolver {
+	return &MonorepoRepoResolver{Gopath: gopath, clones: &monorepoClones{revs: make(map[string]string)}}
+}
+
+// ResolveRepo returns a MonorepoVCS if dep has a Subpath.
+func (mr *MonorepoRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	if dep == nil || dep.Subpath == "" {
+		return nil, NewResolutionFailureError(importPath, "monorepo")
+	}
+	if dep.SourcePath == "" {
+		return nil, fmt.Errorf("cant fetch %s from subpath %s without a SourcePath", importPath, dep.Subpath)
+	}
+	if err := CheckSubpath(dep.Subpath); err != nil {
+		return nil, err
+	}
+	cmd := GuessVCS(ctx, dep.SourcePath)
+	if cmd == nil {
+		return nil, NewResolutionFailureError(importPath, "monorepo")
+	}
+	root := dep.Root
+	if root == "" {
+		root = importPath
+	}
+	mv := NewMonorepoVCS(root, dep.SourcePath, path.Clean(dep.Subpath), mr.Gopath, cmd)
+	mv.clones = mr.clones
+	return mv, nil
+}
@@ -0,0 +1,101 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestMonorepoRoot(t *testing.T) {
+	tests := map[string]string{
+		"https://git.example.com/big.git": ".canticle-monorepos/git.example.com/big",
+		"git@git.example.com:org/big.git": ".canticle-monorepos/git.example.com/org/big",
+		"ssh://git@example.com/big/":      ".canticle-monorepos/example.com/big",
+		"/srv/big":                        ".canticle-monorepos/srv/big",
+	}
+	for source, expected := range tests {
+		if root := MonorepoRoot(source); root != expected {
+			t.Errorf("Expected root of %s to be %s got %s", source, expected, root)
+		}
+	}
+	for subpath, valid := range map[string]bool{"go/lib": true, "": false, ".": false, "../x": false, "/abs": false} {
+		if err := CheckSubpath(subpath); (err == nil) != valid {
+			t.Errorf("Expected subpath %q valid %v got %v", subpath, valid, err)
+		}
+	}
+}
+
+func TestMonorepoVCS(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	upstream := filepath.Join(testHome, "big")
+	for _, name := range []string{"go/a/a.go", "go/b/b.go"} {
+		file := filepath.Join(upstream, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte("package x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = upstream
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	git("init")
+	git("add", ".")
+	git("-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "first")
+	first, err := NewLocalVCS("big", "big", testHome, vcs.ByCmd("git")).CurrentRevCmd.Exec(context.Background(), upstream)
+	if err != nil {
+		t.Fatalf("Error getting rev: %s", err.Error())
+	}
+	git("-c", "user.name=test", "-c", "user.email=test@test.com", "tag", "-a", "-m", "v1", "v1.0.0")
+	git("-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "--allow-empty", "-m", "second")
+
+	gopath := filepath.Join(testHome, "gopath")
+	a := NewMonorepoVCS("example.com/a", upstream, "go/a", gopath, vcs.ByCmd("git"))
+	b := NewMonorepoVCS("example.com/b", upstream, "go/b", gopath, vcs.ByCmd("git"))
+	b.clones = a.clones
+	if err := a.Create(context.Background(), first); err != nil {
+		t.Fatalf("Error creating monorepo vcs: %s", err.Error())
+	}
+	if err := b.Create(context.Background(), "v1.0.0"); err != nil {
+		t.Fatalf("Error creating second subpath by tag of the same commit: %s", err.Error())
+	}
+	for root, file := range map[string]string{"example.com/a": "a.go", "example.com/b": "b.go"} {
+		if _, err := os.Stat(filepath.Join(PackageSource(gopath, root), file)); err != nil {
+			t.Errorf("Expected %s linked from the monorepo got %s", root, err.Error())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PackageSource(gopath, a.CloneRoot()), ".git")); err != nil {
+		t.Errorf("Expected one clone of the monorepo got %s", err.Error())
+	}
+	if rev, err := b.GetRev(context.Background()); err != nil || rev != first {
+		t.Errorf("Expected revision %s got %s %v", first, rev, err)
+	}
+	if err := b.SetRev(context.Background(), "master"); err == nil {
+		t.Errorf("Expected error moving a shared monorepo to another revision")
+	}
+	missing := NewMonorepoVCS("example.com/c", upstream, "go/c", gopath, vcs.ByCmd("git"))
+	missing.clones = a.clones
+	if err := missing.Create(context.Background(), ""); err == nil {
+		t.Errorf("Expected error linking a missing subpath")
+	}
+
+	resolver := NewMonorepoRepoResolver(gopath)
+	if _, err := resolver.ResolveRepo(context.Background(), "example.com/a", &CanticleDependency{Root: "example.com/a"}); err == nil {
+		t.Errorf("Expected error resolving a dependency without a subpath")
+	}
+	if _, err := resolver.ResolveRepo(context.Background(), "example.com/a", &CanticleDependency{Root: "example.com/a", SourcePath: upstream, Subpath: "../a"}); err == nil {
+		t.Errorf("Expected error resolving a subpath outside the repo")
+	}
+}
@@ -0,0 +1,318 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A RemoteLister lists the revisions of the remote of a repo.
+type RemoteLister interface {
+	// RemoteTags returns the revision of each tag of the remote.
+	RemoteTags(ctx context.Context) (map[string]string, error)
+	// RemoteHead returns the revision of branch on the remote, of
+	// its default branch if empty.
+	RemoteHead(ctx context.Context, branch string) (string, error)
+}
+
+// An OutdatedDep is how far a dependency is behind its remote.
+type OutdatedDep struct {
+	Root string
+	// Source is the SourcePath of the dependency.
+	Source string `json:",omitempty"`
+	// Current is the revision pinned, CurrentTag the highest
+	// semantic version tag of it if any.
+	Current    string
+	CurrentTag string `json:",omitempty"`
+	// Latest is the highest semantic version tag upstream if
+	// Current is tagged, else the head of Branch upstream.
+	Latest    string `json:",omitempty"`
+	LatestTag string `json:",omitempty"`
+	// Branch is the branch Current is on, empty for the default
+	// branch. It is only used if Current is not tagged.
+	Branch string `json:",omitempty"`
+	// NewerTags are the semantic version tags newer than
+	// CurrentTag, lowest first.
+	NewerTags []string `json:",omitempty"`
+	// Behind is the number of revisions of Latest Current does
+	// not have, -1 if Latest has not been fetched.
+	Behind int
+	// Enrichment is what the host of the dependency says of its
+	// upgrade, see Enricher.
+	Enrichment *Enrichment `json:",omitempty"`
+	// Err is why the remote could not be checked.
+	Err string `json:",omitempty"`
+}
+
+// Outdated returns true if a newer revision was found upstream.
+func (od *OutdatedDep) Outdated() bool {
+	return od.Err == "" && od.Latest != "" && od.Latest != od.Current
+}
+
+// Upgrade returns the upgrade of the dependency to Latest.
+func (od *OutdatedDep) Upgrade() *Upgrade {
+	u := &Upgrade{Root: od.Root, Source: od.Source, From: od.Current, To: od.Latest}
+	if od.CurrentTag != "" {
+		u.From, u.To = od.CurrentTag, od.LatestTag
+	}
+	return u
+}
+
+// String returns the dependency as cant outdated prints it.
+func (od *OutdatedDep) String() string {
+	switch {
+	case od.Err != "":
+		return fmt.Sprintf("%s: unknown, %s", od.Root, od.Err)
+	case !od.Outdated():
+		return fmt.Sprintf("%s: up to date", od.Root)
+	}
+	behind := "not fetched"
+	if od.Behind >= 0 {
+		behind = fmt.Sprintf("%d revisions behind", od.Behind)
+	}
+	if od.CurrentTag != "" {
+		return fmt.Sprintf("%s: %s -> %s, %d newer tags, %s", od.Root, od.CurrentTag, od.LatestTag, len(od.NewerTags), behind)
+	}
+	branch := od.Branch
+	if branch == "" {
+		branch = "the default branch"
+	}
+	return fmt.Sprintf("%s: %s -> %s on %s, %s", od.Root, od.Current, od.Latest, branch, behind)
+}
+
+// CheckOutdated returns how far current, a revision of the repo of
+// root, is behind the remote listed by lister. If current is tagged
+// with a semantic version it is compared to the highest newer tag,
+// otherwise to the head of branch. Behind is counted if lister can
+// compare revisions, see LocalVCS.AheadBehind.
+func CheckOutdated(ctx context.Context, lister RemoteLister, root, current, branch string) *OutdatedDep {
+	od := &OutdatedDep{Root: root, Current: current, Behind: -1}
+	tags, err := lister.RemoteTags(ctx)
+	if err != nil {
+		od.Err = err.Error()
+		return od
+	}
+	var currentVersion *Version
+	for tag, rev := range tags {
+		if rev != current && tag != current {
+			continue
+		}
+		v, err := ParseVersion(tag)
+		if err == nil && (currentVersion == nil || v.Compare(currentVersion) > 0) {
+			currentVersion = v
+		}
+	}
+	if currentVersion != nil {
+		od.CurrentTag = currentVersion.Original
+		od.LatestTag, od.Latest = od.CurrentTag, tags[od.CurrentTag]
+		var versions []*Version
+		if newer, err := ParseConstraint(">" + od.CurrentTag); err == nil {
+			for tag := range tags {
+				if v, err := ParseVersion(tag); err == nil && newer.Check(v) {
+					versions = append(versions, v)
+				}
+			}
+		}
+		sort.Sort(versionsByOrder(versions))
+		for _, v := range versions {
+			od.NewerTags = append(od.NewerTags, v.Original)
+		}
+		if len(versions) > 0 {
+			od.LatestTag = versions[len(versions)-1].Original
+			od.Latest = tags[od.LatestTag]
+		}
+		// A tag pinned by name is current at its revision
+		od.Current = tags[od.CurrentTag]
+	} else {
+		od.Branch = branch
+		if od.Latest, err = lister.RemoteHead(ctx, branch); err != nil {
+			od.Err = err.Error()
+			return od
+		}
+	}
+	if !od.Outdated() {
+		od.Behind = 0
+		return od
+	}
+	if comparer, ok := lister.(interface {
+		AheadBehind(ctx context.Context, from, rev string) (int, int, error)
+	}); ok {
+		if _, behind, err := comparer.AheadBehind(ctx, od.Current, od.Latest); err == nil {
+			od.Behind = behind
+		} else {
+			LogVerboseContext(ctx, "Cant count revisions of %s behind %s %s", root, od.Latest, err.Error())
+		}
+	}
+	return od
+}
+
+type versionsByOrder []*Version
+
+func (v versionsByOrder) Len() int      { return len(v) }
+func (v versionsByOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v versionsByOrder) Less(i, j int) bool {
+	if c := v[i].Compare(v[j]); c != 0 {
+		return c < 0
+	}
+	return v[i].Original < v[j].Original
+}
+
+type Outdated struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	JSON    bool
+	Fetch   bool
+	Enrich  bool
+}
+
+func NewOutdated() *Outdated {
+	f := flag.NewFlagSet("outdated", flag.ExitOnError)
+	o := &Outdated{flags: f}
+	f.BoolVar(&o.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&o.JSON, "json", false, "Print every dependency as JSON")
+	f.BoolVar(&o.Fetch, "fetch", false, "Fetch the remotes of the dependencies to count how far behind they are")
+	f.BoolVar(&o.Enrich, "enrich", false, "Annotate outdated dependencies using the API of their host")
+	return o
+}
+
+var outdated = NewOutdated()
+
+var OutdatedCommand = &Command{
+	Name:             "outdated",
+	UsageLine:        "outdated [-v] [-json] [-fetch] [-enrich]",
+	ShortDescription: "Print the dependencies with newer revisions upstream.",
+	LongDescription: `The outdated command asks the remote of each dependency in the Canticle file of the current directory, as checked out in the GOPATH, for revisions newer than the one pinned, printing the pinned and latest revision of each outdated dependency and how many of them are outdated.
+
+Dependencies pinned at a semantic version tag, such as v1.2.0, are compared to the highest tag upstream, pre-releases excluded, with the number of newer tags. Other dependencies are compared to the head upstream of the branch saved with cant save -b, or checked out, or else the default branch.
+
+The number of revisions a dependency is behind is printed if its latest revision has been fetched.
+
+Specify -fetch to fetch the remote of each dependency first, without changing its checkout, so how far behind it is can be counted.
+
+Specify -enrich to annotate each outdated dependency with its compare page, the date of its latest revision and its release notes, asked from the GitHub or GitLab API of its host. GITHUB_TOKEN and GITLAB_TOKEN authenticate requests if set. Hosts which can not be reached are skipped.
+
+Specify -json to print every dependency, outdated or not, as JSON.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: outdated.flags,
+	Cmd:   outdated,
+}
+
+// Run the outdated command.
+func (o *Outdated) Run(ctx context.Context, args []string) {
+	if o.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	deps, err := o.Outdated(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	if o.JSON {
+		b, err := json.MarshalIndent(deps, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	count := 0
+	for _, od := range deps {
+		if !od.Outdated() && od.Err == "" {
+			continue
+		}
+		if od.Outdated() {
+			count++
+		}
+		fmt.Println(od.String())
+		if e := od.Enrichment; e != nil {
+			if e.CompareURL != "" {
+				fmt.Printf("    %s\n", e.CompareURL)
+			}
+			if !e.Date.IsZero() {
+				fmt.Printf("    committed %s\n", e.Date.Format("2006-01-02"))
+			}
+			if notes := strings.TrimSpace(e.ReleaseNotes); notes != "" {
+				fmt.Printf("    %s\n", strings.SplitN(notes, "\n", 2)[0])
+			}
+		}
+	}
+	fmt.Printf("%d of %d dependencies outdated\n", count, len(deps))
+}
+
+// Outdated checks every dependency saved in the Canticle file of the
+// project at path against its remote, see CheckOutdated.
+func (o *Outdated) Outdated(ctx context.Context, gopath, path string) ([]*OutdatedDep, error) {
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	var enricher *Enricher
+	if o.Enrich {
+		enricher = NewEnricher()
+	}
+	resolver := &LocalRepoResolver{LocalPath: gopath}
+	deps := make([]*OutdatedDep, 0, len(cdeps))
+	for _, cdep := range cdeps {
+		LogVerboseContext(ctx, "Checking %s for newer revisions", cdep.Root)
+		od := o.check(ctx, resolver, cdep)
+		od.Source = cdep.SourcePath
+		if enricher != nil && od.Outdated() {
+			od.Enrichment = enricher.Enrich(ctx, od.Upgrade())
+		}
+		deps = append(deps, od)
+	}
+	return deps, nil
+}
+
+// check returns how far cdep, as checked out in the gopath of
+// resolver, is behind its remote.
+func (o *Outdated) check(ctx context.Context, resolver RepoResolver, cdep *CanticleDependency) *OutdatedDep {
+	unknown := func(err error) *OutdatedDep {
+		return &OutdatedDep{Root: cdep.Root, Current: cdep.Revision, Behind: -1, Err: err.Error()}
+	}
+	v, err := resolver.ResolveRepo(ctx, cdep.Root, cdep)
+	if err != nil {
+		return unknown(fmt.Errorf("cant find it in the gopath, cant get it first"))
+	}
+	lv, ok := v.(*LocalVCS)
+	if !ok || lv.Cmd == nil {
+		return unknown(fmt.Errorf("it is not under version control"))
+	}
+	if o.Fetch && lv.UpdateCmd != nil {
+		if _, err := lv.UpdateCmd.Exec(ctx, PackageSource(lv.SrcPath, lv.Root)); err != nil {
+			return unknown(fmt.Errorf("cant fetch %s", err.Error()))
+		}
+	}
+	current, branch := cdep.Revision, ""
+	// Revisions saved with cant save -b are branches
+	if current != "" && lv.Branches != nil && lv.RevIsBranch(ctx, current) {
+		branch = current
+		current = ""
+	}
+	if current == "" {
+		if current, err = lv.GetRev(ctx); err != nil {
+			return unknown(err)
+		}
+	}
+	if branch == "" && lv.BranchCmd != nil {
+		if branch, err = lv.GetBranch(ctx); err != nil {
+			// Checkouts of a revision are on no branch
+			branch = ""
+		}
+	}
+	return CheckOutdated(ctx, lv, cdep.Root, current, branch)
+}
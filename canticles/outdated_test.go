@@ -0,0 +1,123 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+type testRemoteLister struct {
+	tags  map[string]string
+	heads map[string]string
+}
+
+func (l *testRemoteLister) RemoteTags(ctx context.Context) (map[string]string, error) {
+	return l.tags, nil
+}
+
+func (l *testRemoteLister) RemoteHead(ctx context.Context, branch string) (string, error) {
+	if head, ok := l.heads[branch]; ok {
+		return head, nil
+	}
+	return "", errors.New("no such branch")
+}
+
+func TestCheckOutdated(t *testing.T) {
+	lister := &testRemoteLister{
+		tags:  map[string]string{"v1.0.0": "a", "v1.1.0": "b", "v1.2.0-rc.1": "c", "v1.2.0": "d", "nightly": "e"},
+		heads: map[string]string{"": "e", "dev": "f"},
+	}
+	tests := []struct {
+		current, branch string
+		expected        *OutdatedDep
+	}{
+		{"a", "", &OutdatedDep{Current: "a", CurrentTag: "v1.0.0", Latest: "d", LatestTag: "v1.2.0", NewerTags: []string{"v1.1.0", "v1.2.0"}, Behind: -1}},
+		{"v1.1.0", "", &OutdatedDep{Current: "b", CurrentTag: "v1.1.0", Latest: "d", LatestTag: "v1.2.0", NewerTags: []string{"v1.2.0"}, Behind: -1}},
+		{"d", "", &OutdatedDep{Current: "d", CurrentTag: "v1.2.0", Latest: "d", LatestTag: "v1.2.0"}},
+		{"x", "", &OutdatedDep{Current: "x", Latest: "e", Behind: -1}},
+		{"x", "dev", &OutdatedDep{Current: "x", Branch: "dev", Latest: "f", Behind: -1}},
+		{"x", "gone", &OutdatedDep{Current: "x", Branch: "gone", Behind: -1, Err: "no such branch"}},
+	}
+	for _, test := range tests {
+		test.expected.Root = "dep.com/x"
+		od := CheckOutdated(context.Background(), lister, "dep.com/x", test.current, test.branch)
+		if !reflect.DeepEqual(test.expected, od) {
+			t.Errorf("Expected %s at %s to be %+v got %+v", test.current, test.branch, test.expected, od)
+		}
+	}
+	od := CheckOutdated(context.Background(), lister, "dep.com/x", "a", "")
+	if s := od.String(); s != "dep.com/x: v1.0.0 -> v1.2.0, 2 newer tags, not fetched" {
+		t.Errorf("Unexpected outdated line %s", s)
+	}
+	if u := od.Upgrade(); u.From != "v1.0.0" || u.To != "v1.2.0" {
+		t.Errorf("Expected upgrade from v1.0.0 to v1.2.0 got %+v", u)
+	}
+}
+
+func TestLocalVCSRemote(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	upstream := filepath.Join(tmpdir, "upstream")
+	gopath := filepath.Join(tmpdir, "gopath")
+	if err := os.MkdirAll(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(upstream, "init", "-q")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "first")
+	git(upstream, "tag", "v1.0.0")
+	first := git(upstream, "rev-parse", "HEAD")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "second")
+	git(upstream, "tag", "-a", "-m", "release", "v1.1.0")
+	second := git(upstream, "rev-parse", "HEAD")
+	dep := PackageSource(gopath, "dep.com/x")
+	if err := os.MkdirAll(filepath.Dir(dep), 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(tmpdir, "clone", "-q", upstream, dep)
+	git(dep, "checkout", "-q", first)
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "third")
+	third := git(upstream, "rev-parse", "HEAD")
+
+	lv := NewLocalVCS("dep.com/x", "dep.com/x", gopath, vcs.ByCmd("git"))
+	tags, err := lv.RemoteTags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"v1.0.0": first, "v1.1.0": second}
+	if !reflect.DeepEqual(expected, tags) {
+		t.Errorf("Expected remote tags %v got %v", expected, tags)
+	}
+	head, err := lv.RemoteHead(context.Background(), "")
+	if err != nil || head != third {
+		t.Errorf("Expected remote head %s got %s %v", third, head, err)
+	}
+
+	od := CheckOutdated(context.Background(), lv, "dep.com/x", first, "")
+	if od.LatestTag != "v1.1.0" || od.Behind != 1 {
+		t.Errorf("Expected v1.1.0 one revision ahead got %+v", od)
+	}
+	od = CheckOutdated(context.Background(), lv, "dep.com/x", "abcdef", "")
+	if od.Latest != third || od.Behind != -1 {
+		t.Errorf("Expected unfetched head %s got %+v", third, od)
+	}
+}
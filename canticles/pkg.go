@@ -1,10 +1,13 @@
 package canticles
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"go/build"
-	"os"
+	"io"
 	"os/exec"
 	"strings"
 )
@@ -33,16 +36,17 @@ type Package struct {
 	// Note: These fields are part of the go command's public API.
 	// See list.go.  It is okay to add fields, but not to change or
 	// remove existing ones.  Keep in sync with list.go
-	Dir         string `json:",omitempty"` // directory containing package sources
-	ImportPath  string `json:",omitempty"` // import path of package in dir
-	Name        string `json:",omitempty"` // package name
-	Doc         string `json:",omitempty"` // package documentation string
-	Target      string `json:",omitempty"` // install path
-	Goroot      bool   `json:",omitempty"` // is this package found in the Go root?
-	Standard    bool   `json:",omitempty"` // is this package part of the standard Go library?
-	Stale       bool   `json:",omitempty"` // would 'go install' do anything for this package?
-	Root        string `json:",omitempty"` // Go root or Go path dir containing this package
-	ConflictDir string `json:",omitempty"` // Dir is hidden by this other directory
+	Dir           string `json:",omitempty"` // directory containing package sources
+	ImportPath    string `json:",omitempty"` // import path of package in dir
+	Name          string `json:",omitempty"` // package name
+	Doc           string `json:",omitempty"` // package documentation string
+	Target        string `json:",omitempty"` // install path
+	Goroot        bool   `json:",omitempty"` // is this package found in the Go root?
+	Standard      bool   `json:",omitempty"` // is this package part of the standard Go library?
+	Stale         bool   `json:",omitempty"` // would 'go install' do anything for this package?
+	Root          string `json:",omitempty"` // Go root or Go path dir containing this package
+	ConflictDir   string `json:",omitempty"` // Dir is hidden by this other directory
+	ImportComment string `json:",omitempty"` // path in import comment on package statement
 
 	// Source files
 	GoFiles        []string `json:",omitempty"` // .go source files (excluding CgoFiles, TestGoFiles, XTestGoFiles)
@@ -119,10 +123,10 @@ func filterStrings(strings []string, f func(string) bool) []string {
 // package. Path should be the import path of the package. Package
 // will be nil if an error occurs. Package itself may also have
 // errors.
-func LoadPackage(pkgPath, gohome string) (*Package, error) {
-	cmd := exec.Command("go", "list", "--json", "-e", pkgPath)
-	LogVerbose("Running command go list --json -e %s", pkgPath)
-	cmd.Env = PatchEnviroment(os.Environ(), "GOPATH", gohome)
+func LoadPackage(ctx context.Context, pkgPath, gohome string) (*Package, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "--json", "-e", pkgPath)
+	LogVerboseContext(ctx, "Running command go list --json -e %s", pkgPath)
+	cmd.Env = GoEnviroment(gohome)
 	result, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, errors.New(string(result))
@@ -140,12 +144,72 @@ func LoadPackage(pkgPath, gohome string) (*Package, error) {
 	return pkg, nil
 }
 
+// LoadPackages uses a single `go list --json` invocation to get
+// details about many local go packages at once. The result maps each
+// listed import path to its Package. Unlike LoadPackage packages with
+// errors are still returned, the caller should check Package.Error.
+func LoadPackages(ctx context.Context, gohome string, pkgPaths ...string) (map[string]*Package, error) {
+	return loadPackages(ctx, gohome, nil, pkgPaths...)
+}
+
+// loadPackages is LoadPackages running go list with the KEY=VALUE
+// variables of env too, see goEnviroment.
+func loadPackages(ctx context.Context, gohome string, env []string, pkgPaths ...string) (map[string]*Package, error) {
+	pkgs := make(map[string]*Package, len(pkgPaths))
+	if len(pkgPaths) == 0 {
+		return pkgs, nil
+	}
+	defer StartSpan(PhaseGoList, strings.Join(pkgPaths, " "))()
+	args := append([]string{"list", "--json", "-e"}, pkgPaths...)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	LogVerboseContext(ctx, "Running command go list --json -e for %d packages", len(pkgPaths))
+	cmd.Env = goEnviroment(gohome, env)
+	result, err := cmd.Output()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("go list failed for batch %v: %s", pkgPaths, err.Error())
+	}
+
+	// go list emits a stream of json objects, not an array
+	d := json.NewDecoder(bytes.NewReader(result))
+	for {
+		pkg := &Package{}
+		err := d.Decode(pkg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		pkgs[pkg.ImportPath] = pkg
+	}
+	return pkgs, nil
+}
+
+// CanonicalPath returns the import path declared by the packages
+// import comment, or the empty string if it does not declare one or
+// it matches the path the package was loaded from.
+func (p *Package) CanonicalPath() string {
+	if p.ImportComment == "" || p.ImportComment == p.ImportPath {
+		return ""
+	}
+	return p.ImportComment
+}
+
 // RemoteImports returns the packages set of remote imports (as
-// defined by IsRemote).
+// defined by IsRemote). If includeTest is true the imports of both
+// internal and external (package foo_test) test files are included.
 func (p *Package) RemoteImports(includeTest bool) []string {
-	imports := p.Imports
+	imports := make([]string, 0, len(p.Imports)+len(p.TestImports)+len(p.XTestImports))
+	imports = append(imports, p.Imports...)
 	if includeTest {
 		imports = append(imports, p.TestImports...)
+		// External tests always import the package itself
+		imports = append(imports, filterStrings(p.XTestImports, func(s string) bool {
+			return s != p.ImportPath
+		})...)
 	}
 
 	return filterStrings(imports, IsRemote)
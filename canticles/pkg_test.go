@@ -1,6 +1,7 @@
 package canticles
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"testing"
@@ -31,6 +32,11 @@ func TestPackageRemoteImports(t *testing.T) {
 			"testing",
 			"github.comcast.com/viper-cog/assert",
 		},
+		XTestImports: []string{
+			"github.comcast.com/viper-cog/canticle",
+			"github.comcast.com/viper-cog/xtest",
+		},
+		ImportPath: "github.comcast.com/viper-cog/canticle",
 	}
 
 	expected := []string{
@@ -47,6 +53,7 @@ func TestPackageRemoteImports(t *testing.T) {
 		"github.comcast.com/viper-cog/canticle/canticle",
 		"github.comcast.com/viper-cog/canticle/test",
 		"github.comcast.com/viper-cog/assert",
+		"github.comcast.com/viper-cog/xtest",
 	}
 	if !reflect.DeepEqual(imps, expected) {
 		t.Errorf("Package remote imports: %v != %v", expected, imps)
@@ -59,7 +66,7 @@ func TestLoadPackage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load gopath %s", err.Error())
 	}
-	pkg, err := LoadPackage(pkgPath, gp)
+	pkg, err := LoadPackage(context.Background(), pkgPath, gp)
 	if err != nil {
 		t.Errorf("Error %s loading package information for valid package", err.Error())
 	}
@@ -73,7 +80,7 @@ func TestLoadPackage(t *testing.T) {
 	}
 
 	pkgPath = "nothere.comcast.com/nothere"
-	pkg, err = LoadPackage(pkgPath, os.ExpandEnv("$GOPATH"))
+	pkg, err = LoadPackage(context.Background(), pkgPath, os.ExpandEnv("$GOPATH"))
 	if err == nil {
 		t.Errorf("No error loading invalid package")
 	}
@@ -83,3 +90,35 @@ func TestLoadPackage(t *testing.T) {
 	}
 
 }
+
+func TestLoadPackages(t *testing.T) {
+	gp, err := EnvGoPath()
+	if err != nil {
+		t.Fatalf("Could not load gopath %s", err.Error())
+	}
+	pkgPaths := []string{
+		"github.com/Comcast/Canticle/cant",
+		"github.com/Comcast/Canticle/buildinfo",
+		"nothere.comcast.com/nothere",
+	}
+	pkgs, err := LoadPackages(context.Background(), gp, pkgPaths...)
+	if err != nil {
+		t.Fatalf("Error %s loading package information for valid packages", err.Error())
+	}
+	if len(pkgs) != len(pkgPaths) {
+		t.Errorf("Expected %d packages got %d", len(pkgPaths), len(pkgs))
+	}
+	for _, p := range pkgPaths[:2] {
+		pkg := pkgs[p]
+		if pkg == nil {
+			t.Errorf("Package %s not loaded", p)
+			continue
+		}
+		if pkg.Error != nil {
+			t.Errorf("Package %s loaded with error %s", p, pkg.Error.Error())
+		}
+	}
+	if pkg := pkgs["nothere.comcast.com/nothere"]; pkg == nil || pkg.Error == nil {
+		t.Errorf("Expected an error for invalid package got %+v", pkg)
+	}
+}
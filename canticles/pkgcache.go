@@ -0,0 +1,232 @@
+package canticles
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DirFingerprint returns a hash of the names, sizes and modification
+// times of the files directly inside dir. It changes whenever a file
+// in dir is added, removed, or modified.
+func DirFingerprint(dir string) (string, error) {
+	finfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	for _, f := range finfos {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		fmt.Fprintf(h, "%s %d %d\n", f.Name(), f.Size(), f.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DirContentHash returns a hash of the names and contents of the
+// files directly inside dir. Unlike DirFingerprint it is unchanged
+// by a fresh checkout or touching files.
+func DirContentHash(dir string) (string, error) {
+	finfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	for _, f := range finfos {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		sum, err := fileHash(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", f.Name(), sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type packageCacheEntry struct {
+	Fingerprint string
+	Hash        string `json:",omitempty"`
+	Package     *Package
+}
+
+// A PackageCache remembers the results of go list for packages across
+// runs. Entries are keyed by gopath, the GoEnv and import path and
+// are only valid while the package directory is unchanged. The
+// DirFingerprint of the directory is checked first, if it differs
+// the DirContentHash is, so only directories whose files really
+// changed are listed again.
+type PackageCache struct {
+	sync.Mutex
+	file    string
+	entries map[string]*packageCacheEntry
+	dirty   bool
+}
+
+// LoadPackageCache reads the cache stored in file. A missing or
+// corrupt cache file results in an empty cache.
+func LoadPackageCache(file string) *PackageCache {
+	defer StartSpan(PhaseIO, file)()
+	pc := &PackageCache{
+		file:    file,
+		entries: make(map[string]*packageCacheEntry),
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			LogVerbose("Error reading package cache %s", err.Error())
+		}
+		return pc
+	}
+	if err := json.Unmarshal(b, &pc.entries); err != nil {
+		LogWarn("Ignoring corrupt package cache %s: %s", file, err.Error())
+		pc.entries = make(map[string]*packageCacheEntry)
+	}
+	return pc
+}
+
+// DefaultPackageCache loads the package cache from the CacheDir.
+func DefaultPackageCache() (*PackageCache, error) {
+	cache, err := DefaultCache()
+	if err != nil {
+		return nil, err
+	}
+	return LoadPackageCache(cache.Entry("golist.json")), nil
+}
+
+// packageCacheKey keys entries by the GoEnv and the goListEnv too as
+// go list results depend on GOOS, GOARCH and other enviroment
+// variables, whether set with GoEnv or inherited, and the go version.
+func packageCacheKey(gopath string, env []string, importPath string) string {
+	return gopath + "|" + strings.Join(goEnvWith(env), " ") + "|" + goListEnv(gopath, env) + "|" + importPath
+}
+
+// goListEnvVars are the go env variables go list results depend on.
+var goListEnvVars = []string{"GOVERSION", "GOOS", "GOARCH", "GOFLAGS", "CGO_ENABLED"}
+
+// goListEnvs memoizes goListEnv by enviroment.
+var goListEnvs = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// goListEnv returns the goListEnvVars as go env resolves them in the
+// goEnviroment of gopath and env. Go env is run once per enviroment.
+func goListEnv(gopath string, env []string) string {
+	environ := goEnviroment(gopath, env)
+	key := strings.Join(environ, "\x00")
+	goListEnvs.Lock()
+	defer goListEnvs.Unlock()
+	if value, ok := goListEnvs.values[key]; ok {
+		return value
+	}
+	cmd := exec.Command("go", append([]string{"env"}, goListEnvVars...)...)
+	cmd.Env = environ
+	result, err := cmd.Output()
+	if err != nil {
+		LogVerbose("Cant resolve go env %s", err.Error())
+	}
+	value := strings.Replace(strings.TrimSpace(string(result)), "\n", " ", -1)
+	goListEnvs.values[key] = value
+	return value
+}
+
+// Get returns the cached package for importPath in gopath if the
+// directory has not changed since it was cached, otherwise nil.
+func (pc *PackageCache) Get(gopath, importPath string) *Package {
+	return pc.get(gopath, nil, importPath)
+}
+
+// get is Get for packages listed with the extra enviroment env, see
+// loadPackages.
+func (pc *PackageCache) get(gopath string, env []string, importPath string) *Package {
+	key := packageCacheKey(gopath, env, importPath)
+	pc.Lock()
+	entry := pc.entries[key]
+	pc.Unlock()
+	if entry == nil {
+		Count(MetricPackageCacheMisses, 1)
+		return nil
+	}
+	dir := PackageSource(gopath, importPath)
+	fp, err := DirFingerprint(dir)
+	if err != nil {
+		Count(MetricPackageCacheMisses, 1)
+		return nil
+	}
+	if fp != entry.Fingerprint {
+		hash, err := DirContentHash(dir)
+		if err != nil || entry.Hash == "" || hash != entry.Hash {
+			Count(MetricPackageCacheMisses, 1)
+			return nil
+		}
+		LogVerbose("Files of %s touched but unchanged", importPath)
+		pc.Lock()
+		pc.entries[key] = &packageCacheEntry{fp, hash, entry.Package}
+		pc.dirty = true
+		pc.Unlock()
+	}
+	LogVerbose("Using cached go list result for %s", importPath)
+	Count(MetricPackageCacheHits, 1)
+	return entry.Package
+}
+
+// Put adds pkg to the cache. Packages with errors other than having
+// no buildable files are not cached.
+func (pc *PackageCache) Put(gopath, importPath string, pkg *Package) {
+	pc.put(gopath, nil, importPath, pkg)
+}
+
+// put is Put for packages listed with the extra enviroment env.
+func (pc *PackageCache) put(gopath string, env []string, importPath string, pkg *Package) {
+	if pkg.Error != nil && !pkg.Error.IsNoBuildable() {
+		return
+	}
+	dir := PackageSource(gopath, importPath)
+	fp, err := DirFingerprint(dir)
+	if err != nil {
+		return
+	}
+	hash, err := DirContentHash(dir)
+	if err != nil {
+		return
+	}
+	// Deps can be huge and is not used by canticle
+	cached := *pkg
+	cached.Deps = nil
+	pc.Lock()
+	pc.entries[packageCacheKey(gopath, env, importPath)] = &packageCacheEntry{fp, hash, &cached}
+	pc.dirty = true
+	pc.Unlock()
+}
+
+// Save writes the cache back to its file if it has changed.
+func (pc *PackageCache) Save() error {
+	defer StartSpan(PhaseIO, pc.file)()
+	pc.Lock()
+	defer pc.Unlock()
+	if !pc.dirty {
+		return nil
+	}
+	b, err := json.Marshal(pc.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pc.file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(pc.file, b, 0644); err != nil {
+		return err
+	}
+	pc.dirty = false
+	return nil
+}
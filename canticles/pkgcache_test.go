@@ -0,0 +1,106 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestPackageCache(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgDir := path.Join(testHome, "src", "test.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(pkgDir, "pkg.go"), []byte("package pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheFile := path.Join(testHome, "cache", "golist.json")
+	pc := LoadPackageCache(cacheFile)
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg != nil {
+		t.Errorf("Empty cache returned package %+v", pkg)
+	}
+	pc.Put(testHome, "test.com/pkg", &Package{ImportPath: "test.com/pkg", Imports: []string{"a.com/b"}})
+	pc.Put(testHome, "test.com/err", &Package{ImportPath: "test.com/err", Error: &PackageError{Err: "broken"}})
+	if err := pc.Save(); err != nil {
+		t.Fatalf("Error saving cache: %s", err.Error())
+	}
+
+	// Reload and check our package survived and the error did not
+	pc = LoadPackageCache(cacheFile)
+	pkg := pc.Get(testHome, "test.com/pkg")
+	if pkg == nil || len(pkg.Imports) != 1 {
+		t.Errorf("Expected cached package got %+v", pkg)
+	}
+	if pkg := pc.Get(testHome, "test.com/err"); pkg != nil {
+		t.Errorf("Package with error should not be cached got %+v", pkg)
+	}
+
+	// Changing the dir should invalidate the cache
+	if err := ioutil.WriteFile(path.Join(pkgDir, "other.go"), []byte("package pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg != nil {
+		t.Errorf("Expected changed dir to invalidate cache got %+v", pkg)
+	}
+}
+
+func TestPackageCacheContentHash(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgDir := path.Join(testHome, "src", "test.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(pkgDir, "pkg.go")
+	if err := ioutil.WriteFile(file, []byte("package pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pc := LoadPackageCache(path.Join(testHome, "cache", "golist.json"))
+	pc.Put(testHome, "test.com/pkg", &Package{ImportPath: "test.com/pkg"})
+
+	// Touching, as a fresh checkout does, keeps the entry valid
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg == nil {
+		t.Errorf("Expected touched but unchanged dir to use the cache")
+	}
+	if err := ioutil.WriteFile(file, []byte("package changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg != nil {
+		t.Errorf("Expected changed contents to invalidate the cache got %+v", pkg)
+	}
+
+	pc.Put(testHome, "test.com/pkg", &Package{ImportPath: "test.com/pkg"})
+	defer func(env EnvFlags) { GoEnv = env }(GoEnv)
+	GoEnv = EnvFlags{"GOOS=plan9"}
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg != nil {
+		t.Errorf("Expected a different GoEnv to not use the cache got %+v", pkg)
+	}
+
+	// Inherited go enviroment variables are keyed by too
+	GoEnv = nil
+	defer os.Setenv("CGO_ENABLED", os.Getenv("CGO_ENABLED"))
+	os.Setenv("CGO_ENABLED", "1")
+	pc.Put(testHome, "test.com/pkg", &Package{ImportPath: "test.com/pkg"})
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg == nil {
+		t.Errorf("Expected the cached package for the inherited enviroment")
+	}
+	os.Setenv("CGO_ENABLED", "0")
+	if pkg := pc.Get(testHome, "test.com/pkg"); pkg != nil {
+		t.Errorf("Expected an inherited CGO_ENABLED change to not use the cache got %+v", pkg)
+	}
+}
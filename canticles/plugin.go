@@ -0,0 +1,119 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the executables on PATH run for
+// subcommands cant does not have, cant foo runs cant-foo.
+const PluginPrefix = "cant-"
+
+// PluginContext is what a plugin is told of the invocation of cant
+// running it. It is passed as JSON on the plugin's stdin, and as
+// CANT_GOPATH, CANT_CANTICLE and CANT_DIR in its enviroment.
+type PluginContext struct {
+	// Gopath is the gopath cant would use, empty if there is none.
+	Gopath string
+	// Canticle is the nearest Canticle file in Dir or its parents,
+	// empty if there is none.
+	Canticle string
+	// Dir is the directory cant was run in.
+	Dir string
+	// Args are the arguments following the subcommand.
+	Args []string
+}
+
+// NewPluginContext returns the context of running a plugin with args
+// in dir.
+func NewPluginContext(dir string, args []string) *PluginContext {
+	pc := &PluginContext{Dir: dir, Args: args}
+	if gopath, err := EnvGoPath(); err == nil {
+		pc.Gopath = gopath
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := DefaultFS.Stat(DependencyFile(d)); err == nil {
+			pc.Canticle = DependencyFile(d)
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return pc
+}
+
+// Env returns the enviroment variables describing pc.
+func (pc *PluginContext) Env() []string {
+	return []string{
+		"CANT_GOPATH=" + pc.Gopath,
+		"CANT_CANTICLE=" + pc.Canticle,
+		"CANT_DIR=" + pc.Dir,
+	}
+}
+
+// FindPlugin returns the path of the plugin for the subcommand name
+// on PATH.
+func FindPlugin(name string) (string, error) {
+	return exec.LookPath(PluginPrefix + name)
+}
+
+// Plugins returns the sorted names of the subcommands of the plugins
+// on PATH, leaving out those shadowed by Commands.
+func Plugins() []string {
+	names := NewStringSet()
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if !strings.HasPrefix(name, PluginPrefix) || !isExecutable(info) {
+				continue
+			}
+			name = strings.TrimPrefix(name, PluginPrefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if _, ok := Commands[name]; !ok && name != "" {
+				names.Add(name)
+			}
+		}
+	}
+	return names.Array()
+}
+
+func isExecutable(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(info.Name()), ".exe")
+	}
+	return info.Mode()&0111 != 0
+}
+
+// RunPlugin runs the plugin at path with the Args of pc, passing it
+// pc. The plugin shares cant's stdout and stderr, and is killed once
+// ctx is done.
+func RunPlugin(ctx context.Context, path string, pc *PluginContext) error {
+	b, err := json.Marshal(pc)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, path, pc.Args...)
+	cmd.Dir = pc.Dir
+	cmd.Env = append(os.Environ(), pc.Env()...)
+	cmd.Stdin = bytes.NewReader(append(b, '\n'))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
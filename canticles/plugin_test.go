@@ -0,0 +1,81 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts need a unix shell")
+	}
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	bin := filepath.Join(testHome, "bin")
+	project := filepath.Join(testHome, "src", "test.com", "project")
+	for _, dir := range []string{bin, filepath.Join(project, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(DependencyFile(project), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(testHome, "out")
+	script := "#!/bin/sh\ncat > " + out + "\necho \"$CANT_CANTICLE $*\" >> " + out + "\n"
+	files := map[string]os.FileMode{"cant-hello": 0755, "cant-get": 0755, "cant-data": 0644}
+	for name, mode := range files {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+
+	plugins := NewStringSet()
+	plugins.Add(Plugins()...)
+	if !plugins["hello"] || plugins["get"] || plugins["data"] {
+		t.Errorf("Expected plugin hello and not get or data got %v", plugins)
+	}
+	if _, err := FindPlugin("missing"); err == nil {
+		t.Errorf("Expected error finding missing plugin")
+	}
+	plugin, err := FindPlugin("hello")
+	if err != nil {
+		t.Fatalf("Error finding plugin %s", err.Error())
+	}
+
+	pc := NewPluginContext(filepath.Join(project, "sub"), []string{"-x", "y"})
+	if pc.Canticle != DependencyFile(project) {
+		t.Errorf("Expected Canticle file %s got %s", DependencyFile(project), pc.Canticle)
+	}
+	if err := RunPlugin(context.Background(), plugin, pc); err != nil {
+		t.Fatalf("Error running plugin %s", err.Error())
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(b), "\n", 2)
+	var got PluginContext
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("Error decoding plugin context %s", err.Error())
+	}
+	if !reflect.DeepEqual(*pc, got) {
+		t.Errorf("Expected plugin context %+v got %+v", *pc, got)
+	}
+	if expected := DependencyFile(project) + " -x y\n"; lines[1] != expected {
+		t.Errorf("Expected plugin enviroment and args %q got %q", expected, lines[1])
+	}
+}
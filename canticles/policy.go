@@ -0,0 +1,235 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PolicyFile returns the location of the source policy file for a
+// path. Should be a directory.
+func PolicyFile(p string) string {
+	return filepath.Join(p, "Canticle.policy")
+}
+
+// A SourcePolicy restricts where dependencies may be fetched from.
+// Sources are compared as host/path prefixes, so github.com/myorg
+// matches https://github.com/myorg/repo.git and
+// git@github.com:myorg/repo.
+type SourcePolicy struct {
+	// Allow are the source prefixes dependencies may come from. If
+	// empty any source not denied is allowed.
+	Allow []string `json:",omitempty"`
+	// Deny are the forbidden source prefixes, they take precedence
+	// over Allow.
+	Deny []string `json:",omitempty"`
+	// Exceptions are the dependencies allowed to violate the
+	// policy from one source, recorded with -allow-violations.
+	Exceptions []*PolicyException `json:",omitempty"`
+	// Licenses is the LicensePolicy kept in the same file.
+	Licenses *LicensePolicy `json:",omitempty"`
+}
+
+// A PolicyException allows the dependency Root to be fetched from
+// Source, as NormalizeSource returns it, though the policy forbids it.
+type PolicyException struct {
+	Root   string
+	Source string
+}
+
+type exceptionsByRoot []*PolicyException
+
+func (e exceptionsByRoot) Len() int      { return len(e) }
+func (e exceptionsByRoot) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e exceptionsByRoot) Less(i, j int) bool {
+	if e[i].Root != e[j].Root {
+		return e[i].Root < e[j].Root
+	}
+	return e[i].Source < e[j].Source
+}
+
+// Excepted returns true if an Exception allows root to be fetched
+// from source.
+func (sp *SourcePolicy) Excepted(root, source string) bool {
+	source = NormalizeSource(source)
+	for _, exception := range sp.Exceptions {
+		if exception.Root == root && NormalizeSource(exception.Source) == source {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSourcePolicy reads the SourcePolicy for the project at path. If
+// no policy file is present nil is returned.
+func LoadSourcePolicy(path string) (*SourcePolicy, error) {
+	b, err := ioutil.ReadFile(PolicyFile(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sp SourcePolicy
+	if err := json.Unmarshal(b, &sp); err != nil {
+		return nil, fmt.Errorf("cant decode source policy %s %s", PolicyFile(path), err.Error())
+	}
+	if err := sp.Licenses.Validate(); err != nil {
+		return nil, fmt.Errorf("cant load source policy %s %s", PolicyFile(path), err.Error())
+	}
+	return &sp, nil
+}
+
+// Save writes the policy to the policy file in path.
+func (sp *SourcePolicy) Save(path string) error {
+	j, err := json.MarshalIndent(sp, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(PolicyFile(path), j, 0644)
+}
+
+var scpSource = regexp.MustCompile(`^[A-Za-z0-9_.-]+@([^:/]+):(.*)$`)
+
+// NormalizeSource returns the host/path of a VCS source, removing any
+// scheme, user, port and .git suffix.
+func NormalizeSource(source string) string {
+	if m := scpSource.FindStringSubmatch(source); m != nil {
+		source = m[1] + "/" + m[2]
+	}
+	if i := strings.Index(source, "://"); i >= 0 {
+		source = source[i+3:]
+	}
+	if i := strings.Index(source, "@"); i >= 0 && i < strings.Index(source+"/", "/") {
+		source = source[i+1:]
+	}
+	if i := strings.Index(source, ":"); i >= 0 && i < strings.Index(source+"/", "/") {
+		source = source[:i] + source[strings.Index(source+"/", "/"):]
+	}
+	return strings.TrimSuffix(strings.Trim(source, "/"), ".git")
+}
+
+// FetchSource returns the source a cdep is fetched from, its
+// SourcePath or else its Root.
+func FetchSource(cdep *CanticleDependency) string {
+	if cdep.SourcePath != "" {
+		return cdep.SourcePath
+	}
+	return cdep.Root
+}
+
+func matchesPrefix(prefixes []string, source string) bool {
+	for _, prefix := range prefixes {
+		if PathIsChild(NormalizeSource(prefix), source) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if the policy allows fetching from source.
+func (sp *SourcePolicy) Allowed(source string) bool {
+	source = NormalizeSource(source)
+	if matchesPrefix(sp.Deny, source) {
+		return false
+	}
+	return len(sp.Allow) == 0 || matchesPrefix(sp.Allow, source)
+}
+
+// Violations returns the cdeps whose source is not Allowed and which
+// are not Exceptions. A nil policy allows everything.
+func (sp *SourcePolicy) Violations(cdeps []*CanticleDependency) []*CanticleDependency {
+	if sp == nil {
+		return nil
+	}
+	var violations []*CanticleDependency
+	for _, cdep := range cdeps {
+		source := FetchSource(cdep)
+		if !sp.Allowed(source) && !sp.Excepted(cdep.Root, source) {
+			violations = append(violations, cdep)
+		}
+	}
+	return violations
+}
+
+// Resolver returns r wrapped to refuse repos resolved to a source the
+// policy does not allow, if sp is non nil. If allow is set they are
+// warned of instead.
+func (sp *SourcePolicy) Resolver(r RepoResolver, allow bool) RepoResolver {
+	if sp == nil {
+		return r
+	}
+	return &PolicyRepoResolver{Resolver: r, Policy: sp, Allow: allow}
+}
+
+// A PolicyRepoResolver refuses the repos resolved by Resolver whose
+// source, as reported by the VCS resolved, Policy does not allow. So
+// an import path whose meta tag points at a denied host is refused
+// even if its Canticle file does not say where it is fetched from.
+// Repos already on disk, which are not fetched, and the Exceptions of
+// Policy are not checked.
+type PolicyRepoResolver struct {
+	Resolver RepoResolver
+	Policy   *SourcePolicy
+	// Allow warns of violations instead of refusing them.
+	Allow bool
+}
+
+// ResolveRepo resolves importPath with Resolver and checks its source.
+func (pr *PolicyRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	v, err := pr.Resolver.ResolveRepo(ctx, importPath, dep)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(*LocalVCS); ok {
+		return v, nil
+	}
+	root := v.GetRoot()
+	if dep != nil && dep.Root != "" {
+		root = dep.Root
+	}
+	if root == "" {
+		root = importPath
+	}
+	source, err := v.GetSource(ctx)
+	if err != nil || source == "" {
+		source = root
+	}
+	if pr.Policy.Allowed(source) || pr.Policy.Excepted(root, source) {
+		return v, nil
+	}
+	if pr.Allow {
+		LogWarnContext(ctx, "Fetching %s from %s forbidden by the source policy", root, source)
+		return v, nil
+	}
+	return nil, fmt.Errorf("cant fetch %s from %s forbidden by the source policy, use -allow-violations to fetch it anyway", root, source)
+}
+
+// EnforcePolicy returns an error naming the cdeps violating the
+// policy of the project at path. If allow is set the violations are
+// instead recorded as Exceptions in the policy file.
+func (sp *SourcePolicy) EnforcePolicy(path string, cdeps []*CanticleDependency, allow bool) error {
+	violations := sp.Violations(cdeps)
+	if len(violations) == 0 {
+		return nil
+	}
+	if !allow {
+		var names []string
+		for _, cdep := range violations {
+			names = append(names, fmt.Sprintf("%s from %s", cdep.Root, FetchSource(cdep)))
+		}
+		return fmt.Errorf("cant use dependencies forbidden by source policy %s: %s, use -allow-violations to record an exception", PolicyFile(path), strings.Join(names, ", "))
+	}
+	for _, cdep := range violations {
+		LogWarn("Recording source policy exception for %s from %s", cdep.Root, FetchSource(cdep))
+		sp.Exceptions = append(sp.Exceptions, &PolicyException{Root: cdep.Root, Source: NormalizeSource(FetchSource(cdep))})
+	}
+	sort.Sort(exceptionsByRoot(sp.Exceptions))
+	return sp.Save(path)
+}
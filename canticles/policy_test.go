@@ -0,0 +1,115 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestNormalizeSource(t *testing.T) {
+	sources := map[string]string{
+		"https://github.com/myorg/repo.git":     "github.com/myorg/repo",
+		"git@github.com:myorg/repo.git":         "github.com/myorg/repo",
+		"ssh://git@git.corp.com:7999/team/repo": "git.corp.com/team/repo",
+		"golang.org/x/tools":                    "golang.org/x/tools",
+		"svn://svn.corp.com/trunk/":             "svn.corp.com/trunk",
+	}
+	for source, expected := range sources {
+		if n := NormalizeSource(source); n != expected {
+			t.Errorf("Expected %s to normalize to %s got %s", source, expected, n)
+		}
+	}
+}
+
+func TestSourcePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	policy := &SourcePolicy{
+		Allow: []string{"github.com/myorg", "golang.org/x"},
+		Deny:  []string{"https://github.com/myorg/forbidden"},
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "github.com/myorg/ok", SourcePath: "git@github.com:myorg/ok.git"},
+		{Root: "golang.org/x/tools"},
+		{Root: "github.com/myorg/forbidden"},
+		{Root: "golang.org/x/net", SourcePath: "https://mirror.evil.com/net"},
+		{Root: "github.com/myorgother/x"},
+	}
+	expected := []*CanticleDependency{cdeps[2], cdeps[3], cdeps[4]}
+	if violations := policy.Violations(cdeps); !reflect.DeepEqual(expected, violations) {
+		t.Errorf("Expected violations %v got %v", expected, violations)
+	}
+	if err := policy.EnforcePolicy(dir, cdeps, false); err == nil {
+		t.Errorf("Expected error enforcing policy")
+	}
+	if err := policy.EnforcePolicy(dir, cdeps, true); err != nil {
+		t.Fatalf("Error recording exceptions: %s", err.Error())
+	}
+	saved, err := LoadSourcePolicy(dir)
+	if err != nil {
+		t.Fatalf("Error loading policy: %s", err.Error())
+	}
+	expectedExceptions := []*PolicyException{
+		{Root: "github.com/myorg/forbidden", Source: "github.com/myorg/forbidden"},
+		{Root: "github.com/myorgother/x", Source: "github.com/myorgother/x"},
+		{Root: "golang.org/x/net", Source: "mirror.evil.com/net"},
+	}
+	if !reflect.DeepEqual(expectedExceptions, saved.Exceptions) {
+		t.Errorf("Expected exceptions %v got %v", expectedExceptions, saved.Exceptions)
+	}
+	if err := saved.EnforcePolicy(dir, cdeps, false); err != nil {
+		t.Errorf("Expected recorded exceptions to be allowed got %s", err.Error())
+	}
+	// An exception allows only the source it was recorded for
+	moved := []*CanticleDependency{{Root: "golang.org/x/net", SourcePath: "https://other.evil.com/net"}}
+	if violations := saved.Violations(moved); len(violations) != 1 {
+		t.Errorf("Expected exception for another source to be a violation got %v", violations)
+	}
+	var none *SourcePolicy
+	if err := none.EnforcePolicy(dir, cdeps, false); err != nil {
+		t.Errorf("Expected no policy to allow everything got %s", err.Error())
+	}
+}
+
+func TestPolicyRepoResolver(t *testing.T) {
+	vanity := &PackageVCS{Repo: &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: "https://evil.com/x", Root: "vanity.org/x"}}
+	allowed := &PackageVCS{Repo: &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: "https://github.com/myorg/y", Root: "vanity.org/y"}}
+	local := NewLocalVCS("vanity.org/z", "vanity.org/z", "", vcs.ByCmd("git"))
+	tr := &TestResolver{map[string]*TestVCSResolve{
+		"vanity.org/x": &TestVCSResolve{vanity, nil},
+		"vanity.org/y": &TestVCSResolve{allowed, nil},
+		"vanity.org/z": &TestVCSResolve{local, nil},
+	}}
+	sp := &SourcePolicy{Deny: []string{"evil.com"}}
+	ctx := context.Background()
+	resolver := sp.Resolver(tr, false)
+	if _, err := resolver.ResolveRepo(ctx, "vanity.org/x", nil); err == nil {
+		t.Errorf("Expected vanity path resolved to a denied host to be refused")
+	}
+	for _, pkg := range []string{"vanity.org/y", "vanity.org/z"} {
+		if _, err := resolver.ResolveRepo(ctx, pkg, nil); err != nil {
+			t.Errorf("Expected %s to be allowed got %s", pkg, err.Error())
+		}
+	}
+	if _, err := sp.Resolver(tr, true).ResolveRepo(ctx, "vanity.org/x", nil); err != nil {
+		t.Errorf("Expected violation allowed got %s", err.Error())
+	}
+	sp.Exceptions = []*PolicyException{{Root: "vanity.org/x", Source: "other.com/x"}}
+	if _, err := resolver.ResolveRepo(ctx, "vanity.org/x", nil); err == nil {
+		t.Errorf("Expected exception for another source to be refused")
+	}
+	sp.Exceptions = []*PolicyException{{Root: "vanity.org/x", Source: "evil.com/x"}}
+	if _, err := resolver.ResolveRepo(ctx, "vanity.org/x", nil); err != nil {
+		t.Errorf("Expected exception allowed got %s", err.Error())
+	}
+	if r := (*SourcePolicy)(nil).Resolver(tr, false); r != tr {
+		t.Errorf("Expected no policy to not wrap the resolver")
+	}
+}
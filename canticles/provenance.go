@@ -0,0 +1,90 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// A ProvenanceEntry records a single fetch of a dependency.
+type ProvenanceEntry struct {
+	// Time is when the fetch completed, RFC3339 in UTC.
+	Time string
+	Root string
+	// Source is where the VCS was fetched from.
+	Source string
+	// Requested is the revision in the Canticle file.
+	Requested string `json:",omitempty"`
+	// Revision is the revision on disk after the fetch.
+	Revision string
+	// Updated is the result of updating a branch, if any.
+	Updated  string `json:",omitempty"`
+	TreeHash string
+	// Resolver is how the VCS was resolved: local for a VCS
+	// already on disk or remote for one cloned from Source.
+	Resolver string
+}
+
+// A ProvenanceLog appends a ProvenanceEntry for every fetched
+// dependency, as a line of json, to an append only file.
+type ProvenanceLog struct {
+	sync.Mutex
+	File string
+}
+
+// Record appends the provenance of cdep, fetched into gopath, to the
+// log. v must be the VCS cdep was resolved to for the fetch, so the
+// Resolver recorded is the one used.
+func (pl *ProvenanceLog) Record(ctx context.Context, gopath string, v VCS, cdep *CanticleDependency, updated string) error {
+	entry := &ProvenanceEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Root:      cdep.Root,
+		Requested: cdep.Revision,
+		Updated:   updated,
+		Resolver:  "remote",
+	}
+	if _, ok := v.(*LocalVCS); ok {
+		entry.Resolver = "local"
+	}
+	var err error
+	if entry.Source, err = v.GetSource(ctx); err != nil {
+		LogWarnContext(ctx, "Not recording source of %s: %s", cdep.Root, err.Error())
+	}
+	local := v
+	switch v.(type) {
+	case *ArchiveVCS, *RawSourceVCS:
+	default:
+		if local, err = (&LocalRepoResolver{LocalPath: gopath}).ResolveRepo(ctx, cdep.Root, cdep); err != nil {
+			return err
+		}
+	}
+	if entry.Revision, err = local.GetRev(ctx); err != nil {
+		return err
+	}
+	if entry.TreeHash, err = TreeHash(ctx, PackageSource(gopath, cdep.Root)); err != nil {
+		return err
+	}
+	return pl.Append(entry)
+}
+
+// Append writes entry to the end of the log file, creating it if
+// needed.
+func (pl *ProvenanceLog) Append(entry *ProvenanceEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	pl.Lock()
+	defer pl.Unlock()
+	f, err := os.OpenFile(pl.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,106 @@
+package canticles
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestProvenanceLog(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgname := "dep.com/x"
+	src := PackageSource(testHome, pkgname)
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a.go"), []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"add", "a.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = src
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+
+	v := NewLocalVCS(pkgname, pkgname, testHome, vcs.ByCmd("git"))
+	rev, err := v.GetRev(context.Background())
+	if err != nil {
+		t.Fatalf("Error getting rev: %s", err.Error())
+	}
+	hash, err := TreeHash(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &ProvenanceLog{File: filepath.Join(testHome, "provenance.log")}
+	cdep := &CanticleDependency{Root: pkgname, Revision: "master"}
+	for i := 0; i < 2; i++ {
+		if err := pl.Record(context.Background(), testHome, v, cdep, ""); err != nil {
+			t.Fatalf("Error recording provenance: %s", err.Error())
+		}
+	}
+
+	f, err := os.Open(pl.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []*ProvenanceEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := &ProvenanceEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			t.Fatalf("Error reading entry %s: %s", scanner.Text(), err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 appended entries got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Root != pkgname || entry.Requested != "master" || entry.Revision != rev {
+		t.Errorf("Expected entry for %s master at %s got %+v", pkgname, rev, entry)
+	}
+	if entry.TreeHash != hash {
+		t.Errorf("Expected tree hash %s got %s", hash, entry.TreeHash)
+	}
+	if entry.Resolver != "local" {
+		t.Errorf("Expected local resolver got %s", entry.Resolver)
+	}
+	if entry.Time == "" {
+		t.Errorf("Expected entry to be timestamped")
+	}
+	remote := &TestVCS{Source: "https://dep.com/x", Root: pkgname}
+	resolver := &testResolver{response: []resolve{{remote, nil}}}
+	loader := &CanticleDepLoader{Gopath: testHome, Resolver: resolver, Provenance: &ProvenanceLog{File: filepath.Join(testHome, "fetched.log")}}
+	if errs := loader.FetchDeps(context.Background(), cdep); len(errs) != 0 {
+		t.Fatalf("Error fetching: %v", errs)
+	}
+	if len(resolver.resolutions) != 1 {
+		t.Errorf("Expected the dep resolved once got %d resolutions", len(resolver.resolutions))
+	}
+	b, err := ioutil.ReadFile(loader.Provenance.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := &ProvenanceEntry{}
+	if err := json.Unmarshal(b, fetched); err != nil || fetched.Resolver != "remote" || fetched.Source != remote.Source {
+		t.Errorf("Expected the resolver used for the fetch recorded got %+v %v", fetched, err)
+	}
+}
@@ -0,0 +1,131 @@
+package canticles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PruneSets are named sets of patterns of files not needed to build a
+// dependency, which may be given in place of patterns when vendoring.
+var PruneSets = map[string][]string{
+	"tests":    {"*_test.go"},
+	"testdata": {"testdata"},
+	"docs":     {"*.md", "*.markdown", "*.rst", "doc", "docs", "example", "examples"},
+}
+
+// NonGo is the name of the prune set of files which are not
+// GoBuildFiles. Directories are not pruned by it.
+const NonGo = "nongo"
+
+// GoBuildFiles are patterns of the files the go tool builds packages
+// from, including cgo, assembly and SWIG sources.
+var GoBuildFiles = []string{
+	"*.go", "*.s", "*.S", "*.sx", "*.c", "*.h", "*.cc", "*.cpp", "*.cxx", "*.hh", "*.hpp", "*.hxx",
+	"*.m", "*.f", "*.F", "*.for", "*.f90", "*.swig", "*.swigcxx", "*.syso", "go.mod", "go.sum",
+}
+
+// KeepFiles are patterns of files never pruned, so the licenses and
+// notices of vendored dependencies are kept with them.
+var KeepFiles = []string{"LICENSE*", "LICENCE*", "COPYING*", "NOTICE*", "PATENTS*", "AUTHORS*"}
+
+// ExpandPrune returns patterns with the names of PruneSets replaced by
+// their patterns.
+func ExpandPrune(patterns []string) []string {
+	var expanded []string
+	for _, p := range patterns {
+		if set, ok := PruneSets[p]; ok {
+			expanded = append(expanded, set...)
+			continue
+		}
+		expanded = append(expanded, p)
+	}
+	return expanded
+}
+
+// CheckPrune returns an error if a pattern of patterns is malformed.
+func CheckPrune(patterns []string) error {
+	for _, p := range ExpandPrune(patterns) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("cant prune with pattern %s %s", p, err.Error())
+		}
+	}
+	return nil
+}
+
+// Pruned returns true if the file or directory rel, slash separated
+// and relative to the dependency copied, matches one of patterns.
+// Patterns without a slash match a files name, others its path. Files
+// matching KeepFiles are never pruned. NonGo is matched by
+// PrunedNonGo.
+func Pruned(patterns []string, rel string) bool {
+	base := filepath.Base(rel)
+	for _, keep := range KeepFiles {
+		if match, _ := filepath.Match(keep, strings.ToUpper(base)); match {
+			return false
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern == NonGo {
+			continue
+		}
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = rel
+		}
+		if match, _ := filepath.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// PrunedNonGo returns true if patterns include NonGo and the file rel
+// matches neither GoBuildFiles nor KeepFiles.
+func PrunedNonGo(patterns []string, rel string) bool {
+	nonGo := false
+	for _, pattern := range patterns {
+		nonGo = nonGo || pattern == NonGo
+	}
+	if !nonGo {
+		return false
+	}
+	base := filepath.Base(rel)
+	for _, keep := range KeepFiles {
+		if match, _ := filepath.Match(keep, strings.ToUpper(base)); match {
+			return false
+		}
+	}
+	for _, pattern := range GoBuildFiles {
+		if match, _ := filepath.Match(pattern, base); match {
+			return false
+		}
+	}
+	return true
+}
+
+// VendorDir returns the vendor directory of the project in p.
+func VendorDir(p string) string {
+	return filepath.Join(p, "vendor")
+}
+
+// CopyToVendor copies the dependency root in gopath into vendor,
+// replacing any copy already there. Files are cloned or hardlinked
+// where the filesystem allows, see LinkFile, so vendored files must
+// be replaced rather than edited in place. Hidden files, including
+// VCS metadata such as .git and .hg, are not copied, nor files
+// matching the prune patterns, see Pruned.
+func CopyToVendor(gopath, vendor, root string, prune []string) error {
+	dest := filepath.Join(vendor, filepath.FromSlash(root))
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("cant remove vendored %s %s", root, err.Error())
+	}
+	dc := NewDirCopier(PackageSource(gopath, root), dest)
+	dc.Prune = ExpandPrune(prune)
+	dc.Link = true
+	if err := dc.Copy(); err != nil {
+		return fmt.Errorf("cant vendor %s %s", root, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruned(t *testing.T) {
+	patterns := ExpandPrune([]string{"tests", "docs", "internal/gen/*.json"})
+	tests := []struct {
+		rel    string
+		pruned bool
+	}{
+		{"foo.go", false},
+		{"foo_test.go", true},
+		{"sub/foo_test.go", true},
+		{"README.md", true},
+		{"LICENSE.md", false},
+		{"license.md", false},
+		{"docs", true},
+		{"internal/gen/schema.json", true},
+		{"schema.json", false},
+		{"testdata", false},
+	}
+	for _, test := range tests {
+		if pruned := Pruned(patterns, test.rel); pruned != test.pruned {
+			t.Errorf("Expected %s pruned %v got %v", test.rel, test.pruned, pruned)
+		}
+	}
+	nonGo := []string{NonGo}
+	for rel, pruned := range map[string]bool{"a.go": false, "sub/asm_amd64.s": false, "LICENSE": false, "logo.png": true, "build.sh": true} {
+		if PrunedNonGo(nonGo, rel) != pruned || Pruned(nonGo, rel) {
+			t.Errorf("Expected %s pruned as non go %v", rel, pruned)
+		}
+	}
+	if PrunedNonGo([]string{"tests"}, "logo.png") {
+		t.Errorf("Expected non go files kept without the nongo set")
+	}
+	if err := CheckPrune([]string{"[a-"}); err == nil {
+		t.Errorf("Expected error for malformed pattern")
+	}
+}
+
+func TestCopyToVendor(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(gopath)
+	files := []string{
+		"dep.com/a/a.go",
+		"dep.com/a/a_test.go",
+		"dep.com/a/.git/HEAD",
+		"dep.com/a/.hg/store",
+		"dep.com/a/testdata/in.txt",
+		"dep.com/a/README.md",
+		"dep.com/a/LICENSE",
+		"dep.com/a/sub/sub.go",
+	}
+	for _, name := range files {
+		path := PackageSource(gopath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating dir: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+	}
+	vendor := VendorDir(filepath.Join(gopath, "project"))
+	stale := filepath.Join(vendor, "dep.com", "a", "stale.go")
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	ioutil.WriteFile(stale, []byte("package a"), 0644)
+
+	if err := CopyToVendor(gopath, vendor, "dep.com/a", []string{"tests", "testdata", "docs"}); err != nil {
+		t.Fatalf("Error copying to vendor: %s", err.Error())
+	}
+	expected := map[string]bool{
+		"a.go":       true,
+		"a_test.go":  false,
+		".git":       false,
+		".hg":        false,
+		"testdata":   false,
+		"README.md":  false,
+		"LICENSE":    true,
+		"sub/sub.go": true,
+		"stale.go":   false,
+	}
+	for name, present := range expected {
+		_, err := os.Stat(filepath.Join(vendor, "dep.com", "a", filepath.FromSlash(name)))
+		if (err == nil) != present {
+			t.Errorf("Expected %s present %v got error %v", name, present, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package canticles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A QueryResult is what is known about an import path from the
+// Canticle files and caches of a project, see cant query.
+type QueryResult struct {
+	ImportPath string
+	// Root, Revision and SourcePath are those of the dependency
+	// pinning ImportPath, empty if it is not pinned.
+	Root       string `json:",omitempty"`
+	Revision   string `json:",omitempty"`
+	SourcePath string `json:",omitempty"`
+	// Locked is true if the workspace lock pins ImportPath, at
+	// LockRevision.
+	Locked       bool   `json:",omitempty"`
+	LockRevision string `json:",omitempty"`
+	// Importers are the packages of the project known to import
+	// ImportPath.
+	Importers []string `json:",omitempty"`
+}
+
+// Pinned returns true if the Canticle file pins the import path.
+func (qr *QueryResult) Pinned() bool {
+	return qr.Root != ""
+}
+
+// Summary returns qr as human readable lines.
+func (qr *QueryResult) Summary() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:\n", qr.ImportPath)
+	if !qr.Pinned() {
+		fmt.Fprintf(&buf, "  not pinned\n")
+	} else {
+		fmt.Fprintf(&buf, "  root: %s\n  revision: %s\n", qr.Root, qr.Revision)
+		if qr.SourcePath != "" {
+			fmt.Fprintf(&buf, "  source: %s\n", qr.SourcePath)
+		}
+	}
+	if qr.Locked {
+		fmt.Fprintf(&buf, "  locked: %s\n", qr.LockRevision)
+	}
+	for _, importer := range qr.Importers {
+		fmt.Fprintf(&buf, "  imported by: %s\n", importer)
+	}
+	return buf.String()
+}
+
+// pinning returns the dependency of cdeps whose root is, or is a
+// parent of, importPath.
+func pinning(cdeps []*CanticleDependency, importPath string) *CanticleDependency {
+	for _, cdep := range cdeps {
+		if cdep.Root == importPath || PathIsChild(cdep.Root, importPath) {
+			return cdep
+		}
+	}
+	return nil
+}
+
+// FindWorkspaceLock returns the workspace lock of the nearest of dir
+// and its parents having one, or the empty string if none do.
+func FindWorkspaceLock(dir string) string {
+	for {
+		if _, err := os.Stat(WorkspaceLockFile(dir)); err == nil {
+			return WorkspaceLockFile(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Importers returns the packages under pkg in cache known to import
+// importPath, from test files too. Only entries still valid are used,
+// packages never listed are not known.
+func (pc *PackageCache) Importers(gopath, pkg, importPath string) []string {
+	prefix := packageCacheKey(gopath, nil, "")
+	var candidates []string
+	pc.Lock()
+	for key := range pc.entries {
+		p := strings.TrimPrefix(key, prefix)
+		if strings.HasPrefix(key, prefix) && (p == pkg || PathIsChild(pkg, p)) {
+			candidates = append(candidates, p)
+		}
+	}
+	pc.Unlock()
+	importers := NewStringSet()
+	for _, candidate := range candidates {
+		p := pc.Get(gopath, candidate)
+		if p == nil {
+			continue
+		}
+		for _, imports := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+			for _, i := range imports {
+				if i == importPath {
+					importers.Add(candidate)
+				}
+			}
+		}
+	}
+	return importers.Array()
+}
+
+// QueryImportPath answers what is known about importPath for the
+// project at path without resolving or listing anything. The Canticle
+// file, the workspace lock and the package cache are consulted.
+func QueryImportPath(gopath, path, importPath string, cache *PackageCache) (*QueryResult, error) {
+	qr := &QueryResult{ImportPath: importPath}
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	if cdep := pinning(cdeps, importPath); cdep != nil {
+		qr.Root, qr.Revision, qr.SourcePath = cdep.Root, cdep.Revision, cdep.SourcePath
+	}
+	if lock := FindWorkspaceLock(path); lock != "" {
+		b, err := ioutil.ReadFile(lock)
+		if err != nil {
+			return nil, err
+		}
+		var locked []*CanticleDependency
+		if err := json.Unmarshal(b, &locked); err != nil {
+			return nil, fmt.Errorf("cant decode lock %s %s", lock, err.Error())
+		}
+		if cdep := pinning(locked, importPath); cdep != nil {
+			qr.Locked, qr.LockRevision = true, cdep.Revision
+		}
+	}
+	if cache != nil {
+		pkg, err := PackageName(gopath, path)
+		if err != nil {
+			return nil, err
+		}
+		qr.Importers = cache.Importers(gopath, pkg, importPath)
+	}
+	return qr, nil
+}
+
+type Query struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	JSON    bool
+}
+
+func NewQuery() *Query {
+	f := flag.NewFlagSet("query", flag.ExitOnError)
+	q := &Query{flags: f}
+	f.BoolVar(&q.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&q.JSON, "json", false, "Print the answers as json")
+	return q
+}
+
+var query = NewQuery()
+
+var QueryCommand = &Command{
+	Name:             "query",
+	UsageLine:        "query [-v] [-json] <importpaths>",
+	ShortDescription: "Quickly answer what is known about import paths.",
+	LongDescription: `The query command prints, for each import path given, the root, revision and source pinning it in the Canticle file of the current directory, its revision in the nearest Canticle.lock of a workspace, see cant save -workspace, and the packages of the project importing it.
+
+Nothing is fetched, resolved or listed, only the Canticle files and the package cache are read, so answers are fast enough for editor plugins and git hooks. Importers are only known for packages listed since they last changed, by cant save for instance.
+
+The exit status is 1 if any import path is not pinned.
+
+Specify -json to print the answers as a json array.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: query.flags,
+	Cmd:   query,
+}
+
+// Run the query command.
+func (q *Query) Run(ctx context.Context, args []string) {
+	if q.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	importPaths := q.flags.Args()
+	if len(importPaths) == 0 {
+		log.Fatal("cant query, no import paths given")
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache, err := DefaultPackageCache()
+	if err != nil {
+		LogWarnContext(ctx, "Not using package cache: %s", err.Error())
+	}
+	var results []*QueryResult
+	unpinned := false
+	for _, importPath := range importPaths {
+		qr, err := QueryImportPath(gopath, wd, importPath, cache)
+		if err != nil {
+			log.Fatal(ErrorMessage(err))
+		}
+		unpinned = unpinned || !qr.Pinned()
+		results = append(results, qr)
+	}
+	if q.JSON {
+		b, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, qr := range results {
+			fmt.Print(qr.Summary())
+		}
+	}
+	if unpinned {
+		os.Exit(1)
+	}
+}
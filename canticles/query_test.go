@@ -0,0 +1,65 @@
+package canticles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQueryImportPath(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	gopath := filepath.Join(testHome, "gopath")
+	project := PackageSource(gopath, "example.com/p")
+	sub := filepath.Join(project, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(file string, v interface{}) {
+		b, _ := json.Marshal(v)
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(DependencyFile(project), []*CanticleDependency{{Root: "dep.com/a", Revision: "1", SourcePath: "git@dep.com:a"}})
+	write(WorkspaceLockFile(filepath.Dir(project)), []*CanticleDependency{{Root: "dep.com/a", Revision: "2"}})
+	write(filepath.Join(sub, "sub.go"), "package sub")
+
+	cache := LoadPackageCache(filepath.Join(testHome, "golist.json"))
+	cache.Put(gopath, "example.com/p", &Package{Imports: []string{"fmt"}})
+	cache.Put(gopath, "example.com/p/sub", &Package{TestImports: []string{"dep.com/a/x"}})
+	cache.Put(gopath, "example.com/other", &Package{Imports: []string{"dep.com/a/x"}})
+
+	qr, err := QueryImportPath(gopath, project, "dep.com/a/x", cache)
+	if err != nil {
+		t.Fatalf("Error querying: %s", err.Error())
+	}
+	expected := &QueryResult{
+		ImportPath:   "dep.com/a/x",
+		Root:         "dep.com/a",
+		Revision:     "1",
+		SourcePath:   "git@dep.com:a",
+		Locked:       true,
+		LockRevision: "2",
+		Importers:    []string{"example.com/p/sub"},
+	}
+	if !reflect.DeepEqual(qr, expected) {
+		t.Errorf("Expected query result %+v got %+v", expected, qr)
+	}
+
+	// Packages changed since they were cached are not trusted
+	write(filepath.Join(sub, "sub.go"), "package sub // changed")
+	qr, err = QueryImportPath(gopath, project, "dep.com/b", cache)
+	if err != nil || qr.Pinned() || qr.Locked || len(qr.Importers) != 0 {
+		t.Errorf("Expected nothing known about dep.com/b got %+v %v", qr, err)
+	}
+	if importers := cache.Importers(gopath, "example.com/p", "dep.com/a/x"); len(importers) != 0 {
+		t.Errorf("Expected changed importer to be unknown got %v", importers)
+	}
+}
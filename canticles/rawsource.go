@@ -0,0 +1,146 @@
+package canticles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Prefixes of the SourcePaths of repos fetched from the revision
+// archives of their web frontend, for hosts which do not allow
+// cloning. gitiles+https://host/repo is fetched from
+// https://host/repo/+archive/<rev>.tar.gz and
+// cgit+https://host/repo.git from
+// https://host/repo.git/snapshot/repo-<rev>.tar.gz.
+const (
+	GitilesPrefix = "gitiles+"
+	CgitPrefix    = "cgit+"
+)
+
+// splitRawSource returns the prefix and url of a raw source, or empty
+// strings if source is not one.
+func splitRawSource(source string) (prefix, url string) {
+	for _, p := range []string{GitilesPrefix, CgitPrefix} {
+		if strings.HasPrefix(source, p) {
+			return p, strings.TrimPrefix(source, p)
+		}
+	}
+	return "", ""
+}
+
+// IsRawSource returns true if source is fetched by a RawSourceVCS.
+func IsRawSource(source string) bool {
+	prefix, _ := splitRawSource(source)
+	return prefix != ""
+}
+
+// A RawSourceVCS fetches the revisions of a repo from the archives
+// served by its Gitiles or cgit frontend. It is read only, it can
+// fetch and set revisions but not report branches.
+type RawSourceVCS struct {
+	Root string
+	// Source is a SourcePath prefixed by GitilesPrefix or
+	// CgitPrefix.
+	Source string
+	// Revision is fetched by Create when it is given none.
+	Revision string
+	Gopath   string
+}
+
+// ArchiveURL returns the url of the archive of rev.
+func (rv *RawSourceVCS) ArchiveURL(rev string) (string, error) {
+	prefix, url := splitRawSource(rv.Source)
+	url = strings.TrimSuffix(url, "/")
+	switch prefix {
+	case GitilesPrefix:
+		return url + "/+archive/" + rev + ".tar.gz", nil
+	case CgitPrefix:
+		name := strings.TrimSuffix(path.Base(url), ".git")
+		return url + "/snapshot/" + name + "-" + rev + ".tar.gz", nil
+	}
+	return "", fmt.Errorf("cant fetch %s, it is not a gitiles or cgit source", rv.Source)
+}
+
+func (rv *RawSourceVCS) record() *archiveRecord {
+	return readArchiveRecord(PackageSource(rv.Gopath, rv.Root))
+}
+
+// Create fetches rev, or the Revision if rev is empty. Nothing is
+// done if it is already fetched.
+func (rv *RawSourceVCS) Create(ctx context.Context, rev string) error {
+	if rev == "" {
+		rev = rv.Revision
+	}
+	return rv.SetRev(ctx, rev)
+}
+
+// SetRev fetches the archive of rev, replacing the revision fetched
+// before.
+func (rv *RawSourceVCS) SetRev(ctx context.Context, rev string) error {
+	if rev == "" {
+		return fmt.Errorf("cant fetch %s from %s without a revision", rv.Root, rv.Source)
+	}
+	if record := rv.record(); record != nil && record.Source == rv.Source && record.Revision == rev {
+		LogVerboseContext(ctx, "Revision %s of %s already fetched", rev, rv.Root)
+		return nil
+	}
+	url, err := rv.ArchiveURL(rev)
+	if err != nil {
+		return err
+	}
+	// Gitiles archives have no top level directory to strip
+	prefix, _ := splitRawSource(rv.Source)
+	record := &archiveRecord{Source: rv.Source, Revision: rev}
+	return fetchArchive(ctx, url, PackageSource(rv.Gopath, rv.Root), prefix == CgitPrefix, record, nil)
+}
+
+// GetRev returns the revision fetched.
+func (rv *RawSourceVCS) GetRev(ctx context.Context) (string, error) {
+	record := rv.record()
+	if record == nil || record.Revision == "" {
+		return "", fmt.Errorf("%s is not fetched from %s", rv.Root, rv.Source)
+	}
+	return record.Revision, nil
+}
+
+// GetBranch always returns an error, the branches of raw sources are
+// not known.
+func (rv *RawSourceVCS) GetBranch(ctx context.Context) (string, error) {
+	return "", errors.New("raw sources have no branches")
+}
+
+// UpdateBranch never updates, the branches of raw sources are not
+// known.
+func (rv *RawSourceVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
+	return false, "raw sources have no branches", nil
+}
+
+// GetSource returns the Source.
+func (rv *RawSourceVCS) GetSource(ctx context.Context) (string, error) {
+	return rv.Source, nil
+}
+
+// GetRoot returns the Root.
+func (rv *RawSourceVCS) GetRoot() string {
+	return rv.Root
+}
+
+// RawSourceRepoResolver resolves a RawSourceVCS for dependencies
+// whose SourcePath is a raw source.
+type RawSourceRepoResolver struct {
+	Gopath string
+}
+
+// ResolveRepo returns a RawSourceVCS if dep has a raw SourcePath.
+func (rr *RawSourceRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	if dep == nil || !IsRawSource(dep.SourcePath) {
+		return nil, NewResolutionFailureError(importPath, "raw source")
+	}
+	root := dep.Root
+	if root == "" {
+		root = importPath
+	}
+	return &RawSourceVCS{Root: root, Source: dep.SourcePath, Revision: dep.Revision, Gopath: rr.Gopath}, nil
+}
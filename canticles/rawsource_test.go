@@ -0,0 +1,87 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRawSourceVCS(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	archives := map[string]string{
+		"/repo/+archive/1.tar.gz":               filepath.Join(testHome, "gitiles-1.tar.gz"),
+		"/repo/+archive/2.tar.gz":               filepath.Join(testHome, "gitiles-2.tar.gz"),
+		"/cgit/repo.git/snapshot/repo-1.tar.gz": filepath.Join(testHome, "cgit-1.tar.gz"),
+	}
+	// A gitiles archive whose files are all in one directory keeps it
+	writeTestTarGz(t, archives["/repo/+archive/1.tar.gz"], map[string]string{"src/a.go": "package a"}, nil)
+	writeTestTarGz(t, archives["/repo/+archive/2.tar.gz"], map[string]string{"src/b.go": "package b"}, nil)
+	writeTestTarGz(t, archives["/cgit/repo.git/snapshot/repo-1.tar.gz"], map[string]string{"repo-1/c.go": "package c"}, nil)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if file, ok := archives[r.URL.Path]; ok {
+			http.ServeFile(w, r, file)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	gopath := filepath.Join(testHome, "gopath")
+	resolver := &RawSourceRepoResolver{gopath}
+	cdep := &CanticleDependency{Root: "example.com/repo", SourcePath: GitilesPrefix + server.URL + "/repo", Revision: "1"}
+	v, err := resolver.ResolveRepo(context.Background(), "example.com/repo/src", cdep)
+	if err != nil {
+		t.Fatalf("Error resolving raw source: %s", err.Error())
+	}
+	if err := v.Create(context.Background(), ""); err != nil {
+		t.Fatalf("Error fetching raw source: %s", err.Error())
+	}
+	dir := PackageSource(gopath, "example.com/repo")
+	if _, err := os.Stat(filepath.Join(dir, "src", "a.go")); err != nil {
+		t.Errorf("Expected gitiles archive unpacked without stripping got %s", err.Error())
+	}
+	if rev, err := v.GetRev(context.Background()); err != nil || rev != "1" {
+		t.Errorf("Expected revision 1 got %s %v", rev, err)
+	}
+	if err := v.Create(context.Background(), ""); err != nil || len(requests) != 1 {
+		t.Errorf("Expected fetched revision not downloaded again got %v %v", requests, err)
+	}
+	if err := v.SetRev(context.Background(), "2"); err != nil {
+		t.Fatalf("Error setting revision: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src", "a.go")); err == nil {
+		t.Errorf("Expected files of the old revision removed")
+	}
+	if err := v.SetRev(context.Background(), "3"); err == nil {
+		t.Errorf("Expected error fetching missing revision")
+	}
+	if rev, _ := v.GetRev(context.Background()); rev != "2" {
+		t.Errorf("Expected failed fetch to keep revision 2 got %s", rev)
+	}
+
+	cgit := &RawSourceVCS{Root: "example.com/cgit", Source: CgitPrefix + server.URL + "/cgit/repo.git", Gopath: gopath}
+	if err := cgit.Create(context.Background(), "1"); err != nil {
+		t.Fatalf("Error fetching cgit source: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(PackageSource(gopath, "example.com/cgit"), "c.go")); err != nil {
+		t.Errorf("Expected cgit snapshot directory stripped got %s", err.Error())
+	}
+
+	if _, err := resolver.ResolveRepo(context.Background(), "example.com/x", &CanticleDependency{SourcePath: "https://example.com/x"}); err == nil {
+		t.Errorf("Expected error resolving a non raw source")
+	}
+	secured, err := (&SecureMode{Enabled: true, Plaintext: NewStringSet()}).Source("gitiles+http://example.com/repo")
+	if err != nil || secured != "gitiles+https://example.com/repo" {
+		t.Errorf("Expected secured raw source got %s %v", secured, err)
+	}
+}
@@ -0,0 +1,279 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// A ResolvedRepo is the VCS and repository a dependency was resolved
+// to.
+type ResolvedRepo struct {
+	VCS  string
+	Repo string
+	Root string
+}
+
+// A ResolutionCache remembers how dependencies were resolved across
+// runs, so fetching them again needs no meta tag discovery or probing
+// of their source. Entries are keyed by Root and SourcePath.
+type ResolutionCache struct {
+	sync.Mutex
+	file    string
+	entries map[string]*ResolvedRepo
+	dirty   bool
+}
+
+// LoadResolutionCache reads the cache stored in file. A missing or
+// corrupt cache file results in an empty cache.
+func LoadResolutionCache(file string) *ResolutionCache {
+	defer StartSpan(PhaseIO, file)()
+	rc := &ResolutionCache{
+		file:    file,
+		entries: make(map[string]*ResolvedRepo),
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			LogVerbose("Error reading resolution cache %s", err.Error())
+		}
+		return rc
+	}
+	if err := json.Unmarshal(b, &rc.entries); err != nil {
+		LogWarn("Ignoring corrupt resolution cache %s: %s", file, err.Error())
+		rc.entries = make(map[string]*ResolvedRepo)
+	}
+	return rc
+}
+
+func resolutionCacheKey(cdep *CanticleDependency) string {
+	return cdep.Root + "|" + cdep.SourcePath
+}
+
+// Get returns the cached resolution of cdep, or nil if it has none.
+func (rc *ResolutionCache) Get(cdep *CanticleDependency) *ResolvedRepo {
+	if rc == nil {
+		return nil
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	return rc.entries[resolutionCacheKey(cdep)]
+}
+
+// Put caches the resolution of cdep to repo.
+func (rc *ResolutionCache) Put(cdep *CanticleDependency, repo *vcs.RepoRoot) {
+	if rc == nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	rc.entries[resolutionCacheKey(cdep)] = &ResolvedRepo{VCS: repo.VCS.Cmd, Repo: repo.Repo, Root: repo.Root}
+	rc.dirty = true
+}
+
+// Save writes the cache back to its file if it has changed.
+func (rc *ResolutionCache) Save() error {
+	if rc == nil {
+		return nil
+	}
+	defer StartSpan(PhaseIO, rc.file)()
+	rc.Lock()
+	defer rc.Unlock()
+	if !rc.dirty {
+		return nil
+	}
+	b, err := json.Marshal(rc.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rc.file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(rc.file, b, 0644); err != nil {
+		return err
+	}
+	rc.dirty = false
+	return nil
+}
+
+var mirrorNames = strings.NewReplacer("/", "!", ":", "!")
+
+// mirrorName returns the name of the entry holding the bare mirror of
+// the git repo for root.
+func mirrorName(root string) string {
+	return "repo-" + mirrorNames.Replace(root)
+}
+
+// MirrorDir returns the entry of cache holding the bare mirror of the
+// git repo for root.
+func MirrorDir(cache *Cache, root string) string {
+	return cache.Entry(mirrorName(root))
+}
+
+// hasRevision returns true if the git repo at gitDir contains rev.
+func hasRevision(ctx context.Context, gitDir, rev string) bool {
+	return command(ctx, "", "git", "--git-dir", gitDir, "cat-file", "-e", rev+"^{commit}").Run() == nil
+}
+
+// MirrorRepo makes dir a bare mirror of the git repo, cloning it if
+// dir does not exist and fetching it if it does not contain rev.
+func MirrorRepo(ctx context.Context, dir, repo, rev string) error {
+	var cmd *exec.Cmd
+	switch _, err := os.Stat(dir); {
+	case os.IsNotExist(err):
+		LogVerboseContext(ctx, "Mirroring %s into %s", repo, dir)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		cmd = command(ctx, "", "git", "clone", "--mirror", "--", repo, dir)
+	case err != nil:
+		return err
+	case rev != "" && hasRevision(ctx, dir, rev):
+		LogVerboseContext(ctx, "Mirror %s already contains %s", dir, rev)
+		return nil
+	default:
+		LogVerboseContext(ctx, "Fetching %s into mirror %s", repo, dir)
+		cmd = command(ctx, "", "git", "--git-dir", dir, "fetch", "--prune", repo, "+refs/*:refs/*")
+	}
+	done := StartSpan(PhaseFetch, repo)
+	out, err := cmd.CombinedOutput()
+	done()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("cant mirror %s %s", repo, strings.TrimSpace(string(out)))
+	}
+	if rev != "" && !hasRevision(ctx, dir, rev) {
+		return fmt.Errorf("cant mirror %s, it has no revision %s", repo, rev)
+	}
+	return nil
+}
+
+// A CachedRepoResolver resolves dependencies from its Resolutions,
+// only using Resolver for those not yet resolved, and fetches git
+// repos with a mirror in Mirrors from the mirror.
+type CachedRepoResolver struct {
+	Resolver    RepoResolver
+	Resolutions *ResolutionCache
+	// Mirrors, if non nil, is the cache of MirrorDirs.
+	Mirrors *Cache
+	Gopath  string
+}
+
+// NewCachedRepoResolver returns a CachedRepoResolver for resolver
+// using the DefaultCache. If the cache can not be used nothing is
+// cached.
+func NewCachedRepoResolver(resolver RepoResolver, gopath string) *CachedRepoResolver {
+	cr := &CachedRepoResolver{Resolver: resolver, Gopath: gopath}
+	cache, err := DefaultCache()
+	if err != nil {
+		LogWarn("Not using resolution cache: %s", err.Error())
+		return cr
+	}
+	cr.Mirrors = cache
+	cr.Resolutions = LoadResolutionCache(cache.Entry("resolve.json"))
+	return cr
+}
+
+// ResolveRepo resolves importPath from the cache, or the Resolver,
+// caching its result.
+func (cr *CachedRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	cacheable := dep != nil && dep.Root != ""
+	var pv *PackageVCS
+	if cacheable {
+		if r := cr.Resolutions.Get(dep); r != nil {
+			if cmd := vcs.ByCmd(r.VCS); cmd != nil {
+				LogVerboseContext(ctx, "Using cached resolution of %s to %s", dep.Root, r.Repo)
+				pv = &PackageVCS{Repo: &vcs.RepoRoot{VCS: cmd, Repo: r.Repo, Root: r.Root}, Gopath: cr.Gopath}
+			}
+		}
+		if pv != nil {
+			Count(MetricResolutionCacheHits, 1)
+		} else {
+			Count(MetricResolutionCacheMisses, 1)
+		}
+	}
+	if pv == nil {
+		v, err := cr.Resolver.ResolveRepo(ctx, importPath, dep)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if pv, ok = v.(*PackageVCS); !ok {
+			return v, nil
+		}
+		if cacheable {
+			cr.Resolutions.Put(dep, pv.Repo)
+		}
+	}
+	if cr.Mirrors == nil || pv.Repo.VCS.Cmd != "git" {
+		return pv, nil
+	}
+	mirror := MirrorDir(cr.Mirrors, pv.Repo.Root)
+	if _, err := os.Stat(mirror); err != nil {
+		return pv, nil
+	}
+	return &MirrorVCS{PackageVCS: pv, Mirror: mirror, Mirrors: cr.Mirrors}, nil
+}
+
+// Save saves the Resolutions.
+func (cr *CachedRepoResolver) Save() error {
+	return cr.Resolutions.Save()
+}
+
+// A MirrorVCS is a git PackageVCS cloned from a local mirror of its
+// repo when the mirror contains the revision fetched.
+type MirrorVCS struct {
+	*PackageVCS
+	Mirror string
+	// Mirrors, if non nil, is the cache holding Mirror, which is in
+	// Use while cloned.
+	Mirrors *Cache
+}
+
+// Create clones the mirror, then points the clone at the real repo.
+func (mv *MirrorVCS) Create(ctx context.Context, rev string) error {
+	if mv.Mirrors != nil {
+		release, err := mv.Mirrors.Use(filepath.Base(mv.Mirror))
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if rev != "" && !hasRevision(ctx, mv.Mirror, rev) {
+		LogVerboseContext(ctx, "Mirror %s has no revision %s, fetching %s", mv.Mirror, rev, mv.Repo.Repo)
+		Count(MetricMirrorMisses, 1)
+		return mv.PackageVCS.Create(ctx, rev)
+	}
+	Count(MetricMirrorHits, 1)
+	mirrored := &PackageVCS{
+		Repo:   &vcs.RepoRoot{VCS: mv.Repo.VCS, Repo: mv.Mirror, Root: mv.Repo.Root},
+		Gopath: mv.Gopath,
+	}
+	if err := mirrored.Create(ctx, rev); err != nil {
+		return err
+	}
+	cmd := command(ctx, PackageSource(mv.Gopath, mv.Repo.Root), "git", "remote", "set-url", "origin", mv.Repo.Repo)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cant set origin of %s %s", mv.Repo.Root, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// CreateShallow clones the mirror if it contains rev, which needs no
+// network, else fetches only rev of the real repo.
+func (mv *MirrorVCS) CreateShallow(ctx context.Context, rev string) error {
+	if rev != "" && hasRevision(ctx, mv.Mirror, rev) {
+		return mv.Create(ctx, rev)
+	}
+	return mv.PackageVCS.CreateShallow(ctx, rev)
+}
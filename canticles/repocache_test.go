@@ -0,0 +1,129 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+type countingResolver struct {
+	v     VCS
+	calls int
+}
+
+func (cr *countingResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	cr.calls++
+	return cr.v, nil
+}
+
+func TestResolutionCache(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	file := filepath.Join(testHome, "cache", "resolve.json")
+	rc := LoadResolutionCache(file)
+	cdep := &CanticleDependency{Root: "dep.com/x"}
+	if r := rc.Get(cdep); r != nil {
+		t.Errorf("Expected empty cache got %+v", r)
+	}
+	rc.Put(cdep, &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: "https://dep.com/x", Root: "dep.com/x"})
+	if err := rc.Save(); err != nil {
+		t.Fatalf("Error saving cache: %s", err.Error())
+	}
+	r := LoadResolutionCache(file).Get(cdep)
+	if r == nil || r.VCS != "git" || r.Repo != "https://dep.com/x" || r.Root != "dep.com/x" {
+		t.Errorf("Expected cached git resolution got %+v", r)
+	}
+	if r := LoadResolutionCache(file).Get(&CanticleDependency{Root: "dep.com/x", SourcePath: "https://mirror.com/x"}); r != nil {
+		t.Errorf("Expected no resolution for another source got %+v", r)
+	}
+}
+
+func TestWarmDependencies(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	upstream := filepath.Join(testHome, "upstream")
+	if err := os.MkdirAll(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(upstream, "a.go"), []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"add", "a.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = upstream
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	rev, err := NewLocalVCS("upstream", "upstream", testHome, vcs.ByCmd("git")).CurrentRevCmd.Exec(context.Background(), upstream)
+	if err != nil {
+		t.Fatalf("Error getting rev: %s", err.Error())
+	}
+
+	cache := &Cache{Dir: filepath.Join(testHome, "cache")}
+	remote := &countingResolver{v: &PackageVCS{Repo: &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: upstream, Root: "dep.com/x"}}}
+	resolver := &CachedRepoResolver{Resolver: remote, Resolutions: LoadResolutionCache(cache.Entry("resolve.json"))}
+	cdep := &CanticleDependency{Root: "dep.com/x", Revision: rev}
+	if errs := WarmDependencies(context.Background(), cache, resolver, []*CanticleDependency{cdep}, 2); len(errs) > 0 {
+		t.Fatalf("Error warming deps: %v", errs)
+	}
+	if err := resolver.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasRevision(context.Background(), MirrorDir(cache, "dep.com/x"), rev) {
+		t.Errorf("Expected mirror to contain %s", rev)
+	}
+	errs := WarmDependencies(context.Background(), cache, resolver, []*CanticleDependency{{Root: "dep.com/x", Revision: "0123456789012345678901234567890123456789"}}, 2)
+	if len(errs) != 1 {
+		t.Errorf("Expected error warming a missing revision got %v", errs)
+	}
+
+	// Fetching now needs neither discovery nor the upstream repo
+	if err := os.RemoveAll(upstream); err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(testHome, "gopath")
+	remote.calls = 0
+	resolver = &CachedRepoResolver{
+		Resolver:    remote,
+		Resolutions: LoadResolutionCache(cache.Entry("resolve.json")),
+		Mirrors:     cache,
+		Gopath:      gopath,
+	}
+	v, err := resolver.ResolveRepo(context.Background(), "dep.com/x", cdep)
+	if err != nil {
+		t.Fatalf("Error resolving from cache: %s", err.Error())
+	}
+	if remote.calls != 0 {
+		t.Errorf("Expected cached resolution got %d resolver calls", remote.calls)
+	}
+	if _, ok := v.(*MirrorVCS); !ok {
+		t.Fatalf("Expected mirror vcs got %+v", v)
+	}
+	if err := v.Create(context.Background(), rev); err != nil {
+		t.Fatalf("Error creating from mirror: %s", err.Error())
+	}
+	lv := NewLocalVCS("dep.com/x", "dep.com/x", gopath, vcs.ByCmd("git"))
+	if got, err := lv.GetRev(context.Background()); err != nil || got != rev {
+		t.Errorf("Expected checkout at %s got %s %v", rev, got, err)
+	}
+	if source, err := lv.GetSource(context.Background()); err != nil || strings.TrimSpace(source) != upstream {
+		t.Errorf("Expected origin %s got %s %v", upstream, source, err)
+	}
+}
@@ -1,12 +1,14 @@
 package canticles
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
 	"sort"
 )
 
@@ -18,8 +20,29 @@ type Save struct {
 	OnDisk    bool
 	Branches  bool
 	NoSources bool
+	NoCache   bool
+	NoTests   bool
+	Binaries  bool
+	Fast      bool
+	AllFiles  bool
+	Generate  bool
+	Packages  bool
+	Hash      bool
+	Compact   bool
+	Jobs      int
+	Full      bool
 	Excludes  DirFlags
+	Reason    string
+	Ticket    string
+	Workspace string
+
+	AllowViolations bool
+	TagSets   BuildTagSets
 	Resolver  ConflictResolver
+
+	// Positions records the file:line positions of imports in
+	// the ImportedAt of deps read by ReadDeps.
+	Positions bool
 }
 
 func NewSave() *Save {
@@ -35,6 +58,22 @@ func NewSave() *Save {
 	f.BoolVar(&s.DryRun, "d", false, "Don't save the deps, just print them.")
 	f.BoolVar(&s.Branches, "b", false, "Save branches for the current projects, not revisions.")
 	f.BoolVar(&s.NoSources, "no-sources", false, "Don't save a sources for the current projects, not revisions.")
+	f.BoolVar(&s.NoCache, "no-cache", false, "Don't use or update the cache of go list results.")
+	f.BoolVar(&s.NoTests, "no-tests", false, "Don't save the imports of test files, including external tests.")
+	f.BoolVar(&s.Fast, "fast", false, "Read imports by parsing go files, only running go list for packages with errors.")
+	f.BoolVar(&s.Packages, "packages", false, "Also save the import paths used from each dependency.")
+	f.BoolVar(&s.AllowViolations, "allow-violations", false, "Save dependencies forbidden by the source policy, recording them as exceptions.")
+	f.BoolVar(&s.Hash, "hash", false, "Also save a hash of each dependencies files, verified by cant get.")
+	f.IntVar(&s.Jobs, "j", runtime.GOMAXPROCS(0), "The number of packages, and go list processes, read at once.")
+	f.BoolVar(&s.Full, "full", false, "Re-resolve every dependency, including those unchanged since the last save.")
+	f.BoolVar(&s.Compact, "compact", false, "Bound memory on very large trees by recording dependencies by root.")
+	f.StringVar(&s.Reason, "reason", "", "Annotate each dependency whose revision changes with this reason.")
+	f.StringVar(&s.Workspace, "workspace", "", "Save the projects listed in this workspace file together into one lock.")
+	f.StringVar(&s.Ticket, "ticket", "", "Annotate each dependency whose revision changes with this ticket, requires -reason.")
+	f.BoolVar(&s.Binaries, "binaries", false, "Also save the dependencies required by each main package.")
+	f.BoolVar(&s.AllFiles, "all-files", false, "Also save imports from files excluded by build constraints for any platform or tags.")
+	f.BoolVar(&s.Generate, "generate", false, "Also save the tools run by go:generate directives in the tools group.")
+	f.Var(&s.TagSets, "tagset", "Also save imports from files built under this goos/goarch,tag,... set, may be repeated.")
 	f.Var(&s.Excludes, "exclude", "Do not recur into these directories when saving unless they are in the dep tree.")
 	return s
 }
@@ -43,21 +82,59 @@ var save = NewSave()
 
 var SaveCommand = &Command{
 	Name:             "save",
-	UsageLine:        "save [-d] [-b] [-v] [-ondisk] [-exclude <dir>] [-no-sources]",
+	UsageLine:        "save [-d] [-b] [-v] [-j <n>] [-ondisk] [-exclude <dir>] [-no-sources] [-no-cache] [-no-tests] [-binaries] [-packages] [-fast] [-tagset <goos/goarch,tags>] [-all-files] [-generate] [-hash] [-compact] [-full] [-allow-violations] [-reason <text>] [-ticket <id>] [-workspace <file>]",
 	ShortDescription: "Save the current revision of all dependencies in a Canticle file.",
 	LongDescription: `The save command will save the dependencies for a package into a Canticle file.  If at the src level save the current revision of all packages in belows. All dependencies must be present on disk and in the GOROOT. The generated Canticle file will be saved in the packages root directory.
 
+Directories matched by a .canticleignore file, using gitignore syntax, in the package root or ignored by the packages git repository are not recurred into.
+
+If the package root contains a Canticle.policy file dependencies whose source is not allowed by it are not saved, see cant get.
+
 Specify -v to print out a verbose set of operations instead of just errors.
 
+Specify -allow-violations to save dependencies forbidden by the source policy anyway, recording them as exceptions in the policy file.
+
 Specify -ondisk to use on disk revisions and sources and do no conflict resolution.
 
-Specify -b to save branches or tags when present instead of revisions`,
+Dependencies whose on disk revision and source match the Canticle file are not re-resolved, the revisions the Canticle files of other dependencies ask for are ignored for them. Specify -full to resolve every dependency.
+
+A dependency may be given a semantic version Constraint in the Canticle file, such as "^1.2.0" or ">=2.0, <3.0", instead of only a pinned Revision. Save lists the tags of the repo on disk and saves the Revision of the highest tag satisfying the constraint, and the constraints the Canticle files of other dependencies give it, along with the constraint, even with -ondisk. Save fails if no tag satisfies them, and warns if the resolved revision is not the one on disk, run cant get to fetch it.
+
+Specify -b to save branches or tags when present instead of revisions
+
+Specify -no-tests to ignore the imports of _test.go files, both in the package and in external foo_test packages.
+
+Specify -tagset linux/arm,appengine to also save the imports of files only built for that platform and tags. It may be repeated.
+
+Specify -all-files to save the imports of every file excluded by build constraints, including files tagged ignore, so pins cover code not built on this machine.
+
+Specify -generate to also save the tools referenced by go:generate directives in the package, such as go run paths and stringer. Dependencies only needed by tools are saved in the tools group, see cant get -group.
+
+Specify -binaries to also save the roots required by each main package into a Canticle.binaries file, see cant binaries.
+
+Specify -packages to record the individual import paths used from each dependency in its Packages field. Dependencies are still fetched by their root.
+
+Specify -hash to record a hash of the files of each dependency as its TreeHash, the files committed at the revision for git repos so untracked and ignored files are left out. Cant get fails if a fetched dependency does not match it, protecting against rewritten history and tampered mirrors.
+
+Save warns when a dependency not already in the Canticle file has a root which differs only in case, in look-alike characters, or by one character from a pinned or widely used root, as such paths are often typos or malicious copies.
+
+Specify -reason "fix CVE-2016-1234" -ticket SEC-42 to annotate each dependency whose revision changes with why, as its Annotation. Annotations of unchanged dependencies are kept, see cant verify -require-annotations.
+
+Specify -j 4 to read at most 4 packages, and run at most 4 go list processes, at once. It defaults to the number of CPUs, -j 1 reads one package at a time. The result does not depend on -j.
+
+Specify -fast to read imports by parsing only the import blocks of go files, go list is still used for packages that can not be parsed.
+
+Specify -compact to bound memory when saving trees of tens of thousands of packages. Dependencies outside the project are recorded by root as they are walked rather than by package, so -compact can not be used with -binaries, -generate or -packages.
+
+Specify -workspace Canticle.workspace to save several projects together. The workspace file is JSON listing the project directories, relative to it, as {"Projects": ["svc/a", "svc/b"]}. The sources of all the projects are resolved at once into a Canticle.lock file next to the workspace file, and each project's Canticle file is saved with the dependencies it uses at the same revisions. Dependencies the projects' Canticle files pin at different revisions are reported before the projects are read, and resolved like any other conflict. -workspace can not be used with -binaries, -compact, -generate or -packages.
+
+Specify -no-cache to list every package with go list instead of reusing results for unchanged directories.`,
 	Flags: save.flags,
 	Cmd:   save,
 }
 
 // Run the save command, ignores args. Uses its flagset instead.
-func (s *Save) Run(args []string) {
+func (s *Save) Run(ctx context.Context, args []string) {
 	if s.Verbose {
 		Verbose = true
 	}
@@ -79,67 +156,220 @@ func (s *Save) Run(args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := s.SaveProject(gopath, wd); err != nil {
+	if s.Workspace != "" {
+		if err := s.SaveWorkspace(ctx, gopath, s.Workspace); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := s.SaveProject(ctx, gopath, wd); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// SaveProject does four things:
+// SaveProject does five things:
 //   *  It fetches the dep tree of path
+//   *  It validates no imports cross an internal boundary
 //   *  It fetches all possible DependencySources
 //   *  It performs conflict resolution
 //   *  It saves a Canticle file in path
-func (s *Save) SaveProject(gopath, path string) error {
-	LogVerbose("Working with gopath %s", gopath)
-	deps, err := s.ReadDeps(gopath, path)
-	if err != nil {
-		return err
+func (s *Save) SaveProject(ctx context.Context, gopath, path string) error {
+	if s.Ticket != "" && s.Reason == "" {
+		return fmt.Errorf("cant annotate with ticket %s without a -reason", s.Ticket)
 	}
-	sources, err := s.GetSources(gopath, path, deps)
+	deps, cantdeps, err := s.ResolveProject(ctx, gopath, path)
 	if err != nil {
 		return err
 	}
-	LogVerbose("Discovered sources:\n%+v", sources)
-	cantdeps, err := s.Resolver.ResolveConflicts(sources)
+	saved, err := SavedDependencies(path)
 	if err != nil {
 		return err
 	}
+	Annotate(saved, cantdeps, s.Reason, s.Ticket)
+	for _, warning := range LookAlikeWarnings(saved, cantdeps) {
+		LogWarnContext(ctx, "%s, check it is the intended import path", warning)
+	}
 
 	if err := s.SaveDeps(path, cantdeps); err != nil {
 		return err
 	}
+	if s.Binaries {
+		if err := s.SaveBinaries(path, BinaryRoots(deps, cantdeps)); err != nil {
+			return err
+		}
+	}
+	if !s.DryRun {
+		Emit(ctx, SaveCompleted{Path: path, Dependencies: cantdeps})
+	}
 	return nil
 }
 
+// ResolveProject does everything SaveProject does but save, returning
+// the dep tree of path and the resolved dependencies which would be
+// saved.
+func (s *Save) ResolveProject(ctx context.Context, gopath, path string) (Dependencies, []*CanticleDependency, error) {
+	LogVerboseContext(ctx, "Working with gopath %s", gopath)
+	if s.Compact {
+		for flag, set := range map[string]bool{"binaries": s.Binaries, "generate": s.Generate, "packages": s.Packages} {
+			if set {
+				return nil, nil, fmt.Errorf("cant save %s with both -compact and -%s", path, flag)
+			}
+		}
+	}
+	deps, err := s.ReadDeps(ctx, gopath, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if violations := deps.InternalViolations(); len(violations) > 0 {
+		for _, v := range violations {
+			LogWarnContext(ctx, "%s", v.Error())
+		}
+		return nil, nil, fmt.Errorf("cant save %s, %d imports of internal packages are not allowed", path, len(violations))
+	}
+	sources, err := s.GetSources(ctx, gopath, path, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+	LogVerboseContext(ctx, "Discovered sources:\n%+v", sources)
+	cantdeps, err := s.Resolver.ResolveConflicts(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := LoadSourcePolicy(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := policy.EnforcePolicy(path, cantdeps, s.AllowViolations); err != nil {
+		return nil, nil, err
+	}
+	if s.Generate {
+		MarkTools(deps, cantdeps)
+	}
+	if s.Packages {
+		for _, cdep := range cantdeps {
+			cdep.Packages = deps.PackagesUnder(cdep.Root)
+		}
+	}
+	if s.Hash {
+		for _, cdep := range cantdeps {
+			if cdep.TreeHash, err = TreeHash(ctx, PackageSource(gopath, cdep.Root)); err != nil {
+				return nil, nil, fmt.Errorf("cant hash %s %s", cdep.Root, err.Error())
+			}
+		}
+	}
+	return deps, cantdeps, nil
+}
+
 // GetSources returns the DependencySources (e.g. the possible revisions, vcs sources, and deps)
 // for a give path, and set Dependencies.
-func (s *Save) GetSources(gopath, path string, deps Dependencies) (*DependencySources, error) {
-	LogVerbose("Getting local vcs sources for repos in path %+v", gopath)
+func (s *Save) GetSources(ctx context.Context, gopath, path string, deps Dependencies) (*DependencySources, error) {
+	LogVerboseContext(ctx, "Getting local vcs sources for repos in path %+v", gopath)
 	repoResolver := NewMemoizedRepoResolver(&LocalRepoResolver{gopath})
 	reader := &DepReader{Gopath: gopath}
+	saved, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
 	sourceResolver := &SourcesResolver{
-		Gopath:     gopath,
-		RootPath:   path,
-		Resolver:   repoResolver,
-		Branches:   s.Branches,
-		Sources:    !s.NoSources,
-		CDepReader: reader,
-	}
-	return sourceResolver.ResolveSources(deps)
+		Gopath:      gopath,
+		RootPath:    path,
+		Resolver:    repoResolver,
+		Branches:    s.Branches,
+		Sources:     !s.NoSources,
+		CDepReader:  reader,
+		Constraints: make(map[string]string),
+	}
+	if !s.Full {
+		sourceResolver.Saved = saved
+	}
+	for _, cdep := range saved {
+		if cdep.Constraint != "" {
+			sourceResolver.Constraints[cdep.Root] = cdep.Constraint
+		}
+	}
+	return sourceResolver.ResolveSources(ctx, deps)
 }
 
 // ReadDeps reads all dependencies and transitive deps for path.
-func (s *Save) ReadDeps(gopath, path string) (Dependencies, error) {
-	LogVerbose("Reading deps for repos in path %s", path)
-	reader := &DepReader{Gopath: gopath}
+func (s *Save) ReadDeps(ctx context.Context, gopath, path string) (Dependencies, error) {
+	LogVerboseContext(ctx, "Reading deps for repos in path %s", path)
+	reader := &DepReader{Gopath: gopath, TagSets: s.TagSets, ExcludeTests: s.NoTests, IgnoredFiles: s.AllFiles, Scan: s.Fast, Positions: s.Positions}
+	if !s.NoCache {
+		cache, err := DefaultPackageCache()
+		if err != nil {
+			LogWarnContext(ctx, "Not using package cache: %s", err.Error())
+		} else {
+			reader.Cache = cache
+			defer func() {
+				if err := cache.Save(); err != nil {
+					LogWarnContext(ctx, "Error saving package cache: %s", err.Error())
+				}
+				EvictCache()
+			}()
+		}
+	}
+	if s.Generate {
+		var err error
+		if reader.Generate, err = PackageName(gopath, path); err != nil {
+			return nil, err
+		}
+	}
 	ds := NewDependencySaver(reader.AllDeps, gopath, path)
 	ds.NoRecur = StringSet(s.Excludes)
+	config, err := LoadProjectConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	ds.Skip = append(ds.Skip, config.SkipDirs...)
+	if ds.Ignore, err = LoadIgnoreFile(path); err != nil {
+		return nil, err
+	}
+	ds.VCSIgnored = func(ctx context.Context, paths []string) ([]string, error) {
+		return GitIgnoredPaths(ctx, path, paths)
+	}
+	reader.Env = config.Env()
+	describe := func(dep *Dependency) {
+		if sd := reader.SystemDeps(dep.ImportPath); sd != nil {
+			dep.PkgConfig.Add(sd.PkgConfig...)
+			dep.CgoLibs.Add(sd.Libs...)
+		}
+		dep.CanonicalPath = reader.CanonicalPath(dep.ImportPath)
+		dep.Main = reader.IsMain(dep.ImportPath)
+		dep.Tool = reader.IsTool(dep.ImportPath)
+	}
+	if s.Compact {
+		// Packages are described as they are read since
+		// afterwards only their roots are known
+		ds.Compact = true
+		ds.Describe = describe
+		resolver := &LocalRepoResolver{gopath}
+		ds.RootOf = func(ctx context.Context, importPath string) (string, error) {
+			vcs, err := resolver.ResolveRepo(ctx, importPath, nil)
+			if err != nil {
+				return "", err
+			}
+			return vcs.GetRoot(), nil
+		}
+	}
 	dw := NewDependencyWalker(ds.PackagePaths, ds.SavePackageDeps)
-	if err := dw.TraverseDependencies(path); err != nil {
+	dw.Prefetch = reader.PrefetchPaths
+	dw.Workers = s.Jobs
+	if err := dw.TraverseDependencies(ctx, path); err != nil {
 		return nil, fmt.Errorf("cant read path dep tree %s %s", path, err.Error())
 	}
-	LogVerbose("Built dep tree: %+v", ds.Dependencies())
-	return ds.Dependencies(), nil
+	deps := ds.Dependencies()
+	for _, dep := range deps {
+		if !s.Compact {
+			describe(dep)
+		}
+		if s.Positions {
+			for importer := range dep.ImportedFrom {
+				dep.ImportedAt.Add(reader.ImportPositions(importer, dep.ImportPath)...)
+			}
+		}
+	}
+	LogVerboseContext(ctx, "Built dep tree: %+v", deps)
+	return deps, nil
 }
 
 // SaveDeps saves a canticle file at path containing deps.
@@ -155,3 +385,17 @@ func (s *Save) SaveDeps(path string, deps []*CanticleDependency) error {
 	}
 	return ioutil.WriteFile(DependencyFile(path), j, 0644)
 }
+
+// SaveBinaries saves the roots required by each binary in a
+// Canticle.binaries file at path.
+func (s *Save) SaveBinaries(path string, binaries map[string][]string) error {
+	j, err := json.MarshalIndent(binaries, "", "    ")
+	if err != nil {
+		return err
+	}
+	if s.DryRun {
+		fmt.Println(string(j))
+		return nil
+	}
+	return ioutil.WriteFile(BinaryFile(path), j, 0644)
+}
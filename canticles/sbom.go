@@ -0,0 +1,382 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NoAssertion is the SPDX value for unknown information.
+const NoAssertion = "NOASSERTION"
+
+// LicensePatterns are checked in order against license files by
+// DetectLicense. A license matches if its file contains all the
+// phrases, compared with whitespace collapsed.
+var LicensePatterns = []struct {
+	ID      string
+	Phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"This is free and unencumbered software"}},
+}
+
+// DetectLicense returns the SPDX identifier of the license found in
+// the LICENSE, LICENCE or COPYING file of dir. If none is found
+// NoAssertion is returned.
+func DetectLicense(dir string) string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return NoAssertion
+	}
+	for _, info := range infos {
+		name := strings.ToUpper(info.Name())
+		if info.IsDir() || !(strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") || strings.HasPrefix(name, "COPYING")) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		text := strings.Join(strings.Fields(string(b)), " ")
+		for _, license := range LicensePatterns {
+			if containsAll(text, license.Phrases) {
+				return license.ID
+			}
+		}
+	}
+	return NoAssertion
+}
+
+func containsAll(s string, subs []string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
+
+// An SBOMPackage is a component of a BillOfMaterials.
+type SBOMPackage struct {
+	// Name is the root import path of the package.
+	Name     string
+	Revision string
+	// Source is the VCS source, if known.
+	Source string
+	// License is an SPDX license identifier or NoAssertion.
+	License string
+}
+
+// A BillOfMaterials lists a project and the dependencies pinned by its
+// Canticle file.
+type BillOfMaterials struct {
+	Project      *SBOMPackage
+	Dependencies []*SBOMPackage
+	// Created is the RFC3339 time the bill was generated, see
+	// BuildTime.
+	Created string
+}
+
+// NewBillOfMaterials returns the bill of materials of the project
+// in path and its pinned dependencies, which must be present in
+// gopath to detect their licenses.
+func NewBillOfMaterials(ctx context.Context, gopath, path string) (*BillOfMaterials, error) {
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := (&LocalRepoResolver{LocalPath: gopath}).ResolveRepo(ctx, pkg, nil)
+	if err != nil {
+		return nil, err
+	}
+	project := &SBOMPackage{Name: v.GetRoot(), License: DetectLicense(PackageSource(gopath, v.GetRoot()))}
+	if project.Revision, err = v.GetRev(ctx); err != nil {
+		return nil, err
+	}
+	if project.Source, err = v.GetSource(ctx); err != nil {
+		LogWarnContext(ctx, "Not recording source of %s: %s", project.Name, err.Error())
+	}
+	cdeps, err := (&DepReader{Gopath: gopath}).CanticleDependencies(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	sort.Sort(CanticleDependencies(cdeps))
+	bom := &BillOfMaterials{Project: project}
+	for _, cdep := range cdeps {
+		bom.Dependencies = append(bom.Dependencies, &SBOMPackage{
+			Name:     cdep.Root,
+			Revision: cdep.Revision,
+			Source:   cdep.SourcePath,
+			License:  DetectLicense(PackageSource(gopath, cdep.Root)),
+		})
+	}
+	if bom.Created, err = BuildTime(); err != nil {
+		return nil, err
+	}
+	return bom, nil
+}
+
+// SPDXDocument is an SPDX 2.3 JSON document.
+type SPDXDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      SPDXCreationInfo   `json:"creationInfo"`
+	Packages          []SPDXPackage      `json:"packages"`
+	Relationships     []SPDXRelationship `json:"relationships"`
+}
+
+type SPDXCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type SPDXPackage struct {
+	SPDXID           string `json:"SPDXID"`
+	Name             string `json:"name"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+	LicenseConcluded string `json:"licenseConcluded"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	CopyrightText    string `json:"copyrightText"`
+}
+
+type SPDXRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX returns bom as an SPDX document describing the project, which
+// depends on each dependency.
+func (bom *BillOfMaterials) SPDX() *SPDXDocument {
+	doc := &SPDXDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              bom.Project.Name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + bom.Project.Name + "-" + bom.Project.Revision,
+		CreationInfo: SPDXCreationInfo{
+			Created:  bom.Created,
+			Creators: []string{"Tool: canticle"},
+		},
+	}
+	for i, p := range append([]*SBOMPackage{bom.Project}, bom.Dependencies...) {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		location := p.Source
+		if location == "" {
+			location = NoAssertion
+		}
+		doc.Packages = append(doc.Packages, SPDXPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Revision,
+			DownloadLocation: location,
+			LicenseConcluded: NoAssertion,
+			LicenseDeclared:  p.License,
+			CopyrightText:    NoAssertion,
+		})
+		rel := SPDXRelationship{SPDXElementID: "SPDXRef-Package-0", RelationshipType: "DEPENDS_ON", RelatedSPDXElement: id}
+		if i == 0 {
+			rel = SPDXRelationship{SPDXElementID: doc.SPDXID, RelationshipType: "DESCRIBES", RelatedSPDXElement: id}
+		}
+		doc.Relationships = append(doc.Relationships, rel)
+	}
+	return doc
+}
+
+// CycloneDXDocument is a CycloneDX 1.4 JSON document.
+type CycloneDXDocument struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	Version      int                   `json:"version"`
+	Metadata     CycloneDXMetadata     `json:"metadata"`
+	Components   []CycloneDXComponent  `json:"components"`
+	Dependencies []CycloneDXDependency `json:"dependencies"`
+}
+
+type CycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp,omitempty"`
+	Tools     []CycloneDXTool    `json:"tools"`
+	Component CycloneDXComponent `json:"component"`
+}
+
+type CycloneDXTool struct {
+	Name string `json:"name"`
+}
+
+type CycloneDXComponent struct {
+	Type               string                       `json:"type"`
+	BOMRef             string                       `json:"bom-ref"`
+	Name               string                       `json:"name"`
+	Version            string                       `json:"version,omitempty"`
+	PURL               string                       `json:"purl"`
+	Licenses           []CycloneDXLicenseChoice     `json:"licenses,omitempty"`
+	ExternalReferences []CycloneDXExternalReference `json:"externalReferences,omitempty"`
+}
+
+type CycloneDXLicenseChoice struct {
+	License CycloneDXLicense `json:"license"`
+}
+
+type CycloneDXLicense struct {
+	ID string `json:"id"`
+}
+
+type CycloneDXExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type CycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// component returns p as a CycloneDX component of type t, referenced
+// by its golang package url.
+func (p *SBOMPackage) component(t string) CycloneDXComponent {
+	purl := "pkg:golang/" + p.Name
+	if p.Revision != "" {
+		purl += "@" + p.Revision
+	}
+	c := CycloneDXComponent{
+		Type:    t,
+		BOMRef:  purl,
+		Name:    p.Name,
+		Version: p.Revision,
+		PURL:    purl,
+	}
+	if p.License != "" && p.License != NoAssertion {
+		c.Licenses = []CycloneDXLicenseChoice{{CycloneDXLicense{p.License}}}
+	}
+	if p.Source != "" {
+		c.ExternalReferences = []CycloneDXExternalReference{{"vcs", p.Source}}
+	}
+	return c
+}
+
+// CycloneDX returns bom as a CycloneDX document with the project as
+// the metadata component, which depends on each dependency.
+func (bom *BillOfMaterials) CycloneDX() *CycloneDXDocument {
+	project := bom.Project.component("application")
+	doc := &CycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: CycloneDXMetadata{
+			Timestamp: bom.Created,
+			Tools:     []CycloneDXTool{{"canticle"}},
+			Component: project,
+		},
+		Components: []CycloneDXComponent{},
+	}
+	dep := CycloneDXDependency{Ref: project.BOMRef}
+	for _, p := range bom.Dependencies {
+		c := p.component("library")
+		doc.Components = append(doc.Components, c)
+		dep.DependsOn = append(dep.DependsOn, c.BOMRef)
+		doc.Dependencies = append(doc.Dependencies, CycloneDXDependency{Ref: c.BOMRef})
+	}
+	doc.Dependencies = append([]CycloneDXDependency{dep}, doc.Dependencies...)
+	return doc
+}
+
+// SBOMFormats are the documents, by -format name, cant sbom can
+// generate from a BillOfMaterials.
+var SBOMFormats = map[string]func(*BillOfMaterials) interface{}{
+	"spdx":      func(bom *BillOfMaterials) interface{} { return bom.SPDX() },
+	"cyclonedx": func(bom *BillOfMaterials) interface{} { return bom.CycloneDX() },
+}
+
+type SBOM struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Format  string
+	Out     string
+}
+
+func NewSBOM() *SBOM {
+	f := flag.NewFlagSet("sbom", flag.ExitOnError)
+	s := &SBOM{flags: f}
+	f.BoolVar(&s.Verbose, "v", false, "Be verbose when getting stuff")
+	f.StringVar(&s.Format, "format", "spdx", "The document format to generate: spdx or cyclonedx")
+	f.StringVar(&s.Out, "o", "", "Write the document to this file instead of stdout")
+	return s
+}
+
+var sbom = NewSBOM()
+
+var SBOMCommand = &Command{
+	Name:             "sbom",
+	UsageLine:        "sbom [-v] [-format spdx|cyclonedx] [-o <file>]",
+	ShortDescription: "Generate a software bill of materials for the project.",
+	LongDescription: `The sbom command generates a software bill of materials covering the project in the current directory and every dependency pinned in its Canticle file, with their revision, source and license. Licenses are detected from the LICENSE, LICENCE or COPYING file of each dependency in the GOPATH, so run cant get first.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -format spdx to generate an SPDX 2.3 JSON document, the default, or -format cyclonedx to generate a CycloneDX 1.4 JSON document.
+
+Specify -o to write the document to a file instead of stdout.`,
+	Flags: sbom.flags,
+	Cmd:   sbom,
+}
+
+// Run the sbom command.
+func (s *SBOM) Run(ctx context.Context, args []string) {
+	if s.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := s.Generate(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s.Out == "" {
+		fmt.Println(string(b))
+		return
+	}
+	if err := ioutil.WriteFile(s.Out, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Generate returns the Format document for the project in path.
+func (s *SBOM) Generate(ctx context.Context, gopath, path string) ([]byte, error) {
+	format, ok := SBOMFormats[s.Format]
+	if !ok {
+		return nil, fmt.Errorf("cant generate unknown sbom format %s", s.Format)
+	}
+	bom, err := NewBillOfMaterials(ctx, gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(format(bom), "", "    ")
+}
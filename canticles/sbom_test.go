@@ -0,0 +1,90 @@
+package canticles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectLicense(t *testing.T) {
+	licenses := map[string]string{
+		"MIT": `Permission is hereby granted, free of
+charge, to any person obtaining a copy`,
+		"Apache-2.0":   "Apache License\n  Version 2.0, January 2004",
+		"BSD-3-Clause": "Redistribution and use in source and binary forms...\nNeither the name of",
+		NoAssertion:    "All rights reserved.",
+	}
+	for expected, text := range licenses {
+		dir, err := ioutil.TempDir("", "cant-test")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %s", err.Error())
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "LICENSE.txt"), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if license := DetectLicense(dir); license != expected {
+			t.Errorf("Expected license %s got %s", expected, license)
+		}
+	}
+	if license := DetectLicense("/nonexistent"); license != NoAssertion {
+		t.Errorf("Expected no license for missing dir got %s", license)
+	}
+}
+
+func TestBillOfMaterialsSPDX(t *testing.T) {
+	bom := &BillOfMaterials{
+		Project: &SBOMPackage{Name: "a.com/p", Revision: "1", Source: "https://a.com/p", License: "MIT"},
+		Dependencies: []*SBOMPackage{
+			{Name: "b.com/d", Revision: "2", License: NoAssertion},
+		},
+		Created: "1970-01-01T00:00:00Z",
+	}
+	doc := bom.SPDX()
+	if len(doc.Packages) != 2 {
+		t.Fatalf("Expected 2 packages got %+v", doc.Packages)
+	}
+	if doc.Packages[0].LicenseDeclared != "MIT" || doc.Packages[0].DownloadLocation != "https://a.com/p" {
+		t.Errorf("Unexpected project package %+v", doc.Packages[0])
+	}
+	if doc.Packages[1].DownloadLocation != NoAssertion || doc.Packages[1].VersionInfo != "2" {
+		t.Errorf("Unexpected dependency package %+v", doc.Packages[1])
+	}
+	expected := []SPDXRelationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-0"},
+		{"SPDXRef-Package-0", "DEPENDS_ON", "SPDXRef-Package-1"},
+	}
+	for i, rel := range expected {
+		if doc.Relationships[i] != rel {
+			t.Errorf("Expected relationship %+v got %+v", rel, doc.Relationships[i])
+		}
+	}
+}
+
+func TestBillOfMaterialsCycloneDX(t *testing.T) {
+	bom := &BillOfMaterials{
+		Project: &SBOMPackage{Name: "a.com/p", Revision: "1", Source: "https://a.com/p", License: "MIT"},
+		Dependencies: []*SBOMPackage{
+			{Name: "b.com/d", Revision: "2", License: NoAssertion},
+		},
+		Created: "1970-01-01T00:00:00Z",
+	}
+	doc := bom.CycloneDX()
+	project := doc.Metadata.Component
+	if project.PURL != "pkg:golang/a.com/p@1" || project.Type != "application" {
+		t.Errorf("Unexpected project component %+v", project)
+	}
+	if len(project.Licenses) != 1 || project.Licenses[0].License.ID != "MIT" {
+		t.Errorf("Expected project license MIT got %+v", project.Licenses)
+	}
+	if len(doc.Components) != 1 {
+		t.Fatalf("Expected 1 component got %+v", doc.Components)
+	}
+	if c := doc.Components[0]; c.BOMRef != "pkg:golang/b.com/d@2" || c.Licenses != nil || c.ExternalReferences != nil {
+		t.Errorf("Unexpected dependency component %+v", c)
+	}
+	if len(doc.Dependencies) != 2 || doc.Dependencies[0].Ref != project.BOMRef || doc.Dependencies[0].DependsOn[0] != "pkg:golang/b.com/d@2" {
+		t.Errorf("Unexpected dependencies %+v", doc.Dependencies)
+	}
+}
@@ -0,0 +1,206 @@
+package canticles
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileImports parses only the import block of a go file and returns
+// the import paths it contains.
+func FileImports(filename string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	imports := make([]string, 0, len(f.Imports))
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad import %s in file %s", imp.Path.Value, filename)
+		}
+		imports = append(imports, p)
+	}
+	return imports, nil
+}
+
+// FileImportPositions parses only the import block of a go file and
+// returns each import path mapped to the file:line positions it is
+// imported at.
+func FileImportPositions(filename string) (map[string][]string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string][]string, len(f.Imports))
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad import %s in file %s", imp.Path.Value, filename)
+		}
+		pos := fset.Position(imp.Path.Pos())
+		positions[p] = append(positions[p], fmt.Sprintf("%s:%d", pos.Filename, pos.Line))
+	}
+	return positions, nil
+}
+
+// ScanPackage reads a package in gopath by parsing only the import
+// blocks of its go files, without running go list. Only the fields
+// needed to read dependencies are filled in. Packages with no
+// buildable files return a *PackageError like LoadPackage.
+func ScanPackage(importPath, gopath string) (*Package, error) {
+	ctx := build.Default
+	ctx.GOPATH = gopath
+	dir := PackageSource(gopath, importPath)
+	bp, err := ctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return nil, &PackageError{Err: err.Error()}
+		}
+		return nil, err
+	}
+	return &Package{
+		Dir:            bp.Dir,
+		ImportPath:     importPath,
+		Name:           bp.Name,
+		ImportComment:  bp.ImportComment,
+		GoFiles:        bp.GoFiles,
+		CgoFiles:       bp.CgoFiles,
+		IgnoredGoFiles: bp.IgnoredGoFiles,
+		CgoLDFLAGS:     bp.CgoLDFLAGS,
+		CgoPkgConfig:   bp.CgoPkgConfig,
+		Imports:        bp.Imports,
+		TestGoFiles:    bp.TestGoFiles,
+		TestImports:    bp.TestImports,
+		XTestGoFiles:   bp.XTestGoFiles,
+		XTestImports:   bp.XTestImports,
+	}, nil
+}
+
+// A BuildTagSet is a GOOS, GOARCH and set of build tags to evaluate
+// build constraints with. An empty GOOS or GOARCH uses the current
+// platforms.
+type BuildTagSet struct {
+	GOOS   string
+	GOARCH string
+	Tags   []string
+}
+
+// ParseBuildTagSet parses a tag set of the form
+// "goos/goarch,tag1,tag2". The goos/goarch element is optional.
+func ParseBuildTagSet(s string) (BuildTagSet, error) {
+	var ts BuildTagSet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case strings.Contains(part, "/"):
+			platform := strings.SplitN(part, "/", 2)
+			if platform[0] == "" || platform[1] == "" {
+				return ts, fmt.Errorf("invalid platform %s in tag set %s", part, s)
+			}
+			ts.GOOS, ts.GOARCH = platform[0], platform[1]
+		default:
+			ts.Tags = append(ts.Tags, part)
+		}
+	}
+	return ts, nil
+}
+
+// String returns the tag set in the form parsed by ParseBuildTagSet.
+func (ts BuildTagSet) String() string {
+	parts := make([]string, 0, len(ts.Tags)+1)
+	if ts.GOOS != "" || ts.GOARCH != "" {
+		parts = append(parts, ts.GOOS+"/"+ts.GOARCH)
+	}
+	return strings.Join(append(parts, ts.Tags...), ",")
+}
+
+// Context returns a build context that evaluates constraints using
+// this tag set.
+func (ts BuildTagSet) Context() build.Context {
+	ctx := build.Default
+	if ts.GOOS != "" {
+		ctx.GOOS = ts.GOOS
+	}
+	if ts.GOARCH != "" {
+		ctx.GOARCH = ts.GOARCH
+	}
+	ctx.BuildTags = ts.Tags
+	return ctx
+}
+
+// BuildTagSets is a flag.Value that collects BuildTagSet's.
+type BuildTagSets []BuildTagSet
+
+// String so this value pretty prints well.
+func (bts *BuildTagSets) String() string {
+	sets := make([]string, 0, len(*bts))
+	for _, ts := range *bts {
+		sets = append(sets, ts.String())
+	}
+	return fmt.Sprintf("%+v", sets)
+}
+
+// Set parses and appends a BuildTagSet.
+func (bts *BuildTagSets) Set(v string) error {
+	ts, err := ParseBuildTagSet(v)
+	if err != nil {
+		return err
+	}
+	*bts = append(*bts, ts)
+	return nil
+}
+
+// IgnoredImports returns the imports of all the packages
+// IgnoredGoFiles regardless of their build constraints. Test files
+// are only examined if includeTest is true.
+func (p *Package) IgnoredImports(includeTest bool) ([]string, error) {
+	imports := NewStringSet()
+	for _, name := range p.IgnoredGoFiles {
+		if !includeTest && strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		fileImports, err := FileImports(filepath.Join(p.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		imports.Add(fileImports...)
+	}
+	return imports.Array(), nil
+}
+
+// MatrixImports returns the imports of the packages IgnoredGoFiles
+// which would be built under any of the tag sets. This finds
+// platform specific imports not reported by go list for the current
+// platform. Test files are only examined if includeTest is true.
+func (p *Package) MatrixImports(sets []BuildTagSet, includeTest bool) ([]string, error) {
+	imports := NewStringSet()
+	for _, ts := range sets {
+		ctx := ts.Context()
+		for _, name := range p.IgnoredGoFiles {
+			if !includeTest && strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			match, err := ctx.MatchFile(p.Dir, name)
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				continue
+			}
+			fileImports, err := FileImports(filepath.Join(p.Dir, name))
+			if err != nil {
+				return nil, err
+			}
+			imports.Add(fileImports...)
+		}
+	}
+	return imports.Array(), nil
+}
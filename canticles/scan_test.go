@@ -0,0 +1,134 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+var linuxFile = `// +build linux
+
+package pkg
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+)
+`
+
+var appengineFile = `// +build appengine
+
+package pkg
+
+import "google.golang.org/appengine"
+`
+
+func TestParseBuildTagSet(t *testing.T) {
+	ts, err := ParseBuildTagSet("linux/arm,appengine,foo")
+	if err != nil {
+		t.Fatalf("Error parsing valid tag set %s", err.Error())
+	}
+	expected := BuildTagSet{GOOS: "linux", GOARCH: "arm", Tags: []string{"appengine", "foo"}}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Errorf("Expected tag set %+v got %+v", expected, ts)
+	}
+	if ts.String() != "linux/arm,appengine,foo" {
+		t.Errorf("Tag set did not round trip got %s", ts.String())
+	}
+	if _, err := ParseBuildTagSet("linux/"); err == nil {
+		t.Errorf("Expected error parsing invalid platform")
+	}
+}
+
+func TestMatrixImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"linux.go": linuxFile, "appengine.go": appengineFile}
+	for name, src := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imports, err := FileImports(path.Join(dir, "linux.go"))
+	if err != nil {
+		t.Fatalf("Error reading file imports %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"fmt", "golang.org/x/sys/unix"}, imports) {
+		t.Errorf("Unexpected file imports %v", imports)
+	}
+
+	pkg := &Package{Dir: dir, IgnoredGoFiles: []string{"appengine.go", "linux.go"}}
+	imports, err = pkg.MatrixImports([]BuildTagSet{{GOOS: "linux", GOARCH: "amd64"}}, true)
+	if err != nil {
+		t.Fatalf("Error reading matrix imports %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"fmt", "golang.org/x/sys/unix"}, imports) {
+		t.Errorf("Unexpected linux imports %v", imports)
+	}
+	imports, err = pkg.MatrixImports([]BuildTagSet{{GOOS: "darwin", GOARCH: "amd64", Tags: []string{"appengine"}}}, true)
+	if err != nil {
+		t.Fatalf("Error reading matrix imports %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"google.golang.org/appengine"}, imports) {
+		t.Errorf("Unexpected appengine imports %v", imports)
+	}
+
+	imports, err = pkg.IgnoredImports(true)
+	if err != nil {
+		t.Fatalf("Error reading ignored imports %s", err.Error())
+	}
+	expected := []string{"fmt", "golang.org/x/sys/unix", "google.golang.org/appengine"}
+	if !reflect.DeepEqual(expected, imports) {
+		t.Errorf("Expected ignored imports %v got %v", expected, imports)
+	}
+}
+
+func TestScanPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	for _, pkg := range []string{"test.com/cubicle", "test.com/cubicle/sosicle"} {
+		p := PackageSource(dir, pkg)
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		src := "package " + path.Base(pkg) + "\nimport _ \"test.com/fascicle\"\n"
+		if err := ioutil.WriteFile(path.Join(p, "test.go"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(PackageSource(dir, "test.com/cubicle/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := ScanPackage("test.com/cubicle", dir)
+	if err != nil {
+		t.Fatalf("Error scanning valid package %s", err.Error())
+	}
+	if pkg.Name != "cubicle" || !reflect.DeepEqual([]string{"test.com/fascicle"}, pkg.Imports) {
+		t.Errorf("Unexpected scanned package %+v", pkg)
+	}
+
+	_, err = ScanPackage("test.com/cubicle/empty", dir)
+	if e, ok := err.(*PackageError); !ok || !e.IsNoBuildable() {
+		t.Errorf("Expected no buildable error scanning empty dir got %v", err)
+	}
+
+	dr := &DepReader{Gopath: dir, Scan: true}
+	deps, err := dr.GoRemoteDependencies(context.Background(), "test.com/cubicle/sosicle")
+	if err != nil {
+		t.Fatalf("Error reading scanned deps %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"test.com/fascicle"}, deps) {
+		t.Errorf("Unexpected scanned deps %v", deps)
+	}
+}
@@ -0,0 +1,165 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PlaintextSchemes maps the unencrypted source schemes to the scheme
+// they are rewritten to in secure mode. Schemes mapped to "" can not
+// be rewritten.
+var PlaintextSchemes = map[string]string{
+	"http": "https",
+	"git":  "https",
+	"svn":  "",
+	"bzr":  "",
+}
+
+// A SecureMode refuses fetching and discovering dependencies over
+// unencrypted transports, except from whitelisted hosts, much like
+// go get without -insecure.
+type SecureMode struct {
+	Enabled bool
+	// Plaintext are the hosts which may still be fetched from
+	// unencrypted.
+	Plaintext StringSet
+}
+
+// Secure is the SecureMode set by cant -secure and -plaintext.
+var Secure = &SecureMode{Plaintext: NewStringSet()}
+
+// Merge returns the SecureMode of sm with the Secure and
+// PlaintextHosts of config added.
+func (sm *SecureMode) Merge(config *ProjectConfig) *SecureMode {
+	merged := &SecureMode{Enabled: sm.Enabled, Plaintext: NewStringSet()}
+	merged.Plaintext.Union(sm.Plaintext)
+	if config != nil {
+		merged.Enabled = merged.Enabled || config.Secure
+		merged.Plaintext.Add(config.PlaintextHosts...)
+	}
+	return merged
+}
+
+// sourceHost returns the host of a scheme://[user@]host[:port]/path
+// source.
+func sourceHost(rest string) string {
+	host := rest
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.ToLower(host)
+}
+
+// Source returns source rewritten to https if it uses an unencrypted
+// scheme. An error is returned if it can not be rewritten. Sources
+// from Plaintext hosts, or when sm is not Enabled, are returned
+// unchanged.
+func (sm *SecureMode) Source(source string) (string, error) {
+	if prefix, url := splitRawSource(source); prefix != "" {
+		secured, err := sm.Source(url)
+		if err != nil {
+			return "", err
+		}
+		return prefix + secured, nil
+	}
+	i := strings.Index(source, "://")
+	if !sm.Enabled || i < 0 {
+		return source, nil
+	}
+	scheme, rest := strings.ToLower(source[:i]), source[i+3:]
+	secure, plaintext := PlaintextSchemes[scheme]
+	host := sourceHost(rest)
+	if !plaintext || sm.Plaintext[host] {
+		return source, nil
+	}
+	if secure == "" {
+		return "", fmt.Errorf("cant fetch %s over unencrypted %s, allow its host with -plaintext %s", source, scheme, host)
+	}
+	authority, path := rest, ""
+	if j := strings.Index(rest, "/"); j >= 0 {
+		authority, path = rest[:j], rest[j:]
+	}
+	// The port of the unencrypted service does not carry over
+	if j := strings.LastIndex(authority, ":"); j > strings.LastIndex(authority, "@") {
+		authority = authority[:j]
+	}
+	rewritten := secure + "://" + authority + path
+	LogVerbose("Rewriting unencrypted source %s to %s", source, rewritten)
+	return rewritten, nil
+}
+
+// Resolver returns r wrapped to fetch only from secure sources, if
+// sm is Enabled.
+func (sm *SecureMode) Resolver(r RepoResolver) RepoResolver {
+	if !sm.Enabled {
+		return r
+	}
+	return &SecureRepoResolver{Resolver: r, Mode: sm}
+}
+
+// A SecureRepoResolver rewrites the SourcePath of dependencies, and
+// the repos of remote VCSs resolved by Resolver, according to Mode.
+type SecureRepoResolver struct {
+	Resolver RepoResolver
+	Mode     *SecureMode
+}
+
+// ResolveRepo resolves importPath from a secured source.
+func (sr *SecureRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	if dep != nil && dep.SourcePath != "" {
+		source, err := sr.Mode.Source(dep.SourcePath)
+		if err != nil {
+			return nil, err
+		}
+		if source != dep.SourcePath {
+			secured := *dep
+			secured.SourcePath = source
+			dep = &secured
+		}
+	}
+	v, err := sr.Resolver.ResolveRepo(ctx, importPath, dep)
+	if err != nil {
+		return nil, err
+	}
+	pv, ok := v.(*PackageVCS)
+	if mv, mirrored := v.(*MirrorVCS); mirrored {
+		pv, ok = mv.PackageVCS, true
+	}
+	if ok {
+		if pv.Repo.Repo, err = sr.Mode.Source(pv.Repo.Repo); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// secureTransport refuses http requests to hosts not in plaintext.
+type secureTransport struct {
+	http.RoundTripper
+	plaintext StringSet
+}
+
+func (st *secureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && !st.plaintext[strings.ToLower(req.URL.Hostname())] {
+		return nil, fmt.Errorf("cant discover %s over unencrypted http, allow its host with -plaintext %s", req.URL, req.URL.Hostname())
+	}
+	return st.RoundTripper.RoundTrip(req)
+}
+
+// Transport returns rt refusing unencrypted requests, such as meta
+// tag discovery, to hosts not in sm.Plaintext if sm is Enabled,
+// otherwise rt.
+func (sm *SecureMode) Transport(rt http.RoundTripper) http.RoundTripper {
+	if sm == nil || !sm.Enabled {
+		return rt
+	}
+	return &secureTransport{RoundTripper: rt, plaintext: sm.Plaintext}
+}
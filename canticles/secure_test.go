@@ -0,0 +1,91 @@
+package canticles
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestSecureModeSource(t *testing.T) {
+	sm := &SecureMode{Enabled: true, Plaintext: NewStringSet()}
+	sm.Plaintext.Add("internal.example.com")
+	cases := []struct {
+		source   string
+		expected string
+		err      bool
+	}{
+		{"http://github.com/foo/bar", "https://github.com/foo/bar", false},
+		{"git://github.com:9418/foo/bar.git", "https://github.com/foo/bar.git", false},
+		{"HTTP://user@example.com:80/repo", "https://user@example.com/repo", false},
+		{"svn://svn.example.com/repo", "", true},
+		{"bzr://bzr.example.com/repo", "", true},
+		{"svn://internal.example.com/repo", "svn://internal.example.com/repo", false},
+		{"git://internal.example.com/repo", "git://internal.example.com/repo", false},
+		{"https://github.com/foo/bar", "https://github.com/foo/bar", false},
+		{"git@github.com:foo/bar.git", "git@github.com:foo/bar.git", false},
+		{"ssh://hg@bitbucket.org/foo/bar", "ssh://hg@bitbucket.org/foo/bar", false},
+		{"github.com/foo/bar", "github.com/foo/bar", false},
+	}
+	for _, c := range cases {
+		result, err := sm.Source(c.source)
+		if (err != nil) != c.err {
+			t.Errorf("Expected %s error %v got %v", c.source, c.err, err)
+		}
+		if result != c.expected {
+			t.Errorf("Expected %s secured to %s got %s", c.source, c.expected, result)
+		}
+	}
+	if result, err := (&SecureMode{}).Source("svn://svn.example.com/repo"); err != nil || result != "svn://svn.example.com/repo" {
+		t.Errorf("Expected disabled secure mode to not change sources got %s %v", result, err)
+	}
+}
+
+func TestSecureRepoResolver(t *testing.T) {
+	sm := &SecureMode{Enabled: true, Plaintext: NewStringSet()}
+	pv := &PackageVCS{Repo: &vcs.RepoRoot{Repo: "http://example.com/foo", Root: "example.com/foo"}}
+	tr := &testResolver{response: []resolve{{v: pv}}}
+	sr := sm.Resolver(tr)
+	cdep := &CanticleDependency{Root: "example.com/foo", SourcePath: "git://example.com/foo"}
+	v, err := sr.ResolveRepo(context.Background(), "example.com/foo", cdep)
+	if err != nil {
+		t.Fatalf("Error resolving repo: %s", err.Error())
+	}
+	if source := tr.resolutions[0].dep.SourcePath; source != "https://example.com/foo" {
+		t.Errorf("Expected resolver passed https source got %s", source)
+	}
+	if cdep.SourcePath != "git://example.com/foo" {
+		t.Errorf("Expected dependency to not be modified got %s", cdep.SourcePath)
+	}
+	if source, _ := v.GetSource(context.Background()); source != "https://example.com/foo" {
+		t.Errorf("Expected resolved repo rewritten to https got %s", source)
+	}
+	if _, err := sr.ResolveRepo(context.Background(), "example.com/bar", &CanticleDependency{Root: "example.com/bar", SourcePath: "svn://example.com/bar"}); err == nil {
+		t.Errorf("Expected svn source to be refused")
+	}
+	if (&SecureMode{}).Resolver(tr) != tr {
+		t.Errorf("Expected disabled secure mode to not wrap the resolver")
+	}
+}
+
+func TestSecureModeTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	sm := &SecureMode{Enabled: true, Plaintext: NewStringSet()}
+	client := &http.Client{Transport: sm.Transport(http.DefaultTransport)}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Expected unencrypted discovery to be refused")
+	}
+	sm.Plaintext.Add("127.0.0.1")
+	if resp, err := client.Get(server.URL); err != nil {
+		t.Errorf("Expected plaintext host to be allowed got %s", err.Error())
+	} else {
+		resp.Body.Close()
+	}
+	if (&SecureMode{}).Transport(http.DefaultTransport) != http.DefaultTransport {
+		t.Errorf("Expected disabled secure mode to not wrap the transport")
+	}
+}
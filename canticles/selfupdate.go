@@ -0,0 +1,392 @@
+package canticles
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultReleaseURL is where canticle releases are downloaded from
+// unless the UpdateConfig says otherwise.
+const DefaultReleaseURL = "https://github.com/Comcast/Canticle/releases"
+
+// ReleaseChecksums is the sha256sum formatted file of each release
+// listing the checksums of its binaries, and the release on a
+// "version <release>" line. ReleaseChecksums + ".sig" is its ed25519
+// signature.
+const ReleaseChecksums = "SHA256SUMS"
+
+// ReleasePublicKey is the base64 ed25519 public key canticle releases
+// are signed with. Release builds embed it with
+// -ldflags "-X github.com/Comcast/Canticle/canticles.ReleasePublicKey=<key>".
+var ReleasePublicKey = ""
+
+// ReleaseVersion is the release of the running cant, embedded as
+// ReleasePublicKey is. It is empty for builds from source.
+var ReleaseVersion = ""
+
+// UpdateConfig configures cant self-update, it is part of the
+// UserConfig.
+type UpdateConfig struct {
+	// URL is where releases are downloaded from, laid out as
+	// GitHub releases are, DefaultReleaseURL if empty.
+	URL string `json:",omitempty"`
+	// Version, if set, pins the release updated to instead of
+	// the latest.
+	Version string `json:",omitempty"`
+	// PublicKey is the base64 ed25519 public key the checksums
+	// of releases must be signed with, ReleasePublicKey if empty.
+	PublicKey string `json:",omitempty"`
+	// AllowUnsigned updates without checking signatures if there
+	// is no PublicKey, e.g. from an unsigned mirror.
+	AllowUnsigned bool `json:",omitempty"`
+}
+
+// ReleaseBinary returns the name of the release binary for goos and
+// goarch, e.g. cant-linux-amd64.
+func ReleaseBinary(goos, goarch string) string {
+	name := "cant-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// A SelfUpdater replaces a cant binary with a release.
+type SelfUpdater struct {
+	// URL is where releases are downloaded from.
+	URL string
+	// Version is the release downloaded, the latest if empty.
+	Version string
+	// PublicKey must have signed the checksums, unless it is nil
+	// and AllowUnsigned is set.
+	PublicKey     ed25519.PublicKey
+	AllowUnsigned bool
+	// Current is the release being replaced, signed releases
+	// must be newer. Any release is taken if empty.
+	Current string
+	// Binary is the name of the release binary downloaded.
+	Binary string
+	Client *http.Client
+}
+
+// NewSelfUpdater returns a SelfUpdater for this platform, replacing
+// the ReleaseVersion, configured by config, which may be nil.
+func NewSelfUpdater(config *UpdateConfig) (*SelfUpdater, error) {
+	client, err := HTTP.Client(TLS, Secure)
+	if err != nil {
+		return nil, err
+	}
+	su := &SelfUpdater{URL: DefaultReleaseURL, Current: ReleaseVersion, Binary: ReleaseBinary(runtime.GOOS, runtime.GOARCH), Client: client}
+	publicKey := ReleasePublicKey
+	if config != nil {
+		if config.URL != "" {
+			su.URL = config.URL
+		}
+		su.Version = config.Version
+		su.AllowUnsigned = config.AllowUnsigned
+		if config.PublicKey != "" {
+			publicKey = config.PublicKey
+		}
+	}
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("cant use update PublicKey %s, it is not a base64 ed25519 public key", publicKey)
+		}
+		su.PublicKey = key
+	}
+	return su, nil
+}
+
+// AssetURL returns the url of the file name of the release.
+func (su *SelfUpdater) AssetURL(name string) string {
+	base := strings.TrimSuffix(su.URL, "/")
+	if su.Version == "" {
+		return base + "/latest/download/" + name
+	}
+	return base + "/download/" + su.Version + "/" + name
+}
+
+// maxChecksumsSize bounds the checksums and signatures read.
+const maxChecksumsSize = 1 << 20
+
+func (su *SelfUpdater) download(ctx context.Context, name string, w io.Writer) error {
+	url := su.AssetURL(name)
+	LogVerboseContext(ctx, "Downloading %s", url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := su.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cant download %s %s", url, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cant download %s status %s", url, resp.Status)
+	}
+	n, err := io.Copy(w, resp.Body)
+	Count(MetricBytesDownloaded, n)
+	return err
+}
+
+func (su *SelfUpdater) downloadSmall(ctx context.Context, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := su.download(ctx, name, &limitedWriter{&buf, maxChecksumsSize}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// limitedWriter fails writes past N bytes.
+type limitedWriter struct {
+	w io.Writer
+	N int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.N {
+		return 0, errors.New("file too large")
+	}
+	lw.N -= int64(len(p))
+	return lw.w.Write(p)
+}
+
+// ParseChecksums parses sha256sum output into a map of file name to
+// hex checksum, and the release of its version line if any.
+func ParseChecksums(b []byte) (sums map[string]string, version string) {
+	sums = make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[0] == "version" {
+			version = fields[1]
+			continue
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, version
+}
+
+// errCurrentRelease is returned by Checksum for the Current release.
+var errCurrentRelease = errors.New("release is current")
+
+// Checksum returns the checksum of the Binary in the release. The
+// checksums must be signed by the PublicKey, unless AllowUnsigned is
+// set without one, and signed checksums must be of the Version, if
+// set, newer than Current.
+func (su *SelfUpdater) Checksum(ctx context.Context) (string, error) {
+	sums, err := su.downloadSmall(ctx, ReleaseChecksums)
+	if err != nil {
+		return "", err
+	}
+	if su.PublicKey == nil && !su.AllowUnsigned {
+		return "", fmt.Errorf("cant update, no PublicKey to verify %s with, configure one or AllowUnsigned", su.AssetURL(ReleaseChecksums))
+	}
+	checksums, version := ParseChecksums(sums)
+	if su.PublicKey != nil {
+		sig, err := su.downloadSmall(ctx, ReleaseChecksums+".sig")
+		if err != nil {
+			return "", err
+		}
+		// Signatures may be raw or base64
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+			sig = decoded
+		}
+		if !ed25519.Verify(su.PublicKey, sums, sig) {
+			return "", fmt.Errorf("cant update, the signature of %s is not valid", su.AssetURL(ReleaseChecksums))
+		}
+		if err := su.checkVersion(version); err != nil {
+			return "", err
+		}
+	}
+	sum, ok := checksums[su.Binary]
+	if !ok {
+		return "", fmt.Errorf("cant update, the release has no %s", su.Binary)
+	}
+	return sum, nil
+}
+
+// checkVersion returns an error unless the signed version is the
+// Version, if set, and newer than Current, errCurrentRelease if it is
+// Current.
+func (su *SelfUpdater) checkVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("cant update, %s has no signed version", su.AssetURL(ReleaseChecksums))
+	}
+	if su.Version != "" && version != su.Version {
+		return fmt.Errorf("cant update, %s is signed for %s not %s", su.AssetURL(ReleaseChecksums), version, su.Version)
+	}
+	if su.Current == "" {
+		return nil
+	}
+	if version == su.Current {
+		return errCurrentRelease
+	}
+	release, err := ParseVersion(version)
+	if err != nil {
+		return fmt.Errorf("cant update to %s", err.Error())
+	}
+	current, err := ParseVersion(su.Current)
+	if err != nil {
+		return fmt.Errorf("cant update from %s", err.Error())
+	}
+	switch release.Compare(current) {
+	case 0:
+		return errCurrentRelease
+	case -1:
+		return fmt.Errorf("cant update to %s, it is older than %s", version, su.Current)
+	}
+	return nil
+}
+
+// Update replaces the binary exe with the Binary of the release once
+// its checksum is verified. The binary is written next to exe and
+// renamed over it, so exe is never partially written. It returns
+// false if exe is already the release.
+func (su *SelfUpdater) Update(ctx context.Context, exe string) (bool, error) {
+	sum, err := su.Checksum(ctx)
+	if err == errCurrentRelease {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current, err := fileHash(exe); err == nil && current == sum {
+		return false, nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".cant-update")
+	if err != nil {
+		return false, fmt.Errorf("cant update %s %s", exe, err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	err = su.download(ctx, su.Binary, io.MultiWriter(tmp, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return false, fmt.Errorf("cant update, %s has checksum %s not %s", su.AssetURL(su.Binary), got, sum)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return false, err
+	}
+	if runtime.GOOS == "windows" {
+		// Running binaries can be renamed but not replaced
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return false, fmt.Errorf("cant move aside %s %s", exe, err.Error())
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return false, fmt.Errorf("cant replace %s %s", exe, err.Error())
+	}
+	return true, nil
+}
+
+type SelfUpdate struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Version string
+	URL     string
+}
+
+func NewSelfUpdate() *SelfUpdate {
+	f := flag.NewFlagSet("self-update", flag.ExitOnError)
+	su := &SelfUpdate{flags: f}
+	f.BoolVar(&su.Verbose, "v", false, "Be verbose when getting stuff")
+	f.StringVar(&su.Version, "version", "", "Update to this release instead of the latest or configured one")
+	f.StringVar(&su.URL, "url", "", "Download releases from this url instead of the configured one")
+	return su
+}
+
+var selfUpdate = NewSelfUpdate()
+
+var SelfUpdateCommand = &Command{
+	Name:             "self-update",
+	UsageLine:        "self-update [-v] [-version <release>] [-url <url>]",
+	ShortDescription: "Replace cant with a verified release.",
+	LongDescription: `The self-update command downloads the cant binary of a release for this platform, e.g. cant-linux-amd64, and replaces the running cant with it.
+
+The binary must match its sha256 in the SHA256SUMS of the release. SHA256SUMS.sig must be the ed25519 signature, raw or base64, of SHA256SUMS by the release key built into cant, or the configured PublicKey. The signed SHA256SUMS names its release on a "version <release>" line, which must be newer than the running cant. Updates from unsigned releases are refused unless AllowUnsigned is configured. The binary is written next to cant and renamed over it only once verified.
+
+Releases are downloaded from https://github.com/Comcast/Canticle/releases, the latest unless a Version is pinned. Machines kept on one release, or updating from a mirror, configure the Update of the users ~/.canticle/config.json, e.g.:
+
+  {
+      "Update": {
+          "URL": "https://mirror.example.com/canticle/releases",
+          "Version": "v1.4.0",
+          "PublicKey": "MCowBQYDK2VwAyEA..."
+      }
+  }
+
+Specify -version to update to that release.
+
+Specify -url to download releases from that url.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: selfUpdate.flags,
+	Cmd:   selfUpdate,
+}
+
+// Run the self-update command.
+func (s *SelfUpdate) Run(ctx context.Context, args []string) {
+	if s.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	config, err := LoadUserConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	su, err := NewSelfUpdater(config.Update)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if s.Version != "" {
+		su.Version = s.Version
+	}
+	if s.URL != "" {
+		su.URL = s.URL
+	}
+	if su.PublicKey == nil && su.AllowUnsigned {
+		LogWarnContext(ctx, "No update PublicKey, only checksums are verified")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	exe = EvalPath(exe)
+	updated, err := su.Update(ctx, exe)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	if !updated {
+		fmt.Printf("%s is already %s\n", exe, su.AssetURL(su.Binary))
+		return
+	}
+	fmt.Printf("Updated %s from %s\n", exe, su.AssetURL(su.Binary))
+}
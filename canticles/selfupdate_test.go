@@ -0,0 +1,139 @@
+package canticles
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("#!/bin/sh\necho new cant\n")
+	sum := sha256.Sum256(binary)
+	sums := []byte(fmt.Sprintf("version v1.2.0\n%s  cant-linux-amd64\n%s *cant-darwin-amd64\n", hex.EncodeToString(sum[:]), hex.EncodeToString(sum[:])))
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sums))
+	files := map[string][]byte{
+		"/download/v1.2.0/SHA256SUMS":       sums,
+		"/download/v1.2.0/SHA256SUMS.sig":   []byte(sig),
+		"/download/v1.2.0/cant-linux-amd64": binary,
+		"/latest/download/SHA256SUMS":       sums,
+		"/latest/download/cant-linux-amd64": []byte("corrupted"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer server.Close()
+
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	exe := filepath.Join(tmpdir, "cant")
+	if err := ioutil.WriteFile(exe, []byte("old cant"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &UpdateConfig{URL: server.URL + "/", Version: "v1.2.0", PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	su, err := NewSelfUpdater(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	su.Binary = "cant-linux-amd64"
+	if url := su.AssetURL("SHA256SUMS"); url != server.URL+"/download/v1.2.0/SHA256SUMS" {
+		t.Errorf("Expected release url got %s", url)
+	}
+	updated, err := su.Update(context.Background(), exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Errorf("Expected cant to be updated")
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != string(binary) {
+		t.Errorf("Expected new binary got %s", b)
+	}
+	if fi, err := os.Stat(exe); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("Expected executable binary got %v %v", fi, err)
+	}
+	if updated, err = su.Update(context.Background(), exe); err != nil || updated {
+		t.Errorf("Expected no update of current binary got %v %v", updated, err)
+	}
+
+	// Signed releases must be newer than the running one
+	ioutil.WriteFile(exe, []byte("old cant"), 0755)
+	su.Current = "v1.2.0"
+	if updated, err = su.Update(context.Background(), exe); err != nil || updated {
+		t.Errorf("Expected no update of the current release got %v %v", updated, err)
+	}
+	su.Current = "v1.3.0"
+	if _, err := su.Update(context.Background(), exe); err == nil {
+		t.Errorf("Expected error updating to an older release")
+	}
+	su.Current = "v1.1.0"
+	su.Version = "v1.2.1"
+	files["/download/v1.2.1/SHA256SUMS"] = sums
+	files["/download/v1.2.1/SHA256SUMS.sig"] = []byte(sig)
+	if _, err := su.Update(context.Background(), exe); err == nil {
+		t.Errorf("Expected error for a release signed for another version")
+	}
+	su.Version = "v1.2.0"
+
+	// A bad signature refuses the release
+	_, other, _ := ed25519.GenerateKey(nil)
+	files["/download/v1.2.0/SHA256SUMS.sig"] = ed25519.Sign(other, sums)
+	ioutil.WriteFile(exe, []byte("old cant"), 0755)
+	if _, err := su.Update(context.Background(), exe); err == nil {
+		t.Errorf("Expected error for bad signature")
+	}
+
+	// Unsigned releases are refused unless allowed
+	su.Version = ""
+	su.PublicKey = nil
+	if _, err := su.Update(context.Background(), exe); err == nil || !strings.Contains(err.Error(), "AllowUnsigned") {
+		t.Errorf("Expected error for unsigned release got %v", err)
+	}
+
+	// A checksum mismatch leaves the binary alone
+	su.AllowUnsigned = true
+	if _, err := su.Update(context.Background(), exe); err == nil {
+		t.Errorf("Expected error for checksum mismatch")
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "old cant" {
+		t.Errorf("Expected old binary kept got %s", b)
+	}
+	if entries, _ := ioutil.ReadDir(tmpdir); len(entries) != 1 {
+		t.Errorf("Expected temp files removed got %d files", len(entries))
+	}
+
+	// Releases without the binary fail
+	su.Binary = "cant-plan9-arm"
+	if _, err := su.Update(context.Background(), exe); err == nil {
+		t.Errorf("Expected error for missing binary")
+	}
+
+	if _, err := NewSelfUpdater(&UpdateConfig{PublicKey: "bad"}); err == nil {
+		t.Errorf("Expected error for bad public key")
+	}
+	if name := ReleaseBinary("windows", "amd64"); name != "cant-windows-amd64.exe" {
+		t.Errorf("Expected windows binary got %s", name)
+	}
+}
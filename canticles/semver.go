@@ -0,0 +1,279 @@
+package canticles
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Version is a semantic version, as tagged, e.g. v1.2.3 or
+// 2.0.0-rc.1.
+type Version struct {
+	Major, Minor, Patch int
+	// Pre is the pre-release, e.g. rc.1, empty for releases.
+	Pre string
+	// Original is the text the version was parsed from.
+	Original string
+}
+
+// ParseVersion parses s as a semantic version. A leading v and build
+// metadata are ignored, and a missing minor or patch is zero.
+func ParseVersion(s string) (*Version, error) {
+	v := &Version{Original: s}
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.Index(rest, "+"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		v.Pre = rest[i+1:]
+		rest = rest[:i]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 || rest == "" {
+		return nil, fmt.Errorf("%s is not a semantic version", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s is not a semantic version", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher
+// than o. Pre-releases are lower than their release.
+func (v *Version) Compare(o *Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		switch {
+		case d < 0:
+			return -1
+		case d > 0:
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+// comparePre compares dot separated pre-release identifiers, numeric
+// ones numerically and lower than alphanumeric ones.
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil && berr != nil:
+			return -1
+		case aerr != nil && berr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		case as[i] > bs[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// versionBound is one comparison of a Constraint, e.g. >= 1.2.0.
+type versionBound struct {
+	op      string
+	version *Version
+}
+
+func (b versionBound) check(v *Version) bool {
+	c := v.Compare(b.version)
+	switch b.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// A Constraint is a set of versions, written as comparisons separated
+// by commas or spaces which must all hold, e.g. ">=2.0, <3.0", with
+// alternatives separated by ||. Besides =, !=, >, >=, < and <= the
+// operators ^1.2.0 (>=1.2.0, <2.0.0) and ~1.2.0 (>=1.2.0, <1.3.0) are
+// understood, as are wildcards such as 1.2.x and *. A bare version is
+// an exact match.
+type Constraint struct {
+	text string
+	// alternatives are ORed, the bounds of each ANDed.
+	alternatives [][]versionBound
+}
+
+// ParseConstraint parses s as a Constraint.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{text: s}
+	for _, alt := range strings.Split(s, "||") {
+		var bounds []versionBound
+		fields := strings.FieldsFunc(alt, func(r rune) bool { return r == ',' || r == ' ' })
+		// Allow a space between operator and version, e.g. ">= 2.0"
+		for i := 0; i < len(fields); i++ {
+			if strings.Trim(fields[i], "<>=!^~") == "" && i+1 < len(fields) {
+				fields[i+1] = fields[i] + fields[i+1]
+				continue
+			}
+			b, err := parseBounds(fields[i])
+			if err != nil {
+				return nil, fmt.Errorf("cant parse constraint %q %s", s, err.Error())
+			}
+			bounds = append(bounds, b...)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("cant parse constraint %q, it has an empty alternative", s)
+		}
+		c.alternatives = append(c.alternatives, bounds)
+	}
+	return c, nil
+}
+
+// parseBounds returns the bounds of one comparison.
+func parseBounds(field string) ([]versionBound, error) {
+	op := field[:len(field)-len(strings.TrimLeft(field, "<>=!^~"))]
+	text := field[len(op):]
+	if text == "*" || text == "x" {
+		return nil, nil
+	}
+	// Wildcards, e.g. 1.2.x, are ranges like ~
+	wild := false
+	parts := strings.Split(strings.TrimPrefix(text, "v"), ".")
+	for i, part := range parts {
+		if part == "x" || part == "*" {
+			parts = parts[:i]
+			wild = true
+			break
+		}
+	}
+	v, err := ParseVersion(strings.Join(parts, "."))
+	if err != nil {
+		return nil, err
+	}
+	upper := func(major, minor int) versionBound {
+		return versionBound{"<", &Version{Major: major, Minor: minor}}
+	}
+	switch {
+	case wild && len(parts) == 1:
+		return []versionBound{{">=", v}, upper(v.Major+1, 0)}, nil
+	case wild:
+		return []versionBound{{">=", v}, upper(v.Major, v.Minor+1)}, nil
+	case op == "^":
+		// Below 1.0.0 minor versions may break compatibility
+		if v.Major == 0 {
+			return []versionBound{{">=", v}, upper(0, v.Minor+1)}, nil
+		}
+		return []versionBound{{">=", v}, upper(v.Major+1, 0)}, nil
+	case op == "~":
+		return []versionBound{{">=", v}, upper(v.Major, v.Minor+1)}, nil
+	case op == "" || op == "=" || op == "==":
+		return []versionBound{{"=", v}}, nil
+	case op == "!=" || op == ">" || op == ">=" || op == "<" || op == "<=":
+		return []versionBound{{op, v}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// Check returns true if v satisfies the constraint. Pre-releases only
+// satisfy alternatives naming a pre-release of the same version.
+func (c *Constraint) Check(v *Version) bool {
+	for _, bounds := range c.alternatives {
+		ok := true
+		pre := v.Pre == ""
+		for _, b := range bounds {
+			if !b.check(v) {
+				ok = false
+				break
+			}
+			if b.version.Pre != "" && b.version.Major == v.Major && b.version.Minor == v.Minor && b.version.Patch == v.Patch {
+				pre = true
+			}
+		}
+		if ok && pre {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Constraint) String() string {
+	return c.text
+}
+
+// A TagLister lists the tags of a repo.
+type TagLister interface {
+	// Tags returns the revision of each tag.
+	Tags(ctx context.Context) (map[string]string, error)
+}
+
+// SolveConstraints returns the highest of tags, a map of tag to
+// revision, whose version satisfies every one of constraints. Tags
+// which are not semantic versions are ignored.
+func SolveConstraints(tags map[string]string, constraints []string) (tag, rev string, err error) {
+	parsed := make([]*Constraint, len(constraints))
+	for i, constraint := range constraints {
+		if parsed[i], err = ParseConstraint(constraint); err != nil {
+			return "", "", err
+		}
+	}
+	var best *Version
+	for name := range tags {
+		v, err := ParseVersion(name)
+		if err != nil {
+			continue
+		}
+		ok := true
+		for _, c := range parsed {
+			ok = ok && c.Check(v)
+		}
+		// Break ties of equal versions, e.g. v1.0 and 1.0.0, by
+		// name so the choice is stable
+		if ok && (best == nil || v.Compare(best) > 0 || (v.Compare(best) == 0 && name < best.Original)) {
+			best = v
+		}
+	}
+	if best == nil {
+		return "", "", fmt.Errorf("none of %d tags satisfies %s", len(tags), strings.Join(constraints, " and "))
+	}
+	return best.Original, tags[best.Original], nil
+}
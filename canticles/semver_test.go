@@ -0,0 +1,156 @@
+package canticles
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s   string
+		v   string
+		err bool
+	}{
+		{s: "v1.2.3", v: "1.2.3"},
+		{s: "1.2", v: "1.2.0"},
+		{s: "v2", v: "2.0.0"},
+		{s: "1.0.0-rc.1+build.5", v: "1.0.0-rc.1"},
+		{s: "release-1", err: true},
+		{s: "1.2.3.4", err: true},
+		{s: "v", err: true},
+	}
+	for _, test := range tests {
+		v, err := ParseVersion(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error parsing %s got %s", test.s, v)
+			}
+			continue
+		}
+		if err != nil || v.String() != test.v {
+			t.Errorf("Expected %s parsed as %s got %v %v", test.s, test.v, v, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"0.9.0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10.0", "2.0.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := ParseVersion(ordered[i])
+		b, _ := ParseVersion(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+			t.Errorf("Expected %s lower than %s", a, b)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		miss       []string
+	}{
+		{"^1.2.0", []string{"1.2.0", "1.9.3"}, []string{"1.1.9", "2.0.0", "1.3.0-rc.1"}},
+		{"^0.2.1", []string{"0.2.1", "0.2.9"}, []string{"0.3.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{">=2.0, <3.0", []string{"2.0.0", "2.9.9"}, []string{"1.9.9", "3.0.0"}},
+		{">= 2.0 < 3.0", []string{"2.5.0"}, []string{"3.0.0"}},
+		{"1.2.x", []string{"1.2.0", "1.2.7"}, []string{"1.3.0"}},
+		{"1.x || >=3.1", []string{"1.0.0", "3.1.0"}, []string{"2.0.0", "3.0.0"}},
+		{"=1.0.0-rc.1", []string{"1.0.0-rc.1"}, []string{"1.0.0"}},
+		{">=1.0.0-rc.1", []string{"1.0.0-rc.2", "1.0.0"}, []string{"1.1.0-rc.1"}},
+		{"!=1.0.1", []string{"1.0.0"}, []string{"1.0.1"}},
+		{"*", []string{"0.0.1", "5.0.0"}, []string{"1.0.0-beta"}},
+	}
+	for _, test := range tests {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("Error parsing constraint %s: %s", test.constraint, err.Error())
+			continue
+		}
+		for _, s := range test.match {
+			if v, _ := ParseVersion(s); !c.Check(v) {
+				t.Errorf("Expected %s to satisfy %s", s, c)
+			}
+		}
+		for _, s := range test.miss {
+			if v, _ := ParseVersion(s); c.Check(v) {
+				t.Errorf("Expected %s not to satisfy %s", s, c)
+			}
+		}
+	}
+	for _, bad := range []string{"", "1.0 ||", "^x.y", "=>1.0"} {
+		if _, err := ParseConstraint(bad); err == nil {
+			t.Errorf("Expected error parsing constraint %q", bad)
+		}
+	}
+}
+
+var testTags = map[string]string{
+	"v1.0.0":   "a",
+	"v1.2.0":   "b",
+	"v1.3.0":   "c",
+	"v2.0.0":   "d",
+	"nightly":  "e",
+	"v2.1.0-b": "f",
+}
+
+func TestSolveConstraints(t *testing.T) {
+	tag, rev, err := SolveConstraints(testTags, []string{"^1.0.0", "<1.3.0"})
+	if err != nil || tag != "v1.2.0" || rev != "b" {
+		t.Errorf("Expected v1.2.0 b got %s %s %v", tag, rev, err)
+	}
+	tag, rev, err = SolveConstraints(testTags, []string{">=1.0"})
+	if err != nil || tag != "v2.0.0" || rev != "d" {
+		t.Errorf("Expected v2.0.0 d got %s %s %v", tag, rev, err)
+	}
+	if _, _, err := SolveConstraints(testTags, []string{"^1.0.0", ">=2.0"}); err == nil {
+		t.Errorf("Expected error solving conflicting constraints")
+	}
+}
+
+type taggedVCS struct {
+	*TestVCS
+	tags map[string]string
+}
+
+func (v *taggedVCS) Tags(ctx context.Context) (map[string]string, error) {
+	return v.tags, nil
+}
+
+func TestSourcesResolverConstraints(t *testing.T) {
+	v := &taggedVCS{&TestVCS{Rev: "a", Root: "dep.com/x"}, testTags}
+	resolver := &TestResolver{ResolvePaths: map[string]*TestVCSResolve{
+		"dep.com/x/pkg": {V: v},
+		"dep.com/x":     {V: v},
+	}}
+	deps := NewDependencies()
+	deps.AddDependency(NewDependency("dep.com/x/pkg"))
+	sr := &SourcesResolver{
+		Gopath:      "/gopath",
+		RootPath:    "/gopath/src/me.com/project",
+		Resolver:    resolver,
+		CDepReader:  &testCantDepReader{deps: []*CanticleDependency{{Root: "dep.com/x", Revision: "c", Constraint: "<1.3.0"}}},
+		Constraints: map[string]string{"dep.com/x": "^1.0.0"},
+	}
+	sources, err := sr.ResolveSources(context.Background(), deps)
+	if err != nil {
+		t.Fatalf("Error resolving sources: %s", err.Error())
+	}
+	source := sources.DepSource("dep.com/x")
+	if source.Resolved != "b" || source.ResolvedTag != "v1.2.0" {
+		t.Errorf("Expected dep.com/x resolved to v1.2.0 b got %s %s", source.ResolvedTag, source.Resolved)
+	}
+	cdeps, err := (&PreferLocalResolution{}).ResolveConflicts(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cdeps[0].Revision != "b" || cdeps[0].Constraint != "^1.0.0" {
+		t.Errorf("Expected constraint and resolved revision saved got %+v", cdeps[0])
+	}
+
+	sr.Constraints["dep.com/x"] = "^3.0"
+	if _, err := sr.ResolveSources(context.Background(), deps); err == nil {
+		t.Errorf("Expected error when no tag satisfies the constraints")
+	}
+}
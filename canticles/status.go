@@ -0,0 +1,227 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// DepStatus is the state on disk of a dependency of a project.
+type DepStatus struct {
+	Root string
+	// Revision is the Revision pinned in the Canticle file.
+	Revision string
+	// SourcePath is the SourcePath pinned in the Canticle file.
+	SourcePath string `json:",omitempty"`
+	// Commit is the revision Revision resolves to in the checkout,
+	// if it is e.g. a tag or branch.
+	Commit string `json:",omitempty"`
+	// OnDisk is the revision of the checkout in the gopath.
+	OnDisk string `json:",omitempty"`
+	// OnDiskSource is the source the checkout is fetched from.
+	OnDiskSource string `json:",omitempty"`
+	// Missing is true if the dependency is not in the gopath.
+	Missing bool `json:",omitempty"`
+	// Dirty is true if the checkout has uncommitted changes.
+	Dirty bool `json:",omitempty"`
+	// Ahead and Behind are the number of revisions the checkout
+	// has which Revision does not, and Revision has which the
+	// checkout does not.
+	Ahead  int `json:",omitempty"`
+	Behind int `json:",omitempty"`
+	// Err is why OnDisk could not be read.
+	Err string `json:",omitempty"`
+}
+
+// SourceMismatch returns true if the checkout is fetched from another
+// source than the pinned SourcePath, see NormalizeSource.
+func (ds *DepStatus) SourceMismatch() bool {
+	return ds.SourcePath != "" && ds.OnDiskSource != "" && NormalizeSource(ds.SourcePath) != NormalizeSource(ds.OnDiskSource)
+}
+
+// RevisionMismatch returns true if the checkout is not at the pinned
+// Revision, or the Commit it resolves to.
+func (ds *DepStatus) RevisionMismatch() bool {
+	if ds.Revision == "" {
+		return false
+	}
+	if ds.Commit != "" {
+		return ds.OnDisk != ds.Commit
+	}
+	return ds.OnDisk != ds.Revision
+}
+
+// Drifted returns true if the checkout is missing, unreadable, dirty,
+// fetched from another source or not at the pinned Revision.
+func (ds *DepStatus) Drifted() bool {
+	return ds.Missing || ds.Err != "" || ds.Dirty || ds.SourceMismatch() || ds.RevisionMismatch()
+}
+
+// String returns the status as cant status prints it.
+func (ds *DepStatus) String() string {
+	switch {
+	case ds.Missing:
+		return fmt.Sprintf("%s: missing, pinned at %s", ds.Root, ds.Revision)
+	case ds.Err != "":
+		return fmt.Sprintf("%s: unknown, %s", ds.Root, ds.Err)
+	case !ds.Drifted():
+		return fmt.Sprintf("%s: ok", ds.Root)
+	}
+	var states []string
+	if ds.RevisionMismatch() {
+		state := fmt.Sprintf("at %s, pinned at %s", ds.OnDisk, ds.Revision)
+		if ds.Ahead > 0 || ds.Behind > 0 {
+			state += fmt.Sprintf(", %d ahead %d behind", ds.Ahead, ds.Behind)
+		}
+		states = append(states, state)
+	}
+	if ds.Dirty {
+		states = append(states, "dirty")
+	}
+	if ds.SourceMismatch() {
+		states = append(states, fmt.Sprintf("fetched from %s, pinned to %s", ds.OnDiskSource, ds.SourcePath))
+	}
+	return fmt.Sprintf("%s: %s", ds.Root, strings.Join(states, ", "))
+}
+
+// MarshalJSON adds whether the dependency Drifted to its fields.
+func (ds *DepStatus) MarshalJSON() ([]byte, error) {
+	type depStatus DepStatus
+	return json.Marshal(&struct {
+		*depStatus
+		Drifted bool
+	}{(*depStatus)(ds), ds.Drifted()})
+}
+
+// DependencyStatuses returns the status of every dependency saved in
+// the Canticle file of the project at path.
+func DependencyStatuses(ctx context.Context, gopath, path string) ([]*DepStatus, error) {
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &LocalRepoResolver{LocalPath: gopath}
+	var statuses []*DepStatus
+	for _, cdep := range cdeps {
+		status := &DepStatus{Root: cdep.Root, Revision: cdep.Revision, SourcePath: cdep.SourcePath}
+		statuses = append(statuses, status)
+		if _, err := os.Stat(PackageSource(gopath, cdep.Root)); err != nil {
+			status.Missing = true
+			continue
+		}
+		v, err := resolver.ResolveRepo(ctx, cdep.Root, cdep)
+		if err == nil {
+			status.OnDisk, err = v.GetRev(ctx)
+		}
+		if err != nil {
+			status.Err = err.Error()
+			continue
+		}
+		if cdep.SourcePath != "" {
+			if status.OnDiskSource, err = v.GetSource(ctx); err != nil {
+				LogVerboseContext(ctx, "Cant read source of %s %s", cdep.Root, err.Error())
+			}
+		}
+		lv, ok := v.(*LocalVCS)
+		if !ok {
+			continue
+		}
+		if status.Dirty, err = lv.IsDirty(ctx); err != nil {
+			LogVerboseContext(ctx, "Cant read dirty state of %s %s", cdep.Root, err.Error())
+		}
+		if cdep.Revision != "" && status.OnDisk != cdep.Revision {
+			// The pinned revision may be a tag or branch, or
+			// not be fetched yet
+			if commit, err := lv.ResolveRev(ctx, cdep.Revision); err != nil {
+				LogVerboseContext(ctx, "Cant resolve %s of %s %s", cdep.Revision, cdep.Root, err.Error())
+			} else if commit != cdep.Revision {
+				status.Commit = commit
+			}
+		}
+		if status.RevisionMismatch() {
+			if status.Ahead, status.Behind, err = lv.AheadBehind(ctx, "", cdep.Revision); err != nil {
+				LogVerboseContext(ctx, "Cant compare %s to %s %s", cdep.Root, cdep.Revision, err.Error())
+			}
+		}
+	}
+	return statuses, nil
+}
+
+type Status struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Check   bool
+	JSON    bool
+}
+
+func NewStatus() *Status {
+	f := flag.NewFlagSet("status", flag.ExitOnError)
+	s := &Status{flags: f}
+	f.BoolVar(&s.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&s.Check, "check", false, "Only print dependencies which drifted, exiting 1 if any did")
+	f.BoolVar(&s.JSON, "json", false, "Print the statuses as JSON")
+	return s
+}
+
+var status = NewStatus()
+
+var StatusCommand = &Command{
+	Name:             "status",
+	UsageLine:        "status [-v] [-check] [-json]",
+	ShortDescription: "Compare the dependencies on disk to the Canticle file.",
+	LongDescription: `The status command prints, for each dependency pinned in the Canticle file of the current directory, whether its checkout in the GOPATH is at the pinned revision, at another revision or missing. Checkouts at another revision are reported with the number of revisions they are ahead of and behind the pinned revision, if it has been fetched. Checkouts with uncommitted changes are reported as dirty, and those fetched from another source than the pinned SourcePath as such.
+
+Specify -check to print only the dependencies which drifted from their pinned revision and exit with status 1 if any did, e.g. in a git hook, see cant hooks. Dirty checkouts and those fetched from another source drifted too.
+
+Specify -json to print the statuses as JSON, each with a Drifted field, e.g. for CI.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: status.flags,
+	Cmd:   status,
+}
+
+// Run the status command.
+func (s *Status) Run(ctx context.Context, args []string) {
+	if s.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	statuses, err := DependencyStatuses(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	drifted := false
+	printed := []*DepStatus{}
+	for _, ds := range statuses {
+		drifted = drifted || ds.Drifted()
+		if !s.Check || ds.Drifted() {
+			printed = append(printed, ds)
+		}
+	}
+	if s.JSON {
+		b, err := json.MarshalIndent(printed, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, ds := range printed {
+			fmt.Println(ds.String())
+		}
+	}
+	if s.Check && drifted {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,147 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDepStatusDrifted(t *testing.T) {
+	tests := []struct {
+		status  *DepStatus
+		drifted bool
+	}{
+		{&DepStatus{Root: "a", Revision: "1", OnDisk: "1"}, false},
+		{&DepStatus{Root: "a", Revision: "1", OnDisk: "2"}, true},
+		{&DepStatus{Root: "a", Revision: "1", Missing: true}, true},
+		{&DepStatus{Root: "a", Revision: "1", Err: "no vcs"}, true},
+		{&DepStatus{Root: "a", OnDisk: "2"}, false},
+		{&DepStatus{Root: "a", Revision: "1", OnDisk: "1", Dirty: true}, true},
+		{&DepStatus{Root: "a", Revision: "1", OnDisk: "1", SourcePath: "git@a.com:a.git", OnDiskSource: "https://a.com/a"}, false},
+		{&DepStatus{Root: "a", Revision: "1", OnDisk: "1", SourcePath: "git@a.com:a.git", OnDiskSource: "https://b.com/a"}, true},
+	}
+	for _, test := range tests {
+		if drifted := test.status.Drifted(); drifted != test.drifted {
+			t.Errorf("Expected %s drifted %v got %v", test.status.String(), test.drifted, drifted)
+		}
+	}
+}
+
+func TestDependencyStatuses(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dep := PackageSource(gopath, "dep.com/x")
+	project := PackageSource(gopath, "me.com/project")
+	for _, dir := range []string{dep, project} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		cmd.Dir = dep
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("remote", "add", "origin", "https://dep.com/x.git")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	pinned := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	git("commit", "-q", "--allow-empty", "-m", "third")
+	head := git("rev-parse", "HEAD")
+	if err := ioutil.WriteFile(filepath.Join(dep, "x.go"), []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cdeps := []*CanticleDependency{
+		{Root: "dep.com/x", Revision: pinned, SourcePath: "git@other.com:x.git"},
+		{Root: "missing.com/y", Revision: "a"},
+	}
+	b, _ := json.Marshal(cdeps)
+	if err := ioutil.WriteFile(DependencyFile(project), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := DependencyStatuses(context.Background(), gopath, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses got %d", len(statuses))
+	}
+	ds := statuses[0]
+	if ds.OnDisk != head || !ds.Dirty || ds.Ahead != 2 || ds.Behind != 0 || !ds.SourceMismatch() {
+		t.Errorf("Expected dep.com/x dirty, 2 ahead and from another source got %+v", ds)
+	}
+	expected := "dep.com/x: at " + head + ", pinned at " + pinned + ", 2 ahead 0 behind, dirty, fetched from https://dep.com/x.git, pinned to git@other.com:x.git"
+	if ds.String() != expected {
+		t.Errorf("Expected status %s got %s", expected, ds.String())
+	}
+	if !statuses[1].Missing {
+		t.Errorf("Expected missing.com/y missing got %+v", statuses[1])
+	}
+	b, err = json.Marshal(ds)
+	if err != nil || !strings.Contains(string(b), `"Drifted":true`) || !strings.Contains(string(b), `"Ahead":2`) {
+		t.Errorf("Expected JSON status with Drifted got %s %v", string(b), err)
+	}
+}
+
+func TestDependencyStatusesTag(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dep := PackageSource(gopath, "dep.com/x")
+	project := PackageSource(gopath, "me.com/project")
+	for _, dir := range []string{dep, project} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		cmd.Dir = dep
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	tagged := git("rev-parse", "HEAD")
+	git("tag", "-a", "-m", "release", "v1.0.0")
+	b, _ := json.Marshal([]*CanticleDependency{{Root: "dep.com/x", Revision: "v1.0.0"}})
+	if err := ioutil.WriteFile(DependencyFile(project), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := DependencyStatuses(context.Background(), gopath, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := statuses[0]; ds.Drifted() || ds.Commit != tagged {
+		t.Errorf("Expected dep.com/x at its tag not drifted got %+v", ds)
+	}
+
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	statuses, err = DependencyStatuses(context.Background(), gopath, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := statuses[0]; !ds.Drifted() || ds.Ahead != 1 || ds.Behind != 0 {
+		t.Errorf("Expected dep.com/x drifted 1 ahead of its tag got %+v", ds)
+	}
+}
@@ -0,0 +1,122 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// SystemDeps are the system libraries a package requires through
+// cgo.
+type SystemDeps struct {
+	// PkgConfig is the list of pkg-config packages.
+	PkgConfig []string
+	// Libs are the libraries linked with -l.
+	Libs []string
+}
+
+// SystemDeps returns the system libraries this package requires
+// through cgo or nil if it requires none.
+func (p *Package) SystemDeps() *SystemDeps {
+	sd := &SystemDeps{PkgConfig: p.CgoPkgConfig}
+	for _, flag := range p.CgoLDFLAGS {
+		if strings.HasPrefix(flag, "-l") && len(flag) > 2 {
+			sd.Libs = append(sd.Libs, strings.TrimPrefix(flag, "-l"))
+		}
+	}
+	if len(sd.PkgConfig) == 0 && len(sd.Libs) == 0 {
+		return nil
+	}
+	return sd
+}
+
+// SystemDeps returns the union of the system deps of every package in
+// this source.
+func (d *DependencySource) SystemDeps() (pkgConfig, libs []string) {
+	pcs, ls := NewStringSet(), NewStringSet()
+	for _, dep := range d.Deps {
+		pcs.Union(dep.PkgConfig)
+		ls.Union(dep.CgoLibs)
+	}
+	if pcs.Size() > 0 {
+		pkgConfig = pcs.Array()
+	}
+	if ls.Size() > 0 {
+		libs = ls.Array()
+	}
+	return pkgConfig, libs
+}
+
+type SysDeps struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Libs    bool
+}
+
+func NewSysDeps() *SysDeps {
+	f := flag.NewFlagSet("sysdeps", flag.ExitOnError)
+	s := &SysDeps{flags: f}
+	f.BoolVar(&s.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&s.Libs, "libs", false, "Also list libraries linked directly with -l")
+	return s
+}
+
+var sysdeps = NewSysDeps()
+
+var SysDepsCommand = &Command{
+	Name:             "sysdeps",
+	UsageLine:        "sysdeps [-v] [-libs] [package]",
+	ShortDescription: "List the system libraries required by dependencies.",
+	LongDescription: `The sysdeps command will list the pkg-config packages required through cgo by the dependencies saved in a packages Canticle file. Run cant save to update this information.
+
+Specify -v to print out a verbose set of operations instead of just errors.
+
+Specify -libs to also list libraries linked directly with -l, these are prefixed with -l.`,
+	Flags: sysdeps.flags,
+	Cmd:   sysdeps,
+}
+
+// Run the sysdeps command.
+func (s *SysDeps) Run(ctx context.Context, args []string) {
+	if s.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader := &DepReader{Gopath: gopath}
+	for _, path := range ParseCmdLinePackages(s.flags.Args()) {
+		pkg, err := PackageName(gopath, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cdeps, err := reader.CanticleDependencies(pkg)
+		if err != nil {
+			log.Fatalf("cant read Canticle file for %s %s", pkg, err.Error())
+		}
+		for _, line := range s.SystemDeps(cdeps) {
+			fmt.Fprintln(os.Stdout, line)
+		}
+	}
+}
+
+// SystemDeps returns the sorted list of system deps for cdeps.
+func (s *SysDeps) SystemDeps(cdeps []*CanticleDependency) []string {
+	deps := NewStringSet()
+	for _, cdep := range cdeps {
+		deps.Add(cdep.PkgConfig...)
+		if !s.Libs {
+			continue
+		}
+		for _, lib := range cdep.CgoLibs {
+			deps.Add("-l" + lib)
+		}
+	}
+	return deps.Array()
+}
@@ -0,0 +1,50 @@
+package canticles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackageSystemDeps(t *testing.T) {
+	pkg := &Package{}
+	if sd := pkg.SystemDeps(); sd != nil {
+		t.Errorf("Package without cgo returned system deps %+v", sd)
+	}
+	pkg = &Package{
+		CgoPkgConfig: []string{"libgit2"},
+		CgoLDFLAGS:   []string{"-L/usr/local/lib", "-lssl", "-l"},
+	}
+	expected := &SystemDeps{PkgConfig: []string{"libgit2"}, Libs: []string{"ssl"}}
+	if sd := pkg.SystemDeps(); !reflect.DeepEqual(expected, sd) {
+		t.Errorf("Expected system deps %+v got %+v", expected, sd)
+	}
+}
+
+func TestSysDeps(t *testing.T) {
+	cdeps := []*CanticleDependency{
+		{Root: "a.com/git", PkgConfig: []string{"libgit2", "zlib"}},
+		{Root: "b.com/ssl", PkgConfig: []string{"zlib"}, CgoLibs: []string{"ssl"}},
+		{Root: "c.com/pure"},
+	}
+	s := NewSysDeps()
+	expected := []string{"libgit2", "zlib"}
+	if deps := s.SystemDeps(cdeps); !reflect.DeepEqual(expected, deps) {
+		t.Errorf("Expected sysdeps %v got %v", expected, deps)
+	}
+	s.Libs = true
+	expected = []string{"-lssl", "libgit2", "zlib"}
+	if deps := s.SystemDeps(cdeps); !reflect.DeepEqual(expected, deps) {
+		t.Errorf("Expected sysdeps with libs %v got %v", expected, deps)
+	}
+
+	source := NewDependencySource("b.com/ssl")
+	dep := NewDependency("b.com/ssl/tls")
+	dep.PkgConfig.Add("zlib")
+	dep.CgoLibs.Add("ssl")
+	source.Deps.AddDependency(dep)
+	source.Deps.AddDependency(NewDependency("b.com/ssl"))
+	pcs, libs := source.SystemDeps()
+	if !reflect.DeepEqual([]string{"zlib"}, pcs) || !reflect.DeepEqual([]string{"ssl"}, libs) {
+		t.Errorf("Unexpected source system deps %v %v", pcs, libs)
+	}
+}
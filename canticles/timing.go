@@ -0,0 +1,98 @@
+package canticles
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The phases Spans are reported for.
+const (
+	PhaseFetch   = "fetch"
+	PhaseResolve = "resolve"
+	PhaseGoList  = "go-list"
+	PhaseIO      = "io"
+)
+
+// A Span is a timed phase of an operation on Name, e.g. the fetch of a
+// dependency root.
+type Span struct {
+	Phase    string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Instrument, if non nil, is called with each finished Span. It may
+// be called concurrently.
+var Instrument func(*Span)
+
+// StartSpan starts timing phase of name. The returned func finishes
+// the span and reports it to Instrument.
+func StartSpan(phase, name string) func() {
+	instrument := Instrument
+	if instrument == nil {
+		return func() {}
+	}
+	span := &Span{Phase: phase, Name: name, Start: time.Now()}
+	return func() {
+		span.Duration = time.Since(span.Start)
+		instrument(span)
+	}
+}
+
+// Timings collects Spans, its Record method may be used as the
+// Instrument.
+type Timings struct {
+	sync.Mutex
+	Spans []*Span
+}
+
+// Record adds span to the timings.
+func (t *Timings) Record(span *Span) {
+	t.Lock()
+	t.Spans = append(t.Spans, span)
+	t.Unlock()
+}
+
+// Summary returns a line for each phase, in order of total duration,
+// with its number of spans, total and slowest span.
+func (t *Timings) Summary() []string {
+	t.Lock()
+	defer t.Unlock()
+	phases := make(map[string]*phaseTiming)
+	var order byTotal
+	for _, span := range t.Spans {
+		p := phases[span.Phase]
+		if p == nil {
+			p = &phaseTiming{name: span.Phase}
+			phases[span.Phase] = p
+			order = append(order, p)
+		}
+		p.count++
+		p.total += span.Duration
+		if p.slowest == nil || span.Duration > p.slowest.Duration {
+			p.slowest = span
+		}
+	}
+	sort.Stable(order)
+	var lines []string
+	for _, p := range order {
+		lines = append(lines, fmt.Sprintf("%-8s %4d spans %12s total, slowest %s %s", p.name, p.count, p.total, p.slowest.Name, p.slowest.Duration))
+	}
+	return lines
+}
+
+type phaseTiming struct {
+	name    string
+	count   int
+	total   time.Duration
+	slowest *Span
+}
+
+type byTotal []*phaseTiming
+
+func (bt byTotal) Len() int           { return len(bt) }
+func (bt byTotal) Less(i, j int) bool { return bt[i].total > bt[j].total }
+func (bt byTotal) Swap(i, j int)      { bt[i], bt[j] = bt[j], bt[i] }
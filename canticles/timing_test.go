@@ -0,0 +1,46 @@
+package canticles
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartSpan(t *testing.T) {
+	defer func() { Instrument = nil }()
+	StartSpan(PhaseIO, "noop")()
+
+	timings := &Timings{}
+	Instrument = timings.Record
+	done := StartSpan(PhaseFetch, "golang.org/x/tools")
+	time.Sleep(time.Millisecond)
+	done()
+	StartSpan(PhaseIO, "Canticle")()
+	if len(timings.Spans) != 2 {
+		t.Fatalf("Expected 2 spans got %d", len(timings.Spans))
+	}
+	span := timings.Spans[0]
+	if span.Phase != PhaseFetch || span.Name != "golang.org/x/tools" {
+		t.Errorf("Expected fetch span for golang.org/x/tools got %+v", span)
+	}
+	if span.Duration < time.Millisecond {
+		t.Errorf("Expected duration of at least 1ms got %s", span.Duration)
+	}
+}
+
+func TestTimingsSummary(t *testing.T) {
+	timings := &Timings{}
+	timings.Record(&Span{Phase: PhaseIO, Name: "a", Duration: time.Second})
+	timings.Record(&Span{Phase: PhaseFetch, Name: "b", Duration: 2 * time.Second})
+	timings.Record(&Span{Phase: PhaseFetch, Name: "c", Duration: 3 * time.Second})
+	lines := timings.Summary()
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines got %v", lines)
+	}
+	if !strings.HasPrefix(lines[0], PhaseFetch) || !strings.Contains(lines[0], "2 spans") || !strings.Contains(lines[0], "slowest c 3s") {
+		t.Errorf("Expected fetch summary first got %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], PhaseIO) {
+		t.Errorf("Expected io summary second got %s", lines[1])
+	}
+}
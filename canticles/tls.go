@@ -0,0 +1,226 @@
+package canticles
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostTLS are the TLS settings for fetching from a host.
+type HostTLS struct {
+	// CAFile is a PEM bundle of CAs trusted for the host in
+	// addition to the system and TLSConfig CAs.
+	CAFile string `json:",omitempty"`
+	// Insecure disables verifying the certificate of the host.
+	Insecure bool `json:",omitempty"`
+}
+
+// TLSConfig configures the TLS used for go get style meta tag
+// discovery and, through git configuration, https fetches. Other VCSs
+// use their own configuration.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the
+	// systems for all hosts.
+	CAFile string `json:",omitempty"`
+	// Hosts are the per host settings by host name.
+	Hosts map[string]*HostTLS `json:",omitempty"`
+}
+
+// TLS is the TLS configuration set by cant -cafile and -insecure.
+var TLS = &TLSConfig{}
+
+// Host returns the settings for host, adding them if not present.
+func (tc *TLSConfig) Host(host string) *HostTLS {
+	if tc.Hosts == nil {
+		tc.Hosts = make(map[string]*HostTLS)
+	}
+	if tc.Hosts[host] == nil {
+		tc.Hosts[host] = &HostTLS{}
+	}
+	return tc.Hosts[host]
+}
+
+// Empty returns true if tc changes nothing.
+func (tc *TLSConfig) Empty() bool {
+	return tc == nil || (tc.CAFile == "" && len(tc.Hosts) == 0)
+}
+
+// Merge returns a config with the settings of tc overriding those of
+// other. Relative CAFiles in other are relative to dir.
+func (tc *TLSConfig) Merge(other *TLSConfig, dir string) *TLSConfig {
+	rel := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+	merged := &TLSConfig{CAFile: tc.CAFile}
+	if other != nil {
+		if merged.CAFile == "" {
+			merged.CAFile = rel(other.CAFile)
+		}
+		for host, ht := range other.Hosts {
+			*merged.Host(host) = HostTLS{CAFile: rel(ht.CAFile), Insecure: ht.Insecure}
+		}
+	}
+	for host, ht := range tc.Hosts {
+		h := merged.Host(host)
+		if ht.CAFile != "" {
+			h.CAFile = ht.CAFile
+		}
+		h.Insecure = h.Insecure || ht.Insecure
+	}
+	return merged
+}
+
+// ClientConfig returns the tls.Config for connecting to host.
+func (tc *TLSConfig) ClientConfig(host string) (*tls.Config, error) {
+	ht := tc.Hosts[host]
+	if ht == nil {
+		ht = &HostTLS{}
+	}
+	config := &tls.Config{InsecureSkipVerify: ht.Insecure}
+	if tc.CAFile == "" && ht.CAFile == "" {
+		return config, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range []string{tc.CAFile, ht.CAFile} {
+		if file == "" {
+			continue
+		}
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cant read CA file %s", err.Error())
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cant read CA file %s, no PEM certificates found", file)
+		}
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// hostTransport uses a per host transport if one is present.
+type hostTransport struct {
+	def   http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (ht *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt, ok := ht.hosts[req.URL.Hostname()]; ok {
+		return rt.RoundTrip(req)
+	}
+	return ht.def.RoundTrip(req)
+}
+
+// RoundTripper returns an http.RoundTripper using the TLS settings
+// for each host.
+func (tc *TLSConfig) RoundTripper() (http.RoundTripper, error) {
+	return tc.roundTripper(HTTP)
+}
+
+// roundTripper is RoundTripper with transports using the settings of
+// hc.
+func (tc *TLSConfig) roundTripper(hc *HTTPConfig) (http.RoundTripper, error) {
+	newTransport := func(host string) (*http.Transport, error) {
+		config, err := tc.ClientConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		t := hc.Transport()
+		t.TLSClientConfig = config
+		return t, nil
+	}
+	def, err := newTransport("")
+	if err != nil {
+		return nil, err
+	}
+	rt := &hostTransport{def: def, hosts: make(map[string]http.RoundTripper, len(tc.Hosts))}
+	for host := range tc.Hosts {
+		if rt.hosts[host], err = newTransport(host); err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+// GitConfig returns the sorted git configuration, as key=value, for
+// the settings of tc.
+func (tc *TLSConfig) GitConfig() []string {
+	if tc.Empty() {
+		return nil
+	}
+	var config []string
+	if tc.CAFile != "" {
+		config = append(config, "http.sslCAInfo="+tc.CAFile)
+	}
+	for host, ht := range tc.Hosts {
+		prefix := "http.https://" + host + "/."
+		if ht.CAFile != "" {
+			config = append(config, prefix+"sslCAInfo="+ht.CAFile)
+		}
+		if ht.Insecure {
+			config = append(config, prefix+"sslVerify=false")
+		}
+	}
+	sort.Strings(config)
+	return config
+}
+
+// GitConfigEnv returns the GIT_CONFIG_COUNT enviroment variables, as
+// KEY=VALUE, passing the git configuration config, as key=value, to
+// git after any already passed in the enviroment.
+func GitConfigEnv(config []string) ([]string, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	var env []string
+	for _, kv := range config {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("git config %q must be key=value", kv)
+		}
+		env = append(env, "GIT_CONFIG_KEY_"+strconv.Itoa(count)+"="+parts[0], "GIT_CONFIG_VALUE_"+strconv.Itoa(count)+"="+parts[1])
+		count++
+	}
+	return append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count)), nil
+}
+
+// InsecureHosts is a flag.Value marking each host set as Insecure in
+// a TLSConfig.
+type InsecureHosts struct {
+	*TLSConfig
+}
+
+func (ih InsecureHosts) String() string {
+	if ih.TLSConfig == nil {
+		return ""
+	}
+	var hosts []string
+	for host, ht := range ih.Hosts {
+		if ht.Insecure {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
+
+func (ih InsecureHosts) Set(host string) error {
+	if host == "" || strings.ContainsAny(host, "/*") {
+		return fmt.Errorf("insecure host %q must be a single host name", host)
+	}
+	ih.Host(host).Insecure = true
+	return nil
+}
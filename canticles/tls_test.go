@@ -0,0 +1,102 @@
+package canticles
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTLSConfigRoundTripper(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	insecure := &TLSConfig{}
+	if err := (InsecureHosts{insecure}).Set("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		config *TLSConfig
+		ok     bool
+	}{
+		{&TLSConfig{}, false},
+		{&TLSConfig{CAFile: caFile}, true},
+		{&TLSConfig{Hosts: map[string]*HostTLS{"127.0.0.1": {CAFile: caFile}}}, true},
+		{&TLSConfig{Hosts: map[string]*HostTLS{"other.com": {CAFile: caFile}}}, false},
+		{insecure, true},
+	}
+	for i, c := range cases {
+		rt, err := c.config.RoundTripper()
+		if err != nil {
+			t.Fatalf("Error creating round tripper: %s", err.Error())
+		}
+		resp, err := (&http.Client{Transport: rt}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("Case %d expected success %v got err %v", i, c.ok, err)
+		}
+	}
+	if _, err := (&TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}).RoundTripper(); err == nil {
+		t.Errorf("Expected error for missing CA file")
+	}
+}
+
+func TestTLSConfigMerge(t *testing.T) {
+	flags := &TLSConfig{Hosts: map[string]*HostTLS{"a.com": {Insecure: true}}}
+	project := &TLSConfig{
+		CAFile: "ca.pem",
+		Hosts:  map[string]*HostTLS{"a.com": {CAFile: "a.pem"}, "b.com": {CAFile: "/b.pem"}},
+	}
+	merged := flags.Merge(project, "/proj")
+	expected := []string{
+		"http.https://a.com/.sslCAInfo=/proj/a.pem",
+		"http.https://a.com/.sslVerify=false",
+		"http.https://b.com/.sslCAInfo=/b.pem",
+		"http.sslCAInfo=/proj/ca.pem",
+	}
+	if config := merged.GitConfig(); !reflect.DeepEqual(expected, config) {
+		t.Errorf("Expected git config %v got %v", expected, config)
+	}
+	if (InsecureHosts{merged}).String() != "a.com" {
+		t.Errorf("Expected a.com to be insecure got %s", InsecureHosts{merged}.String())
+	}
+	if err := (InsecureHosts{merged}).Set("*"); err == nil {
+		t.Errorf("Expected error setting wildcard insecure host")
+	}
+}
+
+func TestGitConfigEnv(t *testing.T) {
+	defer os.Setenv("GIT_CONFIG_COUNT", os.Getenv("GIT_CONFIG_COUNT"))
+	os.Setenv("GIT_CONFIG_COUNT", "1")
+	env, err := GitConfigEnv([]string{"http.sslVerify=false", "core.hooksPath=/dev/null"})
+	if err != nil {
+		t.Fatalf("Error creating git config env: %s", err.Error())
+	}
+	expected := []string{
+		"GIT_CONFIG_KEY_1=http.sslVerify", "GIT_CONFIG_VALUE_1=false",
+		"GIT_CONFIG_KEY_2=core.hooksPath", "GIT_CONFIG_VALUE_2=/dev/null",
+		"GIT_CONFIG_COUNT=3",
+	}
+	if !reflect.DeepEqual(expected, env) {
+		t.Errorf("Expected git config env %v got %v", expected, env)
+	}
+	if _, err := GitConfigEnv([]string{"http.sslVerify"}); err == nil {
+		t.Errorf("Expected error for git config without a value")
+	}
+}
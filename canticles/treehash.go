@@ -0,0 +1,261 @@
+package canticles
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TreeHashPrefix identifies the algorithm of a TreeHash.
+const TreeHashPrefix = "sha256:"
+
+// VCSDirs are the metadata directories of version control systems
+// which are not part of a dependencies tree.
+var VCSDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".bzr": true,
+	".svn": true,
+	// ArchiveDir records the source of unpacked archives
+	ArchiveDir: true,
+}
+
+// TreeHash returns a deterministic hash of the files under dir,
+// excluding VCSDirs. Each files slash separated path, executable bit
+// and content hash are hashed in lexical order. Symlinks are hashed by
+// their target. If dir is the root of a git repo the files committed
+// at its HEAD are hashed instead of those on disk, see gitTreeHash, so
+// untracked and ignored files and line ending conversions of a
+// checkout do not change the hash.
+func TreeHash(ctx context.Context, dir string) (string, error) {
+	defer StartSpan(PhaseIO, dir)()
+	if isGitRoot(ctx, dir) {
+		return gitTreeHash(ctx, dir)
+	}
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if VCSDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var sum string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sum = "link:" + filepath.ToSlash(target)
+		case info.Mode().IsRegular():
+			if sum, err = fileHash(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%t\x00%s\n", filepath.ToSlash(rel), info.Mode()&0111 != 0, sum)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return TreeHashPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isGitRoot returns true if dir is the top level of a git repo.
+func isGitRoot(ctx context.Context, dir string) bool {
+	if _, err := os.Lstat(filepath.Join(dir, ".git")); err != nil {
+		return false
+	}
+	out, err := command(ctx, dir, "git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return false
+	}
+	top, err := filepath.EvalSymlinks(strings.TrimSpace(string(out)))
+	if err != nil {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	return err == nil && top == real
+}
+
+// gitTreeHash returns the TreeHash of the files committed at the HEAD
+// of the git repo at dir, as listed by git ls-tree and read with git
+// cat-file. Files in VCSDirs and submodules are left out.
+func gitTreeHash(ctx context.Context, dir string) (string, error) {
+	out, err := command(ctx, dir, "git", "ls-tree", "-r", "-z", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("cant list the files of %s %s", dir, err.Error())
+	}
+	type blob struct {
+		path, object string
+		exec, link   bool
+	}
+	var blobs []blob
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		tab := strings.Index(entry, "\t")
+		fields := strings.Fields(entry[:tab+1])
+		if tab < 0 || len(fields) != 3 || fields[1] != "blob" || inVCSDir(entry[tab+1:]) {
+			continue
+		}
+		blobs = append(blobs, blob{
+			path:   entry[tab+1:],
+			object: fields[2],
+			exec:   fields[0] == "100755",
+			link:   fields[0] == "120000",
+		})
+	}
+	paths := make([]string, len(blobs))
+	byPath := make(map[string]blob, len(blobs))
+	for i, b := range blobs {
+		paths[i] = b.path
+		byPath[b.path] = b
+	}
+	sort.Sort(pathsByWalkOrder(paths))
+
+	cat := command(ctx, dir, "git", "cat-file", "--batch")
+	in, err := cat.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cat.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cat.Start(); err != nil {
+		return "", err
+	}
+	go func() {
+		for _, p := range paths {
+			fmt.Fprintln(in, byPath[p].object)
+		}
+		in.Close()
+	}()
+	r := bufio.NewReader(stdout)
+	h := sha256.New()
+	for _, p := range paths {
+		b := byPath[p]
+		sum, err := readBlobHash(r, b.link)
+		if err != nil {
+			cat.Process.Kill()
+			cat.Wait()
+			return "", fmt.Errorf("cant read %s of %s %s", p, dir, err.Error())
+		}
+		fmt.Fprintf(h, "%s\x00%t\x00%s\n", p, b.exec, sum)
+	}
+	if err := cat.Wait(); err != nil {
+		return "", fmt.Errorf("cant read the files of %s %s", dir, err.Error())
+	}
+	return TreeHashPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readBlobHash reads the next blob output by git cat-file --batch from
+// r and returns the hash of its content, or its content prefixed with
+// link: if it is a symlink.
+func readBlobHash(r *bufio.Reader, link bool) (string, error) {
+	// <object> SP <type> SP <size> LF <contents> LF
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("unexpected object %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	content := io.LimitReader(r, size)
+	var sum string
+	if link {
+		target, err := ioutil.ReadAll(content)
+		if err != nil {
+			return "", err
+		}
+		sum = "link:" + string(target)
+	} else {
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			return "", err
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	_, err = r.Discard(1)
+	return sum, err
+}
+
+// inVCSDir returns true if the slash separated path is in one of
+// VCSDirs.
+func inVCSDir(p string) bool {
+	for _, dir := range strings.Split(p, "/") {
+		if VCSDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsByWalkOrder sorts slash separated paths in the order
+// filepath.Walk visits them, by name within each directory.
+type pathsByWalkOrder []string
+
+func (p pathsByWalkOrder) Len() int      { return len(p) }
+func (p pathsByWalkOrder) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pathsByWalkOrder) Less(i, j int) bool {
+	a, b := strings.Split(p[i], "/"), strings.Split(p[j], "/")
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyTreeHash returns an error if cdep records a TreeHash which
+// does not match its tree in gopath.
+func VerifyTreeHash(ctx context.Context, gopath string, cdep *CanticleDependency) error {
+	if cdep.TreeHash == "" {
+		return nil
+	}
+	hash, err := TreeHash(ctx, PackageSource(gopath, cdep.Root))
+	if err != nil {
+		return fmt.Errorf("cant hash %s %s", cdep.Root, err.Error())
+	}
+	if hash != cdep.TreeHash {
+		return &IntegrityError{Root: cdep.Root, Revision: cdep.Revision, Saved: cdep.TreeHash, Got: hash}
+	}
+	return nil
+}
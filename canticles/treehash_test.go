@@ -0,0 +1,128 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTreeHash(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := PackageSource(testHome, "dep.com/x")
+	files := map[string]string{
+		"a.go":       "package x\n",
+		"sub/b.go":   "package sub\n",
+		".git/HEAD":  "ref: refs/heads/master\n",
+		".hg/branch": "default\n",
+	}
+	for name, src := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := TreeHash(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Error hashing tree: %s", err.Error())
+	}
+	if !strings.HasPrefix(hash, TreeHashPrefix) {
+		t.Errorf("Expected hash prefixed with %s got %s", TreeHashPrefix, hash)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := TreeHash(context.Background(), dir); err != nil || again != hash {
+		t.Errorf("Expected VCS metadata to not change the hash got %s %v", again, err)
+	}
+
+	cdep := &CanticleDependency{Root: "dep.com/x", TreeHash: hash}
+	if err := VerifyTreeHash(context.Background(), testHome, cdep); err != nil {
+		t.Errorf("Expected unchanged tree to verify got %s", err.Error())
+	}
+	if err := os.Chmod(filepath.Join(dir, "a.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTreeHash(context.Background(), testHome, cdep); err == nil {
+		t.Errorf("Expected changing the executable bit to fail verification")
+	}
+	if err := os.Chmod(filepath.Join(dir, "a.go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b.go"), []byte("package tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTreeHash(context.Background(), testHome, cdep); err == nil {
+		t.Errorf("Expected changed file to fail verification")
+	}
+	if err := VerifyTreeHash(context.Background(), testHome, &CanticleDependency{Root: "dep.com/x"}); err != nil {
+		t.Errorf("Expected dep without a hash to not be verified got %s", err.Error())
+	}
+}
+
+func TestTreeHashGit(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	dir := filepath.Join(testHome, "repo")
+	plain := filepath.Join(testHome, "plain")
+	files := map[string]string{
+		"a.go":       "package x\n",
+		"a/b.go":     "package a\n",
+		"run.sh":     "#!/bin/sh\n",
+		".gitignore": "*.log\n",
+	}
+	for _, root := range []string{dir, plain} {
+		for name, src := range files {
+			p := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			mode := os.FileMode(0644)
+			if strings.HasSuffix(name, ".sh") {
+				mode = 0755
+			}
+			if err := ioutil.WriteFile(p, []byte(src), mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-q", "-m", "test"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+	hash, err := TreeHash(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Error hashing repo: %s", err.Error())
+	}
+	if expected, err := TreeHash(context.Background(), plain); err != nil || hash != expected {
+		t.Errorf("Expected repo to hash as its files %s got %s %v", expected, hash, err)
+	}
+	for name, src := range map[string]string{"untracked.go": "package x\n", "debug.log": "ignored\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if again, err := TreeHash(context.Background(), dir); err != nil || again != hash {
+		t.Errorf("Expected untracked and ignored files to not change the hash got %s %v", again, err)
+	}
+}
@@ -0,0 +1,308 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReachablePackages returns every package of deps imported, directly
+// or not, by the packages of from, including them. Vendored import
+// paths are returned as the import path they vendor.
+func ReachablePackages(ctx context.Context, deps Dependencies, from []string) (StringSet, error) {
+	reachable := NewStringSet()
+	reader := func(ctx context.Context, pkg string) ([]string, error) {
+		if pkg == whyStart {
+			return from, nil
+		}
+		if dep := deps[pkg]; dep != nil {
+			return dep.Imports.Array(), nil
+		}
+		return nil, nil
+	}
+	handler := func(ctx context.Context, pkg string) error {
+		if pkg != whyStart {
+			reachable.Add(unvendoredPath(pkg))
+		}
+		return nil
+	}
+	dw := NewDependencyWalker(reader, handler)
+	if err := dw.TraverseDependencies(ctx, whyStart); err != nil {
+		return nil, err
+	}
+	return reachable, nil
+}
+
+// unvendoredPath returns the import path vendored by importPath, or
+// importPath if it is not in a vendor directory.
+func unvendoredPath(importPath string) string {
+	if strings.HasPrefix(importPath, "vendor/") {
+		return importPath[len("vendor/"):]
+	}
+	if i := strings.LastIndex(importPath, "/vendor/"); i >= 0 {
+		return importPath[i+len("/vendor/"):]
+	}
+	return importPath
+}
+
+// UnusedPackages returns the import paths of the directories under
+// the roots in src holding go packages none of which are reachable.
+// Only the top most directory of each unused tree is returned, the
+// root itself if none of its packages are reachable. Hidden and
+// testdata directories are not looked in.
+func UnusedPackages(src string, roots []string, reachable StringSet) ([]string, error) {
+	var unused []string
+	for _, root := range roots {
+		dirs, err := packageDirs(src, root)
+		if err != nil {
+			return nil, err
+		}
+		needed := NewStringSet()
+		for pkg := range reachable {
+			if pkg != root && !PathIsChild(root, pkg) {
+				continue
+			}
+			for p := pkg; p != root && p != "." && p != "/"; p = path.Dir(p) {
+				needed.Add(p)
+			}
+			needed.Add(root)
+		}
+		for dir, hasGo := range dirs {
+			if !hasGo || needed[dir] {
+				continue
+			}
+			if dir != root && !needed[path.Dir(dir)] {
+				continue
+			}
+			unused = append(unused, dir)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
+
+// packageDirs returns the import paths of the directories under root
+// in src, true for those with go files in them or below them.
+func packageDirs(src, root string) (map[string]bool, error) {
+	dirs := make(map[string]bool)
+	base := filepath.Join(src, filepath.FromSlash(root))
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == base {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		importPath := path.Join(root, filepath.ToSlash(rel))
+		if info.IsDir() {
+			if p != base && (strings.HasPrefix(info.Name(), ".") || info.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			if _, ok := dirs[importPath]; !ok {
+				dirs[importPath] = false
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".go" {
+			return nil
+		}
+		for dir := path.Dir(importPath); ; dir = path.Dir(dir) {
+			dirs[dir] = true
+			if dir == root || dir == "." || dir == "/" {
+				break
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// toolPackages returns the packages of cdep pinned to be run rather
+// than imported, its go:generate Tools and, if it is in the
+// ToolsGroup, every main package of it in src.
+func toolPackages(src string, cdep *CanticleDependency) ([]string, error) {
+	tools := append([]string{}, cdep.Tools...)
+	if cdep.Group != ToolsGroup {
+		return tools, nil
+	}
+	dirs, err := packageDirs(src, cdep.Root)
+	if err != nil {
+		return nil, err
+	}
+	for dir, hasGo := range dirs {
+		if !hasGo {
+			continue
+		}
+		bp, err := build.Default.ImportDir(filepath.Join(src, filepath.FromSlash(dir)), 0)
+		if err == nil && bp.Name == "main" {
+			tools = append(tools, dir)
+		}
+	}
+	sort.Strings(tools)
+	return tools, nil
+}
+
+// addScannedImports adds the packages in src imported, directly or
+// not, by pkgs and not already in deps, such as those of tools the
+// project does not import, to deps.
+func addScannedImports(src string, deps Dependencies, pkgs []string) {
+	queue := append([]string{}, pkgs...)
+	for len(queue) > 0 {
+		importPath := queue[0]
+		queue = queue[1:]
+		if deps[importPath] != nil {
+			continue
+		}
+		bp, err := build.Default.ImportDir(filepath.Join(src, filepath.FromSlash(importPath)), 0)
+		if err != nil {
+			continue
+		}
+		pkg := &Package{ImportPath: importPath, Imports: bp.Imports}
+		dep := NewDependency(importPath)
+		dep.Imports.Add(pkg.RemoteImports(false)...)
+		deps.AddDependency(dep)
+		queue = append(queue, dep.Imports.Array()...)
+	}
+}
+
+type Prune struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	DryRun  bool
+	Vendor  bool
+
+	// Gopath, if set, is pruned instead of the gopath of
+	// EnvGoPath.
+	Gopath string
+}
+
+func NewPrune() *Prune {
+	f := flag.NewFlagSet("prune", flag.ExitOnError)
+	p := &Prune{flags: f}
+	f.BoolVar(&p.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&p.DryRun, "d", false, "Don't remove the packages, just print them.")
+	f.BoolVar(&p.Vendor, "vendor", false, "Prune the vendor directory of the project instead of the gopath.")
+	return p
+}
+
+var pruner = NewPrune()
+
+var PruneCommand = &Command{
+	Name:             "prune",
+	UsageLine:        "prune [-v] [-d] [-vendor]",
+	ShortDescription: "Remove the packages of dependencies the project does not import.",
+	LongDescription: `The prune command removes the packages of the dependencies in the Canticle file of the project in the current directory which the project does not import, directly or through other packages. Each directory removed is printed.
+
+Imports are read from every go file, including those excluded by build constraints for the current platform, so packages only imported on other platforms or with other tags are kept. The go:generate tools of the project, the Tools of each dependency and the main packages of dependencies in the tools group are kept along with the packages they import, see cant save -generate.
+
+Directories without go files, such as assets, and hidden and testdata directories are kept, as are the directories of packages in the gopath which do not belong to a root of the Canticle file. A root none of whose packages are imported is removed entirely.
+
+Specify -d to print the directories which would be removed without removing them.
+
+Specify -vendor to prune the vendor directory of the project instead of the gopath.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: pruner.flags,
+	Cmd:   pruner,
+}
+
+// Run the prune command.
+func (p *Prune) Run(ctx context.Context, args []string) {
+	if p.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	unused, err := p.Prune(ctx, wd)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	for _, dir := range unused {
+		fmt.Println(dir)
+	}
+}
+
+// Prune removes the unused packages of the dependencies of the project
+// at path, see UnusedPackages, returning their directories. Nothing is
+// removed if DryRun is set. Packages imported by files excluded by
+// build constraints are reachable, so those only used on other
+// platforms are kept, as are the tools of the project and of its
+// dependencies.
+func (p *Prune) Prune(ctx context.Context, path string) ([]string, error) {
+	client, err := NewClient(Options{Gopath: p.Gopath, AllFiles: true, Generate: true})
+	if err != nil {
+		return nil, err
+	}
+	project, err := PackageName(client.Gopath(), path)
+	if err != nil {
+		return nil, err
+	}
+	cdeps, err := SavedDependencies(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cdeps) == 0 {
+		return nil, fmt.Errorf("cant prune %s, it has no saved dependencies", path)
+	}
+	deps, err := client.ReadDeps(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var from []string
+	for _, pkg := range deps.PackagesUnder(project) {
+		if unvendoredPath(pkg) == pkg {
+			from = append(from, pkg)
+		}
+	}
+	src := PackageSource(client.Gopath(), "")
+	if p.Vendor {
+		src = VendorDir(path)
+	}
+	for _, cdep := range cdeps {
+		tools, err := toolPackages(src, cdep)
+		if err != nil {
+			return nil, fmt.Errorf("cant list packages in %s %s", src, err.Error())
+		}
+		addScannedImports(src, deps, tools)
+		from = append(from, tools...)
+	}
+	reachable, err := ReachablePackages(ctx, deps, from)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]string, 0, len(cdeps))
+	for _, cdep := range cdeps {
+		roots = append(roots, cdep.Root)
+	}
+	unused, err := UnusedPackages(src, roots, reachable)
+	if err != nil {
+		return nil, fmt.Errorf("cant list packages in %s %s", src, err.Error())
+	}
+	dirs := make([]string, 0, len(unused))
+	for _, pkg := range unused {
+		dir := filepath.Join(src, filepath.FromSlash(pkg))
+		dirs = append(dirs, dir)
+		if p.DryRun {
+			continue
+		}
+		LogVerboseContext(ctx, "Removing unused package %s", pkg)
+		if err := os.RemoveAll(dir); err != nil {
+			return dirs, fmt.Errorf("cant remove unused package %s %s", pkg, err.Error())
+		}
+	}
+	return dirs, nil
+}
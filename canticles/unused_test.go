@@ -0,0 +1,141 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUnusedPackages(t *testing.T) {
+	deps := NewDependencies()
+	for importPath, imports := range map[string][]string{
+		"example.com/p":                          {"example.com/p/vendor/other.com/lib/a"},
+		"example.com/p/vendor/other.com/lib/a":   {"example.com/p/vendor/other.com/lib/a/b"},
+		"example.com/p/vendor/other.com/lib/a/b": {},
+		"example.com/p/vendor/other.com/lib/c":   {},
+	} {
+		dep := NewDependency(importPath)
+		for _, imp := range imports {
+			dep.Imports.Add(imp)
+		}
+		deps.AddDependency(dep)
+	}
+	reachable, err := ReachablePackages(context.Background(), deps, []string{"example.com/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"example.com/p", "other.com/lib/a", "other.com/lib/a/b"}
+	if !reflect.DeepEqual(expected, reachable.Array()) {
+		t.Errorf("Expected reachable %v got %v", expected, reachable.Array())
+	}
+
+	tmpdir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for _, file := range []string{
+		"other.com/lib/lib.go",
+		"other.com/lib/a/a.go",
+		"other.com/lib/a/b/b.go",
+		"other.com/lib/a/testdata/t.go",
+		"other.com/lib/c/c.go",
+		"other.com/lib/c/d/d.go",
+		"other.com/lib/assets/logo.png",
+		"other.com/lib/.git/x.go",
+		"unused.com/x/x.go",
+		"unused.com/x/y/y.go",
+	} {
+		p := filepath.Join(tmpdir, filepath.FromSlash(file))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte("package x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unused, err := UnusedPackages(tmpdir, []string{"other.com/lib", "unused.com/x", "missing.com/x"}, reachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"other.com/lib/c", "unused.com/x"}
+	if !reflect.DeepEqual(expected, unused) {
+		t.Errorf("Expected unused %v got %v", expected, unused)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"src/proj/main.go":           "package main\n\nimport _ \"dep.com/x/a\"\n\nfunc main() {}\n",
+		"src/proj/main_windows.go":   "//go:build windows\n\npackage main\n\nimport _ \"dep.com/x/windows\"\n",
+		"src/proj/Canticle":          "[{\"Root\": \"dep.com/x\"}]\n",
+		"src/dep.com/x/a/a.go":       "package a\n",
+		"src/dep.com/x/windows/w.go": "//go:build windows\n\npackage windows\n",
+		"src/dep.com/x/unused/u.go":  "package unused\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(testHome, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &Prune{Gopath: testHome, DryRun: true}
+	unused, err := p.Prune(context.Background(), filepath.Join(testHome, "src", "proj"))
+	if err != nil {
+		t.Fatalf("Error pruning: %s", err.Error())
+	}
+	expected := []string{filepath.Join(testHome, "src", "dep.com", "x", "unused")}
+	if !reflect.DeepEqual(expected, unused) {
+		t.Errorf("Expected only the unused package pruned, not the windows one, got %v", unused)
+	}
+}
+
+func TestPruneTools(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"src/proj/main.go":            "package main\n\n//go:generate go run gen.com/g\n\nimport _ \"dep.com/x/a\"\n\nfunc main() {}\n",
+		"src/proj/Canticle":           `[{"Root": "dep.com/x", "Tools": ["dep.com/x/cmd/tool"]}, {"Root": "tool.com/t", "Group": "tools"}, {"Root": "gen.com/g"}]`,
+		"src/dep.com/x/a/a.go":        "package a\n",
+		"src/dep.com/x/cmd/tool/t.go": "package main\n\nimport _ \"dep.com/x/toollib\"\n\nfunc main() {}\n",
+		"src/dep.com/x/toollib/l.go":  "package toollib\n",
+		"src/dep.com/x/unused/u.go":   "package unused\n",
+		"src/tool.com/t/cmd/t/t.go":   "package main\n\nimport _ \"tool.com/t/lib\"\n\nfunc main() {}\n",
+		"src/tool.com/t/lib/l.go":     "package lib\n",
+		"src/tool.com/t/unused/u.go":  "package unused\n",
+		"src/gen.com/g/g.go":          "package main\n\nfunc main() {}\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(testHome, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &Prune{Gopath: testHome, DryRun: true}
+	unused, err := p.Prune(context.Background(), filepath.Join(testHome, "src", "proj"))
+	if err != nil {
+		t.Fatalf("Error pruning: %s", err.Error())
+	}
+	expected := []string{
+		filepath.Join(testHome, "src", "dep.com", "x", "unused"),
+		filepath.Join(testHome, "src", "tool.com", "t", "unused"),
+	}
+	if !reflect.DeepEqual(expected, unused) {
+		t.Errorf("Expected tools and what they import kept got %v", unused)
+	}
+}
@@ -1,13 +1,13 @@
 package canticles
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 )
@@ -15,10 +15,16 @@ import (
 type DirCopier struct {
 	source, dest string
 	CopyDot      bool
+	// Link shares files with the source using LinkFile rather
+	// than copying them.
+	Link bool
+	// Prune are patterns of files and directories not copied, see
+	// Pruned.
+	Prune []string
 }
 
 func NewDirCopier(source, dest string) *DirCopier {
-	return &DirCopier{source, dest, false}
+	return &DirCopier{source: source, dest: dest}
 }
 
 func (dc *DirCopier) Copy() error {
@@ -36,38 +42,90 @@ func (dc *DirCopier) cp(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return err
 	}
+	rel, err := filepath.Rel(dc.source, path)
+	if err != nil {
+		return err
+	}
+	if rel != "." && Pruned(dc.Prune, filepath.ToSlash(rel)) {
+		if f.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if !f.IsDir() && PrunedNonGo(dc.Prune, filepath.ToSlash(rel)) {
+		return nil
+	}
 	// If our file isn't a directory or a normal file ignore it
 	// (don't get unix domain sockets etc.)
 	if !f.Mode().IsDir() && !f.Mode().IsRegular() {
 		return nil
 	}
-	rel, err := filepath.Rel(dc.source, path)
-	if err != nil {
-		return err
-	}
 	if f.IsDir() {
 		dest := filepath.Join(dc.dest, rel)
 		return os.MkdirAll(dest, f.Mode())
 	}
-	s, err := os.Open(path)
+	dst := filepath.Join(dc.dest, rel)
+	if dc.Link {
+		return LinkFile(path, dst, f.Mode())
+	}
+	return copyFile(path, dst, f.Mode())
+}
+
+// removeFile removes any file at dst, so it is replaced rather than
+// written in place. A dst hardlinked to its source would otherwise be
+// truncated along with the source.
+func removeFile(dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// copyFile copies the contents of src to dst, created with mode,
+// replacing any file at dst.
+func copyFile(src, dst string, mode os.FileMode) error {
+	s, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer s.Close()
 
-	dst := filepath.Join(dc.dest, rel)
+	if err := removeFile(dst); err != nil {
+		return err
+	}
 	d, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
 	defer d.Close()
-	d.Chmod(f.Mode())
+	d.Chmod(mode)
 	if _, err := io.Copy(d, s); err != nil {
 		return err
 	}
 	return nil
 }
 
+// LinkFile makes dst share the contents of src where the filesystem
+// allows instead of copying them. A copy on write clone, which later
+// writes to either file do not affect, is tried first, then a
+// hardlink and finally a full copy. Hardlinked files are the same
+// file so must not be modified in place. Any file at dst is replaced.
+func LinkFile(src, dst string, mode os.FileMode) error {
+	if err := removeFile(dst); err != nil {
+		return err
+	}
+	err := cloneFile(src, dst, mode)
+	if err == nil {
+		return nil
+	}
+	LogVerbose("Not cloning %s: %s", src, err.Error())
+	if err = os.Link(src, dst); err == nil {
+		return nil
+	}
+	LogVerbose("Not linking %s: %s", src, err.Error())
+	return copyFile(src, dst, mode)
+}
+
 // PatchEnviroment changes an enviroment variable set to
 // have a new key value
 func PatchEnviroment(env []string, key, value string) []string {
@@ -82,6 +140,48 @@ func PatchEnviroment(env []string, key, value string) []string {
 	return append(env, newValue)
 }
 
+// GoEnv holds extra KEY=VALUE enviroment variables, such as
+// CGO_ENABLED or GOFLAGS, applied to every go subprocess after GOPATH
+// is set. Later values for a key win.
+var GoEnv EnvFlags
+
+// GoEnviroment returns the enviroment go subprocesses are run with
+// for gohome.
+func GoEnviroment(gohome string) []string {
+	return goEnviroment(gohome, nil)
+}
+
+// goEnviroment returns the GoEnviroment for gohome with the KEY=VALUE
+// variables of extra applied before GoEnv, so GoEnv wins.
+func goEnviroment(gohome string, extra []string) []string {
+	env := PatchEnviroment(os.Environ(), "GOPATH", gohome)
+	for _, kv := range goEnvWith(extra) {
+		parts := strings.SplitN(kv, "=", 2)
+		env = PatchEnviroment(env, parts[0], parts[1])
+	}
+	return env
+}
+
+// goEnvWith returns extra followed by GoEnv.
+func goEnvWith(extra []string) []string {
+	return append(append([]string{}, extra...), GoEnv...)
+}
+
+// EnvFlags is a flag.Value collecting KEY=VALUE enviroment variables.
+type EnvFlags []string
+
+func (ef *EnvFlags) String() string {
+	return fmt.Sprintf("%v", []string(*ef))
+}
+
+func (ef *EnvFlags) Set(v string) error {
+	if i := strings.Index(v, "="); i < 1 {
+		return fmt.Errorf("invalid enviroment variable %s, must be KEY=VALUE", v)
+	}
+	*ef = append(*ef, v)
+	return nil
+}
+
 // EnvGoPath returns a proper gopath, if we are inside a gb style
 // 'src/' workspace this gopath is set to the parent of the src dir.
 // If not the enviorment gopath will be used. If neither a log message
@@ -90,51 +190,117 @@ func EnvGoPath() (string, error) {
 	gopath := os.Getenv("GOPATH")
 	wd, err := os.Getwd()
 	if gopath != "" && err != nil {
-		return gopath, nil
+		return gopath, CheckGoPath(gopath)
 	}
 	if err != nil {
-		return "", fmt.Errorf("no gopath set and error getting current working directory %s", err.Error())
+		return "", &GoPathError{Err: fmt.Errorf("no gopath set and error getting current working directory %s", err.Error())}
 	}
 	root := ProjectRoot(wd)
 	if root != "" {
 		return root, nil
 	}
 	if gopath != "" {
-		return gopath, nil
+		return gopath, CheckGoPath(gopath)
 	}
 
-	return "", fmt.Errorf("no gopath set and working directory %s is not inside a 'src/' directory", wd)
+	return "", &GoPathError{Err: fmt.Errorf("no gopath set and working directory %s is not inside a 'src/' directory, canticle expects packages at $GOPATH/src/<import path>, set GOPATH or run cant inside a src directory", wd)}
 }
 
-// PathIsChild will return true if the child path
-// is a subfolder of parent.
+// CreateGoPath controls whether CheckGoPath creates a missing src
+// directory.
+var CreateGoPath = false
+
+// CheckGoPath returns an error explaining the expected layout if
+// gopath has no src directory in the DefaultFS. If CreateGoPath is
+// true a missing src directory is created instead.
+func CheckGoPath(gopath string) error {
+	src := filepath.Join(gopath, "src")
+	s, err := DefaultFS.Stat(src)
+	switch {
+	case err == nil && s.IsDir():
+		return nil
+	case err == nil:
+		return &GoPathError{Gopath: gopath, Err: fmt.Errorf("gopath %s is invalid, %s is a file not a directory", gopath, src)}
+	case os.IsNotExist(err) && CreateGoPath:
+		LogInfo("Creating gopath src directory %s", src)
+		return DefaultFS.MkdirAll(src, 0755)
+	case os.IsNotExist(err):
+		return &GoPathError{Gopath: gopath, Missing: true, Err: fmt.Errorf("gopath %s has no src directory, canticle expects packages at %s, run cant -create to create it", gopath, filepath.Join(src, "<import path>"))}
+	}
+	return &GoPathError{Gopath: gopath, Err: fmt.Errorf("cant check gopath %s %s", gopath, err.Error())}
+}
+
+// PathIsChild will return true if the child path is a subfolder of
+// parent. If both are absolute paths symlinks are evaluated so
+// symlinked gopaths are handled.
 func PathIsChild(parent, child string) bool {
-	parentParts := strings.Split(parent, string(os.PathSeparator))
-	childParts := strings.Split(child, string(os.PathSeparator))
+	if pathIsChild(parent, child) {
+		return true
+	}
+	if !filepath.IsAbs(parent) || !filepath.IsAbs(child) {
+		return false
+	}
+	return pathIsChild(EvalPath(parent), EvalPath(child))
+}
+
+func pathIsChild(parent, child string) bool {
+	parentParts := strings.Split(filepath.ToSlash(parent), "/")
+	childParts := strings.Split(filepath.ToSlash(child), "/")
 	if len(childParts) < len(parentParts) {
 		return false
 	}
 	for i, part := range parentParts {
-		if part != childParts[i] {
+		if !pathPartEqual(part, childParts[i]) {
 			return false
 		}
 	}
 	return true
 }
 
+// pathPartEqual compares path elements, ignoring case on windows
+// where the filesystem is case insensitive.
+func pathPartEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// EvalPath returns path with any symlinks evaluated. If path can not
+// be evaluated (for instance it does not exist yet) the longest
+// existing prefix is evaluated and the rest appended.
+func EvalPath(path string) string {
+	if evaled, err := filepath.EvalSymlinks(path); err == nil {
+		return evaled
+	}
+	dir, file := filepath.Split(filepath.Clean(path))
+	dir = filepath.Clean(dir)
+	if dir == path || dir == "." || file == "" {
+		return path
+	}
+	return filepath.Join(EvalPath(dir), file)
+}
+
 // PackageSource returns the src dir for a package
 func PackageSource(gopath, pkg string) string {
-	return path.Join(gopath, "src", filepath.FromSlash(pkg))
+	return filepath.Join(gopath, "src", filepath.FromSlash(pkg))
 }
 
 // PackageName returns the package name (importpath) of a path given a
 // path relative to a gopath. If path is not filepath.Rel to gopath an
 // error will be returned.
 func PackageName(gopath, path string) (string, error) {
-	path, err := filepath.Rel(gopath, path)
+	rel, err := filepath.Rel(gopath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		// One of our paths may be through a symlink
+		if evalRel, evalErr := filepath.Rel(EvalPath(gopath), EvalPath(path)); evalErr == nil {
+			rel, err = evalRel, nil
+		}
+	}
 	if err != nil {
 		return "", err
 	}
+	path = rel
 
 	name := filepath.ToSlash(path)
 	name = strings.TrimPrefix(name, "src/")
@@ -147,11 +313,10 @@ func PackageName(gopath, path string) (string, error) {
 // Verbose controls whether verbose logs will be printed from this package
 var Verbose = false
 
-// LogVerbose will log a value using log.Printf if Verbose is true.
+// LogVerbose will log a value with the DefaultLogger if Verbose is
+// true.
 func LogVerbose(fmtString string, args ...interface{}) {
-	if Verbose {
-		log.Printf(fmtString, args...)
-	}
+	LogVerboseContext(context.Background(), fmtString, args...)
 }
 
 // Quite being true prevents LogWarn from printing.
@@ -159,18 +324,12 @@ var Quite = false
 
 // LogWarn will print lines unless quite is true
 func LogWarn(fmtString string, args ...interface{}) {
-	if Quite {
-		return
-	}
-	log.Printf("WARN: "+fmtString, args...)
+	LogWarnContext(context.Background(), fmtString, args...)
 }
 
 // LogInfo will print lines unless quite is true
 func LogInfo(fmtString string, args ...interface{}) {
-	if Quite {
-		return
-	}
-	log.Printf("INFO: "+fmtString, args...)
+	LogInfoContext(context.Background(), fmtString, args...)
 }
 
 // StringSets adds set like operations to a string map.
@@ -253,7 +412,7 @@ func (ds DirFlags) String() string {
 }
 
 func (ds DirFlags) Set(v string) error {
-	if path.IsAbs(v) {
+	if filepath.IsAbs(v) {
 		StringSet(ds).Add(v)
 		return nil
 	}
@@ -261,33 +420,50 @@ func (ds DirFlags) Set(v string) error {
 	if err != nil {
 		return fmt.Errorf("could not add relative directory, error getting wd %s", err.Error())
 	}
-	StringSet(ds).Add(path.Join(wd, v))
+	StringSet(ds).Add(filepath.Join(wd, v))
 	return nil
 }
 
 // Return the location of the depedency file for a path. Should be a
 // directory.
 func DependencyFile(p string) string {
-	return path.Join(p, "Canticle")
+	return filepath.Join(p, "Canticle")
 }
 
-func VisibleSubDirectories(dirname string) ([]string, error) {
-	finfos, err := ioutil.ReadDir(dirname)
+// VisibleSubDirectories returns the paths of the subdirectories of
+// dirname in fsys not starting with a ".".
+func VisibleSubDirectories(fsys FS, dirname string) ([]string, error) {
+	finfos, err := VisibleSubDirectoryInfos(fsys, dirname)
 	subdirs := make([]string, 0, len(finfos))
+	for _, f := range finfos {
+		subdirs = append(subdirs, filepath.Join(dirname, f.Name()))
+	}
+	return subdirs, err
+}
+
+// VisibleSubDirectoryInfos reads dirname in fsys once and returns the
+// FileInfo of each subdirectory not starting with a ".".
+func VisibleSubDirectoryInfos(fsys FS, dirname string) ([]os.FileInfo, error) {
+	finfos, err := fsys.ReadDir(dirname)
+	subdirs := make([]os.FileInfo, 0, len(finfos))
 	for _, f := range finfos {
 		if f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
-			subdirs = append(subdirs, path.Join(dirname, f.Name()))
+			subdirs = append(subdirs, f)
 		}
 	}
 	return subdirs, err
 }
 
+// ProjectRoot returns the parent of the last "src" directory in
+// dirname, keeping any volume name, or the empty string if there is
+// no src directory.
 func ProjectRoot(dirname string) string {
-	list := strings.Split(filepath.ToSlash(dirname), "/")
+	vol := filepath.VolumeName(dirname)
+	list := strings.Split(filepath.ToSlash(dirname[len(vol):]), "/")
 	for i := len(list) - 1; i > 0; i-- {
 		if list[i] == "src" {
 			list = append([]string{"/"}, list[:i]...)
-			return path.Join(list...)
+			return vol + filepath.FromSlash(path.Join(list...))
 		}
 	}
 	return ""
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -91,3 +92,173 @@ func TestEnvGoPath(t *testing.T) {
 		t.Errorf("Expected an error when getting envgopath in an valid workspace, got")
 	}
 }
+
+func TestSymlinkedGoPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	real := filepath.Join(dir, "real")
+	pkgDir := filepath.Join(real, "src", "test.com", "pkg")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skipf("Could not create symlink %s", err.Error())
+	}
+
+	// Gopath through the link, path through the real dir and vice versa
+	for _, paths := range [][2]string{{link, pkgDir}, {real, filepath.Join(link, "src", "test.com", "pkg")}} {
+		pkg, err := PackageName(paths[0], paths[1])
+		if err != nil {
+			t.Errorf("Error getting package name for %s in %s: %s", paths[1], paths[0], err.Error())
+		}
+		if pkg != "test.com/pkg" {
+			t.Errorf("Expected package test.com/pkg for %s in %s got %s", paths[1], paths[0], pkg)
+		}
+		if !PathIsChild(paths[0], paths[1]) {
+			t.Errorf("Expected %s to be a child of %s", paths[1], paths[0])
+		}
+	}
+
+	// Paths not on disk yet should still resolve through the link
+	missing := filepath.Join(link, "src", "test.com", "missing")
+	if !PathIsChild(real, missing) {
+		t.Errorf("Expected %s to be a child of %s", missing, real)
+	}
+	if PathIsChild(filepath.Join(real, "src", "other.com"), pkgDir) {
+		t.Errorf("Expected %s to not be a child of other.com", pkgDir)
+	}
+}
+
+func TestGoEnviroment(t *testing.T) {
+	defer func() { GoEnv = nil }()
+	if err := GoEnv.Set("CGO_ENABLED"); err == nil {
+		t.Errorf("Expected error setting env without a value")
+	}
+	for _, kv := range []string{"CGO_ENABLED=0", "GOOS=plan9", "GOOS=linux"} {
+		if err := GoEnv.Set(kv); err != nil {
+			t.Fatalf("Error setting env %s: %s", kv, err.Error())
+		}
+	}
+	vars := make(map[string]string)
+	for _, kv := range GoEnviroment("/test/gopath") {
+		parts := strings.SplitN(kv, "=", 2)
+		vars[parts[0]] = parts[1]
+	}
+	expected := map[string]string{"GOPATH": "/test/gopath", "CGO_ENABLED": "0", "GOOS": "linux"}
+	for k, v := range expected {
+		if vars[k] != v {
+			t.Errorf("Expected %s=%s got %s", k, v, vars[k])
+		}
+	}
+}
+
+func TestCheckGoPath(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	defer func() { CreateGoPath = false }()
+
+	if err := CheckGoPath(testHome); err == nil || !strings.Contains(err.Error(), "-create") {
+		t.Errorf("Expected error suggesting -create for missing src got %v", err)
+	}
+	CreateGoPath = true
+	if err := CheckGoPath(testHome); err != nil {
+		t.Errorf("Expected no error creating gopath got %s", err.Error())
+	}
+	if s, err := os.Stat(filepath.Join(testHome, "src")); err != nil || !s.IsDir() {
+		t.Errorf("Expected src directory to be created")
+	}
+	if err := CheckGoPath(testHome); err != nil {
+		t.Errorf("Expected no error for valid gopath got %s", err.Error())
+	}
+}
+
+func TestDirCopierLink(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	source := filepath.Join(testHome, "source")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"a.go": "package a\n", filepath.Join("sub", "b.go"): "package b\n"}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(source, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(testHome, "dest")
+	dc := NewDirCopier(source, dest)
+	dc.Link = true
+	if err := dc.Copy(); err != nil {
+		t.Fatalf("Error linking dir: %s", err.Error())
+	}
+	for name, contents := range files {
+		result, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Errorf("Error reading linked file %s: %s", name, err.Error())
+			continue
+		}
+		if string(result) != contents {
+			t.Errorf("Expected %s to contain %q got %q", name, contents, string(result))
+		}
+	}
+
+	// Linking over an existing file replaces it
+	if err := ioutil.WriteFile(filepath.Join(dest, "a.go"), []byte("package old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkFile(filepath.Join(source, "sub", "b.go"), filepath.Join(dest, "a.go"), 0644); err != nil {
+		t.Fatalf("Error linking over a file: %s", err.Error())
+	}
+	if result, _ := ioutil.ReadFile(filepath.Join(dest, "a.go")); string(result) != "package b\n" {
+		t.Errorf("Expected linked file to be replaced got %q", string(result))
+	}
+}
+
+func TestDirCopierOverLinks(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	source := filepath.Join(testHome, "source")
+	dest := filepath.Join(testHome, "dest")
+	for _, dir := range []string{source, dest} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(source, "a.go")
+	if err := ioutil.WriteFile(src, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copying or linking again over a hardlink to the source, as
+	// when vendoring again, must not truncate the source
+	for _, link := range []bool{false, true} {
+		os.Remove(filepath.Join(dest, "a.go"))
+		if err := os.Link(src, filepath.Join(dest, "a.go")); err != nil {
+			t.Skipf("Hardlinks not supported: %s", err.Error())
+		}
+		dc := NewDirCopier(source, dest)
+		dc.Link = link
+		if err := dc.Copy(); err != nil {
+			t.Fatalf("Error copying over a link: %s", err.Error())
+		}
+		for _, file := range []string{src, filepath.Join(dest, "a.go")} {
+			if result, _ := ioutil.ReadFile(file); string(result) != "package a\n" {
+				t.Errorf("Expected %s to keep its contents with link %v got %q", file, link, string(result))
+			}
+		}
+	}
+}
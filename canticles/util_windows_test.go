@@ -0,0 +1,53 @@
+package canticles
+
+import "testing"
+
+func TestWindowsPackageSource(t *testing.T) {
+	src := PackageSource(`C:\Users\cant\go`, "github.com/Comcast/Canticle")
+	expected := `C:\Users\cant\go\src\github.com\Comcast\Canticle`
+	if src != expected {
+		t.Errorf("Expected package source %s got %s", expected, src)
+	}
+	if file := DependencyFile(src); file != expected+`\Canticle` {
+		t.Errorf("Expected dependency file in %s got %s", expected, file)
+	}
+}
+
+func TestWindowsPackageName(t *testing.T) {
+	pkg, err := PackageName(`C:\Users\cant\go`, `c:\users\cant\go\src\github.com\Comcast\Canticle`)
+	if err != nil {
+		t.Errorf("Error getting valid package: %s", err.Error())
+	}
+	if pkg != "github.com/Comcast/Canticle" {
+		t.Errorf("Expected package github.com/Comcast/Canticle got %s", pkg)
+	}
+}
+
+func TestWindowsPathIsChild(t *testing.T) {
+	cases := []struct {
+		parent, child string
+		expected      bool
+	}{
+		{`C:\go\src`, `C:\go\src\github.com\x`, true},
+		{`C:\go\src`, `c:\GO\SRC\github.com\x`, true},
+		{`C:\go\src`, `C:/go/src/github.com/x`, true},
+		{`C:\go\src`, `D:\go\src\github.com\x`, false},
+		{`C:\go\src\github.com\x`, `C:\go\src`, false},
+		{"github.com/x", "github.com/x/y", true},
+	}
+	for _, c := range cases {
+		if PathIsChild(c.parent, c.child) != c.expected {
+			t.Errorf("Expected PathIsChild(%s, %s) to be %v", c.parent, c.child, c.expected)
+		}
+	}
+}
+
+func TestWindowsProjectRoot(t *testing.T) {
+	root := ProjectRoot(`C:\Users\cant\work\src\github.com\x`)
+	if root != `C:\Users\cant\work` {
+		t.Errorf(`Expected project root C:\Users\cant\work got %s`, root)
+	}
+	if root := ProjectRoot(`C:\Users\cant`); root != "" {
+		t.Errorf("Expected no project root got %s", root)
+	}
+}
@@ -1,12 +1,15 @@
 package canticles
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -19,12 +22,12 @@ import (
 // A VCS has the ability to create and change the revision of a
 // package. A VCS is generall resolved using a RepoDiscovery.
 type VCS interface {
-	Create(rev string) error
-	SetRev(rev string) error
-	GetRev() (string, error)
-	GetBranch() (string, error)
-	UpdateBranch(branch string) (updated bool, update string, err error)
-	GetSource() (string, error)
+	Create(ctx context.Context, rev string) error
+	SetRev(ctx context.Context, rev string) error
+	GetRev(ctx context.Context) (string, error)
+	GetBranch(ctx context.Context) (string, error)
+	UpdateBranch(ctx context.Context, branch string) (updated bool, update string, err error)
+	GetSource(ctx context.Context) (string, error)
 	GetRoot() string
 }
 
@@ -48,14 +51,14 @@ type VCSCmd struct {
 }
 
 // ExecWithArgs overriden from the default
-func (vc *VCSCmd) ExecWithArgs(repo string, args []string) (string, error) {
-	LogVerbose("Running command: %s %v in dir %s", vc.Cmd, args, repo)
-	cmd := exec.Command(vc.Cmd, args...)
-	cmd.Dir = repo
-	result, err := cmd.CombinedOutput()
+func (vc *VCSCmd) ExecWithArgs(ctx context.Context, repo string, args []string) (string, error) {
+	LogVerboseContext(ctx, "Running command: %s %v in dir %s", vc.Cmd, args, repo)
+	result, err := command(ctx, repo, vc.Cmd, args...).CombinedOutput()
 	resultTrim := strings.TrimSpace(string(result))
 	rev := vc.ParseRegex.FindSubmatch([]byte(resultTrim))
 	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", ctx.Err()
 	case err != nil:
 		return "", fmt.Errorf("Error getting revision %s", result)
 	case result == nil:
@@ -70,13 +73,13 @@ func (vc *VCSCmd) ExecWithArgs(repo string, args []string) (string, error) {
 // Exec executes this command with its arguments and parses them using
 // regexp. Return an error if the command generates an error or we can
 // not parse the results.
-func (vc *VCSCmd) Exec(repo string) (string, error) {
-	return vc.ExecWithArgs(repo, vc.Args)
+func (vc *VCSCmd) Exec(ctx context.Context, repo string) (string, error) {
+	return vc.ExecWithArgs(ctx, repo, vc.Args)
 }
 
 // ExecReplace replaces the value in this commands args with values
 // from vals and executes the function.
-func (vc *VCSCmd) ExecReplace(repo string, vals map[string]string) (string, error) {
+func (vc *VCSCmd) ExecReplace(ctx context.Context, repo string, vals map[string]string) (string, error) {
 	replacements := make([]string, 0, len(vals)*2)
 	for k, v := range vals {
 		replacements = append(replacements, k, v)
@@ -86,7 +89,65 @@ func (vc *VCSCmd) ExecReplace(repo string, vals map[string]string) (string, erro
 	for _, arg := range vc.Args {
 		args = append(args, replacer.Replace(arg))
 	}
-	return vc.ExecWithArgs(repo, args)
+	return vc.ExecWithArgs(ctx, repo, args)
+}
+
+// RunVCS runs cmdline, a command of v such as its CreateCmd, in dir
+// as golang.org/x/tools/go/vcs does, expanding each {key} in its
+// arguments to the value following key in keyval. The command is
+// killed when ctx is done.
+func RunVCS(ctx context.Context, v *vcs.Cmd, dir, cmdline string, keyval ...string) ([]byte, error) {
+	replacements := make([]string, 0, len(keyval))
+	for i := 0; i+1 < len(keyval); i += 2 {
+		replacements = append(replacements, "{"+keyval[i]+"}", keyval[i+1])
+	}
+	replacer := strings.NewReplacer(replacements...)
+	args := strings.Fields(cmdline)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+	LogVerboseContext(ctx, "Running command: %s %v in dir %s", v.Cmd, args, dir)
+	cmd := command(ctx, dir, v.Cmd, args...)
+	if filepath.IsAbs(dir) {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = PatchEnviroment(cmd.Env, "PWD", dir)
+	}
+	out, err := cmd.CombinedOutput()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
+		return nil, fmt.Errorf("%s %s failed %s", v.Cmd, strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// TagSync syncs the repo of v in dir to tag, the default tag if it is
+// empty, as v.TagSync does but killed when ctx is done.
+func TagSync(ctx context.Context, v *vcs.Cmd, dir, tag string) error {
+	if v.TagSyncCmd == "" {
+		return nil
+	}
+	if tag != "" {
+		for _, tc := range v.TagLookupCmd {
+			out, err := RunVCS(ctx, v, dir, tc.Cmd, "tag", tag)
+			if err != nil {
+				return err
+			}
+			if m := regexp.MustCompile(`(?m-s)` + tc.Pattern).FindStringSubmatch(string(out)); len(m) > 1 {
+				tag = m[1]
+				break
+			}
+		}
+	}
+	if tag == "" && v.TagSyncDefault != "" {
+		_, err := RunVCS(ctx, v, dir, v.TagSyncDefault)
+		return err
+	}
+	_, err := RunVCS(ctx, v, dir, v.TagSyncCmd, "tag", tag)
+	return err
 }
 
 var (
@@ -288,14 +349,12 @@ var (
 	}
 )
 
-func GetSvnBranches(path string) ([]string, error) {
+func GetSvnBranches(ctx context.Context, path string) ([]string, error) {
 	return nil, errors.New("Not implemented")
 }
 
-func GetGitBranches(path string) ([]string, error) {
-	cmd := exec.Command("git", "show-ref")
-	cmd.Dir = path
-	result, err := cmd.CombinedOutput()
+func GetGitBranches(ctx context.Context, path string) ([]string, error) {
+	result, err := command(ctx, path, "git", "show-ref").CombinedOutput()
 	if err != nil {
 		return nil, err
 	}
@@ -318,21 +377,187 @@ func GetGitBranches(path string) ([]string, error) {
 	return results, nil
 }
 
-func GetHgBranches(path string) ([]string, error) {
+// GitIgnoredPaths returns the paths which are ignored by the git
+// repository containing dir. An error is returned if dir is not in a
+// git work tree.
+func GitIgnoredPaths(ctx context.Context, dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	result, err := command(ctx, dir, "git", append([]string{"check-ignore", "--"}, paths...)...).Output()
+	if err != nil {
+		// check-ignore exits 1 if no paths are ignored
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("git check-ignore failed in %s %s", dir, err.Error())
+	}
+	var ignored []string
+	for _, line := range strings.Split(string(result), "\n") {
+		if line != "" {
+			ignored = append(ignored, line)
+		}
+	}
+	return ignored, nil
+}
+
+func GetHgBranches(ctx context.Context, path string) ([]string, error) {
 	return nil, errors.New("Not implemented")
 }
 
-func GetBzrBranches(path string) ([]string, error) {
+func GetBzrBranches(ctx context.Context, path string) ([]string, error) {
 	return nil, errors.New("Not implemented")
 }
 
-var BranchFuncs = map[string]func(string) ([]string, error){
+var BranchFuncs = map[string]func(context.Context, string) ([]string, error){
 	GitBranchCmd.Name: GetGitBranches,
 	SvnBranchCmd.Name: GetSvnBranches,
 	HgBranchCmd.Name:  GetHgBranches,
 	BzrBranchCmd.Name: GetBzrBranches,
 }
 
+// StatusCmds are the commands, by vcs name, listing the uncommitted
+// changes of a repo. Their output is empty for a clean working tree.
+var StatusCmds = map[string][]string{
+	"Git":        {"git", "status", "--porcelain"},
+	"Mercurial":  {"hg", "status"},
+	"Bazaar":     {"bzr", "status", "--short"},
+	"Subversion": {"svn", "status", "-q"},
+}
+
+// DescribeCmds are the commands, by vcs name, describing the current
+// revision of a repo as its nearest tag, the number of commits since
+// it and a short revision, with a -dirty suffix for uncommitted
+// changes, e.g. v1.2.0-3-gabc1234-dirty.
+var DescribeCmds = map[string][]string{
+	"Git": {"git", "describe", "--tags", "--always", "--dirty"},
+}
+
+// TagCmds are the commands, by vcs name, listing the tags of a repo,
+// one per line with the tag first and its revision last, optionally
+// after a colon.
+var TagCmds = map[string][]string{
+	"Git":       {"git", "for-each-ref", "--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags"},
+	"Mercurial": {"hg", "tags", "--debug"},
+}
+
+// AheadBehindCmds are the commands, by vcs name, counting the
+// revisions of {from} which {rev} lacks and those only in {rev},
+// separated by whitespace.
+var AheadBehindCmds = map[string][]string{
+	"Git": {"git", "rev-list", "--left-right", "--count", "{from}...{rev}"},
+}
+
+// ResolveCmds are the commands, by vcs name, printing the revision
+// {rev} names, e.g. the commit a tag or branch is at.
+var ResolveCmds = map[string][]string{
+	"Git":       {"git", "rev-parse", "-q", "--verify", "{rev}^{commit}"},
+	"Mercurial": {"hg", "log", "-r", "{rev}", "--template", "{node}"},
+}
+
+// RemoteTagCmds are the commands, by vcs name, listing the tags of the
+// remote of a repo, one per line with the revision first and the tag
+// ref last. Annotated tags are listed again with a ^{} suffix at the
+// revision they tag.
+var RemoteTagCmds = map[string][]string{
+	"Git": {"git", "ls-remote", "--tags", "origin"},
+}
+
+// RemoteHeadCmds are the commands, by vcs name, printing the revision
+// of {branch} of the remote of a repo first, or of the refs matching
+// it one per line. DefaultBranches are used for {branch} if no branch
+// is given.
+var RemoteHeadCmds = map[string][]string{
+	"Git":       {"git", "ls-remote", "origin", "{branch}"},
+	"Mercurial": {"hg", "identify", "--debug", "-r", "{branch}", "default"},
+}
+
+// DefaultBranches are the names, by vcs name, of the default branch of
+// a remote in RemoteHeadCmds.
+var DefaultBranches = map[string]string{
+	"Git":       "HEAD",
+	"Mercurial": "default",
+}
+
+// ShallowCreateCmds are the commands, by vcs cmd, run in order in an
+// empty directory to fetch only the revision {rev} of {repo} into it
+// rather than its full history. {ref} is {rev}, or its ShallowTagRefs
+// if it is a tag of {repo}. Mercurial fetches the ancestors of {rev},
+// it can not leave them out.
+var ShallowCreateCmds = map[string][]string{
+	"git": {"init -q", "remote add origin {repo}", "fetch -q --depth 1 origin {ref}", "checkout -q FETCH_HEAD"},
+	"hg":  {"clone -r {rev} {repo} ."},
+}
+
+// ShallowTagRefs are the refs, by vcs cmd, ShallowCreateCmds fetch as
+// {ref} when {rev} is a tag of {repo}, so the tag is fetched too.
+var ShallowTagRefs = map[string]string{
+	"git": "refs/tags/{rev}:refs/tags/{rev}",
+}
+
+// RemoteTagExistsCmds are the commands, by vcs cmd, printing tag {rev}
+// of {repo} if it has one and nothing otherwise.
+var RemoteTagExistsCmds = map[string][]string{
+	"git": {"git", "ls-remote", "--tags", "{repo}", "refs/tags/{rev}"},
+}
+
+// A ShallowCreator can fetch a revision of a repo without its
+// history, see DependencyLoader.Shallow.
+type ShallowCreator interface {
+	CreateShallow(ctx context.Context, rev string) error
+}
+
+// VerifyGitSignature checks that the checked out HEAD of the git repo
+// in dir has a good GPG signature, as a commit or through rev if rev
+// is a tag of HEAD. The keys trusted must be given explicitly: gpghome
+// is the GNUPGHOME holding them, all of them trusted unless keys lists
+// the fingerprints of those trusted.
+func VerifyGitSignature(ctx context.Context, dir, rev, gpghome string, keys []string) error {
+	if gpghome == "" && len(keys) == 0 {
+		return fmt.Errorf("no trusted keys, specify a gpghome or the fingerprints of trusted keys")
+	}
+	head, err := command(ctx, dir, "git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("cant read HEAD %s", err.Error())
+	}
+	args := []string{"verify-commit", "--raw", "HEAD"}
+	if rev != "" && command(ctx, dir, "git", "rev-parse", "-q", "--verify", "refs/tags/"+rev).Run() == nil {
+		tagged, err := command(ctx, dir, "git", "rev-parse", "refs/tags/"+rev+"^{commit}").Output()
+		if err == nil && strings.TrimSpace(string(tagged)) == strings.TrimSpace(string(head)) {
+			args = []string{"verify-tag", "--raw", "refs/tags/" + rev}
+		}
+	}
+	cmd := command(ctx, dir, "git", args...)
+	if gpghome != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = PatchEnviroment(cmd.Env, "GNUPGHOME", gpghome)
+	}
+	result, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("no good signature for %s %s", args[2], strings.TrimSpace(string(result)))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	trusted := NewStringSet()
+	for _, key := range keys {
+		trusted.Add(strings.ToUpper(strings.Replace(key, " ", "", -1)))
+	}
+	// [GNUPG:] VALIDSIG <fingerprint> ... <primary key fingerprint>
+	for _, line := range strings.Split(string(result), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		if trusted[strings.ToUpper(fields[2])] || trusted[strings.ToUpper(fields[len(fields)-1])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not signed by a trusted key", args[2])
+}
+
 // A LocalVCS uses packages and version control systems available at a
 // local srcpath to control a local destpath (it copies the files over).
 type LocalVCS struct {
@@ -347,7 +572,7 @@ type LocalVCS struct {
 	BranchUpdateCmd    *VCSCmd        // BranchUpdateCmd is used to update a local branch with a remote
 	BranchUpdatedRegex *regexp.Regexp // The regex to examine if an update occured from a branch update cmd
 	SyncCmd            *VCSCmd
-	Branches           func(path string) ([]string, error)
+	Branches           func(ctx context.Context, path string) ([]string, error)
 }
 
 // NewLocalVCS returns a a LocalVCS with CurrentRevCmd initialized
@@ -371,51 +596,51 @@ func NewLocalVCS(pkg, root, srcPath string, cmd *vcs.Cmd) *LocalVCS {
 
 // Create will copy (using a dir copier) the package from srcpath to
 // destpath and then call set.
-func (lv *LocalVCS) Create(rev string) error {
-	return lv.SetRev(rev)
+func (lv *LocalVCS) Create(ctx context.Context, rev string) error {
+	return lv.SetRev(ctx, rev)
 }
 
 // SetRev will use the LocalVCS's Cmd.TagSync method to change the
 // revision of a repo if rev is not the empty string and Cmd is not
 // nil.
-func (lv *LocalVCS) SetRev(rev string) error {
+func (lv *LocalVCS) SetRev(ctx context.Context, rev string) error {
 	if lv.Cmd == nil || rev == "" {
 		return nil
 	}
 	src := PackageSource(lv.SrcPath, lv.Root)
 	// Update against remotes if we need too
 	if lv.UpdateCmd != nil {
-		if _, err := lv.UpdateCmd.Exec(src); err != nil {
+		if _, err := lv.UpdateCmd.Exec(ctx, src); err != nil {
 			return err
 		}
 	}
 	// For revisions we just want to check it out
-	if err := lv.TagSync(rev); err != nil {
-		return err
+	if err := lv.TagSync(ctx, rev); err != nil {
+		return &RevisionError{Root: lv.Root, Revision: rev, Err: err}
 	}
 	return nil
 }
 
-func (lv *LocalVCS) TagSync(rev string) error {
-	LogVerbose("Tag sync to: %s", rev)
+func (lv *LocalVCS) TagSync(ctx context.Context, rev string) error {
+	LogVerboseContext(ctx, "Tag sync to: %s", rev)
 	if lv.SyncCmd == nil {
 		return nil
 	}
-	_, err := lv.SyncCmd.ExecReplace(PackageSource(lv.SrcPath, lv.Root), map[string]string{"{tag}": rev})
-	if err == nil {
-		return nil
+	_, err := lv.SyncCmd.ExecReplace(ctx, PackageSource(lv.SrcPath, lv.Root), map[string]string{"{tag}": rev})
+	if err == nil || ctx.Err() != nil {
+		return err
 	}
-	LogVerbose("Tag sync failed with err: %s", err.Error())
-	return lv.Cmd.TagSync(PackageSource(lv.SrcPath, lv.Root), rev)
+	LogVerboseContext(ctx, "Tag sync failed with err: %s", err.Error())
+	return TagSync(ctx, lv.Cmd, PackageSource(lv.SrcPath, lv.Root), rev)
 }
 
-func (lv *LocalVCS) RevIsBranch(rev string) bool {
-	branches, err := lv.Branches(PackageSource(lv.SrcPath, lv.Root))
+func (lv *LocalVCS) RevIsBranch(ctx context.Context, rev string) bool {
+	branches, err := lv.Branches(ctx, PackageSource(lv.SrcPath, lv.Root))
 	if err != nil {
-		LogVerbose("Error getting branches %s", err.Error())
+		LogVerboseContext(ctx, "Error getting branches %s", err.Error())
 		return false
 	}
-	LogVerbose("Found branches %v", branches)
+	LogVerboseContext(ctx, "Found branches %v", branches)
 	for _, br := range branches {
 		if rev == br {
 			return true
@@ -427,21 +652,223 @@ func (lv *LocalVCS) RevIsBranch(rev string) bool {
 // GetRev will return current revision of the local repo.  If the
 // local package is not under a VCS it will return nil, nil.  If the
 // vcs can not query the version it will return nil and an error.
-func (lv *LocalVCS) GetRev() (string, error) {
+func (lv *LocalVCS) GetRev(ctx context.Context) (string, error) {
 	if lv.CurrentRevCmd == nil || lv.Cmd == nil {
 		return "", nil
 	}
-	return lv.CurrentRevCmd.Exec(PackageSource(lv.SrcPath, lv.Root))
+	return lv.CurrentRevCmd.Exec(ctx, PackageSource(lv.SrcPath, lv.Root))
+
+}
+
+// IsDirty returns true if the local repo has uncommitted changes. An
+// error is returned if the vcs has no StatusCmds entry.
+func (lv *LocalVCS) IsDirty(ctx context.Context) (bool, error) {
+	if lv.Cmd == nil {
+		return false, nil
+	}
+	status := StatusCmds[lv.Cmd.Name]
+	if status == nil {
+		return false, fmt.Errorf("cant check status of %s repos", lv.Cmd.Name)
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), status[0], status[1:]...).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("Error getting status %s", result)
+	}
+	return len(strings.TrimSpace(string(result))) > 0, nil
+}
+
+// AheadBehind returns the number of revisions of from which rev does
+// not have, and of rev from does not have, using AheadBehindCmds. From
+// is the revision of the local repo if empty. An error is returned if
+// the vcs has no AheadBehindCmds entry or either revision is not in
+// the local repo.
+func (lv *LocalVCS) AheadBehind(ctx context.Context, from, rev string) (ahead, behind int, err error) {
+	if lv.Cmd == nil {
+		return 0, 0, nil
+	}
+	count := AheadBehindCmds[lv.Cmd.Name]
+	if count == nil {
+		return 0, 0, fmt.Errorf("cant compare revisions of %s repos", lv.Cmd.Name)
+	}
+	if from == "" {
+		if from, err = lv.GetRev(ctx); err != nil {
+			return 0, 0, err
+		}
+	}
+	replacer := strings.NewReplacer("{from}", from, "{rev}", rev)
+	args := make([]string, 0, len(count)-1)
+	for _, arg := range count[1:] {
+		args = append(args, replacer.Replace(arg))
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), count[0], args...).CombinedOutput()
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error comparing %s to %s %s", from, rev, result)
+	}
+	fields := strings.Fields(string(result))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("Error comparing %s to %s, unexpected output %s", from, rev, result)
+	}
+	if ahead, err = strconv.Atoi(fields[0]); err == nil {
+		behind, err = strconv.Atoi(fields[1])
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error comparing %s to %s %s", from, rev, err.Error())
+	}
+	return ahead, behind, nil
+}
+
+// ResolveRev returns the revision rev names in the local repo using
+// ResolveCmds, e.g. the commit of a tag. Rev is returned unchanged if
+// the vcs has no ResolveCmds entry. An error is returned if rev is
+// not in the local repo.
+func (lv *LocalVCS) ResolveRev(ctx context.Context, rev string) (string, error) {
+	if lv.Cmd == nil {
+		return rev, nil
+	}
+	resolve := ResolveCmds[lv.Cmd.Name]
+	if resolve == nil {
+		return rev, nil
+	}
+	args := make([]string, 0, len(resolve)-1)
+	for _, arg := range resolve[1:] {
+		args = append(args, strings.Replace(arg, "{rev}", rev, -1))
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), resolve[0], args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Error resolving %s %s", rev, result)
+	}
+	return strings.TrimSpace(string(result)), nil
+}
+
+// RemoteTags returns the revision of each tag of the remote of the
+// local repo using RemoteTagCmds. An error is returned if the vcs has
+// no RemoteTagCmds entry.
+func (lv *LocalVCS) RemoteTags(ctx context.Context) (map[string]string, error) {
+	if lv.Cmd == nil {
+		return nil, nil
+	}
+	list := RemoteTagCmds[lv.Cmd.Name]
+	if list == nil {
+		return nil, fmt.Errorf("cant list remote tags of %s repos", lv.Cmd.Name)
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), list[0], list[1:]...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing remote tags %s", result)
+	}
+	tags := make(map[string]string)
+	peeled := NewStringSet()
+	for _, line := range strings.Split(string(result), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[len(fields)-1], "refs/tags/")
+		if strings.HasSuffix(tag, "^{}") {
+			tag = strings.TrimSuffix(tag, "^{}")
+			peeled.Add(tag)
+		} else if peeled[tag] {
+			continue
+		}
+		tags[tag] = fields[0]
+	}
+	return tags, nil
+}
+
+// RemoteHead returns the revision of branch on the remote of the local
+// repo, or of its default branch if branch is empty, using
+// RemoteHeadCmds. An error is returned if the vcs has no
+// RemoteHeadCmds entry or the remote has no such branch.
+func (lv *LocalVCS) RemoteHead(ctx context.Context, branch string) (string, error) {
+	if lv.Cmd == nil {
+		return "", nil
+	}
+	head := RemoteHeadCmds[lv.Cmd.Name]
+	if head == nil {
+		return "", fmt.Errorf("cant read remote branches of %s repos", lv.Cmd.Name)
+	}
+	if branch == "" {
+		branch = DefaultBranches[lv.Cmd.Name]
+	}
+	args := make([]string, 0, len(head)-1)
+	for _, arg := range head[1:] {
+		args = append(args, strings.Replace(arg, "{branch}", branch, -1))
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), head[0], args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("Error reading remote branch %s %s", branch, err.Error())
+	}
+	var rev string
+	for _, line := range strings.Split(string(result), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Prefer the branch to tags or other refs of the same name
+		if rev == "" || fields[len(fields)-1] == "refs/heads/"+branch {
+			rev = fields[0]
+		}
+	}
+	if rev == "" {
+		return "", fmt.Errorf("remote has no branch %s", branch)
+	}
+	return rev, nil
+}
+
+// Describe returns a human readable version of the local repo using
+// DescribeCmds. An error is returned if the vcs has no DescribeCmds
+// entry.
+func (lv *LocalVCS) Describe(ctx context.Context) (string, error) {
+	if lv.Cmd == nil {
+		return "", nil
+	}
+	describe := DescribeCmds[lv.Cmd.Name]
+	if describe == nil {
+		return "", fmt.Errorf("cant describe %s repos", lv.Cmd.Name)
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), describe[0], describe[1:]...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Error describing revision %s", result)
+	}
+	return strings.TrimSpace(string(result)), nil
+}
 
+// Tags returns the revision of each tag of the local repo using
+// TagCmds. An error is returned if the vcs has no TagCmds entry.
+func (lv *LocalVCS) Tags(ctx context.Context) (map[string]string, error) {
+	if lv.Cmd == nil {
+		return nil, nil
+	}
+	list := TagCmds[lv.Cmd.Name]
+	if list == nil {
+		return nil, fmt.Errorf("cant list tags of %s repos", lv.Cmd.Name)
+	}
+	result, err := command(ctx, PackageSource(lv.SrcPath, lv.Root), list[0], list[1:]...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tags %s", result)
+	}
+	tags := make(map[string]string)
+	for _, line := range strings.Split(string(result), "\n") {
+		fields := strings.Fields(line)
+		// Mercurial's tip is no tag
+		if len(fields) < 2 || fields[0] == "tip" {
+			continue
+		}
+		rev := fields[len(fields)-1]
+		if i := strings.LastIndex(rev, ":"); i >= 0 {
+			rev = rev[i+1:]
+		}
+		tags[fields[0]] = rev
+	}
+	return tags, nil
 }
 
 // GetSource on a LocalVCS will attempt to determine the local repos
 // upstream source. See the RemoteCmd for each VCS for behavior.
-func (lv *LocalVCS) GetSource() (string, error) {
+func (lv *LocalVCS) GetSource(ctx context.Context) (string, error) {
 	if lv.RemoteCmd == nil {
 		return "", nil
 	}
-	return lv.RemoteCmd.Exec(PackageSource(lv.SrcPath, lv.Root))
+	return lv.RemoteCmd.Exec(ctx, PackageSource(lv.SrcPath, lv.Root))
 }
 
 // GetRoot on a LocalVCS will return PackageName for SrcPath
@@ -451,18 +878,19 @@ func (lv *LocalVCS) GetRoot() string {
 
 // GetBranch on a LocalVCS will return the branch (if any) for the
 // current local repo. If none GetBranch will return an error.
-func (lv *LocalVCS) GetBranch() (string, error) {
-	return lv.BranchCmd.Exec(PackageSource(lv.SrcPath, lv.Root))
+func (lv *LocalVCS) GetBranch(ctx context.Context) (string, error) {
+	return lv.BranchCmd.Exec(ctx, PackageSource(lv.SrcPath, lv.Root))
 }
 
 // UpdateBranch will return true if the local branch was updated,
 // false if not. Error will be non nil if an error occured during the
 // udpate.
-func (lv *LocalVCS) UpdateBranch(branch string) (updated bool, update string, err error) {
-	if !lv.RevIsBranch(branch) {
+func (lv *LocalVCS) UpdateBranch(ctx context.Context, branch string) (updated bool, update string, err error) {
+	if !lv.RevIsBranch(ctx, branch) {
 		return false, fmt.Sprintf("rev %s is not a branch", branch), nil
 	}
 	res, err := lv.BranchUpdateCmd.ExecReplace(
+		ctx,
 		PackageSource(lv.SrcPath, lv.Root),
 		map[string]string{"{branch}": branch},
 	)
@@ -494,7 +922,7 @@ var VCSTypes = []VCSType{
 // GuessVCS attempts to guess the VCS given a url. This uses the
 // VCSTypes array, checking for prefixes that match and attempting to
 // ping the VCS with the given scheme
-func GuessVCS(url string) *vcs.Cmd {
+func GuessVCS(ctx context.Context, url string) *vcs.Cmd {
 	for _, vt := range VCSTypes {
 		if !strings.HasPrefix(url, vt.Prefix) {
 			continue
@@ -502,9 +930,9 @@ func GuessVCS(url string) *vcs.Cmd {
 		path := strings.TrimPrefix(url, vt.Scheme)
 		path = strings.TrimPrefix(path, "://")
 		path = strings.TrimPrefix(path, "@")
-		LogVerbose("Pinging path %s with scheme %s for vcs %s", path, vt.Scheme, vt.VCS.Name)
-		if err := vt.VCS.Ping(vt.Scheme, path); err != nil {
-			LogVerbose("Error pinging path %s with scheme %s", path, vt.Scheme)
+		LogVerboseContext(ctx, "Pinging path %s with scheme %s for vcs %s", path, vt.Scheme, vt.VCS.Name)
+		if _, err := RunVCS(ctx, vt.VCS, ".", vt.VCS.PingCmd, "scheme", vt.Scheme, "repo", path); err != nil {
+			LogVerboseContext(ctx, "Error pinging path %s with scheme %s", path, vt.Scheme)
 			continue
 		}
 		return vt.VCS
@@ -521,35 +949,85 @@ type PackageVCS struct {
 }
 
 // UpdateBranch will attempt to construct a local vcs and update that.
-func (pv *PackageVCS) UpdateBranch(branch string) (updated bool, update string, err error) {
+func (pv *PackageVCS) UpdateBranch(ctx context.Context, branch string) (updated bool, update string, err error) {
 	lv := NewLocalVCS(pv.Repo.Root, pv.Repo.Root, pv.Gopath, pv.Repo.VCS)
-	return lv.UpdateBranch(branch)
+	return lv.UpdateBranch(ctx, branch)
 }
 
 // Create clones the VCS into the location provided by Repo.Root
-func (pv *PackageVCS) Create(rev string) error {
+func (pv *PackageVCS) Create(ctx context.Context, rev string) error {
 	v := pv.Repo.VCS
 	dir := PackageSource(pv.Gopath, pv.Repo.Root)
-	if err := v.Create(dir, pv.Repo.Repo); err != nil {
+	if _, err := RunVCS(ctx, v, ".", v.CreateCmd, "dir", dir, "repo", pv.Repo.Repo); err != nil {
 		return err
 	}
 	if rev == "" {
 		return nil
 	}
-	return pv.SetRev(rev)
+	return pv.SetRev(ctx, rev)
 }
 
 // SetRev changes the revision of the Repo.Root to the value
 // provided. This also modifies the git based vcs to be able to deal
 // with non named revisions (sigh).
-func (pv *PackageVCS) SetRev(rev string) error {
+func (pv *PackageVCS) SetRev(ctx context.Context, rev string) error {
 	lv := NewLocalVCS(pv.Repo.Root, pv.Repo.Root, pv.Gopath, pv.Repo.VCS)
-	return lv.TagSync(rev)
+	if err := lv.TagSync(ctx, rev); err != nil {
+		return &RevisionError{Root: pv.Repo.Root, Revision: rev, Err: err}
+	}
+	return nil
+}
+
+// CreateShallow fetches only the revision rev of the repo, without
+// its history, into the location provided by Repo.Root using
+// ShallowCreateCmds. An error is returned if the vcs has no
+// ShallowCreateCmds entry or the revision can not be fetched alone,
+// such as an abbreviated commit id.
+func (pv *PackageVCS) CreateShallow(ctx context.Context, rev string) error {
+	v := pv.Repo.VCS
+	cmds := ShallowCreateCmds[v.Cmd]
+	if cmds == nil || rev == "" {
+		return fmt.Errorf("cant fetch %s without its history", pv.Repo.Root)
+	}
+	dir := PackageSource(pv.Gopath, pv.Repo.Root)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ref := rev
+	if tagRef, ok := ShallowTagRefs[v.Cmd]; ok && pv.remoteTagExists(ctx, rev) {
+		ref = strings.Replace(tagRef, "{rev}", rev, -1)
+	}
+	for _, cmdline := range cmds {
+		if _, err := RunVCS(ctx, v, dir, cmdline, "repo", pv.Repo.Repo, "rev", rev, "ref", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteTagExists returns true if rev is a tag of the repo, see
+// RemoteTagExistsCmds.
+func (pv *PackageVCS) remoteTagExists(ctx context.Context, rev string) bool {
+	list := RemoteTagExistsCmds[pv.Repo.VCS.Cmd]
+	if list == nil {
+		return false
+	}
+	replacer := strings.NewReplacer("{repo}", pv.Repo.Repo, "{rev}", rev)
+	args := make([]string, 0, len(list)-1)
+	for _, arg := range list[1:] {
+		args = append(args, replacer.Replace(arg))
+	}
+	result, err := command(ctx, "", list[0], args...).Output()
+	if err != nil {
+		LogVerboseContext(ctx, "Cant list tag %s of %s %s", rev, pv.Repo.Repo, err.Error())
+		return false
+	}
+	return len(strings.TrimSpace(string(result))) > 0
 }
 
 // GetRev does not work on remote VCS's and will always return a not
 // implemented error.
-func (pv *PackageVCS) GetRev() (string, error) {
+func (pv *PackageVCS) GetRev(ctx context.Context) (string, error) {
 	return "", errors.New("package VCS currently does not support GetRev")
 }
 
@@ -559,13 +1037,13 @@ func (pv *PackageVCS) GetRoot() string {
 }
 
 // GetSource returns the pv.Repo.Repo
-func (pv *PackageVCS) GetSource() (string, error) {
+func (pv *PackageVCS) GetSource(ctx context.Context) (string, error) {
 	return pv.Repo.Repo, nil
 }
 
 // GetBranch does not work on remote VCS for now and will return an
 // error.
-func (pv *PackageVCS) GetBranch() (string, error) {
+func (pv *PackageVCS) GetBranch(ctx context.Context) (string, error) {
 	return "", errors.New("package VCS currently does not support GetBranch")
 }
 
@@ -592,12 +1070,10 @@ func (re ResolutionFailureError) Error() string {
 }
 
 // ResolutionFailureErr will return non nil if a RepoResolver could not
-// resolve a VCS.
+// resolve a VCS, even if err wraps the failure.
 func ResolutionFailureErr(err error) *ResolutionFailureError {
-	if err == nil {
-		return nil
-	}
-	if re, ok := err.(*ResolutionFailureError); ok {
+	var re *ResolutionFailureError
+	if errors.As(err, &re) {
 		return re
 	}
 	return nil
@@ -606,7 +1082,7 @@ func ResolutionFailureErr(err error) *ResolutionFailureError {
 // RepoResolver provides the mechanisms for resolving a VCS from an
 // importpath and sourceUrl.
 type RepoResolver interface {
-	ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error)
+	ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error)
 }
 
 // DefaultRepoResolver attempts to resolve a repo using the go
@@ -629,26 +1105,29 @@ func TrimPathToRoot(importPath, root string) (string, error) {
 
 // ResolveRepo on a default reporesolver is effectively go get wraped
 // to use the url string.
-func (dr *DefaultRepoResolver) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (dr *DefaultRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
 	// We guess our vcs based off our url path if present
 	resolvePath := getResolvePath(importPath)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	LogVerbose("Attempting to use go get vcs for url: %s", resolvePath)
+	LogVerboseContext(ctx, "Attempting to use go get vcs for url: %s", resolvePath)
 	vcs.Verbose = Verbose
-	repo, err := vcs.RepoRootForImportPath(resolvePath, true)
+	repo, err := RepoRootForImportPath(ctx, resolvePath)
 	if err != nil {
-		LogVerbose("Failed creating VCS for url: %s, err: %s", resolvePath, err.Error())
+		LogVerboseContext(ctx, "Failed creating VCS for url: %s, err: %s", resolvePath, err.Error())
 		return nil, err
 	}
 
 	// If we found something return non nil
 	repo.Root, err = TrimPathToRoot(importPath, repo.Root)
 	if err != nil {
-		LogVerbose("Failed creating VCS for url: %s, err: %s", resolvePath, err.Error())
+		LogVerboseContext(ctx, "Failed creating VCS for url: %s, err: %s", resolvePath, err.Error())
 		return nil, err
 	}
 	v := &PackageVCS{Repo: repo, Gopath: dr.Gopath}
-	LogVerbose("Created VCS for url: %s", resolvePath)
+	LogVerboseContext(ctx, "Created VCS for url: %s", resolvePath)
 	return v, nil
 }
 
@@ -660,14 +1139,14 @@ type RemoteRepoResolver struct {
 
 // ResolveRepo on the remoterepo resolver uses our own GuessVCS
 // method. It mostly looks at protocol cues like svn:// and git@.
-func (rr *RemoteRepoResolver) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (rr *RemoteRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
 	resolvePath := getResolvePath(importPath)
 	if dep != nil && dep.SourcePath != "" {
 		resolvePath = getResolvePath(dep.SourcePath)
 	}
 	// Attempt our internal guessing logic first
-	LogVerbose("Attempting to use default resolver for url: %s", resolvePath)
-	v := GuessVCS(resolvePath)
+	LogVerboseContext(ctx, "Attempting to use default resolver for url: %s", resolvePath)
+	v := GuessVCS(ctx, resolvePath)
 	if v == nil {
 		return nil, NewResolutionFailureError(importPath, "remote")
 	}
@@ -697,7 +1176,8 @@ func getResolvePath(importPath string) string {
 
 // LocalRepoResolver will attempt to find local copies of a repo in
 // LocalPath (treating it like a gopath) and provide VCS systems for
-// updating them in RemotePath (also treaded like a gopath).
+// updating them in RemotePath (also treaded like a gopath). Copies
+// matching a registered Backend are controlled with it.
 type LocalRepoResolver struct {
 	LocalPath string
 }
@@ -706,26 +1186,30 @@ type LocalRepoResolver struct {
 // *  The local package is not present (no directory) in LocalPath
 // *  The local "package" is a file in localpath
 // *  There was an error stating the directory for the localPkg
-func (lr *LocalRepoResolver) ResolveRepo(pkg string, dep *CanticleDependency) (VCS, error) {
-	LogVerbose("Finding local vcs for package: %s\n", pkg)
+func (lr *LocalRepoResolver) ResolveRepo(ctx context.Context, pkg string, dep *CanticleDependency) (VCS, error) {
+	LogVerboseContext(ctx, "Finding local vcs for package: %s\n", pkg)
 	fullPath := PackageSource(lr.LocalPath, getResolvePath(pkg))
 	s, err := os.Stat(fullPath)
 	switch {
 	case err != nil:
-		LogVerbose("Error stating local copy of package: %s %s\n", fullPath, err.Error())
+		LogVerboseContext(ctx, "Error stating local copy of package: %s %s\n", fullPath, err.Error())
 		return nil, err
 	case s != nil && s.IsDir():
+		// Registered backends know repos go/vcs does not
+		if v, err := (&BackendRepoResolver{lr.LocalPath}).ResolveRepo(ctx, pkg, dep); err == nil {
+			return v, nil
+		}
 		cmd, root, err := vcs.FromDir(fullPath, lr.LocalPath)
 		if err != nil {
-			LogVerbose("Error with local vcs: %s", err.Error())
+			LogVerboseContext(ctx, "Error with local vcs: %s", err.Error())
 			return nil, err
 		}
-		root, _ = PackageName(lr.LocalPath, path.Join(lr.LocalPath, root))
+		root, _ = PackageName(lr.LocalPath, filepath.Join(lr.LocalPath, filepath.FromSlash(root)))
 		v := NewLocalVCS(root, root, lr.LocalPath, cmd)
-		LogVerbose("Created vcs for local pkg: %+v", v)
+		LogVerboseContext(ctx, "Created vcs for local pkg: %+v", v)
 		return v, nil
 	default:
-		LogVerbose("Could not resolve local vcs for package: %s", fullPath)
+		LogVerboseContext(ctx, "Could not resolve local vcs for package: %s", fullPath)
 		return nil, NewResolutionFailureError(pkg, "local")
 	}
 }
@@ -739,12 +1223,15 @@ type CompositeRepoResolver struct {
 // ResolveRepo for the composite attempts its sub Resolvers in order
 // ignoring any errors. If all resolvers fail a ResolutionFailureError
 // will be returned.
-func (cr *CompositeRepoResolver) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (cr *CompositeRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
 	for _, r := range cr.Resolvers {
-		vcs, err := r.ResolveRepo(importPath, dep)
+		vcs, err := r.ResolveRepo(ctx, importPath, dep)
 		if vcs != nil && err == nil {
 			return vcs, nil
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 	}
 	return nil, NewResolutionFailureError(importPath, "composite")
 }
@@ -760,6 +1247,8 @@ type MemoizedRepoResolver struct {
 	sync.RWMutex
 	resolvedPaths map[string]*resolve
 	resolver      RepoResolver
+	// Logger, if non nil, is logged to while resolving.
+	Logger Logger
 }
 
 // NewMemoizedRepoResolver creates a memozied version of the passed in
@@ -773,7 +1262,8 @@ func NewMemoizedRepoResolver(resolver RepoResolver) *MemoizedRepoResolver {
 
 // ResolveRepo on a MemoizedRepoResolver will cache the results of its
 // child resolver.
-func (mr *MemoizedRepoResolver) ResolveRepo(importPath string, dep *CanticleDependency) (VCS, error) {
+func (mr *MemoizedRepoResolver) ResolveRepo(ctx context.Context, importPath string, dep *CanticleDependency) (VCS, error) {
+	ctx = withLogger(ctx, mr.Logger)
 	mr.RLock()
 	r := mr.resolvedPaths[importPath]
 	mr.RUnlock()
@@ -781,7 +1271,11 @@ func (mr *MemoizedRepoResolver) ResolveRepo(importPath string, dep *CanticleDepe
 		return r.v, r.err
 	}
 
-	v, err := mr.resolver.ResolveRepo(importPath, dep)
+	v, err := mr.resolver.ResolveRepo(ctx, importPath, dep)
+	if ctx.Err() != nil {
+		// Do not remember resolutions cut short
+		return v, err
+	}
 	mr.Lock()
 	mr.resolvedPaths[importPath] = &resolve{v, err}
 	mr.Unlock()
@@ -1,11 +1,14 @@
 package canticles
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"regexp"
+	"strings"
 	"testing"
 
 	"golang.org/x/tools/go/vcs"
@@ -17,7 +20,7 @@ func TestDefaultRepoResolver(t *testing.T) {
 	dr := &DefaultRepoResolver{os.ExpandEnv("$GOPATH")}
 	// Try a VCS resolution against someone supports go get syntax
 	importPath := "golang.org/x/tools/go/vcs"
-	vcs, err := dr.ResolveRepo(importPath, nil)
+	vcs, err := dr.ResolveRepo(context.Background(), importPath, nil)
 	if err != nil {
 		t.Errorf("DefaultRepoResolver returned error for golang.org repo: %s", err.Error())
 	}
@@ -42,7 +45,7 @@ func TestRemoteRepoResolver(t *testing.T) {
 		Root:       "github.com/Comcast/Canticle",
 	}
 
-	vcs, err := rr.ResolveRepo(dep.Root, dep)
+	vcs, err := rr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("RemoteRepoResolver returned error for our own repo: %s", err.Error())
 	}
@@ -64,7 +67,7 @@ func TestRemoteRepoResolver(t *testing.T) {
 		Root:       "nothere.comcast.com/viper-cog/cant",
 		SourcePath: "git@nothere.comcast.com:viper-cog/cant.git",
 	}
-	vcs, err = rr.ResolveRepo(dep.Root, dep)
+	vcs, err = rr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err == nil {
 		t.Errorf("RemoteRepoResolver returned no error for a package that does not exist")
 	}
@@ -83,7 +86,7 @@ func TestLocalRepoResolver(t *testing.T) {
 	}
 
 	pkg := "github.com/Comcast/Canticle"
-	vcs, err := lr.ResolveRepo(pkg, nil)
+	vcs, err := lr.ResolveRepo(context.Background(), pkg, nil)
 	if err != nil {
 		t.Errorf("LocalRepoResolver returned error resolving our own package %s", err.Error())
 	}
@@ -98,7 +101,7 @@ func TestLocalRepoResolver(t *testing.T) {
 
 	// Test dealing with a package whose vcs root != the importpath
 	pkg = "golang.org/x/tools/go/vcs"
-	vcs, err = lr.ResolveRepo(pkg, nil)
+	vcs, err = lr.ResolveRepo(context.Background(), pkg, nil)
 	if err != nil {
 		t.Errorf("LocalRepoResolver returned error resolving our own package %s", err.Error())
 	}
@@ -124,13 +127,13 @@ func TestResolveRootWithNoSlash(t *testing.T) {
 	}
 
 	dr := &DefaultRepoResolver{gopath}
-	_, err = dr.ResolveRepo(dep.Root, dep)
+	_, err = dr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("DefaultRepoResolver could not resolve Root that does not contain a slash: %v", err)
 	}
 
 	rr := &RemoteRepoResolver{gopath}
-	_, err = rr.ResolveRepo(dep.Root, dep)
+	_, err = rr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("RemoteRepoResolver could not resolve Root that does not contain a slash: %v", err)
 	}
@@ -146,7 +149,7 @@ func TestResolveRootWithNoSlash(t *testing.T) {
 	}
 
 	lr := &LocalRepoResolver{testHome}
-	_, err = lr.ResolveRepo(dep.Root, dep)
+	_, err = lr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("LocalRepoResolver could not resolve Root that does not contain a slash: %v", err)
 	}
@@ -162,27 +165,27 @@ type TestVCS struct {
 	Root    string
 }
 
-func (v *TestVCS) UpdateBranch(branch string) (bool, string, error) {
+func (v *TestVCS) UpdateBranch(ctx context.Context, branch string) (bool, string, error) {
 	return false, "", nil
 }
 
-func (v *TestVCS) Create(rev string) error {
+func (v *TestVCS) Create(ctx context.Context, rev string) error {
 	v.Rev = rev
 	v.Created++
 	return v.Err
 }
 
-func (v *TestVCS) SetRev(rev string) error {
+func (v *TestVCS) SetRev(ctx context.Context, rev string) error {
 	v.Rev = rev
 	v.Updated++
 	return v.Err
 }
 
-func (v *TestVCS) GetRev() (string, error) {
+func (v *TestVCS) GetRev(ctx context.Context) (string, error) {
 	return v.Rev, v.Err
 }
 
-func (v *TestVCS) GetSource() (string, error) {
+func (v *TestVCS) GetSource(ctx context.Context) (string, error) {
 	return v.Source, v.Err
 }
 
@@ -190,7 +193,7 @@ func (v *TestVCS) GetRoot() string {
 	return v.Root
 }
 
-func (v *TestVCS) GetBranch() (string, error) {
+func (v *TestVCS) GetBranch(ctx context.Context) (string, error) {
 	return v.Rev, v.Err
 }
 
@@ -204,7 +207,7 @@ type testResolver struct {
 	response    []resolve
 }
 
-func (tr *testResolver) ResolveRepo(i string, d *CanticleDependency) (VCS, error) {
+func (tr *testResolver) ResolveRepo(ctx context.Context, i string, d *CanticleDependency) (VCS, error) {
 	tr.resolutions = append(tr.resolutions, testResolve{i, d})
 	resp := tr.response[0]
 	tr.response = tr.response[1:]
@@ -221,7 +224,7 @@ func TestCompositeRepoResolver(t *testing.T) {
 	dep := &CanticleDependency{
 		Root: "testi",
 	}
-	v, err := cr.ResolveRepo(dep.Root, dep)
+	v, err := cr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("CompositeRepoResolver returned error with valid resolve %s", err.Error())
 	}
@@ -241,7 +244,7 @@ func TestCompositeRepoResolver(t *testing.T) {
 	tr1 = &testResolver{response: []resolve{{nil, errTest}}}
 	tr2 = &testResolver{response: []resolve{{nil, errTest}}}
 	cr = &CompositeRepoResolver{[]RepoResolver{tr1, tr2}}
-	v, err = cr.ResolveRepo(dep.Root, dep)
+	v, err = cr.ResolveRepo(context.Background(), dep.Root, dep)
 	if re := ResolutionFailureErr(err); re == nil {
 		t.Errorf("CompositeRepoResolver did not return resolution failure")
 	}
@@ -254,7 +257,7 @@ func TestMemoizedRepoResolver(t *testing.T) {
 	dep := &CanticleDependency{
 		Root: "testi",
 	}
-	v, err := mr.ResolveRepo(dep.Root, dep)
+	v, err := mr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("MemoizedRepoResolver returned error %s", err.Error())
 	}
@@ -265,7 +268,7 @@ func TestMemoizedRepoResolver(t *testing.T) {
 		t.Errorf("MemoizedRepoResolver did not call tr1 only once")
 	}
 
-	v, err = mr.ResolveRepo(dep.Root, dep)
+	v, err = mr.ResolveRepo(context.Background(), dep.Root, dep)
 	if err != nil {
 		t.Errorf("MemoizedRepoResolver returned error %s", err.Error())
 	}
@@ -296,7 +299,7 @@ func TestVCSCmds(t *testing.T) {
 	}
 	defer os.RemoveAll(testHome)
 
-	rev, err := TestRevCmd.Exec(testHome)
+	rev, err := TestRevCmd.Exec(context.Background(), testHome)
 	if err != nil {
 		t.Fatalf("Error running valid test exec command: %s", err.Error())
 	}
@@ -304,7 +307,7 @@ func TestVCSCmds(t *testing.T) {
 		t.Errorf("Exec not %s not match expected %s", rev, expectedRev)
 	}
 
-	rev, err = TestRevCmd.Exec("someinvaliddir")
+	rev, err = TestRevCmd.Exec(context.Background(), "someinvaliddir")
 	if err == nil {
 		t.Fatalf("No Error running invalid test exec command")
 	}
@@ -313,7 +316,7 @@ func TestVCSCmds(t *testing.T) {
 	}
 
 	TestRevCmd.Args = []string{"this should be invalid"}
-	rev, err = TestRevCmd.Exec(testHome)
+	rev, err = TestRevCmd.Exec(context.Background(), testHome)
 	if err == nil {
 		t.Fatalf("No Error running invalid test exec command")
 	}
@@ -358,7 +361,7 @@ func TestLocalVCS(t *testing.T) {
 	}
 
 	v := NewLocalVCS(childpkg, pkgname, testHome, TestVCSCmd)
-	rev, err := v.GetRev()
+	rev, err := v.GetRev(context.Background())
 	if err != nil {
 		t.Fatalf("Local vcs should not return error with no rev command")
 	}
@@ -368,10 +371,10 @@ func TestLocalVCS(t *testing.T) {
 
 	RevCmds[TestRevCmd.Name] = TestRevCmd
 	v = NewLocalVCS(childpkg, pkgname, testHome, TestVCSCmd)
-	v.Branches = func(path string) ([]string, error) {
+	v.Branches = func(ctx context.Context, path string) ([]string, error) {
 		return []string{"testrev"}, nil
 	}
-	rev, err = v.GetRev()
+	rev, err = v.GetRev(context.Background())
 	if err != nil {
 		t.Errorf("Error getting valid rev: %s", err.Error())
 	}
@@ -379,10 +382,215 @@ func TestLocalVCS(t *testing.T) {
 		t.Errorf("Rev not %s not match expected %s", rev, expectedRev)
 	}
 
-	if err := v.Create(""); err != nil {
+	if err := v.Create(context.Background(), ""); err != nil {
 		t.Errorf("Error running create command with no revision: %s", err.Error())
 	}
-	if err = v.SetRev("testrev"); err != nil {
+	if err = v.SetRev(context.Background(), "testrev"); err != nil {
 		t.Errorf("Error setting rev to testrev: %s", err.Error())
 	}
 }
+
+func TestLocalVCSIsDirty(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test-src")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgname := "test.com/test"
+	src := PackageSource(testHome, pkgname)
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	cmd := exec.Command("git", "init")
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error running git init %s %s", err.Error(), string(out))
+	}
+
+	v := NewLocalVCS(pkgname, pkgname, testHome, vcs.ByCmd("git"))
+	dirty, err := v.IsDirty(context.Background())
+	if err != nil {
+		t.Fatalf("Error checking status of clean repo: %s", err.Error())
+	}
+	if dirty {
+		t.Errorf("Expected empty repo to be clean")
+	}
+	if err := ioutil.WriteFile(path.Join(src, "new.go"), []byte("package test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err = v.IsDirty(context.Background()); err != nil || !dirty {
+		t.Errorf("Expected repo with untracked file to be dirty got %v %v", dirty, err)
+	}
+}
+
+func TestLocalVCSDescribe(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test-src")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	pkgname := "test.com/test"
+	src := PackageSource(testHome, pkgname)
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(src, "test.go"), []byte("package test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"add", "test.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@test.com", "commit", "-m", "test"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = src
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+	}
+
+	v := NewLocalVCS(pkgname, pkgname, testHome, vcs.ByCmd("git"))
+	version, err := v.Describe(context.Background())
+	if err != nil {
+		t.Fatalf("Error describing repo: %s", err.Error())
+	}
+	if version != "v1.0.0" {
+		t.Errorf("Expected version v1.0.0 got %s", version)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "test.go"), []byte("package changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if version, err = v.Describe(context.Background()); err != nil || version != "v1.0.0-dirty" {
+		t.Errorf("Expected version v1.0.0-dirty got %s %v", version, err)
+	}
+}
+
+func TestVerifyGitSignature(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	gpghome := path.Join(testHome, "gnupg")
+	src := path.Join(testHome, "repo")
+	for _, dir := range []string{gpghome, src} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(name string, args ...string) string {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = src
+		cmd.Env = PatchEnviroment(os.Environ(), "GNUPGHOME", gpghome)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running %s %v %s %s", name, args, err.Error(), string(out))
+		}
+		return string(out)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "a.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git := []string{"-c", "user.name=test", "-c", "user.email=test@test.com"}
+	run("git", "init")
+	run("git", "add", "a.go")
+	run("git", append(git, "commit", "-m", "unsigned")...)
+	run("git", append(git, "tag", "-a", "-m", "unsigned", "v1")...)
+	for _, rev := range []string{"", "v1"} {
+		if err := VerifyGitSignature(context.Background(), src, rev, gpghome, nil); err == nil {
+			t.Errorf("Expected unsigned rev %q to fail verification", rev)
+		}
+	}
+	if err := VerifyGitSignature(context.Background(), src, "v1", "", nil); err == nil {
+		t.Errorf("Expected error verifying without trusted keys")
+	}
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed, not verifying signed tags")
+	}
+	defer exec.Command("gpgconf", "--homedir", gpghome, "--kill", "gpg-agent").Run()
+	run("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "test <test@test.com>", "ed25519", "sign", "never")
+	run("git", append(git, "tag", "-s", "-m", "signed", "v2")...)
+	if err := VerifyGitSignature(context.Background(), src, "v2", gpghome, nil); err != nil {
+		t.Errorf("Expected signed tag to verify got %s", err.Error())
+	}
+	var fingerprint string
+	for _, line := range strings.Split(run("gpg", "--with-colons", "--list-keys"), "\n") {
+		if fields := strings.Split(line, ":"); len(fields) > 9 && fields[0] == "fpr" && fingerprint == "" {
+			fingerprint = fields[9]
+		}
+	}
+	if err := VerifyGitSignature(context.Background(), src, "v2", gpghome, []string{fingerprint}); err != nil {
+		t.Errorf("Expected tag signed by the trusted key to verify got %s", err.Error())
+	}
+	if err := VerifyGitSignature(context.Background(), src, "v2", gpghome, []string{"0123456789ABCDEF0123456789ABCDEF01234567"}); err == nil {
+		t.Errorf("Expected tag signed by an untrusted key to fail verification")
+	}
+
+	// The checkout is verified, not the tag
+	run("git", append(git, "commit", "--allow-empty", "-m", "unsigned")...)
+	if err := VerifyGitSignature(context.Background(), src, "v2", gpghome, nil); err == nil {
+		t.Errorf("Expected unsigned checkout to fail verification though its tag is signed")
+	}
+}
+
+func TestPackageVCSCreateShallow(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	upstream := path.Join(testHome, "upstream")
+	if err := os.MkdirAll(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running git %v %s %s", args, err.Error(), string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(upstream, "init", "-q")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "first")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "second")
+	second := git(upstream, "rev-parse", "HEAD")
+	git(upstream, "commit", "-q", "--allow-empty", "-m", "third")
+
+	gopath := path.Join(testHome, "gopath")
+	pv := &PackageVCS{
+		Repo:   &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: "file://" + upstream, Root: "test.com/test"},
+		Gopath: gopath,
+	}
+	if err := pv.CreateShallow(context.Background(), second); err != nil {
+		t.Fatalf("Error fetching %s shallowly %s", second, err.Error())
+	}
+	dir := PackageSource(gopath, "test.com/test")
+	if rev := git(dir, "rev-parse", "HEAD"); rev != second {
+		t.Errorf("Expected shallow checkout at %s got %s", second, rev)
+	}
+	if count := git(dir, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("Expected one revision fetched got %s", count)
+	}
+
+	os.RemoveAll(dir)
+	if err := pv.CreateShallow(context.Background(), second[:7]); err == nil {
+		t.Errorf("Expected abbreviated revision to not be fetched shallowly")
+	}
+
+	// Tags are fetched as tags
+	git(upstream, "tag", "-a", "-m", "release", "v1.0.0", second)
+	os.RemoveAll(dir)
+	if err := pv.CreateShallow(context.Background(), "v1.0.0"); err != nil {
+		t.Fatalf("Error fetching v1.0.0 shallowly %s", err.Error())
+	}
+	if rev := git(dir, "rev-parse", "HEAD"); rev != second {
+		t.Errorf("Expected shallow checkout of v1.0.0 at %s got %s", second, rev)
+	}
+	if rev := git(dir, "rev-parse", "refs/tags/v1.0.0^{commit}"); rev != second {
+		t.Errorf("Expected tag v1.0.0 fetched at %s got %s", second, rev)
+	}
+}
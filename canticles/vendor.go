@@ -1,28 +1,56 @@
 package canticles
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
 )
 
 type Vendor struct {
 	flags    *flag.FlagSet
 	Verbose  bool
 	Sources  string
+	Flatten  bool
+	OnDisk   bool
+	Copy     bool
+	Only     bool
+	Prune    StringSet
+	Depth    int
+	Shallow  bool
+	Jobs     int
 	Resolver ConflictResolver
+	// AllowViolations vendors dependencies forbidden by the
+	// source policy of the package, recording them as exceptions.
+	AllowViolations bool
+
+	// Gopath, if set, is vendored into instead of the gopath of
+	// EnvGoPath.
+	Gopath string
 }
 
 func NewVendor() *Vendor {
 	f := flag.NewFlagSet("save", flag.ExitOnError)
 	s := &Vendor{
 		flags:    f,
-		Resolver: &PromptResolution{},
+		Prune:    NewStringSet(),
+		Resolver: &PromptResolution{Printf: fmt.Printf, Scanf: fmt.Scanf},
 	}
 	f.BoolVar(&s.Verbose, "v", false, "Be verbose when getting stuff")
 	f.StringVar(&s.Sources, "s", "", "Use this canticle file to source repos.")
+	f.BoolVar(&s.Flatten, "flatten", false, "Flatten the vendor directories of dependencies into the gopath, keeping one revision of each.")
+	f.BoolVar(&s.Copy, "copy", false, "Copy the dependencies into the vendor directory of the package, without their VCS metadata.")
+	f.BoolVar(&s.Only, "only", false, "Fetch the dependencies into a temporary gopath and copy them into the vendor directory of the package only.")
+	f.Var(s.Prune, "prune", "Do not copy files matching this pattern, or in the tests, testdata or docs set, into vendor, may be repeated.")
+	f.IntVar(&s.Depth, "depth", 0, "Only fetch dependencies this many imports from the package, 1 fetches only its direct dependencies.")
+	f.BoolVar(&s.Shallow, "shallow", false, "Fetch dependencies at their pinned revision without their history where possible.")
+	f.BoolVar(&s.AllowViolations, "allow-violations", false, "Vendor dependencies forbidden by the source policy, recording them as exceptions.")
+	f.IntVar(&s.Jobs, "j", runtime.GOMAXPROCS(0), "The number of repos fetched at once.")
+	f.BoolVar(&s.OnDisk, "ondisk", false, "When flattening keep the revision at the top level of the gopath, or the first copy, instead of prompting.")
 	return s
 }
 
@@ -30,22 +58,43 @@ var vendor = NewVendor()
 
 var VendorCommand = &Command{
 	Name:             "vendor",
-	UsageLine:        "vendor [-v] [-s sourcefile]",
+	UsageLine:        "vendor [-v] [-s sourcefile] [-flatten] [-ondisk] [-copy] [-only] [-prune <pattern>] [-depth <n>] [-shallow] [-j <n>] [-allow-violations]",
 	ShortDescription: "Download the all dependencies of a project.",
 	LongDescription: `The vendor command will download all dependencies of a package in its go and Canticle dependency graph.
 
 Specify -v to print out a verbose set of operations instead of just errors.
 
-Specify -s <filename>, where filename contains Canticle deps to specify alternative sources to fetch packages from.`,
+Specify -s <filename>, where filename contains Canticle deps to specify alternative sources to fetch packages from. Deps with a TreeHash, see cant save -hash, are fetched at their Revision and vendoring fails if their files do not match it.
+
+Specify -flatten to flatten the vendor directories of the dependencies fetched. Each dependency vendored is kept once at the top level of the gopath and the vendored copies are removed. When the copies and the dependency already at the top level are at different revisions, those pinned by the Canticle files of the vendoring dependencies, the revision kept is prompted for. The choice made for each dependency is printed, including how many of the copies removed were identical.
+
+Specify -ondisk with -flatten to keep the revision already at the top level, or the copy pinned at no revision or else the first, instead of prompting.
+
+Specify -copy to copy each dependency into the vendor directory of the package once fetched, replacing any copy already there. Hidden files, including .git and .hg directories, are not copied.
+
+Specify -only to vendor without touching the gopath. The dependencies are fetched into a temporary gopath, holding a link to the package's repo, at the Revision pinned by the -s file, or else the Canticle file of the package's repo, and each is copied into the vendor directory of the package as -copy does. The temporary gopath is removed once done. -only can not be used with -flatten.
+
+Specify -prune '*_test.go' with -copy or -only to not copy files, or directories, matching the pattern. Patterns without a slash match a files name, others its path within the dependency. The sets tests (*_test.go), testdata (testdata directories), docs (markdown files, doc and example directories) and nongo (every file the go tool does not build from, such as images and scripts) may be given by name. The VendorPrune list of the packages .canticle.json is used too. License, notice and authors files are never pruned.
+
+Specify -depth 1 to fetch only the direct dependencies of the package, -depth 2 their dependencies too and so on, e.g. to audit them without fetching every transitive dependency.
+
+Specify -shallow to fetch each dependency pinned at a Revision without its history, which is faster for large repos. Git fetches just the revision, which must be a full commit id, a tag or a branch, and Mercurial fetches it and its ancestors only. Dependencies whose revision can not be fetched this way are fetched with their history. Shallow git repos in the gopath can be completed with git fetch --unshallow.
+
+Specify -j 8 to fetch up to 8 repos at once, the default is the number of CPUs. The output of each fetch is printed together once it is done, and the error of every repo which could not be fetched is printed.
+
+Dependencies whose source is forbidden by the Canticle.policy of the package, as saved or as resolved, are refused. Specify -allow-violations to vendor them anyway, recording those of the Canticle file as exceptions in the policy file.`,
 	Flags: vendor.flags,
 	Cmd:   vendor,
 }
 
-func (v *Vendor) Run(args []string) {
+func (v *Vendor) Run(ctx context.Context, args []string) {
 	if v.Verbose {
 		Verbose = true
 	}
 	defer func() { Verbose = false }()
+	if v.OnDisk {
+		v.Resolver = &PreferLocalResolution{}
+	}
 
 	var deps []*CanticleDependency
 	if v.Sources != "" {
@@ -54,7 +103,7 @@ func (v *Vendor) Run(args []string) {
 			log.Fatalf("cant open dep file %s", v.Sources)
 			return
 		}
-		LogVerbose("Reading canticle file: %s", f.Name())
+		LogVerboseContext(ctx, "Reading canticle file: %s", f.Name())
 		defer f.Close()
 		d := json.NewDecoder(f)
 		if err := d.Decode(&deps); err != nil {
@@ -64,35 +113,166 @@ func (v *Vendor) Run(args []string) {
 	}
 
 	for _, pkg := range v.flags.Args() {
-		LogWarn("Vendoring package %s", pkg)
-		if err := v.Vendor(pkg, deps); err != nil {
-			log.Fatal(err)
+		LogWarnContext(ctx, "Vendoring package %s", pkg)
+		if err := v.Vendor(ctx, pkg, deps); err != nil {
+			log.Fatal(ErrorMessage(err))
 		}
 	}
 }
 
-func (v *Vendor) Vendor(pkg string, deps []*CanticleDependency) error {
-	LogVerbose("Fetching pkg %+v", pkg)
-	gopath, err := EnvGoPath()
+func (v *Vendor) Vendor(ctx context.Context, pkg string, deps []*CanticleDependency) error {
+	LogVerboseContext(ctx, "Fetching pkg %+v", pkg)
+	gopath := v.Gopath
+	if gopath == "" {
+		var err error
+		if gopath, err = EnvGoPath(); err != nil {
+			return err
+		}
+	}
+	projectDir := PackageSource(gopath, pkg)
+	config, err := LoadProjectConfig(projectDir)
+	if err != nil {
+		return err
+	}
+	secure := Secure.Merge(config)
+	fe, err := NewFetchEnv(TLS.Merge(config.TLS, projectDir), secure)
+	if err != nil {
+		return err
+	}
+	defer fe.Close()
+	ctx = fe.Context(ctx)
+	fetchPath := gopath
+	var stage *IsolatedProject
+	if v.Only {
+		if v.Flatten {
+			return fmt.Errorf("cant vendor %s with both -only and -flatten", pkg)
+		}
+		var err error
+		if stage, err = v.Stage(ctx, gopath, pkg); err != nil {
+			return err
+		}
+		defer os.RemoveAll(stage.Gopath)
+		fetchPath = stage.Gopath
+		if deps == nil {
+			if deps, err = SavedDependencies(stage.Dir); err != nil {
+				return err
+			}
+		}
+	}
+	policy, err := LoadSourcePolicy(projectDir)
 	if err != nil {
 		return err
 	}
-	resolvers := []RepoResolver{
-		&LocalRepoResolver{LocalPath: gopath},
-		&RemoteRepoResolver{gopath},
-		&DefaultRepoResolver{gopath},
+	if err := policy.EnforcePolicy(projectDir, deps, v.AllowViolations); err != nil {
+		return err
 	}
-	resolver := NewMemoizedRepoResolver(&CompositeRepoResolver{resolvers})
-	depReader := &DepReader{gopath}
+	resolver, remote := NewFetchResolver(fetchPath, secure, policy, v.AllowViolations)
+	defer func() {
+		if err := remote.Save(); err != nil {
+			LogWarnContext(ctx, "Error saving resolution cache: %s", err.Error())
+		}
+	}()
+	depReader := &DepReader{Gopath: fetchPath}
 
 	// Setup our resolvers, loaders, and walkers
-	dl := NewDependencyLoader(resolver, depReader.AllDeps, deps, gopath)
+	dl := NewDependencyLoader(resolver, depReader.AllDeps, deps, fetchPath)
+	dl.Shallow = v.Shallow
+	dl.Workers = v.Jobs
+	if stage != nil {
+		dir := PackageSource(gopath, pkg)
+		prune, err := v.prune(dir)
+		if err != nil {
+			return err
+		}
+		dl.VendorDir, dl.VendorPrune = VendorDir(dir), prune
+	}
 	dw := NewDependencyWalker(dl.PackageImports, dl.FetchUpdatePackage)
+	dw.Prefetch = dl.Prefetch
+	dw.MaxDepth = v.Depth
 
 	// And walk it
-	if err := dw.TraverseDependencies(pkg); err != nil {
-		return fmt.Errorf("cant fetch packages %s", err.Error())
+	if err := dw.TraverseDependencies(ctx, pkg); err != nil {
+		return fmt.Errorf("cant fetch packages %w", err)
+	}
+	if stage != nil || (!v.Flatten && !v.Copy) {
+		return nil
 	}
 
+	localResolver := NewMemoizedRepoResolver(&LocalRepoResolver{gopath})
+	roots := NewStringSet()
+	for _, dep := range dl.Dependencies() {
+		if vcs, err := localResolver.ResolveRepo(ctx, dep.ImportPath, nil); err == nil {
+			roots.Add(vcs.GetRoot())
+		}
+	}
+	if v.Flatten {
+		vf := NewVendorFlattener(gopath, v.Resolver)
+		vf.RepoResolver = localResolver
+		if _, err := vf.Flatten(ctx, roots.Array()); err != nil {
+			return err
+		}
+	}
+	if v.Copy {
+		return v.CopyDeps(ctx, gopath, pkg, roots.Array())
+	}
 	return nil
 }
+
+// CopyDeps copies the dependencies roots of pkg into its vendor
+// directory, leaving out the root of pkg.
+func (v *Vendor) CopyDeps(ctx context.Context, gopath, pkg string, roots []string) error {
+	dir := PackageSource(gopath, pkg)
+	prune, err := v.prune(dir)
+	if err != nil {
+		return err
+	}
+	vendor := VendorDir(dir)
+	for _, root := range roots {
+		if root == pkg || PathIsChild(root, pkg) {
+			continue
+		}
+		LogVerboseContext(ctx, "Copying %s into %s", root, vendor)
+		if err := CopyToVendor(gopath, vendor, root, prune); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune returns the prune patterns for the package in dir, those of
+// -prune and the VendorPrune of its .canticle.json.
+func (v *Vendor) prune(dir string) ([]string, error) {
+	config, err := LoadProjectConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	prune := append(v.Prune.Array(), config.VendorPrune...)
+	if err := CheckPrune(prune); err != nil {
+		return nil, err
+	}
+	return prune, nil
+}
+
+// Stage returns a temporary gopath, as an IsolatedProject linking the
+// repo of pkg in gopath into it, to vendor pkg with -only. The caller
+// removes its Gopath.
+func (v *Vendor) Stage(ctx context.Context, gopath, pkg string) (*IsolatedProject, error) {
+	if _, err := os.Stat(PackageSource(gopath, pkg)); err != nil {
+		return nil, fmt.Errorf("cant vendor %s, it is not in the gopath %s", pkg, err.Error())
+	}
+	root := pkg
+	if vcs, err := (&LocalRepoResolver{LocalPath: gopath}).ResolveRepo(ctx, pkg, nil); err == nil {
+		root = vcs.GetRoot()
+	}
+	staging, err := ioutil.TempDir("", "cant-vendor")
+	if err != nil {
+		return nil, err
+	}
+	stage := &IsolatedProject{Dir: PackageSource(gopath, root), ImportPath: root, Gopath: staging}
+	if err := stage.Link(); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	LogVerboseContext(ctx, "Staging %s in %s", root, staging)
+	return stage, nil
+}
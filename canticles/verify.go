@@ -0,0 +1,213 @@
+package canticles
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Behaviours of a LicensePolicy for dependencies without a detected
+// license.
+const (
+	UnknownAllow = "allow"
+	UnknownWarn  = "warn"
+	UnknownDeny  = "deny"
+)
+
+// A LicensePolicy restricts the licenses, as SPDX identifiers, of
+// dependencies. It is stored as the Licenses of the SourcePolicy in
+// the PolicyFile.
+type LicensePolicy struct {
+	// Allow are the licenses dependencies may have. If empty any
+	// license not denied is allowed.
+	Allow []string `json:",omitempty"`
+	// Deny are the forbidden licenses.
+	Deny []string `json:",omitempty"`
+	// Unknown is what to do with dependencies whose license is not
+	// detected: UnknownAllow, UnknownWarn, the default, or
+	// UnknownDeny.
+	Unknown string `json:",omitempty"`
+}
+
+// Validate returns an error if Unknown is not empty, UnknownAllow,
+// UnknownWarn or UnknownDeny. A nil policy is valid.
+func (lp *LicensePolicy) Validate() error {
+	if lp == nil {
+		return nil
+	}
+	switch lp.Unknown {
+	case "", UnknownAllow, UnknownWarn, UnknownDeny:
+		return nil
+	}
+	return fmt.Errorf("invalid Unknown license behaviour %q, it must be %s, %s or %s", lp.Unknown, UnknownAllow, UnknownWarn, UnknownDeny)
+}
+
+// LoadLicensePolicy reads the LicensePolicy of the project at path.
+// If no policy file is present, or it has no Licenses, nil is
+// returned.
+func LoadLicensePolicy(path string) (*LicensePolicy, error) {
+	policy, err := LoadSourcePolicy(path)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	return policy.Licenses, nil
+}
+
+// Violations returns a description of each cdep whose license in
+// gopath the policy forbids. A nil policy allows everything.
+func (lp *LicensePolicy) Violations(gopath string, cdeps []*CanticleDependency) []string {
+	if lp == nil {
+		return nil
+	}
+	allow, deny := NewStringSet(), NewStringSet()
+	allow.Add(lp.Allow...)
+	deny.Add(lp.Deny...)
+	var violations []string
+	for _, cdep := range cdeps {
+		license := DetectLicense(PackageSource(gopath, cdep.Root))
+		switch {
+		case license == NoAssertion && lp.Unknown == UnknownAllow:
+		case license == NoAssertion && lp.Unknown == UnknownDeny:
+			violations = append(violations, fmt.Sprintf("%s has no detected license", cdep.Root))
+		case license == NoAssertion:
+			LogWarn("%s has no detected license", cdep.Root)
+		case deny[license]:
+			violations = append(violations, fmt.Sprintf("%s has forbidden license %s", cdep.Root, license))
+		case len(allow) > 0 && !allow[license]:
+			violations = append(violations, fmt.Sprintf("%s has license %s which is not allowed", cdep.Root, license))
+		}
+	}
+	return violations
+}
+
+// VerifyLicenses returns an error listing the cdeps violating the
+// license policy of the project at path.
+func VerifyLicenses(gopath, path string, cdeps []*CanticleDependency) error {
+	policy, err := LoadLicensePolicy(path)
+	if err != nil {
+		return err
+	}
+	if violations := policy.Violations(gopath, cdeps); len(violations) > 0 {
+		return fmt.Errorf("cant use dependencies forbidden by license policy %s: %s", PolicyFile(path), strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+type Verify struct {
+	flags   *flag.FlagSet
+	Verbose bool
+
+	RequireAnnotations bool
+	Base               string
+}
+
+func NewVerify() *Verify {
+	f := flag.NewFlagSet("verify", flag.ExitOnError)
+	v := &Verify{flags: f}
+	f.BoolVar(&v.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&v.RequireAnnotations, "require-annotations", false, "Require every dependency changed since -base to be annotated")
+	f.StringVar(&v.Base, "base", "HEAD", "The git revision changes are annotated against")
+	return v
+}
+
+var verify = NewVerify()
+
+var VerifyCommand = &Command{
+	Name:             "verify",
+	UsageLine:        "verify [-v] [-require-annotations] [-base <rev>]",
+	ShortDescription: "Check the dependencies on disk against the Canticle file and policies.",
+	LongDescription: `The verify command checks the dependencies pinned in the Canticle file of the project in the current directory, as present in the GOPATH. It reports dependencies whose files do not match their saved hash, whose source the Canticle.policy forbids, and whose license the Licenses of the Canticle.policy forbid, e.g.:
+
+  {
+      "Licenses": {
+          "Allow": ["MIT", "BSD-3-Clause", "Apache-2.0"],
+          "Deny": ["GPL-3.0"],
+          "Unknown": "deny"
+      }
+  }
+
+Unknown may be allow, warn or deny. cant get also enforces the license policy after fetching. It exits with status 1 if problems are found.
+
+Specify -require-annotations to also report each dependency whose revision differs from the Canticle file committed at the git revision -base, HEAD by default, without an Annotation of its new revision, e.g. from cant save -reason, so every dependency bump carries a reviewable reason:
+  {
+      "Root": "github.com/foo/bar",
+      "Revision": "v1.2.0",
+      "Annotation": {
+          "Revision": "v1.2.0",
+          "Reason": "fix CVE-2016-1234",
+          "Ticket": "SEC-42"
+      }
+  }
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: verify.flags,
+	Cmd:   verify,
+}
+
+// Run the verify command.
+func (v *Verify) Run(ctx context.Context, args []string) {
+	if v.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gopath, err := EnvGoPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	problems, err := v.VerifyProject(ctx, gopath, wd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// VerifyProject returns the problems found with the pinned
+// dependencies of the project at path.
+func (v *Verify) VerifyProject(ctx context.Context, gopath, path string) ([]string, error) {
+	pkg, err := PackageName(gopath, path)
+	if err != nil {
+		return nil, err
+	}
+	cdeps, err := (&DepReader{Gopath: gopath}).CanticleDependencies(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("cant read Canticle file for %s %s", pkg, err.Error())
+	}
+	var problems []string
+	for _, cdep := range cdeps {
+		if err := VerifyTreeHash(ctx, gopath, cdep); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	sources, err := LoadSourcePolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, cdep := range sources.Violations(cdeps) {
+		problems = append(problems, fmt.Sprintf("%s source %s is forbidden by source policy", cdep.Root, FetchSource(cdep)))
+	}
+	licenses, err := LoadLicensePolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, licenses.Violations(gopath, cdeps)...)
+	if v.RequireAnnotations {
+		base, err := CommittedDependencies(path, v.Base)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, UnannotatedChanges(base, cdeps)...)
+	}
+	return problems, nil
+}
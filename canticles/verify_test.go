@@ -0,0 +1,89 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyProject(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"test.com/proj/main.go":    "package main\nfunc main() {}\n",
+		"dep.com/mit/LICENSE":      "Permission is hereby granted, free of charge",
+		"dep.com/gpl/COPYING":      "GNU GENERAL PUBLIC LICENSE Version 3",
+		"dep.com/isc/LICENSE":      "Permission to use, copy, modify, and/or distribute",
+		"dep.com/unknown/a.go":     "package unknown\n",
+		"evil.com/mirror/LICENSE":  "Permission is hereby granted, free of charge",
+		"dep.com/tampered/LICENSE": "Permission is hereby granted, free of charge",
+	}
+	for name, src := range files {
+		p := PackageSource(testHome, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proj := PackageSource(testHome, "test.com/proj")
+	cdeps := []*CanticleDependency{
+		{Root: "dep.com/gpl"},
+		{Root: "dep.com/isc"},
+		{Root: "dep.com/mit"},
+		{Root: "dep.com/tampered", TreeHash: TreeHashPrefix + "0"},
+		{Root: "dep.com/unknown"},
+		{Root: "evil.com/mirror"},
+	}
+	if err := NewSave().SaveDeps(proj, cdeps); err != nil {
+		t.Fatal(err)
+	}
+	policy := &SourcePolicy{
+		Allow: []string{"dep.com"},
+		Licenses: &LicensePolicy{
+			Allow:   []string{"MIT", "GPL-3.0"},
+			Deny:    []string{"GPL-3.0"},
+			Unknown: UnknownDeny,
+		},
+	}
+	if err := policy.Save(proj); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := NewVerify().VerifyProject(context.Background(), testHome, proj)
+	if err != nil {
+		t.Fatalf("Error verifying project: %s", err.Error())
+	}
+	hash, err := TreeHash(context.Background(), PackageSource(testHome, "dep.com/tampered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"cant verify dep.com/tampered at , its tree hash " + hash + " does not match the saved " + TreeHashPrefix + "0",
+		"evil.com/mirror source evil.com/mirror is forbidden by source policy",
+		"dep.com/gpl has forbidden license GPL-3.0",
+		"dep.com/isc has license ISC which is not allowed",
+		"dep.com/unknown has no detected license",
+	}
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("Expected problems:\n%v\ngot:\n%v", expected, problems)
+	}
+	if err := VerifyLicenses(testHome, proj, cdeps[2:3]); err != nil {
+		t.Errorf("Expected MIT dependency to be allowed got %s", err.Error())
+	}
+	policy.Licenses.Unknown = "deny!"
+	if err := policy.Save(proj); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadLicensePolicy(proj); err == nil || !strings.Contains(err.Error(), "deny!") {
+		t.Errorf("Expected invalid Unknown refused got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+type Warm struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	Limit   int
+}
+
+func NewWarm() *Warm {
+	f := flag.NewFlagSet("warm", flag.ExitOnError)
+	w := &Warm{flags: f}
+	f.BoolVar(&w.Verbose, "v", false, "Be verbose when getting stuff")
+	f.IntVar(&w.Limit, "limit", 10, "Limit the number of repos mirrored at once to limit")
+	return w
+}
+
+var warm = NewWarm()
+
+var WarmCommand = &Command{
+	Name:             "warm",
+	UsageLine:        "warm [-v] [-limit <n>] [file]",
+	ShortDescription: "Fill the cache with the dependencies of a Canticle file.",
+	LongDescription: `The warm command fills the cache, see cant clean, with the dependencies pinned in a Canticle file, the Canticle file of the current directory if no file is given, without fetching them into a GOPATH.
+
+How each dependency is resolved is cached, so later fetches need no discovery, and a bare mirror of each git repo containing its revision is kept. cant get and cant vendor clone git repos from their mirror when it contains the revision fetched, so run on CI agents later builds fetch nothing over the network.
+
+Dependencies with archive sources are not cached, and repos other than git are only resolved.
+
+Specify -limit to limit the number of repos mirrored at once.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: warm.flags,
+	Cmd:   warm,
+}
+
+// Run the warm command.
+func (w *Warm) Run(ctx context.Context, args []string) {
+	if w.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	file := DependencyFile(".")
+	if w.flags.NArg() > 0 {
+		file = w.flags.Arg(0)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("cant read dep file %s", err.Error())
+	}
+	var cdeps []*CanticleDependency
+	if err := json.Unmarshal(b, &cdeps); err != nil {
+		log.Fatalf("cant decode dep file %s %s", file, err.Error())
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Warm(ctx, wd, cdeps); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Warm resolves and mirrors cdeps in the DefaultCache using the
+// settings of the project at path.
+func (w *Warm) Warm(ctx context.Context, path string, cdeps []*CanticleDependency) error {
+	config, err := LoadProjectConfig(path)
+	if err != nil {
+		return err
+	}
+	secure := Secure.Merge(config)
+	fe, err := NewFetchEnv(TLS.Merge(config.TLS, path), secure)
+	if err != nil {
+		return err
+	}
+	defer fe.Close()
+	ctx = fe.Context(ctx)
+	policy, err := LoadSourcePolicy(path)
+	if err != nil {
+		return err
+	}
+	if err := policy.EnforcePolicy(path, cdeps, false); err != nil {
+		return err
+	}
+	cache, err := DefaultCache()
+	if err != nil {
+		return err
+	}
+	resolver := &CachedRepoResolver{
+		Resolver:    &CompositeRepoResolver{[]RepoResolver{&RemoteRepoResolver{}, &DefaultRepoResolver{}}},
+		Resolutions: LoadResolutionCache(cache.Entry("resolve.json")),
+	}
+	errs := WarmDependencies(ctx, cache, policy.Resolver(secure.Resolver(resolver), false), cdeps, w.Limit)
+	if err := resolver.Save(); err != nil {
+		LogWarnContext(ctx, "Error saving resolution cache: %s", err.Error())
+	}
+	EvictCache()
+	for _, err := range errs {
+		LogWarnContext(ctx, "%s", err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cant warm %d of %d dependencies", len(errs), len(cdeps))
+	}
+	return nil
+}
+
+// WarmDependencies resolves each of cdeps with resolver and mirrors
+// the git repos at their revision in cache, limit at a time. It
+// returns the errors encountered. No more are warmed once ctx is done.
+func WarmDependencies(ctx context.Context, cache *Cache, resolver RepoResolver, cdeps []*CanticleDependency, limit int) []error {
+	if limit <= 0 {
+		limit = len(cdeps)
+	}
+	var mu sync.Mutex
+	var errs []error
+	work := make(chan *CanticleDependency)
+	var wg sync.WaitGroup
+	for i := 0; i < limit && i < len(cdeps); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cdep := range work {
+				if err := warmDependency(ctx, cache, resolver, cdep); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for _, cdep := range cdeps {
+		select {
+		case work <- cdep:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func warmDependency(ctx context.Context, cache *Cache, resolver RepoResolver, cdep *CanticleDependency) error {
+	if IsArchiveSource(cdep.SourcePath) || IsRawSource(cdep.SourcePath) || cdep.Subpath != "" {
+		LogVerboseContext(ctx, "Not caching archive or monorepo %s", cdep.SourcePath)
+		return nil
+	}
+	LogInfoContext(ctx, "Warming %s", cdep.Root)
+	v, err := resolver.ResolveRepo(ctx, cdep.Root, cdep)
+	if err != nil {
+		return fmt.Errorf("cant resolve %s %s", cdep.Root, err.Error())
+	}
+	pv, ok := v.(*PackageVCS)
+	if mv, mirrored := v.(*MirrorVCS); mirrored {
+		pv, ok = mv.PackageVCS, true
+	}
+	if !ok || pv.Repo.VCS.Cmd != "git" {
+		LogVerboseContext(ctx, "Only resolving %s, it is not a git repo", cdep.Root)
+		return nil
+	}
+	release, err := cache.Use(mirrorName(pv.Repo.Root))
+	if err != nil {
+		return fmt.Errorf("cant warm %s %s", cdep.Root, err.Error())
+	}
+	defer release()
+	if err := MirrorRepo(ctx, MirrorDir(cache, pv.Repo.Root), pv.Repo.Repo, cdep.Revision); err != nil {
+		return fmt.Errorf("cant warm %s %s", cdep.Root, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// whyStart is walked from by WhyChains and ReachablePackages, it is no
+// valid import path.
+const whyStart = "(project)"
+
+// WhyChains returns every shortest chain of imports in deps from one
+// of the packages from to the package to, or to the packages under it
+// if it is a root. Chains to the packages under to reached in the
+// fewest imports are returned, sorted.
+func WhyChains(ctx context.Context, deps Dependencies, from []string, to string) ([][]string, error) {
+	// Walk from a start importing every package of from so all
+	// of them are depth one
+	reader := func(ctx context.Context, pkg string) ([]string, error) {
+		if pkg == whyStart {
+			return from, nil
+		}
+		if dep := deps[pkg]; dep != nil {
+			return dep.Imports.Array(), nil
+		}
+		return nil, nil
+	}
+	dw := NewDependencyWalker(reader, func(ctx context.Context, pkg string) error { return nil })
+	if err := dw.TraverseDependencies(ctx, whyStart); err != nil {
+		return nil, err
+	}
+	targets := NewStringSet()
+	targets.Add(to)
+	for _, importPath := range deps.PackagesUnder(to) {
+		targets.Add(importPath)
+	}
+	shortest := -1
+	var closest []string
+	for _, target := range targets.Array() {
+		depth, ok := dw.Depth(target)
+		switch {
+		case !ok:
+		case shortest < 0 || depth < shortest:
+			shortest = depth
+			closest = []string{target}
+		case depth == shortest:
+			closest = append(closest, target)
+		}
+	}
+	var chains [][]string
+	for _, target := range closest {
+		for _, chain := range dw.ShortestChains(target) {
+			chains = append(chains, chain[1:])
+		}
+	}
+	return chains, nil
+}
+
+// WhyResult is the answer of cant why for one import path.
+type WhyResult struct {
+	ImportPath string
+	// Chains are the shortest chains of imports from the project
+	// to ImportPath, none if it is not imported.
+	Chains [][]string
+	// ImportedAt are the file:line positions of the imports of
+	// each link of Chains, keyed by "importer -> imported", if
+	// asked for with -files.
+	ImportedAt map[string][]string `json:",omitempty"`
+}
+
+// chainPositions returns the file:line positions, from the ImportedAt
+// of deps, of the imports of each link of chains keyed by
+// "importer -> imported". Dirs are compared with symlinks evaluated,
+// so a linked gopath matches the positions read through its target.
+func chainPositions(gopath string, deps Dependencies, chains [][]string) map[string][]string {
+	positions := make(map[string][]string)
+	evaled := make(map[string]string)
+	eval := func(dir string) string {
+		if _, ok := evaled[dir]; !ok {
+			evaled[dir] = EvalPath(dir)
+		}
+		return evaled[dir]
+	}
+	for _, chain := range chains {
+		for i := 1; i < len(chain); i++ {
+			link := chain[i-1] + " -> " + chain[i]
+			dep := deps[chain[i]]
+			if _, ok := positions[link]; ok || dep == nil {
+				continue
+			}
+			dir := eval(PackageSource(gopath, chain[i-1]))
+			at := []string{}
+			for _, pos := range dep.ImportedAt.Array() {
+				file := pos
+				if j := strings.LastIndex(pos, ":"); j >= 0 {
+					file = pos[:j]
+				}
+				if eval(filepath.Dir(file)) == dir {
+					at = append(at, pos)
+				}
+			}
+			positions[link] = at
+		}
+	}
+	return positions
+}
+
+type Why struct {
+	flags   *flag.FlagSet
+	Verbose bool
+	JSON    bool
+	Files   bool
+}
+
+func NewWhy() *Why {
+	f := flag.NewFlagSet("why", flag.ExitOnError)
+	w := &Why{flags: f}
+	f.BoolVar(&w.Verbose, "v", false, "Be verbose when getting stuff")
+	f.BoolVar(&w.JSON, "json", false, "Print the chains as JSON")
+	f.BoolVar(&w.Files, "files", false, "Print the file and line of each import of the chains")
+	return w
+}
+
+var why = NewWhy()
+
+var WhyCommand = &Command{
+	Name:             "why",
+	UsageLine:        "why [-v] [-json] [-files] <importpath>...",
+	ShortDescription: "Print why the project imports a package.",
+	LongDescription: `The why command prints, for each import path given, every shortest chain of imports from the packages of the project in the current directory to the package, one "a -> b -> c" line per chain, similar to go mod why. If the import path is a root, such as a repo of the Canticle file, the chains to its closest packages are printed.
+
+Import paths the project does not import are reported as such.
+
+Specify -files to print, below each chain, the file:line positions of the import statements of each of its links.
+
+Specify -json to print the chains as JSON.
+
+Specify -v to print out a verbose set of operations instead of just errors.`,
+	Flags: why.flags,
+	Cmd:   why,
+}
+
+// Run the why command.
+func (w *Why) Run(ctx context.Context, args []string) {
+	if w.Verbose {
+		Verbose = true
+	}
+	defer func() { Verbose = false }()
+	importPaths := w.flags.Args()
+	if len(importPaths) == 0 {
+		log.Fatal("cant why, no import paths given")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	results, err := w.Why(ctx, wd, importPaths)
+	if err != nil {
+		log.Fatal(ErrorMessage(err))
+	}
+	if w.JSON {
+		b, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	for i, result := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# %s\n", result.ImportPath)
+		if len(result.Chains) == 0 {
+			fmt.Println("(not imported by the project)")
+		}
+		for _, chain := range result.Chains {
+			fmt.Println(strings.Join(chain, " -> "))
+			if result.ImportedAt == nil {
+				continue
+			}
+			for i := 1; i < len(chain); i++ {
+				link := chain[i-1] + " -> " + chain[i]
+				fmt.Printf("    %s: %s\n", link, strings.Join(result.ImportedAt[link], ", "))
+			}
+		}
+	}
+}
+
+// Why returns the shortest chains of imports from the packages of the
+// project at path to each of importPaths, with the positions of their
+// imports if Files is set.
+func (w *Why) Why(ctx context.Context, path string, importPaths []string) ([]*WhyResult, error) {
+	client, err := NewClient(Options{Positions: w.Files})
+	if err != nil {
+		return nil, err
+	}
+	project, err := PackageName(client.Gopath(), path)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := client.ReadDeps(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	from := deps.PackagesUnder(project)
+	var results []*WhyResult
+	for _, importPath := range importPaths {
+		chains, err := WhyChains(ctx, deps, from, importPath)
+		if err != nil {
+			return nil, err
+		}
+		result := &WhyResult{ImportPath: importPath, Chains: chains}
+		if w.Files {
+			result.ImportedAt = chainPositions(client.Gopath(), deps, chains)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,105 @@
+package canticles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWhyChains(t *testing.T) {
+	deps := NewDependencies()
+	for importPath, imports := range map[string][]string{
+		"example.com/p":       {"example.com/a", "example.com/b"},
+		"example.com/p/cmd/x": {"other.com/lib/sub"},
+		"example.com/a":       {"other.com/lib"},
+		"example.com/b":       {"other.com/lib"},
+		"other.com/lib":       {"other.com/lib/sub"},
+		"other.com/lib/sub":   {},
+	} {
+		dep := NewDependency(importPath)
+		for _, imp := range imports {
+			dep.Imports.Add(imp)
+		}
+		deps.AddDependency(dep)
+	}
+	from := []string{"example.com/p", "example.com/p/cmd/x"}
+
+	chains, err := WhyChains(context.Background(), deps, from, "other.com/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The closest package of the root is reached directly
+	expected := [][]string{{"example.com/p/cmd/x", "other.com/lib/sub"}}
+	if !reflect.DeepEqual(expected, chains) {
+		t.Errorf("Expected chains %v got %v", expected, chains)
+	}
+
+	// Every shortest chain is returned
+	chains, err = WhyChains(context.Background(), deps, []string{"example.com/p"}, "other.com/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = [][]string{
+		{"example.com/p", "example.com/a", "other.com/lib"},
+		{"example.com/p", "example.com/b", "other.com/lib"},
+	}
+	if !reflect.DeepEqual(expected, chains) {
+		t.Errorf("Expected chains %v got %v", expected, chains)
+	}
+
+	chains, err = WhyChains(context.Background(), deps, from, "unused.com/x")
+	if err != nil || len(chains) != 0 {
+		t.Errorf("Expected no chains got %v %v", chains, err)
+	}
+}
+
+func TestWhyFiles(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+	files := map[string]string{
+		"src/proj/main.go":   "package main\n\nimport _ \"dep.com/x\"\n\nfunc main() {}\n",
+		"src/dep.com/x/x.go": "package x\n\nimport _ \"dep.com/y\"\n",
+		"src/dep.com/y/y.go": "package y\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(testHome, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewClient(Options{Gopath: testHome, NoCache: true, Positions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, err := c.ReadDeps(context.Background(), filepath.Join(testHome, "src", "proj"))
+	if err != nil {
+		t.Fatalf("Error reading deps: %s", err.Error())
+	}
+	chains, err := WhyChains(context.Background(), deps, deps.PackagesUnder("proj"), "dep.com/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"proj -> dep.com/x":      {filepath.Join(testHome, "src", "proj", "main.go") + ":3"},
+		"dep.com/x -> dep.com/y": {filepath.Join(testHome, "src", "dep.com", "x", "x.go") + ":3"},
+	}
+	if positions := chainPositions(testHome, deps, chains); !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected import positions %v got %v", expected, positions)
+	}
+	linked := filepath.Join(testHome, "linked")
+	if err := os.Symlink(testHome, linked); err != nil {
+		t.Fatal(err)
+	}
+	if positions := chainPositions(linked, deps, chains); !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected import positions through a linked gopath %v got %v", expected, positions)
+	}
+}
@@ -0,0 +1,262 @@
+package canticles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WorkspaceFile returns the location of the workspace file in p.
+func WorkspaceFile(p string) string {
+	return filepath.Join(p, "Canticle.workspace")
+}
+
+// WorkspaceLockFile returns the location of the lock saved for the
+// workspace in p.
+func WorkspaceLockFile(p string) string {
+	return filepath.Join(p, "Canticle.lock")
+}
+
+// A Workspace is a set of projects, such as the services of one repo
+// or sibling repos, whose dependencies are saved together so every
+// project pins each dependency at the same revision.
+type Workspace struct {
+	// Dir is the directory of the workspace file.
+	Dir string `json:"-"`
+	// Projects are the directories of the projects, relative to
+	// Dir.
+	Projects []string
+}
+
+// LoadWorkspace reads the workspace file at file.
+func LoadWorkspace(file string) (*Workspace, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cant read workspace file %s", err.Error())
+	}
+	ws := &Workspace{}
+	if err := json.Unmarshal(b, ws); err != nil {
+		return nil, fmt.Errorf("cant decode workspace file %s %s", file, err.Error())
+	}
+	if len(ws.Projects) == 0 {
+		return nil, fmt.Errorf("cant use workspace file %s, it has no Projects", file)
+	}
+	if ws.Dir, err = filepath.Abs(filepath.Dir(file)); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// ProjectDirs returns the absolute directory of each project.
+func (ws *Workspace) ProjectDirs() []string {
+	dirs := make([]string, len(ws.Projects))
+	for i, p := range ws.Projects {
+		if filepath.IsAbs(p) {
+			dirs[i] = filepath.Clean(p)
+			continue
+		}
+		dirs[i] = filepath.Join(ws.Dir, filepath.FromSlash(p))
+	}
+	return dirs
+}
+
+// A WorkspaceConflict is a dependency the Canticle files of the
+// projects of a workspace pin at different revisions.
+type WorkspaceConflict struct {
+	Root string
+	// Revisions are the projects pinning each revision.
+	Revisions map[string][]string
+}
+
+// String lists the revisions and the projects pinning them.
+func (wc *WorkspaceConflict) String() string {
+	revs := make([]string, 0, len(wc.Revisions))
+	for rev := range wc.Revisions {
+		revs = append(revs, rev)
+	}
+	sort.Strings(revs)
+	pins := make([]string, len(revs))
+	for i, rev := range revs {
+		pins[i] = fmt.Sprintf("%s by %s", rev, strings.Join(wc.Revisions[rev], ", "))
+	}
+	return fmt.Sprintf("%s is pinned at %s", wc.Root, strings.Join(pins, " and "))
+}
+
+// Conflicts returns the dependencies the saved Canticle files of the
+// projects pin at different revisions, sorted by root.
+func (ws *Workspace) Conflicts() ([]*WorkspaceConflict, error) {
+	pinned := make(map[string]map[string][]string)
+	for i, dir := range ws.ProjectDirs() {
+		saved, err := SavedDependencies(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, cdep := range saved {
+			if cdep.Revision == "" {
+				continue
+			}
+			if pinned[cdep.Root] == nil {
+				pinned[cdep.Root] = make(map[string][]string)
+			}
+			pinned[cdep.Root][cdep.Revision] = append(pinned[cdep.Root][cdep.Revision], ws.Projects[i])
+		}
+	}
+	var conflicts []*WorkspaceConflict
+	for root, revs := range pinned {
+		if len(revs) > 1 {
+			conflicts = append(conflicts, &WorkspaceConflict{Root: root, Revisions: revs})
+		}
+	}
+	sort.Sort(conflictsByRoot(conflicts))
+	return conflicts, nil
+}
+
+type conflictsByRoot []*WorkspaceConflict
+
+func (c conflictsByRoot) Len() int           { return len(c) }
+func (c conflictsByRoot) Less(i, j int) bool { return c[i].Root < c[j].Root }
+func (c conflictsByRoot) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// MergeSources adds the sources of from to into, merging the
+// revisions, sources and deps of sources with the same root.
+func MergeSources(into, from *DependencySources) {
+	for _, source := range from.Sources {
+		var already *DependencySource
+		for _, s := range into.Sources {
+			if s.Root == source.Root {
+				already = s
+				break
+			}
+		}
+		if already == nil {
+			merged := NewDependencySource(source.Root)
+			merged.OnDiskRevision = source.OnDiskRevision
+			merged.OnDiskSource = source.OnDiskSource
+			merged.Err = source.Err
+			into.AddSource(merged)
+			already = merged
+		}
+		already.Revisions.Union(source.Revisions)
+		already.Sources.Union(source.Sources)
+		already.Deps.AddDependencies(source.Deps)
+		if already.Err == nil {
+			already.Err = source.Err
+		}
+	}
+}
+
+// SaveWorkspace saves the dependencies of every project of the
+// workspace at file. Their sources are resolved together into one
+// lock, saved in the workspace directory, and each project's Canticle
+// file is saved with the dependencies it uses at the locked
+// revisions. Dependencies the projects pin at different revisions are
+// reported before anything is read.
+func (s *Save) SaveWorkspace(ctx context.Context, gopath, file string) error {
+	if s.Ticket != "" && s.Reason == "" {
+		return fmt.Errorf("cant annotate with ticket %s without a -reason", s.Ticket)
+	}
+	for flag, set := range map[string]bool{"binaries": s.Binaries, "compact": s.Compact, "generate": s.Generate, "packages": s.Packages} {
+		if set {
+			return fmt.Errorf("cant save workspace %s with -%s", file, flag)
+		}
+	}
+	ws, err := LoadWorkspace(file)
+	if err != nil {
+		return err
+	}
+	conflicts, err := ws.Conflicts()
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		LogWarnContext(ctx, "%s", conflict.String())
+	}
+
+	dirs := ws.ProjectDirs()
+	merged := NewDependencySources(0)
+	projectRoots := make([]StringSet, len(dirs))
+	for i, dir := range dirs {
+		deps, err := s.ReadDeps(ctx, gopath, dir)
+		if err != nil {
+			return err
+		}
+		if violations := deps.InternalViolations(); len(violations) > 0 {
+			for _, v := range violations {
+				LogWarnContext(ctx, "%s", v.Error())
+			}
+			return fmt.Errorf("cant save %s, %d imports of internal packages are not allowed", dir, len(violations))
+		}
+		sources, err := s.GetSources(ctx, gopath, dir, deps)
+		if err != nil {
+			return err
+		}
+		projectRoots[i] = NewStringSet()
+		for _, source := range sources.Sources {
+			projectRoots[i].Add(source.Root)
+		}
+		MergeSources(merged, sources)
+	}
+	LogVerboseContext(ctx, "Discovered workspace sources:\n%+v", merged)
+	cantdeps, err := s.Resolver.ResolveConflicts(merged)
+	if err != nil {
+		return err
+	}
+	if s.Hash {
+		for _, cdep := range cantdeps {
+			if cdep.TreeHash, err = TreeHash(ctx, PackageSource(gopath, cdep.Root)); err != nil {
+				return fmt.Errorf("cant hash %s %s", cdep.Root, err.Error())
+			}
+		}
+	}
+
+	for i, dir := range dirs {
+		var project []*CanticleDependency
+		for _, cdep := range cantdeps {
+			if _, ok := projectRoots[i][cdep.Root]; ok {
+				c := *cdep
+				project = append(project, &c)
+			}
+		}
+		policy, err := LoadSourcePolicy(dir)
+		if err != nil {
+			return err
+		}
+		if err := policy.EnforcePolicy(dir, project, s.AllowViolations); err != nil {
+			return err
+		}
+		saved, err := SavedDependencies(dir)
+		if err != nil {
+			return err
+		}
+		Annotate(saved, project, s.Reason, s.Ticket)
+		for _, warning := range LookAlikeWarnings(saved, project) {
+			LogWarnContext(ctx, "%s, check it is the intended import path", warning)
+		}
+		if err := s.SaveDeps(dir, project); err != nil {
+			return err
+		}
+		if !s.DryRun {
+			Emit(ctx, SaveCompleted{Path: dir, Dependencies: project})
+		}
+	}
+	return s.SaveLock(ws.Dir, cantdeps)
+}
+
+// SaveLock saves the dependencies of every project of the workspace
+// in path to its lock file.
+func (s *Save) SaveLock(path string, deps []*CanticleDependency) error {
+	sort.Sort(CanticleDependencies(deps))
+	j, err := json.MarshalIndent(deps, "", "    ")
+	if err != nil {
+		return err
+	}
+	if s.DryRun {
+		fmt.Println(string(j))
+		return nil
+	}
+	return ioutil.WriteFile(WorkspaceLockFile(path), j, 0644)
+}
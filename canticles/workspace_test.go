@@ -0,0 +1,96 @@
+package canticles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorkspaceConflicts(t *testing.T) {
+	testHome, err := ioutil.TempDir("", "cant-test")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %s", err.Error())
+	}
+	defer os.RemoveAll(testHome)
+
+	projects := map[string][]*CanticleDependency{
+		"svc/a": {{Root: "dep.com/x", Revision: "1"}, {Root: "dep.com/y", Revision: "1"}},
+		"svc/b": {{Root: "dep.com/x", Revision: "2"}, {Root: "dep.com/y", Revision: "1"}},
+		"svc/c": {{Root: "dep.com/x", Revision: "1"}},
+	}
+	for project, cdeps := range projects {
+		dir := filepath.Join(testHome, project)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Error creating project: %s", err.Error())
+		}
+		b, _ := json.Marshal(cdeps)
+		if err := ioutil.WriteFile(DependencyFile(dir), b, 0644); err != nil {
+			t.Fatalf("Error writing Canticle file: %s", err.Error())
+		}
+	}
+	file := WorkspaceFile(testHome)
+	if err := ioutil.WriteFile(file, []byte(`{"Projects": ["svc/a", "svc/b", "svc/c"]}`), 0644); err != nil {
+		t.Fatalf("Error writing workspace file: %s", err.Error())
+	}
+
+	ws, err := LoadWorkspace(file)
+	if err != nil {
+		t.Fatalf("Error loading workspace: %s", err.Error())
+	}
+	if dirs := ws.ProjectDirs(); dirs[1] != filepath.Join(testHome, "svc/b") {
+		t.Errorf("Expected project dir %s got %s", filepath.Join(testHome, "svc/b"), dirs[1])
+	}
+	conflicts, err := ws.Conflicts()
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %s", err.Error())
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("Expected 1 conflict got %v", conflicts)
+	}
+	expected := "dep.com/x is pinned at 1 by svc/a, svc/c and 2 by svc/b"
+	if conflicts[0].String() != expected {
+		t.Errorf("Expected conflict %s got %s", expected, conflicts[0].String())
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("Error writing workspace file: %s", err.Error())
+	}
+	if _, err := LoadWorkspace(file); err == nil {
+		t.Errorf("Expected error loading workspace with no projects")
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	a := NewDependencySource("dep.com/x")
+	a.OnDiskRevision = "2"
+	a.Revisions.Add("1")
+	a.Deps.AddDeps("dep.com/x/pkg")
+	b := NewDependencySource("dep.com/x")
+	b.OnDiskRevision = "2"
+	b.Revisions.Add("2")
+	b.Deps.AddDeps("dep.com/x/other")
+	c := NewDependencySource("dep.com/y")
+
+	merged := NewDependencySources(0)
+	MergeSources(merged, &DependencySources{Sources: []*DependencySource{a}})
+	MergeSources(merged, &DependencySources{Sources: []*DependencySource{b, c}})
+	if len(merged.Sources) != 2 {
+		t.Fatalf("Expected 2 merged sources got %v", merged)
+	}
+	x := merged.Sources[0]
+	if revs := x.Revisions.Array(); !reflect.DeepEqual(revs, []string{"1", "2"}) {
+		t.Errorf("Expected revisions [1 2] got %v", revs)
+	}
+	if len(x.Deps) != 2 {
+		t.Errorf("Expected deps of both projects got %v", x.Deps)
+	}
+	if x.OnDiskRevision != "2" {
+		t.Errorf("Expected on disk revision 2 got %s", x.OnDiskRevision)
+	}
+	if a.Revisions.Size() != 1 {
+		t.Errorf("Expected merge to not modify its sources got %v", a.Revisions)
+	}
+}